@@ -0,0 +1,217 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/romana/rlog"
+	"k8s.io/api/core/v1"
+
+	"github.com/flant/antiopa/kube"
+	"github.com/flant/antiopa/task"
+)
+
+// Period of the addons health check
+var AddonsHealthCheckPeriod = 30 * time.Second
+
+// Queue is stuck if the same task is at its head for this time
+var AddonsHealthQueueStuckTimeout = 15 * time.Minute
+
+// AddonsHealthCondition is the composite "addons healthy" condition for paging alerts instead
+// of alerts per module. Addons are unhealthy if a critical module is not converged or is failed,
+// a hook fails repeatedly at the head of a queue and blocks it, or a queue is stuck.
+type AddonsHealthCondition struct {
+	Healthy bool `json:"healthy"`
+	// why addons are unhealthy, empty if healthy
+	Reasons []string `json:"reasons"`
+	// time of the last change of Healthy
+	LastTransitionTime time.Time `json:"lastTransitionTime"`
+}
+
+// AddonsHealthModule is a state of the enabled module for the check
+type AddonsHealthModule struct {
+	Name      string
+	Critical  bool
+	Converged bool
+}
+
+// addonsQueueHead is a task at the head of the queue and the time it is seen first
+type addonsQueueHead struct {
+	taskId string
+	since  time.Time
+}
+
+// AddonsHealthChecker keeps the last condition and heads of queues to detect stuck queues
+type AddonsHealthChecker struct {
+	m         sync.Mutex
+	condition *AddonsHealthCondition
+	heads     map[string]addonsQueueHead
+}
+
+func NewAddonsHealthChecker() *AddonsHealthChecker {
+	return &AddonsHealthChecker{
+		heads: make(map[string]addonsQueueHead),
+	}
+}
+
+// Condition returns the last condition, nil before the first check
+func (c *AddonsHealthChecker) Condition() *AddonsHealthCondition {
+	c.m.Lock()
+	defer c.m.Unlock()
+
+	if c.condition == nil {
+		return nil
+	}
+	res := *c.condition
+	return &res
+}
+
+// Check evaluates the condition. Changed is true if Healthy is changed since the previous check,
+// it is false for the first check.
+func (c *AddonsHealthChecker) Check(modules []AddonsHealthModule, health []ModuleHealthStatus, queues []DashboardQueueState, now time.Time) (condition AddonsHealthCondition, changed bool) {
+	c.m.Lock()
+	defer c.m.Unlock()
+
+	reasons := addonsModulesReasons(modules, health)
+	reasons = append(reasons, c.queuesReasons(queues, now)...)
+
+	condition = AddonsHealthCondition{Healthy: len(reasons) == 0, Reasons: reasons, LastTransitionTime: now}
+	if c.condition != nil {
+		changed = c.condition.Healthy != condition.Healthy
+		if !changed {
+			condition.LastTransitionTime = c.condition.LastTransitionTime
+		}
+	}
+	c.condition = &condition
+	return condition, changed
+}
+
+// addonsModulesReasons checks critical modules, all modules are critical if no module is marked
+func addonsModulesReasons(modules []AddonsHealthModule, health []ModuleHealthStatus) []string {
+	critical := make([]AddonsHealthModule, 0)
+	for _, module := range modules {
+		if module.Critical {
+			critical = append(critical, module)
+		}
+	}
+	if len(critical) == 0 {
+		critical = modules
+	}
+
+	healthByModule := make(map[string]ModuleHealthStatus)
+	for _, h := range health {
+		healthByModule[h.Module] = h
+	}
+
+	reasons := make([]string, 0)
+	for _, module := range critical {
+		if !module.Converged {
+			reasons = append(reasons, fmt.Sprintf("critical module '%s' is not converged", module.Name))
+			continue
+		}
+		if h, has := healthByModule[module.Name]; has && h.Condition == ModuleHealthFailed {
+			reasons = append(reasons, fmt.Sprintf("critical module '%s' is failed %d times in a row", module.Name, h.ConsecutiveFailures))
+		}
+	}
+	return reasons
+}
+
+// queuesReasons checks tasks at heads of queues, delays between retries are skipped
+func (c *AddonsHealthChecker) queuesReasons(queues []DashboardQueueState, now time.Time) []string {
+	reasons := make([]string, 0)
+	seen := make(map[string]bool)
+	for _, q := range queues {
+		if q.Queue == nil {
+			continue
+		}
+		head, _ := q.Queue.Find(func(t task.Task) bool {
+			return t.GetType() != task.Delay
+		})
+		if head == nil {
+			continue
+		}
+		seen[q.Name] = true
+
+		isHook := head.GetType() == task.ModuleHookRun || head.GetType() == task.GlobalHookRun
+		if isHook && head.GetFailureCount() >= ModuleFailedRuns {
+			reasons = append(reasons, fmt.Sprintf("hook '%s' is failed %d times at the head of queue '%s'", head.GetName(), head.GetFailureCount(), q.Name))
+		}
+
+		previous, hasPrevious := c.heads[q.Name]
+		if !hasPrevious || previous.taskId != head.GetId() {
+			c.heads[q.Name] = addonsQueueHead{taskId: head.GetId(), since: now}
+			continue
+		}
+		if stuckFor := now.Sub(previous.since); stuckFor >= AddonsHealthQueueStuckTimeout {
+			reasons = append(reasons, fmt.Sprintf("queue '%s' is stuck: %s '%s' is at the head for %s", q.Name, head.GetType(), head.GetName(), stuckFor.Truncate(time.Second)))
+		}
+	}
+	for name := range c.heads {
+		if !seen[name] {
+			delete(c.heads, name)
+		}
+	}
+	sort.Strings(reasons)
+	return reasons
+}
+
+// CheckNow checks the current state of antiopa, sends the metric and creates an event
+// for antiopa Pod when the condition is changed
+func (c *AddonsHealthChecker) CheckNow() {
+	if ModuleManager == nil {
+		return
+	}
+
+	modules := make([]AddonsHealthModule, 0)
+	for _, moduleName := range ModuleManager.GetModuleNamesInOrder() {
+		module, err := ModuleManager.GetModule(moduleName)
+		if err != nil {
+			continue
+		}
+		modules = append(modules, AddonsHealthModule{Name: moduleName, Critical: module.IsCritical(), Converged: module.IsConverged()})
+	}
+	var health []ModuleHealthStatus
+	if ModulesHealth != nil {
+		health = ModulesHealth.Dump()
+	}
+	queues := []DashboardQueueState{{Name: MainQueueName, Queue: TasksQueue}}
+	for _, name := range NamedQueues.Names() {
+		queues = append(queues, DashboardQueueState{Name: name, Queue: NamedQueues.Get(name)})
+	}
+
+	condition, changed := c.Check(modules, health, queues, time.Now())
+
+	healthy := 0.0
+	if condition.Healthy {
+		healthy = 1.0
+	}
+	MetricsStorage.SendGaugeMetric("antiopa_addons_healthy", healthy, map[string]string{})
+
+	if !changed {
+		return
+	}
+	pod := v1.ObjectReference{Kind: "Pod", APIVersion: "v1", Namespace: kube.KubernetesAntiopaNamespace, Name: Hostname}
+	var err error
+	if condition.Healthy {
+		rlog.Infof("MAIN addons are healthy")
+		err = kube.CreateNormalEvent(pod, "AddonsHealthy", "addons are healthy")
+	} else {
+		message := strings.Join(condition.Reasons, "; ")
+		rlog.Warnf("MAIN addons are unhealthy: %s", message)
+		err = kube.CreateWarningEvent(pod, "AddonsUnhealthy", message)
+	}
+	if err != nil {
+		rlog.Errorf("MAIN %s", err)
+	}
+}
+
+// Run periodically checks addons health
+func (c *AddonsHealthChecker) Run() {
+	ticker := time.NewTicker(AddonsHealthCheckPeriod)
+	for range ticker.C {
+		c.CheckNow()
+	}
+}
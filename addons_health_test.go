@@ -0,0 +1,67 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/flant/antiopa/task"
+)
+
+func TestAddonsHealthChecker(t *testing.T) {
+	c := NewAddonsHealthChecker()
+	now := time.Now()
+
+	modules := []AddonsHealthModule{
+		{Name: "ingress", Critical: true, Converged: true},
+		{Name: "dns", Critical: true, Converged: true},
+		{Name: "dashboard", Converged: false},
+	}
+	queue := task.NewTasksQueue()
+	queues := []DashboardQueueState{{Name: MainQueueName, Queue: queue}}
+
+	// not converged module is not critical
+	condition, changed := c.Check(modules, nil, queues, now)
+	assert.True(t, condition.Healthy)
+	assert.False(t, changed)
+	assert.Equal(t, now, condition.LastTransitionTime)
+
+	health := []ModuleHealthStatus{{Module: "dns", Condition: ModuleHealthFailed, ConsecutiveFailures: 3}}
+	hook := task.NewTask(task.ModuleHookRun, "ingress/hooks/certs")
+	for i := 0; i < ModuleFailedRuns; i++ {
+		hook.IncrementFailureCount()
+	}
+	queue.Add(task.NewTaskDelay(time.Second))
+	queue.Add(hook)
+
+	condition, changed = c.Check(modules, health, queues, now.Add(time.Minute))
+	assert.False(t, condition.Healthy)
+	assert.True(t, changed)
+	assert.Equal(t, now.Add(time.Minute), condition.LastTransitionTime)
+	assert.Equal(t, []string{
+		"critical module 'dns' is failed 3 times in a row",
+		"hook 'ingress/hooks/certs' is failed 3 times at the head of queue 'main'",
+	}, condition.Reasons)
+
+	// the same hook is at the head of the queue until the stuck timeout
+	condition, changed = c.Check(modules, nil, queues, now.Add(time.Minute+AddonsHealthQueueStuckTimeout))
+	assert.False(t, changed)
+	assert.Equal(t, now.Add(time.Minute), condition.LastTransitionTime)
+	if assert.Len(t, condition.Reasons, 2) {
+		assert.Contains(t, condition.Reasons[1], "queue 'main' is stuck")
+	}
+
+	// all modules are critical if no module is marked
+	modules = []AddonsHealthModule{{Name: "dashboard", Converged: false}}
+	condition, changed = c.Check(modules, nil, nil, now.Add(2*AddonsHealthQueueStuckTimeout))
+	assert.False(t, changed)
+	assert.Equal(t, []string{"critical module 'dashboard' is not converged"}, condition.Reasons)
+
+	modules[0].Converged = true
+	condition, changed = c.Check(modules, nil, nil, now.Add(3*AddonsHealthQueueStuckTimeout))
+	assert.True(t, condition.Healthy)
+	assert.True(t, changed)
+	assert.Empty(t, condition.Reasons)
+	assert.Equal(t, condition, *c.Condition())
+}
@@ -0,0 +1,228 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/romana/rlog"
+	"k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"github.com/flant/antiopa/helm"
+	"github.com/flant/antiopa/kube"
+	"github.com/flant/antiopa/module_manager"
+)
+
+// ConfigMap with NOTES.txt of modules releases: endpoints, credentials hints and next steps for operators
+const AddonsReportConfigMapName = "antiopa-addons-report"
+
+// ModuleReleaseNotes is a rendered NOTES.txt of the module release and module notes
+// rendered by antiopa with live values (see module_manager.ModuleNotesScript)
+type ModuleReleaseNotes struct {
+	Module      string    `json:"module"`
+	Notes       string    `json:"notes"`
+	ModuleNotes string    `json:"moduleNotes,omitempty"`
+	UpdatedAt   time.Time `json:"updatedAt"`
+}
+
+func (n ModuleReleaseNotes) empty() bool {
+	return n.Notes == "" && n.ModuleNotes == ""
+}
+
+// AddonsReport aggregates release notes and module notes of modules. The report is published
+// into ConfigMap when the main queue is empty and notes are changed.
+type AddonsReport struct {
+	m       sync.Mutex
+	notes   map[string]ModuleReleaseNotes
+	changed bool
+
+	publish func(data map[string]string) error
+}
+
+func NewAddonsReport() *AddonsReport {
+	return &AddonsReport{
+		notes:   make(map[string]ModuleReleaseNotes),
+		publish: publishAddonsReportConfigMap,
+	}
+}
+
+// Has returns true if notes of the module are known
+func (r *AddonsReport) Has(moduleName string) bool {
+	r.m.Lock()
+	defer r.m.Unlock()
+	_, has := r.notes[moduleName]
+	return has
+}
+
+// Set saves release notes of the module, modules without notes are not shown in the report
+func (r *AddonsReport) Set(moduleName string, notes string) {
+	r.m.Lock()
+	defer r.m.Unlock()
+
+	current, has := r.notes[moduleName]
+	if has && current.Notes == notes {
+		return
+	}
+	current.Module = moduleName
+	current.Notes = notes
+	current.UpdatedAt = time.Now()
+	r.notes[moduleName] = current
+	r.changed = true
+}
+
+// SetModuleNotes saves notes rendered by the module notes script or template
+func (r *AddonsReport) SetModuleNotes(moduleName string, notes string) {
+	r.m.Lock()
+	defer r.m.Unlock()
+
+	current := r.notes[moduleName]
+	if current.ModuleNotes == notes {
+		return
+	}
+	current.Module = moduleName
+	current.ModuleNotes = notes
+	current.UpdatedAt = time.Now()
+	r.notes[moduleName] = current
+	r.changed = true
+}
+
+// Get returns notes of the module
+func (r *AddonsReport) Get(moduleName string) (ModuleReleaseNotes, bool) {
+	r.m.Lock()
+	defer r.m.Unlock()
+	notes, has := r.notes[moduleName]
+	return notes, has && !notes.empty()
+}
+
+// Delete removes notes of the deleted module
+func (r *AddonsReport) Delete(moduleName string) {
+	r.m.Lock()
+	defer r.m.Unlock()
+
+	if _, has := r.notes[moduleName]; has {
+		delete(r.notes, moduleName)
+		r.changed = true
+	}
+}
+
+// Dump returns non empty notes sorted by module name
+func (r *AddonsReport) Dump() []ModuleReleaseNotes {
+	r.m.Lock()
+	defer r.m.Unlock()
+	return r.dump()
+}
+
+func (r *AddonsReport) dump() []ModuleReleaseNotes {
+	res := make([]ModuleReleaseNotes, 0, len(r.notes))
+	for _, notes := range r.notes {
+		if !notes.empty() {
+			res = append(res, notes)
+		}
+	}
+	sort.Slice(res, func(i, j int) bool {
+		return res[i].Module < res[j].Module
+	})
+	return res
+}
+
+// Markdown returns the report with a section for every module
+func (r *AddonsReport) Markdown() string {
+	return addonsReportMarkdown(r.Dump())
+}
+
+func addonsReportMarkdown(notes []ModuleReleaseNotes) string {
+	buf := &bytes.Buffer{}
+	buf.WriteString("# Cluster addons report\n")
+	if len(notes) == 0 {
+		buf.WriteString("\nModules have no release notes.\n")
+	}
+	for _, moduleNotes := range notes {
+		fmt.Fprintf(buf, "\n## %s\n\n", moduleNotes.Module)
+		sections := make([]string, 0, 2)
+		for _, section := range []string{moduleNotes.Notes, moduleNotes.ModuleNotes} {
+			if section = strings.TrimSpace(section); section != "" {
+				sections = append(sections, section)
+			}
+		}
+		buf.WriteString(strings.Join(sections, "\n\n"))
+		buf.WriteString("\n")
+	}
+	return buf.String()
+}
+
+// PublishIfChanged writes the report into ConfigMap if notes are changed since last publish
+func (r *AddonsReport) PublishIfChanged() error {
+	r.m.Lock()
+	defer r.m.Unlock()
+
+	if !r.changed {
+		return nil
+	}
+
+	notes := r.dump()
+	notesJson, err := json.Marshal(notes)
+	if err != nil {
+		return err
+	}
+
+	err = r.publish(map[string]string{
+		"report.md":   addonsReportMarkdown(notes),
+		"report.json": string(notesJson),
+	})
+	if err != nil {
+		return err
+	}
+
+	r.changed = false
+	return nil
+}
+
+// UpdateModuleNotes saves notes from helm upgrade result. Notes of the unchanged
+// release are requested from tiller once, e.g. after antiopa restart.
+// Module notes are rendered after every run because values could be changed.
+// Previous notes are kept if notes cannot be rendered.
+func (r *AddonsReport) UpdateModuleNotes(module *module_manager.Module, releaseUpgrade *helm.ReleaseUpgradeResult) {
+	if releaseUpgrade != nil {
+		r.Set(module.Name, releaseUpgrade.Notes)
+	} else if !r.Has(module.Name) {
+		notes, err := module.ReleaseNotes()
+		if err != nil {
+			rlog.Errorf("ADDONS_REPORT module '%s': cannot get release notes: %s", module.Name, err)
+		} else {
+			r.Set(module.Name, notes)
+		}
+	}
+
+	moduleNotes, err := module.RenderNotes()
+	if err != nil {
+		rlog.Errorf("ADDONS_REPORT module '%s': cannot render module notes: %s", module.Name, err)
+		return
+	}
+	r.SetModuleNotes(module.Name, moduleNotes)
+}
+
+func publishAddonsReportConfigMap(data map[string]string) error {
+	configMaps := kube.KubernetesClient.CoreV1().ConfigMaps(kube.KubernetesAntiopaNamespace)
+
+	obj, err := configMaps.Get(AddonsReportConfigMapName, metav1.GetOptions{})
+	if errors.IsNotFound(err) {
+		obj = &v1.ConfigMap{}
+		obj.Name = AddonsReportConfigMapName
+		obj.Data = data
+		_, err = configMaps.Create(obj)
+		return err
+	}
+	if err != nil {
+		return err
+	}
+
+	obj.Data = data
+	_, err = configMaps.Update(obj)
+	return err
+}
@@ -0,0 +1,67 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestAddonsReport(t *testing.T) {
+	r := NewAddonsReport()
+	published := make([]map[string]string, 0)
+	r.publish = func(data map[string]string) error {
+		published = append(published, data)
+		return nil
+	}
+
+	r.Set("prometheus", "Grafana: https://grafana.example.com\n")
+	r.Set("cert-manager", "")
+	r.Set("dashboard", "Token: kubectl -n kube-system get secret dashboard-token")
+
+	assert.True(t, r.Has("cert-manager"))
+	notes := r.Dump()
+	if !assert.Len(t, notes, 2) {
+		return
+	}
+	assert.Equal(t, "dashboard", notes[0].Module)
+	assert.Equal(t, "prometheus", notes[1].Module)
+
+	assert.NoError(t, r.PublishIfChanged())
+	if !assert.Len(t, published, 1) {
+		return
+	}
+	assert.Equal(t, "# Cluster addons report\n\n## dashboard\n\nToken: kubectl -n kube-system get secret dashboard-token\n\n## prometheus\n\nGrafana: https://grafana.example.com\n", published[0]["report.md"])
+	assert.Contains(t, published[0]["report.json"], `"module":"dashboard"`)
+
+	// same notes do not change the report
+	r.Set("prometheus", "Grafana: https://grafana.example.com\n")
+	assert.NoError(t, r.PublishIfChanged())
+	assert.Len(t, published, 1)
+
+	r.Delete("prometheus")
+	assert.NoError(t, r.PublishIfChanged())
+	assert.Len(t, published, 2)
+	assert.NotContains(t, published[1]["report.md"], "prometheus")
+}
+
+func TestAddonsReport_ModuleNotes(t *testing.T) {
+	r := NewAddonsReport()
+	r.publish = func(data map[string]string) error { return nil }
+
+	r.SetModuleNotes("dex", "Login: https://dex.example.com")
+	r.Set("dex", "Dex is installed.\n")
+
+	notes, has := r.Get("dex")
+	assert.True(t, has)
+	assert.Equal(t, "Dex is installed.\n", notes.Notes)
+	assert.Equal(t, "Login: https://dex.example.com", notes.ModuleNotes)
+	assert.Equal(t, "# Cluster addons report\n\n## dex\n\nDex is installed.\n\nLogin: https://dex.example.com\n", r.Markdown())
+
+	assert.NoError(t, r.PublishIfChanged())
+	r.SetModuleNotes("dex", "Login: https://dex.example.com")
+	assert.False(t, r.changed)
+
+	r.SetModuleNotes("prometheus", "")
+	_, has = r.Get("prometheus")
+	assert.False(t, has)
+}
@@ -0,0 +1,52 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"time"
+
+	"github.com/flant/antiopa/helm"
+)
+
+// RunAdoptReleaseCommand handles `antiopa adopt-release [-dry-run] <module>`: objects of a manually
+// installed component that exist in the cluster are added into the release of the module, so module
+// is installed over them without deletion. Command uses the API of running antiopa.
+func RunAdoptReleaseCommand(args []string) error {
+	flags := flag.NewFlagSet("adopt-release", flag.ContinueOnError)
+	dryRun := flags.Bool("dry-run", false, "only print objects that would be adopted")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if flags.NArg() != 1 {
+		return fmt.Errorf("usage: antiopa adopt-release [-dry-run] <module>")
+	}
+
+	client := &http.Client{Timeout: 5 * time.Minute}
+	query := url.Values{"name": {flags.Arg(0)}}
+	if *dryRun {
+		query.Set("dryRun", "true")
+	}
+
+	resp, err := client.Post(ApiAddress+"/module/adopt?"+query.Encode(), "text/plain", nil)
+	if err != nil {
+		return fmt.Errorf("cannot adopt release objects: %s", err)
+	}
+	defer resp.Body.Close()
+	body, _ := ioutil.ReadAll(resp.Body)
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("cannot adopt release objects: %s: %s", resp.Status, string(body))
+	}
+
+	results := make([]helm.AdoptedResource, 0)
+	if err := json.Unmarshal(body, &results); err != nil {
+		return fmt.Errorf("bad response: %s", err)
+	}
+	for _, res := range results {
+		fmt.Printf("%-9s %s\n", res.Result, res.Resource)
+	}
+	return nil
+}
@@ -0,0 +1,239 @@
+package main
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/romana/rlog"
+
+	"github.com/flant/antiopa/kube"
+)
+
+// ApiRole is a level of access to the HTTP API
+type ApiRole int
+
+const (
+	// endpoints for prometheus and probes
+	ApiRolePublic ApiRole = iota
+	// dumps of queue, hooks, history and profiling
+	ApiRoleRead
+	// endpoints that change state (manual runs, values import) or show values
+	ApiRoleTrigger
+)
+
+func (r ApiRole) String() string {
+	switch r {
+	case ApiRolePublic:
+		return "public"
+	case ApiRoleRead:
+		return "read"
+	case ApiRoleTrigger:
+		return "trigger"
+	}
+	return "unknown"
+}
+
+// Roles of API endpoints by path, ApiRoleRead is used for unknown paths
+var ApiEndpointsRoles = map[string]ApiRole{
+	"/metrics":                    ApiRolePublic,
+	"/version":                    ApiRolePublic,
+	"/values/export":              ApiRoleTrigger,
+	"/values/import":              ApiRoleTrigger,
+	"/values/current":             ApiRoleTrigger,
+	"/modules/enabled-simulation": ApiRoleTrigger,
+	"/module/release-values":      ApiRoleTrigger,
+	"/module/failure-artifacts":   ApiRoleTrigger,
+	"/module/logs":                ApiRoleTrigger,
+	"/module/run":                 ApiRoleTrigger,
+	"/module/adopt":               ApiRoleTrigger,
+	"/module/migrate-namespace":   ApiRoleTrigger,
+	"/modules/diff":               ApiRoleTrigger,
+	"/modules/run":                ApiRoleTrigger,
+	"/global-hook/run":            ApiRoleTrigger,
+	"/task/cancel":                ApiRoleTrigger,
+	"/converge-plan/approve":      ApiRoleTrigger,
+	"/approvals/approve":          ApiRoleTrigger,
+	"/debug/failures/inject":      ApiRoleTrigger,
+	"/debug/failures/clear":       ApiRoleTrigger,
+}
+
+// apiEndpointRole returns the role of the endpoint, ApiRoleRead for unknown paths
+func apiEndpointRole(path string) ApiRole {
+	if role, ok := ApiEndpointsRoles[path]; ok {
+		return role
+	}
+	return ApiRoleRead
+}
+
+// How long results of TokenReview are cached
+const ApiTokenReviewCacheTTL = time.Minute
+
+// ApiAuth settings from flags
+var (
+	ApiAuthEnabled bool
+	// comma separated users and groups with read access, empty means any authenticated subject
+	ApiReadSubjects string
+	// comma separated users and groups with trigger access
+	ApiTriggerSubjects string
+)
+
+// ApiSubject is an owner of the bearer token
+type ApiSubject struct {
+	User   string
+	Groups []string
+}
+
+func (s *ApiSubject) matches(subjects []string) bool {
+	for _, subject := range subjects {
+		if subject == s.User {
+			return true
+		}
+		for _, group := range s.Groups {
+			if subject == group {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+type apiTokenReviewResult struct {
+	subject   *ApiSubject
+	err       error
+	expiresAt time.Time
+}
+
+// ApiAuthenticator checks bearer tokens of requests from non-loopback addresses with
+// TokenReview and allows endpoints by roles. Requests from localhost (CLI commands
+// run with kubectl exec) are trusted.
+type ApiAuthenticator struct {
+	readSubjects    []string
+	triggerSubjects []string
+
+	review func(token string) (*ApiSubject, error)
+
+	m     sync.Mutex
+	cache map[string]apiTokenReviewResult
+}
+
+func NewApiAuthenticator(readSubjects []string, triggerSubjects []string) *ApiAuthenticator {
+	return &ApiAuthenticator{
+		readSubjects:    readSubjects,
+		triggerSubjects: triggerSubjects,
+		review: func(token string) (*ApiSubject, error) {
+			user, groups, err := kube.ReviewToken(token)
+			if err != nil {
+				return nil, err
+			}
+			return &ApiSubject{User: user, Groups: groups}, nil
+		},
+		cache: make(map[string]apiTokenReviewResult),
+	}
+}
+
+// Handler checks access to the endpoint before calling next handler
+func (a *ApiAuthenticator) Handler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		role := apiEndpointRole(request.URL.Path)
+
+		if role == ApiRolePublic || isLoopbackRequest(request) {
+			next.ServeHTTP(writer, request)
+			return
+		}
+
+		token := strings.TrimPrefix(request.Header.Get("Authorization"), "Bearer ")
+		if token == "" || token == request.Header.Get("Authorization") {
+			http.Error(writer, "bearer token is required", http.StatusUnauthorized)
+			return
+		}
+
+		subject, err := a.authenticate(token)
+		if err != nil {
+			rlog.Debugf("API auth: %s %s: %s", request.Method, request.URL.Path, err)
+			http.Error(writer, "token is not authenticated", http.StatusUnauthorized)
+			return
+		}
+
+		if !a.allowed(subject, role) {
+			rlog.Infof("API auth: '%s' is not allowed to %s %s: %s role is required", subject.User, request.Method, request.URL.Path, role)
+			http.Error(writer, fmt.Sprintf("'%s' has no %s access", subject.User, role), http.StatusForbidden)
+			return
+		}
+
+		next.ServeHTTP(writer, request)
+	})
+}
+
+func (a *ApiAuthenticator) allowed(subject *ApiSubject, role ApiRole) bool {
+	if subject.matches(a.triggerSubjects) {
+		return true
+	}
+	if role == ApiRoleRead {
+		return len(a.readSubjects) == 0 || subject.matches(a.readSubjects)
+	}
+	return false
+}
+
+// authenticate returns the owner of the token, results are cached for ApiTokenReviewCacheTTL
+func (a *ApiAuthenticator) authenticate(token string) (*ApiSubject, error) {
+	key := fmt.Sprintf("%x", sha256.Sum256([]byte(token)))
+	now := time.Now()
+
+	a.m.Lock()
+	cached, hasCached := a.cache[key]
+	a.m.Unlock()
+	if hasCached && now.Before(cached.expiresAt) {
+		return cached.subject, cached.err
+	}
+
+	subject, err := a.review(token)
+
+	a.m.Lock()
+	defer a.m.Unlock()
+	// remove expired tokens
+	for cachedKey, res := range a.cache {
+		if !now.Before(res.expiresAt) {
+			delete(a.cache, cachedKey)
+		}
+	}
+	a.cache[key] = apiTokenReviewResult{subject: subject, err: err, expiresAt: now.Add(ApiTokenReviewCacheTTL)}
+
+	return subject, err
+}
+
+// LoopbackTriggerHandler is used if API auth is disabled: endpoints with ApiRoleTrigger
+// are served only for requests from localhost, e.g. CLI commands run with kubectl exec.
+func LoopbackTriggerHandler(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		if apiEndpointRole(request.URL.Path) == ApiRoleTrigger && !isLoopbackRequest(request) {
+			http.Error(writer, "endpoint is available only from localhost, start antiopa with -api-auth to allow requests with bearer token", http.StatusForbidden)
+			return
+		}
+		next.ServeHTTP(writer, request)
+	})
+}
+
+func isLoopbackRequest(request *http.Request) bool {
+	host, _, err := net.SplitHostPort(request.RemoteAddr)
+	if err != nil {
+		return false
+	}
+	ip := net.ParseIP(host)
+	return ip != nil && ip.IsLoopback()
+}
+
+func splitSubjects(subjects string) []string {
+	res := make([]string, 0)
+	for _, subject := range strings.Split(subjects, ",") {
+		subject = strings.TrimSpace(subject)
+		if subject != "" {
+			res = append(res, subject)
+		}
+	}
+	return res
+}
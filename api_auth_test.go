@@ -0,0 +1,85 @@
+package main
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestApiAuthenticator_Handler(t *testing.T) {
+	auth := NewApiAuthenticator([]string{"system:serviceaccounts:monitoring"}, []string{"admin"})
+	reviews := 0
+	auth.review = func(token string) (*ApiSubject, error) {
+		reviews++
+		switch token {
+		case "admin-token":
+			return &ApiSubject{User: "admin"}, nil
+		case "monitoring-token":
+			return &ApiSubject{User: "system:serviceaccount:monitoring:prometheus", Groups: []string{"system:serviceaccounts:monitoring"}}, nil
+		case "other-token":
+			return &ApiSubject{User: "system:serviceaccount:default:default"}, nil
+		}
+		return nil, fmt.Errorf("token is not authenticated")
+	}
+
+	handler := auth.Handler(http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		writer.WriteHeader(http.StatusOK)
+	}))
+
+	do := func(remoteAddr string, path string, token string) int {
+		request := httptest.NewRequest(http.MethodPost, path, nil)
+		request.RemoteAddr = remoteAddr
+		if token != "" {
+			request.Header.Set("Authorization", "Bearer "+token)
+		}
+		recorder := httptest.NewRecorder()
+		handler.ServeHTTP(recorder, request)
+		return recorder.Code
+	}
+
+	// loopback and public endpoints
+	assert.Equal(t, http.StatusOK, do("127.0.0.1:40000", "/module/run", ""))
+	assert.Equal(t, http.StatusOK, do("10.0.0.1:40000", "/metrics", ""))
+
+	assert.Equal(t, http.StatusUnauthorized, do("10.0.0.1:40000", "/queue", ""))
+	assert.Equal(t, http.StatusUnauthorized, do("10.0.0.1:40000", "/queue", "bad-token"))
+
+	assert.Equal(t, http.StatusOK, do("10.0.0.1:40000", "/queue", "monitoring-token"))
+	assert.Equal(t, http.StatusForbidden, do("10.0.0.1:40000", "/module/run", "monitoring-token"))
+	assert.Equal(t, http.StatusForbidden, do("10.0.0.1:40000", "/modules/run", "monitoring-token"))
+	assert.Equal(t, http.StatusForbidden, do("10.0.0.1:40000", "/queue", "other-token"))
+
+	assert.Equal(t, http.StatusOK, do("10.0.0.1:40000", "/queue", "admin-token"))
+	assert.Equal(t, http.StatusOK, do("10.0.0.1:40000", "/module/run", "admin-token"))
+
+	// tokens are reviewed once
+	assert.Equal(t, 4, reviews)
+}
+
+func TestLoopbackTriggerHandler(t *testing.T) {
+	handler := LoopbackTriggerHandler(http.HandlerFunc(func(writer http.ResponseWriter, request *http.Request) {
+		writer.WriteHeader(http.StatusOK)
+	}))
+
+	do := func(remoteAddr string, path string) int {
+		request := httptest.NewRequest(http.MethodPost, path, nil)
+		request.RemoteAddr = remoteAddr
+		recorder := httptest.NewRecorder()
+		handler.ServeHTTP(recorder, request)
+		return recorder.Code
+	}
+
+	assert.Equal(t, http.StatusOK, do("127.0.0.1:40000", "/module/run"))
+	assert.Equal(t, http.StatusOK, do("[::1]:40000", "/values/import"))
+
+	// endpoints that change state are not open without auth
+	for _, path := range []string{"/values/import", "/module/run", "/modules/run", "/global-hook/run", "/module/adopt", "/module/migrate-namespace", "/task/cancel", "/approvals/approve", "/converge-plan/approve"} {
+		assert.Equal(t, http.StatusForbidden, do("10.0.0.1:40000", path), path)
+	}
+
+	assert.Equal(t, http.StatusOK, do("10.0.0.1:40000", "/metrics"))
+	assert.Equal(t, http.StatusOK, do("10.0.0.1:40000", "/queue"))
+}
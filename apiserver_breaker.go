@@ -0,0 +1,122 @@
+package main
+
+import (
+	"sync"
+	"time"
+
+	"github.com/romana/rlog"
+
+	"github.com/flant/antiopa/kube"
+)
+
+// ApiserverBreakerEnabled enables pausing of tasks queues on apiserver outage
+var ApiserverBreakerEnabled = true
+
+// ApiserverBreaker is a circuit breaker for all tasks queues
+var ApiserverBreaker *ApiserverCircuitBreaker
+
+// Period of apiserver checks while the breaker is open, it is doubled up to ApiserverBreakerMaxInterval
+var (
+	ApiserverBreakerInterval    = 5 * time.Second
+	ApiserverBreakerMaxInterval = time.Minute
+)
+
+// ApiserverCircuitBreaker pauses tasks queues when apiserver is not available. Task errors that
+// look like outage (connection refused, timeouts, 5xx) are confirmed with an apiserver check,
+// failed task stays at the head of the queue without incrementing its failure count. Queues are
+// resumed when the check is successful.
+type ApiserverCircuitBreaker struct {
+	m      sync.Mutex
+	open   bool
+	probe  func() error
+	openAt time.Time
+	// task errors while the breaker is open, they are logged once on close
+	suppressedErrors int
+	interval         time.Duration
+	probing          bool
+}
+
+func NewApiserverCircuitBreaker(probe func() error) *ApiserverCircuitBreaker {
+	return &ApiserverCircuitBreaker{probe: probe}
+}
+
+// IsOpen returns true if tasks should not be run
+func (b *ApiserverCircuitBreaker) IsOpen() bool {
+	b.m.Lock()
+	defer b.m.Unlock()
+	return b.open
+}
+
+// Trip checks apiserver if task error looks like an outage. True is returned if apiserver is not
+// available: the breaker is open and the task should be retried after close without counting the failure.
+func (b *ApiserverCircuitBreaker) Trip(err error) bool {
+	if !ApiserverBreakerEnabled || !kube.IsApiserverUnavailableError(err) {
+		return false
+	}
+
+	b.m.Lock()
+	if b.open {
+		b.suppressedErrors++
+		b.m.Unlock()
+		return true
+	}
+	b.m.Unlock()
+
+	probeErr := b.probe()
+	if probeErr == nil {
+		return false
+	}
+
+	b.m.Lock()
+	defer b.m.Unlock()
+	if !b.open {
+		b.open = true
+		b.openAt = time.Now()
+		b.suppressedErrors = 0
+		b.interval = ApiserverBreakerInterval
+		rlog.Errorf("APISERVER is not available, tasks queues are paused: %s", probeErr)
+		MetricsStorage.SendCounterMetric("antiopa_apiserver_outages", 1.0, map[string]string{})
+		sendApiserverAvailableMetric(false)
+	}
+	if !b.probing {
+		b.probing = true
+		go b.waitForApiserver()
+	}
+	return true
+}
+
+// waitForApiserver checks apiserver with backoff and closes the breaker on success
+func (b *ApiserverCircuitBreaker) waitForApiserver() {
+	for {
+		b.m.Lock()
+		interval := b.interval
+		b.m.Unlock()
+		time.Sleep(interval)
+
+		err := b.probe()
+
+		b.m.Lock()
+		if err == nil {
+			rlog.Infof("APISERVER is available after %s, tasks queues are resumed, %d task errors were suppressed", time.Since(b.openAt).Round(time.Second), b.suppressedErrors)
+			b.open = false
+			b.probing = false
+			b.m.Unlock()
+			sendApiserverAvailableMetric(true)
+			return
+		}
+		rlog.Debugf("APISERVER is still not available: %s", err)
+		b.interval *= 2
+		if b.interval > ApiserverBreakerMaxInterval {
+			b.interval = ApiserverBreakerMaxInterval
+		}
+		b.m.Unlock()
+	}
+}
+
+func sendApiserverAvailableMetric(available bool) {
+	value := 0.0
+	if available {
+		value = 1.0
+	}
+	MetricsStorage.SendGaugeMetric("antiopa_apiserver_available", value, map[string]string{})
+}
@@ -0,0 +1,51 @@
+package main
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestApiserverCircuitBreaker(t *testing.T) {
+	ApiserverBreakerInterval = 10 * time.Millisecond
+
+	var m sync.Mutex
+	available := true
+	probe := func() error {
+		m.Lock()
+		defer m.Unlock()
+		if available {
+			return nil
+		}
+		return fmt.Errorf("connection refused")
+	}
+	setAvailable := func(value bool) {
+		m.Lock()
+		available = value
+		m.Unlock()
+	}
+
+	b := NewApiserverCircuitBreaker(probe)
+	outageErr := fmt.Errorf("Get https://10.0.0.1:443/api/v1/namespaces: dial tcp 10.0.0.1:443: connect: connection refused")
+
+	// errors of hooks and charts are usual failures
+	assert.False(t, b.Trip(fmt.Errorf("hook exit status 1")))
+	// apiserver is available: error is not an outage
+	assert.False(t, b.Trip(outageErr))
+	assert.False(t, b.IsOpen())
+
+	setAvailable(false)
+	assert.True(t, b.Trip(outageErr))
+	assert.True(t, b.IsOpen())
+	// errors of other queues are suppressed without checks
+	assert.True(t, b.Trip(outageErr))
+
+	setAvailable(true)
+	for i := 0; i < 100 && b.IsOpen(); i++ {
+		time.Sleep(10 * time.Millisecond)
+	}
+	assert.False(t, b.IsOpen())
+}
@@ -0,0 +1,116 @@
+package approval
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/romana/rlog"
+)
+
+// Destructive operations wait for approval with API or annotation if Required is true
+var Required bool
+
+// Operation is a destructive operation waiting for approval
+type Operation struct {
+	Key         string    `json:"key"`
+	Description string    `json:"description"`
+	RequestedAt time.Time `json:"requestedAt"`
+
+	// called when operation is approved, e.g. to queue the task again
+	onApprove func()
+}
+
+var (
+	m        sync.Mutex
+	pending  = make(map[string]*Operation)
+	approved = make(map[string]bool)
+)
+
+// DeleteReleaseKey is a key of release deletion for the disabled module
+func DeleteReleaseKey(moduleName string) string {
+	return fmt.Sprintf("delete-release:%s", moduleName)
+}
+
+// PurgeFailedRevisionsKey is a key of deletion of old failed revisions of the release
+func PurgeFailedRevisionsKey(releaseName string) string {
+	return fmt.Sprintf("purge-failed-revisions:%s", releaseName)
+}
+
+// Request returns true if operation can be done: approval is not required or operation
+// is approved. Approval is used once. Otherwise operation is pending until Approve.
+func Request(key string, description string, onApprove func()) bool {
+	if !Required {
+		return true
+	}
+
+	m.Lock()
+	defer m.Unlock()
+
+	if approved[key] {
+		delete(approved, key)
+		delete(pending, key)
+		rlog.Infof("APPROVAL operation '%s' is approved: %s", key, description)
+		return true
+	}
+
+	if op, isPending := pending[key]; isPending {
+		op.Description = description
+		op.onApprove = onApprove
+		return false
+	}
+
+	rlog.Warnf("APPROVAL operation '%s' waits for approval: %s", key, description)
+	pending[key] = &Operation{
+		Key:         key,
+		Description: description,
+		RequestedAt: time.Now(),
+		onApprove:   onApprove,
+	}
+	return false
+}
+
+// Approve approves the operation. Operation can be approved before it is requested.
+func Approve(key string) {
+	m.Lock()
+	approved[key] = true
+	var onApprove func()
+	if op, isPending := pending[key]; isPending {
+		onApprove = op.onApprove
+		op.onApprove = nil
+	}
+	m.Unlock()
+
+	rlog.Infof("APPROVAL operation '%s' is approved", key)
+	if onApprove != nil {
+		onApprove()
+	}
+}
+
+// Forget removes pending operation and its approval, e.g. when disabled module is enabled again
+func Forget(key string) {
+	m.Lock()
+	defer m.Unlock()
+
+	if _, isPending := pending[key]; isPending {
+		rlog.Infof("APPROVAL operation '%s' is not needed anymore", key)
+	}
+	delete(pending, key)
+	delete(approved, key)
+}
+
+// Pending returns operations waiting for approval sorted by key
+func Pending() []Operation {
+	m.Lock()
+	defer m.Unlock()
+
+	res := make([]Operation, 0, len(pending))
+	for _, op := range pending {
+		res = append(res, *op)
+	}
+	sort.Slice(res, func(i, j int) bool {
+		return res[i].Key < res[j].Key
+	})
+	return res
+}
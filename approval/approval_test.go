@@ -0,0 +1,32 @@
+package approval
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRequest(t *testing.T) {
+	Required = true
+	defer func() { Required = false }()
+
+	key := DeleteReleaseKey("alpha")
+	approvedCalls := 0
+	assert.False(t, Request(key, "delete release of disabled module 'alpha'", func() { approvedCalls++ }))
+	assert.Len(t, Pending(), 1)
+
+	Approve(key)
+	assert.Equal(t, 1, approvedCalls)
+	assert.True(t, Request(key, "delete release of disabled module 'alpha'", nil))
+	assert.Len(t, Pending(), 0)
+
+	// approval is used once
+	assert.False(t, Request(key, "delete release of disabled module 'alpha'", nil))
+	Forget(key)
+	assert.Len(t, Pending(), 0)
+}
+
+func TestRequest_NotRequired(t *testing.T) {
+	assert.True(t, Request(PurgeFailedRevisionsKey("alpha"), "purge", nil))
+	assert.Len(t, Pending(), 0)
+}
@@ -0,0 +1,173 @@
+package chart_repo
+
+import (
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/romana/rlog"
+	"gopkg.in/yaml.v2"
+
+	"github.com/flant/antiopa/utils"
+)
+
+// Enabled turns on serving of module charts as a chart repository
+var Enabled bool
+
+// PathPrefix is an URL path of the repository, index is served at <PathPrefix>index.yaml
+const PathPrefix = "/charts/"
+
+// ChartMetadata is a part of Chart.yaml used in the repository index
+type ChartMetadata struct {
+	Name        string `yaml:"name"`
+	Version     string `yaml:"version"`
+	AppVersion  string `yaml:"appVersion,omitempty"`
+	Description string `yaml:"description,omitempty"`
+}
+
+// ChartVersion is an entry of the repository index
+type ChartVersion struct {
+	ChartMetadata `yaml:",inline"`
+	Digest        string    `yaml:"digest"`
+	Urls          []string  `yaml:"urls"`
+	Created       time.Time `yaml:"created"`
+}
+
+// Index is a helm repository index.yaml
+type Index struct {
+	ApiVersion string                     `yaml:"apiVersion"`
+	Entries    map[string][]*ChartVersion `yaml:"entries"`
+	Generated  time.Time                  `yaml:"generated"`
+}
+
+// chartPackage is a packaged chart of the module directory
+type chartPackage struct {
+	Metadata ChartMetadata
+	FileName string
+	Data     []byte
+	Digest   string
+}
+
+// Repository packages module charts on first request. Modules directories are not
+// changed while antiopa is running, so packages are cached by directory.
+type Repository struct {
+	m       sync.Mutex
+	cache   map[string]*chartPackage
+	created time.Time
+	// ChartsDirs returns directories of charts of deployed modules
+	ChartsDirs func() []string
+}
+
+func NewRepository(chartsDirs func() []string) *Repository {
+	return &Repository{
+		cache:      make(map[string]*chartPackage),
+		created:    time.Now(),
+		ChartsDirs: chartsDirs,
+	}
+}
+
+// packageChart creates a gzipped tar archive of the chart directory in helm package format.
+// Archive of the same directory is the same, so digest is stable between restarts.
+func packageChart(dir string) (*chartPackage, error) {
+	data, err := ioutil.ReadFile(filepath.Join(dir, "Chart.yaml"))
+	if err != nil {
+		return nil, err
+	}
+	var metadata ChartMetadata
+	if err := yaml.Unmarshal(data, &metadata); err != nil {
+		return nil, fmt.Errorf("bad Chart.yaml: %s", err)
+	}
+	if metadata.Name == "" || metadata.Version == "" {
+		return nil, fmt.Errorf("bad Chart.yaml: name and version are required")
+	}
+
+	buf := &bytes.Buffer{}
+	gz := gzip.NewWriter(buf)
+	if err := utils.ArchiveDirectoryWithPrefix(dir, metadata.Name, gz); err != nil {
+		return nil, err
+	}
+	if err := gz.Close(); err != nil {
+		return nil, err
+	}
+
+	sum := sha256.Sum256(buf.Bytes())
+	return &chartPackage{
+		Metadata: metadata,
+		FileName: fmt.Sprintf("%s-%s.tgz", metadata.Name, metadata.Version),
+		Data:     buf.Bytes(),
+		Digest:   hex.EncodeToString(sum[:]),
+	}, nil
+}
+
+// packages returns packages of charts of deployed modules
+func (r *Repository) packages() []*chartPackage {
+	r.m.Lock()
+	defer r.m.Unlock()
+
+	res := make([]*chartPackage, 0)
+	for _, dir := range r.ChartsDirs() {
+		pkg, ok := r.cache[dir]
+		if !ok {
+			var err error
+			pkg, err = packageChart(dir)
+			if err != nil {
+				rlog.Errorf("CHART_REPO cannot package chart '%s': %s", dir, err)
+				continue
+			}
+			r.cache[dir] = pkg
+		}
+		res = append(res, pkg)
+	}
+	return res
+}
+
+// Index returns the repository index with urls relative to the repository
+func (r *Repository) Index() *Index {
+	index := &Index{
+		ApiVersion: "v1",
+		Entries:    make(map[string][]*ChartVersion),
+		Generated:  time.Now(),
+	}
+	for _, pkg := range r.packages() {
+		index.Entries[pkg.Metadata.Name] = append(index.Entries[pkg.Metadata.Name], &ChartVersion{
+			ChartMetadata: pkg.Metadata,
+			Digest:        pkg.Digest,
+			Urls:          []string{pkg.FileName},
+			Created:       r.created,
+		})
+	}
+	return index
+}
+
+// ServeHTTP serves index.yaml and chart packages under PathPrefix
+func (r *Repository) ServeHTTP(writer http.ResponseWriter, request *http.Request) {
+	fileName := strings.TrimPrefix(request.URL.Path, PathPrefix)
+
+	if fileName == "index.yaml" {
+		data, err := yaml.Marshal(r.Index())
+		if err != nil {
+			http.Error(writer, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		writer.Header().Set("Content-Type", "application/x-yaml")
+		writer.Write(data)
+		return
+	}
+
+	for _, pkg := range r.packages() {
+		if pkg.FileName == fileName {
+			writer.Header().Set("Content-Type", "application/gzip")
+			writer.Write(pkg.Data)
+			return
+		}
+	}
+	http.NotFound(writer, request)
+}
@@ -0,0 +1,66 @@
+package chart_repo
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"io/ioutil"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"gopkg.in/yaml.v2"
+)
+
+func TestRepository(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "chart-repo")
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer os.RemoveAll(tmpDir)
+
+	chartDir := filepath.Join(tmpDir, "010-cert-manager")
+	os.MkdirAll(filepath.Join(chartDir, "templates"), 0755)
+	ioutil.WriteFile(filepath.Join(chartDir, "Chart.yaml"), []byte("name: cert-manager\nversion: 0.1.0\n"), 0644)
+	ioutil.WriteFile(filepath.Join(chartDir, "templates", "cm.yaml"), []byte("kind: ConfigMap\n"), 0644)
+
+	repo := NewRepository(func() []string { return []string{chartDir, filepath.Join(tmpDir, "absent")} })
+
+	rec := httptest.NewRecorder()
+	repo.ServeHTTP(rec, httptest.NewRequest("GET", "/charts/index.yaml", nil))
+	assert.Equal(t, 200, rec.Code)
+
+	var index Index
+	assert.NoError(t, yaml.Unmarshal(rec.Body.Bytes(), &index))
+	if !assert.Len(t, index.Entries["cert-manager"], 1) {
+		return
+	}
+	entry := index.Entries["cert-manager"][0]
+	assert.Equal(t, "0.1.0", entry.Version)
+	assert.Equal(t, []string{"cert-manager-0.1.0.tgz"}, entry.Urls)
+
+	rec = httptest.NewRecorder()
+	repo.ServeHTTP(rec, httptest.NewRequest("GET", "/charts/cert-manager-0.1.0.tgz", nil))
+	assert.Equal(t, 200, rec.Code)
+
+	gz, err := gzip.NewReader(bytes.NewReader(rec.Body.Bytes()))
+	if !assert.NoError(t, err) {
+		return
+	}
+	names := make([]string, 0)
+	tr := tar.NewReader(gz)
+	for {
+		header, err := tr.Next()
+		if err != nil {
+			break
+		}
+		names = append(names, header.Name)
+	}
+	assert.Equal(t, []string{"cert-manager/Chart.yaml", "cert-manager/templates", "cert-manager/templates/cm.yaml"}, names)
+
+	rec = httptest.NewRecorder()
+	repo.ServeHTTP(rec, httptest.NewRequest("GET", "/charts/absent-0.1.0.tgz", nil))
+	assert.Equal(t, 404, rec.Code)
+}
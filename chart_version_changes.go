@@ -0,0 +1,38 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/romana/rlog"
+	"k8s.io/api/core/v1"
+
+	"github.com/flant/antiopa/kube"
+	"github.com/flant/antiopa/module_manager"
+)
+
+// ReportChartVersionChange logs a change of the module chart version in the last run
+// and creates an event for antiopa Pod with the changelog excerpt
+func ReportChartVersionChange(module *module_manager.Module, taskId string) {
+	change := module.LastRunChartVersionChange()
+	if change == nil {
+		return
+	}
+
+	message := fmt.Sprintf("module '%s' chart version is changed from '%s' to '%s'", module.Name, change.PreviousVersion, change.Version)
+	if change.Changelog != "" {
+		message = fmt.Sprintf("%s:\n%s", message, change.Changelog)
+	}
+	rlog.Infof("MAIN_LOOP [%s] %s", taskId, message)
+
+	MetricsStorage.SendCounterMetric("antiopa_module_chart_version_changes", 1.0, map[string]string{"module": module.Name, "version": change.Version})
+
+	err := kube.CreateTaskEvent(v1.ObjectReference{
+		Kind:       "Pod",
+		APIVersion: "v1",
+		Namespace:  kube.KubernetesAntiopaNamespace,
+		Name:       Hostname,
+	}, v1.EventTypeNormal, "ModuleChartVersionChanged", message, taskId)
+	if err != nil {
+		rlog.Errorf("MAIN_LOOP %s", err)
+	}
+}
@@ -0,0 +1,68 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/flant/antiopa/helm"
+	"github.com/flant/antiopa/kube"
+)
+
+// RunCompactReleasesCommand handles `antiopa compact-releases`: old SUPERSEDED and FAILED
+// revisions of releases are deleted from tiller storage to free space in etcd.
+func RunCompactReleasesCommand(args []string) error {
+	flags := flag.NewFlagSet("compact-releases", flag.ContinueOnError)
+	tillerNamespace := flags.String("tiller-namespace", "", "tiller namespace, antiopa namespace is used if empty")
+	releases := flags.String("releases", "", "comma separated names of releases, all releases of the tiller are compacted if empty")
+	keepRevisions := flags.Int("keep-revisions", 10, "number of the last revisions to keep for each release, DEPLOYED revision is always kept")
+	deleteInterval := flags.Duration("delete-interval", 200*time.Millisecond, "pause between deletions of ConfigMaps")
+	dryRun := flags.Bool("dry-run", false, "only print revisions that would be deleted")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	kube.InitKube()
+
+	options := helm.CompactReleasesOptions{
+		TillerNamespace: kube.KubernetesAntiopaNamespace,
+		KeepRevisions:   *keepRevisions,
+		DryRun:          *dryRun,
+		DeleteInterval:  *deleteInterval,
+	}
+	if *tillerNamespace != "" {
+		options.TillerNamespace = *tillerNamespace
+	}
+	if *releases != "" {
+		options.Releases = strings.Split(*releases, ",")
+	}
+
+	results, err := helm.CompactReleases(options)
+	if err != nil {
+		return err
+	}
+
+	failed := 0
+	deleted := 0
+	deletedBytes := 0
+	for _, res := range results {
+		deleted += len(res.Deleted)
+		deletedBytes += res.DeletedBytes
+		if res.Error != nil {
+			failed++
+			fmt.Printf("FAIL %s: %s\n", res.Release, res.Error)
+			continue
+		}
+		fmt.Printf("OK   %s: %d of %d revisions deleted, %d KiB freed\n", res.Release, len(res.Deleted), res.Revisions, res.DeletedBytes/1024)
+	}
+	fmt.Printf("%d revisions deleted, %d KiB freed\n", deleted, deletedBytes/1024)
+	if *dryRun {
+		fmt.Println("dry run, nothing is deleted")
+	}
+
+	if failed > 0 {
+		return fmt.Errorf("%d releases are not compacted", failed)
+	}
+	return nil
+}
@@ -0,0 +1,133 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+
+	"github.com/romana/rlog"
+	"k8s.io/api/admission/v1beta1"
+	admissionregistration "k8s.io/api/admissionregistration/v1beta1"
+	"k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"github.com/flant/antiopa/kube"
+	"github.com/flant/antiopa/kube_config_manager"
+)
+
+// Validating webhook for the antiopa ConfigMap: edits with bad yaml or values that do not match
+// values schemas of modules are rejected by kubectl at once instead of errors in antiopa logs.
+// Webhook is served with TLS on ConfigWebhookListenAddress and is registered only if
+// ConfigWebhookServiceName is set: apiserver calls antiopa through this Service.
+var (
+	ConfigWebhookServiceName   string
+	ConfigWebhookListenAddress = ":9443"
+	ConfigWebhookCertFile      string
+	ConfigWebhookKeyFile       string
+	// CA bundle to verify the certificate, it is set into ValidatingWebhookConfiguration
+	ConfigWebhookCAFile string
+)
+
+const ConfigWebhookPath = "/validate/config"
+
+// configWebhookName is unique for the namespace, several antiopa can run in one cluster
+func configWebhookName() string {
+	return fmt.Sprintf("antiopa-config.%s.antiopa.flant.com", kube.KubernetesAntiopaNamespace)
+}
+
+// StartConfigValidatingWebhook starts the TLS server for admission requests and creates or updates
+// ValidatingWebhookConfiguration. Failure policy is Ignore, so the ConfigMap can be edited while
+// antiopa is down. Rules cannot select ConfigMap by name, other ConfigMaps are allowed by the handler.
+func StartConfigValidatingWebhook() error {
+	caBundle, err := ioutil.ReadFile(ConfigWebhookCAFile)
+	if err != nil {
+		return fmt.Errorf("cannot read CA bundle: %s", err)
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc(ConfigWebhookPath, handleConfigAdmissionReview)
+	go func() {
+		rlog.Infof("MAIN config validating webhook is listening on %s", ConfigWebhookListenAddress)
+		if err := http.ListenAndServeTLS(ConfigWebhookListenAddress, ConfigWebhookCertFile, ConfigWebhookKeyFile, mux); err != nil {
+			rlog.Errorf("MAIN config validating webhook server is stopped: %s", err)
+		}
+	}()
+
+	path := ConfigWebhookPath
+	failurePolicy := admissionregistration.Ignore
+	webhookConfig := &admissionregistration.ValidatingWebhookConfiguration{
+		ObjectMeta: metav1.ObjectMeta{Name: configWebhookName()},
+		Webhooks: []admissionregistration.ValidatingWebhook{
+			{
+				Name: configWebhookName(),
+				ClientConfig: admissionregistration.WebhookClientConfig{
+					Service: &admissionregistration.ServiceReference{
+						Namespace: kube.KubernetesAntiopaNamespace,
+						Name:      ConfigWebhookServiceName,
+						Path:      &path,
+					},
+					CABundle: caBundle,
+				},
+				Rules: []admissionregistration.RuleWithOperations{
+					{
+						Operations: []admissionregistration.OperationType{admissionregistration.Create, admissionregistration.Update},
+						Rule: admissionregistration.Rule{
+							APIGroups:   []string{""},
+							APIVersions: []string{"v1"},
+							Resources:   []string{"configmaps"},
+						},
+					},
+				},
+				FailurePolicy: &failurePolicy,
+			},
+		},
+	}
+
+	client := kube.Kubernetes.AdmissionregistrationV1beta1().ValidatingWebhookConfigurations()
+	existing, err := client.Get(webhookConfig.Name, metav1.GetOptions{})
+	if errors.IsNotFound(err) {
+		_, err = client.Create(webhookConfig)
+	} else if err == nil {
+		webhookConfig.ResourceVersion = existing.ResourceVersion
+		_, err = client.Update(webhookConfig)
+	}
+	if err != nil {
+		return fmt.Errorf("cannot register ValidatingWebhookConfiguration '%s': %s", webhookConfig.Name, err)
+	}
+	rlog.Infof("MAIN ValidatingWebhookConfiguration '%s' is registered", webhookConfig.Name)
+	return nil
+}
+
+func handleConfigAdmissionReview(writer http.ResponseWriter, request *http.Request) {
+	review := v1beta1.AdmissionReview{}
+	if err := json.NewDecoder(request.Body).Decode(&review); err != nil || review.Request == nil {
+		writer.WriteHeader(http.StatusBadRequest)
+		fmt.Fprintf(writer, "bad AdmissionReview: %v", err)
+		return
+	}
+
+	review.Response = reviewConfigAdmission(review.Request)
+	review.Response.UID = review.Request.UID
+	writer.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(writer).Encode(review)
+}
+
+// reviewConfigAdmission validates the antiopa ConfigMap, other objects are allowed
+func reviewConfigAdmission(request *v1beta1.AdmissionRequest) *v1beta1.AdmissionResponse {
+	if request.Namespace != kube.KubernetesAntiopaNamespace || request.Name != kube_config_manager.ConfigMapName {
+		return &v1beta1.AdmissionResponse{Allowed: true}
+	}
+
+	cm := v1.ConfigMap{}
+	if err := json.Unmarshal(request.Object.Raw, &cm); err != nil {
+		return &v1beta1.AdmissionResponse{Allowed: false, Result: &metav1.Status{Message: fmt.Sprintf("cannot decode ConfigMap: %s", err)}}
+	}
+	if err := ModuleManager.ValidateConfigData(cm.Data); err != nil {
+		rlog.Infof("MAIN config validating webhook: %s of ConfigMap '%s' by '%s' is rejected: %s", request.Operation, cm.Name, request.UserInfo.Username, err)
+		MetricsStorage.SendCounterMetric("antiopa_config_rejected", 1.0, map[string]string{})
+		return &v1beta1.AdmissionResponse{Allowed: false, Result: &metav1.Status{Message: fmt.Sprintf("antiopa config is invalid: %s", err)}}
+	}
+	return &v1beta1.AdmissionResponse{Allowed: true}
+}
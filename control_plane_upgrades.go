@@ -0,0 +1,106 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/romana/rlog"
+	"k8s.io/api/core/v1"
+
+	"github.com/flant/antiopa/kube"
+	"github.com/flant/antiopa/task"
+)
+
+// ControlPlaneUpgradeCheckInterval is a period of apiserver version checks
+var ControlPlaneUpgradeCheckInterval = time.Minute
+
+// ControlPlaneUpgradeConfirmations is a number of consecutive checks with a new version
+// before the upgrade is detected: apiservers of HA control plane are upgraded one by one,
+// so versions of responses alternate while the upgrade is in progress.
+const ControlPlaneUpgradeConfirmations = 2
+
+// controlPlaneVersion detects changes of the apiserver version
+type controlPlaneVersion struct {
+	version       string
+	candidate     string
+	confirmations int
+}
+
+// observe returns the previous version and true when the new version is confirmed
+func (v *controlPlaneVersion) observe(version string) (string, bool) {
+	if v.version == "" {
+		v.version = version
+		return "", false
+	}
+	if version == v.version {
+		v.candidate, v.confirmations = "", 0
+		return "", false
+	}
+	if version != v.candidate {
+		v.candidate, v.confirmations = version, 0
+	}
+	v.confirmations++
+	if v.confirmations < ControlPlaneUpgradeConfirmations {
+		return "", false
+	}
+	previous := v.version
+	v.version, v.candidate, v.confirmations = version, "", 0
+	return previous, true
+}
+
+// RunControlPlaneUpgradesWatcher checks the apiserver version and reruns modules
+// with kubernetesVersionSensitive in module.yaml when the version is changed.
+func RunControlPlaneUpgradesWatcher() {
+	detector := &controlPlaneVersion{}
+	for {
+		version, err := kube.ServerGitVersion()
+		if err != nil {
+			rlog.Debugf("CONTROL_PLANE cannot get apiserver version: %s", err)
+		} else if previous, upgraded := detector.observe(version); upgraded {
+			HandleControlPlaneUpgrade(previous, version)
+		}
+		time.Sleep(ControlPlaneUpgradeCheckInterval)
+	}
+}
+
+// HandleControlPlaneUpgrade adds runs with helm upgrade of enabled version sensitive modules
+// and creates an event for antiopa Pod with the versions and modules.
+func HandleControlPlaneUpgrade(previous string, version string) {
+	cause := fmt.Sprintf("control plane upgrade from %s to %s", previous, version)
+
+	modules := make([]string, 0)
+	for _, moduleName := range ModuleManager.GetModuleNamesInOrder() {
+		module, err := ModuleManager.GetModule(moduleName)
+		if err != nil || module.Definition == nil || !module.Definition.KubernetesVersionSensitive {
+			continue
+		}
+		if err := ModuleManager.ForceModuleHelmUpgrade(moduleName); err != nil {
+			rlog.Errorf("CONTROL_PLANE module '%s': cannot force helm upgrade: %s", moduleName, err)
+			continue
+		}
+		newTask := task.NewTask(task.ModuleRun, moduleName).
+			WithCause(cause)
+		TasksQueue.Add(newTask)
+		moduleQueueLog(moduleName).Recordf("INFO", "task %s: rerun after %s", newTask.GetCorrelationId(), cause)
+		modules = append(modules, moduleName)
+	}
+
+	message := fmt.Sprintf("%s, no version sensitive modules are enabled", cause)
+	if len(modules) > 0 {
+		message = fmt.Sprintf("%s, rerun modules: %s", cause, strings.Join(modules, ", "))
+	}
+	rlog.Infof("CONTROL_PLANE %s", message)
+
+	MetricsStorage.SendCounterMetric("antiopa_control_plane_upgrades", 1.0, map[string]string{"version": version})
+
+	err := kube.CreateNormalEvent(v1.ObjectReference{
+		Kind:       "Pod",
+		APIVersion: "v1",
+		Namespace:  kube.KubernetesAntiopaNamespace,
+		Name:       Hostname,
+	}, "ControlPlaneUpgraded", message)
+	if err != nil {
+		rlog.Errorf("CONTROL_PLANE %s", err)
+	}
+}
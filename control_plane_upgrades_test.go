@@ -0,0 +1,29 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestControlPlaneVersion_observe(t *testing.T) {
+	detector := &controlPlaneVersion{}
+
+	_, upgraded := detector.observe("v1.14.6")
+	assert.False(t, upgraded)
+
+	// versions alternate while apiservers are upgraded one by one
+	_, upgraded = detector.observe("v1.15.3")
+	assert.False(t, upgraded)
+	_, upgraded = detector.observe("v1.14.6")
+	assert.False(t, upgraded)
+	_, upgraded = detector.observe("v1.15.3")
+	assert.False(t, upgraded)
+
+	previous, upgraded := detector.observe("v1.15.3")
+	assert.True(t, upgraded)
+	assert.Equal(t, "v1.14.6", previous)
+
+	_, upgraded = detector.observe("v1.15.3")
+	assert.False(t, upgraded)
+}
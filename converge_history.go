@@ -0,0 +1,242 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/romana/rlog"
+
+	"github.com/flant/antiopa/helm"
+	"github.com/flant/antiopa/module_manager"
+	"github.com/flant/antiopa/state_store"
+	"github.com/flant/antiopa/task"
+)
+
+// Number of converge cycles kept in history
+const ConvergeHistoryLength = 50
+
+// ConvergeHistoryStorageName is a ConfigMap (or other state storage) to keep converge history
+// between restarts of antiopa pod. History is kept only in memory if name is empty.
+var ConvergeHistoryStorageName string
+
+const convergeHistoryStorageKey = "converge-history.json"
+
+// ConvergeModuleRecord is a result of module task in converge cycle
+type ConvergeModuleRecord struct {
+	Module    string    `json:"module"`
+	Task      string    `json:"task"`
+	TaskId    string    `json:"taskId"`
+	StartedAt time.Time `json:"startedAt"`
+	Duration  string    `json:"duration"`
+	Result    string    `json:"result"`
+	Error     string    `json:"error,omitempty"`
+	// summary of values changes since last successful run
+	ValuesChanges []string `json:"valuesChanges,omitempty"`
+	// result of helm upgrade if release was changed
+	Release *helm.ReleaseUpgradeResult `json:"release,omitempty"`
+	// change of Chart.yaml version since the previous run
+	ChartVersionChange *module_manager.ChartVersionChange `json:"chartVersionChange,omitempty"`
+}
+
+// ConvergeCycle is a set of module tasks from the trigger until the queue is empty
+type ConvergeCycle struct {
+	Id         int                    `json:"id"`
+	Cause      string                 `json:"cause"`
+	StartedAt  time.Time              `json:"startedAt"`
+	FinishedAt *time.Time             `json:"finishedAt,omitempty"`
+	Modules    []ConvergeModuleRecord `json:"modules"`
+}
+
+// ConvergeHistory keeps a bounded list of recent converge cycles
+type ConvergeHistory struct {
+	m      sync.Mutex
+	limit  int
+	lastId int
+	cycles []*ConvergeCycle
+	// nil store keeps history in memory
+	store  state_store.Store
+	saveCh chan struct{}
+}
+
+func NewConvergeHistory(limit int) *ConvergeHistory {
+	return &ConvergeHistory{
+		limit:  limit,
+		cycles: make([]*ConvergeCycle, 0),
+	}
+}
+
+func (h *ConvergeHistory) currentCycle() *ConvergeCycle {
+	if len(h.cycles) == 0 {
+		return nil
+	}
+	cycle := h.cycles[len(h.cycles)-1]
+	if cycle.FinishedAt != nil {
+		return nil
+	}
+	return cycle
+}
+
+// StartCycle finishes current cycle and starts a new one
+func (h *ConvergeHistory) StartCycle(cause string) {
+	h.m.Lock()
+	defer h.m.Unlock()
+
+	h.finishCycle()
+	h.startCycle(cause)
+}
+
+func (h *ConvergeHistory) startCycle(cause string) *ConvergeCycle {
+	h.lastId++
+	cycle := &ConvergeCycle{
+		Id:        h.lastId,
+		Cause:     cause,
+		StartedAt: time.Now(),
+		Modules:   make([]ConvergeModuleRecord, 0),
+	}
+
+	h.cycles = append(h.cycles, cycle)
+	if len(h.cycles) > h.limit {
+		h.cycles = h.cycles[len(h.cycles)-h.limit:]
+	}
+
+	return cycle
+}
+
+// RecordModule adds a result of module task to current cycle.
+// A new cycle is started if there is no current cycle.
+func (h *ConvergeHistory) RecordModule(cause string, record ConvergeModuleRecord) {
+	h.m.Lock()
+	defer h.m.Unlock()
+
+	cycle := h.currentCycle()
+	if cycle == nil {
+		cycle = h.startCycle(cause)
+	}
+	cycle.Modules = append(cycle.Modules, record)
+}
+
+// FinishCycle marks current cycle as finished
+func (h *ConvergeHistory) FinishCycle() {
+	h.m.Lock()
+	defer h.m.Unlock()
+
+	h.finishCycle()
+}
+
+func (h *ConvergeHistory) finishCycle() {
+	cycle := h.currentCycle()
+	if cycle == nil {
+		return
+	}
+	now := time.Now()
+	cycle.FinishedAt = &now
+
+	if h.saveCh != nil {
+		select {
+		case h.saveCh <- struct{}{}:
+		default:
+			// save is already pending
+		}
+	}
+}
+
+// Restore loads history saved by the previous antiopa pod and saves finished cycles into the store
+func (h *ConvergeHistory) Restore(store state_store.Store) error {
+	data, err := store.Load()
+	if err != nil {
+		return fmt.Errorf("cannot load converge history: %s", err)
+	}
+
+	h.m.Lock()
+	defer h.m.Unlock()
+	h.store = store
+	h.saveCh = make(chan struct{}, 1)
+	go h.runSaves()
+	if data == nil {
+		return nil
+	}
+
+	cycles := make([]*ConvergeCycle, 0)
+	if err := json.Unmarshal(data, &cycles); err != nil {
+		return fmt.Errorf("bad saved converge history: %s", err)
+	}
+	for _, cycle := range cycles {
+		// cycle is interrupted by restart
+		if cycle.FinishedAt == nil {
+			finishedAt := cycle.StartedAt
+			cycle.FinishedAt = &finishedAt
+		}
+		if cycle.Id > h.lastId {
+			h.lastId = cycle.Id
+		}
+	}
+	h.cycles = append(cycles, h.cycles...)
+	if len(h.cycles) > h.limit {
+		h.cycles = h.cycles[len(h.cycles)-h.limit:]
+	}
+	rlog.Infof("MAIN converge history is restored from %s", store)
+	return nil
+}
+
+func (h *ConvergeHistory) runSaves() {
+	for range h.saveCh {
+		h.m.Lock()
+		finished := make([]*ConvergeCycle, 0, len(h.cycles))
+		for _, cycle := range h.cycles {
+			if cycle.FinishedAt != nil {
+				finished = append(finished, cycle)
+			}
+		}
+		data, err := json.Marshal(finished)
+		h.m.Unlock()
+		if err == nil {
+			err = h.store.Save(data)
+		}
+		if err != nil {
+			rlog.Errorf("MAIN cannot save converge history into %s: %s", h.store, err)
+		}
+	}
+}
+
+// Dump returns a copy of cycles, latest cycle goes first
+func (h *ConvergeHistory) Dump() []ConvergeCycle {
+	h.m.Lock()
+	defer h.m.Unlock()
+
+	res := make([]ConvergeCycle, 0, len(h.cycles))
+	for i := len(h.cycles) - 1; i >= 0; i-- {
+		cycle := *h.cycles[i]
+		cycle.Modules = append([]ConvergeModuleRecord{}, cycle.Modules...)
+		res = append(res, cycle)
+	}
+	return res
+}
+
+// RecordModuleTask saves a result of ModuleRun, ModuleDelete or ModulePurge task into ConvergeCycles
+func RecordModuleTask(t task.Task, startedAt time.Time, valuesChanges []string, release *helm.ReleaseUpgradeResult, err error) {
+	record := ConvergeModuleRecord{
+		Module:        t.GetName(),
+		Task:          string(t.GetType()),
+		TaskId:        t.GetCorrelationId(),
+		StartedAt:     startedAt,
+		Duration:      time.Since(startedAt).String(),
+		Result:        "success",
+		ValuesChanges: valuesChanges,
+		Release:       release,
+	}
+	if err != nil {
+		record.Result = "error"
+		record.Error = err.Error()
+	} else if t.GetType() == task.ModuleRun {
+		if module, _ := ModuleManager.GetModule(t.GetName()); module != nil {
+			record.ChartVersionChange = module.LastRunChartVersionChange()
+		}
+	}
+	if t.IsCancelled() {
+		record.Result = "cancelled"
+	}
+
+	ConvergeCycles.RecordModule(t.GetCause(), record)
+}
@@ -0,0 +1,85 @@
+package main
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/flant/antiopa/state_store"
+)
+
+func TestConvergeHistory(t *testing.T) {
+	h := NewConvergeHistory(2)
+
+	// record without started cycle starts a new one
+	h.RecordModule("module values changed", ConvergeModuleRecord{Module: "a", Result: "success"})
+	h.FinishCycle()
+
+	h.StartCycle("startup")
+	h.RecordModule("startup", ConvergeModuleRecord{Module: "b", Result: "error", Error: "fail"})
+	h.RecordModule("startup", ConvergeModuleRecord{Module: "b", Result: "success"})
+
+	h.StartCycle("global values changed")
+
+	cycles := h.Dump()
+	if !assert.Len(t, cycles, 2) {
+		return
+	}
+
+	assert.Equal(t, 3, cycles[0].Id)
+	assert.Equal(t, "global values changed", cycles[0].Cause)
+	assert.Nil(t, cycles[0].FinishedAt)
+	assert.Len(t, cycles[0].Modules, 0)
+
+	assert.Equal(t, 2, cycles[1].Id)
+	assert.Equal(t, "startup", cycles[1].Cause)
+	assert.NotNil(t, cycles[1].FinishedAt)
+	assert.Len(t, cycles[1].Modules, 2)
+}
+
+func TestConvergeHistory_Restore(t *testing.T) {
+	dir, err := ioutil.TempDir("", "converge-history")
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer os.RemoveAll(dir)
+	store := &state_store.FileStore{Path: filepath.Join(dir, "converge-history.json")}
+
+	h := NewConvergeHistory(3)
+	assert.NoError(t, h.Restore(store))
+	h.RecordModule("startup", ConvergeModuleRecord{Module: "a", Result: "success"})
+	h.FinishCycle()
+	h.RecordModule("module values changed", ConvergeModuleRecord{Module: "b", Result: "success"})
+	h.FinishCycle()
+	h.StartCycle("global values changed")
+
+	// wait for background save
+	var restored *ConvergeHistory
+	for i := 0; i < 100; i++ {
+		restored = restoredConvergeHistory(t, store)
+		if len(restored.Dump()) == 2 {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	cycles := restored.Dump()
+	if !assert.Len(t, cycles, 2) {
+		return
+	}
+	assert.Equal(t, 2, cycles[0].Id)
+	assert.Equal(t, "module values changed", cycles[0].Cause)
+
+	// ids continue after restored cycles
+	restored.StartCycle("startup")
+	assert.Equal(t, 3, restored.Dump()[0].Id)
+}
+
+func restoredConvergeHistory(t *testing.T, store state_store.Store) *ConvergeHistory {
+	h := NewConvergeHistory(3)
+	assert.NoError(t, h.Restore(store))
+	return h
+}
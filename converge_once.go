@@ -0,0 +1,110 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/romana/rlog"
+
+	"github.com/flant/antiopa/task"
+)
+
+// ConvergeOnce runs onStartup hooks, modules discovery and one converge of all modules, then exits.
+// Exit code is 0 if converge is successful, 1 if a task fails ConvergeOnceMaxFailures times and 2 on timeout.
+var ConvergeOnce bool
+
+// Task is failed in converge once mode after this number of failed runs
+var ConvergeOnceMaxFailures = 3
+
+// Converge once mode exits with error if converge is not done in this time
+var ConvergeOnceTimeout = 30 * time.Minute
+
+const (
+	ConvergeOnceExitSuccess = 0
+	ConvergeOnceExitFailed  = 1
+	ConvergeOnceExitTimeout = 2
+)
+
+var (
+	convergeOnceMutex      sync.Mutex
+	convergeOnceDiscovered bool
+)
+
+// CheckConvergeOnce validates options of converge once mode
+func CheckConvergeOnce() error {
+	if !ConvergeOnce {
+		return nil
+	}
+	if ConvergePlanApproval {
+		return fmt.Errorf("converge plan approval cannot be used, nobody approves plans before exit")
+	}
+	if ConvergeOnceMaxFailures < 1 {
+		return fmt.Errorf("max failures should be positive")
+	}
+	if ConvergeOnceTimeout <= 0 {
+		return fmt.Errorf("timeout should be positive")
+	}
+	return nil
+}
+
+// ConvergeOnceDiscovered marks that modules discovery is done and module tasks are queued
+func ConvergeOnceDiscovered() {
+	convergeOnceMutex.Lock()
+	convergeOnceDiscovered = true
+	convergeOnceMutex.Unlock()
+}
+
+// CheckConvergeOnceDone exits when the main queue is empty after modules discovery
+func CheckConvergeOnceDone() {
+	if !ConvergeOnce {
+		return
+	}
+	convergeOnceMutex.Lock()
+	discovered := convergeOnceDiscovered
+	convergeOnceMutex.Unlock()
+	if !discovered {
+		return
+	}
+
+	rlog.Infof("CONVERGE_ONCE converge is done")
+	exitConvergeOnce(ConvergeOnceExitSuccess)
+}
+
+// CheckConvergeOnceFailure exits if the task is failed too many times
+func CheckConvergeOnceFailure(t task.Task, err error) {
+	if !ConvergeOnce || t.GetFailureCount() < ConvergeOnceMaxFailures {
+		return
+	}
+
+	rlog.Errorf("CONVERGE_ONCE %s '%s' failed %d times: %s", t.GetType(), t.GetName(), t.GetFailureCount(), err)
+	exitConvergeOnce(ConvergeOnceExitFailed)
+}
+
+// RunConvergeOnceTimeout exits if converge is not done in ConvergeOnceTimeout
+func RunConvergeOnceTimeout() {
+	time.Sleep(ConvergeOnceTimeout)
+	rlog.Errorf("CONVERGE_ONCE converge is not done in %s", ConvergeOnceTimeout)
+	exitConvergeOnce(ConvergeOnceExitTimeout)
+}
+
+// exitConvergeOnce logs results of module tasks and exits with code
+func exitConvergeOnce(code int) {
+	for _, cycle := range ConvergeCycles.Dump() {
+		for _, record := range cycle.Modules {
+			if record.Error != "" {
+				rlog.Infof("CONVERGE_ONCE cycle #%d %s %s: %s: %s", cycle.Id, record.Task, record.Module, record.Result, record.Error)
+			} else {
+				rlog.Infof("CONVERGE_ONCE cycle #%d %s %s: %s", cycle.Id, record.Task, record.Module, record.Result)
+			}
+		}
+	}
+
+	if err := ModuleManager.FlushDynamicValues(); err != nil {
+		rlog.Errorf("CONVERGE_ONCE %s", err)
+	}
+
+	rlog.Infof("CONVERGE_ONCE exit with code %d", code)
+	os.Exit(code)
+}
@@ -0,0 +1,173 @@
+package main
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/romana/rlog"
+
+	"github.com/flant/antiopa/module_manager"
+	"github.com/flant/antiopa/utils"
+)
+
+// Plans with changes are queued only after approval with API
+var ConvergePlanApproval bool
+
+const (
+	ConvergePlanRun    = "run"
+	ConvergePlanDelete = "delete"
+	ConvergePlanPurge  = "purge"
+)
+
+const (
+	ConvergePlanPending   = "pending"
+	ConvergePlanApplied   = "applied"
+	ConvergePlanDiscarded = "discarded"
+)
+
+// ConvergePlanAction is a module task of the converge cycle and its reason
+type ConvergePlanAction struct {
+	Module string `json:"module"`
+	Action string `json:"action"`
+	Reason string `json:"reason"`
+	// action is not caused only by the trigger of converge cycle
+	Change bool `json:"change"`
+}
+
+// ConvergePlan is a list of module tasks calculated by modules discovery before they are queued
+type ConvergePlan struct {
+	Id        int                  `json:"id"`
+	Cause     string               `json:"cause"`
+	CreatedAt time.Time            `json:"createdAt"`
+	Status    string               `json:"status"`
+	Actions   []ConvergePlanAction `json:"actions"`
+}
+
+func NewConvergePlan(cause string, state *module_manager.ModulesState) *ConvergePlan {
+	plan := &ConvergePlan{
+		Cause:     cause,
+		CreatedAt: time.Now(),
+		Actions:   make([]ConvergePlanAction, 0),
+	}
+
+	for _, moduleName := range state.ModulesToRun {
+		action := ConvergePlanAction{Module: moduleName, Action: ConvergePlanRun, Reason: cause, Change: true}
+		switch {
+		case utils.ListFullyIn([]string{moduleName}, state.NewlyEnabledModules):
+			action.Reason = "module enabled"
+		case utils.ListFullyIn([]string{moduleName}, state.ModulesWithChangedValues):
+			action.Reason = "values changed"
+		default:
+			action.Change = false
+		}
+		plan.Actions = append(plan.Actions, action)
+	}
+	for _, moduleName := range state.ModulesToDisable {
+		plan.Actions = append(plan.Actions, ConvergePlanAction{Module: moduleName, Action: ConvergePlanDelete, Reason: "module disabled", Change: true})
+	}
+	for _, moduleName := range state.ReleasedUnknownModules {
+		plan.Actions = append(plan.Actions, ConvergePlanAction{Module: moduleName, Action: ConvergePlanPurge, Reason: "release of unknown module", Change: true})
+	}
+
+	return plan
+}
+
+// HasChanges returns true if plan has actions not caused only by the trigger,
+// e.g. periodic converge of unchanged modules has no changes.
+func (p *ConvergePlan) HasChanges() bool {
+	for _, action := range p.Actions {
+		if action.Change {
+			return true
+		}
+	}
+	return false
+}
+
+func (p *ConvergePlan) Log() {
+	rlog.Infof("CONVERGE_PLAN #%d '%s': %d actions", p.Id, p.Cause, len(p.Actions))
+	for _, action := range p.Actions {
+		mark := " "
+		if action.Change {
+			mark = "~"
+		}
+		rlog.Infof("CONVERGE_PLAN #%d %s %s %s: %s", p.Id, mark, action.Action, action.Module, action.Reason)
+	}
+}
+
+// ConvergePlans keeps the last plan and the plan waiting for approval
+type ConvergePlans struct {
+	m       sync.Mutex
+	lastId  int
+	last    *ConvergePlan
+	pending *ConvergePlan
+	apply   func() error
+}
+
+func NewConvergePlans() *ConvergePlans {
+	return &ConvergePlans{}
+}
+
+// Propose applies the plan or keeps it until approval if approval is required and plan
+// has changes. New plan discards the pending one, it is calculated from the current state.
+func (c *ConvergePlans) Propose(plan *ConvergePlan, apply func() error) error {
+	c.m.Lock()
+	c.lastId++
+	plan.Id = c.lastId
+	c.last = plan
+	if c.pending != nil {
+		rlog.Infof("CONVERGE_PLAN #%d is discarded by plan #%d", c.pending.Id, plan.Id)
+		c.pending.Status = ConvergePlanDiscarded
+		c.pending, c.apply = nil, nil
+	}
+	plan.Log()
+
+	if ConvergePlanApproval && plan.HasChanges() {
+		plan.Status = ConvergePlanPending
+		c.pending, c.apply = plan, apply
+		c.m.Unlock()
+		rlog.Infof("CONVERGE_PLAN #%d waits for approval", plan.Id)
+		MetricsStorage.SendGaugeMetric("antiopa_converge_plan_pending", 1.0, map[string]string{})
+		return nil
+	}
+	c.m.Unlock()
+
+	MetricsStorage.SendGaugeMetric("antiopa_converge_plan_pending", 0.0, map[string]string{})
+	if err := apply(); err != nil {
+		return err
+	}
+	c.m.Lock()
+	plan.Status = ConvergePlanApplied
+	c.m.Unlock()
+	return nil
+}
+
+// Approve applies the pending plan with id
+func (c *ConvergePlans) Approve(id int) error {
+	c.m.Lock()
+	defer c.m.Unlock()
+
+	if c.pending == nil || c.pending.Id != id {
+		return fmt.Errorf("converge plan #%d is not pending approval", id)
+	}
+	if err := c.apply(); err != nil {
+		return err
+	}
+	rlog.Infof("CONVERGE_PLAN #%d is approved", id)
+	c.pending.Status = ConvergePlanApplied
+	c.pending, c.apply = nil, nil
+	MetricsStorage.SendGaugeMetric("antiopa_converge_plan_pending", 0.0, map[string]string{})
+	return nil
+}
+
+// Last returns a copy of the last plan or nil
+func (c *ConvergePlans) Last() *ConvergePlan {
+	c.m.Lock()
+	defer c.m.Unlock()
+
+	if c.last == nil {
+		return nil
+	}
+	plan := *c.last
+	return &plan
+}
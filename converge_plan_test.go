@@ -0,0 +1,38 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/flant/antiopa/module_manager"
+)
+
+func TestNewConvergePlan(t *testing.T) {
+	state := &module_manager.ModulesState{
+		ModulesToRun:             []string{"alpha", "beta", "gamma"},
+		ModulesToDisable:         []string{"delta"},
+		ReleasedUnknownModules:   []string{"epsilon"},
+		NewlyEnabledModules:      []string{"alpha"},
+		ModulesWithChangedValues: []string{"alpha", "beta"},
+	}
+
+	plan := NewConvergePlan("periodic", state)
+	assert.Equal(t, []ConvergePlanAction{
+		{Module: "alpha", Action: ConvergePlanRun, Reason: "module enabled", Change: true},
+		{Module: "beta", Action: ConvergePlanRun, Reason: "values changed", Change: true},
+		{Module: "gamma", Action: ConvergePlanRun, Reason: "periodic", Change: false},
+		{Module: "delta", Action: ConvergePlanDelete, Reason: "module disabled", Change: true},
+		{Module: "epsilon", Action: ConvergePlanPurge, Reason: "release of unknown module", Change: true},
+	}, plan.Actions)
+	assert.True(t, plan.HasChanges())
+
+	plan = NewConvergePlan("periodic", &module_manager.ModulesState{ModulesToRun: []string{"gamma"}})
+	assert.False(t, plan.HasChanges())
+}
+
+func TestConvergePlans_ApproveUnknown(t *testing.T) {
+	plans := NewConvergePlans()
+	assert.Nil(t, plans.Last())
+	assert.Error(t, plans.Approve(1))
+}
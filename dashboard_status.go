@@ -0,0 +1,203 @@
+package main
+
+import (
+	"time"
+
+	"github.com/flant/antiopa/module_manager"
+	"github.com/flant/antiopa/task"
+	"github.com/flant/antiopa/version"
+)
+
+// DashboardStatusVersion is a version of the /dashboard/v1 format. Fields are only added
+// within the version, renames and removals require a new version and a new endpoint.
+const DashboardStatusVersion = "v1"
+
+// DashboardStatus is a consolidated state of antiopa for dashboards and status pages
+type DashboardStatus struct {
+	Version     string                      `json:"version"`
+	Antiopa     version.Info                `json:"antiopa"`
+	GeneratedAt time.Time                   `json:"generatedAt"`
+	Summary     DashboardSummary            `json:"summary"`
+	Queues      []DashboardQueue            `json:"queues"`
+	Converge    *DashboardConverge          `json:"lastConverge"`
+	Modules     []DashboardModule           `json:"modules"`
+	Skipped     []module_manager.ModuleSkip `json:"skippedModules"`
+	// nil before the first check
+	AddonsHealth *AddonsHealthCondition `json:"addonsHealth,omitempty"`
+}
+
+// DashboardSummary are counters for single-stat panels
+type DashboardSummary struct {
+	EnabledModules  int `json:"enabledModules"`
+	HealthyModules  int `json:"healthyModules"`
+	FlappingModules int `json:"flappingModules"`
+	FailedModules   int `json:"failedModules"`
+	SkippedModules  int `json:"skippedModules"`
+	QueuedTasks     int `json:"queuedTasks"`
+}
+
+// DashboardQueue is a length of the queue and the task in progress
+type DashboardQueue struct {
+	Name   string `json:"name"`
+	Length int    `json:"length"`
+	// task at the head of the queue
+	HeadTask         string `json:"headTask,omitempty"`
+	HeadTaskFailures int    `json:"headTaskFailures"`
+}
+
+// DashboardConverge is a summary of the last converge cycle
+type DashboardConverge struct {
+	Id         int        `json:"id"`
+	Cause      string     `json:"cause"`
+	StartedAt  time.Time  `json:"startedAt"`
+	FinishedAt *time.Time `json:"finishedAt,omitempty"`
+	// duration in seconds, cycle in progress has duration until now
+	Duration float64 `json:"duration"`
+	Modules  int     `json:"modules"`
+	Failed   int     `json:"failed"`
+}
+
+// DashboardModule is a state of the enabled module
+type DashboardModule struct {
+	Name                string  `json:"name"`
+	Condition           string  `json:"condition"`
+	SuccessRate         float64 `json:"successRate"`
+	ConsecutiveFailures int     `json:"consecutiveFailures"`
+	// the last run from converge history
+	LastRunAt     *time.Time `json:"lastRunAt,omitempty"`
+	LastRunResult string     `json:"lastRunResult,omitempty"`
+	LastRunError  string     `json:"lastRunError,omitempty"`
+}
+
+// DashboardQueueState is a state of one tasks queue
+type DashboardQueueState struct {
+	Name  string
+	Queue *task.TasksQueue
+}
+
+// BuildDashboardStatus combines module health, converge history and queues.
+// Enabled modules without runs in history are Healthy with zero runs.
+func BuildDashboardStatus(enabledModules []string, health []ModuleHealthStatus, cycles []ConvergeCycle, queues []DashboardQueueState, skipped []module_manager.ModuleSkip, now time.Time) *DashboardStatus {
+	status := &DashboardStatus{
+		Version:     DashboardStatusVersion,
+		Antiopa:     version.Get(),
+		GeneratedAt: now,
+		Queues:      make([]DashboardQueue, 0, len(queues)),
+		Modules:     make([]DashboardModule, 0, len(enabledModules)),
+		Skipped:     skipped,
+	}
+	if status.Skipped == nil {
+		status.Skipped = make([]module_manager.ModuleSkip, 0)
+	}
+
+	for _, q := range queues {
+		if q.Queue == nil {
+			continue
+		}
+		queue := DashboardQueue{Name: q.Name, Length: q.Queue.Length()}
+		if head, err := q.Queue.Peek(); err == nil && head != nil {
+			queue.HeadTask = string(head.GetType()) + " " + head.GetName()
+			queue.HeadTaskFailures = head.GetFailureCount()
+		}
+		status.Queues = append(status.Queues, queue)
+		status.Summary.QueuedTasks += queue.Length
+	}
+
+	if len(cycles) > 0 {
+		// the latest cycle goes first
+		cycle := cycles[0]
+		converge := &DashboardConverge{
+			Id:         cycle.Id,
+			Cause:      cycle.Cause,
+			StartedAt:  cycle.StartedAt,
+			FinishedAt: cycle.FinishedAt,
+			Modules:    len(cycle.Modules),
+		}
+		finishedAt := now
+		if cycle.FinishedAt != nil {
+			finishedAt = *cycle.FinishedAt
+		}
+		converge.Duration = finishedAt.Sub(cycle.StartedAt).Seconds()
+		for _, record := range cycle.Modules {
+			if record.Error != "" {
+				converge.Failed++
+			}
+		}
+		status.Converge = converge
+	}
+
+	healthByModule := make(map[string]ModuleHealthStatus)
+	for _, h := range health {
+		healthByModule[h.Module] = h
+	}
+
+	for _, name := range enabledModules {
+		module := DashboardModule{Name: name, Condition: ModuleHealthHealthy}
+		if h, has := healthByModule[name]; has {
+			module.Condition = h.Condition
+			module.SuccessRate = h.SuccessRate
+			module.ConsecutiveFailures = h.ConsecutiveFailures
+		}
+		if record := lastModuleRecord(cycles, name); record != nil {
+			startedAt := record.StartedAt
+			module.LastRunAt = &startedAt
+			module.LastRunResult = record.Result
+			module.LastRunError = record.Error
+		}
+
+		switch module.Condition {
+		case ModuleHealthFailed:
+			status.Summary.FailedModules++
+		case ModuleHealthFlapping:
+			status.Summary.FlappingModules++
+		default:
+			status.Summary.HealthyModules++
+		}
+		status.Modules = append(status.Modules, module)
+	}
+	status.Summary.EnabledModules = len(status.Modules)
+	status.Summary.SkippedModules = len(status.Skipped)
+
+	return status
+}
+
+// lastModuleRecord returns the latest record of the module in cycles sorted from the latest
+func lastModuleRecord(cycles []ConvergeCycle, moduleName string) *ConvergeModuleRecord {
+	for _, cycle := range cycles {
+		for i := len(cycle.Modules) - 1; i >= 0; i-- {
+			if cycle.Modules[i].Module == moduleName {
+				return &cycle.Modules[i]
+			}
+		}
+	}
+	return nil
+}
+
+// DashboardStatusNow returns the current status of antiopa
+func DashboardStatusNow() *DashboardStatus {
+	queues := []DashboardQueueState{{Name: MainQueueName, Queue: TasksQueue}}
+	for _, name := range NamedQueues.Names() {
+		queues = append(queues, DashboardQueueState{Name: name, Queue: NamedQueues.Get(name)})
+	}
+
+	var enabledModules []string
+	var skipped []module_manager.ModuleSkip
+	if ModuleManager != nil {
+		enabledModules = ModuleManager.GetModuleNamesInOrder()
+		skipped = ModuleManager.SkippedModules()
+	}
+	var health []ModuleHealthStatus
+	if ModulesHealth != nil {
+		health = ModulesHealth.Dump()
+	}
+	var cycles []ConvergeCycle
+	if ConvergeCycles != nil {
+		cycles = ConvergeCycles.Dump()
+	}
+
+	status := BuildDashboardStatus(enabledModules, health, cycles, queues, skipped, time.Now())
+	if AddonsHealth != nil {
+		status.AddonsHealth = AddonsHealth.Condition()
+	}
+	return status
+}
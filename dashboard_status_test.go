@@ -0,0 +1,62 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/flant/antiopa/module_manager"
+	"github.com/flant/antiopa/task"
+)
+
+func TestBuildDashboardStatus(t *testing.T) {
+	now := time.Now()
+	startedAt := now.Add(-time.Minute)
+
+	queue := task.NewTasksQueue()
+	head := task.NewTask(task.ModuleRun, "dex")
+	head.IncrementFailureCount()
+	queue.Add(head)
+	queue.Add(task.NewTask(task.ModuleRun, "nginx"))
+
+	cycles := []ConvergeCycle{
+		{Id: 2, Cause: "schedule", StartedAt: startedAt, Modules: []ConvergeModuleRecord{
+			{Module: "dex", StartedAt: startedAt, Result: "failed", Error: "helm upgrade failed"},
+		}},
+		{Id: 1, Cause: "startup", StartedAt: startedAt.Add(-time.Hour), Modules: []ConvergeModuleRecord{
+			{Module: "nginx", StartedAt: startedAt.Add(-time.Hour), Result: "success"},
+			{Module: "dex", StartedAt: startedAt.Add(-time.Hour), Result: "success"},
+		}},
+	}
+	health := []ModuleHealthStatus{
+		{Module: "dex", Condition: ModuleHealthFailed, ConsecutiveFailures: 3},
+	}
+	skipped := []module_manager.ModuleSkip{{Module: "ceph", Condition: module_manager.ModuleSkipInsufficientClusterSize}}
+
+	status := BuildDashboardStatus([]string{"nginx", "dex", "cert-manager"}, health, cycles,
+		[]DashboardQueueState{{Name: MainQueueName, Queue: queue}}, skipped, now)
+
+	assert.Equal(t, DashboardStatusVersion, status.Version)
+	assert.Equal(t, DashboardSummary{
+		EnabledModules: 3,
+		HealthyModules: 2,
+		FailedModules:  1,
+		SkippedModules: 1,
+		QueuedTasks:    2,
+	}, status.Summary)
+
+	assert.Equal(t, []DashboardQueue{{Name: MainQueueName, Length: 2, HeadTask: "TASK_MODULE_RUN dex", HeadTaskFailures: 1}}, status.Queues)
+
+	if assert.NotNil(t, status.Converge) {
+		assert.Equal(t, 2, status.Converge.Id)
+		assert.Equal(t, 1, status.Converge.Failed)
+		// cycle is in progress
+		assert.Equal(t, 60.0, status.Converge.Duration)
+	}
+
+	assert.Equal(t, "success", status.Modules[0].LastRunResult)
+	assert.Equal(t, "helm upgrade failed", status.Modules[1].LastRunError)
+	assert.Equal(t, ModuleHealthFailed, status.Modules[1].Condition)
+	assert.Nil(t, status.Modules[2].LastRunAt)
+}
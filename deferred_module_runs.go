@@ -0,0 +1,110 @@
+package main
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/romana/rlog"
+
+	"github.com/flant/antiopa/task"
+)
+
+// Period of checking maintenance windows of modules with deferred runs
+var DeferredModuleRunsCheckPeriod = time.Minute
+
+// DeferredModuleRun is a ModuleRun task waiting for the module maintenance window
+type DeferredModuleRun struct {
+	Module     string    `json:"module"`
+	Cause      string    `json:"cause"`
+	DeferredAt time.Time `json:"deferredAt"`
+	// start of the nearest maintenance window
+	NextWindow time.Time `json:"nextWindow"`
+}
+
+// DeferredModuleRuns keeps ModuleRun tasks that are triggered by non-urgent changes
+// outside of module maintenance windows. One run per module is kept.
+type DeferredModuleRuns struct {
+	m    sync.Mutex
+	runs map[string]*DeferredModuleRun
+}
+
+func NewDeferredModuleRuns() *DeferredModuleRuns {
+	return &DeferredModuleRuns{
+		runs: make(map[string]*DeferredModuleRun),
+	}
+}
+
+// Defer saves a ModuleRun task until the next maintenance window
+func (d *DeferredModuleRuns) Defer(t task.Task, nextWindow time.Time) {
+	d.m.Lock()
+	defer d.m.Unlock()
+
+	if _, hasRun := d.runs[t.GetName()]; hasRun {
+		return
+	}
+	d.runs[t.GetName()] = &DeferredModuleRun{
+		Module:     t.GetName(),
+		Cause:      t.GetCause(),
+		DeferredAt: time.Now(),
+		NextWindow: nextWindow,
+	}
+}
+
+// Forget removes deferred run of the module, e.g. after successful urgent run
+func (d *DeferredModuleRuns) Forget(moduleName string) {
+	d.m.Lock()
+	defer d.m.Unlock()
+
+	delete(d.runs, moduleName)
+}
+
+// Dump returns deferred runs sorted by module name
+func (d *DeferredModuleRuns) Dump() []DeferredModuleRun {
+	d.m.Lock()
+	defer d.m.Unlock()
+
+	res := make([]DeferredModuleRun, 0, len(d.runs))
+	for _, run := range d.runs {
+		res = append(res, *run)
+	}
+	sort.Slice(res, func(i, j int) bool {
+		return res[i].Module < res[j].Module
+	})
+	return res
+}
+
+// Run periodically queues deferred runs of modules with open maintenance windows
+func (d *DeferredModuleRuns) Run() {
+	ticker := time.NewTicker(DeferredModuleRunsCheckPeriod)
+	for range ticker.C {
+		d.queueOpened(time.Now())
+	}
+}
+
+func (d *DeferredModuleRuns) queueOpened(now time.Time) {
+	d.m.Lock()
+	defer d.m.Unlock()
+
+	MetricsStorage.SendGaugeMetric("antiopa_deferred_module_runs", float64(len(d.runs)), map[string]string{})
+
+	for moduleName, run := range d.runs {
+		module, err := ModuleManager.GetModule(moduleName)
+		if err != nil {
+			// module is gone, nothing to run
+			delete(d.runs, moduleName)
+			continue
+		}
+		if !module.IsInMaintenanceWindow(now) {
+			continue
+		}
+
+		delete(d.runs, moduleName)
+		// window is open now, so the task should not be deferred again
+		newTask := task.NewTask(task.ModuleRun, moduleName).
+			WithCause(run.Cause + ", deferred until maintenance window").
+			WithUrgent(true)
+		TasksQueue.Add(newTask)
+		rlog.Infof("QUEUE add ModuleRun %s: maintenance window is open", moduleName)
+	}
+}
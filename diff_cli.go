@@ -0,0 +1,71 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/flant/antiopa/helm"
+	"github.com/flant/antiopa/module_manager"
+)
+
+// RunDiffCommand handles `antiopa diff [-summary] [module...]`: charts of modules are rendered by the running
+// antiopa with current values and compared with manifests of deployed releases. Nothing is changed in
+// the cluster, so the effect of a new antiopa image or config values can be previewed before the upgrade.
+func RunDiffCommand(args []string) error {
+	flags := flag.NewFlagSet("diff", flag.ContinueOnError)
+	summary := flags.Bool("summary", false, "only print changed objects without diffs")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	client := &http.Client{Timeout: 10 * time.Minute}
+	query := url.Values{"name": flags.Args()}
+
+	resp, err := client.Get(ApiAddress + "/modules/diff?" + query.Encode())
+	if err != nil {
+		return fmt.Errorf("cannot get modules diff: %s", err)
+	}
+	defer resp.Body.Close()
+	body, _ := ioutil.ReadAll(resp.Body)
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("cannot get modules diff: %s: %s", resp.Status, string(body))
+	}
+
+	diffs := make([]module_manager.ModuleDiff, 0)
+	if err := json.Unmarshal(body, &diffs); err != nil {
+		return fmt.Errorf("bad response: %s", err)
+	}
+	for _, diff := range diffs {
+		printModuleDiff(diff, *summary)
+	}
+	return nil
+}
+
+var resourceDiffMarks = map[string]string{
+	helm.ResourceAdded:   "+",
+	helm.ResourceRemoved: "-",
+	helm.ResourceChanged: "~",
+}
+
+func printModuleDiff(diff module_manager.ModuleDiff, summary bool) {
+	if diff.Release == "" {
+		fmt.Printf("%s: %s\n", diff.Module, diff.Action)
+	} else {
+		fmt.Printf("%s: %s release '%s'\n", diff.Module, diff.Action, diff.Release)
+	}
+	for _, resource := range diff.Resources {
+		fmt.Printf("  %s %s\n", resourceDiffMarks[resource.Change], resource.Resource)
+		if summary || resource.Diff == "" {
+			continue
+		}
+		for _, line := range strings.Split(strings.TrimSuffix(resource.Diff, "\n"), "\n") {
+			fmt.Printf("      %s\n", line)
+		}
+	}
+}
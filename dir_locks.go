@@ -0,0 +1,61 @@
+package main
+
+import (
+	"os"
+	"path/filepath"
+	"syscall"
+	"time"
+
+	"github.com/romana/rlog"
+
+	"github.com/flant/antiopa/utils"
+)
+
+// DirLockFileName is a lock file in locked directories
+const DirLockFileName = ".antiopa.lock"
+
+// DirLocks enables advisory locks of TempDirBase and the modules directory, so two antiopa
+// processes with shared directories (e.g. an old and a new pod of a botched rollout on the
+// same node with hostPath volumes) cannot both clean up temporary sessions and run helm
+// for the same releases. Locks complement leader election, which does not protect from
+// a process that still runs after its lease has expired.
+var DirLocks = true
+
+// DirLocksTimeout is a time to wait for the lock of a stopping process
+var DirLocksTimeout = 30 * time.Second
+
+// locks are held until the process exits
+var dirLocks = make([]*utils.FileLock, 0)
+
+// LockDirs locks directories, waiting up to DirLocksTimeout for each lock.
+// Directories where the lock file cannot be created, e.g. a read-only modules
+// directory from the image, are not locked: files there are not changed anyway.
+func LockDirs(dirs ...string) error {
+	if !DirLocks {
+		return nil
+	}
+
+	for _, dir := range dirs {
+		path := filepath.Join(dir, DirLockFileName)
+		lock, err := utils.LockFile(path, DirLocksTimeout, time.Second)
+		if err != nil && isReadOnlyLockError(err) {
+			rlog.Warnf("MAIN directory '%s' is not locked: %s", dir, err)
+			continue
+		}
+		if err != nil {
+			return err
+		}
+		rlog.Infof("MAIN directory '%s' is locked", dir)
+		dirLocks = append(dirLocks, lock)
+	}
+
+	return nil
+}
+
+func isReadOnlyLockError(err error) bool {
+	pathErr, ok := err.(*os.PathError)
+	if !ok {
+		return false
+	}
+	return os.IsPermission(err) || os.IsNotExist(err) || pathErr.Err == syscall.EROFS
+}
@@ -0,0 +1,127 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/flant/antiopa/helm"
+	"github.com/flant/antiopa/http_poller"
+	"github.com/flant/antiopa/kube"
+	"github.com/flant/antiopa/module_manager"
+)
+
+// DoctorCheck is one line of `antiopa doctor` report
+type DoctorCheck struct {
+	Name  string
+	Error error
+	// what to do if check is failed
+	Hint string
+}
+
+// RunDoctorCommand handles `antiopa doctor`: RBAC permissions, tiller and the working dir
+// are checked and a report is printed. Nothing is changed in the cluster.
+func RunDoctorCommand(args []string) error {
+	flags := flag.NewFlagSet("doctor", flag.ContinueOnError)
+	workingDir := flags.String("working-dir", "", "directory with modules and global hooks, current dir is used if empty")
+	skipCluster := flags.Bool("skip-cluster", false, "check only the working dir, do not connect to the cluster")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	if *workingDir == "" {
+		dir, err := os.Getwd()
+		if err != nil {
+			return fmt.Errorf("cannot determine working dir: %s", err)
+		}
+		*workingDir = dir
+	}
+
+	checks := make([]DoctorCheck, 0)
+	if !*skipCluster {
+		checks = append(checks, doctorClusterChecks()...)
+	}
+	checks = append(checks, doctorWorkingDirChecks(*workingDir)...)
+
+	failed := 0
+	for _, check := range checks {
+		if check.Error == nil {
+			fmt.Printf("OK   %s\n", check.Name)
+			continue
+		}
+		failed++
+		fmt.Printf("FAIL %s: %s\n", check.Name, check.Error)
+		if check.Hint != "" {
+			fmt.Printf("     hint: %s\n", check.Hint)
+		}
+	}
+
+	if failed > 0 {
+		return fmt.Errorf("%d of %d checks failed", failed, len(checks))
+	}
+	return nil
+}
+
+func doctorClusterChecks() []DoctorCheck {
+	kube.InitKube()
+	namespace := kube.KubernetesAntiopaNamespace
+
+	checks := make([]DoctorCheck, 0)
+
+	for _, perm := range kube.MergePermissions(rbacRequiredPermissions(namespace)) {
+		check := DoctorCheck{
+			Name: fmt.Sprintf("permission to %s", perm),
+			Hint: fmt.Sprintf("add '%s' verb for '%s' resource to the Role or ClusterRole bound to antiopa ServiceAccount", perm.Verb, perm.Resource),
+		}
+		allowed, reason, err := kube.CanI(perm.Verb, perm.Group, perm.Resource, perm.Namespace)
+		if err != nil {
+			check.Error = err
+		} else if !allowed {
+			check.Error = fmt.Errorf("access denied %s", reason)
+		}
+		checks = append(checks, check)
+	}
+
+	tillerCheck := DoctorCheck{
+		Name: fmt.Sprintf("tiller in namespace '%s' is reachable", namespace),
+		Hint: "check tiller-deploy Deployment and its pods, antiopa installs tiller on start if it is absent",
+	}
+	if EmbeddedTiller {
+		tillerCheck.Name = "tiller is embedded, no tiller Deployment is needed"
+	} else {
+		_, tillerCheck.Error = helm.CheckTiller(namespace)
+	}
+	checks = append(checks, tillerCheck)
+
+	return checks
+}
+
+func doctorWorkingDirChecks(workingDir string) []DoctorCheck {
+	checks := make([]DoctorCheck, 0)
+
+	// hooks and modules may write files into temp dir while loading
+	tempDir, err := ioutil.TempDir("", "antiopa-doctor")
+	if err != nil {
+		return append(checks, DoctorCheck{Name: "temporary dir", Error: err})
+	}
+	defer os.RemoveAll(tempDir)
+
+	for _, res := range module_manager.ValidateWorkingDir(workingDir, tempDir) {
+		checks = append(checks, DoctorCheck{
+			Name:  res.Name,
+			Error: res.Error,
+			Hint:  fmt.Sprintf("fix files in '%s' and run doctor again", workingDir),
+		})
+	}
+
+	_, err = http_poller.LoadPollers(filepath.Join(workingDir, http_poller.PollersFileName))
+	checks = append(checks, DoctorCheck{
+		Name:  "http pollers",
+		Error: err,
+		Hint:  fmt.Sprintf("fix %s in '%s' and run doctor again", http_poller.PollersFileName, workingDir),
+	})
+
+	return checks
+}
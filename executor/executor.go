@@ -1,18 +1,40 @@
 package executor
 
 import (
+	"bytes"
+	"errors"
 	"os/exec"
 	"strings"
 	"sync"
+	"syscall"
+	"time"
 
 	"github.com/romana/rlog"
 )
 
-var ExecutorLock = &sync.Mutex{}
+// Commands are run concurrently with read lock, zombie reaper waits for all commands with write lock
+var ExecutorLock = &sync.RWMutex{}
+
+// TaskIdEnv in environment of the command tags it with the correlation id of antiopa task,
+// commands of the task are killed by KillTask when the task is cancelled.
+const TaskIdEnv = "TASK_ID"
+
+// commands in progress with their task ids, they are killed with process groups on task cancellation
+var (
+	runningCmdsLock = &sync.Mutex{}
+	runningCmds     = make(map[*exec.Cmd]string)
+)
 
 func Run(cmd *exec.Cmd, debug bool) error {
-	ExecutorLock.Lock()
-	defer ExecutorLock.Unlock()
+	return run(cmd, debug, 0)
+}
+
+// run waits for a free slot, runs the command and kills it after timeout if it is positive
+func run(cmd *exec.Cmd, debug bool, timeout time.Duration) error {
+	defer waitThrottle()()
+
+	ExecutorLock.RLock()
+	defer ExecutorLock.RUnlock()
 
 	if debug {
 		dir := ""
@@ -22,13 +44,114 @@ func Run(cmd *exec.Cmd, debug bool) error {
 		rlog.Debugf("Executing command%s: '%s'", dir, strings.Join(cmd.Args, " "))
 	}
 
-	return cmd.Run()
+	return runTracked(cmd, timeout)
 }
 
 func Output(cmd *exec.Cmd) (output []byte, err error) {
-	ExecutorLock.Lock()
-	defer ExecutorLock.Unlock()
+	defer waitThrottle()()
+
+	ExecutorLock.RLock()
+	defer ExecutorLock.RUnlock()
+
+	if cmd.Stdout != nil {
+		return nil, errors.New("exec: Stdout already set")
+	}
+	var stdout bytes.Buffer
+	cmd.Stdout = &stdout
+
+	err = runTracked(cmd, 0)
+	return stdout.Bytes(), err
+}
+
+// runTracked starts the command in its own process group, so the command
+// can be killed with all its children by KillRunning. Timeout is counted
+// from the start of the command, time in the throttle queue is not counted.
+func runTracked(cmd *exec.Cmd, timeout time.Duration) error {
+	if cmd.SysProcAttr == nil {
+		cmd.SysProcAttr = &syscall.SysProcAttr{}
+	}
+	cmd.SysProcAttr.Setpgid = true
+
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+
+	runningCmdsLock.Lock()
+	runningCmds[cmd] = CommandTaskId(cmd)
+	runningCmdsLock.Unlock()
+
+	defer func() {
+		runningCmdsLock.Lock()
+		delete(runningCmds, cmd)
+		runningCmdsLock.Unlock()
+	}()
+
+	if timeout <= 0 {
+		return cmd.Wait()
+	}
+
+	// Wait can return before the timer function is finished, result of the kill is waited for
+	exceeded := make(chan bool, 1)
+	timer := time.AfterFunc(timeout, func() {
+		rlog.Errorf("Command '%s' is not finished in %s, kill it", strings.Join(cmd.Args, " "), timeout)
+		killed := KillRunning(func(c *exec.Cmd) bool {
+			return c == cmd
+		})
+		exceeded <- killed > 0
+	})
+	err := cmd.Wait()
+
+	if !timer.Stop() && <-exceeded {
+		return &ErrTimeout{Command: strings.Join(cmd.Args, " "), Timeout: timeout}
+	}
+	return err
+}
+
+// CommandTaskId returns the task id from environment of the command, the last value is used as by exec
+func CommandTaskId(cmd *exec.Cmd) string {
+	for i := len(cmd.Env) - 1; i >= 0; i-- {
+		if strings.HasPrefix(cmd.Env[i], TaskIdEnv+"=") {
+			return strings.TrimPrefix(cmd.Env[i], TaskIdEnv+"=")
+		}
+	}
+	return ""
+}
 
-	output, err = cmd.Output()
-	return
+// KillTask kills process groups of commands in progress that are tagged with the task id.
+// Commands are matched by the tag, so they are found when run through the command wrapper too.
+func KillTask(taskId string) int {
+	if taskId == "" {
+		return 0
+	}
+	return killRunning(func(_ *exec.Cmd, cmdTaskId string) bool {
+		return cmdTaskId == taskId
+	})
+}
+
+// KillRunning kills process groups of commands in progress that match the predicate.
+// Number of killed commands is returned.
+func KillRunning(match func(cmd *exec.Cmd) bool) int {
+	return killRunning(func(cmd *exec.Cmd, _ string) bool {
+		return match(cmd)
+	})
+}
+
+func killRunning(match func(cmd *exec.Cmd, taskId string) bool) int {
+	runningCmdsLock.Lock()
+	defer runningCmdsLock.Unlock()
+
+	killed := 0
+	for cmd, taskId := range runningCmds {
+		if cmd.Process == nil || !match(cmd, taskId) {
+			continue
+		}
+		pid := cmd.Process.Pid
+		if err := syscall.Kill(-pid, syscall.SIGKILL); err != nil {
+			rlog.Errorf("Cannot kill process group of '%s' (pid %d): %s", strings.Join(cmd.Args, " "), pid, err)
+			continue
+		}
+		rlog.Infof("Killed process group of '%s' (pid %d)", strings.Join(cmd.Args, " "), pid)
+		killed++
+	}
+	return killed
 }
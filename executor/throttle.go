@@ -0,0 +1,36 @@
+package executor
+
+import (
+	"sync/atomic"
+)
+
+// throttled executor runs one command at a time to reduce memory and CPU
+// usage of antiopa container, commands in progress are not affected
+var (
+	throttled    int32
+	throttleSlot = make(chan struct{}, 1)
+)
+
+// SetThrottled enables or disables limit of one command at a time
+func SetThrottled(value bool) {
+	var v int32
+	if value {
+		v = 1
+	}
+	atomic.StoreInt32(&throttled, v)
+}
+
+func IsThrottled() bool {
+	return atomic.LoadInt32(&throttled) == 1
+}
+
+// waitThrottle blocks until the command can be run, returned func should be called after the command
+func waitThrottle() func() {
+	if !IsThrottled() {
+		return func() {}
+	}
+	throttleSlot <- struct{}{}
+	return func() {
+		<-throttleSlot
+	}
+}
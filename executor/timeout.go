@@ -0,0 +1,30 @@
+package executor
+
+import (
+	"fmt"
+	"os/exec"
+	"time"
+)
+
+// ErrTimeout is returned if the command is killed because it is not finished in time
+type ErrTimeout struct {
+	Command string
+	Timeout time.Duration
+}
+
+func (e *ErrTimeout) Error() string {
+	return fmt.Sprintf("'%s' is killed after timeout %s", e.Command, e.Timeout)
+}
+
+// IsTimeout returns true if err is ErrTimeout
+func IsTimeout(err error) bool {
+	_, ok := err.(*ErrTimeout)
+	return ok
+}
+
+// RunWithTimeout runs the command as Run does and kills its process group if the command
+// is not finished in timeout, e.g. helm upgrade --wait that hangs on a broken readiness probe.
+// Timeout is counted from the start of the process. Command is not limited if timeout is 0.
+func RunWithTimeout(cmd *exec.Cmd, debug bool, timeout time.Duration) error {
+	return run(cmd, debug, timeout)
+}
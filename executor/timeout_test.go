@@ -0,0 +1,85 @@
+package executor
+
+import (
+	"os/exec"
+	"testing"
+	"time"
+)
+
+func TestRunWithTimeout(t *testing.T) {
+	startedAt := time.Now()
+	err := RunWithTimeout(exec.Command("/bin/sh", "-c", "sleep 10 & wait"), false, 200*time.Millisecond)
+	if !IsTimeout(err) {
+		t.Fatalf("expected timeout error, got %v", err)
+	}
+	if time.Since(startedAt) > 5*time.Second {
+		t.Errorf("command with children is not killed in time: %s", time.Since(startedAt))
+	}
+
+	if err := RunWithTimeout(exec.Command("/bin/sh", "-c", "exit 0"), false, time.Second); err != nil {
+		t.Errorf("expected no error, got %s", err)
+	}
+}
+
+func TestKillTask(t *testing.T) {
+	cmd := exec.Command("/bin/sh", "-c", "sleep 10 & wait")
+	cmd.Env = []string{TaskIdEnv + "=main-1"}
+	other := exec.Command("/bin/sh", "-c", "sleep 1")
+	other.Env = []string{TaskIdEnv + "=main-2"}
+
+	done := make(chan error, 1)
+	otherDone := make(chan error, 1)
+	go func() { done <- Run(cmd, false) }()
+	go func() { otherDone <- Run(other, false) }()
+	// the zombie reaper started by other tests can hold Run of the other task until main-1 is finished
+	waitForTaskCommand(t, "main-1")
+
+	startedAt := time.Now()
+	if killed := KillTask("main-1"); killed != 1 {
+		t.Fatalf("expected 1 killed command, got %d", killed)
+	}
+	<-done
+	if time.Since(startedAt) > 900*time.Millisecond {
+		t.Errorf("command of the task is not killed in time: %s", time.Since(startedAt))
+	}
+	if err := <-otherDone; err != nil {
+		t.Errorf("command of other task should not be killed, got %s", err)
+	}
+}
+
+func waitForTaskCommand(t *testing.T, taskId string) {
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		runningCmdsLock.Lock()
+		running := false
+		for _, id := range runningCmds {
+			running = running || id == taskId
+		}
+		runningCmdsLock.Unlock()
+
+		if running {
+			return
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("command of task '%s' is not started", taskId)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestRunWithTimeout_Throttled(t *testing.T) {
+	SetThrottled(true)
+	defer SetThrottled(false)
+
+	done := make(chan error, 1)
+	go func() { done <- Run(exec.Command("/bin/sh", "-c", "sleep 0.5"), false) }()
+	time.Sleep(100 * time.Millisecond)
+
+	// time in the throttle queue is not counted
+	if err := RunWithTimeout(exec.Command("/bin/sh", "-c", "sleep 0.1"), false, 300*time.Millisecond); err != nil {
+		t.Errorf("expected no error, got %s", err)
+	}
+	if err := <-done; err != nil {
+		t.Errorf("expected no error, got %s", err)
+	}
+}
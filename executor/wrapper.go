@@ -0,0 +1,76 @@
+package executor
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"github.com/romana/rlog"
+)
+
+// CommandWrapper is a command with arguments that runs helm and kubectl, e.g. 'tsh' or an auditing
+// shim in environments where all cluster access passes an audited gateway. Path of the wrapped
+// binary and its arguments are appended to arguments of the wrapper. Empty disables wrapping.
+var CommandWrapper string
+
+// WrappedCommands are names of binaries that are run through CommandWrapper
+var WrappedCommands = []string{"helm", "kubectl"}
+
+// WrapperShimsDir has shims of WrappedCommands that run them through CommandWrapper.
+// It is prepended to PATH of hooks, so kubectl and helm in hooks pass the wrapper too.
+var WrapperShimsDir string
+
+// WrapCommand returns the command for the binary, wrapped binaries are run through CommandWrapper
+func WrapCommand(binPath string, args ...string) *exec.Cmd {
+	wrapper := strings.Fields(CommandWrapper)
+	if len(wrapper) == 0 || !isWrappedCommand(binPath) {
+		return exec.Command(binPath, args...)
+	}
+	wrapperArgs := make([]string, 0, len(wrapper)+len(args))
+	wrapperArgs = append(wrapperArgs, wrapper[1:]...)
+	wrapperArgs = append(wrapperArgs, binPath)
+	wrapperArgs = append(wrapperArgs, args...)
+	return exec.Command(wrapper[0], wrapperArgs...)
+}
+
+func isWrappedCommand(binPath string) bool {
+	name := filepath.Base(binPath)
+	for _, wrapped := range WrappedCommands {
+		if wrapped == name {
+			return true
+		}
+	}
+	return false
+}
+
+// WriteWrapperShims writes shims of WrappedCommands into dir and sets WrapperShimsDir.
+// Binaries are found in PATH of antiopa, commands that are not found are skipped.
+func WriteWrapperShims(dir string) error {
+	wrapper := strings.Fields(CommandWrapper)
+	if len(wrapper) == 0 {
+		return nil
+	}
+	for i := range wrapper {
+		wrapper[i] = shellQuote(wrapper[i])
+	}
+
+	for _, name := range WrappedCommands {
+		binPath, err := exec.LookPath(name)
+		if err != nil {
+			rlog.Warnf("Command wrapper: '%s' is not found, hooks run it without wrapper: %s", name, err)
+			continue
+		}
+		script := fmt.Sprintf("#!/bin/sh\nexec %s %s \"$@\"\n", strings.Join(wrapper, " "), shellQuote(binPath))
+		if err := ioutil.WriteFile(filepath.Join(dir, name), []byte(script), 0755); err != nil {
+			return fmt.Errorf("cannot write shim of '%s': %s", name, err)
+		}
+	}
+	WrapperShimsDir = dir
+	return nil
+}
+
+func shellQuote(s string) string {
+	return "'" + strings.Replace(s, "'", `'\''`, -1) + "'"
+}
@@ -0,0 +1,53 @@
+package executor
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestWrapCommand(t *testing.T) {
+	defer func() { CommandWrapper = "" }()
+
+	cmd := WrapCommand("/usr/local/bin/helm", "list")
+	assert.Equal(t, []string{"/usr/local/bin/helm", "list"}, cmd.Args)
+
+	CommandWrapper = "tsh kube exec --"
+	cmd = WrapCommand("/usr/local/bin/helm", "list", "--output", "json")
+	assert.Equal(t, []string{"tsh", "kube", "exec", "--", "/usr/local/bin/helm", "list", "--output", "json"}, cmd.Args)
+
+	cmd = WrapCommand("/usr/bin/jq", ".")
+	assert.Equal(t, []string{"/usr/bin/jq", "."}, cmd.Args)
+}
+
+func TestWriteWrapperShims(t *testing.T) {
+	defer func(commands []string) {
+		CommandWrapper = ""
+		WrappedCommands = commands
+		WrapperShimsDir = ""
+	}(WrappedCommands)
+
+	dir, err := ioutil.TempDir("", "wrapper-shims")
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer os.RemoveAll(dir)
+
+	CommandWrapper = "audit-shim --user 'antiopa'"
+	WrappedCommands = []string{"sh", "absent-command"}
+	if !assert.NoError(t, WriteWrapperShims(dir)) {
+		return
+	}
+	assert.Equal(t, dir, WrapperShimsDir)
+
+	script, err := ioutil.ReadFile(filepath.Join(dir, "sh"))
+	if assert.NoError(t, err) {
+		assert.Contains(t, string(script), `exec 'audit-shim' '--user' ''\''antiopa'\''' `)
+		assert.Contains(t, string(script), `"$@"`)
+	}
+	_, err = os.Stat(filepath.Join(dir, "absent-command"))
+	assert.True(t, os.IsNotExist(err))
+}
@@ -0,0 +1,174 @@
+package failure_injection
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/romana/rlog"
+)
+
+// Failures are injected with the debug API only if Enabled is true. It is for staging clusters,
+// where platform teams validate alerts and retries of antiopa, and should be off in production.
+var Enabled bool
+
+// Kind of injected failure
+type Kind string
+
+const (
+	// helm command or tiller call fails, target is a helm command (upgrade, delete, ...) or a release name
+	HelmFailure Kind = "helm"
+	// hook is not run and fails as if its deadline is exceeded, target is a hook name
+	HookTimeout Kind = "hook-timeout"
+	// open watches of informers are closed at once as if apiserver is disconnected
+	WatchDisconnect Kind = "watch-disconnect"
+)
+
+var Kinds = []Kind{HelmFailure, HookTimeout, WatchDisconnect}
+
+// Injection is a pending failure, it is taken by the next Count matching operations
+type Injection struct {
+	Kind Kind `json:"kind"`
+	// empty target matches all operations of the kind
+	Target     string    `json:"target,omitempty"`
+	Count      int       `json:"count"`
+	InjectedAt time.Time `json:"injectedAt"`
+}
+
+// ErrInjected is returned by operations that take an injection
+type ErrInjected struct {
+	Kind   Kind
+	Target string
+}
+
+func (e *ErrInjected) Error() string {
+	if e.Target == "" {
+		return fmt.Sprintf("injected failure '%s'", e.Kind)
+	}
+	return fmt.Sprintf("injected failure '%s' of '%s'", e.Kind, e.Target)
+}
+
+func IsInjected(err error) bool {
+	_, ok := err.(*ErrInjected)
+	return ok
+}
+
+var (
+	m          sync.Mutex
+	injections = make([]*Injection, 0)
+	// disconnects open watches, it is set by kube package to not import it here
+	disconnectWatches func() int
+)
+
+// SetWatchDisconnector sets the function that closes open watches and returns their count
+func SetWatchDisconnector(disconnect func() int) {
+	m.Lock()
+	defer m.Unlock()
+	disconnectWatches = disconnect
+}
+
+func CheckKind(kind Kind) error {
+	for _, k := range Kinds {
+		if k == kind {
+			return nil
+		}
+	}
+	return fmt.Errorf("unknown failure kind '%s', expected one of %v", kind, Kinds)
+}
+
+// Inject adds the failure for the next count operations. Watches are disconnected at once,
+// the number of closed watches is returned as the count of the injection.
+func Inject(kind Kind, target string, count int) (*Injection, error) {
+	if !Enabled {
+		return nil, fmt.Errorf("failure injection is disabled")
+	}
+	if err := CheckKind(kind); err != nil {
+		return nil, err
+	}
+	if count < 1 {
+		return nil, fmt.Errorf("count should be positive, got %d", count)
+	}
+
+	m.Lock()
+	defer m.Unlock()
+
+	injection := &Injection{Kind: kind, Target: target, Count: count, InjectedAt: time.Now()}
+	if kind == WatchDisconnect {
+		if disconnectWatches == nil {
+			return nil, fmt.Errorf("watches are not started")
+		}
+		injection.Count = disconnectWatches()
+		rlog.Warnf("FAILURE_INJECTION %d watches are disconnected", injection.Count)
+		return injection, nil
+	}
+
+	injections = append(injections, injection)
+	rlog.Warnf("FAILURE_INJECTION failure '%s' is injected for %d operations of '%s'", kind, count, targetString(target))
+	return injection, nil
+}
+
+// Take consumes the injection that matches the kind and one of targets of the operation.
+// Error is returned if the operation should fail.
+func Take(kind Kind, targets ...string) error {
+	if !Enabled {
+		return nil
+	}
+
+	m.Lock()
+	defer m.Unlock()
+
+	for i, injection := range injections {
+		if injection.Kind != kind || !matchTarget(injection.Target, targets) {
+			continue
+		}
+		injection.Count--
+		if injection.Count == 0 {
+			injections = append(injections[:i], injections[i+1:]...)
+		}
+		rlog.Warnf("FAILURE_INJECTION failure '%s' of '%s' is taken by %v", kind, targetString(injection.Target), targets)
+		return &ErrInjected{Kind: kind, Target: injection.Target}
+	}
+	return nil
+}
+
+// List returns copies of pending injections
+func List() []Injection {
+	m.Lock()
+	defer m.Unlock()
+
+	res := make([]Injection, 0, len(injections))
+	for _, injection := range injections {
+		res = append(res, *injection)
+	}
+	return res
+}
+
+// Clear removes pending injections
+func Clear() {
+	m.Lock()
+	defer m.Unlock()
+
+	if len(injections) > 0 {
+		rlog.Warnf("FAILURE_INJECTION %d pending injections are cleared", len(injections))
+	}
+	injections = make([]*Injection, 0)
+}
+
+func matchTarget(target string, targets []string) bool {
+	if target == "" {
+		return true
+	}
+	for _, t := range targets {
+		if t == target {
+			return true
+		}
+	}
+	return false
+}
+
+func targetString(target string) string {
+	if target == "" {
+		return "*"
+	}
+	return target
+}
@@ -0,0 +1,57 @@
+package failure_injection
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestTake(t *testing.T) {
+	Enabled = true
+	defer func() { Enabled = false; Clear() }()
+
+	_, err := Inject(HelmFailure, "upgrade", 2)
+	assert.NoError(t, err)
+	_, err = Inject(HookTimeout, "", 1)
+	assert.NoError(t, err)
+	assert.Len(t, List(), 2)
+
+	assert.NoError(t, Take(HelmFailure, "delete", "release-alpha"))
+	assert.True(t, IsInjected(Take(HelmFailure, "upgrade", "release-alpha")))
+	assert.True(t, IsInjected(Take(HelmFailure, "upgrade", "release-beta")))
+	assert.NoError(t, Take(HelmFailure, "upgrade", "release-alpha"))
+
+	// empty target matches any hook
+	assert.EqualError(t, Take(HookTimeout, "alpha/hooks/a"), "injected failure 'hook-timeout'")
+	assert.NoError(t, Take(HookTimeout, "alpha/hooks/a"))
+	assert.Len(t, List(), 0)
+}
+
+func TestInject_WatchDisconnect(t *testing.T) {
+	Enabled = true
+	defer func() { Enabled = false; SetWatchDisconnector(nil) }()
+
+	_, err := Inject(WatchDisconnect, "", 1)
+	assert.Error(t, err)
+
+	SetWatchDisconnector(func() int { return 3 })
+	injection, err := Inject(WatchDisconnect, "", 1)
+	if assert.NoError(t, err) {
+		assert.Equal(t, 3, injection.Count)
+	}
+	// disconnect is not pending
+	assert.Len(t, List(), 0)
+}
+
+func TestInject_Disabled(t *testing.T) {
+	_, err := Inject(HelmFailure, "", 1)
+	assert.Error(t, err)
+	assert.NoError(t, Take(HelmFailure, "upgrade"))
+
+	Enabled = true
+	defer func() { Enabled = false }()
+	_, err = Inject(Kind("oom"), "", 1)
+	assert.Error(t, err)
+	_, err = Inject(HelmFailure, "", 0)
+	assert.Error(t, err)
+}
@@ -0,0 +1,76 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/flant/antiopa/module_manager"
+)
+
+// Bindings of global hooks that can be run manually
+var manualGlobalHookBindings = []module_manager.BindingType{
+	module_manager.BeforeAll,
+	module_manager.AfterAll,
+	module_manager.OnStartup,
+	module_manager.Schedule,
+	module_manager.OnShutdown,
+}
+
+// globalHookBinding returns binding by its name in hook config, it should be one of the hook bindings
+func globalHookBinding(hook *module_manager.GlobalHook, name string) (module_manager.BindingType, error) {
+	names := make([]string, 0)
+	for _, binding := range manualGlobalHookBindings {
+		hasBinding := false
+		for _, hookBinding := range hook.Bindings {
+			hasBinding = hasBinding || hookBinding == binding
+		}
+		if !hasBinding {
+			continue
+		}
+		if module_manager.ContextBindingType[binding] == name {
+			return binding, nil
+		}
+		names = append(names, module_manager.ContextBindingType[binding])
+	}
+	sort.Strings(names)
+	return "", fmt.Errorf("global hook '%s' cannot be run with binding '%s', expected one of: %s", hook.Name, name, strings.Join(names, ", "))
+}
+
+// RunGlobalCommand handles `antiopa global hook run <name> --binding beforeAll`.
+// Hook is run by the running antiopa with live values, so the command is run with kubectl exec.
+func RunGlobalCommand(args []string) error {
+	const usage = "usage: antiopa global hook run <name> [--binding beforeAll]"
+	if len(args) < 3 || args[0] != "hook" || args[1] != "run" {
+		return fmt.Errorf(usage)
+	}
+	hookName := args[2]
+
+	flags := flag.NewFlagSet("global hook run", flag.ContinueOnError)
+	binding := flags.String("binding", module_manager.ContextBindingType[module_manager.BeforeAll], "binding of the hook run: beforeAll, afterAll, onStartup or schedule")
+	if err := flags.Parse(args[3:]); err != nil {
+		return err
+	}
+
+	client := &http.Client{Timeout: 60 * time.Second}
+	query := url.Values{}
+	query.Set("name", hookName)
+	query.Set("binding", *binding)
+
+	resp, err := client.Post(ApiAddress+"/global-hook/run?"+query.Encode(), "text/plain", nil)
+	if err != nil {
+		return fmt.Errorf("cannot run global hook: %s", err)
+	}
+	defer resp.Body.Close()
+	body, _ := ioutil.ReadAll(resp.Body)
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("cannot run global hook: %s: %s", resp.Status, string(body))
+	}
+	fmt.Print(string(body))
+	return nil
+}
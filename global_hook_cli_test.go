@@ -0,0 +1,25 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/flant/antiopa/module_manager"
+)
+
+func TestGlobalHookBinding(t *testing.T) {
+	hook := &module_manager.GlobalHook{Hook: &module_manager.Hook{
+		Name:     "global-hooks/discover",
+		Bindings: []module_manager.BindingType{module_manager.BeforeAll, module_manager.Schedule},
+	}}
+
+	binding, err := globalHookBinding(hook, "beforeAll")
+	assert.NoError(t, err)
+	assert.Equal(t, module_manager.BeforeAll, binding)
+
+	_, err = globalHookBinding(hook, "afterAll")
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), "expected one of: beforeAll, schedule")
+	}
+}
@@ -2,4 +2,12 @@
 
 set -e
 
-go install github.com/flant/antiopa
+VERSION=${VERSION:-$(git describe --tags --always --dirty 2>/dev/null || echo dev)}
+COMMIT=${COMMIT:-$(git rev-parse --short HEAD 2>/dev/null || echo unknown)}
+BUILD_DATE=${BUILD_DATE:-$(date -u +%Y-%m-%dT%H:%M:%SZ)}
+
+go install -ldflags "\
+  -X github.com/flant/antiopa/version.Version=${VERSION} \
+  -X github.com/flant/antiopa/version.Commit=${COMMIT} \
+  -X github.com/flant/antiopa/version.BuildDate=${BUILD_DATE}" \
+  github.com/flant/antiopa
@@ -0,0 +1,109 @@
+package main
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/romana/rlog"
+
+	"github.com/flant/antiopa/module_manager"
+	"github.com/flant/antiopa/task"
+)
+
+// HeldHookRun is a hook run waiting for modules from waitForModules of the hook
+type HeldHookRun struct {
+	Hook     string                     `json:"hook"`
+	Binding  module_manager.BindingType `json:"binding"`
+	Pending  []string                   `json:"pendingModules"`
+	HeldAt   time.Time                  `json:"heldAt"`
+	TaskType task.TaskType              `json:"-"`
+	// binding contexts of all held runs are passed to one hook run
+	BindingContext []module_manager.BindingContext `json:"-"`
+	AllowFailure   bool                            `json:"-"`
+	QueueName      string                          `json:"-"`
+}
+
+// HeldHookRunsStorage keeps GlobalHookRun and ModuleHookRun tasks of hooks that wait for modules.
+// Tasks are removed from the queue, so modules they wait for are not blocked.
+// One run per hook and binding is kept.
+type HeldHookRunsStorage struct {
+	m    sync.Mutex
+	runs map[string]*HeldHookRun
+}
+
+func NewHeldHookRuns() *HeldHookRunsStorage {
+	return &HeldHookRunsStorage{
+		runs: make(map[string]*HeldHookRun),
+	}
+}
+
+// Hold saves a hook run task until pending modules are converged
+func (h *HeldHookRunsStorage) Hold(t task.Task, pending []string) {
+	h.m.Lock()
+	defer h.m.Unlock()
+
+	key := t.GetName() + "@" + string(t.GetBinding())
+	if run, hasRun := h.runs[key]; hasRun {
+		// one held run is enough for schedule, events are accumulated
+		if t.GetBinding() != module_manager.Schedule {
+			run.BindingContext = append(run.BindingContext, t.GetBindingContext()...)
+		}
+		run.Pending = pending
+		return
+	}
+	h.runs[key] = &HeldHookRun{
+		Hook:           t.GetName(),
+		Binding:        t.GetBinding(),
+		Pending:        pending,
+		HeldAt:         time.Now(),
+		TaskType:       t.GetType(),
+		BindingContext: t.GetBindingContext(),
+		AllowFailure:   t.GetAllowFailure(),
+		QueueName:      t.GetQueueName(),
+	}
+}
+
+// Dump returns held runs sorted by hook name
+func (h *HeldHookRunsStorage) Dump() []HeldHookRun {
+	h.m.Lock()
+	defer h.m.Unlock()
+
+	res := make([]HeldHookRun, 0, len(h.runs))
+	for _, run := range h.runs {
+		res = append(res, *run)
+	}
+	sort.Slice(res, func(i, j int) bool {
+		if res[i].Hook == res[j].Hook {
+			return res[i].Binding < res[j].Binding
+		}
+		return res[i].Hook < res[j].Hook
+	})
+	return res
+}
+
+// QueueReady adds held runs of hooks without pending modules to their queues.
+// It is called after successful run of a module.
+func (h *HeldHookRunsStorage) QueueReady() {
+	h.m.Lock()
+	defer h.m.Unlock()
+
+	for key, run := range h.runs {
+		pending := ModuleManager.HookPendingModules(run.Hook)
+		if len(pending) > 0 {
+			run.Pending = pending
+			continue
+		}
+
+		delete(h.runs, key)
+		newTask := task.NewTask(run.TaskType, run.Hook).
+			WithBinding(run.Binding).
+			WithBindingContext(run.BindingContext).
+			WithAllowFailure(run.AllowFailure).
+			WithQueueName(run.QueueName)
+		AddHookTask(newTask)
+		rlog.Infof("QUEUE add %s@%s %s: modules are converged", run.TaskType, run.Binding, run.Hook)
+	}
+
+	MetricsStorage.SendGaugeMetric("antiopa_held_hook_runs", float64(len(h.runs)), map[string]string{})
+}
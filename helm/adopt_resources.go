@@ -0,0 +1,300 @@
+package helm
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-yaml/yaml"
+	"github.com/golang/protobuf/proto"
+	"github.com/golang/protobuf/ptypes"
+	"github.com/romana/rlog"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
+	kblabels "k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/types"
+	chartpb "k8s.io/helm/pkg/proto/hapi/chart"
+	rspb "k8s.io/helm/pkg/proto/hapi/release"
+
+	"github.com/flant/antiopa/kube"
+)
+
+// AdoptResourcesOptions describe the release that adopts existing objects
+type AdoptResourcesOptions struct {
+	Release string
+	// chart of the new release record if release does not exist
+	ChartName    string
+	ChartVersion string
+	// rendered manifest of the release, objects from it are searched in the cluster
+	Manifest string
+	DryRun   bool
+}
+
+const (
+	AdoptResultAdopted   = "adopted"
+	AdoptResultInRelease = "inRelease"
+	AdoptResultAbsent    = "absent"
+)
+
+// AdoptedResource is a result of adoption of one object from the manifest
+type AdoptedResource struct {
+	Resource string `json:"resource"`
+	// adopted, inRelease if object is already in the release manifest, absent if object is not in the cluster
+	Result string `json:"result"`
+}
+
+// Fields of live objects that are not saved into the release manifest
+var adoptDroppedMetadata = []string{"uid", "resourceVersion", "generation", "creationTimestamp", "selfLink", "managedFields"}
+
+// AdoptResources adds existing objects that are not managed by helm into the manifest of the last
+// deployed release revision, so the next helm upgrade patches them instead of failing with
+// 'already exists'. A new DEPLOYED revision is created if release does not exist. Live objects
+// are saved into the manifest, so upgrade brings them to the state from the chart. Objects also
+// get helm 3 ownership labels and annotations to be adopted after migration to helm 3.
+// Objects are searched in the cluster of antiopa, releases of remote clusters are not supported.
+func (helm *CliHelm) AdoptResources(options AdoptResourcesOptions) ([]AdoptedResource, error) {
+	if helm.cluster != nil {
+		return nil, fmt.Errorf("adoption is not supported for cluster '%s'", helm.cluster.Name)
+	}
+	if !options.DryRun {
+		defer helm.lockRelease(options.Release)()
+		if helm.operations != nil {
+			defer helm.beginReleaseOperation(options.Release)()
+		}
+	}
+
+	cm, release, err := lastDeployedRelease(helm.tillerNamespace, options.Release)
+	if err != nil {
+		return nil, err
+	}
+
+	inRelease := make(map[string]bool)
+	if release != nil {
+		resources, err := ParseReleaseManifest(release.Manifest, release.Namespace)
+		if err != nil {
+			return nil, fmt.Errorf("release '%s': %s", options.Release, err)
+		}
+		for _, resource := range resources {
+			inRelease[resource.String()] = true
+		}
+	}
+
+	results := make([]AdoptedResource, 0)
+	adoptedDocs := make([]string, 0)
+	for _, doc := range manifestDocumentSeparator.Split(options.Manifest, -1) {
+		resources, err := ParseReleaseManifest(doc, helm.tillerNamespace)
+		if err != nil {
+			return nil, err
+		}
+		// hooks are not stored in the release manifest
+		if len(resources) == 0 || resources[0].Hook != "" {
+			continue
+		}
+		resource := resources[0]
+
+		result := AdoptedResource{Resource: resource.String(), Result: AdoptResultInRelease}
+		if !inRelease[resource.String()] {
+			liveDoc, err := adoptLiveObject(resource, options.Release, helm.tillerNamespace, options.DryRun)
+			if err != nil {
+				return nil, fmt.Errorf("cannot adopt %s: %s", resource, err)
+			}
+			if liveDoc == "" {
+				result.Result = AdoptResultAbsent
+			} else {
+				result.Result = AdoptResultAdopted
+				adoptedDocs = append(adoptedDocs, fmt.Sprintf("# Source: adopted %s\n%s", resource, liveDoc))
+			}
+		}
+		results = append(results, result)
+	}
+
+	if len(adoptedDocs) == 0 || options.DryRun {
+		return results, nil
+	}
+
+	if release == nil {
+		return results, createAdoptedRelease(helm.tillerNamespace, options, strings.Join(adoptedDocs, "---\n"))
+	}
+
+	release.Manifest = strings.TrimRight(release.Manifest, "\n") + "\n---\n" + strings.Join(adoptedDocs, "---\n")
+	data, err := encodeV2Release(release)
+	if err != nil {
+		return nil, err
+	}
+	cm.Data["release"] = data
+	if _, err := kube.KubernetesClient.CoreV1().ConfigMaps(cm.Namespace).Update(cm); err != nil {
+		return nil, fmt.Errorf("cannot update release '%s': %s", cm.Name, err)
+	}
+	rlog.Infof("HELM release '%s': %d objects are adopted into revision %d", options.Release, len(adoptedDocs), release.Version)
+
+	return results, nil
+}
+
+// lastDeployedRelease returns ConfigMap and release of the last DEPLOYED revision or nils if there is no such revision
+func lastDeployedRelease(tillerNamespace string, releaseName string) (*v1.ConfigMap, *rspb.Release, error) {
+	cmList, err := kube.KubernetesClient.CoreV1().
+		ConfigMaps(tillerNamespace).
+		List(metav1.ListOptions{LabelSelector: kblabels.Set{"OWNER": "TILLER", "NAME": releaseName, "STATUS": "DEPLOYED"}.AsSelector().String()})
+	if err != nil {
+		return nil, nil, fmt.Errorf("cannot list revisions of release '%s': %s", releaseName, err)
+	}
+	if len(cmList.Items) == 0 {
+		return nil, nil, nil
+	}
+
+	sort.Slice(cmList.Items, func(i, j int) bool {
+		vi, _ := strconv.Atoi(cmList.Items[i].Labels["VERSION"])
+		vj, _ := strconv.Atoi(cmList.Items[j].Labels["VERSION"])
+		return vi > vj
+	})
+	cm := &cmList.Items[0]
+	release, err := decodeV2Release(cm.Data["release"])
+	if err != nil {
+		return nil, nil, fmt.Errorf("cannot decode ConfigMap '%s': %s", cm.Name, err)
+	}
+	return cm, release, nil
+}
+
+// adoptLiveObject returns yaml of the live object for the release manifest and sets helm 3
+// ownership metadata. Empty string is returned if object does not exist.
+func adoptLiveObject(resource ReleaseResource, releaseName string, releaseNamespace string, dryRun bool) (string, error) {
+	gvr, namespaced, err := kube.GroupVersionResource(resource.ApiVersion, resource.Kind)
+	if kube.IsKindNotRegistered(err) {
+		return "", nil
+	}
+	if err != nil {
+		return "", err
+	}
+
+	dynamicClient, err := kube.DynamicClient()
+	if err != nil {
+		return "", err
+	}
+	client := dynamicClient.Resource(gvr)
+	var obj *unstructured.Unstructured
+	if namespaced {
+		obj, err = client.Namespace(resource.Namespace).Get(resource.Name, metav1.GetOptions{})
+	} else {
+		obj, err = client.Get(resource.Name, metav1.GetOptions{})
+	}
+	if errors.IsNotFound(err) {
+		return "", nil
+	}
+	if err != nil {
+		return "", err
+	}
+
+	if !dryRun {
+		patch, _ := json.Marshal(map[string]interface{}{
+			"metadata": map[string]interface{}{
+				"labels": map[string]string{"app.kubernetes.io/managed-by": "Helm"},
+				"annotations": map[string]string{
+					"meta.helm.sh/release-name":      releaseName,
+					"meta.helm.sh/release-namespace": releaseNamespace,
+				},
+			},
+		})
+		if namespaced {
+			_, err = client.Namespace(resource.Namespace).Patch(resource.Name, types.MergePatchType, patch, metav1.PatchOptions{})
+		} else {
+			_, err = client.Patch(resource.Name, types.MergePatchType, patch, metav1.PatchOptions{})
+		}
+		if err != nil {
+			return "", fmt.Errorf("cannot set ownership metadata: %s", err)
+		}
+	}
+
+	data, err := yaml.Marshal(cleanLiveObject(obj.Object))
+	if err != nil {
+		return "", err
+	}
+	return string(data), nil
+}
+
+// cleanLiveObject removes status and server-side metadata from the live object
+func cleanLiveObject(obj map[string]interface{}) map[string]interface{} {
+	delete(obj, "status")
+	if metadata, ok := obj["metadata"].(map[string]interface{}); ok {
+		for _, field := range adoptDroppedMetadata {
+			delete(metadata, field)
+		}
+		if annotations, ok := metadata["annotations"].(map[string]interface{}); ok {
+			delete(annotations, "kubectl.kubernetes.io/last-applied-configuration")
+			if len(annotations) == 0 {
+				delete(metadata, "annotations")
+			}
+		}
+	}
+	return obj
+}
+
+// createAdoptedRelease creates the first DEPLOYED revision of the release with adopted objects
+func createAdoptedRelease(tillerNamespace string, options AdoptResourcesOptions, manifest string) error {
+	now := ptypes.TimestampNow()
+	release := &rspb.Release{
+		Name:      options.Release,
+		Namespace: tillerNamespace,
+		Version:   1,
+		Info: &rspb.Info{
+			Status:        &rspb.Status{Code: rspb.Status_DEPLOYED},
+			FirstDeployed: now,
+			LastDeployed:  now,
+			Description:   "Adopted by antiopa",
+		},
+		Chart: &chartpb.Chart{
+			Metadata: &chartpb.Metadata{Name: options.ChartName, Version: options.ChartVersion},
+		},
+		Config:   &chartpb.Config{Raw: ""},
+		Manifest: manifest,
+	}
+
+	data, err := encodeV2Release(release)
+	if err != nil {
+		return err
+	}
+	cm := &v1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      fmt.Sprintf("%s.v1", options.Release),
+			Namespace: tillerNamespace,
+			Labels: map[string]string{
+				"NAME":       options.Release,
+				"OWNER":      "TILLER",
+				"STATUS":     "DEPLOYED",
+				"VERSION":    "1",
+				"CREATED_AT": strconv.FormatInt(time.Now().Unix(), 10),
+			},
+		},
+		Data: map[string]string{"release": data},
+	}
+	if _, err := kube.KubernetesClient.CoreV1().ConfigMaps(tillerNamespace).Create(cm); err != nil {
+		return fmt.Errorf("cannot create release '%s': %s", cm.Name, err)
+	}
+	rlog.Infof("HELM release '%s' is created with adopted objects", options.Release)
+	return nil
+}
+
+// encodeV2Release encodes release like tiller ConfigMap storage does
+func encodeV2Release(release *rspb.Release) (string, error) {
+	b, err := proto.Marshal(release)
+	if err != nil {
+		return "", err
+	}
+	var buf bytes.Buffer
+	w, err := gzip.NewWriterLevel(&buf, gzip.BestCompression)
+	if err != nil {
+		return "", err
+	}
+	if _, err := w.Write(b); err != nil {
+		return "", err
+	}
+	w.Close()
+	return base64.StdEncoding.EncodeToString(buf.Bytes()), nil
+}
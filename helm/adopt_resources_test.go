@@ -0,0 +1,54 @@
+package helm
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	rspb "k8s.io/helm/pkg/proto/hapi/release"
+)
+
+func TestEncodeV2Release(t *testing.T) {
+	release := &rspb.Release{
+		Name:     "ingress",
+		Version:  3,
+		Info:     &rspb.Info{Status: &rspb.Status{Code: rspb.Status_DEPLOYED}},
+		Manifest: "---\nkind: ConfigMap\n",
+	}
+
+	data, err := encodeV2Release(release)
+	if !assert.NoError(t, err) {
+		return
+	}
+	decoded, err := decodeV2Release(data)
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.Equal(t, "ingress", decoded.Name)
+	assert.Equal(t, int32(3), decoded.Version)
+	assert.Equal(t, "DEPLOYED", decoded.Info.Status.Code.String())
+	assert.Equal(t, release.Manifest, decoded.Manifest)
+}
+
+func TestCleanLiveObject(t *testing.T) {
+	obj := cleanLiveObject(map[string]interface{}{
+		"kind": "ConfigMap",
+		"metadata": map[string]interface{}{
+			"name":            "config",
+			"uid":             "123",
+			"resourceVersion": "42",
+			"annotations": map[string]interface{}{
+				"kubectl.kubernetes.io/last-applied-configuration": "{}",
+			},
+			"labels": map[string]interface{}{"app": "test"},
+		},
+		"status": map[string]interface{}{"phase": "Active"},
+	})
+
+	assert.Equal(t, map[string]interface{}{
+		"kind": "ConfigMap",
+		"metadata": map[string]interface{}{
+			"name":   "config",
+			"labels": map[string]interface{}{"app": "test"},
+		},
+	}, obj)
+}
@@ -0,0 +1,199 @@
+package helm
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+
+	"github.com/romana/rlog"
+)
+
+// ClientsPool keeps helm clients by tiller namespace. Modules can be sharded
+// across several tillers, so upgrades of one module group are not serialized
+// by the release lock of other tiller. Clients for additional tillers are
+// initialized on first use.
+type ClientsPool struct {
+	m             sync.Mutex
+	defaultClient HelmClient
+	clients       map[string]HelmClient
+	newClient     func(tillerNamespace string) (HelmClient, error)
+	// remote clusters, their clients and errors of clients initialization by cluster name
+	clusters         map[string]*RemoteCluster
+	clusterClients   map[string]HelmClient
+	clusterErrors    map[string]error
+	newClusterClient func(cluster *RemoteCluster) (HelmClient, error)
+}
+
+// NewClientsPool creates a pool with the default client. Pool without newClient
+// supports only the default tiller namespace.
+func NewClientsPool(defaultClient HelmClient, newClient func(tillerNamespace string) (HelmClient, error)) *ClientsPool {
+	return &ClientsPool{
+		defaultClient: defaultClient,
+		clients: map[string]HelmClient{
+			defaultClient.TillerNamespace(): defaultClient,
+		},
+		newClient:      newClient,
+		clusters:       make(map[string]*RemoteCluster),
+		clusterClients: make(map[string]HelmClient),
+		clusterErrors:  make(map[string]error),
+	}
+}
+
+func (p *ClientsPool) Default() HelmClient {
+	return p.defaultClient
+}
+
+// Get returns a client for the tiller namespace. Default client is returned for empty namespace.
+func (p *ClientsPool) Get(tillerNamespace string) (HelmClient, error) {
+	if tillerNamespace == "" {
+		return p.defaultClient, nil
+	}
+
+	p.m.Lock()
+	defer p.m.Unlock()
+
+	if client, hasClient := p.clients[tillerNamespace]; hasClient {
+		return client, nil
+	}
+
+	if p.newClient == nil {
+		return nil, fmt.Errorf("tiller namespace '%s' is not supported: only '%s' is available", tillerNamespace, p.defaultClient.TillerNamespace())
+	}
+
+	rlog.Infof("Helm: initialize client for tiller namespace '%s'", tillerNamespace)
+	client, err := p.newClient(tillerNamespace)
+	if err != nil {
+		return nil, fmt.Errorf("cannot initialize helm for tiller namespace '%s': %s", tillerNamespace, err)
+	}
+	p.clients[tillerNamespace] = client
+
+	return client, nil
+}
+
+// Clients returns initialized clients, the default client goes first
+func (p *ClientsPool) Clients() []HelmClient {
+	p.m.Lock()
+	defer p.m.Unlock()
+
+	namespaces := make([]string, 0)
+	for namespace := range p.clients {
+		if namespace != p.defaultClient.TillerNamespace() {
+			namespaces = append(namespaces, namespace)
+		}
+	}
+	sort.Strings(namespaces)
+
+	res := []HelmClient{p.defaultClient}
+	for _, namespace := range namespaces {
+		res = append(res, p.clients[namespace])
+	}
+	return res
+}
+
+// ClusterStatus is a state of the client of the remote cluster
+type ClusterStatus struct {
+	Name  string `json:"name"`
+	Ready bool   `json:"ready"`
+	Error string `json:"error,omitempty"`
+}
+
+// AddClusters registers remote clusters. Clients are initialized on first use and
+// initialization is retried on next use if cluster is unreachable.
+func (p *ClientsPool) AddClusters(clusters []*RemoteCluster, newClient func(cluster *RemoteCluster) (HelmClient, error)) {
+	p.m.Lock()
+	defer p.m.Unlock()
+	for _, cluster := range clusters {
+		p.clusters[cluster.Name] = cluster
+	}
+	p.newClusterClient = newClient
+}
+
+// GetCluster returns a client of the remote cluster. The client is created without the lock
+// of the pool, so a slow cluster does not block other clients.
+func (p *ClientsPool) GetCluster(clusterName string) (HelmClient, error) {
+	p.m.Lock()
+	if client, hasClient := p.clusterClients[clusterName]; hasClient {
+		p.m.Unlock()
+		return client, nil
+	}
+	cluster, hasCluster := p.clusters[clusterName]
+	newClusterClient := p.newClusterClient
+	p.m.Unlock()
+
+	if !hasCluster {
+		return nil, fmt.Errorf("cluster '%s' is not configured", clusterName)
+	}
+
+	client, err := newClusterClient(cluster)
+
+	p.m.Lock()
+	defer p.m.Unlock()
+
+	// client could be created by a concurrent call
+	if existing, hasClient := p.clusterClients[clusterName]; hasClient {
+		return existing, nil
+	}
+	if err != nil {
+		p.clusterErrors[clusterName] = err
+		return nil, fmt.Errorf("cannot initialize helm for cluster '%s': %s", clusterName, err)
+	}
+	delete(p.clusterErrors, clusterName)
+	p.clusterClients[clusterName] = client
+
+	return client, nil
+}
+
+// ClusterNames returns sorted names of remote clusters
+func (p *ClientsPool) ClusterNames() []string {
+	p.m.Lock()
+	defer p.m.Unlock()
+
+	res := make([]string, 0, len(p.clusters))
+	for name := range p.clusters {
+		res = append(res, name)
+	}
+	sort.Strings(res)
+	return res
+}
+
+// ClustersStatus returns states of clients of remote clusters
+func (p *ClientsPool) ClustersStatus() []ClusterStatus {
+	names := p.ClusterNames()
+
+	p.m.Lock()
+	defer p.m.Unlock()
+
+	res := make([]ClusterStatus, 0, len(names))
+	for _, name := range names {
+		status := ClusterStatus{Name: name}
+		_, status.Ready = p.clusterClients[name]
+		if err, hasErr := p.clusterErrors[name]; hasErr {
+			status.Error = err.Error()
+		}
+		res = append(res, status)
+	}
+	return res
+}
+
+// AllClients returns clients of tillers in the cluster of antiopa and initialized clients of remote
+// clusters. Unreachable remote clusters do not block operations in the cluster of antiopa.
+func (p *ClientsPool) AllClients() []HelmClient {
+	return append(p.Clients(), p.ClusterClients()...)
+}
+
+// ClusterClients returns initialized clients of remote clusters sorted by cluster name
+func (p *ClientsPool) ClusterClients() []HelmClient {
+	p.m.Lock()
+	defer p.m.Unlock()
+
+	names := make([]string, 0, len(p.clusterClients))
+	for name := range p.clusterClients {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	res := make([]HelmClient, 0, len(names))
+	for _, name := range names {
+		res = append(res, p.clusterClients[name])
+	}
+	return res
+}
@@ -0,0 +1,108 @@
+package helm
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestClientsPool(t *testing.T) {
+	pool := NewClientsPool(NewRecorderHelm("antiopa"), func(tillerNamespace string) (HelmClient, error) {
+		return NewRecorderHelm(tillerNamespace), nil
+	})
+
+	client, err := pool.Get("")
+	assert.NoError(t, err)
+	assert.Equal(t, "antiopa", client.TillerNamespace())
+
+	client, err = pool.Get("antiopa-heavy")
+	assert.NoError(t, err)
+	assert.Equal(t, "antiopa-heavy", client.TillerNamespace())
+
+	// client is created once
+	again, _ := pool.Get("antiopa-heavy")
+	assert.True(t, client == again)
+
+	namespaces := make([]string, 0)
+	for _, client := range pool.Clients() {
+		namespaces = append(namespaces, client.TillerNamespace())
+	}
+	assert.Equal(t, []string{"antiopa", "antiopa-heavy"}, namespaces)
+}
+
+func TestClientsPool_WithoutFactory(t *testing.T) {
+	pool := NewClientsPool(NewRecorderHelm("antiopa"), nil)
+
+	_, err := pool.Get("antiopa-heavy")
+	assert.Error(t, err)
+
+	client, err := pool.Get("antiopa")
+	assert.NoError(t, err)
+	assert.Equal(t, "antiopa", client.TillerNamespace())
+}
+
+func TestClientsPool_Clusters(t *testing.T) {
+	pool := NewClientsPool(NewRecorderHelm("antiopa"), nil)
+
+	reachable := false
+	pool.AddClusters([]*RemoteCluster{{Name: "edge", TillerNamespace: "antiopa-edge"}}, func(cluster *RemoteCluster) (HelmClient, error) {
+		if !reachable {
+			return nil, fmt.Errorf("connection refused")
+		}
+		return NewRecorderHelm(cluster.TillerNamespace), nil
+	})
+
+	_, err := pool.GetCluster("absent")
+	assert.Error(t, err)
+
+	_, err = pool.GetCluster("edge")
+	assert.Error(t, err)
+	assert.Equal(t, []ClusterStatus{{Name: "edge", Error: "connection refused"}}, pool.ClustersStatus())
+	assert.Len(t, pool.AllClients(), 1)
+
+	// initialization is retried
+	reachable = true
+	client, err := pool.GetCluster("edge")
+	assert.NoError(t, err)
+	assert.Equal(t, "antiopa-edge", client.TillerNamespace())
+	assert.Equal(t, []ClusterStatus{{Name: "edge", Ready: true}}, pool.ClustersStatus())
+
+	// remote clients are not in clients of the antiopa cluster
+	assert.Len(t, pool.Clients(), 1)
+	assert.Len(t, pool.AllClients(), 2)
+}
+
+func TestClientsPool_SlowCluster(t *testing.T) {
+	pool := NewClientsPool(NewRecorderHelm("antiopa"), func(tillerNamespace string) (HelmClient, error) {
+		return NewRecorderHelm(tillerNamespace), nil
+	})
+
+	started := make(chan struct{})
+	connected := make(chan struct{})
+	pool.AddClusters([]*RemoteCluster{{Name: "edge", TillerNamespace: "antiopa-edge"}}, func(cluster *RemoteCluster) (HelmClient, error) {
+		close(started)
+		<-connected
+		return NewRecorderHelm(cluster.TillerNamespace), nil
+	})
+
+	done := make(chan HelmClient)
+	go func() {
+		client, _ := pool.GetCluster("edge")
+		done <- client
+	}()
+	<-started
+
+	// clients of the antiopa cluster are available while the remote cluster is connecting
+	client, err := pool.Get("antiopa-heavy")
+	assert.NoError(t, err)
+	assert.Equal(t, "antiopa-heavy", client.TillerNamespace())
+	assert.Equal(t, []ClusterStatus{{Name: "edge"}}, pool.ClustersStatus())
+
+	close(connected)
+	client = <-done
+	if assert.NotNil(t, client) {
+		assert.Equal(t, "antiopa-edge", client.TillerNamespace())
+	}
+	assert.Len(t, pool.ClusterClients(), 1)
+}
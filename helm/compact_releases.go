@@ -0,0 +1,166 @@
+package helm
+
+import (
+	"fmt"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/romana/rlog"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	kblabels "k8s.io/apimachinery/pkg/labels"
+
+	"github.com/flant/antiopa/kube"
+)
+
+// CompactReleasesOptions are options of `antiopa compact-releases`
+type CompactReleasesOptions struct {
+	TillerNamespace string
+	// releases to compact, all releases of the tiller namespace if empty
+	Releases []string
+	// number of the last revisions that are kept in the history of each release
+	KeepRevisions int
+	DryRun        bool
+	// pause between deletions of ConfigMaps to not overload apiserver and etcd
+	DeleteInterval time.Duration
+}
+
+// CompactedRelease is a result of one release history compaction
+type CompactedRelease struct {
+	Release string
+	// number of revisions in the history before compaction
+	Revisions int
+	// deleted revisions, revisions that would be deleted in dry run
+	Deleted []int
+	// size of deleted release data in bytes
+	DeletedBytes int
+	Error        error
+}
+
+// compactableStatuses are statuses of revisions that are not needed for rollbacks and upgrades
+var compactableStatuses = map[string]bool{
+	"SUPERSEDED": true,
+	"FAILED":     true,
+}
+
+// releaseRevision is a tiller ConfigMap of one release revision
+type releaseRevision struct {
+	ConfigMap string
+	Revision  int
+	Status    string
+	Size      int
+}
+
+// CompactReleases deletes old SUPERSEDED and FAILED revisions of releases from tiller ConfigMaps
+// storage: the last KeepRevisions revisions and DEPLOYED revisions are kept. Releases with
+// a pending operation are skipped. Tiller is not used, ConfigMaps are deleted one by one
+// with DeleteInterval pause.
+func CompactReleases(options CompactReleasesOptions) ([]CompactedRelease, error) {
+	if options.KeepRevisions < 1 {
+		return nil, fmt.Errorf("at least one revision should be kept, got %d", options.KeepRevisions)
+	}
+
+	cmList, err := kube.KubernetesClient.CoreV1().
+		ConfigMaps(options.TillerNamespace).
+		List(metav1.ListOptions{LabelSelector: kblabels.Set{"OWNER": "TILLER"}.AsSelector().String()})
+	if err != nil {
+		return nil, fmt.Errorf("cannot list tiller ConfigMaps in namespace '%s': %s", options.TillerNamespace, err)
+	}
+
+	histories := releasesHistories(cmList.Items)
+
+	names := options.Releases
+	if len(names) == 0 {
+		for name := range histories {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+	}
+
+	res := make([]CompactedRelease, 0, len(names))
+	for _, name := range names {
+		history, has := histories[name]
+		if !has {
+			res = append(res, CompactedRelease{Release: name, Error: &ErrReleaseNotFound{Release: name}})
+			continue
+		}
+
+		compacted := CompactedRelease{Release: name, Revisions: len(history), Deleted: make([]int, 0)}
+		toDelete, err := revisionsToCompact(history, options.KeepRevisions)
+		if err != nil {
+			compacted.Error = err
+			res = append(res, compacted)
+			continue
+		}
+
+		for _, revision := range toDelete {
+			if !options.DryRun {
+				rlog.Infof("COMPACT release '%s': delete %s revision cm/%s", name, revision.Status, revision.ConfigMap)
+				err := kube.KubernetesClient.CoreV1().
+					ConfigMaps(options.TillerNamespace).
+					Delete(revision.ConfigMap, &metav1.DeleteOptions{})
+				if err != nil && !errors.IsNotFound(err) {
+					compacted.Error = fmt.Errorf("cannot delete ConfigMap '%s': %s", revision.ConfigMap, err)
+					break
+				}
+				time.Sleep(options.DeleteInterval)
+			}
+			compacted.Deleted = append(compacted.Deleted, revision.Revision)
+			compacted.DeletedBytes += revision.Size
+		}
+
+		res = append(res, compacted)
+	}
+
+	return res, nil
+}
+
+// releasesHistories groups tiller ConfigMaps by release, revisions are sorted
+func releasesHistories(cms []v1.ConfigMap) map[string][]releaseRevision {
+	res := make(map[string][]releaseRevision)
+	for _, cm := range cms {
+		name := cm.Labels["NAME"]
+		revision, err := strconv.Atoi(cm.Labels["VERSION"])
+		if name == "" || err != nil {
+			rlog.Warnf("COMPACT ConfigMap '%s' has no NAME or VERSION labels, skip it", cm.Name)
+			continue
+		}
+		res[name] = append(res[name], releaseRevision{
+			ConfigMap: cm.Name,
+			Revision:  revision,
+			Status:    cm.Labels["STATUS"],
+			Size:      len(cm.Data["release"]),
+		})
+	}
+
+	for _, history := range res {
+		sort.Slice(history, func(i, j int) bool {
+			return history[i].Revision < history[j].Revision
+		})
+	}
+	return res
+}
+
+// revisionsToCompact returns SUPERSEDED and FAILED revisions except the last keep revisions.
+// An error is returned if the release has a pending operation.
+func revisionsToCompact(history []releaseRevision, keep int) ([]releaseRevision, error) {
+	for _, revision := range history {
+		if strings.HasPrefix(revision.Status, "PENDING") {
+			return nil, fmt.Errorf("revision %d is %s, release has an operation in progress", revision.Revision, revision.Status)
+		}
+	}
+
+	res := make([]releaseRevision, 0)
+	if len(history) <= keep {
+		return res, nil
+	}
+	for _, revision := range history[:len(history)-keep] {
+		if compactableStatuses[revision.Status] {
+			res = append(res, revision)
+		}
+	}
+	return res, nil
+}
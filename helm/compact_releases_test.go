@@ -0,0 +1,54 @@
+package helm
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func releaseRevisionConfigMap(name string, version string, status string) v1.ConfigMap {
+	return v1.ConfigMap{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:   name + ".v" + version,
+			Labels: map[string]string{"OWNER": "TILLER", "NAME": name, "VERSION": version, "STATUS": status},
+		},
+		Data: map[string]string{"release": "data"},
+	}
+}
+
+func TestRevisionsToCompact(t *testing.T) {
+	histories := releasesHistories([]v1.ConfigMap{
+		releaseRevisionConfigMap("dex", "10", "DEPLOYED"),
+		releaseRevisionConfigMap("dex", "2", "SUPERSEDED"),
+		releaseRevisionConfigMap("dex", "9", "FAILED"),
+		releaseRevisionConfigMap("dex", "1", "SUPERSEDED"),
+		releaseRevisionConfigMap("dex", "3", "DELETED"),
+		releaseRevisionConfigMap("dex", "8", "SUPERSEDED"),
+		releaseRevisionConfigMap("nginx", "1", "DEPLOYED"),
+		releaseRevisionConfigMap("nginx", "2", "PENDING_UPGRADE"),
+	})
+	if !assert.Len(t, histories, 2) || !assert.Len(t, histories["dex"], 6) {
+		return
+	}
+	assert.Equal(t, 1, histories["dex"][0].Revision)
+	assert.Equal(t, 4, histories["dex"][0].Size)
+
+	revisions, err := revisionsToCompact(histories["dex"], 2)
+	if assert.NoError(t, err) {
+		deleted := make([]int, 0)
+		for _, revision := range revisions {
+			deleted = append(deleted, revision.Revision)
+		}
+		// DELETED and the last two revisions are kept
+		assert.Equal(t, []int{1, 2, 8}, deleted)
+	}
+
+	revisions, err = revisionsToCompact(histories["dex"], 10)
+	assert.NoError(t, err)
+	assert.Len(t, revisions, 0)
+
+	_, err = revisionsToCompact(histories["nginx"], 1)
+	assert.Error(t, err)
+}
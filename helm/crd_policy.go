@@ -0,0 +1,148 @@
+package helm
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/go-yaml/yaml"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+
+	"github.com/flant/antiopa/kube"
+)
+
+// Object with "keep" in this annotation is not deleted by helm delete
+const ResourcePolicyAnnotation = "helm.sh/resource-policy"
+
+const customResourceDefinitionKind = "CustomResourceDefinition"
+
+// CustomResourceDefinition of the release manifest with names to find its custom resources
+type CustomResourceDefinition struct {
+	Resource ReleaseResource
+	Group    string
+	Versions []string
+	Kind     string
+}
+
+// KeepCustomResourceDefinitions returns the manifest where CRDs have 'keep' resource policy,
+// so helm delete does not delete them with their custom resources. The manifest is returned
+// as is if all CRDs already have the policy.
+func KeepCustomResourceDefinitions(manifest string) (string, error) {
+	objects, err := parseManifestObjects(manifest)
+	if err != nil {
+		return "", err
+	}
+
+	changed := false
+	for _, obj := range objects {
+		if obj["kind"] != customResourceDefinitionKind || obj.annotation(ResourcePolicyAnnotation) == "keep" {
+			continue
+		}
+		// annotation name has dots, so it is not a path for setManifestField
+		annotations, _ := getManifestField(obj, "metadata.annotations")
+		annotationsMap, _ := annotations.(map[interface{}]interface{})
+		if annotationsMap == nil {
+			annotationsMap = make(map[interface{}]interface{})
+		}
+		annotationsMap[ResourcePolicyAnnotation] = "keep"
+		setManifestField(obj, "metadata.annotations", annotationsMap)
+		changed = true
+	}
+	if !changed {
+		return manifest, nil
+	}
+
+	docs := make([]string, 0, len(objects))
+	for _, obj := range objects {
+		data, err := yaml.Marshal(obj)
+		if err != nil {
+			return "", err
+		}
+		docs = append(docs, string(data))
+	}
+	return "---\n" + strings.Join(docs, "---\n"), nil
+}
+
+// ManifestCustomResourceDefinitions returns CRDs of the manifest. unkeptOnly returns only CRDs
+// without 'keep' resource policy, helm delete deletes them.
+func ManifestCustomResourceDefinitions(manifest string, unkeptOnly bool) ([]CustomResourceDefinition, error) {
+	objects, err := parseManifestObjects(manifest)
+	if err != nil {
+		return nil, err
+	}
+
+	res := make([]CustomResourceDefinition, 0)
+	for _, obj := range objects {
+		if obj["kind"] != customResourceDefinitionKind {
+			continue
+		}
+		if unkeptOnly && obj.annotation(ResourcePolicyAnnotation) == "keep" {
+			continue
+		}
+
+		var crd struct {
+			ApiVersion string `yaml:"apiVersion"`
+			Metadata   struct {
+				Name string `yaml:"name"`
+			} `yaml:"metadata"`
+			Spec struct {
+				Group   string `yaml:"group"`
+				Version string `yaml:"version"`
+				Names   struct {
+					Kind string `yaml:"kind"`
+				} `yaml:"names"`
+				Versions []struct {
+					Name string `yaml:"name"`
+				} `yaml:"versions"`
+			} `yaml:"spec"`
+		}
+		data, err := yaml.Marshal(obj)
+		if err != nil {
+			return nil, err
+		}
+		if err := yaml.Unmarshal(data, &crd); err != nil {
+			return nil, fmt.Errorf("bad CustomResourceDefinition: %s", err)
+		}
+
+		versions := make([]string, 0)
+		if crd.Spec.Version != "" {
+			versions = append(versions, crd.Spec.Version)
+		}
+		for _, version := range crd.Spec.Versions {
+			if version.Name != crd.Spec.Version {
+				versions = append(versions, version.Name)
+			}
+		}
+		res = append(res, CustomResourceDefinition{
+			Resource: ReleaseResource{ApiVersion: crd.ApiVersion, Kind: customResourceDefinitionKind, Name: crd.Metadata.Name},
+			Group:    crd.Spec.Group,
+			Versions: versions,
+			Kind:     crd.Spec.Names.Kind,
+		})
+	}
+	return res, nil
+}
+
+// CountCustomResources returns the number of custom resources of the CRD in all namespaces.
+// 0 is returned if the CRD is not registered in apiserver.
+func CountCustomResources(crd CustomResourceDefinition) (int, error) {
+	if len(crd.Versions) == 0 {
+		return 0, fmt.Errorf("%s has no versions", crd.Resource)
+	}
+	gvr, _, err := kube.GroupVersionResource(schema.GroupVersion{Group: crd.Group, Version: crd.Versions[0]}.String(), crd.Kind)
+	if kube.IsKindNotRegistered(err) {
+		return 0, nil
+	}
+	if err != nil {
+		return 0, err
+	}
+	dynamicClient, err := kube.DynamicClient()
+	if err != nil {
+		return 0, err
+	}
+	list, err := dynamicClient.Resource(gvr).List(metav1.ListOptions{})
+	if err != nil {
+		return 0, fmt.Errorf("cannot list custom resources of %s: %s", crd.Resource, err)
+	}
+	return len(list.Items), nil
+}
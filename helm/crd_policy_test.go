@@ -0,0 +1,78 @@
+package helm
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const crdManifest = `---
+# Source: cert-manager/templates/crds.yaml
+apiVersion: apiextensions.k8s.io/v1beta1
+kind: CustomResourceDefinition
+metadata:
+  name: certificates.certmanager.k8s.io
+spec:
+  group: certmanager.k8s.io
+  version: v1alpha1
+  versions:
+  - name: v1alpha1
+  - name: v1alpha2
+  names:
+    kind: Certificate
+    plural: certificates
+---
+apiVersion: apiextensions.k8s.io/v1beta1
+kind: CustomResourceDefinition
+metadata:
+  name: issuers.certmanager.k8s.io
+  annotations:
+    helm.sh/resource-policy: keep
+spec:
+  group: certmanager.k8s.io
+  version: v1alpha1
+  names:
+    kind: Issuer
+---
+apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: cert-manager
+`
+
+func TestManifestCustomResourceDefinitions(t *testing.T) {
+	crds, err := ManifestCustomResourceDefinitions(crdManifest, false)
+	if assert.NoError(t, err) && assert.Len(t, crds, 2) {
+		assert.Equal(t, CustomResourceDefinition{
+			Resource: ReleaseResource{ApiVersion: "apiextensions.k8s.io/v1beta1", Kind: "CustomResourceDefinition", Name: "certificates.certmanager.k8s.io"},
+			Group:    "certmanager.k8s.io",
+			Versions: []string{"v1alpha1", "v1alpha2"},
+			Kind:     "Certificate",
+		}, crds[0])
+		assert.Equal(t, "Issuer", crds[1].Kind)
+	}
+
+	crds, err = ManifestCustomResourceDefinitions(crdManifest, true)
+	if assert.NoError(t, err) && assert.Len(t, crds, 1) {
+		assert.Equal(t, "certificates.certmanager.k8s.io", crds[0].Resource.Name)
+	}
+}
+
+func TestKeepCustomResourceDefinitions(t *testing.T) {
+	manifest, err := KeepCustomResourceDefinitions(crdManifest)
+	if !assert.NoError(t, err) {
+		return
+	}
+	crds, err := ManifestCustomResourceDefinitions(manifest, true)
+	assert.NoError(t, err)
+	assert.Len(t, crds, 0)
+
+	resources, err := ParseReleaseManifest(manifest, "default")
+	assert.NoError(t, err)
+	assert.Len(t, resources, 3)
+
+	// manifest without CRDs to change is not re-marshaled
+	kept, err := KeepCustomResourceDefinitions(manifest)
+	assert.NoError(t, err)
+	assert.Equal(t, manifest, kept)
+}
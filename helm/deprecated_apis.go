@@ -0,0 +1,120 @@
+package helm
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// DeprecatedApi is an API version of kubernetes that is deprecated and then removed.
+// Empty Kind matches all kinds of the API version.
+type DeprecatedApi struct {
+	ApiVersion   string `json:"apiVersion"`
+	Kind         string `json:"kind,omitempty"`
+	DeprecatedIn string `json:"deprecatedIn"`
+	RemovedIn    string `json:"removedIn"`
+	Replacement  string `json:"replacement"`
+}
+
+// DeprecatedApis are checked in rendered manifests of modules
+var DeprecatedApis = []DeprecatedApi{
+	{ApiVersion: "extensions/v1beta1", Kind: "Deployment", DeprecatedIn: "v1.9", RemovedIn: "v1.16", Replacement: "apps/v1"},
+	{ApiVersion: "extensions/v1beta1", Kind: "DaemonSet", DeprecatedIn: "v1.9", RemovedIn: "v1.16", Replacement: "apps/v1"},
+	{ApiVersion: "extensions/v1beta1", Kind: "ReplicaSet", DeprecatedIn: "v1.9", RemovedIn: "v1.16", Replacement: "apps/v1"},
+	{ApiVersion: "extensions/v1beta1", Kind: "NetworkPolicy", DeprecatedIn: "v1.9", RemovedIn: "v1.16", Replacement: "networking.k8s.io/v1"},
+	{ApiVersion: "extensions/v1beta1", Kind: "PodSecurityPolicy", DeprecatedIn: "v1.10", RemovedIn: "v1.16", Replacement: "policy/v1beta1"},
+	{ApiVersion: "extensions/v1beta1", Kind: "Ingress", DeprecatedIn: "v1.14", RemovedIn: "v1.22", Replacement: "networking.k8s.io/v1"},
+	{ApiVersion: "apps/v1beta1", DeprecatedIn: "v1.9", RemovedIn: "v1.16", Replacement: "apps/v1"},
+	{ApiVersion: "apps/v1beta2", DeprecatedIn: "v1.9", RemovedIn: "v1.16", Replacement: "apps/v1"},
+	{ApiVersion: "scheduling.k8s.io/v1beta1", Kind: "PriorityClass", DeprecatedIn: "v1.14", RemovedIn: "v1.22", Replacement: "scheduling.k8s.io/v1"},
+	{ApiVersion: "apiextensions.k8s.io/v1beta1", Kind: "CustomResourceDefinition", DeprecatedIn: "v1.16", RemovedIn: "v1.22", Replacement: "apiextensions.k8s.io/v1"},
+	{ApiVersion: "admissionregistration.k8s.io/v1beta1", DeprecatedIn: "v1.16", RemovedIn: "v1.22", Replacement: "admissionregistration.k8s.io/v1"},
+	{ApiVersion: "rbac.authorization.k8s.io/v1alpha1", DeprecatedIn: "v1.17", RemovedIn: "v1.22", Replacement: "rbac.authorization.k8s.io/v1"},
+	{ApiVersion: "rbac.authorization.k8s.io/v1beta1", DeprecatedIn: "v1.17", RemovedIn: "v1.22", Replacement: "rbac.authorization.k8s.io/v1"},
+	{ApiVersion: "networking.k8s.io/v1beta1", Kind: "Ingress", DeprecatedIn: "v1.19", RemovedIn: "v1.22", Replacement: "networking.k8s.io/v1"},
+	{ApiVersion: "apiregistration.k8s.io/v1beta1", Kind: "APIService", DeprecatedIn: "v1.19", RemovedIn: "v1.22", Replacement: "apiregistration.k8s.io/v1"},
+	{ApiVersion: "coordination.k8s.io/v1beta1", Kind: "Lease", DeprecatedIn: "v1.19", RemovedIn: "v1.22", Replacement: "coordination.k8s.io/v1"},
+	{ApiVersion: "batch/v1beta1", Kind: "CronJob", DeprecatedIn: "v1.21", RemovedIn: "v1.25", Replacement: "batch/v1"},
+	{ApiVersion: "policy/v1beta1", Kind: "PodDisruptionBudget", DeprecatedIn: "v1.21", RemovedIn: "v1.25", Replacement: "policy/v1"},
+	{ApiVersion: "policy/v1beta1", Kind: "PodSecurityPolicy", DeprecatedIn: "v1.21", RemovedIn: "v1.25"},
+}
+
+// DeprecatedApiUsage is an object of the manifest with a deprecated API version
+type DeprecatedApiUsage struct {
+	Resource ReleaseResource `json:"resource"`
+	DeprecatedApi
+	// API is not served by the cluster, helm upgrade fails
+	Removed bool `json:"removed"`
+	// API is removed in the next minor version of the cluster
+	RemovedInNextUpgrade bool `json:"removedInNextUpgrade"`
+}
+
+func (u DeprecatedApiUsage) String() string {
+	res := fmt.Sprintf("%s uses %s deprecated in %s", u.Resource, u.Resource.ApiVersion, u.DeprecatedIn)
+	if u.Removed {
+		res = fmt.Sprintf("%s and removed in %s", res, u.RemovedIn)
+	} else {
+		res = fmt.Sprintf("%s, it is removed in %s", res, u.RemovedIn)
+	}
+	if u.Replacement != "" {
+		res = fmt.Sprintf("%s, use %s", res, u.Replacement)
+	}
+	return res
+}
+
+// FindDeprecatedApis returns objects of the manifest with API versions that are deprecated
+// in the cluster of serverVersion, e.g. v1.15.3. Hook resources are checked too.
+func FindDeprecatedApis(manifest string, serverVersion string) ([]DeprecatedApiUsage, error) {
+	server, err := parseMinorVersion(serverVersion)
+	if err != nil {
+		return nil, err
+	}
+	resources, err := ParseReleaseManifest(manifest, "")
+	if err != nil {
+		return nil, err
+	}
+
+	res := make([]DeprecatedApiUsage, 0)
+	for _, resource := range resources {
+		for _, api := range DeprecatedApis {
+			if api.ApiVersion != resource.ApiVersion || (api.Kind != "" && api.Kind != resource.Kind) {
+				continue
+			}
+			deprecatedIn, _ := parseMinorVersion(api.DeprecatedIn)
+			if minorVersionLess(server, deprecatedIn) {
+				break
+			}
+			removedIn, _ := parseMinorVersion(api.RemovedIn)
+			res = append(res, DeprecatedApiUsage{
+				Resource:             resource,
+				DeprecatedApi:        api,
+				Removed:              !minorVersionLess(server, removedIn),
+				RemovedInNextUpgrade: removedIn == [2]int{server[0], server[1] + 1},
+			})
+			break
+		}
+	}
+	return res, nil
+}
+
+// parseMinorVersion returns major and minor of the version like v1.15.3 or v1.15.3-gke.1
+func parseMinorVersion(version string) ([2]int, error) {
+	var res [2]int
+	parts := strings.SplitN(strings.TrimPrefix(version, "v"), ".", 3)
+	if len(parts) < 2 {
+		return res, fmt.Errorf("bad kubernetes version '%s'", version)
+	}
+	for i := range res {
+		// minor of some providers has a suffix, e.g. 1.15+
+		n, err := strconv.Atoi(strings.TrimRight(parts[i], "+"))
+		if err != nil {
+			return res, fmt.Errorf("bad kubernetes version '%s'", version)
+		}
+		res[i] = n
+	}
+	return res, nil
+}
+
+func minorVersionLess(a [2]int, b [2]int) bool {
+	return a[0] < b[0] || (a[0] == b[0] && a[1] < b[1])
+}
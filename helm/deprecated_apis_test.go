@@ -0,0 +1,59 @@
+package helm
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFindDeprecatedApis(t *testing.T) {
+	manifest := `
+---
+apiVersion: extensions/v1beta1
+kind: Deployment
+metadata:
+  name: web
+---
+apiVersion: extensions/v1beta1
+kind: Ingress
+metadata:
+  name: web
+  namespace: prod
+---
+apiVersion: apiextensions.k8s.io/v1beta1
+kind: CustomResourceDefinition
+metadata:
+  name: certificates.certmanager.k8s.io
+---
+apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: api
+`
+
+	usages, err := FindDeprecatedApis(manifest, "v1.15.3-gke.1")
+	if assert.NoError(t, err) && assert.Len(t, usages, 2) {
+		assert.Equal(t, "Deployment/web", usages[0].Resource.String())
+		assert.Equal(t, "v1.16", usages[0].RemovedIn)
+		assert.False(t, usages[0].Removed)
+		assert.True(t, usages[0].RemovedInNextUpgrade)
+		assert.Equal(t, "Deployment/web uses extensions/v1beta1 deprecated in v1.9, it is removed in v1.16, use apps/v1", usages[0].String())
+
+		assert.Equal(t, "prod/Ingress/web", usages[1].Resource.String())
+		assert.False(t, usages[1].RemovedInNextUpgrade)
+	}
+
+	usages, err = FindDeprecatedApis(manifest, "v1.16.0")
+	if assert.NoError(t, err) && assert.Len(t, usages, 3) {
+		assert.True(t, usages[0].Removed)
+		assert.Equal(t, "CustomResourceDefinition", usages[2].Resource.Kind)
+		assert.False(t, usages[2].Removed)
+	}
+
+	usages, err = FindDeprecatedApis(manifest, "v1.8.0")
+	assert.NoError(t, err)
+	assert.Len(t, usages, 0)
+
+	_, err = FindDeprecatedApis(manifest, "latest")
+	assert.Error(t, err)
+}
@@ -0,0 +1,144 @@
+package helm
+
+import (
+	"fmt"
+	"net/http"
+	"os"
+	"os/exec"
+	"sync"
+	"time"
+
+	"github.com/romana/rlog"
+)
+
+const (
+	TillerBinPath = "/usr/local/bin/tiller"
+	// Embedded tillers listen on localhost only, each tiller uses two ports: grpc and probes
+	EmbeddedTillerHost         = "127.0.0.1"
+	EmbeddedTillerBasePort     = 44434
+	EmbeddedTillerRestartDelay = 5 * time.Second
+	EmbeddedTillerReadyTimeout = 60 * time.Second
+)
+
+var (
+	embeddedTillerPortsLock sync.Mutex
+	embeddedTillerNextPort  = EmbeddedTillerBasePort
+)
+
+// EmbeddedTiller is a tiller process started by antiopa. Process is restarted if it exits.
+// Tiller uses the service account of antiopa pod, so there is no tiller Deployment,
+// service account and cluster-visible grpc port.
+type EmbeddedTiller struct {
+	Namespace     string
+	ListenAddress string
+	ProbeAddress  string
+
+	m        sync.Mutex
+	cmd      *exec.Cmd
+	stopped  bool
+	restarts int
+}
+
+// StartEmbeddedTiller starts tiller process and waits until it is ready
+func StartEmbeddedTiller(tillerNamespace string) (*EmbeddedTiller, error) {
+	embeddedTillerPortsLock.Lock()
+	port := embeddedTillerNextPort
+	embeddedTillerNextPort += 2
+	embeddedTillerPortsLock.Unlock()
+
+	tiller := &EmbeddedTiller{
+		Namespace:     tillerNamespace,
+		ListenAddress: fmt.Sprintf("%s:%d", EmbeddedTillerHost, port),
+		ProbeAddress:  fmt.Sprintf("%s:%d", EmbeddedTillerHost, port+1),
+	}
+
+	go tiller.supervise()
+
+	if err := tiller.waitReady(EmbeddedTillerReadyTimeout); err != nil {
+		tiller.Stop()
+		return nil, err
+	}
+
+	rlog.Infof("Helm: embedded tiller for namespace '%s' is listening on %s", tillerNamespace, tiller.ListenAddress)
+	return tiller, nil
+}
+
+// supervise runs tiller and restarts it after exit until Stop is called
+func (t *EmbeddedTiller) supervise() {
+	for {
+		t.m.Lock()
+		if t.stopped {
+			t.m.Unlock()
+			return
+		}
+		cmd := exec.Command(TillerBinPath,
+			"--listen", t.ListenAddress,
+			"--probe-listen", t.ProbeAddress,
+			"--storage", "configmap",
+		)
+		cmd.Env = append(os.Environ(), fmt.Sprintf("TILLER_NAMESPACE=%s", t.Namespace))
+		cmd.Stdout = os.Stdout
+		cmd.Stderr = os.Stderr
+		t.cmd = cmd
+		err := cmd.Start()
+		t.m.Unlock()
+
+		if err == nil {
+			rlog.Debugf("Helm: embedded tiller for namespace '%s' started with pid %d", t.Namespace, cmd.Process.Pid)
+			// error is also returned if process is reaped by zombie reaper
+			err = cmd.Wait()
+		}
+
+		t.m.Lock()
+		stopped := t.stopped
+		if !stopped {
+			t.restarts++
+		}
+		restarts := t.restarts
+		t.m.Unlock()
+
+		if stopped {
+			return
+		}
+
+		rlog.Errorf("Helm: embedded tiller for namespace '%s' exited: %v. Restart #%d after %s", t.Namespace, err, restarts, EmbeddedTillerRestartDelay.String())
+		time.Sleep(EmbeddedTillerRestartDelay)
+	}
+}
+
+func (t *EmbeddedTiller) waitReady(timeout time.Duration) error {
+	url := fmt.Sprintf("http://%s/readiness", t.ProbeAddress)
+	client := &http.Client{Timeout: time.Second}
+
+	deadline := time.Now().Add(timeout)
+	for time.Now().Before(deadline) {
+		resp, err := client.Get(url)
+		if err == nil {
+			resp.Body.Close()
+			if resp.StatusCode == http.StatusOK {
+				return nil
+			}
+		}
+		time.Sleep(500 * time.Millisecond)
+	}
+
+	return fmt.Errorf("embedded tiller for namespace '%s' is not ready after %s", t.Namespace, timeout.String())
+}
+
+// Restarts returns a number of tiller restarts after crashes
+func (t *EmbeddedTiller) Restarts() int {
+	t.m.Lock()
+	defer t.m.Unlock()
+	return t.restarts
+}
+
+// Stop kills tiller process and stops supervising
+func (t *EmbeddedTiller) Stop() {
+	t.m.Lock()
+	defer t.m.Unlock()
+
+	t.stopped = true
+	if t.cmd != nil && t.cmd.Process != nil {
+		t.cmd.Process.Kill()
+	}
+}
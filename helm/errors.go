@@ -0,0 +1,75 @@
+package helm
+
+import (
+	"fmt"
+	"strings"
+)
+
+// ErrReleaseNotFound is returned if there is no release with such name in tiller
+type ErrReleaseNotFound struct {
+	Release string
+	// helm output for logs
+	Output string
+}
+
+func (e *ErrReleaseNotFound) Error() string {
+	if e.Output == "" {
+		return fmt.Sprintf("release '%s' not found", e.Release)
+	}
+	return fmt.Sprintf("release '%s' not found\n%s", e.Release, e.Output)
+}
+
+// IsReleaseNotFound returns true if err is ErrReleaseNotFound
+func IsReleaseNotFound(err error) bool {
+	_, ok := err.(*ErrReleaseNotFound)
+	return ok
+}
+
+// isReleaseNotFoundOutput detects 'Error: release: "name" not found' in helm stderr
+func isReleaseNotFoundOutput(stderr string) bool {
+	errLine := strings.Split(stderr, "\n")[0]
+	return strings.Contains(errLine, "Error:") && strings.Contains(errLine, "not found")
+}
+
+// ErrOperationInProgress is returned if tiller has a pending operation on the release
+type ErrOperationInProgress struct {
+	Release string
+	// helm output for logs
+	Output string
+}
+
+func (e *ErrOperationInProgress) Error() string {
+	return fmt.Sprintf("another operation is in progress for release '%s'\n%s", e.Release, e.Output)
+}
+
+// IsOperationInProgress returns true if err is ErrOperationInProgress
+func IsOperationInProgress(err error) bool {
+	_, ok := err.(*ErrOperationInProgress)
+	return ok
+}
+
+// isOperationInProgressOutput detects 'Error: UPGRADE FAILED: another operation (install/upgrade/rollback) is in progress'
+func isOperationInProgressOutput(stderr string) bool {
+	return strings.Contains(stderr, "another operation") && strings.Contains(stderr, "in progress")
+}
+
+// ErrValuesTooLarge is returned before helm upgrade if values of the release do not fit into tiller limits
+type ErrValuesTooLarge struct {
+	Release string
+	Size    int
+	Limit   int
+	// which limit is exceeded
+	Reason string
+	// the largest top level values keys with sizes to find the source of the problem
+	LargestKeys []string
+}
+
+func (e *ErrValuesTooLarge) Error() string {
+	return fmt.Sprintf("values of release '%s' are too large: %s: %d bytes, limit is %d bytes, the largest keys: %s", e.Release, e.Reason, e.Size, e.Limit, strings.Join(e.LargestKeys, ", "))
+}
+
+// IsValuesTooLarge returns true if err is ErrValuesTooLarge
+func IsValuesTooLarge(err error) bool {
+	_, ok := err.(*ErrValuesTooLarge)
+	return ok
+}
@@ -2,19 +2,24 @@ package helm
 
 import (
 	"bytes"
+	"encoding/json"
 	"fmt"
+	"io/ioutil"
 	"os"
-	"os/exec"
 	"regexp"
 	"sort"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/romana/rlog"
+	"k8s.io/api/core/v1"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 	kblabels "k8s.io/apimachinery/pkg/labels"
 
+	"github.com/flant/antiopa/approval"
 	"github.com/flant/antiopa/executor"
+	"github.com/flant/antiopa/failure_injection"
 	"github.com/flant/antiopa/kube"
 	"github.com/flant/antiopa/utils"
 )
@@ -26,16 +31,60 @@ type HelmClient interface {
 	DeleteSingleFailedRevision(releaseName string) error
 	DeleteOldFailedRevisions(releaseName string) error
 	LastReleaseStatus(releaseName string) (string, string, error)
-	UpgradeRelease(releaseName string, chart string, valuesPaths []string, setValues []string, namespace string) error
-	GetReleaseValues(releaseName string) (utils.Values, error)
+	UpgradeRelease(releaseName string, chart string, valuesPaths []string, setValues []SetValue, namespace string, reuseValues bool) (*ReleaseUpgradeResult, error)
+	GetReleaseValues(releaseName string, all bool) (utils.Values, error)
+	RenderRelease(releaseName string, chart string, valuesPaths []string, setValues []SetValue, namespace string) (string, error)
+	GetReleaseManifest(releaseName string) (string, error)
+	GetReleaseNotes(releaseName string) (string, error)
+	TestRelease(releaseName string, timeout int, cleanup bool) (string, error)
 	DeleteRelease(releaseName string) error
 	ListReleases(labelSelector map[string]string) ([]string, error)
 	ListReleasesNames(labelSelector map[string]string) ([]string, error)
 	IsReleaseExists(releaseName string) (bool, error)
+	AdoptResources(options AdoptResourcesOptions) ([]AdoptedResource, error)
 }
 
 type CliHelm struct {
 	tillerNamespace string
+	// cache of tiller ConfigMaps for ListReleases, releases are listed from apiserver if it is nil
+	releasesCache *ReleasesCache
+	// local tiller process instead of tiller Deployment
+	embeddedTiller *EmbeddedTiller
+	// helm operations of antiopa to detect manual operations
+	operations *releaseOperations
+	// remote cluster of releases and its client, nil for the cluster of antiopa
+	cluster    *RemoteCluster
+	kubeClient kube.Client
+	// correlation id of the task in env of helm commands, they are killed when the task is cancelled
+	taskId string
+}
+
+// NewOfflineHelm returns a client for commands without tiller, e.g. 'helm template'
+func NewOfflineHelm(tillerNamespace string) HelmClient {
+	return &CliHelm{tillerNamespace: tillerNamespace}
+}
+
+// Modes of tiller for InitFunc
+const (
+	// tiller Deployment is installed and upgraded by antiopa
+	TillerModeDeployment = "deployment"
+	// tiller process is started on localhost
+	TillerModeEmbedded = "embedded"
+	// tiller is provisioned by cluster admins
+	TillerModeExternal = "external"
+)
+
+// InitFunc returns a function that initializes a helm client for the tiller mode
+func InitFunc(tillerMode string) (func(tillerNamespace string) (HelmClient, error), error) {
+	switch tillerMode {
+	case TillerModeDeployment, "":
+		return Init, nil
+	case TillerModeEmbedded:
+		return InitWithEmbeddedTiller, nil
+	case TillerModeExternal:
+		return InitWithExternalTiller, nil
+	}
+	return nil, fmt.Errorf("unknown tiller mode '%s', expected '%s', '%s' or '%s'", tillerMode, TillerModeDeployment, TillerModeEmbedded, TillerModeExternal)
 }
 
 // InitHelm запускает установку tiller-a.
@@ -44,17 +93,84 @@ func Init(tillerNamespace string) (HelmClient, error) {
 
 	helm := &CliHelm{tillerNamespace: tillerNamespace}
 
+	if BootstrapTillerRBAC {
+		if err := EnsureTillerRBAC(tillerNamespace); err != nil {
+			return nil, err
+		}
+	}
+
 	err := helm.InitTiller()
 	if err != nil {
 		return nil, err
 	}
 
+	return helm.initClient()
+}
+
+// InitWithEmbeddedTiller starts tiller process on localhost instead of installation of tiller Deployment.
+func InitWithEmbeddedTiller(tillerNamespace string) (HelmClient, error) {
+	rlog.Info("Helm: start embedded tiller")
+
+	tiller, err := StartEmbeddedTiller(tillerNamespace)
+	if err != nil {
+		return nil, err
+	}
+
+	helm := &CliHelm{tillerNamespace: tillerNamespace, embeddedTiller: tiller}
+
+	// only helm home is needed, tiller is already running
+	stdout, stderr, err := helm.Cmd("init", "--client-only", "--skip-refresh")
+	if err != nil {
+		tiller.Stop()
+		return nil, fmt.Errorf("%s\n%s\n%s", err, stdout, stderr)
+	}
+
+	return helm.initClient()
+}
+
+// InitWithExternalTiller uses tiller provisioned by cluster admins: tiller is not installed
+// or upgraded and antiopa Deployment is not read, so RBAC for helm init is not needed.
+// Only connection to tiller is checked.
+func InitWithExternalTiller(tillerNamespace string) (HelmClient, error) {
+	rlog.Infof("Helm: use external tiller in namespace '%s'", tillerNamespace)
+
+	helm := &CliHelm{tillerNamespace: tillerNamespace}
+
+	// only helm home is needed, tiller is installed by admins
+	stdout, stderr, err := helm.Cmd("init", "--client-only", "--skip-refresh")
+	if err != nil {
+		return nil, fmt.Errorf("%s\n%s\n%s", err, stdout, stderr)
+	}
+
+	return helm.initClient()
+}
+
+// initClient checks connection to tiller and starts the releases cache
+func (helm *CliHelm) initClient() (HelmClient, error) {
 	stdout, stderr, err := helm.Cmd("version")
 	if err != nil {
 		return nil, fmt.Errorf("unable to get helm version: %v\n%v %v", err, stdout, stderr)
 	}
 	rlog.Infof("Helm: helm version:\n%v %v", stdout, stderr)
 
+	helm.operations = newReleaseOperations()
+
+	releasesCache := NewReleasesCache(helm.tillerNamespace)
+	releasesCache.OnNewRevision(helm.detectManualOperation)
+	if err := releasesCache.Run(); err != nil {
+		return nil, err
+	}
+	helm.releasesCache = releasesCache
+
+	if ClientType == NativeClient {
+		native, err := newNativeHelm(helm)
+		if err != nil {
+			return nil, err
+		}
+		rlog.Info("Helm: successfully initialized")
+		return native, nil
+	}
+
 	rlog.Info("Helm: successfully initialized")
 
 	return helm, nil
@@ -69,7 +185,7 @@ func (helm *CliHelm) InitTiller() error {
 	cmd := make([]string, 0)
 	cmd = append(cmd,
 		"init",
-		"--service-account", "antiopa",
+		"--service-account", TillerServiceAccountName,
 		"--upgrade", "--wait", "--skip-refresh",
 	)
 
@@ -112,15 +228,46 @@ func (helm *CliHelm) TillerNamespace() string {
 func (helm *CliHelm) CommandEnv() []string {
 	res := make([]string, 0)
 	res = append(res, fmt.Sprintf("TILLER_NAMESPACE=%s", helm.TillerNamespace()))
+	if helm.embeddedTiller != nil {
+		res = append(res, fmt.Sprintf("HELM_HOST=%s", helm.embeddedTiller.ListenAddress))
+	}
+	if helm.cluster != nil {
+		res = append(res, fmt.Sprintf("KUBECONFIG=%s", helm.cluster.KubeConfig))
+	}
+	if helm.taskId != "" {
+		res = append(res, fmt.Sprintf("%s=%s", executor.TaskIdEnv, helm.taskId))
+	}
 	return res
 }
 
+// WithTaskId returns a copy of the client that runs helm commands of the task, they are
+// killed when the task is cancelled. Clients that do not run helm are returned as is.
+func WithTaskId(client HelmClient, taskId string) HelmClient {
+	cliHelm, ok := client.(*CliHelm)
+	if !ok || taskId == "" {
+		return client
+	}
+	res := *cliHelm
+	res.taskId = taskId
+	return &res
+}
+
+// CommandTimeout limits helm commands, hung helm is killed with its process group. It should be
+// greater than --timeout of helm upgrade --wait. Commands are not limited if it is 0.
+var CommandTimeout = 20 * time.Minute
+
 // Запускает helm с переданными аргументами.
 // Перед запуском устанавливает переменную среды TILLER_NAMESPACE,
 // чтобы antiopa работала со своим tiller-ом.
 func (helm *CliHelm) Cmd(args ...string) (stdout string, stderr string, err error) {
 	binPath := "/usr/local/bin/helm"
-	cmd := exec.Command(binPath, args...)
+	if err := failure_injection.Take(failure_injection.HelmFailure, args...); err != nil {
+		return "", err.Error(), err
+	}
+	if helm.cluster != nil && helm.cluster.KubeContext != "" {
+		args = append([]string{"--kube-context", helm.cluster.KubeContext}, args...)
+	}
+	cmd := executor.WrapCommand(binPath, args...)
 	cmd.Env = append(os.Environ(), helm.CommandEnv()...)
 
 	var stdoutBuf bytes.Buffer
@@ -128,7 +275,7 @@ func (helm *CliHelm) Cmd(args ...string) (stdout string, stderr string, err erro
 	var stderrBuf bytes.Buffer
 	cmd.Stderr = &stderrBuf
 
-	err = executor.Run(cmd, true)
+	err = executor.RunWithTimeout(cmd, true, CommandTimeout)
 	stdout = strings.TrimSpace(stdoutBuf.String())
 	stderr = strings.TrimSpace(stderrBuf.String())
 
@@ -138,8 +285,8 @@ func (helm *CliHelm) Cmd(args ...string) (stdout string, stderr string, err erro
 func (helm *CliHelm) DeleteSingleFailedRevision(releaseName string) (err error) {
 	revision, status, err := helm.LastReleaseStatus(releaseName)
 	if err != nil {
-		if revision == "0" {
-			// revision 0 is not an error. just skip deletion.
+		if IsReleaseNotFound(err) {
+			// no release is not an error. just skip deletion.
 			rlog.Debugf("helm release '%s': Release not found, no cleanup required.", releaseName)
 			return nil
 		}
@@ -163,6 +310,9 @@ func (helm *CliHelm) DeleteSingleFailedRevision(releaseName string) (err error)
 	return
 }
 
+// Deletion of more old FAILED revisions than the threshold waits for approval if approval is required
+var FailedRevisionsApprovalThreshold = 5
+
 func (helm *CliHelm) DeleteOldFailedRevisions(releaseName string) error {
 	cmNames, err := helm.ListReleases(map[string]string{"STATUS": "FAILED", "NAME": releaseName})
 	if err != nil {
@@ -191,12 +341,24 @@ func (helm *CliHelm) DeleteOldFailedRevisions(releaseName string) error {
 		revisions = revisions[:len(revisions)-1]
 	}
 
+	if FailedRevisionsApprovalThreshold > 0 && len(revisions) > FailedRevisionsApprovalThreshold {
+		description := fmt.Sprintf("delete %d old FAILED revisions of release '%s'", len(revisions), releaseName)
+		if !approval.Request(approval.PurgeFailedRevisionsKey(releaseName), description, nil) {
+			return nil
+		}
+	}
+
+	if len(revisions) > 0 {
+		defer helm.lockRelease(releaseName)()
+		defer helm.invalidateCachedRelease(releaseName)
+	}
+
 	for _, revision := range revisions {
 		cmName := fmt.Sprintf("%s.v%d", releaseName, revision)
 		rlog.Infof("helm release '%s': delete old FAILED revision cm/%s", releaseName, cmName)
 
-		err := kube.KubernetesClient.CoreV1().
-			ConfigMaps(kube.KubernetesAntiopaNamespace).
+		err := helm.client().CoreV1().
+			ConfigMaps(helm.tillerNamespace).
 			Delete(cmName, &metav1.DeleteOptions{})
 
 		if err != nil {
@@ -215,10 +377,9 @@ func (helm *CliHelm) LastReleaseStatus(releaseName string) (revision string, sta
 	stdout, stderr, err := helm.Cmd("history", releaseName, "--max", "1")
 
 	if err != nil {
-		errLine := strings.Split(stderr, "\n")[0]
-		if strings.Contains(errLine, "Error:") && strings.Contains(errLine, "not found") {
+		if isReleaseNotFoundOutput(stderr) {
 			// Bad module name or no releases installed
-			err = fmt.Errorf("release '%s' not found\n%v %v", releaseName, stdout, stderr)
+			err = &ErrReleaseNotFound{Release: releaseName, Output: fmt.Sprintf("%v %v", stdout, stderr)}
 			revision = "0"
 			return
 		}
@@ -235,41 +396,162 @@ func (helm *CliHelm) LastReleaseStatus(releaseName string) (revision string, sta
 	return
 }
 
-func (helm *CliHelm) UpgradeRelease(releaseName string, chart string, valuesPaths []string, setValues []string, namespace string) error {
+// UpgradeRelease installs or upgrades the release. Values of the deployed release are reset
+// unless reuseValues is set: then values and set values are merged on top of them.
+func (helm *CliHelm) UpgradeRelease(releaseName string, chart string, valuesPaths []string, setValues []SetValue, namespace string, reuseValues bool) (*ReleaseUpgradeResult, error) {
 	args := make([]string, 0)
 	args = append(args, "upgrade")
 	args = append(args, "--install")
 	args = append(args, releaseName)
 	args = append(args, chart)
 
+	if reuseValues {
+		args = append(args, "--reuse-values")
+	}
+
 	if namespace != "" {
 		args = append(args, "--namespace")
 		args = append(args, namespace)
 	}
 
+	valuesPaths, cleanupValuesChunks, err := prepareValuesFiles(releaseName, valuesPaths)
+	defer cleanupValuesChunks()
+	if err != nil {
+		return nil, err
+	}
+
 	for _, valuesPath := range valuesPaths {
 		args = append(args, "--values")
 		args = append(args, valuesPath)
 	}
 
+	// helm has no flag for json values, so they are passed in a values file
+	jsonValuesPath, err := writeJsonSetValuesFile(setValues)
+	if err != nil {
+		return nil, err
+	}
+	if jsonValuesPath != "" {
+		defer os.Remove(jsonValuesPath)
+		args = append(args, "--values")
+		args = append(args, jsonValuesPath)
+	}
+
 	for _, setValue := range setValues {
-		args = append(args, "--set")
-		args = append(args, setValue)
+		if setValue.Type == SetValueJson {
+			continue
+		}
+		args = append(args, setValue.Flag())
+		args = append(args, setValue.String())
 	}
 
 	rlog.Infof("Running helm upgrade for release '%s' with chart '%s' in namespace '%s' ...", releaseName, chart, namespace)
+	if helm.operations != nil {
+		defer helm.beginReleaseOperation(releaseName)()
+	}
+	stdout, stderr, err := helm.releaseCmd(releaseName, args...)
+	if IsOperationInProgress(err) {
+		return nil, err
+	}
+	// failed upgrade creates a FAILED revision too
+	helm.invalidateCachedRelease(releaseName)
+	if err != nil {
+		return nil, fmt.Errorf("helm upgrade failed: %s:\n%s %s", err, stdout, stderr)
+	}
+	rlog.Debugf("Helm upgrade for release '%s' output:\n%s\n%s", releaseName, stdout, stderr)
+
+	result := ParseUpgradeOutput(releaseName, stdout)
+	// revision is not in the output of helm upgrade
+	revision, _, err := helm.LastReleaseStatus(releaseName)
+	if err != nil {
+		rlog.Warnf("Helm upgrade for release '%s': cannot get revision: %s", releaseName, err)
+	} else {
+		result.Revision, _ = strconv.Atoi(revision)
+	}
+
+	rlog.Infof("Helm upgrade for release '%s' with chart '%s' in namespace '%s' successful: %s", releaseName, chart, namespace, result)
+
+	return result, nil
+}
+
+// RenderRelease returns a manifest rendered by `helm template` without installation
+func (helm *CliHelm) RenderRelease(releaseName string, chart string, valuesPaths []string, setValues []SetValue, namespace string) (string, error) {
+	args := []string{"template", chart, "--name", releaseName}
+
+	if namespace != "" {
+		args = append(args, "--namespace", namespace)
+	}
+
+	for _, valuesPath := range valuesPaths {
+		args = append(args, "--values", valuesPath)
+	}
+
+	jsonValuesPath, err := writeJsonSetValuesFile(setValues)
+	if err != nil {
+		return "", err
+	}
+	if jsonValuesPath != "" {
+		defer os.Remove(jsonValuesPath)
+		args = append(args, "--values", jsonValuesPath)
+	}
+
+	for _, setValue := range setValues {
+		if setValue.Type == SetValueJson {
+			continue
+		}
+		args = append(args, setValue.Flag(), setValue.String())
+	}
+
 	stdout, stderr, err := helm.Cmd(args...)
 	if err != nil {
-		return fmt.Errorf("helm upgrade failed: %s:\n%s %s", err, stdout, stderr)
+		return "", fmt.Errorf("helm template for release '%s' failed: %s:\n%s %s", releaseName, err, stdout, stderr)
 	}
-	rlog.Infof("Helm upgrade for release '%s' with chart '%s' in namespace '%s' successful:\n%s\n%s", releaseName, chart, namespace, stdout, stderr)
 
-	return nil
+	return stdout, nil
+}
+
+// writeJsonSetValuesFile writes set values with json type into a temporary values file.
+// Empty path is returned if there are no such values.
+func writeJsonSetValuesFile(setValues []SetValue) (string, error) {
+	values, err := JsonSetValuesToValues(setValues)
+	if err != nil {
+		return "", err
+	}
+	if len(values) == 0 {
+		return "", nil
+	}
+
+	// json is a valid yaml for helm
+	data, err := json.Marshal(values)
+	if err != nil {
+		return "", err
+	}
+
+	f, err := ioutil.TempFile("", "antiopa-set-values-")
+	if err != nil {
+		return "", fmt.Errorf("cannot create values file for json set values: %s", err)
+	}
+	defer f.Close()
+
+	if _, err := f.Write(data); err != nil {
+		os.Remove(f.Name())
+		return "", fmt.Errorf("cannot write values file for json set values: %s", err)
+	}
+
+	return f.Name(), nil
 }
 
-func (helm *CliHelm) GetReleaseValues(releaseName string) (utils.Values, error) {
-	stdout, stderr, err := helm.Cmd("get", "values", releaseName)
+// GetReleaseValues returns values of the release passed with --values and --set. If all
+// is true, values are computed: defaults from values.yaml of the chart are merged with them.
+func (helm *CliHelm) GetReleaseValues(releaseName string, all bool) (utils.Values, error) {
+	args := []string{"get", "values", releaseName}
+	if all {
+		args = append(args, "--all")
+	}
+	stdout, stderr, err := helm.Cmd(args...)
 	if err != nil {
+		if isReleaseNotFoundOutput(stderr) {
+			return nil, &ErrReleaseNotFound{Release: releaseName, Output: fmt.Sprintf("%v %v", stdout, stderr)}
+		}
 		return nil, fmt.Errorf("cannot get values of helm release %s: %s\n%s %s", releaseName, err, stdout, stderr)
 	}
 
@@ -281,11 +563,40 @@ func (helm *CliHelm) GetReleaseValues(releaseName string) (utils.Values, error)
 	return values, nil
 }
 
+// GetReleaseNotes returns rendered NOTES.txt of the release from the output of `helm status`
+func (helm *CliHelm) GetReleaseNotes(releaseName string) (string, error) {
+	stdout, stderr, err := helm.Cmd("status", releaseName)
+	if err != nil {
+		if isReleaseNotFoundOutput(stderr) {
+			return "", &ErrReleaseNotFound{Release: releaseName, Output: fmt.Sprintf("%v %v", stdout, stderr)}
+		}
+		return "", fmt.Errorf("cannot get status of helm release %s: %s\n%s %s", releaseName, err, stdout, stderr)
+	}
+
+	return ParseUpgradeOutput(releaseName, stdout).Notes, nil
+}
+
+func (helm *CliHelm) GetReleaseManifest(releaseName string) (string, error) {
+	stdout, stderr, err := helm.Cmd("get", "manifest", releaseName)
+	if err != nil {
+		return "", fmt.Errorf("cannot get manifest of helm release %s: %s\n%s %s", releaseName, err, stdout, stderr)
+	}
+
+	return stdout, nil
+}
+
 func (helm *CliHelm) DeleteRelease(releaseName string) (err error) {
 	rlog.Debugf("helm release '%s': execute helm delete --purge", releaseName)
 
-	stdout, stderr, err := helm.Cmd("delete", "--purge", releaseName)
+	stdout, stderr, err := helm.releaseCmd(releaseName, "delete", "--purge", releaseName)
+	if IsOperationInProgress(err) {
+		return err
+	}
+	helm.invalidateCachedRelease(releaseName)
 	if err != nil {
+		if isReleaseNotFoundOutput(stderr) {
+			return &ErrReleaseNotFound{Release: releaseName, Output: fmt.Sprintf("%v %v", stdout, stderr)}
+		}
 		return fmt.Errorf("helm delete --purge %s invocation error: %v\n%v %v", releaseName, err, stdout, stderr)
 	}
 
@@ -293,8 +604,8 @@ func (helm *CliHelm) DeleteRelease(releaseName string) (err error) {
 }
 
 func (helm *CliHelm) IsReleaseExists(releaseName string) (bool, error) {
-	revision, _, err := helm.LastReleaseStatus(releaseName)
-	if err != nil && revision == "0" {
+	_, _, err := helm.LastReleaseStatus(releaseName)
+	if IsReleaseNotFound(err) {
 		return false, nil
 	} else if err != nil {
 		return false, err
@@ -313,16 +624,14 @@ func (helm *CliHelm) ListReleases(labelSelector map[string]string) ([]string, er
 	}
 	labelsSet["OWNER"] = "TILLER"
 
-	cmList, err := kube.KubernetesClient.CoreV1().
-		ConfigMaps(kube.KubernetesAntiopaNamespace).
-		List(metav1.ListOptions{LabelSelector: labelsSet.AsSelector().String()})
+	cmList, err := helm.listReleasesConfigMaps(labelsSet.AsSelector())
 	if err != nil {
 		rlog.Debugf("helm: list of releases ConfigMaps failed: %s", err)
 		return nil, err
 	}
 
 	releases := make([]string, 0)
-	for _, cm := range cmList.Items {
+	for _, cm := range cmList {
 		if _, has_key := cm.Data["release"]; has_key {
 			releases = append(releases, cm.Name)
 		}
@@ -333,6 +642,27 @@ func (helm *CliHelm) ListReleases(labelSelector map[string]string) ([]string, er
 	return releases, nil
 }
 
+// listReleasesConfigMaps returns tiller ConfigMaps from the cache if it is synced and has no
+// invalidated releases or from apiserver
+func (helm *CliHelm) listReleasesConfigMaps(selector kblabels.Selector) ([]*v1.ConfigMap, error) {
+	if helm.releasesCache != nil && helm.releasesCache.HasSynced() && !helm.releasesCache.hasInvalidated() {
+		return helm.releasesCache.List(selector)
+	}
+
+	cmList, err := helm.client().CoreV1().
+		ConfigMaps(helm.tillerNamespace).
+		List(metav1.ListOptions{LabelSelector: selector.String()})
+	if err != nil {
+		return nil, err
+	}
+
+	res := make([]*v1.ConfigMap, 0, len(cmList.Items))
+	for i := range cmList.Items {
+		res = append(res, &cmList.Items[i])
+	}
+	return res, nil
+}
+
 // Список имён релизов без суффикса ".v<номер релиза>"
 func (helm *CliHelm) ListReleasesNames(labelSelector map[string]string) ([]string, error) {
 	releases, err := helm.ListReleases(labelSelector)
@@ -358,3 +688,14 @@ func (helm *CliHelm) ListReleasesNames(labelSelector map[string]string) ([]strin
 
 	return releasesNames, nil
 }
+
+// CheckTiller runs `helm version` to check that tiller in the namespace is reachable.
+// Tiller is not installed or upgraded.
+func CheckTiller(tillerNamespace string) (string, error) {
+	helm := &CliHelm{tillerNamespace: tillerNamespace}
+	stdout, stderr, err := helm.Cmd("version", "--server")
+	if err != nil {
+		return "", fmt.Errorf("%s\n%s %s", err, stdout, stderr)
+	}
+	return stdout, nil
+}
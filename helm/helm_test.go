@@ -55,7 +55,7 @@ func releasesListShouldEqual(helm HelmClient, expectedList []string) (err error)
 }
 
 func shouldUpgradeRelease(helm HelmClient, releaseName string, chart string, valuesPaths []string) (err error) {
-	err = helm.UpgradeRelease(releaseName, chart, []string{}, []string{}, helm.TillerNamespace())
+	_, err = helm.UpgradeRelease(releaseName, chart, []string{}, []SetValue{}, helm.TillerNamespace(), false)
 	if err != nil {
 		return fmt.Errorf("Cannot install test release: %s", err)
 	}
@@ -189,7 +189,7 @@ func TestHelm(t *testing.T) {
 		t.Error(err)
 	}
 
-	err = helm.UpgradeRelease("hello", "no-such-chart", []string{}, []string{}, helm.TillerNamespace())
+	_, err = helm.UpgradeRelease("hello", "no-such-chart", []string{}, []SetValue{}, helm.TillerNamespace(), false)
 	if err == nil {
 		t.Errorf("Expected helm upgrade to fail, got no error from helm client")
 	}
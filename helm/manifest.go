@@ -0,0 +1,111 @@
+package helm
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/go-yaml/yaml"
+
+	"github.com/flant/antiopa/kube"
+)
+
+// ReleaseResource identifies a kubernetes object from a release manifest
+type ReleaseResource struct {
+	ApiVersion string
+	Kind       string
+	Namespace  string
+	Name       string
+	// value of helm.sh/hook annotation for hook resources
+	Hook string
+}
+
+func (r ReleaseResource) String() string {
+	if r.Namespace == "" {
+		return fmt.Sprintf("%s/%s", r.Kind, r.Name)
+	}
+	return fmt.Sprintf("%s/%s/%s", r.Namespace, r.Kind, r.Name)
+}
+
+const HookAnnotation = "helm.sh/hook"
+
+var manifestDocumentSeparator = regexp.MustCompile(`(?m)^---\s*$`)
+
+// built-in kinds without namespace, custom resources are resolved with discovery
+var clusterScopedKinds = map[string]bool{
+	"apiservice":                     true,
+	"certificatesigningrequest":      true,
+	"clusterrole":                    true,
+	"clusterrolebinding":             true,
+	"csidriver":                      true,
+	"csinode":                        true,
+	"customresourcedefinition":       true,
+	"mutatingwebhookconfiguration":   true,
+	"namespace":                      true,
+	"node":                           true,
+	"persistentvolume":               true,
+	"podsecuritypolicy":              true,
+	"priorityclass":                  true,
+	"runtimeclass":                   true,
+	"storageclass":                   true,
+	"validatingwebhookconfiguration": true,
+	"volumeattachment":               true,
+}
+
+// isClusterScoped returns true for kinds without namespace. Kinds that cannot be
+// resolved, e.g. without connection to the cluster, are considered namespaced.
+func isClusterScoped(apiVersion string, kind string) bool {
+	if clusterScopedKinds[strings.ToLower(kind)] {
+		return true
+	}
+	_, namespaced, err := kube.GroupVersionResource(apiVersion, kind)
+	return err == nil && !namespaced
+}
+
+// ParseReleaseManifest returns objects from the output of `helm get manifest` or `helm get hooks`.
+// Namespaced objects without namespace get defaultNamespace, namespace of cluster-scoped objects is empty.
+func ParseReleaseManifest(manifest string, defaultNamespace string) ([]ReleaseResource, error) {
+	res := make([]ReleaseResource, 0)
+
+	for _, doc := range manifestDocumentSeparator.Split(manifest, -1) {
+		if strings.TrimSpace(doc) == "" {
+			continue
+		}
+
+		var obj struct {
+			ApiVersion string `yaml:"apiVersion"`
+			Kind       string `yaml:"kind"`
+			Metadata   struct {
+				Name        string            `yaml:"name"`
+				Namespace   string            `yaml:"namespace"`
+				Annotations map[string]string `yaml:"annotations"`
+			} `yaml:"metadata"`
+		}
+
+		if err := yaml.Unmarshal([]byte(doc), &obj); err != nil {
+			return nil, fmt.Errorf("bad manifest document: %s\n%s", err, doc)
+		}
+
+		// comments only or empty templates
+		if obj.Kind == "" {
+			continue
+		}
+
+		resource := ReleaseResource{
+			ApiVersion: obj.ApiVersion,
+			Kind:       obj.Kind,
+			Namespace:  obj.Metadata.Namespace,
+			Name:       obj.Metadata.Name,
+			Hook:       obj.Metadata.Annotations[HookAnnotation],
+		}
+		if isClusterScoped(resource.ApiVersion, resource.Kind) {
+			resource.Namespace = ""
+		} else if resource.Namespace == "" {
+			resource.Namespace = defaultNamespace
+		}
+
+		res = append(res, resource)
+	}
+
+	return res, nil
+}
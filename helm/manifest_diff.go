@@ -0,0 +1,143 @@
+package helm
+
+import (
+	"bytes"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/go-yaml/yaml"
+
+	"github.com/flant/antiopa/utils"
+)
+
+// Changes of objects in DiffManifests
+const (
+	ResourceAdded   = "added"
+	ResourceRemoved = "removed"
+	ResourceChanged = "changed"
+)
+
+// ResourceDiff is a change of one object between the release manifest and the rendered manifest
+type ResourceDiff struct {
+	Resource string `json:"resource"`
+	Change   string `json:"change"`
+	// lines of the object yaml prefixed with '-', '+' or ' ', only for changed objects
+	Diff string `json:"diff,omitempty"`
+}
+
+// DiffManifests compares objects of the release manifest and the rendered manifest. Objects are
+// compared after yaml normalization, so formatting and order of keys are not changes.
+// Data of Secrets is replaced with checksums. Result is sorted by objects.
+func DiffManifests(releaseManifest string, renderedManifest string) ([]ResourceDiff, error) {
+	releaseObjects, err := manifestObjectsByKey(releaseManifest)
+	if err != nil {
+		return nil, fmt.Errorf("release manifest: %s", err)
+	}
+	renderedObjects, err := manifestObjectsByKey(renderedManifest)
+	if err != nil {
+		return nil, fmt.Errorf("rendered manifest: %s", err)
+	}
+
+	res := make([]ResourceDiff, 0)
+	for key, rendered := range renderedObjects {
+		released, isReleased := releaseObjects[key]
+		if !isReleased {
+			res = append(res, ResourceDiff{Resource: key, Change: ResourceAdded})
+			continue
+		}
+		if released != rendered {
+			res = append(res, ResourceDiff{Resource: key, Change: ResourceChanged, Diff: diffLines(released, rendered)})
+		}
+	}
+	for key := range releaseObjects {
+		if _, isRendered := renderedObjects[key]; !isRendered {
+			res = append(res, ResourceDiff{Resource: key, Change: ResourceRemoved})
+		}
+	}
+
+	sort.Slice(res, func(i, j int) bool {
+		return res[i].Resource < res[j].Resource
+	})
+	return res, nil
+}
+
+// manifestObjectsByKey returns normalized yaml of objects by namespace/kind/name
+func manifestObjectsByKey(manifest string) (map[string]string, error) {
+	objects, err := parseManifestObjects(manifest)
+	if err != nil {
+		return nil, err
+	}
+
+	res := make(map[string]string, len(objects))
+	for _, obj := range objects {
+		if obj["kind"] == "Secret" {
+			maskSecretData(obj)
+		}
+		data, err := yaml.Marshal(obj)
+		if err != nil {
+			return nil, err
+		}
+		metadata, _ := obj["metadata"].(map[interface{}]interface{})
+		key := fmt.Sprintf("%v/%v", obj["kind"], metadata["name"])
+		if namespace, ok := metadata["namespace"].(string); ok && namespace != "" {
+			key = namespace + "/" + key
+		}
+		res[key] = string(data)
+	}
+	return res, nil
+}
+
+// maskSecretData replaces values of Secret data with checksums, changes are still visible
+func maskSecretData(obj manifestObject) {
+	for _, field := range []string{"data", "stringData"} {
+		data, ok := obj[field].(map[interface{}]interface{})
+		if !ok {
+			continue
+		}
+		for key, value := range data {
+			data[key] = fmt.Sprintf("<checksum %s>", utils.CalculateChecksum(fmt.Sprintf("%v", value)))
+		}
+	}
+}
+
+// diffLines returns a line diff of texts based on the longest common subsequence
+func diffLines(a string, b string) string {
+	aLines := strings.Split(strings.TrimSuffix(a, "\n"), "\n")
+	bLines := strings.Split(strings.TrimSuffix(b, "\n"), "\n")
+
+	// lcs[i][j] is a length of LCS of aLines[i:] and bLines[j:]
+	lcs := make([][]int, len(aLines)+1)
+	for i := range lcs {
+		lcs[i] = make([]int, len(bLines)+1)
+	}
+	for i := len(aLines) - 1; i >= 0; i-- {
+		for j := len(bLines) - 1; j >= 0; j-- {
+			if aLines[i] == bLines[j] {
+				lcs[i][j] = lcs[i+1][j+1] + 1
+			} else if lcs[i+1][j] >= lcs[i][j+1] {
+				lcs[i][j] = lcs[i+1][j]
+			} else {
+				lcs[i][j] = lcs[i][j+1]
+			}
+		}
+	}
+
+	var res bytes.Buffer
+	i, j := 0, 0
+	for i < len(aLines) || j < len(bLines) {
+		switch {
+		case i < len(aLines) && j < len(bLines) && aLines[i] == bLines[j]:
+			res.WriteString(" " + aLines[i] + "\n")
+			i++
+			j++
+		case j < len(bLines) && (i == len(aLines) || lcs[i][j+1] > lcs[i+1][j]):
+			res.WriteString("+" + bLines[j] + "\n")
+			j++
+		default:
+			res.WriteString("-" + aLines[i] + "\n")
+			i++
+		}
+	}
+	return res.String()
+}
@@ -0,0 +1,87 @@
+package helm
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDiffManifests(t *testing.T) {
+	releaseManifest := `
+---
+apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: unchanged
+data:
+  a: "1"
+  b: "2"
+---
+apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: changed
+  namespace: kube-system
+data:
+  a: "1"
+---
+apiVersion: v1
+kind: Secret
+metadata:
+  name: removed
+data:
+  password: c2VjcmV0
+`
+	renderedManifest := `
+---
+# Source: test/templates/cm.yaml
+apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: unchanged
+data:
+  b: "2"
+  a: "1"
+---
+apiVersion: v1
+kind: ConfigMap
+metadata:
+  namespace: kube-system
+  name: changed
+data:
+  a: "2"
+---
+apiVersion: v1
+kind: Service
+metadata:
+  name: added
+`
+
+	diffs, err := DiffManifests(releaseManifest, renderedManifest)
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	assert.Equal(t, []ResourceDiff{
+		{Resource: "Secret/removed", Change: ResourceRemoved},
+		{Resource: "Service/added", Change: ResourceAdded},
+		{
+			Resource: "kube-system/ConfigMap/changed",
+			Change:   ResourceChanged,
+			Diff:     " apiVersion: v1\n data:\n-  a: \"1\"\n+  a: \"2\"\n kind: ConfigMap\n metadata:\n   name: changed\n   namespace: kube-system\n",
+		},
+	}, diffs)
+}
+
+func TestDiffManifests_SecretDataIsMasked(t *testing.T) {
+	diffs, err := DiffManifests(
+		"apiVersion: v1\nkind: Secret\nmetadata:\n  name: creds\nstringData:\n  password: old-password\n",
+		"apiVersion: v1\nkind: Secret\nmetadata:\n  name: creds\nstringData:\n  password: new-password\n",
+	)
+	if !assert.NoError(t, err) || !assert.Len(t, diffs, 1) {
+		return
+	}
+	assert.Equal(t, ResourceChanged, diffs[0].Change)
+	assert.NotContains(t, diffs[0].Diff, "old-password")
+	assert.NotContains(t, diffs[0].Diff, "new-password")
+}
@@ -0,0 +1,186 @@
+package helm
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/go-yaml/yaml"
+	"k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+)
+
+// ResourcesTotals is a sum of resources requests and limits of pods declared in a release manifest.
+// Pods of workloads are multiplied by replicas, pods of DaemonSets are counted separately
+// because they are multiplied by the number of nodes. Hook resources are not counted.
+type ResourcesTotals struct {
+	Pods     int             `json:"pods"`
+	Requests v1.ResourceList `json:"requests"`
+	Limits   v1.ResourceList `json:"limits"`
+
+	PerNodePods     int             `json:"perNodePods"`
+	PerNodeRequests v1.ResourceList `json:"perNodeRequests"`
+	PerNodeLimits   v1.ResourceList `json:"perNodeLimits"`
+}
+
+type manifestContainer struct {
+	Resources struct {
+		Requests map[string]string `yaml:"requests"`
+		Limits   map[string]string `yaml:"limits"`
+	} `yaml:"resources"`
+}
+
+type manifestPodSpec struct {
+	Containers     []manifestContainer `yaml:"containers"`
+	InitContainers []manifestContainer `yaml:"initContainers"`
+}
+
+type manifestPodTemplate struct {
+	Spec manifestPodSpec `yaml:"spec"`
+}
+
+type manifestWorkload struct {
+	Kind     string `yaml:"kind"`
+	Metadata struct {
+		Annotations map[string]string `yaml:"annotations"`
+	} `yaml:"metadata"`
+	Spec struct {
+		// Pod
+		manifestPodSpec `yaml:",inline"`
+		// Deployment, StatefulSet, ReplicaSet, Job
+		Replicas    *int                `yaml:"replicas"`
+		Parallelism *int                `yaml:"parallelism"`
+		Template    manifestPodTemplate `yaml:"template"`
+		// CronJob
+		JobTemplate struct {
+			Spec struct {
+				Parallelism *int                `yaml:"parallelism"`
+				Template    manifestPodTemplate `yaml:"template"`
+			} `yaml:"spec"`
+		} `yaml:"jobTemplate"`
+	} `yaml:"spec"`
+}
+
+// ManifestResourcesTotals returns resources of pods declared in the manifest
+func ManifestResourcesTotals(manifest string) (*ResourcesTotals, error) {
+	totals := &ResourcesTotals{
+		Requests:        v1.ResourceList{},
+		Limits:          v1.ResourceList{},
+		PerNodeRequests: v1.ResourceList{},
+		PerNodeLimits:   v1.ResourceList{},
+	}
+
+	for _, doc := range manifestDocumentSeparator.Split(manifest, -1) {
+		if strings.TrimSpace(doc) == "" {
+			continue
+		}
+
+		var obj manifestWorkload
+		if err := yaml.Unmarshal([]byte(doc), &obj); err != nil {
+			return nil, fmt.Errorf("bad manifest document: %s\n%s", err, doc)
+		}
+		if obj.Metadata.Annotations[HookAnnotation] != "" {
+			continue
+		}
+
+		var spec manifestPodSpec
+		pods := 1
+		switch obj.Kind {
+		case "Pod":
+			spec = obj.Spec.manifestPodSpec
+		case "Deployment", "StatefulSet", "ReplicaSet", "ReplicationController":
+			spec = obj.Spec.Template.Spec
+			if obj.Spec.Replicas != nil {
+				pods = *obj.Spec.Replicas
+			}
+		case "Job":
+			spec = obj.Spec.Template.Spec
+			if obj.Spec.Parallelism != nil {
+				pods = *obj.Spec.Parallelism
+			}
+		case "CronJob":
+			spec = obj.Spec.JobTemplate.Spec.Template.Spec
+			if obj.Spec.JobTemplate.Spec.Parallelism != nil {
+				pods = *obj.Spec.JobTemplate.Spec.Parallelism
+			}
+		case "DaemonSet":
+			spec = obj.Spec.Template.Spec
+		default:
+			continue
+		}
+
+		requests, limits, err := podResources(spec)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %s", obj.Kind, err)
+		}
+
+		if obj.Kind == "DaemonSet" {
+			totals.PerNodePods++
+			addResources(totals.PerNodeRequests, requests, 1)
+			addResources(totals.PerNodeLimits, limits, 1)
+			continue
+		}
+		totals.Pods += pods
+		addResources(totals.Requests, requests, pods)
+		addResources(totals.Limits, limits, pods)
+	}
+
+	return totals, nil
+}
+
+// podResources returns effective resources of pod: the sum of containers or the maximum of init containers
+func podResources(spec manifestPodSpec) (v1.ResourceList, v1.ResourceList, error) {
+	requests, limits := v1.ResourceList{}, v1.ResourceList{}
+	for _, container := range spec.Containers {
+		if err := addQuantities(requests, container.Resources.Requests); err != nil {
+			return nil, nil, err
+		}
+		if err := addQuantities(limits, container.Resources.Limits); err != nil {
+			return nil, nil, err
+		}
+	}
+
+	for _, container := range spec.InitContainers {
+		initRequests, initLimits := v1.ResourceList{}, v1.ResourceList{}
+		if err := addQuantities(initRequests, container.Resources.Requests); err != nil {
+			return nil, nil, err
+		}
+		if err := addQuantities(initLimits, container.Resources.Limits); err != nil {
+			return nil, nil, err
+		}
+		maxResources(requests, initRequests)
+		maxResources(limits, initLimits)
+	}
+
+	return requests, limits, nil
+}
+
+func addQuantities(list v1.ResourceList, quantities map[string]string) error {
+	for name, value := range quantities {
+		quantity, err := resource.ParseQuantity(value)
+		if err != nil {
+			return fmt.Errorf("bad quantity '%s' of '%s': %s", value, name, err)
+		}
+		sum := list[v1.ResourceName(name)]
+		sum.Add(quantity)
+		list[v1.ResourceName(name)] = sum
+	}
+	return nil
+}
+
+func addResources(list v1.ResourceList, add v1.ResourceList, times int) {
+	for name, quantity := range add {
+		sum := list[name]
+		for i := 0; i < times; i++ {
+			sum.Add(quantity)
+		}
+		list[name] = sum
+	}
+}
+
+func maxResources(list v1.ResourceList, other v1.ResourceList) {
+	for name, quantity := range other {
+		if current, ok := list[name]; !ok || quantity.Cmp(current) > 0 {
+			list[name] = quantity
+		}
+	}
+}
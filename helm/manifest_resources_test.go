@@ -0,0 +1,91 @@
+package helm
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"k8s.io/api/core/v1"
+)
+
+func TestManifestResourcesTotals(t *testing.T) {
+	manifest := `
+---
+apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: web
+spec:
+  replicas: 3
+  template:
+    spec:
+      initContainers:
+      - name: migrate
+        resources:
+          requests:
+            memory: 1Gi
+      containers:
+      - name: app
+        resources:
+          requests:
+            cpu: 100m
+            memory: 128Mi
+          limits:
+            memory: 256Mi
+      - name: sidecar
+        resources:
+          requests:
+            cpu: 50m
+            memory: 64Mi
+---
+apiVersion: apps/v1
+kind: DaemonSet
+metadata:
+  name: agent
+spec:
+  template:
+    spec:
+      containers:
+      - name: agent
+        resources:
+          requests:
+            cpu: 10m
+---
+apiVersion: batch/v1
+kind: Job
+metadata:
+  name: upgrade
+  annotations:
+    helm.sh/hook: pre-upgrade
+spec:
+  template:
+    spec:
+      containers:
+      - name: upgrade
+        resources:
+          requests:
+            cpu: "2"
+---
+apiVersion: v1
+kind: Service
+metadata:
+  name: web
+`
+
+	totals, err := ManifestResourcesTotals(manifest)
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	assert.Equal(t, 3, totals.Pods)
+	cpu := totals.Requests[v1.ResourceCPU]
+	assert.Equal(t, int64(450), cpu.MilliValue())
+	// init container requests more memory than containers
+	memory := totals.Requests[v1.ResourceMemory]
+	assert.Equal(t, int64(3*1024*1024*1024), memory.Value())
+	memoryLimit := totals.Limits[v1.ResourceMemory]
+	assert.Equal(t, int64(3*256*1024*1024), memoryLimit.Value())
+
+	assert.Equal(t, 1, totals.PerNodePods)
+	perNodeCpu := totals.PerNodeRequests[v1.ResourceCPU]
+	assert.Equal(t, int64(10), perNodeCpu.MilliValue())
+}
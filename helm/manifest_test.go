@@ -0,0 +1,42 @@
+package helm
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseReleaseManifest(t *testing.T) {
+	manifest := `
+---
+# Source: test/templates/cm.yaml
+apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: test-cm
+---
+# Source: test/templates/empty.yaml
+---
+apiVersion: extensions/v1beta1
+kind: Deployment
+metadata:
+  name: test-deploy
+  namespace: other
+---
+apiVersion: rbac.authorization.k8s.io/v1
+kind: ClusterRole
+metadata:
+  name: test-role
+`
+
+	resources, err := ParseReleaseManifest(manifest, "antiopa")
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	assert.Equal(t, []ReleaseResource{
+		{ApiVersion: "v1", Kind: "ConfigMap", Namespace: "antiopa", Name: "test-cm"},
+		{ApiVersion: "extensions/v1beta1", Kind: "Deployment", Namespace: "other", Name: "test-deploy"},
+		{ApiVersion: "rbac.authorization.k8s.io/v1", Kind: "ClusterRole", Namespace: "", Name: "test-role"},
+	}, resources)
+}
@@ -0,0 +1,106 @@
+package helm
+
+import (
+	"strconv"
+	"sync"
+
+	"github.com/romana/rlog"
+	"k8s.io/api/core/v1"
+)
+
+// ManualReleaseOperation is a release revision created not by antiopa,
+// e.g. by `helm upgrade` or `helm rollback` of an admin.
+type ManualReleaseOperation struct {
+	Release         string
+	Revision        int
+	Status          string
+	TillerNamespace string
+	// tiller ConfigMap with the release revision
+	ConfigMap string
+}
+
+// ManualReleaseOperations receives revisions created not by antiopa
+var ManualReleaseOperations = make(chan ManualReleaseOperation, 100)
+
+// releaseOperations tracks helm operations of antiopa to distinguish
+// its revisions from revisions created by others.
+type releaseOperations struct {
+	m sync.Mutex
+	// number of running operations for release
+	inProgress map[string]int
+	// last revision known after operation of antiopa
+	revisions map[string]int
+}
+
+func newReleaseOperations() *releaseOperations {
+	return &releaseOperations{
+		inProgress: make(map[string]int),
+		revisions:  make(map[string]int),
+	}
+}
+
+func (o *releaseOperations) begin(releaseName string) {
+	o.m.Lock()
+	defer o.m.Unlock()
+	o.inProgress[releaseName]++
+}
+
+func (o *releaseOperations) end(releaseName string, revision int) {
+	o.m.Lock()
+	defer o.m.Unlock()
+	if revision > o.revisions[releaseName] {
+		o.revisions[releaseName] = revision
+	}
+	o.inProgress[releaseName]--
+	if o.inProgress[releaseName] <= 0 {
+		delete(o.inProgress, releaseName)
+	}
+}
+
+// isOwn returns true if revision is created while antiopa operation on release
+func (o *releaseOperations) isOwn(releaseName string, revision int) bool {
+	o.m.Lock()
+	defer o.m.Unlock()
+	return o.inProgress[releaseName] > 0 || revision <= o.revisions[releaseName]
+}
+
+// beginReleaseOperation marks release as changed by antiopa until returned func is called.
+// Returned func saves the last revision of release.
+func (helm *CliHelm) beginReleaseOperation(releaseName string) func() {
+	helm.operations.begin(releaseName)
+	return func() {
+		revision := 0
+		if lastRevision, _, err := helm.LastReleaseStatus(releaseName); err == nil {
+			revision, _ = strconv.Atoi(lastRevision)
+		}
+		helm.operations.end(releaseName, revision)
+	}
+}
+
+// detectManualOperation is called for new tiller ConfigMaps
+func (helm *CliHelm) detectManualOperation(cm *v1.ConfigMap) {
+	releaseName := cm.Labels["NAME"]
+	revision, err := strconv.Atoi(cm.Labels["VERSION"])
+	if releaseName == "" || err != nil {
+		return
+	}
+
+	if helm.operations.isOwn(releaseName, revision) {
+		return
+	}
+
+	rlog.Warnf("HELM release '%s' revision %d with status '%s' is created not by antiopa", releaseName, revision, cm.Labels["STATUS"])
+
+	operation := ManualReleaseOperation{
+		Release:         releaseName,
+		Revision:        revision,
+		Status:          cm.Labels["STATUS"],
+		TillerNamespace: helm.tillerNamespace,
+		ConfigMap:       cm.Name,
+	}
+	select {
+	case ManualReleaseOperations <- operation:
+	default:
+		rlog.Errorf("HELM release '%s' revision %d: manual operations queue is full", releaseName, revision)
+	}
+}
@@ -0,0 +1,25 @@
+package helm
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestReleaseOperations(t *testing.T) {
+	o := newReleaseOperations()
+
+	// no operations of antiopa yet
+	assert.False(t, o.isOwn("test", 1))
+
+	o.begin("test")
+	assert.True(t, o.isOwn("test", 1))
+	assert.True(t, o.isOwn("test", 2))
+	o.end("test", 2)
+
+	// event came after operation is finished
+	assert.True(t, o.isOwn("test", 2))
+	// manual upgrade
+	assert.False(t, o.isOwn("test", 3))
+	assert.False(t, o.isOwn("other", 1))
+}
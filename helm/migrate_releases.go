@@ -0,0 +1,479 @@
+package helm
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/go-yaml/yaml"
+	"github.com/golang/protobuf/proto"
+	"github.com/golang/protobuf/ptypes"
+	"github.com/golang/protobuf/ptypes/timestamp"
+	"github.com/romana/rlog"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	kblabels "k8s.io/apimachinery/pkg/labels"
+	chartpb "k8s.io/helm/pkg/proto/hapi/chart"
+	rspb "k8s.io/helm/pkg/proto/hapi/release"
+
+	"github.com/flant/antiopa/kube"
+	"github.com/flant/antiopa/utils"
+)
+
+// Type of secrets with helm 3 releases
+const Helm3ReleaseSecretType = "helm.sh/release.v1"
+
+var gzipMagic = []byte{0x1f, 0x8b, 0x08}
+
+// MigrateReleasesOptions are options of `antiopa migrate-releases`
+type MigrateReleasesOptions struct {
+	TillerNamespace string
+	// names of releases of modules, ConfigMaps of other releases in the tiller namespace are skipped
+	Releases []string
+	DryRun   bool
+	// delete tiller ConfigMaps after release is converted and validated
+	DeleteV2Releases bool
+}
+
+// MigratedRelease is a result of one release revision conversion
+type MigratedRelease struct {
+	Release  string
+	Revision int
+	Secret   string
+	Error    error
+}
+
+// helm3Release is a release in helm 3 storage format
+type helm3Release struct {
+	Name      string       `json:"name,omitempty"`
+	Info      *helm3Info   `json:"info,omitempty"`
+	Chart     *helm3Chart  `json:"chart,omitempty"`
+	Config    interface{}  `json:"config,omitempty"`
+	Manifest  string       `json:"manifest,omitempty"`
+	Hooks     []*helm3Hook `json:"hooks,omitempty"`
+	Version   int          `json:"version,omitempty"`
+	Namespace string       `json:"namespace,omitempty"`
+}
+
+type helm3Info struct {
+	FirstDeployed time.Time `json:"first_deployed,omitempty"`
+	LastDeployed  time.Time `json:"last_deployed,omitempty"`
+	Deleted       time.Time `json:"deleted"`
+	Description   string    `json:"description,omitempty"`
+	Status        string    `json:"status,omitempty"`
+	Notes         string    `json:"notes,omitempty"`
+}
+
+type helm3Chart struct {
+	Metadata  *helm3ChartMetadata `json:"metadata"`
+	Templates []*helm3File        `json:"templates"`
+	Values    interface{}         `json:"values"`
+	Files     []*helm3File        `json:"files"`
+	// subcharts from charts/ dir of helm 2 chart
+	Dependencies []*helm3Chart `json:"dependencies,omitempty"`
+}
+
+type helm3ChartMetadata struct {
+	Name        string   `json:"name,omitempty"`
+	Home        string   `json:"home,omitempty"`
+	Sources     []string `json:"sources,omitempty"`
+	Version     string   `json:"version,omitempty"`
+	Description string   `json:"description,omitempty"`
+	Keywords    []string `json:"keywords,omitempty"`
+	Icon        string   `json:"icon,omitempty"`
+	APIVersion  string   `json:"apiVersion,omitempty"`
+	AppVersion  string   `json:"appVersion,omitempty"`
+	Deprecated  bool     `json:"deprecated,omitempty"`
+	KubeVersion string   `json:"kubeVersion,omitempty"`
+	// subcharts are listed in metadata like in Chart.yaml of helm 3 charts
+	Dependencies []*helm3ChartDependency `json:"dependencies,omitempty"`
+}
+
+type helm3ChartDependency struct {
+	Name    string `json:"name"`
+	Version string `json:"version,omitempty"`
+}
+
+type helm3File struct {
+	Name string `json:"name"`
+	Data []byte `json:"data"`
+}
+
+type helm3Hook struct {
+	Name           string    `json:"name,omitempty"`
+	Kind           string    `json:"kind,omitempty"`
+	Path           string    `json:"path,omitempty"`
+	Manifest       string    `json:"manifest,omitempty"`
+	Events         []string  `json:"events,omitempty"`
+	LastRun        time.Time `json:"last_run,omitempty"`
+	Weight         int       `json:"weight,omitempty"`
+	DeletePolicies []string  `json:"delete_policies,omitempty"`
+}
+
+// MigrateReleases converts tiller ConfigMaps of options.Releases from the tiller namespace into helm 3
+// secrets in release namespaces. Each secret is read back and compared with the source release.
+func MigrateReleases(options MigrateReleasesOptions) ([]MigratedRelease, error) {
+	releases := make(map[string]bool)
+	for _, release := range options.Releases {
+		releases[release] = true
+	}
+
+	cmList, err := kube.KubernetesClient.CoreV1().
+		ConfigMaps(options.TillerNamespace).
+		List(metav1.ListOptions{LabelSelector: kblabels.Set{"OWNER": "TILLER"}.AsSelector().String()})
+	if err != nil {
+		return nil, fmt.Errorf("cannot list tiller ConfigMaps in namespace '%s': %s", options.TillerNamespace, err)
+	}
+
+	res := make([]MigratedRelease, 0)
+	for _, cm := range cmList.Items {
+		// tiller can be shared with releases that are not managed by antiopa
+		if !releases[cm.Labels["NAME"]] {
+			rlog.Debugf("MIGRATE skip ConfigMap '%s' of unknown release '%s'", cm.Name, cm.Labels["NAME"])
+			continue
+		}
+		migrated := MigratedRelease{Release: cm.Labels["NAME"]}
+		migrated.Revision, _ = strconv.Atoi(cm.Labels["VERSION"])
+		migrated.Secret, migrated.Error = migrateRelease(&cm, options)
+		res = append(res, migrated)
+	}
+
+	return res, nil
+}
+
+func migrateRelease(cm *v1.ConfigMap, options MigrateReleasesOptions) (string, error) {
+	v2Release, err := decodeV2Release(cm.Data["release"])
+	if err != nil {
+		return "", fmt.Errorf("cannot decode ConfigMap '%s': %s", cm.Name, err)
+	}
+
+	v3Release, err := convertV2Release(v2Release)
+	if err != nil {
+		return "", fmt.Errorf("cannot convert ConfigMap '%s': %s", cm.Name, err)
+	}
+
+	secret, err := helm3ReleaseSecret(v3Release)
+	if err != nil {
+		return "", err
+	}
+
+	if options.DryRun {
+		return secret.Name, nil
+	}
+
+	_, err = kube.KubernetesClient.CoreV1().Secrets(secret.Namespace).Create(secret)
+	if errors.IsAlreadyExists(err) {
+		rlog.Infof("MIGRATE secret '%s' already exists, validate it", secret.Name)
+	} else if err != nil {
+		return "", fmt.Errorf("cannot create secret '%s': %s", secret.Name, err)
+	}
+
+	if err := validateHelm3ReleaseSecret(secret.Namespace, secret.Name, v3Release); err != nil {
+		return "", err
+	}
+
+	if options.DeleteV2Releases {
+		err := kube.KubernetesClient.CoreV1().ConfigMaps(cm.Namespace).Delete(cm.Name, &metav1.DeleteOptions{})
+		if err != nil {
+			return "", fmt.Errorf("cannot delete ConfigMap '%s': %s", cm.Name, err)
+		}
+	}
+
+	return secret.Name, nil
+}
+
+func decodeV2Release(data string) (*rspb.Release, error) {
+	b, err := base64.StdEncoding.DecodeString(data)
+	if err != nil {
+		return nil, err
+	}
+	if len(b) > 3 && bytes.Equal(b[0:3], gzipMagic) {
+		r, err := gzip.NewReader(bytes.NewReader(b))
+		if err != nil {
+			return nil, err
+		}
+		if b, err = ioutil.ReadAll(r); err != nil {
+			return nil, err
+		}
+	}
+
+	release := &rspb.Release{}
+	if err := proto.Unmarshal(b, release); err != nil {
+		return nil, err
+	}
+	return release, nil
+}
+
+func encodeV3Release(release *helm3Release) (string, error) {
+	b, err := json.Marshal(release)
+	if err != nil {
+		return "", err
+	}
+	var buf bytes.Buffer
+	w, err := gzip.NewWriterLevel(&buf, gzip.BestCompression)
+	if err != nil {
+		return "", err
+	}
+	if _, err := w.Write(b); err != nil {
+		return "", err
+	}
+	w.Close()
+	return base64.StdEncoding.EncodeToString(buf.Bytes()), nil
+}
+
+func decodeV3Release(data string) (*helm3Release, error) {
+	b, err := base64.StdEncoding.DecodeString(data)
+	if err != nil {
+		return nil, err
+	}
+	r, err := gzip.NewReader(bytes.NewReader(b))
+	if err != nil {
+		return nil, err
+	}
+	if b, err = ioutil.ReadAll(r); err != nil {
+		return nil, err
+	}
+	release := &helm3Release{}
+	if err := json.Unmarshal(b, release); err != nil {
+		return nil, err
+	}
+	return release, nil
+}
+
+// convertV2Release maps helm 2 release into helm 3 format like helm-2to3 plugin does
+func convertV2Release(v2 *rspb.Release) (*helm3Release, error) {
+	v3 := &helm3Release{
+		Name:      v2.Name,
+		Manifest:  v2.Manifest,
+		Version:   int(v2.Version),
+		Namespace: v2.Namespace,
+		Info:      &helm3Info{},
+		Hooks:     make([]*helm3Hook, 0, len(v2.Hooks)),
+	}
+
+	if v2.Info != nil {
+		v3.Info.FirstDeployed = protoTime(v2.Info.FirstDeployed)
+		v3.Info.LastDeployed = protoTime(v2.Info.LastDeployed)
+		v3.Info.Deleted = protoTime(v2.Info.Deleted)
+		v3.Info.Description = v2.Info.Description
+		if v2.Info.Status != nil {
+			v3.Info.Status = helm3Status(v2.Info.Status.Code.String())
+			v3.Info.Notes = v2.Info.Status.Notes
+		}
+	}
+
+	if v2.Config != nil {
+		config, err := yamlToJsonValues(v2.Config.Raw)
+		if err != nil {
+			return nil, fmt.Errorf("bad release config: %s", err)
+		}
+		v3.Config = config
+	}
+
+	if v2.Chart != nil {
+		chart, err := convertV2Chart(v2.Chart)
+		if err != nil {
+			return nil, err
+		}
+		v3.Chart = chart
+	}
+
+	for _, h := range v2.Hooks {
+		hook := &helm3Hook{
+			Name:     h.Name,
+			Kind:     h.Kind,
+			Path:     h.Path,
+			Manifest: h.Manifest,
+			LastRun:  protoTime(h.LastRun),
+			Weight:   int(h.Weight),
+		}
+		for _, e := range h.Events {
+			hook.Events = append(hook.Events, helm3Enum(e.String()))
+		}
+		for _, p := range h.DeletePolicies {
+			hook.DeletePolicies = append(hook.DeletePolicies, helm3Enum(p.String()))
+		}
+		v3.Hooks = append(v3.Hooks, hook)
+	}
+
+	return v3, nil
+}
+
+// convertV2Chart maps helm 2 chart with its subcharts
+func convertV2Chart(v2 *chartpb.Chart) (*helm3Chart, error) {
+	chart := &helm3Chart{
+		Templates: make([]*helm3File, 0, len(v2.Templates)),
+		Files:     make([]*helm3File, 0, len(v2.Files)),
+	}
+	if m := v2.Metadata; m != nil {
+		chart.Metadata = &helm3ChartMetadata{
+			Name:        m.Name,
+			Home:        m.Home,
+			Sources:     m.Sources,
+			Version:     m.Version,
+			Description: m.Description,
+			Keywords:    m.Keywords,
+			Icon:        m.Icon,
+			APIVersion:  "v1",
+			AppVersion:  m.AppVersion,
+			Deprecated:  m.Deprecated,
+			KubeVersion: m.KubeVersion,
+		}
+	}
+	for _, t := range v2.Templates {
+		chart.Templates = append(chart.Templates, &helm3File{Name: t.Name, Data: t.Data})
+	}
+	for _, f := range v2.Files {
+		chart.Files = append(chart.Files, &helm3File{Name: f.TypeUrl, Data: f.Value})
+	}
+	if v2.Values != nil {
+		values, err := yamlToJsonValues(v2.Values.Raw)
+		if err != nil {
+			return nil, fmt.Errorf("bad chart values: %s", err)
+		}
+		chart.Values = values
+	}
+
+	for _, d := range v2.Dependencies {
+		dependency, err := convertV2Chart(d)
+		if err != nil {
+			return nil, fmt.Errorf("subchart: %s", err)
+		}
+		chart.Dependencies = append(chart.Dependencies, dependency)
+		if chart.Metadata != nil && dependency.Metadata != nil {
+			chart.Metadata.Dependencies = append(chart.Metadata.Dependencies, &helm3ChartDependency{
+				Name:    dependency.Metadata.Name,
+				Version: dependency.Metadata.Version,
+			})
+		}
+	}
+
+	return chart, nil
+}
+
+func helm3ReleaseSecret(release *helm3Release) (*v1.Secret, error) {
+	data, err := encodeV3Release(release)
+	if err != nil {
+		return nil, err
+	}
+
+	return &v1.Secret{
+		ObjectMeta: metav1.ObjectMeta{
+			Name:      fmt.Sprintf("sh.helm.release.v1.%s.v%d", release.Name, release.Version),
+			Namespace: release.Namespace,
+			Labels: map[string]string{
+				"name":    release.Name,
+				"owner":   "helm",
+				"status":  release.Info.Status,
+				"version": strconv.Itoa(release.Version),
+			},
+		},
+		Type: Helm3ReleaseSecretType,
+		Data: map[string][]byte{"release": []byte(data)},
+	}, nil
+}
+
+func validateHelm3ReleaseSecret(namespace string, name string, expected *helm3Release) error {
+	secret, err := kube.KubernetesClient.CoreV1().Secrets(namespace).Get(name, metav1.GetOptions{})
+	if err != nil {
+		return fmt.Errorf("cannot get secret '%s': %s", name, err)
+	}
+	release, err := decodeV3Release(string(secret.Data["release"]))
+	if err != nil {
+		return fmt.Errorf("cannot decode secret '%s': %s", name, err)
+	}
+	if release.Name != expected.Name || release.Version != expected.Version || release.Manifest != expected.Manifest {
+		return fmt.Errorf("secret '%s' does not match release '%s' revision %d", name, expected.Name, expected.Version)
+	}
+	// ConfigMap is deleted with -delete-v2-releases, so everything helm 3 uses should be the same.
+	// Expected release is encoded and decoded too to drop empty fields.
+	data, err := encodeV3Release(expected)
+	if err != nil {
+		return err
+	}
+	if expected, err = decodeV3Release(data); err != nil {
+		return err
+	}
+	fields := []struct {
+		name     string
+		actual   interface{}
+		expected interface{}
+	}{
+		{"info", release.Info, expected.Info},
+		{"chart", release.Chart, expected.Chart},
+		{"config", release.Config, expected.Config},
+		{"hooks", release.Hooks, expected.Hooks},
+	}
+	for _, field := range fields {
+		same, err := sameJson(field.actual, field.expected)
+		if err != nil {
+			return fmt.Errorf("cannot compare %s of secret '%s': %s", field.name, name, err)
+		}
+		if !same {
+			return fmt.Errorf("secret '%s' %s does not match release '%s' revision %d", name, field.name, expected.Name, expected.Version)
+		}
+	}
+	return nil
+}
+
+// sameJson compares values by their json
+func sameJson(a interface{}, b interface{}) (bool, error) {
+	aData, err := json.Marshal(a)
+	if err != nil {
+		return false, err
+	}
+	bData, err := json.Marshal(b)
+	if err != nil {
+		return false, err
+	}
+	return bytes.Equal(aData, bData), nil
+}
+
+// yamlToJsonValues converts yaml from helm 2 chart.Config into json compatible map
+func yamlToJsonValues(raw string) (map[string]interface{}, error) {
+	var values map[interface{}]interface{}
+	if err := yaml.Unmarshal([]byte(raw), &values); err != nil {
+		return nil, err
+	}
+	return utils.NormalizeValues(values)
+}
+
+func protoTime(ts *timestamp.Timestamp) time.Time {
+	if ts == nil {
+		return time.Time{}
+	}
+	t, err := ptypes.Timestamp(ts)
+	if err != nil {
+		return time.Time{}
+	}
+	return t
+}
+
+// helm3Status maps DEPLOYED into deployed, DELETED into uninstalled, etc.
+func helm3Status(v2Status string) string {
+	switch v2Status {
+	case "DELETED":
+		return "uninstalled"
+	case "DELETING":
+		return "uninstalling"
+	case "PENDING_INSTALL":
+		return "pending-install"
+	case "PENDING_UPGRADE":
+		return "pending-upgrade"
+	case "PENDING_ROLLBACK":
+		return "pending-rollback"
+	}
+	return strings.ToLower(v2Status)
+}
+
+// helm3Enum maps PRE_INSTALL into pre-install
+func helm3Enum(v2Value string) string {
+	return strings.Replace(strings.ToLower(v2Value), "_", "-", -1)
+}
@@ -0,0 +1,163 @@
+package helm
+
+import (
+	"encoding/base64"
+	"testing"
+
+	"github.com/golang/protobuf/proto"
+	"github.com/stretchr/testify/assert"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+	chartpb "k8s.io/helm/pkg/proto/hapi/chart"
+	rspb "k8s.io/helm/pkg/proto/hapi/release"
+
+	"github.com/flant/antiopa/kube"
+)
+
+func TestConvertV2Release(t *testing.T) {
+	v2 := &rspb.Release{
+		Name:      "test",
+		Namespace: "antiopa",
+		Version:   3,
+		Manifest:  "---\nkind: ConfigMap\n",
+		Info: &rspb.Info{
+			Status:      &rspb.Status{Code: rspb.Status_DEPLOYED},
+			Description: "Upgrade complete",
+		},
+		Config: &chartpb.Config{Raw: "replicas: 2\nimage:\n  tag: v1\n"},
+		Chart: &chartpb.Chart{
+			Metadata:  &chartpb.Metadata{Name: "test", Version: "0.1.0"},
+			Templates: []*chartpb.Template{{Name: "templates/cm.yaml", Data: []byte("kind: ConfigMap")}},
+			Dependencies: []*chartpb.Chart{{
+				Metadata:     &chartpb.Metadata{Name: "redis", Version: "3.0.0"},
+				Templates:    []*chartpb.Template{{Name: "templates/sts.yaml", Data: []byte("kind: StatefulSet")}},
+				Values:       &chartpb.Config{Raw: "port: 6379\n"},
+				Dependencies: []*chartpb.Chart{{Metadata: &chartpb.Metadata{Name: "common", Version: "0.0.1"}}},
+			}},
+		},
+		Hooks: []*rspb.Hook{{
+			Name:   "test-hook",
+			Events: []rspb.Hook_Event{rspb.Hook_PRE_INSTALL},
+		}},
+	}
+
+	v3, err := convertV2Release(v2)
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.Equal(t, "deployed", v3.Info.Status)
+	assert.Equal(t, map[string]interface{}{"replicas": 2.0, "image": map[string]interface{}{"tag": "v1"}}, v3.Config)
+	assert.Equal(t, "v1", v3.Chart.Metadata.APIVersion)
+	assert.Equal(t, []string{"pre-install"}, v3.Hooks[0].Events)
+
+	// subcharts are converted recursively
+	if assert.Len(t, v3.Chart.Dependencies, 1) {
+		redis := v3.Chart.Dependencies[0]
+		assert.Equal(t, "redis", redis.Metadata.Name)
+		assert.Equal(t, "templates/sts.yaml", redis.Templates[0].Name)
+		assert.Equal(t, map[string]interface{}{"port": 6379.0}, redis.Values)
+		assert.Equal(t, []*helm3ChartDependency{{Name: "common", Version: "0.0.1"}}, redis.Metadata.Dependencies)
+	}
+	assert.Equal(t, []*helm3ChartDependency{{Name: "redis", Version: "3.0.0"}}, v3.Chart.Metadata.Dependencies)
+
+	secret, err := helm3ReleaseSecret(v3)
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.Equal(t, "sh.helm.release.v1.test.v3", secret.Name)
+	assert.Equal(t, "deployed", secret.Labels["status"])
+
+	decoded, err := decodeV3Release(string(secret.Data["release"]))
+	if assert.NoError(t, err) {
+		assert.Equal(t, v3.Manifest, decoded.Manifest)
+		assert.Equal(t, v3.Version, decoded.Version)
+	}
+}
+
+func TestMigrateReleases(t *testing.T) {
+	defer func(c kube.Client) { kube.KubernetesClient = c }(kube.KubernetesClient)
+
+	configMap := func(name string, config string) *v1.ConfigMap {
+		release := &rspb.Release{
+			Name:      name,
+			Namespace: "default",
+			Version:   1,
+			Manifest:  "---\nkind: ConfigMap\n",
+			Info:      &rspb.Info{Status: &rspb.Status{Code: rspb.Status_DEPLOYED}},
+			Config:    &chartpb.Config{Raw: config},
+			Chart:     &chartpb.Chart{Metadata: &chartpb.Metadata{Name: name, Version: "0.1.0"}},
+		}
+		data, err := proto.Marshal(release)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return &v1.ConfigMap{
+			ObjectMeta: metav1.ObjectMeta{
+				Name:      name + ".v1",
+				Namespace: "antiopa",
+				Labels:    map[string]string{"OWNER": "TILLER", "NAME": name, "VERSION": "1"},
+			},
+			Data: map[string]string{"release": base64.StdEncoding.EncodeToString(data)},
+		}
+	}
+
+	// secret of nginx was created by previous run with other values
+	nginxV3, err := convertV2Release(&rspb.Release{
+		Name: "nginx", Namespace: "default", Version: 1, Manifest: "---\nkind: ConfigMap\n",
+		Info:   &rspb.Info{Status: &rspb.Status{Code: rspb.Status_DEPLOYED}},
+		Config: &chartpb.Config{Raw: "replicas: 1\n"},
+		Chart:  &chartpb.Chart{Metadata: &chartpb.Metadata{Name: "nginx", Version: "0.1.0"}},
+	})
+	if !assert.NoError(t, err) {
+		return
+	}
+	nginxSecret, err := helm3ReleaseSecret(nginxV3)
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	clientset := fake.NewSimpleClientset(
+		configMap("prometheus", "retention: 7d\n"),
+		configMap("nginx", "replicas: 2\n"),
+		configMap("user-app", "replicas: 3\n"),
+		nginxSecret,
+	)
+	kube.KubernetesClient = clientset
+
+	results, err := MigrateReleases(MigrateReleasesOptions{
+		TillerNamespace:  "antiopa",
+		Releases:         []string{"nginx", "prometheus"},
+		DeleteV2Releases: true,
+	})
+	if !assert.NoError(t, err) || !assert.Len(t, results, 2) {
+		return
+	}
+	for _, res := range results {
+		switch res.Release {
+		case "prometheus":
+			assert.NoError(t, res.Error)
+			assert.Equal(t, "sh.helm.release.v1.prometheus.v1", res.Secret)
+		case "nginx":
+			assert.EqualError(t, res.Error, "secret 'sh.helm.release.v1.nginx.v1' config does not match release 'nginx' revision 1")
+		default:
+			t.Errorf("unexpected release %s", res.Release)
+		}
+	}
+
+	// ConfigMaps of not validated and unknown releases are kept
+	cms, err := clientset.CoreV1().ConfigMaps("antiopa").List(metav1.ListOptions{})
+	if assert.NoError(t, err) {
+		names := make([]string, 0)
+		for _, cm := range cms.Items {
+			names = append(names, cm.Name)
+		}
+		assert.ElementsMatch(t, []string{"nginx.v1", "user-app.v1"}, names)
+	}
+}
+
+func TestHelm3Status(t *testing.T) {
+	assert.Equal(t, "superseded", helm3Status("SUPERSEDED"))
+	assert.Equal(t, "uninstalled", helm3Status("DELETED"))
+	assert.Equal(t, "pending-upgrade", helm3Status("PENDING_UPGRADE"))
+}
@@ -0,0 +1,315 @@
+package helm
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/romana/rlog"
+	"k8s.io/helm/pkg/chartutil"
+	helmclient "k8s.io/helm/pkg/helm"
+	rspb "k8s.io/helm/pkg/proto/hapi/release"
+	"k8s.io/helm/pkg/strvals"
+
+	"github.com/flant/antiopa/failure_injection"
+	"github.com/flant/antiopa/utils"
+)
+
+const (
+	CliClient    = "cli"
+	NativeClient = "native"
+)
+
+// ClientType selects the implementation of HelmClient for the cluster of antiopa:
+// CliClient runs /usr/local/bin/helm and parses its output, NativeClient talks to tiller
+// over gRPC with helm Go packages. Releases of remote clusters are always managed with CLI.
+var ClientType = CliClient
+
+// TillerPort is a gRPC port of tiller-deploy Service created by helm init
+const TillerPort = 44134
+
+// native client waits for the release operation as long as helm CLI does by default
+const nativeOperationTimeout = 300
+
+// NativeHelm implements release operations with gRPC calls to tiller, so results are
+// structured and errors are typed. Other operations, e.g. helm template and helm test,
+// are delegated to CliHelm.
+type NativeHelm struct {
+	*CliHelm
+	tillerHost string
+	client     helmclient.Interface
+}
+
+// CheckClientType returns an error for unknown ClientType
+func CheckClientType() error {
+	switch ClientType {
+	case CliClient, NativeClient:
+		return nil
+	}
+	return fmt.Errorf("unknown helm client '%s', expected '%s' or '%s'", ClientType, CliClient, NativeClient)
+}
+
+// tillerHost returns an address of tiller: HELM_HOST, embedded tiller or tiller-deploy Service
+func (helm *CliHelm) tillerHost() string {
+	if host := os.Getenv("HELM_HOST"); host != "" {
+		return host
+	}
+	if helm.embeddedTiller != nil {
+		return helm.embeddedTiller.ListenAddress
+	}
+	return fmt.Sprintf("tiller-deploy.%s:%d", helm.tillerNamespace, TillerPort)
+}
+
+// newNativeHelm returns a native client that uses cli for other operations.
+// Connection to tiller is checked.
+func newNativeHelm(cli *CliHelm) (*NativeHelm, error) {
+	helm := &NativeHelm{CliHelm: cli, tillerHost: cli.tillerHost()}
+	helm.client = helmclient.NewClient(helmclient.Host(helm.tillerHost), helmclient.ConnectTimeout(5))
+
+	version, err := helm.client.GetVersion()
+	if err != nil {
+		return nil, fmt.Errorf("cannot connect to tiller at '%s': %s", helm.tillerHost, err)
+	}
+	rlog.Infof("Helm: native client is connected to tiller %s at '%s'", version.GetVersion().GetSemVer(), helm.tillerHost)
+
+	return helm, nil
+}
+
+// nativeError converts errors of tiller to ErrReleaseNotFound and ErrOperationInProgress
+func nativeError(releaseName string, err error) error {
+	if err == nil {
+		return nil
+	}
+	// tiller errors are gRPC errors with a description like 'release: "name" not found'
+	msg := err.Error()
+	if strings.Contains(msg, fmt.Sprintf("release: %q not found", releaseName)) {
+		return &ErrReleaseNotFound{Release: releaseName, Output: msg}
+	}
+	if isOperationInProgressOutput(msg) {
+		return &ErrOperationInProgress{Release: releaseName, Output: msg}
+	}
+	return err
+}
+
+// releaseCall runs the operation on the release under the release lock. ErrOperationInProgress
+// is returned at once if another operation is in progress in tiller, as in CliHelm.releaseCmd.
+func (helm *NativeHelm) releaseCall(releaseName string, operation string, call func() error) error {
+	defer helm.lockRelease(releaseName)()
+
+	if err := failure_injection.Take(failure_injection.HelmFailure, operation, releaseName); err != nil {
+		return err
+	}
+
+	err := nativeError(releaseName, call())
+	if IsOperationInProgress(err) {
+		rlog.Warnf("helm release '%s': another operation is in progress in tiller, %s is not run", releaseName, operation)
+	}
+	return err
+}
+
+// lastRelease returns the last revision of the release from tiller
+func (helm *NativeHelm) lastRelease(releaseName string) (*rspb.Release, error) {
+	res, err := helm.client.ReleaseHistory(releaseName, helmclient.WithMaxHistory(1))
+	if err != nil {
+		return nil, nativeError(releaseName, err)
+	}
+	if len(res.GetReleases()) == 0 {
+		return nil, &ErrReleaseNotFound{Release: releaseName}
+	}
+	return res.GetReleases()[0], nil
+}
+
+func (helm *NativeHelm) LastReleaseStatus(releaseName string) (revision string, status string, err error) {
+	release, err := helm.lastRelease(releaseName)
+	if err != nil {
+		if IsReleaseNotFound(err) {
+			return "0", "", err
+		}
+		return "", "", fmt.Errorf("cannot get history for release '%s': %s", releaseName, err)
+	}
+	return fmt.Sprintf("%d", release.GetVersion()), release.GetInfo().GetStatus().GetCode().String(), nil
+}
+
+func (helm *NativeHelm) IsReleaseExists(releaseName string) (bool, error) {
+	_, _, err := helm.LastReleaseStatus(releaseName)
+	if IsReleaseNotFound(err) {
+		return false, nil
+	} else if err != nil {
+		return false, err
+	}
+	return true, nil
+}
+
+// UpgradeRelease installs or upgrades the release from the chart directory. Values files
+// and set values are merged in the same order as helm CLI merges them.
+func (helm *NativeHelm) UpgradeRelease(releaseName string, chart string, valuesPaths []string, setValues []SetValue, namespace string, reuseValues bool) (*ReleaseUpgradeResult, error) {
+	// values are sent in one message, so chunks are not needed, only sizes are checked
+	valuesPaths, cleanupValuesChunks, err := prepareValuesFiles(releaseName, valuesPaths)
+	defer cleanupValuesChunks()
+	if err != nil {
+		return nil, err
+	}
+
+	rawValues, err := nativeValues(valuesPaths, setValues)
+	if err != nil {
+		return nil, fmt.Errorf("helm release '%s': %s", releaseName, err)
+	}
+
+	chartRequested, err := chartutil.Load(chart)
+	if err != nil {
+		return nil, fmt.Errorf("cannot load chart '%s': %s", chart, err)
+	}
+
+	exists, err := helm.IsReleaseExists(releaseName)
+	if err != nil {
+		return nil, err
+	}
+
+	rlog.Infof("Running native helm upgrade for release '%s' with chart '%s' in namespace '%s' ...", releaseName, chart, namespace)
+	if helm.operations != nil {
+		defer helm.beginReleaseOperation(releaseName)()
+	}
+
+	var release *rspb.Release
+	err = helm.releaseCall(releaseName, "upgrade", func() error {
+		if !exists {
+			res, err := helm.client.InstallReleaseFromChart(chartRequested, namespace,
+				helmclient.ReleaseName(releaseName),
+				helmclient.ValueOverrides(rawValues),
+				helmclient.InstallTimeout(nativeOperationTimeout),
+			)
+			release = res.GetRelease()
+			return err
+		}
+		res, err := helm.client.UpdateReleaseFromChart(releaseName, chartRequested,
+			helmclient.UpdateValueOverrides(rawValues),
+			helmclient.ReuseValues(reuseValues),
+			helmclient.UpgradeTimeout(nativeOperationTimeout),
+		)
+		release = res.GetRelease()
+		return err
+	})
+	if IsOperationInProgress(err) {
+		return nil, err
+	}
+	helm.invalidateCachedRelease(releaseName)
+	if err != nil {
+		return nil, fmt.Errorf("helm upgrade failed: %s", err)
+	}
+
+	result := nativeUpgradeResult(release, !exists)
+	rlog.Infof("Helm upgrade for release '%s' with chart '%s' in namespace '%s' successful: %s", releaseName, chart, namespace, result)
+
+	return result, nil
+}
+
+// nativeValues merges values files, json set values and other set values into yaml for tiller
+func nativeValues(valuesPaths []string, setValues []SetValue) ([]byte, error) {
+	values := make(map[string]interface{})
+	for _, path := range valuesPaths {
+		data, err := ioutil.ReadFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("cannot read values file '%s': %s", path, err)
+		}
+		fileValues, err := utils.NewValuesFromBytes(data)
+		if err != nil {
+			return nil, fmt.Errorf("bad values file '%s': %s", path, err)
+		}
+		values = utils.MergeValues(values, fileValues)
+	}
+
+	jsonValues, err := JsonSetValuesToValues(setValues)
+	if err != nil {
+		return nil, err
+	}
+	values = utils.MergeValues(values, jsonValues)
+
+	for _, setValue := range setValues {
+		switch setValue.Type {
+		case SetValueJson:
+			continue
+		case SetValueString:
+			err = strvals.ParseIntoString(setValue.String(), values)
+		case SetValueFile:
+			var data []byte
+			if data, err = ioutil.ReadFile(setValue.Value); err == nil {
+				setValueByPath(values, setValue.Name, string(data))
+			}
+		default:
+			err = strvals.ParseInto(setValue.String(), values)
+		}
+		if err != nil {
+			return nil, fmt.Errorf("bad set value '%s': %s", setValue.Name, err)
+		}
+	}
+
+	return utils.DumpValuesYaml(values)
+}
+
+// nativeUpgradeResult converts the release from tiller into the result of helm upgrade
+func nativeUpgradeResult(release *rspb.Release, installed bool) *ReleaseUpgradeResult {
+	// resources are formatted by tiller as in the output of helm CLI
+	result := ParseUpgradeOutput(release.GetName(), "RESOURCES:\n"+release.GetInfo().GetStatus().GetResources())
+	result.Revision = int(release.GetVersion())
+	result.Status = release.GetInfo().GetStatus().GetCode().String()
+	result.Namespace = release.GetNamespace()
+	result.Installed = installed
+	result.Notes = strings.TrimSpace(release.GetInfo().GetStatus().GetNotes())
+	if lastDeployed := release.GetInfo().GetLastDeployed(); lastDeployed != nil {
+		result.LastDeployed = protoTime(lastDeployed).Format(time.ANSIC)
+	}
+	return result
+}
+
+// GetReleaseValues returns values of the release passed with values files and set values.
+// If all is true, defaults from values.yaml of the chart are merged with them.
+func (helm *NativeHelm) GetReleaseValues(releaseName string, all bool) (utils.Values, error) {
+	res, err := helm.client.ReleaseContent(releaseName)
+	if err != nil {
+		err = nativeError(releaseName, err)
+		if IsReleaseNotFound(err) {
+			return nil, err
+		}
+		return nil, fmt.Errorf("cannot get values of helm release %s: %s", releaseName, err)
+	}
+
+	raw := res.GetRelease().GetConfig().GetRaw()
+	if all {
+		coalesced, err := chartutil.CoalesceValues(res.GetRelease().GetChart(), res.GetRelease().GetConfig())
+		if err != nil {
+			return nil, fmt.Errorf("cannot get values of helm release %s: %s", releaseName, err)
+		}
+		if raw, err = coalesced.YAML(); err != nil {
+			return nil, fmt.Errorf("cannot get values of helm release %s: %s", releaseName, err)
+		}
+	}
+
+	values, err := utils.NewValuesFromBytes([]byte(raw))
+	if err != nil {
+		return nil, fmt.Errorf("cannot get values of helm release %s: %s", releaseName, err)
+	}
+
+	return values, nil
+}
+
+func (helm *NativeHelm) DeleteRelease(releaseName string) error {
+	rlog.Debugf("helm release '%s': native delete with purge", releaseName)
+
+	err := helm.releaseCall(releaseName, "delete", func() error {
+		_, err := helm.client.DeleteRelease(releaseName, helmclient.DeletePurge(true), helmclient.DeleteTimeout(nativeOperationTimeout))
+		return err
+	})
+	if IsOperationInProgress(err) {
+		return err
+	}
+	helm.invalidateCachedRelease(releaseName)
+	if IsReleaseNotFound(err) {
+		return err
+	}
+	if err != nil {
+		return fmt.Errorf("helm delete --purge %s error: %s", releaseName, err)
+	}
+	return nil
+}
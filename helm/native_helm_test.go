@@ -0,0 +1,52 @@
+package helm
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/flant/antiopa/utils"
+)
+
+func TestNativeValues(t *testing.T) {
+	f, err := ioutil.TempFile("", "native-values-")
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer os.Remove(f.Name())
+	f.WriteString("replicas: 1\nimage:\n  repository: nginx\n  tag: \"1.9\"\n")
+	f.Close()
+
+	raw, err := nativeValues([]string{f.Name()}, []SetValue{
+		NewSetValue("replicas", "2"),
+		NewSetStringValue("image.tag", "1.10"),
+		{Name: "image.pullSecrets", Value: `["registry"]`, Type: SetValueJson},
+	})
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	values, err := utils.NewValuesFromBytes(raw)
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.Equal(t, 2.0, values["replicas"])
+	assert.Equal(t, map[string]interface{}{
+		"repository":  "nginx",
+		"tag":         "1.10",
+		"pullSecrets": []interface{}{"registry"},
+	}, values["image"])
+}
+
+func TestNativeError(t *testing.T) {
+	assert.Nil(t, nativeError("test", nil))
+	assert.True(t, IsReleaseNotFound(nativeError("test", fmt.Errorf(`rpc error: code = Unknown desc = release: "test" not found`))))
+	assert.True(t, IsOperationInProgress(nativeError("test", fmt.Errorf("rpc error: code = Unknown desc = another operation (install/upgrade/rollback) is in progress"))))
+
+	// not found errors of release resources are not release errors
+	err := nativeError("test", fmt.Errorf(`rpc error: code = Unknown desc = namespaces "test" not found`))
+	assert.False(t, IsReleaseNotFound(err))
+}
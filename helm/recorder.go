@@ -0,0 +1,283 @@
+package helm
+
+import (
+	"fmt"
+	"io/ioutil"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"github.com/romana/rlog"
+
+	"github.com/flant/antiopa/utils"
+)
+
+// RecordedRelease is a state of the release in RecorderHelm
+type RecordedRelease struct {
+	Name      string
+	Chart     string
+	Namespace string
+	Revision  int
+	Status    string
+	Values    utils.Values
+}
+
+// RecorderHelm is a HelmClient for dev mode. It does not run helm and tiller,
+// releases are stored in memory and every operation is logged.
+type RecorderHelm struct {
+	tillerNamespace string
+
+	m        sync.Mutex
+	Releases map[string]*RecordedRelease
+	Calls    []string
+}
+
+func NewRecorderHelm(tillerNamespace string) *RecorderHelm {
+	rlog.Info("Helm: use helm recorder instead of helm and tiller")
+
+	return &RecorderHelm{
+		tillerNamespace: tillerNamespace,
+		Releases:        make(map[string]*RecordedRelease),
+		Calls:           make([]string, 0),
+	}
+}
+
+func (helm *RecorderHelm) record(format string, args ...interface{}) {
+	call := fmt.Sprintf(format, args...)
+	helm.Calls = append(helm.Calls, call)
+	rlog.Infof("HELM_RECORDER %s", call)
+}
+
+func (helm *RecorderHelm) TillerNamespace() string {
+	return helm.tillerNamespace
+}
+
+func (helm *RecorderHelm) CommandEnv() []string {
+	return []string{}
+}
+
+func (helm *RecorderHelm) Cmd(args ...string) (string, string, error) {
+	helm.m.Lock()
+	defer helm.m.Unlock()
+
+	helm.record("helm %s", strings.Join(args, " "))
+	return "", "", nil
+}
+
+func (helm *RecorderHelm) DeleteSingleFailedRevision(releaseName string) error {
+	return nil
+}
+
+func (helm *RecorderHelm) DeleteOldFailedRevisions(releaseName string) error {
+	return nil
+}
+
+func (helm *RecorderHelm) LastReleaseStatus(releaseName string) (string, string, error) {
+	helm.m.Lock()
+	defer helm.m.Unlock()
+
+	release, hasRelease := helm.Releases[releaseName]
+	if !hasRelease {
+		return "0", "", &ErrReleaseNotFound{Release: releaseName}
+	}
+
+	return fmt.Sprintf("%d", release.Revision), release.Status, nil
+}
+
+func (helm *RecorderHelm) UpgradeRelease(releaseName string, chart string, valuesPaths []string, setValues []SetValue, namespace string, reuseValues bool) (*ReleaseUpgradeResult, error) {
+	helm.m.Lock()
+	defer helm.m.Unlock()
+
+	values := make(utils.Values)
+	if release, hasRelease := helm.Releases[releaseName]; hasRelease && reuseValues {
+		values = utils.MergeValues(values, release.Values)
+	}
+	for _, valuesPath := range valuesPaths {
+		data, err := ioutil.ReadFile(valuesPath)
+		if err != nil {
+			return nil, fmt.Errorf("helm upgrade failed: cannot read values file '%s': %s", valuesPath, err)
+		}
+		fileValues, err := utils.NewValuesFromBytes(data)
+		if err != nil {
+			return nil, fmt.Errorf("helm upgrade failed: bad values file '%s': %s", valuesPath, err)
+		}
+		values = utils.MergeValues(values, fileValues)
+	}
+
+	jsonValues, err := JsonSetValuesToValues(setValues)
+	if err != nil {
+		return nil, fmt.Errorf("helm upgrade failed: %s", err)
+	}
+	values = utils.MergeValues(values, utils.Values(jsonValues))
+
+	for _, setValue := range setValues {
+		var value interface{}
+		switch setValue.Type {
+		case SetValueJson:
+			continue
+		case SetValueString:
+			value = setValue.Value
+		case SetValueFile:
+			data, err := ioutil.ReadFile(setValue.Value)
+			if err != nil {
+				return nil, fmt.Errorf("helm upgrade failed: cannot read file for set value '%s': %s", setValue.Name, err)
+			}
+			value = string(data)
+		default:
+			value = coerceSetValue(setValue.Value)
+		}
+		setValueByPath(values, setValue.Name, value)
+	}
+
+	release, hasRelease := helm.Releases[releaseName]
+	if !hasRelease {
+		release = &RecordedRelease{Name: releaseName}
+		helm.Releases[releaseName] = release
+	}
+	release.Chart = chart
+	release.Namespace = namespace
+	release.Revision++
+	release.Status = "DEPLOYED"
+	release.Values = values
+
+	helm.record("upgrade release '%s' revision %d with chart '%s' in namespace '%s'", releaseName, release.Revision, chart, namespace)
+
+	return &ReleaseUpgradeResult{
+		Release:   releaseName,
+		Revision:  release.Revision,
+		Status:    release.Status,
+		Namespace: namespace,
+		Installed: !hasRelease,
+		Resources: make(map[string][]string),
+	}, nil
+}
+
+// coerceSetValue converts value like helm does for --set flag
+func coerceSetValue(value string) interface{} {
+	switch value {
+	case "true":
+		return true
+	case "false":
+		return false
+	case "null":
+		return nil
+	}
+	if i, err := strconv.ParseInt(value, 10, 64); err == nil {
+		return i
+	}
+	return value
+}
+
+// RenderRelease does not render templates: an empty manifest is returned
+func (helm *RecorderHelm) RenderRelease(releaseName string, chart string, _ []string, _ []SetValue, namespace string) (string, error) {
+	helm.m.Lock()
+	defer helm.m.Unlock()
+
+	helm.record("render release '%s' with chart '%s' in namespace '%s'", releaseName, chart, namespace)
+	return "", nil
+}
+
+// GetReleaseValues returns values of the release. Charts are not read by recorder, so
+// computed values are the same as user values.
+func (helm *RecorderHelm) GetReleaseValues(releaseName string, _ bool) (utils.Values, error) {
+	helm.m.Lock()
+	defer helm.m.Unlock()
+
+	release, hasRelease := helm.Releases[releaseName]
+	if !hasRelease {
+		return nil, &ErrReleaseNotFound{Release: releaseName}
+	}
+
+	return release.Values, nil
+}
+
+// GetReleaseManifest returns empty manifest: charts are not rendered by recorder
+func (helm *RecorderHelm) GetReleaseManifest(releaseName string) (string, error) {
+	return "", nil
+}
+
+// GetReleaseNotes returns empty notes: charts are not rendered by recorder
+func (helm *RecorderHelm) GetReleaseNotes(releaseName string) (string, error) {
+	helm.m.Lock()
+	defer helm.m.Unlock()
+
+	if _, hasRelease := helm.Releases[releaseName]; !hasRelease {
+		return "", &ErrReleaseNotFound{Release: releaseName}
+	}
+	return "", nil
+}
+
+func (helm *RecorderHelm) TestRelease(releaseName string, timeout int, cleanup bool) (string, error) {
+	helm.m.Lock()
+	defer helm.m.Unlock()
+
+	helm.record("test release '%s'", releaseName)
+	return "", nil
+}
+
+func (helm *RecorderHelm) DeleteRelease(releaseName string) error {
+	helm.m.Lock()
+	defer helm.m.Unlock()
+
+	delete(helm.Releases, releaseName)
+	helm.record("delete release '%s'", releaseName)
+	return nil
+}
+
+// ListReleases returns release names in the form of tiller ConfigMaps names: "<release name>.v<revision>".
+// Only NAME and STATUS labels are supported.
+func (helm *RecorderHelm) ListReleases(labelSelector map[string]string) ([]string, error) {
+	helm.m.Lock()
+	defer helm.m.Unlock()
+
+	releases := make([]string, 0)
+	for _, release := range helm.Releases {
+		if name, hasName := labelSelector["NAME"]; hasName && name != release.Name {
+			continue
+		}
+		if status, hasStatus := labelSelector["STATUS"]; hasStatus && status != release.Status {
+			continue
+		}
+		releases = append(releases, fmt.Sprintf("%s.v%d", release.Name, release.Revision))
+	}
+
+	sort.Strings(releases)
+
+	return releases, nil
+}
+
+func (helm *RecorderHelm) ListReleasesNames(labelSelector map[string]string) ([]string, error) {
+	helm.m.Lock()
+	defer helm.m.Unlock()
+
+	releasesNames := make([]string, 0)
+	for _, release := range helm.Releases {
+		if name, hasName := labelSelector["NAME"]; hasName && name != release.Name {
+			continue
+		}
+		if status, hasStatus := labelSelector["STATUS"]; hasStatus && status != release.Status {
+			continue
+		}
+		releasesNames = append(releasesNames, release.Name)
+	}
+
+	return releasesNames, nil
+}
+
+func (helm *RecorderHelm) IsReleaseExists(releaseName string) (bool, error) {
+	helm.m.Lock()
+	defer helm.m.Unlock()
+
+	_, hasRelease := helm.Releases[releaseName]
+	return hasRelease, nil
+}
+
+// AdoptResources records adoption, objects are not searched: recorder has no cluster objects
+func (helm *RecorderHelm) AdoptResources(options AdoptResourcesOptions) ([]AdoptedResource, error) {
+	helm.m.Lock()
+	defer helm.m.Unlock()
+
+	helm.record("adopt resources into release '%s' dry run %v", options.Release, options.DryRun)
+	return []AdoptedResource{}, nil
+}
@@ -0,0 +1,81 @@
+package helm
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRecorderHelm_UpgradeRelease(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "recorder-helm")
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer os.RemoveAll(tmpDir)
+
+	valuesPath := filepath.Join(tmpDir, "values.yaml")
+	if !assert.NoError(t, ioutil.WriteFile(valuesPath, []byte("replicas: 2\n"), 0644)) {
+		return
+	}
+
+	helm := NewRecorderHelm("antiopa")
+
+	exists, _ := helm.IsReleaseExists("test")
+	assert.False(t, exists)
+
+	revision, _, err := helm.LastReleaseStatus("test")
+	assert.True(t, IsReleaseNotFound(err))
+	assert.Equal(t, "0", revision)
+
+	_, err = helm.GetReleaseValues("test", false)
+	assert.True(t, IsReleaseNotFound(err))
+
+	for i := 0; i < 2; i++ {
+		_, err = helm.UpgradeRelease("test", "/charts/test", []string{valuesPath}, []SetValue{NewSetStringValue("_antiopaModuleChecksum", "123"), NewSetValue("debug.enabled", "true")}, "antiopa", false)
+		assert.NoError(t, err)
+	}
+
+	revision, status, err := helm.LastReleaseStatus("test")
+	assert.NoError(t, err)
+	assert.Equal(t, "2", revision)
+	assert.Equal(t, "DEPLOYED", status)
+
+	values, err := helm.GetReleaseValues("test", false)
+	assert.NoError(t, err)
+	assert.Equal(t, "123", values["_antiopaModuleChecksum"])
+	assert.Equal(t, 2.0, values["replicas"])
+	assert.Equal(t, map[string]interface{}{"enabled": true}, values["debug"])
+
+	releases, _ := helm.ListReleases(map[string]string{"STATUS": "DEPLOYED"})
+	assert.Equal(t, []string{"test.v2"}, releases)
+
+	assert.NoError(t, helm.DeleteRelease("test"))
+	exists, _ = helm.IsReleaseExists("test")
+	assert.False(t, exists)
+}
+
+func TestRecorderHelm_UpgradeRelease_ReuseValues(t *testing.T) {
+	helm := NewRecorderHelm("antiopa")
+
+	_, err := helm.UpgradeRelease("test", "/charts/test", []string{}, []SetValue{NewSetValue("replicas", "2")}, "antiopa", true)
+	assert.NoError(t, err)
+
+	// value injected by a third-party controller
+	_, err = helm.UpgradeRelease("test", "/charts/test", []string{}, []SetValue{NewSetValue("injected.caBundle", "abc")}, "antiopa", true)
+	assert.NoError(t, err)
+
+	_, err = helm.UpgradeRelease("test", "/charts/test", []string{}, []SetValue{NewSetValue("replicas", "3")}, "antiopa", true)
+	assert.NoError(t, err)
+	values, _ := helm.GetReleaseValues("test", false)
+	assert.Equal(t, int64(3), values["replicas"])
+	assert.Equal(t, map[string]interface{}{"caBundle": "abc"}, values["injected"])
+
+	// values are reset without reuse
+	_, err = helm.UpgradeRelease("test", "/charts/test", []string{}, []SetValue{NewSetValue("replicas", "3")}, "antiopa", false)
+	assert.NoError(t, err)
+	values, _ = helm.GetReleaseValues("test", false)
+	assert.NotContains(t, values, "injected")
+}
@@ -0,0 +1,64 @@
+package helm
+
+import (
+	"fmt"
+	"sync"
+
+	"github.com/romana/rlog"
+)
+
+// releaseLocks serializes helm operations of antiopa on the same release,
+// operations on different releases are run in parallel.
+var releaseLocks = newReleaseLocksRegistry()
+
+type releaseLocksRegistry struct {
+	m     sync.Mutex
+	locks map[string]chan struct{}
+}
+
+func newReleaseLocksRegistry() *releaseLocksRegistry {
+	return &releaseLocksRegistry{
+		locks: make(map[string]chan struct{}),
+	}
+}
+
+// lock blocks until the release is free, returned func releases the lock
+func (r *releaseLocksRegistry) lock(key string) func() {
+	r.m.Lock()
+	lock, ok := r.locks[key]
+	if !ok {
+		lock = make(chan struct{}, 1)
+		r.locks[key] = lock
+	}
+	r.m.Unlock()
+
+	select {
+	case lock <- struct{}{}:
+	default:
+		rlog.Infof("Helm: release '%s' is busy with another operation of antiopa, wait", key)
+		lock <- struct{}{}
+	}
+
+	return func() {
+		<-lock
+	}
+}
+
+// lockRelease locks release of the tiller for an operation
+func (helm *CliHelm) lockRelease(releaseName string) func() {
+	return releaseLocks.lock(fmt.Sprintf("%s/%s", helm.tillerNamespace, releaseName))
+}
+
+// releaseCmd runs helm operation on the release under the release lock.
+// ErrOperationInProgress is returned at once if tiller reports that another operation
+// is in progress, the lock is not held while tiller is busy and the task is retried by the queue.
+func (helm *CliHelm) releaseCmd(releaseName string, args ...string) (stdout string, stderr string, err error) {
+	defer helm.lockRelease(releaseName)()
+
+	stdout, stderr, err = helm.Cmd(args...)
+	if err != nil && isOperationInProgressOutput(stderr) {
+		rlog.Warnf("helm release '%s': another operation is in progress in tiller, '%s' is not run", releaseName, args[0])
+		err = &ErrOperationInProgress{Release: releaseName, Output: fmt.Sprintf("%v %v", stdout, stderr)}
+	}
+	return
+}
@@ -0,0 +1,41 @@
+package helm
+
+import (
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestReleaseLocksRegistry(t *testing.T) {
+	r := newReleaseLocksRegistry()
+
+	unlock := r.lock("kube-system/a")
+	// other releases are not blocked
+	r.lock("kube-system/b")()
+
+	var wg sync.WaitGroup
+	locked := make(chan struct{})
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		r.lock("kube-system/a")()
+		close(locked)
+	}()
+
+	select {
+	case <-locked:
+		t.Fatal("release is locked twice")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	unlock()
+	wg.Wait()
+	<-locked
+}
+
+func TestIsOperationInProgressOutput(t *testing.T) {
+	assert.True(t, isOperationInProgressOutput("Error: UPGRADE FAILED: another operation (install/upgrade/rollback) is in progress"))
+	assert.False(t, isOperationInProgressOutput("Error: UPGRADE FAILED: timed out waiting for the condition"))
+}
@@ -0,0 +1,190 @@
+package helm
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/romana/rlog"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	kblabels "k8s.io/apimachinery/pkg/labels"
+
+	"github.com/flant/antiopa/kube"
+)
+
+// MigrateReleaseNamespaceOptions describe the move of the release into the tiller namespace of the client
+type MigrateReleaseNamespaceOptions struct {
+	Release string
+	// tiller namespace of the current release
+	FromNamespace string
+	// chart of the new release record
+	ChartName    string
+	ChartVersion string
+	// manifest of the release rendered for the new namespace
+	Manifest string
+	DryRun   bool
+}
+
+// ReleaseNamespaceMigration is a result of MigrateReleaseNamespace
+type ReleaseNamespaceMigration struct {
+	Release string            `json:"release"`
+	From    string            `json:"from"`
+	To      string            `json:"to"`
+	Adopted []AdoptedResource `json:"adopted"`
+	// objects of the old release that are not in the new manifest, e.g. objects without
+	// namespace in templates. They should be deleted after the release is upgraded in the new namespace.
+	Stale []ReleaseResource `json:"stale"`
+}
+
+// MigrateReleaseNamespace moves the release from the tiller in FromNamespace into the tiller of the client
+// without deletion of its objects: existing objects of the manifest are adopted into the new release
+// record and revisions of the old release are deleted from the old tiller. Release should be upgraded
+// in the new namespace after migration to create objects in the new namespace, then Stale objects
+// that are left in the old namespace should be deleted with DeleteResources.
+func MigrateReleaseNamespace(helmClient HelmClient, options MigrateReleaseNamespaceOptions) (*ReleaseNamespaceMigration, error) {
+	res := &ReleaseNamespaceMigration{
+		Release: options.Release,
+		From:    options.FromNamespace,
+		To:      helmClient.TillerNamespace(),
+	}
+	if res.From == res.To {
+		return nil, fmt.Errorf("release '%s' is already in namespace '%s'", options.Release, res.To)
+	}
+
+	_, oldRelease, err := lastDeployedRelease(options.FromNamespace, options.Release)
+	if err != nil {
+		return nil, err
+	}
+	if oldRelease == nil {
+		return nil, fmt.Errorf("release '%s' has no deployed revision in namespace '%s'", options.Release, options.FromNamespace)
+	}
+	if exists, err := helmClient.IsReleaseExists(options.Release); err != nil {
+		return nil, err
+	} else if exists {
+		return nil, fmt.Errorf("release '%s' already exists in namespace '%s'", options.Release, res.To)
+	}
+
+	res.Stale, err = staleReleaseResources(oldRelease.Manifest, oldRelease.Namespace, options.Manifest, res.To)
+	if err != nil {
+		return nil, err
+	}
+
+	res.Adopted, err = helmClient.AdoptResources(AdoptResourcesOptions{
+		Release:      options.Release,
+		ChartName:    options.ChartName,
+		ChartVersion: options.ChartVersion,
+		Manifest:     options.Manifest,
+		DryRun:       options.DryRun,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("cannot adopt objects into release in namespace '%s': %s", res.To, err)
+	}
+	if options.DryRun {
+		return res, nil
+	}
+
+	if err := deleteReleaseRevisions(options.FromNamespace, options.Release); err != nil {
+		return nil, err
+	}
+	rlog.Infof("HELM release '%s' is moved from namespace '%s' to '%s', %d stale objects are left", options.Release, res.From, res.To, len(res.Stale))
+	return res, nil
+}
+
+// staleReleaseResources returns objects of the old manifest that are not in the new manifest.
+// Cluster scoped objects are compared without namespace, hooks are ignored.
+func staleReleaseResources(oldManifest string, oldNamespace string, newManifest string, newNamespace string) ([]ReleaseResource, error) {
+	oldResources, err := ParseReleaseManifest(oldManifest, oldNamespace)
+	if err != nil {
+		return nil, err
+	}
+	newResources, err := ParseReleaseManifest(newManifest, newNamespace)
+	if err != nil {
+		return nil, err
+	}
+
+	key := func(resource ReleaseResource) (string, error) {
+		_, namespaced, err := kube.GroupVersionResource(resource.ApiVersion, resource.Kind)
+		if err != nil {
+			return "", err
+		}
+		if !namespaced {
+			resource.Namespace = ""
+		}
+		return resource.String(), nil
+	}
+
+	inNewManifest := make(map[string]bool)
+	for _, resource := range newResources {
+		k, err := key(resource)
+		if kube.IsKindNotRegistered(err) {
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		inNewManifest[k] = true
+	}
+
+	res := make([]ReleaseResource, 0)
+	for _, resource := range oldResources {
+		if resource.Hook != "" {
+			continue
+		}
+		k, err := key(resource)
+		if kube.IsKindNotRegistered(err) {
+			// objects of the kind cannot exist
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		if !inNewManifest[k] {
+			res = append(res, resource)
+		}
+	}
+	sort.Slice(res, func(i, j int) bool {
+		return res[i].String() < res[j].String()
+	})
+	return res, nil
+}
+
+// deleteReleaseRevisions deletes tiller ConfigMaps of the release, objects of the release are not deleted
+func deleteReleaseRevisions(tillerNamespace string, releaseName string) error {
+	err := kube.KubernetesClient.CoreV1().ConfigMaps(tillerNamespace).DeleteCollection(
+		&metav1.DeleteOptions{},
+		metav1.ListOptions{LabelSelector: kblabels.Set{"OWNER": "TILLER", "NAME": releaseName}.AsSelector().String()},
+	)
+	if err != nil {
+		return fmt.Errorf("cannot delete revisions of release '%s' in namespace '%s': %s", releaseName, tillerNamespace, err)
+	}
+	return nil
+}
+
+// DeleteResources deletes objects, absent objects are skipped
+func DeleteResources(resources []ReleaseResource) error {
+	for _, resource := range resources {
+		gvr, namespaced, err := kube.GroupVersionResource(resource.ApiVersion, resource.Kind)
+		if kube.IsKindNotRegistered(err) {
+			continue
+		}
+		if err != nil {
+			return err
+		}
+
+		dynamicClient, err := kube.DynamicClient()
+		if err != nil {
+			return err
+		}
+		client := dynamicClient.Resource(gvr)
+		if namespaced {
+			err = client.Namespace(resource.Namespace).Delete(resource.Name, &metav1.DeleteOptions{})
+		} else {
+			err = client.Delete(resource.Name, &metav1.DeleteOptions{})
+		}
+		if err != nil && !errors.IsNotFound(err) {
+			return fmt.Errorf("cannot delete %s: %s", resource, err)
+		}
+		rlog.Infof("HELM deleted %s", resource)
+	}
+	return nil
+}
@@ -0,0 +1,25 @@
+package helm
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"k8s.io/client-go/kubernetes/fake"
+
+	"github.com/flant/antiopa/kube"
+)
+
+func TestMigrateReleaseNamespace_Checks(t *testing.T) {
+	kube.KubernetesClient = fake.NewSimpleClientset()
+	helmClient := &CliHelm{tillerNamespace: "ingress"}
+
+	_, err := MigrateReleaseNamespace(helmClient, MigrateReleaseNamespaceOptions{Release: "nginx", FromNamespace: "ingress"})
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), "already in namespace 'ingress'")
+	}
+
+	_, err = MigrateReleaseNamespace(helmClient, MigrateReleaseNamespaceOptions{Release: "nginx", FromNamespace: "antiopa"})
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), "has no deployed revision in namespace 'antiopa'")
+	}
+}
@@ -0,0 +1,43 @@
+package helm
+
+import (
+	"strconv"
+)
+
+// ReleaseRevision is the last revision of the release in tiller storage
+type ReleaseRevision struct {
+	Revision int
+	Status   string
+	// uid of the revision ConfigMap, it changes if the release is deleted and installed again
+	UID string
+}
+
+// CachedRevisionClient is implemented by clients with the cache of tiller ConfigMaps
+type CachedRevisionClient interface {
+	// CachedLastRevision returns the last revision of the release without requests to tiller and
+	// apiserver. ok is false if the cache is not available or the release is changed and the cache
+	// is not updated yet, revision is nil if there is no release.
+	CachedLastRevision(releaseName string) (revision *ReleaseRevision, ok bool)
+}
+
+func (helm *CliHelm) CachedLastRevision(releaseName string) (*ReleaseRevision, bool) {
+	if helm.releasesCache == nil || !helm.releasesCache.HasSynced() || !helm.releasesCache.IsValid(releaseName) {
+		return nil, false
+	}
+	cm, err := helm.releasesCache.LastRevision(releaseName)
+	if err != nil {
+		return nil, false
+	}
+	if cm == nil {
+		return nil, true
+	}
+	revision, _ := strconv.Atoi(cm.Labels["VERSION"])
+	return &ReleaseRevision{Revision: revision, Status: cm.Labels["STATUS"], UID: string(cm.UID)}, true
+}
+
+// invalidateCachedRelease should be called after operations that change ConfigMaps of the release
+func (helm *CliHelm) invalidateCachedRelease(releaseName string) {
+	if helm.releasesCache != nil {
+		helm.releasesCache.Invalidate(releaseName)
+	}
+}
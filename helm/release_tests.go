@@ -0,0 +1,88 @@
+package helm
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/romana/rlog"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"github.com/flant/antiopa/kube"
+)
+
+// TestRelease runs `helm test` for the release and collects logs of the test pods.
+// Returned string contains helm output and logs of each test pod.
+// Test pods are deleted after logs are collected if cleanup is true.
+func (helm *CliHelm) TestRelease(releaseName string, timeout int, cleanup bool) (string, error) {
+	args := []string{"test", releaseName}
+	if timeout > 0 {
+		args = append(args, "--timeout", fmt.Sprintf("%d", timeout))
+	}
+
+	rlog.Infof("Running helm test for release '%s' ...", releaseName)
+	stdout, stderr, testErr := helm.releaseCmd(releaseName, args...)
+
+	report := make([]string, 0)
+	report = append(report, stdout, stderr)
+
+	testPods, err := helm.releaseTestPods(releaseName)
+	if err != nil {
+		rlog.Errorf("helm release '%s': cannot get test pods: %s", releaseName, err)
+	}
+
+	for _, pod := range testPods {
+		logs, err := kube.KubernetesClient.CoreV1().
+			Pods(pod.Namespace).
+			GetLogs(pod.Name, &v1.PodLogOptions{}).
+			Do().Raw()
+		if err != nil {
+			report = append(report, fmt.Sprintf("--- pod/%s: cannot get logs: %s", pod.Name, err))
+		} else {
+			report = append(report, fmt.Sprintf("--- pod/%s logs:\n%s", pod.Name, strings.TrimSpace(string(logs))))
+		}
+
+		if cleanup {
+			err := kube.KubernetesClient.CoreV1().
+				Pods(pod.Namespace).
+				Delete(pod.Name, &metav1.DeleteOptions{})
+			if err != nil {
+				rlog.Errorf("helm release '%s': cannot delete test pod '%s': %s", releaseName, pod.Name, err)
+			}
+		}
+	}
+
+	output := strings.TrimSpace(strings.Join(report, "\n"))
+
+	if testErr != nil {
+		return output, fmt.Errorf("helm test for release '%s' failed: %s:\n%s", releaseName, testErr, output)
+	}
+	rlog.Infof("Helm test for release '%s' successful:\n%s", releaseName, output)
+
+	return output, nil
+}
+
+// releaseTestPods returns pods defined with test-success or test-failure hooks.
+func (helm *CliHelm) releaseTestPods(releaseName string) ([]ReleaseResource, error) {
+	stdout, stderr, err := helm.Cmd("get", "hooks", releaseName)
+	if err != nil {
+		return nil, fmt.Errorf("cannot get hooks of helm release %s: %s\n%s %s", releaseName, err, stdout, stderr)
+	}
+
+	hooks, err := ParseReleaseManifest(stdout, helm.TillerNamespace())
+	if err != nil {
+		return nil, err
+	}
+
+	pods := make([]ReleaseResource, 0)
+	for _, hook := range hooks {
+		if hook.Kind != "Pod" {
+			continue
+		}
+		if strings.Contains(hook.Hook, "test-success") || strings.Contains(hook.Hook, "test-failure") {
+			pods = append(pods, hook)
+		}
+	}
+
+	return pods, nil
+}
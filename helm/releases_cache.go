@@ -0,0 +1,213 @@
+package helm
+
+import (
+	"fmt"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/romana/rlog"
+	"k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	kblabels "k8s.io/apimachinery/pkg/labels"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/watch"
+	corelisters "k8s.io/client-go/listers/core/v1"
+	"k8s.io/client-go/tools/cache"
+
+	"github.com/flant/antiopa/kube"
+)
+
+// Resync period of tiller ConfigMaps cache
+const ReleasesCacheResyncPeriod = 5 * time.Minute
+
+// ReleasesCache keeps tiller ConfigMaps (OWNER=TILLER) from the tiller namespace in the informer cache,
+// so listing of releases does not request the full list from apiserver each time.
+type ReleasesCache struct {
+	namespace string
+	informer  cache.SharedIndexInformer
+	lister    corelisters.ConfigMapLister
+	stopCh    chan struct{}
+	// ConfigMaps created before start are not new revisions
+	startedAt time.Time
+
+	// releases changed by upgrade or delete are not served from the cache until
+	// the informer has the same ConfigMaps of the release as apiserver
+	m           sync.Mutex
+	invalidated map[string]bool
+}
+
+func NewReleasesCache(namespace string) *ReleasesCache {
+	ownerSelector := kblabels.Set{"OWNER": "TILLER"}.AsSelector().String()
+
+	// ListWatch with typed client to work with fake clientset in dev mode
+	lw := &cache.ListWatch{
+		ListFunc: func(options metav1.ListOptions) (runtime.Object, error) {
+			options.LabelSelector = ownerSelector
+			return kube.KubernetesClient.CoreV1().ConfigMaps(namespace).List(options)
+		},
+		WatchFunc: func(options metav1.ListOptions) (watch.Interface, error) {
+			options.LabelSelector = ownerSelector
+			return kube.KubernetesClient.CoreV1().ConfigMaps(namespace).Watch(options)
+		},
+	}
+
+	informer := cache.NewSharedIndexInformer(kube.NewRelistingListWatch(lw),
+		&v1.ConfigMap{},
+		ReleasesCacheResyncPeriod,
+		cache.Indexers{cache.NamespaceIndex: cache.MetaNamespaceIndexFunc})
+
+	c := &ReleasesCache{
+		namespace:   namespace,
+		informer:    informer,
+		lister:      corelisters.NewConfigMapLister(informer.GetIndexer()),
+		stopCh:      make(chan struct{}),
+		invalidated: make(map[string]bool),
+	}
+
+	// handlers are called after the indexer is updated
+	revalidateHandler := func(obj interface{}) {
+		if tombstone, ok := obj.(cache.DeletedFinalStateUnknown); ok {
+			obj = tombstone.Obj
+		}
+		if cm, ok := obj.(*v1.ConfigMap); ok {
+			c.revalidate(cm.Labels["NAME"])
+		}
+	}
+	informer.AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc:    revalidateHandler,
+		UpdateFunc: func(_ interface{}, obj interface{}) { revalidateHandler(obj) },
+		DeleteFunc: revalidateHandler,
+	})
+
+	return c
+}
+
+// OnNewRevision sets a handler for ConfigMaps of release revisions created after start of the cache.
+// Should be called before Run.
+func (c *ReleasesCache) OnNewRevision(handler func(cm *v1.ConfigMap)) {
+	c.informer.AddEventHandler(cache.ResourceEventHandlerFuncs{
+		AddFunc: func(obj interface{}) {
+			cm, ok := obj.(*v1.ConfigMap)
+			if !ok || !cm.CreationTimestamp.Time.After(c.startedAt) {
+				return
+			}
+			handler(cm)
+		},
+	})
+}
+
+// Run starts informer and waits until the cache is filled
+func (c *ReleasesCache) Run() error {
+	rlog.Debugf("helm: start releases cache in namespace '%s'", c.namespace)
+
+	// creation timestamp has seconds precision
+	c.startedAt = time.Now().Truncate(time.Second)
+
+	go c.informer.Run(c.stopCh)
+
+	if !cache.WaitForCacheSync(c.stopCh, c.informer.HasSynced) {
+		return fmt.Errorf("cannot sync releases cache in namespace '%s'", c.namespace)
+	}
+
+	rlog.Debugf("helm: releases cache in namespace '%s' is synced", c.namespace)
+	return nil
+}
+
+func (c *ReleasesCache) Stop() {
+	close(c.stopCh)
+}
+
+func (c *ReleasesCache) HasSynced() bool {
+	return c.informer.HasSynced()
+}
+
+// Invalidate should be called after the release is upgraded or deleted. The release is not
+// served from the cache until the informer receives all changes of its ConfigMaps.
+func (c *ReleasesCache) Invalidate(releaseName string) {
+	c.m.Lock()
+	c.invalidated[releaseName] = true
+	c.m.Unlock()
+
+	// changes can be received before the call
+	c.revalidate(releaseName)
+}
+
+// IsValid returns false if the cached ConfigMaps of the release can be outdated
+func (c *ReleasesCache) IsValid(releaseName string) bool {
+	c.m.Lock()
+	defer c.m.Unlock()
+	return !c.invalidated[releaseName]
+}
+
+// hasInvalidated returns true if some release is not served from the cache
+func (c *ReleasesCache) hasInvalidated() bool {
+	c.m.Lock()
+	defer c.m.Unlock()
+	return len(c.invalidated) > 0
+}
+
+// revalidate compares cached ConfigMaps of the invalidated release with ConfigMaps in apiserver.
+// The lock is held during the request, so a newer Invalidate is not lost.
+func (c *ReleasesCache) revalidate(releaseName string) {
+	c.m.Lock()
+	defer c.m.Unlock()
+
+	if !c.invalidated[releaseName] {
+		return
+	}
+
+	selector := kblabels.Set{"OWNER": "TILLER", "NAME": releaseName}.AsSelector()
+	cmList, err := kube.KubernetesClient.CoreV1().ConfigMaps(c.namespace).List(metav1.ListOptions{LabelSelector: selector.String()})
+	if err != nil {
+		rlog.Debugf("helm: cannot check releases cache for release '%s': %s", releaseName, err)
+		return
+	}
+	cached, err := c.List(selector)
+	if err != nil {
+		return
+	}
+
+	versions := make(map[string]string)
+	for _, cm := range cached {
+		versions[cm.Name] = cm.ResourceVersion
+	}
+	if len(versions) != len(cmList.Items) {
+		return
+	}
+	for _, cm := range cmList.Items {
+		if versions[cm.Name] != cm.ResourceVersion {
+			return
+		}
+	}
+
+	rlog.Debugf("helm: release '%s' is served from releases cache again", releaseName)
+	delete(c.invalidated, releaseName)
+}
+
+// List returns tiller ConfigMaps that match the selector
+func (c *ReleasesCache) List(selector kblabels.Selector) ([]*v1.ConfigMap, error) {
+	return c.lister.ConfigMaps(c.namespace).List(selector)
+}
+
+// LastRevision returns the ConfigMap of the last revision of the release, nil if there is no release
+func (c *ReleasesCache) LastRevision(releaseName string) (*v1.ConfigMap, error) {
+	cms, err := c.List(kblabels.Set{"OWNER": "TILLER", "NAME": releaseName}.AsSelector())
+	if err != nil {
+		return nil, err
+	}
+
+	var last *v1.ConfigMap
+	lastRevision := 0
+	for _, cm := range cms {
+		revision, err := strconv.Atoi(cm.Labels["VERSION"])
+		if err != nil {
+			continue
+		}
+		if last == nil || revision > lastRevision {
+			last = cm
+			lastRevision = revision
+		}
+	}
+	return last, nil
+}
@@ -0,0 +1,56 @@
+package helm
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+
+	"github.com/flant/antiopa/kube"
+)
+
+func TestReleasesCache_Invalidate(t *testing.T) {
+	defer func(c kube.Client) { kube.KubernetesClient = c }(kube.KubernetesClient)
+
+	revision := func(name string, version string, status string) *v1.ConfigMap {
+		return &v1.ConfigMap{ObjectMeta: metav1.ObjectMeta{
+			Name:            name + ".v" + version,
+			Namespace:       "antiopa",
+			ResourceVersion: version,
+			Labels:          map[string]string{"OWNER": "TILLER", "NAME": name, "VERSION": version, "STATUS": status},
+		}}
+	}
+	v1Deployed := revision("test", "1", "DEPLOYED")
+	v1Superseded := revision("test", "1", "SUPERSEDED")
+	v1Superseded.ResourceVersion = "3"
+	v2Deployed := revision("test", "2", "DEPLOYED")
+
+	kube.KubernetesClient = fake.NewSimpleClientset(v1Superseded, v2Deployed)
+
+	// informer is not run, the indexer has revisions before upgrade
+	c := NewReleasesCache("antiopa")
+	indexer := c.informer.GetIndexer()
+	assert.NoError(t, indexer.Add(v1Deployed))
+	assert.True(t, c.IsValid("test"))
+
+	c.Invalidate("test")
+	assert.False(t, c.IsValid("test"))
+	assert.True(t, c.hasInvalidated())
+
+	// new revision is received, the old one is not updated yet
+	assert.NoError(t, indexer.Add(v2Deployed))
+	c.revalidate("test")
+	assert.False(t, c.IsValid("test"))
+
+	assert.NoError(t, indexer.Update(v1Superseded))
+	c.revalidate("test")
+	assert.True(t, c.IsValid("test"))
+	assert.False(t, c.hasInvalidated())
+
+	last, err := c.LastRevision("test")
+	if assert.NoError(t, err) && assert.NotNil(t, last) {
+		assert.Equal(t, "2", last.Labels["VERSION"])
+	}
+}
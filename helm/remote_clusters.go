@@ -0,0 +1,118 @@
+package helm
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+
+	"github.com/romana/rlog"
+	"gopkg.in/yaml.v2"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/tools/clientcmd"
+
+	"github.com/flant/antiopa/kube"
+	"github.com/flant/antiopa/utils"
+)
+
+// RemoteClustersFileName is a list of remote clusters in the working dir
+const RemoteClustersFileName = "clusters.yaml"
+
+// RemoteCluster is a cluster where modules with 'cluster' in module.yaml are released.
+// Tiller should be installed in the remote cluster, antiopa does not install it. Tiller namespace
+// should be used only by antiopa: releases of unknown modules are purged.
+type RemoteCluster struct {
+	Name string `yaml:"name"`
+	// path to kubeconfig file, e.g. mounted from a Secret
+	KubeConfig string `yaml:"kubeconfig"`
+	// context in kubeconfig, the current context is used if empty
+	KubeContext     string `yaml:"context"`
+	TillerNamespace string `yaml:"tillerNamespace"`
+	// values are merged into values of modules released in the cluster, e.g. {"global": {"clusterName": "edge-1"}}
+	RawValues map[interface{}]interface{} `yaml:"values"`
+
+	Values utils.Values `yaml:"-"`
+}
+
+func (c *RemoteCluster) validate() error {
+	if c.Name == "" {
+		return fmt.Errorf("name is required")
+	}
+	if c.KubeConfig == "" {
+		return fmt.Errorf("cluster '%s': kubeconfig is required", c.Name)
+	}
+	if c.TillerNamespace == "" {
+		return fmt.Errorf("cluster '%s': tillerNamespace is required", c.Name)
+	}
+	return nil
+}
+
+// LoadRemoteClusters reads clusters file. Empty list is returned if file is not exists.
+func LoadRemoteClusters(path string) ([]*RemoteCluster, error) {
+	clusters := make([]*RemoteCluster, 0)
+
+	if _, err := os.Stat(path); os.IsNotExist(err) {
+		return clusters, nil
+	}
+
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("cannot read '%s': %s", path, err)
+	}
+
+	if err := yaml.UnmarshalStrict(data, &clusters); err != nil {
+		return nil, fmt.Errorf("bad %s: %s\n%s", RemoteClustersFileName, err, string(data))
+	}
+	names := make(map[string]bool)
+	for _, cluster := range clusters {
+		if err := cluster.validate(); err != nil {
+			return nil, fmt.Errorf("bad %s: %s", RemoteClustersFileName, err)
+		}
+		if names[cluster.Name] {
+			return nil, fmt.Errorf("bad %s: duplicate cluster '%s'", RemoteClustersFileName, cluster.Name)
+		}
+		names[cluster.Name] = true
+		cluster.Values = make(utils.Values)
+		if cluster.RawValues != nil {
+			if cluster.Values, err = utils.FormatValues(cluster.RawValues); err != nil {
+				return nil, fmt.Errorf("bad %s: cluster '%s' values: %s", RemoteClustersFileName, cluster.Name, err)
+			}
+		}
+	}
+	return clusters, nil
+}
+
+// InitRemote creates a helm client for the tiller in the remote cluster. Releases
+// are listed from apiserver of the remote cluster without cache, manual operations
+// are not detected.
+func InitRemote(cluster *RemoteCluster) (HelmClient, error) {
+	rlog.Infof("Helm: initialize client for cluster '%s'", cluster.Name)
+
+	config, err := clientcmd.NewNonInteractiveDeferredLoadingClientConfig(
+		&clientcmd.ClientConfigLoadingRules{ExplicitPath: cluster.KubeConfig},
+		&clientcmd.ConfigOverrides{CurrentContext: cluster.KubeContext},
+	).ClientConfig()
+	if err != nil {
+		return nil, fmt.Errorf("cluster '%s': bad kubeconfig: %s", cluster.Name, err)
+	}
+	clientset, err := kubernetes.NewForConfig(config)
+	if err != nil {
+		return nil, fmt.Errorf("cluster '%s': %s", cluster.Name, err)
+	}
+
+	helm := &CliHelm{tillerNamespace: cluster.TillerNamespace, cluster: cluster, kubeClient: clientset}
+	stdout, stderr, err := helm.Cmd("version")
+	if err != nil {
+		return nil, fmt.Errorf("cluster '%s': unable to get helm version: %v\n%v %v", cluster.Name, err, stdout, stderr)
+	}
+	rlog.Infof("Helm: cluster '%s' helm version:\n%v %v", cluster.Name, stdout, stderr)
+
+	return helm, nil
+}
+
+// client returns a client of the release cluster
+func (helm *CliHelm) client() kube.Client {
+	if helm.kubeClient != nil {
+		return helm.kubeClient
+	}
+	return kube.KubernetesClient
+}
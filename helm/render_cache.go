@@ -0,0 +1,71 @@
+package helm
+
+import (
+	"sync"
+
+	"github.com/flant/antiopa/utils"
+)
+
+// Number of rendered manifests kept in RenderCache
+const DefaultRenderCacheSize = 100
+
+// RenderCache keeps manifests rendered by `helm template`. Manifests are keyed
+// by checksums of the chart and values, so unchanged modules are not rendered again.
+type RenderCache struct {
+	m       sync.Mutex
+	size    int
+	entries map[string]string
+	// keys from oldest to newest for eviction
+	order []string
+
+	hits   int
+	misses int
+}
+
+func NewRenderCache(size int) *RenderCache {
+	return &RenderCache{
+		size:    size,
+		entries: make(map[string]string),
+		order:   make([]string, 0),
+	}
+}
+
+// RenderCacheKey returns a key for chart and values checksums
+func RenderCacheKey(chartChecksum string, valuesChecksum string) string {
+	return utils.CalculateChecksum("chart:"+chartChecksum, "values:"+valuesChecksum)
+}
+
+func (c *RenderCache) Get(key string) (string, bool) {
+	c.m.Lock()
+	defer c.m.Unlock()
+
+	manifest, hasManifest := c.entries[key]
+	if hasManifest {
+		c.hits++
+	} else {
+		c.misses++
+	}
+	return manifest, hasManifest
+}
+
+func (c *RenderCache) Put(key string, manifest string) {
+	c.m.Lock()
+	defer c.m.Unlock()
+
+	if _, hasManifest := c.entries[key]; !hasManifest {
+		c.order = append(c.order, key)
+	}
+	c.entries[key] = manifest
+
+	for len(c.order) > c.size {
+		delete(c.entries, c.order[0])
+		c.order = c.order[1:]
+	}
+}
+
+// Stats returns numbers of cache hits and misses
+func (c *RenderCache) Stats() (hits int, misses int) {
+	c.m.Lock()
+	defer c.m.Unlock()
+	return c.hits, c.misses
+}
@@ -0,0 +1,37 @@
+package helm
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRenderCache(t *testing.T) {
+	cache := NewRenderCache(2)
+
+	key1 := RenderCacheKey("chart", "values-1")
+	key2 := RenderCacheKey("chart", "values-2")
+	key3 := RenderCacheKey("chart", "values-3")
+	assert.NotEqual(t, key1, key2)
+
+	_, hasManifest := cache.Get(key1)
+	assert.False(t, hasManifest)
+
+	cache.Put(key1, "manifest-1")
+	cache.Put(key2, "manifest-2")
+
+	manifest, hasManifest := cache.Get(key1)
+	assert.True(t, hasManifest)
+	assert.Equal(t, "manifest-1", manifest)
+
+	// the oldest entry is evicted
+	cache.Put(key3, "manifest-3")
+	_, hasManifest = cache.Get(key1)
+	assert.False(t, hasManifest)
+	_, hasManifest = cache.Get(key3)
+	assert.True(t, hasManifest)
+
+	hits, misses := cache.Stats()
+	assert.Equal(t, 2, hits)
+	assert.Equal(t, 2, misses)
+}
@@ -0,0 +1,105 @@
+package helm
+
+import (
+	"encoding/json"
+	"fmt"
+	"strings"
+)
+
+// SetValueType defines how helm treats a value from the command line
+type SetValueType string
+
+const (
+	// --set: helm coerces "true", "false" and digits into bools and numbers
+	SetValueAuto SetValueType = ""
+	// --set-string: value is always a string
+	SetValueString SetValueType = "string"
+	// --set-file: value is a path to the file, helm sets its content as a string
+	SetValueFile SetValueType = "file"
+	// value is a JSON document, it is passed to helm in a generated values file
+	SetValueJson SetValueType = "json"
+)
+
+// SetValue is a value for `helm upgrade`. Name is a dotted path to the key.
+type SetValue struct {
+	Name  string       `yaml:"name" json:"name"`
+	Value string       `yaml:"value" json:"value"`
+	Type  SetValueType `yaml:"type,omitempty" json:"type,omitempty"`
+}
+
+func NewSetValue(name string, value string) SetValue {
+	return SetValue{Name: name, Value: value}
+}
+
+func NewSetStringValue(name string, value string) SetValue {
+	return SetValue{Name: name, Value: value, Type: SetValueString}
+}
+
+func (v SetValue) String() string {
+	return fmt.Sprintf("%s=%s", v.Name, v.Value)
+}
+
+// Flag returns a helm flag for the value
+func (v SetValue) Flag() string {
+	switch v.Type {
+	case SetValueString:
+		return "--set-string"
+	case SetValueFile:
+		return "--set-file"
+	}
+	return "--set"
+}
+
+func (v SetValue) Validate() error {
+	if v.Name == "" {
+		return fmt.Errorf("set value name is empty")
+	}
+
+	switch v.Type {
+	case SetValueAuto, SetValueString, SetValueFile:
+	case SetValueJson:
+		var value interface{}
+		if err := json.Unmarshal([]byte(v.Value), &value); err != nil {
+			return fmt.Errorf("set value '%s' is not a valid json: %s", v.Name, err)
+		}
+	default:
+		return fmt.Errorf("set value '%s' has unsupported type '%s', expected '%s', '%s' or '%s'", v.Name, v.Type, SetValueString, SetValueFile, SetValueJson)
+	}
+
+	return nil
+}
+
+// JsonSetValuesToValues decodes values with json type into a values map,
+// other values are ignored.
+func JsonSetValuesToValues(setValues []SetValue) (map[string]interface{}, error) {
+	res := make(map[string]interface{})
+	for _, setValue := range setValues {
+		if setValue.Type != SetValueJson {
+			continue
+		}
+
+		var value interface{}
+		if err := json.Unmarshal([]byte(setValue.Value), &value); err != nil {
+			return nil, fmt.Errorf("set value '%s' is not a valid json: %s", setValue.Name, err)
+		}
+
+		setValueByPath(res, setValue.Name, value)
+	}
+	return res, nil
+}
+
+// setValueByPath sets value into the nested map by dotted path.
+// Intermediate maps are created, non-map intermediate values are replaced.
+func setValueByPath(values map[string]interface{}, path string, value interface{}) {
+	keys := strings.Split(path, ".")
+	current := values
+	for _, key := range keys[:len(keys)-1] {
+		next, ok := current[key].(map[string]interface{})
+		if !ok {
+			next = make(map[string]interface{})
+			current[key] = next
+		}
+		current = next
+	}
+	current[keys[len(keys)-1]] = value
+}
@@ -0,0 +1,40 @@
+package helm
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSetValue_Flag(t *testing.T) {
+	assert.Equal(t, "--set", NewSetValue("replicas", "2").Flag())
+	assert.Equal(t, "--set-string", NewSetStringValue("image.tag", "1.10").Flag())
+	assert.Equal(t, "--set-file", SetValue{Name: "config", Value: "config.toml", Type: SetValueFile}.Flag())
+	assert.Equal(t, "image.tag=1.10", NewSetStringValue("image.tag", "1.10").String())
+}
+
+func TestSetValue_Validate(t *testing.T) {
+	assert.NoError(t, NewSetValue("replicas", "2").Validate())
+	assert.NoError(t, SetValue{Name: "hosts", Value: `["a", "b"]`, Type: SetValueJson}.Validate())
+	assert.Error(t, SetValue{Name: "hosts", Value: `["a", `, Type: SetValueJson}.Validate())
+	assert.Error(t, SetValue{Name: "hosts", Value: "a", Type: "yaml"}.Validate())
+	assert.Error(t, NewSetValue("", "2").Validate())
+}
+
+func TestJsonSetValuesToValues(t *testing.T) {
+	values, err := JsonSetValuesToValues([]SetValue{
+		NewSetValue("replicas", "2"),
+		{Name: "image.pullSecrets", Value: `["registry"]`, Type: SetValueJson},
+		{Name: "image.labels", Value: `{"version": "1.10"}`, Type: SetValueJson},
+	})
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	assert.Equal(t, map[string]interface{}{
+		"image": map[string]interface{}{
+			"pullSecrets": []interface{}{"registry"},
+			"labels":      map[string]interface{}{"version": "1.10"},
+		},
+	}, values)
+}
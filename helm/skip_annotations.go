@@ -0,0 +1,130 @@
+package helm
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/go-yaml/yaml"
+)
+
+const (
+	// Object with this annotation is not updated by helm upgrade if it is already in the release.
+	SkipUpdateAnnotation = "antiopa.flant.com/skip-update"
+	// Comma separated paths of fields (e.g. "spec.replicas") that are managed by other controllers.
+	// Values of these fields are not updated by helm upgrade if object is already in the release.
+	PreserveFieldsAnnotation = "antiopa.flant.com/preserve-fields"
+)
+
+// HasSkipAnnotations returns true if any object in manifest has skip-update or preserve-fields annotation
+func HasSkipAnnotations(manifest string) bool {
+	return strings.Contains(manifest, SkipUpdateAnnotation) || strings.Contains(manifest, PreserveFieldsAnnotation)
+}
+
+type manifestObject map[interface{}]interface{}
+
+func (o manifestObject) key() string {
+	metadata, _ := o["metadata"].(map[interface{}]interface{})
+	return fmt.Sprintf("%v/%v/%v", metadata["namespace"], o["kind"], metadata["name"])
+}
+
+func (o manifestObject) annotation(name string) string {
+	metadata, _ := o["metadata"].(map[interface{}]interface{})
+	annotations, _ := metadata["annotations"].(map[interface{}]interface{})
+	value, _ := annotations[name].(string)
+	return value
+}
+
+func parseManifestObjects(manifest string) ([]manifestObject, error) {
+	res := make([]manifestObject, 0)
+	for _, doc := range manifestDocumentSeparator.Split(manifest, -1) {
+		if strings.TrimSpace(doc) == "" {
+			continue
+		}
+		// nested maps get the type of the target map, so they are map[interface{}]interface{} only for the unnamed type
+		var data map[interface{}]interface{}
+		if err := yaml.Unmarshal([]byte(doc), &data); err != nil {
+			return nil, fmt.Errorf("bad manifest document: %s\n%s", err, doc)
+		}
+		obj := manifestObject(data)
+		// comments only or empty templates
+		if obj["kind"] == nil {
+			continue
+		}
+		res = append(res, obj)
+	}
+	return res, nil
+}
+
+// ApplySkipAnnotations returns the rendered manifest where objects with skip-update annotation are
+// replaced with objects from the release manifest and fields from preserve-fields annotation
+// are copied from the release manifest. helm does not patch fields that are equal in the
+// release manifest and the new manifest, so changes of other controllers are kept.
+func ApplySkipAnnotations(rendered string, releaseManifest string) (string, error) {
+	objects, err := parseManifestObjects(rendered)
+	if err != nil {
+		return "", err
+	}
+	releaseObjects, err := parseManifestObjects(releaseManifest)
+	if err != nil {
+		return "", err
+	}
+	releaseObjectsByKey := make(map[string]manifestObject)
+	for _, obj := range releaseObjects {
+		releaseObjectsByKey[obj.key()] = obj
+	}
+
+	docs := make([]string, 0, len(objects))
+	for _, obj := range objects {
+		releaseObj, inRelease := releaseObjectsByKey[obj.key()]
+
+		if inRelease && obj.annotation(SkipUpdateAnnotation) == "true" {
+			obj = releaseObj
+		} else if inRelease {
+			for _, path := range strings.Split(obj.annotation(PreserveFieldsAnnotation), ",") {
+				path = strings.TrimSpace(path)
+				if path == "" {
+					continue
+				}
+				if value, hasValue := getManifestField(releaseObj, path); hasValue {
+					setManifestField(obj, path, value)
+				}
+			}
+		}
+
+		data, err := yaml.Marshal(obj)
+		if err != nil {
+			return "", err
+		}
+		docs = append(docs, string(data))
+	}
+
+	return "---\n" + strings.Join(docs, "---\n"), nil
+}
+
+func getManifestField(obj map[interface{}]interface{}, path string) (interface{}, bool) {
+	keys := strings.Split(path, ".")
+	current := obj
+	for _, key := range keys[:len(keys)-1] {
+		next, ok := current[key].(map[interface{}]interface{})
+		if !ok {
+			return nil, false
+		}
+		current = next
+	}
+	value, hasValue := current[keys[len(keys)-1]]
+	return value, hasValue
+}
+
+func setManifestField(obj map[interface{}]interface{}, path string, value interface{}) {
+	keys := strings.Split(path, ".")
+	current := obj
+	for _, key := range keys[:len(keys)-1] {
+		next, ok := current[key].(map[interface{}]interface{})
+		if !ok {
+			next = make(map[interface{}]interface{})
+			current[key] = next
+		}
+		current = next
+	}
+	current[keys[len(keys)-1]] = value
+}
@@ -0,0 +1,87 @@
+package helm
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestApplySkipAnnotations(t *testing.T) {
+	releaseManifest := `---
+apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: web
+  annotations:
+    antiopa.flant.com/preserve-fields: spec.replicas
+spec:
+  replicas: 2
+  template:
+    spec:
+      containers:
+      - image: web:1
+---
+apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: settings
+  annotations:
+    antiopa.flant.com/skip-update: "true"
+data:
+  key: old
+`
+	rendered := `---
+apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: web
+  annotations:
+    antiopa.flant.com/preserve-fields: spec.replicas
+spec:
+  replicas: 1
+  template:
+    spec:
+      containers:
+      - image: web:2
+---
+apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: settings
+  annotations:
+    antiopa.flant.com/skip-update: "true"
+data:
+  key: new
+---
+apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: created
+  annotations:
+    antiopa.flant.com/skip-update: "true"
+data:
+  key: new
+`
+	assert.True(t, HasSkipAnnotations(rendered))
+
+	res, err := ApplySkipAnnotations(rendered, releaseManifest)
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	objects, err := parseManifestObjects(res)
+	if !assert.NoError(t, err) || !assert.Len(t, objects, 3) {
+		return
+	}
+
+	// replicas are preserved, image is updated
+	replicas, _ := getManifestField(objects[0], "spec.replicas")
+	assert.Equal(t, 2, replicas)
+	assert.Contains(t, res, "image: web:2")
+
+	// existed object is not updated, new object is created
+	key, _ := getManifestField(objects[1], "data.key")
+	assert.Equal(t, "old", key)
+	key, _ = getManifestField(objects[2], "data.key")
+	assert.Equal(t, "new", key)
+}
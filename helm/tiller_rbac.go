@@ -0,0 +1,157 @@
+package helm
+
+import (
+	"fmt"
+	"reflect"
+
+	"github.com/romana/rlog"
+	v1 "k8s.io/api/core/v1"
+	rbacv1beta1 "k8s.io/api/rbac/v1beta1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"github.com/flant/antiopa/kube"
+	"github.com/flant/antiopa/utils"
+)
+
+// ServiceAccount of tiller Deployment
+const TillerServiceAccountName = "antiopa"
+
+// ClusterRole of tillers, it is bound to ServiceAccount in each tiller namespace
+const TillerClusterRoleName = "antiopa-tiller"
+
+// BootstrapTillerRBAC enables creation of ServiceAccount, ClusterRole and ClusterRoleBinding
+// for tiller before helm init. Objects are repaired if they are changed.
+var BootstrapTillerRBAC = false
+
+// tiller installs arbitrary resources of modules charts
+var tillerClusterRoleRules = []rbacv1beta1.PolicyRule{
+	{
+		APIGroups: []string{"*"},
+		Resources: []string{"*"},
+		Verbs:     []string{"*"},
+	},
+	{
+		NonResourceURLs: []string{"*"},
+		Verbs:           []string{"*"},
+	},
+}
+
+func tillerClusterRoleBindingName(tillerNamespace string) string {
+	return fmt.Sprintf("%s-%s", TillerClusterRoleName, tillerNamespace)
+}
+
+// EnsureTillerRBAC creates or repairs ServiceAccount, ClusterRole and ClusterRoleBinding for the tiller
+func EnsureTillerRBAC(tillerNamespace string) error {
+	rlog.Infof("Helm: bootstrap RBAC for tiller in namespace '%s'", tillerNamespace)
+
+	if err := ensureTillerServiceAccount(tillerNamespace); err != nil {
+		return fmt.Errorf("tiller ServiceAccount: %s", err)
+	}
+	if err := ensureTillerClusterRole(); err != nil {
+		return fmt.Errorf("tiller ClusterRole: %s", err)
+	}
+	if err := ensureTillerClusterRoleBinding(tillerNamespace); err != nil {
+		return fmt.Errorf("tiller ClusterRoleBinding: %s", err)
+	}
+	return nil
+}
+
+func ensureTillerServiceAccount(tillerNamespace string) error {
+	serviceAccounts := kube.KubernetesClient.CoreV1().ServiceAccounts(tillerNamespace)
+
+	_, err := serviceAccounts.Get(TillerServiceAccountName, metav1.GetOptions{})
+	if err == nil {
+		rlog.Debugf("Helm: ServiceAccount '%s/%s' is unchanged", tillerNamespace, TillerServiceAccountName)
+		return nil
+	}
+	if !errors.IsNotFound(err) {
+		return err
+	}
+
+	sa := &v1.ServiceAccount{}
+	sa.Name = TillerServiceAccountName
+	if _, err := serviceAccounts.Create(sa); err != nil {
+		return err
+	}
+	rlog.Infof("Helm: ServiceAccount '%s/%s' is created", tillerNamespace, TillerServiceAccountName)
+	return nil
+}
+
+func ensureTillerClusterRole() error {
+	clusterRoles := kube.KubernetesClient.RbacV1beta1().ClusterRoles()
+
+	role, err := clusterRoles.Get(TillerClusterRoleName, metav1.GetOptions{})
+	if errors.IsNotFound(err) {
+		role = &rbacv1beta1.ClusterRole{}
+		role.Name = TillerClusterRoleName
+		role.Rules = tillerClusterRoleRules
+		if _, err := clusterRoles.Create(role); err != nil {
+			return err
+		}
+		rlog.Infof("Helm: ClusterRole '%s' is created", TillerClusterRoleName)
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	if reflect.DeepEqual(role.Rules, tillerClusterRoleRules) {
+		rlog.Debugf("Helm: ClusterRole '%s' is unchanged", TillerClusterRoleName)
+		return nil
+	}
+
+	rlog.Infof("Helm: ClusterRole '%s' rules are repaired:\n--- current\n%s+++ expected\n%s", TillerClusterRoleName, utils.YamlToString(role.Rules), utils.YamlToString(tillerClusterRoleRules))
+	role.Rules = tillerClusterRoleRules
+	_, err = clusterRoles.Update(role)
+	return err
+}
+
+func ensureTillerClusterRoleBinding(tillerNamespace string) error {
+	clusterRoleBindings := kube.KubernetesClient.RbacV1beta1().ClusterRoleBindings()
+	name := tillerClusterRoleBindingName(tillerNamespace)
+
+	roleRef := rbacv1beta1.RoleRef{
+		APIGroup: "rbac.authorization.k8s.io",
+		Kind:     "ClusterRole",
+		Name:     TillerClusterRoleName,
+	}
+	subjects := []rbacv1beta1.Subject{
+		{Kind: "ServiceAccount", Name: TillerServiceAccountName, Namespace: tillerNamespace},
+	}
+
+	binding, err := clusterRoleBindings.Get(name, metav1.GetOptions{})
+	if errors.IsNotFound(err) {
+		binding = &rbacv1beta1.ClusterRoleBinding{}
+		binding.Name = name
+		binding.RoleRef = roleRef
+		binding.Subjects = subjects
+		if _, err := clusterRoleBindings.Create(binding); err != nil {
+			return err
+		}
+		rlog.Infof("Helm: ClusterRoleBinding '%s' is created", name)
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	if binding.RoleRef == roleRef && reflect.DeepEqual(binding.Subjects, subjects) {
+		rlog.Debugf("Helm: ClusterRoleBinding '%s' is unchanged", name)
+		return nil
+	}
+
+	// roleRef is immutable, so the binding is recreated
+	rlog.Infof("Helm: ClusterRoleBinding '%s' is repaired:\n--- current\n%s%s+++ expected\n%s%s", name,
+		utils.YamlToString(binding.RoleRef), utils.YamlToString(binding.Subjects),
+		utils.YamlToString(roleRef), utils.YamlToString(subjects))
+	if err := clusterRoleBindings.Delete(name, &metav1.DeleteOptions{}); err != nil {
+		return err
+	}
+	binding = &rbacv1beta1.ClusterRoleBinding{}
+	binding.Name = name
+	binding.RoleRef = roleRef
+	binding.Subjects = subjects
+	_, err = clusterRoleBindings.Create(binding)
+	return err
+}
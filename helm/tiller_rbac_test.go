@@ -0,0 +1,42 @@
+package helm
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	rbacv1beta1 "k8s.io/api/rbac/v1beta1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+
+	"github.com/flant/antiopa/kube"
+)
+
+func TestEnsureTillerRBAC(t *testing.T) {
+	brokenRole := &rbacv1beta1.ClusterRole{}
+	brokenRole.Name = TillerClusterRoleName
+	brokenRole.Rules = []rbacv1beta1.PolicyRule{{APIGroups: []string{""}, Resources: []string{"pods"}, Verbs: []string{"get"}}}
+
+	clientset := fake.NewSimpleClientset(brokenRole)
+	kube.KubernetesClient = clientset
+
+	// second run does not change objects
+	for i := 0; i < 2; i++ {
+		if !assert.NoError(t, EnsureTillerRBAC("antiopa")) {
+			return
+		}
+	}
+
+	_, err := clientset.CoreV1().ServiceAccounts("antiopa").Get(TillerServiceAccountName, metav1.GetOptions{})
+	assert.NoError(t, err)
+
+	role, err := clientset.RbacV1beta1().ClusterRoles().Get(TillerClusterRoleName, metav1.GetOptions{})
+	if assert.NoError(t, err) {
+		assert.Equal(t, tillerClusterRoleRules, role.Rules)
+	}
+
+	binding, err := clientset.RbacV1beta1().ClusterRoleBindings().Get("antiopa-tiller-antiopa", metav1.GetOptions{})
+	if assert.NoError(t, err) {
+		assert.Equal(t, TillerClusterRoleName, binding.RoleRef.Name)
+		assert.Equal(t, "antiopa", binding.Subjects[0].Namespace)
+	}
+}
@@ -0,0 +1,123 @@
+package helm
+
+import (
+	"reflect"
+	"time"
+
+	"github.com/romana/rlog"
+	appsv1beta1 "k8s.io/api/apps/v1beta1"
+	v1 "k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/watch"
+	"k8s.io/client-go/tools/cache"
+
+	"github.com/flant/antiopa/kube"
+	"github.com/flant/antiopa/utils"
+)
+
+// Name of tiller Deployment created by helm init
+const TillerDeploymentName = "tiller-deploy"
+
+// TillerSchedulingWatcher copies nodeSelector and tolerations of antiopa Deployment
+// into tiller Deployments. helm init copies them only once at start, so changes
+// of antiopa Deployment are applied to tillers by the watcher.
+type TillerSchedulingWatcher struct {
+	// namespaces of installed tillers
+	tillerNamespaces func() []string
+}
+
+func NewTillerSchedulingWatcher(tillerNamespaces func() []string) *TillerSchedulingWatcher {
+	return &TillerSchedulingWatcher{tillerNamespaces: tillerNamespaces}
+}
+
+// Run watches antiopa Deployment until stopCh is closed
+func (w *TillerSchedulingWatcher) Run(stopCh <-chan struct{}) {
+	rlog.Debugf("Helm: run tiller scheduling watcher")
+
+	fieldSelector := fields.OneTermEqualSelector("metadata.name", kube.AntiopaDeploymentName).String()
+	lw := &cache.ListWatch{
+		ListFunc: func(options metav1.ListOptions) (runtime.Object, error) {
+			options.FieldSelector = fieldSelector
+			return kube.KubernetesClient.AppsV1beta1().Deployments(kube.KubernetesAntiopaNamespace).List(options)
+		},
+		WatchFunc: func(options metav1.ListOptions) (watch.Interface, error) {
+			options.FieldSelector = fieldSelector
+			return kube.KubernetesClient.AppsV1beta1().Deployments(kube.KubernetesAntiopaNamespace).Watch(options)
+		},
+	}
+
+	informer := cache.NewSharedInformer(kube.NewRelistingListWatch(lw), &appsv1beta1.Deployment{}, time.Duration(60)*time.Second)
+	informer.AddEventHandler(cache.ResourceEventHandlerFuncs{
+		// tiller could be changed while antiopa was not running
+		AddFunc: func(obj interface{}) {
+			w.sync(obj.(*appsv1beta1.Deployment))
+		},
+		UpdateFunc: func(prevObj interface{}, obj interface{}) {
+			prev := &prevObj.(*appsv1beta1.Deployment).Spec.Template.Spec
+			deploy := obj.(*appsv1beta1.Deployment)
+			if schedulingEqual(prev, &deploy.Spec.Template.Spec) {
+				return
+			}
+			w.sync(deploy)
+		},
+	})
+
+	informer.Run(stopCh)
+}
+
+func (w *TillerSchedulingWatcher) sync(antiopaDeploy *appsv1beta1.Deployment) {
+	for _, tillerNamespace := range w.tillerNamespaces() {
+		if err := SyncTillerScheduling(tillerNamespace, antiopaDeploy); err != nil {
+			rlog.Errorf("Helm: cannot sync scheduling of tiller in namespace '%s': %s", tillerNamespace, err)
+		}
+	}
+}
+
+// SyncTillerScheduling updates nodeSelector and tolerations of tiller Deployment
+// if they differ from antiopa Deployment.
+func SyncTillerScheduling(tillerNamespace string, antiopaDeploy *appsv1beta1.Deployment) error {
+	deployments := kube.KubernetesClient.AppsV1beta1().Deployments(tillerNamespace)
+
+	tillerDeploy, err := deployments.Get(TillerDeploymentName, metav1.GetOptions{})
+	if errors.IsNotFound(err) {
+		rlog.Debugf("Helm: no tiller Deployment in namespace '%s', scheduling is not synced", tillerNamespace)
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	expected := &antiopaDeploy.Spec.Template.Spec
+	current := &tillerDeploy.Spec.Template.Spec
+	if schedulingEqual(current, expected) {
+		return nil
+	}
+
+	rlog.Infof("Helm: tiller in namespace '%s' scheduling is updated:\n--- current\nnodeSelector:\n%stolerations:\n%s+++ expected\nnodeSelector:\n%stolerations:\n%s",
+		tillerNamespace,
+		utils.YamlToString(current.NodeSelector), utils.YamlToString(current.Tolerations),
+		utils.YamlToString(expected.NodeSelector), utils.YamlToString(expected.Tolerations))
+
+	current.NodeSelector = expected.NodeSelector
+	current.Tolerations = expected.Tolerations
+	_, err = deployments.Update(tillerDeploy)
+	return err
+}
+
+// schedulingEqual compares nodeSelector and tolerations of pod specs, nil and empty are equal
+func schedulingEqual(a *v1.PodSpec, b *v1.PodSpec) bool {
+	if len(a.NodeSelector) != 0 || len(b.NodeSelector) != 0 {
+		if !reflect.DeepEqual(a.NodeSelector, b.NodeSelector) {
+			return false
+		}
+	}
+	if len(a.Tolerations) != 0 || len(b.Tolerations) != 0 {
+		if !reflect.DeepEqual(a.Tolerations, b.Tolerations) {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,47 @@
+package helm
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	appsv1beta1 "k8s.io/api/apps/v1beta1"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+
+	"github.com/flant/antiopa/kube"
+)
+
+func TestSyncTillerScheduling(t *testing.T) {
+	tillerDeploy := &appsv1beta1.Deployment{}
+	tillerDeploy.Name = TillerDeploymentName
+	tillerDeploy.Namespace = "antiopa"
+	tillerDeploy.Spec.Template.Spec.NodeSelector = map[string]string{"node-role/system": ""}
+
+	clientset := fake.NewSimpleClientset(tillerDeploy)
+	kube.KubernetesClient = clientset
+
+	antiopaDeploy := &appsv1beta1.Deployment{}
+	antiopaDeploy.Spec.Template.Spec.NodeSelector = map[string]string{"node-role/master": ""}
+	antiopaDeploy.Spec.Template.Spec.Tolerations = []v1.Toleration{
+		{Key: "node-role/master", Operator: v1.TolerationOpExists, Effect: v1.TaintEffectNoSchedule},
+	}
+
+	if !assert.NoError(t, SyncTillerScheduling("antiopa", antiopaDeploy)) {
+		return
+	}
+
+	synced, err := clientset.AppsV1beta1().Deployments("antiopa").Get(TillerDeploymentName, metav1.GetOptions{})
+	if assert.NoError(t, err) {
+		assert.Equal(t, map[string]string{"node-role/master": ""}, synced.Spec.Template.Spec.NodeSelector)
+		assert.Len(t, synced.Spec.Template.Spec.Tolerations, 1)
+	}
+
+	// tiller in other namespace is not installed
+	assert.NoError(t, SyncTillerScheduling("other", antiopaDeploy))
+}
+
+func TestSchedulingEqual(t *testing.T) {
+	assert.True(t, schedulingEqual(&v1.PodSpec{}, &v1.PodSpec{NodeSelector: map[string]string{}}))
+	assert.False(t, schedulingEqual(&v1.PodSpec{}, &v1.PodSpec{Tolerations: []v1.Toleration{{Key: "a"}}}))
+}
@@ -0,0 +1,131 @@
+package helm
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+)
+
+// ReleaseUpgradeResult is a structured output of `helm upgrade --install`
+type ReleaseUpgradeResult struct {
+	Release      string `json:"release"`
+	Revision     int    `json:"revision"`
+	Status       string `json:"status"`
+	Namespace    string `json:"namespace"`
+	LastDeployed string `json:"lastDeployed"`
+	// release did not exist before upgrade
+	Installed bool `json:"installed"`
+	// names of release resources by kind, e.g. "v1/Service"
+	Resources map[string][]string `json:"resources"`
+	Notes     string              `json:"notes,omitempty"`
+}
+
+// ResourcesCount returns a number of resources in the release
+func (r *ReleaseUpgradeResult) ResourcesCount() int {
+	count := 0
+	for _, names := range r.Resources {
+		count += len(names)
+	}
+	return count
+}
+
+func (r *ReleaseUpgradeResult) String() string {
+	action := "upgraded"
+	if r.Installed {
+		action = "installed"
+	}
+	return fmt.Sprintf("release '%s' %s: revision %d, status %s, %d resources", r.Release, action, r.Revision, r.Status, r.ResourcesCount())
+}
+
+// ParseUpgradeOutput parses the output of `helm upgrade --install`:
+//
+//	Release "test" has been upgraded. Happy Helming!
+//	LAST DEPLOYED: Mon Jul  2 12:00:00 2018
+//	NAMESPACE: antiopa
+//	STATUS: DEPLOYED
+//
+//	RESOURCES:
+//	==> v1/Service
+//	NAME  TYPE       CLUSTER-IP  EXTERNAL-IP  PORT(S)  AGE
+//	test  ClusterIP  10.0.0.1    <none>       80/TCP   1s
+//
+//	NOTES:
+//	...
+//
+// Revision is not present in the output and should be requested separately.
+func ParseUpgradeOutput(releaseName string, output string) *ReleaseUpgradeResult {
+	res := &ReleaseUpgradeResult{
+		Release:   releaseName,
+		Resources: make(map[string][]string),
+	}
+
+	const (
+		headerSection = iota
+		resourcesSection
+		notesSection
+	)
+
+	section := headerSection
+	kind := ""
+	skipColumns := false
+	notes := make([]string, 0)
+
+	for _, line := range strings.Split(output, "\n") {
+		trimmed := strings.TrimSpace(line)
+
+		switch {
+		case trimmed == "RESOURCES:":
+			section = resourcesSection
+			continue
+		case trimmed == "NOTES:":
+			section = notesSection
+			continue
+		}
+
+		switch section {
+		case headerSection:
+			if strings.Contains(trimmed, "does not exist. Installing it now.") {
+				res.Installed = true
+			}
+			if kv := strings.SplitN(trimmed, ":", 2); len(kv) == 2 {
+				value := strings.TrimSpace(kv[1])
+				switch kv[0] {
+				case "LAST DEPLOYED":
+					res.LastDeployed = value
+				case "NAMESPACE":
+					res.Namespace = value
+				case "STATUS":
+					res.Status = value
+				}
+			}
+		case resourcesSection:
+			if strings.HasPrefix(trimmed, "==>") {
+				kind = strings.TrimSpace(strings.TrimPrefix(trimmed, "==>"))
+				// pods of deployments etc. are not release resources
+				if strings.HasSuffix(kind, "(related)") {
+					kind = ""
+				}
+				skipColumns = true
+				continue
+			}
+			if trimmed == "" || kind == "" {
+				continue
+			}
+			if skipColumns {
+				skipColumns = false
+				continue
+			}
+			res.Resources[kind] = append(res.Resources[kind], strings.Fields(trimmed)[0])
+		case notesSection:
+			notes = append(notes, line)
+		}
+	}
+
+	res.Notes = strings.TrimSpace(strings.Join(notes, "\n"))
+
+	for kind := range res.Resources {
+		sort.Strings(res.Resources[kind])
+	}
+
+	return res
+}
@@ -0,0 +1,60 @@
+package helm
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseUpgradeOutput(t *testing.T) {
+	output := `Release "test" does not exist. Installing it now.
+NAME:   test
+LAST DEPLOYED: Mon Jul  2 12:00:00 2018
+NAMESPACE: antiopa
+STATUS: DEPLOYED
+
+RESOURCES:
+==> v1/Service
+NAME      TYPE       CLUSTER-IP  EXTERNAL-IP  PORT(S)  AGE
+frontend  ClusterIP  10.0.0.1    <none>       80/TCP   1s
+backend   ClusterIP  10.0.0.2    <none>       80/TCP   1s
+
+==> v1beta1/Deployment
+NAME      DESIRED  CURRENT  UP-TO-DATE  AVAILABLE  AGE
+frontend  1        1        1           0          1s
+
+==> v1/Pod(related)
+NAME                       READY  STATUS   RESTARTS  AGE
+frontend-5c7b8c8b4d-abcde  0/1    Pending  0         1s
+
+
+NOTES:
+Frontend is available at
+  http://frontend.antiopa
+`
+
+	res := ParseUpgradeOutput("test", output)
+
+	assert.Equal(t, &ReleaseUpgradeResult{
+		Release:      "test",
+		Status:       "DEPLOYED",
+		Namespace:    "antiopa",
+		LastDeployed: "Mon Jul  2 12:00:00 2018",
+		Installed:    true,
+		Resources: map[string][]string{
+			"v1/Service":         {"backend", "frontend"},
+			"v1beta1/Deployment": {"frontend"},
+		},
+		Notes: "Frontend is available at\n  http://frontend.antiopa",
+	}, res)
+	assert.Equal(t, 3, res.ResourcesCount())
+}
+
+func TestParseUpgradeOutput_Upgraded(t *testing.T) {
+	res := ParseUpgradeOutput("test", "Release \"test\" has been upgraded. Happy Helming!\nSTATUS: DEPLOYED\n")
+
+	assert.False(t, res.Installed)
+	assert.Equal(t, "DEPLOYED", res.Status)
+	assert.Equal(t, 0, res.ResourcesCount())
+	assert.Equal(t, "", res.Notes)
+}
@@ -0,0 +1,216 @@
+package helm
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"sort"
+
+	"github.com/romana/rlog"
+	"gopkg.in/yaml.v2"
+)
+
+// Tiller receives chart and values of a release in one gRPC message and stores the release
+// gzipped and base64-encoded in a ConfigMap. Values are checked before helm upgrade, so
+// a clear error is returned instead of errors of gRPC or apiserver.
+var (
+	// ValuesMaxSize is a limit of all values files of the release, tiller accepts 20MiB messages
+	ValuesMaxSize = 20 * 1024 * 1024
+	// ValuesFileChunkSize is a size of values file to split it by keys into several --values files,
+	// helm merges them into the same values. Zero disables splitting.
+	ValuesFileChunkSize = 1024 * 1024
+)
+
+// releaseStorageLimit is a limit of ConfigMap data in etcd
+const releaseStorageLimit = 1024 * 1024
+
+// number of the largest keys in ErrValuesTooLarge
+const valuesTooLargeKeys = 5
+
+// prepareValuesFiles checks sizes of values files and splits large files into chunks.
+// Returned func removes chunk files.
+func prepareValuesFiles(releaseName string, valuesPaths []string) ([]string, func(), error) {
+	chunksPaths := make([]string, 0)
+	cleanup := func() {
+		for _, path := range chunksPaths {
+			os.Remove(path)
+		}
+	}
+
+	var all bytes.Buffer
+	valuesByFile := make([]map[interface{}]interface{}, 0, len(valuesPaths))
+	for _, path := range valuesPaths {
+		data, err := ioutil.ReadFile(path)
+		if err != nil {
+			return nil, cleanup, fmt.Errorf("cannot read values file '%s': %s", path, err)
+		}
+		all.Write(data)
+
+		values := make(map[interface{}]interface{})
+		if err := yaml.Unmarshal(data, &values); err != nil {
+			return nil, cleanup, fmt.Errorf("bad values file '%s': %s", path, err)
+		}
+		valuesByFile = append(valuesByFile, values)
+	}
+
+	if all.Len() > ValuesMaxSize {
+		return nil, cleanup, &ErrValuesTooLarge{
+			Release:     releaseName,
+			Size:        all.Len(),
+			Limit:       ValuesMaxSize,
+			Reason:      "size of values files",
+			LargestKeys: largestValuesKeys(valuesByFile),
+		}
+	}
+
+	// release can not be saved if compressed values alone exceed the ConfigMap limit
+	if storedSize := gzippedSize(all.Bytes()) * 4 / 3; storedSize > releaseStorageLimit {
+		return nil, cleanup, &ErrValuesTooLarge{
+			Release:     releaseName,
+			Size:        storedSize,
+			Limit:       releaseStorageLimit,
+			Reason:      "size of compressed values in the release ConfigMap",
+			LargestKeys: largestValuesKeys(valuesByFile),
+		}
+	}
+
+	if ValuesFileChunkSize <= 0 {
+		return valuesPaths, cleanup, nil
+	}
+
+	paths := make([]string, 0, len(valuesPaths))
+	for i, path := range valuesPaths {
+		if fileSize(path) <= ValuesFileChunkSize {
+			paths = append(paths, path)
+			continue
+		}
+
+		chunks := splitValues(valuesByFile[i], ValuesFileChunkSize)
+		rlog.Infof("Helm release '%s': values file '%s' is split into %d files", releaseName, path, len(chunks))
+		for _, chunk := range chunks {
+			chunkPath, err := writeValuesChunk(chunk)
+			if err != nil {
+				return nil, cleanup, err
+			}
+			chunksPaths = append(chunksPaths, chunkPath)
+			paths = append(paths, chunkPath)
+		}
+	}
+
+	return paths, cleanup, nil
+}
+
+// splitValues splits values by keys into maps of at most chunkSize bytes in yaml. Large maps
+// are split by their keys recursively, a large list or string is kept in one chunk.
+func splitValues(values map[interface{}]interface{}, chunkSize int) []map[interface{}]interface{} {
+	chunks := make([]map[interface{}]interface{}, 0)
+	current := make(map[interface{}]interface{})
+	currentSize := 0
+
+	for _, key := range sortedKeys(values) {
+		value := values[key]
+		size := yamlSize(map[interface{}]interface{}{key: value})
+
+		if nested, ok := value.(map[interface{}]interface{}); ok && size > chunkSize {
+			for _, nestedChunk := range splitValues(nested, chunkSize) {
+				chunks = append(chunks, map[interface{}]interface{}{key: nestedChunk})
+			}
+			continue
+		}
+
+		if currentSize > 0 && currentSize+size > chunkSize {
+			chunks = append(chunks, current)
+			current = make(map[interface{}]interface{})
+			currentSize = 0
+		}
+		current[key] = value
+		currentSize += size
+	}
+	if len(current) > 0 {
+		chunks = append(chunks, current)
+	}
+
+	return chunks
+}
+
+func writeValuesChunk(values map[interface{}]interface{}) (string, error) {
+	data, err := yaml.Marshal(values)
+	if err != nil {
+		return "", err
+	}
+
+	f, err := ioutil.TempFile("", "antiopa-values-chunk-")
+	if err != nil {
+		return "", fmt.Errorf("cannot create values chunk file: %s", err)
+	}
+	defer f.Close()
+
+	if _, err := f.Write(data); err != nil {
+		os.Remove(f.Name())
+		return "", fmt.Errorf("cannot write values chunk file: %s", err)
+	}
+
+	return f.Name(), nil
+}
+
+// largestValuesKeys returns top level and nested keys of values files with the largest sizes
+func largestValuesKeys(valuesByFile []map[interface{}]interface{}) []string {
+	type keySize struct {
+		key  string
+		size int
+	}
+	sizes := make([]keySize, 0)
+	for _, values := range valuesByFile {
+		for key, value := range values {
+			if nested, ok := value.(map[interface{}]interface{}); ok {
+				for nestedKey, nestedValue := range nested {
+					sizes = append(sizes, keySize{fmt.Sprintf("%v.%v", key, nestedKey), yamlSize(nestedValue)})
+				}
+				continue
+			}
+			sizes = append(sizes, keySize{fmt.Sprintf("%v", key), yamlSize(value)})
+		}
+	}
+
+	sort.Slice(sizes, func(i, j int) bool { return sizes[i].size > sizes[j].size })
+	res := make([]string, 0, valuesTooLargeKeys)
+	for i := 0; i < len(sizes) && i < valuesTooLargeKeys; i++ {
+		res = append(res, fmt.Sprintf("%s (%d bytes)", sizes[i].key, sizes[i].size))
+	}
+	return res
+}
+
+func sortedKeys(values map[interface{}]interface{}) []interface{} {
+	keys := make([]interface{}, 0, len(values))
+	for key := range values {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool { return fmt.Sprintf("%v", keys[i]) < fmt.Sprintf("%v", keys[j]) })
+	return keys
+}
+
+func yamlSize(value interface{}) int {
+	data, err := yaml.Marshal(value)
+	if err != nil {
+		return 0
+	}
+	return len(data)
+}
+
+func gzippedSize(data []byte) int {
+	var buf bytes.Buffer
+	w := gzip.NewWriter(&buf)
+	w.Write(data)
+	w.Close()
+	return buf.Len()
+}
+
+func fileSize(path string) int {
+	info, err := os.Stat(path)
+	if err != nil {
+		return 0
+	}
+	return int(info.Size())
+}
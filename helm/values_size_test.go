@@ -0,0 +1,76 @@
+package helm
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"gopkg.in/yaml.v2"
+)
+
+func TestSplitValues(t *testing.T) {
+	values := map[interface{}]interface{}{
+		"global": map[interface{}]interface{}{"a": strings.Repeat("a", 40)},
+		"module": map[interface{}]interface{}{
+			"b": strings.Repeat("b", 40),
+			"c": strings.Repeat("c", 40),
+		},
+	}
+
+	chunks := splitValues(values, 60)
+	assert.Len(t, chunks, 3)
+
+	// chunks are merged into the same values
+	merged := make(map[interface{}]interface{})
+	for _, chunk := range chunks {
+		for key, value := range chunk {
+			if existing, ok := merged[key].(map[interface{}]interface{}); ok {
+				for k, v := range value.(map[interface{}]interface{}) {
+					existing[k] = v
+				}
+				continue
+			}
+			merged[key] = value
+		}
+	}
+	assert.Equal(t, values, merged)
+}
+
+func TestPrepareValuesFiles(t *testing.T) {
+	defer func(maxSize, chunkSize int) { ValuesMaxSize, ValuesFileChunkSize = maxSize, chunkSize }(ValuesMaxSize, ValuesFileChunkSize)
+
+	dir, err := ioutil.TempDir("", "values-size")
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer os.RemoveAll(dir)
+
+	values := make(map[string]interface{})
+	for i := 0; i < 10; i++ {
+		values[fmt.Sprintf("key%d", i)] = strings.Repeat("x", 100+i)
+	}
+	data, _ := yaml.Marshal(values)
+	path := filepath.Join(dir, "values.yaml")
+	ioutil.WriteFile(path, data, 0644)
+
+	ValuesFileChunkSize = 500
+	paths, cleanup, err := prepareValuesFiles("test", []string{path})
+	if assert.NoError(t, err) {
+		assert.Len(t, paths, 3)
+	}
+	cleanup()
+	for _, chunkPath := range paths {
+		_, err := os.Stat(chunkPath)
+		assert.True(t, os.IsNotExist(err))
+	}
+
+	ValuesMaxSize = 500
+	_, cleanup, err = prepareValuesFiles("test", []string{path})
+	cleanup()
+	assert.True(t, IsValuesTooLarge(err))
+	assert.Contains(t, err.Error(), "key9 (")
+}
@@ -0,0 +1,105 @@
+package main
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/romana/rlog"
+
+	"github.com/flant/antiopa/module_manager"
+	"github.com/flant/antiopa/task"
+)
+
+// HelmUpgradeWait is a ModuleRun task removed from the queue while helm upgrade of the module
+// waits for helmUpgradeGate from module.yaml
+type HelmUpgradeWait struct {
+	Module  string    `json:"module"`
+	Cause   string    `json:"cause"`
+	Urgent  bool      `json:"urgent"`
+	Reason  string    `json:"reason"`
+	RetryAt time.Time `json:"retryAt"`
+}
+
+// HelmUpgradeWaitsStorage keeps ModuleRun tasks until gates of helm upgrades can be checked again,
+// so the queue runs other tasks meanwhile. One wait per module is kept.
+type HelmUpgradeWaitsStorage struct {
+	m     sync.Mutex
+	waits map[string]*HelmUpgradeWait
+}
+
+func NewHelmUpgradeWaits() *HelmUpgradeWaitsStorage {
+	return &HelmUpgradeWaitsStorage{
+		waits: make(map[string]*HelmUpgradeWait),
+	}
+}
+
+// Wait saves the task until RetryAt of the error
+func (w *HelmUpgradeWaitsStorage) Wait(t task.Task, err *module_manager.ErrHelmUpgradeWaiting) {
+	w.m.Lock()
+	defer w.m.Unlock()
+
+	w.waits[t.GetName()] = &HelmUpgradeWait{
+		Module:  t.GetName(),
+		Cause:   t.GetCause(),
+		Urgent:  t.GetUrgent(),
+		Reason:  err.Reason,
+		RetryAt: err.RetryAt,
+	}
+}
+
+// Forget removes the wait of the module, e.g. after successful run triggered by another event
+func (w *HelmUpgradeWaitsStorage) Forget(moduleName string) {
+	w.m.Lock()
+	defer w.m.Unlock()
+
+	delete(w.waits, moduleName)
+}
+
+// Dump returns waits sorted by module name
+func (w *HelmUpgradeWaitsStorage) Dump() []HelmUpgradeWait {
+	w.m.Lock()
+	defer w.m.Unlock()
+
+	res := make([]HelmUpgradeWait, 0, len(w.waits))
+	for _, wait := range w.waits {
+		res = append(res, *wait)
+	}
+	sort.Slice(res, func(i, j int) bool {
+		return res[i].Module < res[j].Module
+	})
+	return res
+}
+
+// Run periodically queues runs of modules with passed RetryAt
+func (w *HelmUpgradeWaitsStorage) Run() {
+	ticker := time.NewTicker(ModuleRunRetriesCheckPeriod)
+	for range ticker.C {
+		for _, t := range w.due(time.Now()) {
+			TasksQueue.Add(t)
+			rlog.Infof("QUEUE add ModuleRun %s: check helm upgrade gate again", t.GetName())
+		}
+	}
+}
+
+func (w *HelmUpgradeWaitsStorage) due(now time.Time) []task.Task {
+	w.m.Lock()
+	defer w.m.Unlock()
+
+	MetricsStorage.SendGaugeMetric("antiopa_helm_upgrade_waits", float64(len(w.waits)), map[string]string{})
+
+	res := make([]task.Task, 0)
+	for moduleName, wait := range w.waits {
+		if now.Before(wait.RetryAt) {
+			continue
+		}
+		delete(w.waits, moduleName)
+		res = append(res, task.NewTask(task.ModuleRun, moduleName).
+			WithCause(wait.Cause).
+			WithUrgent(wait.Urgent))
+	}
+	sort.Slice(res, func(i, j int) bool {
+		return res[i].GetName() < res[j].GetName()
+	})
+	return res
+}
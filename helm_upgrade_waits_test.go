@@ -0,0 +1,37 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/flant/antiopa/module_manager"
+	"github.com/flant/antiopa/task"
+)
+
+func TestHelmUpgradeWaits(t *testing.T) {
+	waits := NewHelmUpgradeWaits()
+	now := time.Now()
+
+	waiting := task.NewTask(task.ModuleRun, "dex").WithCause("config change").WithUrgent(true)
+	waits.Wait(waiting, &module_manager.ErrHelmUpgradeWaiting{Module: "dex", Reason: "waiting for dex/Secret/dex-tls", RetryAt: now.Add(5 * time.Second)})
+	if dump := waits.Dump(); assert.Len(t, dump, 1) {
+		assert.Equal(t, "waiting for dex/Secret/dex-tls", dump[0].Reason)
+	}
+
+	assert.Len(t, waits.due(now), 0)
+
+	due := waits.due(now.Add(5 * time.Second))
+	if assert.Len(t, due, 1) {
+		assert.Equal(t, "dex", due[0].GetName())
+		assert.Equal(t, "config change", due[0].GetCause())
+		assert.True(t, due[0].GetUrgent())
+		assert.Equal(t, 0, due[0].GetFailureCount())
+	}
+	assert.Len(t, waits.Dump(), 0)
+
+	waits.Wait(waiting, &module_manager.ErrHelmUpgradeWaiting{Module: "dex", RetryAt: now})
+	waits.Forget("dex")
+	assert.Len(t, waits.due(now), 0)
+}
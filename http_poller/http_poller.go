@@ -0,0 +1,210 @@
+package http_poller
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/romana/rlog"
+	"gopkg.in/yaml.v2"
+)
+
+// PollersFileName is a list of polled HTTP endpoints in the working dir
+const PollersFileName = "http-pollers.yaml"
+
+const (
+	DefaultPollInterval = time.Minute
+	DefaultPollTimeout  = 10 * time.Second
+)
+
+// ResponseChangedCh receives pollers with changed responses
+var ResponseChangedCh chan ResponseChange
+
+// ResponseChange is a new response of the poller endpoint
+type ResponseChange struct {
+	Poller   *Poller
+	Checksum string
+	// response parsed as JSON
+	Response interface{}
+}
+
+// Poller fetches JSON from URL with interval. Modules and hooks are run when response is changed.
+type Poller struct {
+	Name     string            `yaml:"name"`
+	Url      string            `yaml:"url"`
+	Headers  map[string]string `yaml:"headers"`
+	Interval string            `yaml:"interval"`
+	Timeout  string            `yaml:"timeout"`
+	// modules to run on change
+	Modules []string `yaml:"modules"`
+	// global or module hooks to run on change with binding context of the poller
+	Hooks []string `yaml:"hooks"`
+
+	interval time.Duration
+	client   *http.Client
+	checksum string
+}
+
+func (p *Poller) init() error {
+	if p.Name == "" {
+		return fmt.Errorf("name is required")
+	}
+	if p.Url == "" {
+		return fmt.Errorf("poller '%s': url is required", p.Name)
+	}
+	if len(p.Modules) == 0 && len(p.Hooks) == 0 {
+		return fmt.Errorf("poller '%s': modules or hooks are required", p.Name)
+	}
+
+	var err error
+	p.interval = DefaultPollInterval
+	if p.Interval != "" {
+		if p.interval, err = time.ParseDuration(p.Interval); err != nil {
+			return fmt.Errorf("poller '%s': bad interval: %s", p.Name, err)
+		}
+	}
+	timeout := DefaultPollTimeout
+	if p.Timeout != "" {
+		if timeout, err = time.ParseDuration(p.Timeout); err != nil {
+			return fmt.Errorf("poller '%s': bad timeout: %s", p.Name, err)
+		}
+	}
+	if p.interval <= 0 || timeout <= 0 {
+		return fmt.Errorf("poller '%s': interval and timeout should be positive", p.Name)
+	}
+	p.client = &http.Client{Timeout: timeout}
+	return nil
+}
+
+// fetch returns checksum of the response body and the body parsed as JSON
+func (p *Poller) fetch() (string, interface{}, error) {
+	req, err := http.NewRequest(http.MethodGet, p.Url, nil)
+	if err != nil {
+		return "", nil, err
+	}
+	req.Header.Set("Accept", "application/json")
+	for name, value := range p.Headers {
+		req.Header.Set(name, os.ExpandEnv(value))
+	}
+
+	resp, err := p.client.Do(req)
+	if err != nil {
+		return "", nil, err
+	}
+	defer resp.Body.Close()
+
+	body, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return "", nil, err
+	}
+	if resp.StatusCode != http.StatusOK {
+		return "", nil, fmt.Errorf("unexpected status %d: %s", resp.StatusCode, string(body))
+	}
+
+	var response interface{}
+	if err := json.Unmarshal(body, &response); err != nil {
+		return "", nil, fmt.Errorf("bad JSON response: %s", err)
+	}
+	// checksum of the re-encoded JSON ignores formatting and order of keys
+	data, _ := json.Marshal(response)
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:]), response, nil
+}
+
+// poll fetches the endpoint and sends a change if checksum is changed.
+// The first response is a baseline: modules are converged on start anyway.
+func (p *Poller) poll() {
+	checksum, response, err := p.fetch()
+	if err != nil {
+		rlog.Errorf("HTTP_POLLER '%s': %s", p.Name, err)
+		return
+	}
+	if checksum == p.checksum {
+		return
+	}
+	if p.checksum == "" {
+		rlog.Infof("HTTP_POLLER '%s': initial response checksum %s", p.Name, checksum)
+		p.checksum = checksum
+		return
+	}
+
+	rlog.Infof("HTTP_POLLER '%s': response changed %s -> %s", p.Name, p.checksum, checksum)
+	p.checksum = checksum
+	ResponseChangedCh <- ResponseChange{Poller: p, Checksum: checksum, Response: response}
+}
+
+type HttpPoller interface {
+	Pollers() []*Poller
+	Run()
+}
+
+type MainHttpPoller struct {
+	pollers []*Poller
+}
+
+func (hp *MainHttpPoller) Pollers() []*Poller {
+	return hp.pollers
+}
+
+// Run polls each endpoint in its own goroutine
+func (hp *MainHttpPoller) Run() {
+	for _, poller := range hp.pollers {
+		go func(p *Poller) {
+			for {
+				p.poll()
+				time.Sleep(p.interval)
+			}
+		}(poller)
+	}
+}
+
+// LoadPollers reads pollers config. Empty list is returned if file is not exists.
+func LoadPollers(path string) ([]*Poller, error) {
+	pollers := make([]*Poller, 0)
+
+	if _, err := os.Stat(path); os.IsNotExist(err) {
+		return pollers, nil
+	}
+
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("cannot read '%s': %s", path, err)
+	}
+	if err := yaml.UnmarshalStrict(data, &pollers); err != nil {
+		return nil, fmt.Errorf("bad %s: %s\n%s", PollersFileName, err, string(data))
+	}
+
+	names := make(map[string]bool)
+	for _, poller := range pollers {
+		if err := poller.init(); err != nil {
+			return nil, fmt.Errorf("bad %s: %s", PollersFileName, err)
+		}
+		if names[poller.Name] {
+			return nil, fmt.Errorf("bad %s: duplicate poller '%s'", PollersFileName, poller.Name)
+		}
+		names[poller.Name] = true
+	}
+
+	return pollers, nil
+}
+
+func Init(path string) (HttpPoller, error) {
+	rlog.Info("Initializing http poller ...")
+
+	ResponseChangedCh = make(chan ResponseChange, 1)
+
+	pollers, err := LoadPollers(path)
+	if err != nil {
+		return nil, err
+	}
+	if len(pollers) > 0 {
+		rlog.Infof("Initialized %d http pollers", len(pollers))
+	}
+
+	return &MainHttpPoller{pollers: pollers}, nil
+}
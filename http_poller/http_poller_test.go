@@ -0,0 +1,72 @@
+package http_poller
+
+import (
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPoller_Poll(t *testing.T) {
+	response := `{"flags": {"ingress": true}}`
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		fmt.Fprint(w, response)
+	}))
+	defer server.Close()
+
+	ResponseChangedCh = make(chan ResponseChange, 1)
+	p := &Poller{Name: "flags", Url: server.URL, Modules: []string{"ingress"}}
+	assert.NoError(t, p.init())
+
+	// first response is a baseline
+	p.poll()
+	assert.Len(t, ResponseChangedCh, 0)
+
+	// formatting is not a change
+	response = `{ "flags": { "ingress": true } }`
+	p.poll()
+	assert.Len(t, ResponseChangedCh, 0)
+
+	response = `{"flags": {"ingress": false}}`
+	p.poll()
+	if assert.Len(t, ResponseChangedCh, 1) {
+		change := <-ResponseChangedCh
+		assert.Equal(t, "flags", change.Poller.Name)
+		assert.Equal(t, map[string]interface{}{"flags": map[string]interface{}{"ingress": false}}, change.Response)
+	}
+}
+
+func TestLoadPollers(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "http-poller")
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer os.RemoveAll(tmpDir)
+	path := filepath.Join(tmpDir, PollersFileName)
+
+	pollers, err := LoadPollers(path)
+	assert.NoError(t, err)
+	assert.Len(t, pollers, 0)
+
+	ioutil.WriteFile(path, []byte(`
+- name: inventory
+  url: http://inventory/api/nodes
+  interval: 5m
+  hooks: [global-hooks/inventory]
+`), 0644)
+	pollers, err = LoadPollers(path)
+	assert.NoError(t, err)
+	assert.Len(t, pollers, 1)
+
+	ioutil.WriteFile(path, []byte(`
+- name: inventory
+  url: http://inventory/api/nodes
+`), 0644)
+	_, err = LoadPollers(path)
+	assert.Error(t, err)
+}
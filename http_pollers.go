@@ -0,0 +1,46 @@
+package main
+
+import (
+	"github.com/romana/rlog"
+
+	"github.com/flant/antiopa/http_poller"
+	"github.com/flant/antiopa/module_manager"
+	"github.com/flant/antiopa/task"
+)
+
+// QueueHttpPollerChange adds ModuleRun tasks for modules of the poller and hook runs
+// with the new response in the binding context.
+func QueueHttpPollerChange(change http_poller.ResponseChange) {
+	poller := change.Poller
+	cause := "http poller " + poller.Name + " response changed"
+
+	for _, moduleName := range poller.Modules {
+		if _, err := ModuleManager.GetModule(moduleName); err != nil {
+			rlog.Errorf("HTTP_POLLER '%s': %s", poller.Name, err)
+			continue
+		}
+		TasksQueue.Add(task.NewTask(task.ModuleRun, moduleName).WithCause(cause))
+		rlog.Infof("QUEUE add ModuleRun %s: %s", moduleName, cause)
+	}
+
+	bindingContext := module_manager.BindingContext{
+		Binding:      module_manager.ContextBindingType[module_manager.HttpPoll],
+		HttpPoller:   poller.Name,
+		HttpResponse: change.Response,
+	}
+	for _, hookName := range poller.Hooks {
+		taskType := task.GlobalHookRun
+		if _, err := ModuleManager.GetGlobalHook(hookName); err != nil {
+			if _, err := ModuleManager.GetModuleHook(hookName); err != nil {
+				rlog.Errorf("HTTP_POLLER '%s': hook '%s' is not found", poller.Name, hookName)
+				continue
+			}
+			taskType = task.ModuleHookRun
+		}
+		newTask := task.NewTask(taskType, hookName).
+			WithBinding(module_manager.HttpPoll).
+			AppendBindingContext(bindingContext)
+		AddHookTask(newTask)
+		rlog.Infof("QUEUE add %s@%s %s: %s", taskType, module_manager.HttpPoll, hookName, cause)
+	}
+}
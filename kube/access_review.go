@@ -0,0 +1,107 @@
+package kube
+
+import (
+	"fmt"
+	"strings"
+
+	authv1 "k8s.io/api/authorization/v1"
+)
+
+// CanI checks with SelfSubjectAccessReview if antiopa service account is allowed to do
+// the verb on the resource. Empty namespace means all namespaces. Reason from the
+// authorizer is returned if access is denied.
+func CanI(verb string, group string, resource string, namespace string) (allowed bool, reason string, err error) {
+	review := &authv1.SelfSubjectAccessReview{
+		Spec: authv1.SelfSubjectAccessReviewSpec{
+			ResourceAttributes: &authv1.ResourceAttributes{
+				Namespace: namespace,
+				Verb:      verb,
+				Group:     group,
+				Resource:  resource,
+			},
+		},
+	}
+
+	res, err := Kubernetes.AuthorizationV1().SelfSubjectAccessReviews().Create(review)
+	if err != nil {
+		return false, "", fmt.Errorf("cannot create SelfSubjectAccessReview for '%s %s': %s", verb, resource, err)
+	}
+
+	return res.Status.Allowed, res.Status.Reason, nil
+}
+
+// Permission is a verb on a resource. Empty namespace means all namespaces.
+// Users is a list of antiopa parts that need the permission, it is shown in reports.
+type Permission struct {
+	Verb      string   `json:"verb"`
+	Group     string   `json:"group"`
+	Resource  string   `json:"resource"`
+	Namespace string   `json:"namespace"`
+	Users     []string `json:"users"`
+}
+
+func (p Permission) String() string {
+	resource := p.Resource
+	if p.Group != "" {
+		resource = fmt.Sprintf("%s.%s", p.Resource, p.Group)
+	}
+	if p.Namespace == "" {
+		return fmt.Sprintf("%s %s in all namespaces", p.Verb, resource)
+	}
+	return fmt.Sprintf("%s %s in namespace '%s'", p.Verb, resource, p.Namespace)
+}
+
+func (p Permission) key() string {
+	return fmt.Sprintf("%s/%s/%s/%s", p.Verb, p.Group, p.Resource, p.Namespace)
+}
+
+// MergePermissions removes duplicates, users of duplicates are merged. Order of first occurrences is kept.
+func MergePermissions(permissions []Permission) []Permission {
+	res := make([]Permission, 0, len(permissions))
+	index := make(map[string]int)
+	for _, perm := range permissions {
+		i, has := index[perm.key()]
+		if !has {
+			index[perm.key()] = len(res)
+			perm.Users = append([]string{}, perm.Users...)
+			res = append(res, perm)
+			continue
+		}
+		for _, user := range perm.Users {
+			if !containsString(res[i].Users, user) {
+				res[i].Users = append(res[i].Users, user)
+			}
+		}
+	}
+	return res
+}
+
+// CheckPermissions returns permissions that are denied for antiopa. All permissions are
+// checked, errors of SelfSubjectAccessReview requests are returned together.
+func CheckPermissions(permissions []Permission) ([]Permission, error) {
+	denied := make([]Permission, 0)
+	errs := make([]string, 0)
+	for _, perm := range MergePermissions(permissions) {
+		allowed, _, err := CanI(perm.Verb, perm.Group, perm.Resource, perm.Namespace)
+		if err != nil {
+			errs = append(errs, err.Error())
+			continue
+		}
+		if !allowed {
+			denied = append(denied, perm)
+		}
+	}
+	if len(errs) > 0 {
+		return denied, fmt.Errorf("%s", strings.Join(errs, "; "))
+	}
+	return denied, nil
+}
+
+func containsString(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,49 @@
+package kube
+
+import (
+	"fmt"
+
+	"k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// ClusterCapacity is a number of schedulable nodes and the sum of their allocatable resources
+type ClusterCapacity struct {
+	Nodes int `json:"nodes"`
+	// allocatable CPU in millicores
+	CPU int64 `json:"cpu"`
+	// allocatable memory in bytes
+	Memory int64 `json:"memory"`
+}
+
+func (c ClusterCapacity) String() string {
+	return fmt.Sprintf("%d nodes, %dm CPU, %dMi memory", c.Nodes, c.CPU, c.Memory/(1024*1024))
+}
+
+// DiscoverNodes returns platforms and capacity of cluster nodes with one request to apiserver
+func DiscoverNodes() ([]NodePlatform, *ClusterCapacity, error) {
+	nodes, err := KubernetesClient.CoreV1().Nodes().List(metav1.ListOptions{})
+	if err != nil {
+		return nil, nil, fmt.Errorf("cannot list nodes: %s", err)
+	}
+	capacity := NodesCapacity(nodes.Items)
+	return NodePlatforms(nodes.Items), &capacity, nil
+}
+
+// NodesCapacity returns capacity of nodes, cordoned nodes are not counted
+func NodesCapacity(nodes []v1.Node) ClusterCapacity {
+	res := ClusterCapacity{}
+	for _, node := range nodes {
+		if node.Spec.Unschedulable {
+			continue
+		}
+		res.Nodes++
+		if cpu, ok := node.Status.Allocatable[v1.ResourceCPU]; ok {
+			res.CPU += cpu.MilliValue()
+		}
+		if memory, ok := node.Status.Allocatable[v1.ResourceMemory]; ok {
+			res.Memory += memory.Value()
+		}
+	}
+	return res
+}
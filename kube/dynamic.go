@@ -0,0 +1,141 @@
+package kube
+
+import (
+	"fmt"
+	"sync"
+
+	"github.com/romana/rlog"
+	"k8s.io/apimachinery/pkg/api/errors"
+	"k8s.io/apimachinery/pkg/api/meta"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime/schema"
+	"k8s.io/client-go/discovery"
+	"k8s.io/client-go/discovery/cached/memory"
+	"k8s.io/client-go/dynamic"
+	"k8s.io/client-go/restmapper"
+)
+
+// dynamicClients are created from RestConfig on the first use, so antiopa does not
+// request discovery at start if no binding or release needs custom resources
+var dynamicClients struct {
+	m sync.Mutex
+	// client for custom resources and other kinds without typed informers
+	client dynamic.Interface
+	// kinds to resources mapper with cached discovery, cache is reset if kind is not found,
+	// so CRDs created after antiopa start are found
+	mapper *restmapper.DeferredDiscoveryRESTMapper
+}
+
+// ErrKindNotRegistered is returned if apiserver has no resource for apiVersion and kind, e.g. CRD is not created yet
+type ErrKindNotRegistered struct {
+	ApiVersion string
+	Kind       string
+}
+
+func (e *ErrKindNotRegistered) Error() string {
+	return fmt.Sprintf("kind '%s' of apiVersion '%s' is not registered in apiserver", e.Kind, e.ApiVersion)
+}
+
+// IsKindNotRegistered returns true if err is ErrKindNotRegistered
+func IsKindNotRegistered(err error) bool {
+	_, ok := err.(*ErrKindNotRegistered)
+	return ok
+}
+
+// resetDynamicClients drops clients created from the previous RestConfig
+func resetDynamicClients() {
+	dynamicClients.m.Lock()
+	defer dynamicClients.m.Unlock()
+	dynamicClients.client = nil
+	dynamicClients.mapper = nil
+}
+
+// initDynamicClients creates the dynamic client and the mapper from RestConfig if they are
+// not created yet. Creation is retried on the next call after an error.
+func initDynamicClients() (dynamic.Interface, *restmapper.DeferredDiscoveryRESTMapper, error) {
+	dynamicClients.m.Lock()
+	defer dynamicClients.m.Unlock()
+
+	if dynamicClients.client != nil {
+		return dynamicClients.client, dynamicClients.mapper, nil
+	}
+	if RestConfig == nil {
+		return nil, nil, fmt.Errorf("dynamic client is not initialized: no connection to the cluster")
+	}
+
+	client, err := dynamic.NewForConfig(RestConfig)
+	if err != nil {
+		return nil, nil, fmt.Errorf("kubernetes dynamic client problem: %s", err)
+	}
+	discoveryClient, err := discovery.NewDiscoveryClientForConfig(RestConfig)
+	if err != nil {
+		return nil, nil, fmt.Errorf("kubernetes discovery client problem: %s", err)
+	}
+
+	rlog.Debugf("KUBE dynamic client is created")
+	dynamicClients.client = client
+	dynamicClients.mapper = restmapper.NewDeferredDiscoveryRESTMapper(memory.NewMemCacheClient(discoveryClient))
+	return dynamicClients.client, dynamicClients.mapper, nil
+}
+
+// DynamicClient returns the client for custom resources and other kinds without typed clients
+func DynamicClient() (dynamic.Interface, error) {
+	client, _, err := initDynamicClients()
+	return client, err
+}
+
+// GroupVersionResource returns the resource for apiVersion and kind and whether it is namespaced
+func GroupVersionResource(apiVersion string, kind string) (schema.GroupVersionResource, bool, error) {
+	_, mapper, err := initDynamicClients()
+	if err != nil {
+		return schema.GroupVersionResource{}, false, fmt.Errorf("kind '%s' of apiVersion '%s': %s", kind, apiVersion, err)
+	}
+
+	gv, err := schema.ParseGroupVersion(apiVersion)
+	if err != nil {
+		return schema.GroupVersionResource{}, false, fmt.Errorf("bad apiVersion '%s': %s", apiVersion, err)
+	}
+	groupKind := schema.GroupKind{Group: gv.Group, Kind: kind}
+
+	mapping, err := mapper.RESTMapping(groupKind, gv.Version)
+	if meta.IsNoMatchError(err) {
+		// discovery cache can be stale, CRD could be created after the cache was filled
+		rlog.Debugf("KUBE kind '%s' of apiVersion '%s' is not found, reset discovery cache", kind, apiVersion)
+		mapper.Reset()
+		mapping, err = mapper.RESTMapping(groupKind, gv.Version)
+	}
+	if meta.IsNoMatchError(err) {
+		return schema.GroupVersionResource{}, false, &ErrKindNotRegistered{ApiVersion: apiVersion, Kind: kind}
+	}
+	if err != nil {
+		return schema.GroupVersionResource{}, false, err
+	}
+
+	return mapping.Resource, mapping.Scope.Name() == meta.RESTScopeNameNamespace, nil
+}
+
+// ObjectExists returns true if the object exists. False is returned if kind is not registered.
+func ObjectExists(apiVersion string, kind string, namespace string, name string) (bool, error) {
+	gvr, namespaced, err := GroupVersionResource(apiVersion, kind)
+	if IsKindNotRegistered(err) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+
+	dynamicClient, err := DynamicClient()
+	if err != nil {
+		return false, err
+	}
+	client := dynamicClient.Resource(gvr)
+	if namespaced {
+		_, err = client.Namespace(namespace).Get(name, metav1.GetOptions{})
+	} else {
+		_, err = client.Get(name, metav1.GetOptions{})
+	}
+	if errors.IsNotFound(err) {
+		return false, nil
+	}
+	return err == nil, err
+}
@@ -0,0 +1,37 @@
+package kube
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"k8s.io/client-go/rest"
+)
+
+func TestDynamicClient_Lazy(t *testing.T) {
+	defer func(c *rest.Config) {
+		RestConfig = c
+		resetDynamicClients()
+	}(RestConfig)
+
+	RestConfig = nil
+	resetDynamicClients()
+	_, err := DynamicClient()
+	assert.Error(t, err)
+	_, _, err = GroupVersionResource("example.com/v1", "Test")
+	assert.Error(t, err)
+
+	// clients are created from the shared config without requests to apiserver
+	assert.NoError(t, InitKubeWithConfig(&rest.Config{Host: "http://127.0.0.1:1"}, "antiopa"))
+	assert.Nil(t, dynamicClients.client)
+
+	client, err := DynamicClient()
+	if assert.NoError(t, err) {
+		assert.NotNil(t, client)
+		same, _ := DynamicClient()
+		assert.True(t, client == same)
+	}
+
+	// new config drops clients of the previous one
+	assert.NoError(t, InitKubeWithConfig(&rest.Config{Host: "http://127.0.0.1:2"}, "antiopa"))
+	assert.Nil(t, dynamicClients.client)
+}
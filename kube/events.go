@@ -0,0 +1,51 @@
+package kube
+
+import (
+	"fmt"
+
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// TaskIdAnnotation is an annotation of events with correlation id of antiopa task
+const TaskIdAnnotation = "antiopa.flant.com/task-id"
+
+// CreateWarningEvent creates a Warning event for the object, so it is visible in `kubectl describe`
+func CreateWarningEvent(object v1.ObjectReference, reason string, message string) error {
+	return createEvent(object, v1.EventTypeWarning, reason, message, nil)
+}
+
+// CreateNormalEvent creates a Normal event for the object
+func CreateNormalEvent(object v1.ObjectReference, reason string, message string) error {
+	return createEvent(object, v1.EventTypeNormal, reason, message, nil)
+}
+
+// CreateTaskEvent creates an event annotated with the correlation id of the task
+func CreateTaskEvent(object v1.ObjectReference, eventType string, reason string, message string, taskId string) error {
+	return createEvent(object, eventType, reason, message, map[string]string{TaskIdAnnotation: taskId})
+}
+
+func createEvent(object v1.ObjectReference, eventType string, reason string, message string, annotations map[string]string) error {
+	now := metav1.Now()
+	event := &v1.Event{
+		ObjectMeta: metav1.ObjectMeta{
+			GenerateName: fmt.Sprintf("%s.", object.Name),
+			Namespace:    object.Namespace,
+			Annotations:  annotations,
+		},
+		InvolvedObject: object,
+		Reason:         reason,
+		Message:        message,
+		Type:           eventType,
+		Source:         v1.EventSource{Component: "antiopa"},
+		FirstTimestamp: now,
+		LastTimestamp:  now,
+		Count:          1,
+	}
+
+	_, err := KubernetesClient.CoreV1().Events(object.Namespace).Create(event)
+	if err != nil {
+		return fmt.Errorf("cannot create event for %s '%s': %s", object.Kind, object.Name, err)
+	}
+	return nil
+}
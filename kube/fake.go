@@ -0,0 +1,86 @@
+package kube
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+
+	"github.com/romana/rlog"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+	"k8s.io/client-go/kubernetes/scheme"
+)
+
+var fixtureDocumentSeparator = regexp.MustCompile(`(?m)^---\s*$`)
+
+// InitFakeKube initializes a fake clientset for dev mode instead of a connection to the cluster.
+// Fake clientset is seeded with objects from *.yaml files in fixturesDir.
+func InitFakeKube(fixturesDir string) error {
+	rlog.Info("KUBE-INIT Using fake kubernetes client")
+
+	objects := make([]runtime.Object, 0)
+	if fixturesDir != "" {
+		var err error
+		objects, err = LoadFixtures(fixturesDir)
+		if err != nil {
+			return err
+		}
+		rlog.Infof("KUBE-INIT Loaded %d objects from fixtures in %s", len(objects), fixturesDir)
+	}
+
+	KubernetesAntiopaNamespace = os.Getenv("ANTIOPA_NAMESPACE")
+	if KubernetesAntiopaNamespace == "" {
+		KubernetesAntiopaNamespace = DefaultNamespace
+	}
+
+	clientset := fake.NewSimpleClientset(objects...)
+	Kubernetes = clientset
+	KubernetesClient = clientset
+
+	return nil
+}
+
+// LoadFixtures decodes kubernetes objects from yaml files in the directory.
+// A file can contain several objects separated with '---'.
+func LoadFixtures(fixturesDir string) ([]runtime.Object, error) {
+	files, err := ioutil.ReadDir(fixturesDir)
+	if err != nil {
+		return nil, fmt.Errorf("cannot read fixtures dir '%s': %s", fixturesDir, err)
+	}
+
+	decoder := scheme.Codecs.UniversalDeserializer()
+
+	objects := make([]runtime.Object, 0)
+	for _, file := range files {
+		if file.IsDir() {
+			continue
+		}
+		ext := filepath.Ext(file.Name())
+		if ext != ".yaml" && ext != ".yml" {
+			continue
+		}
+
+		filePath := filepath.Join(fixturesDir, file.Name())
+		data, err := ioutil.ReadFile(filePath)
+		if err != nil {
+			return nil, fmt.Errorf("cannot read fixture '%s': %s", filePath, err)
+		}
+
+		for _, doc := range fixtureDocumentSeparator.Split(string(data), -1) {
+			if strings.TrimSpace(doc) == "" {
+				continue
+			}
+
+			obj, _, err := decoder.Decode([]byte(doc), nil, nil)
+			if err != nil {
+				return nil, fmt.Errorf("cannot decode object in fixture '%s': %s", filePath, err)
+			}
+			objects = append(objects, obj)
+		}
+	}
+
+	return objects, nil
+}
@@ -0,0 +1,67 @@
+package kube
+
+import (
+	"fmt"
+	"net"
+	"strings"
+
+	"k8s.io/apimachinery/pkg/api/errors"
+)
+
+// CheckApiserver requests the version of apiserver to check that it is available
+func CheckApiserver() error {
+	if Kubernetes == nil {
+		return fmt.Errorf("kube client is not initialized")
+	}
+	_, err := Kubernetes.Discovery().ServerVersion()
+	return err
+}
+
+// ServerGitVersion returns the version of apiserver, e.g. v1.15.3
+func ServerGitVersion() (string, error) {
+	if Kubernetes == nil {
+		return "", fmt.Errorf("kube client is not initialized")
+	}
+	info, err := Kubernetes.Discovery().ServerVersion()
+	if err != nil {
+		return "", err
+	}
+	return info.GitVersion, nil
+}
+
+// Messages of apiserver outages in errors and outputs of hooks, kubectl and helm
+var apiserverUnavailableMessages = []string{
+	"connection refused",
+	"connection reset by peer",
+	"no route to host",
+	"i/o timeout",
+	"TLS handshake timeout",
+	"the server is currently unable to handle the request",
+	"the server was unable to return a response",
+	"Internal error occurred",
+	"Service Unavailable",
+	"Too many requests",
+	"context deadline exceeded",
+}
+
+// IsApiserverUnavailableError returns true if error looks like apiserver is not available:
+// network errors, timeouts and 5xx or 429 responses. Errors of hooks and helm are strings,
+// so messages are also checked.
+func IsApiserverUnavailableError(err error) bool {
+	if err == nil {
+		return false
+	}
+	if errors.IsServerTimeout(err) || errors.IsTimeout(err) || errors.IsInternalError(err) || errors.IsTooManyRequests(err) {
+		return true
+	}
+	if _, ok := err.(net.Error); ok {
+		return true
+	}
+	msg := err.Error()
+	for _, unavailableMsg := range apiserverUnavailableMessages {
+		if strings.Contains(msg, unavailableMsg) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,289 @@
+package kube
+
+import (
+	"encoding/base64"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/romana/rlog"
+	batchv1 "k8s.io/api/batch/v1"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+const (
+	// Label of Jobs and Secrets of hooks run in Kubernetes Jobs
+	HookJobLabel = "antiopa-hook-job"
+	// Input files of the hook are mounted from the Secret into this dir
+	HookJobInputsDir = "/antiopa/inputs"
+	// Hook writes output files into this dir, they are returned with the termination message
+	HookJobOutputsDir = "/antiopa/outputs"
+	// Data of the Secret is limited by etcd
+	HookJobInputsMaxSize = 1024 * 1024
+	// kubelet truncates the termination message to 4096 bytes
+	HookJobOutputsMaxSize = 4096
+	// Default timeout of the hook Job
+	DefaultHookJobTimeout = time.Hour
+)
+
+// Period of hook Job pods status polling
+var HookJobPollPeriod = 2 * time.Second
+
+// HookJobSpec describes a hook run in a Kubernetes Job
+type HookJobSpec struct {
+	// name of the hook for labels and logs
+	Owner string
+	// image should have the hook, sh, base64 and tr
+	Image string
+	// RuntimeClass of the pod for an alternate container runtime, e.g. gVisor or Kata Containers
+	RuntimeClassName string
+	ServiceAccount   string
+	WorkingDir       string
+	Command          []string
+	Env              map[string]string
+	// files mounted into HookJobInputsDir
+	Inputs map[string][]byte
+	// names of files in HookJobOutputsDir that are returned in HookJobResult
+	Outputs []string
+	Timeout time.Duration
+}
+
+// HookJobResult is a result of the hook Job
+type HookJobResult struct {
+	ExitCode int
+	// logs of the hook
+	Output string
+	// non-empty output files by names
+	Outputs map[string][]byte
+}
+
+// outputs are base64 encoded into lines of the termination message, the last line
+// is a marker to detect truncation
+const hookJobOutputsEnd = "end"
+
+const hookJobWrapper = `"$@"
+code=$?
+for name in $ANTIOPA_HOOK_JOB_OUTPUTS; do
+  if [ -s "` + HookJobOutputsDir + `/$name" ]; then
+    printf '%s %s\n' "$name" "$(base64 < "` + HookJobOutputsDir + `/$name" | tr -d '\n')"
+  fi
+done > /dev/termination-log
+echo ` + hookJobOutputsEnd + ` >> /dev/termination-log
+exit $code
+`
+
+// RunHookJob runs the hook in a Job. Inputs are passed in a Secret, outputs are collected
+// from the termination message of the pod. The Job and the Secret are deleted after the run.
+func RunHookJob(spec HookJobSpec) (*HookJobResult, error) {
+	inputsSize := 0
+	for _, data := range spec.Inputs {
+		inputsSize += len(data)
+	}
+	if inputsSize > HookJobInputsMaxSize {
+		return nil, fmt.Errorf("inputs of the hook are %d bytes, limit is %d bytes", inputsSize, HookJobInputsMaxSize)
+	}
+
+	labels := map[string]string{HookJobLabel: NormalizeLabelValue(spec.Owner)}
+
+	secret := &v1.Secret{}
+	secret.GenerateName = "antiopa-hook-job-"
+	secret.Labels = labels
+	secret.Data = spec.Inputs
+	secret, err := Kubernetes.CoreV1().Secrets(KubernetesAntiopaNamespace).Create(secret)
+	if err != nil {
+		return nil, fmt.Errorf("cannot create Secret with inputs: %s", err)
+	}
+	defer func() {
+		err := Kubernetes.CoreV1().Secrets(KubernetesAntiopaNamespace).Delete(secret.Name, &metav1.DeleteOptions{})
+		if err != nil {
+			rlog.Errorf("KUBE hook job '%s': cannot delete Secret '%s': %s", spec.Owner, secret.Name, err)
+		}
+	}()
+
+	timeout := spec.Timeout
+	if timeout <= 0 {
+		timeout = DefaultHookJobTimeout
+	}
+
+	job, err := Kubernetes.BatchV1().Jobs(KubernetesAntiopaNamespace).Create(hookJob(spec, secret.Name, labels, timeout))
+	if err != nil {
+		return nil, fmt.Errorf("cannot create Job: %s", err)
+	}
+	defer func() {
+		// pods of the Job are deleted too
+		propagation := metav1.DeletePropagationBackground
+		err := Kubernetes.BatchV1().Jobs(KubernetesAntiopaNamespace).Delete(job.Name, &metav1.DeleteOptions{PropagationPolicy: &propagation})
+		if err != nil {
+			rlog.Errorf("KUBE hook job '%s': cannot delete Job '%s': %s", spec.Owner, job.Name, err)
+		}
+	}()
+
+	rlog.Infof("KUBE hook job '%s': run Job '%s'", spec.Owner, job.Name)
+
+	pod, err := waitHookJobPod(job.Name, timeout)
+	if err != nil {
+		return nil, fmt.Errorf("Job '%s': %s", job.Name, err)
+	}
+
+	result := &HookJobResult{ExitCode: -1}
+	var message string
+	for _, status := range pod.Status.ContainerStatuses {
+		if status.State.Terminated != nil {
+			result.ExitCode = int(status.State.Terminated.ExitCode)
+			message = status.State.Terminated.Message
+		}
+	}
+
+	logs, err := hookJobPodLogs(pod.Name)
+	if err != nil {
+		rlog.Errorf("KUBE hook job '%s': cannot get logs of pod '%s': %s", spec.Owner, pod.Name, err)
+	}
+	result.Output = string(logs)
+
+	result.Outputs, err = parseHookJobOutputs(message)
+	if err != nil {
+		return result, fmt.Errorf("Job '%s': %s", job.Name, err)
+	}
+
+	return result, nil
+}
+
+// waitHookJobPod returns the completed pod of the Job
+func waitHookJobPod(jobName string, timeout time.Duration) (*v1.Pod, error) {
+	deadline := time.Now().Add(timeout)
+	for {
+		pods, err := Kubernetes.CoreV1().Pods(KubernetesAntiopaNamespace).List(metav1.ListOptions{
+			LabelSelector: fmt.Sprintf("job-name=%s", jobName),
+		})
+		if err != nil {
+			return nil, fmt.Errorf("cannot list pods: %s", err)
+		}
+		for i := range pods.Items {
+			pod := &pods.Items[i]
+			if pod.Status.Phase == v1.PodSucceeded || pod.Status.Phase == v1.PodFailed {
+				return pod, nil
+			}
+		}
+		if time.Now().After(deadline) {
+			return nil, fmt.Errorf("timeout %s exceeded", timeout)
+		}
+		time.Sleep(HookJobPollPeriod)
+	}
+}
+
+func parseHookJobOutputs(message string) (map[string][]byte, error) {
+	lines := strings.Split(strings.TrimRight(message, "\n"), "\n")
+	if lines[len(lines)-1] != hookJobOutputsEnd {
+		return nil, fmt.Errorf("outputs of the hook are lost or exceed %d bytes limit of the termination message", HookJobOutputsMaxSize)
+	}
+
+	outputs := make(map[string][]byte)
+	for _, line := range lines[:len(lines)-1] {
+		parts := strings.SplitN(line, " ", 2)
+		if len(parts) != 2 {
+			return nil, fmt.Errorf("bad output line '%s'", line)
+		}
+		data, err := base64.StdEncoding.DecodeString(parts[1])
+		if err != nil {
+			return nil, fmt.Errorf("bad output '%s': %s", parts[0], err)
+		}
+		outputs[parts[0]] = data
+	}
+	return outputs, nil
+}
+
+// hookJobPodLogs returns output of the hook Job pod, fake clientset has no logs
+var hookJobPodLogs = func(podName string) ([]byte, error) {
+	return Kubernetes.CoreV1().
+		Pods(KubernetesAntiopaNamespace).
+		GetLogs(podName, &v1.PodLogOptions{}).
+		Do().Raw()
+}
+
+func hookJob(spec HookJobSpec, secretName string, labels map[string]string, timeout time.Duration) *batchv1.Job {
+	env := []v1.EnvVar{{Name: "ANTIOPA_HOOK_JOB_OUTPUTS", Value: strings.Join(spec.Outputs, " ")}}
+	for name, value := range spec.Env {
+		env = append(env, v1.EnvVar{Name: name, Value: value})
+	}
+	sort.Slice(env, func(i, j int) bool {
+		return env[i].Name < env[j].Name
+	})
+
+	backoffLimit := int32(0)
+	activeDeadlineSeconds := int64(timeout.Seconds())
+
+	podSpec := v1.PodSpec{
+		RestartPolicy:      v1.RestartPolicyNever,
+		ServiceAccountName: spec.ServiceAccount,
+		Containers: []v1.Container{
+			{
+				Name:       "hook",
+				Image:      spec.Image,
+				Command:    append([]string{"sh", "-c", hookJobWrapper, "--"}, spec.Command...),
+				WorkingDir: spec.WorkingDir,
+				Env:        env,
+				VolumeMounts: []v1.VolumeMount{
+					{Name: "inputs", MountPath: HookJobInputsDir, ReadOnly: true},
+					{Name: "outputs", MountPath: HookJobOutputsDir},
+				},
+				TerminationMessagePolicy: v1.TerminationMessageReadFile,
+			},
+		},
+		Volumes: []v1.Volume{
+			{Name: "inputs", VolumeSource: v1.VolumeSource{Secret: &v1.SecretVolumeSource{SecretName: secretName}}},
+			{Name: "outputs", VolumeSource: v1.VolumeSource{EmptyDir: &v1.EmptyDirVolumeSource{}}},
+		},
+	}
+	if spec.RuntimeClassName != "" {
+		runtimeClassName := spec.RuntimeClassName
+		podSpec.RuntimeClassName = &runtimeClassName
+	}
+
+	return &batchv1.Job{
+		ObjectMeta: metav1.ObjectMeta{
+			GenerateName: "antiopa-hook-job-",
+			Labels:       labels,
+		},
+		Spec: batchv1.JobSpec{
+			BackoffLimit:          &backoffLimit,
+			ActiveDeadlineSeconds: &activeDeadlineSeconds,
+			Template: v1.PodTemplateSpec{
+				ObjectMeta: metav1.ObjectMeta{Labels: labels},
+				Spec:       podSpec,
+			},
+		},
+	}
+}
+
+// CleanupHookJobs deletes Jobs and Secrets of hooks left by the previous antiopa process
+func CleanupHookJobs() {
+	selector := metav1.ListOptions{LabelSelector: HookJobLabel}
+	propagation := metav1.DeletePropagationBackground
+
+	jobs, err := Kubernetes.BatchV1().Jobs(KubernetesAntiopaNamespace).List(selector)
+	if err != nil {
+		rlog.Errorf("KUBE cannot list hook Jobs left by previous run: %s", err)
+	} else {
+		for _, job := range jobs.Items {
+			rlog.Infof("KUBE delete hook Job '%s' left by previous run", job.Name)
+			err := Kubernetes.BatchV1().Jobs(KubernetesAntiopaNamespace).Delete(job.Name, &metav1.DeleteOptions{PropagationPolicy: &propagation})
+			if err != nil {
+				rlog.Errorf("KUBE cannot delete hook Job '%s': %s", job.Name, err)
+			}
+		}
+	}
+
+	secrets, err := Kubernetes.CoreV1().Secrets(KubernetesAntiopaNamespace).List(selector)
+	if err != nil {
+		rlog.Errorf("KUBE cannot list Secrets of hook Jobs left by previous run: %s", err)
+		return
+	}
+	for _, secret := range secrets.Items {
+		err := Kubernetes.CoreV1().Secrets(KubernetesAntiopaNamespace).Delete(secret.Name, &metav1.DeleteOptions{})
+		if err != nil {
+			rlog.Errorf("KUBE cannot delete Secret '%s' of hook Job: %s", secret.Name, err)
+		}
+	}
+}
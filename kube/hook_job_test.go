@@ -0,0 +1,224 @@
+package kube
+
+import (
+	"encoding/base64"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	batchv1 "k8s.io/api/batch/v1"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+// fakeHookJobs returns a clientset where created Jobs are completed at once with the termination message
+func fakeHookJobs(t *testing.T, exitCode int32, message string) (*fake.Clientset, *[]*batchv1.Job, *[]*v1.Secret) {
+	clientset := fake.NewSimpleClientset()
+	jobs := []*batchv1.Job{}
+	secrets := []*v1.Secret{}
+
+	clientset.PrependReactor("create", "secrets", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		secret := action.(k8stesting.CreateAction).GetObject().(*v1.Secret)
+		secret.Name = secret.GenerateName + "inputs"
+		secrets = append(secrets, secret.DeepCopy())
+		return false, nil, nil
+	})
+	clientset.PrependReactor("create", "jobs", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		job := action.(k8stesting.CreateAction).GetObject().(*batchv1.Job)
+		job.Name = job.GenerateName + "abcde"
+		jobs = append(jobs, job.DeepCopy())
+
+		phase := v1.PodSucceeded
+		if exitCode != 0 {
+			phase = v1.PodFailed
+		}
+		pod := &v1.Pod{
+			ObjectMeta: metav1.ObjectMeta{Name: job.Name + "-xyz", Namespace: "antiopa", Labels: map[string]string{"job-name": job.Name}},
+			Status: v1.PodStatus{
+				Phase: phase,
+				ContainerStatuses: []v1.ContainerStatus{
+					{Name: "hook", State: v1.ContainerState{Terminated: &v1.ContainerStateTerminated{ExitCode: exitCode, Message: message}}},
+				},
+			},
+		}
+		assert.NoError(t, clientset.Tracker().Add(pod))
+		return false, nil, nil
+	})
+	return clientset, &jobs, &secrets
+}
+
+func TestRunHookJob(t *testing.T) {
+	defer func(k kubernetes.Interface, namespace string, period time.Duration, logs func(string) ([]byte, error)) {
+		Kubernetes = k
+		KubernetesAntiopaNamespace = namespace
+		HookJobPollPeriod = period
+		hookJobPodLogs = logs
+	}(Kubernetes, KubernetesAntiopaNamespace, HookJobPollPeriod, hookJobPodLogs)
+	KubernetesAntiopaNamespace = "antiopa"
+	HookJobPollPeriod = time.Millisecond
+	hookJobPodLogs = func(podName string) ([]byte, error) {
+		return []byte("output of " + podName), nil
+	}
+
+	patch := `[{"op":"add","path":"/global/a","value":1}]`
+	message := "values.json-patch " + base64.StdEncoding.EncodeToString([]byte(patch)) + "\nend\n"
+	clientset, jobs, secrets := fakeHookJobs(t, 0, message)
+	Kubernetes = clientset
+
+	result, err := RunHookJob(HookJobSpec{
+		Owner:            "global-hooks/startup",
+		Image:            "antiopa:stable",
+		RuntimeClassName: "gvisor",
+		ServiceAccount:   "antiopa-hook-global-hooks-startup",
+		WorkingDir:       "/antiopa",
+		Command:          []string{"/antiopa/global-hooks/startup"},
+		Env:              map[string]string{"VALUES_PATH": HookJobInputsDir + "/values.json"},
+		Inputs:           map[string][]byte{"values.json": []byte(`{"global":{}}`)},
+		Outputs:          []string{"config-values.json-patch", "values.json-patch"},
+		Timeout:          time.Minute,
+	})
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.Equal(t, 0, result.ExitCode)
+	assert.Equal(t, "output of antiopa-hook-job-abcde-xyz", result.Output)
+	assert.Equal(t, map[string][]byte{"values.json-patch": []byte(patch)}, result.Outputs)
+
+	// inputs are passed in the Secret
+	if assert.Len(t, *secrets, 1) {
+		assert.Equal(t, map[string][]byte{"values.json": []byte(`{"global":{}}`)}, (*secrets)[0].Data)
+		assert.Equal(t, "global_hooks_startup", (*secrets)[0].Labels[HookJobLabel])
+	}
+
+	if assert.Len(t, *jobs, 1) {
+		job := (*jobs)[0]
+		assert.Equal(t, int32(0), *job.Spec.BackoffLimit)
+		assert.Equal(t, int64(60), *job.Spec.ActiveDeadlineSeconds)
+
+		pod := job.Spec.Template.Spec
+		assert.Equal(t, v1.RestartPolicyNever, pod.RestartPolicy)
+		assert.Equal(t, "antiopa-hook-global-hooks-startup", pod.ServiceAccountName)
+		if assert.NotNil(t, pod.RuntimeClassName) {
+			assert.Equal(t, "gvisor", *pod.RuntimeClassName)
+		}
+		assert.Equal(t, "antiopa-hook-job-inputs", pod.Volumes[0].Secret.SecretName)
+		assert.NotNil(t, pod.Volumes[1].EmptyDir)
+
+		container := pod.Containers[0]
+		assert.Equal(t, "antiopa:stable", container.Image)
+		assert.Equal(t, []string{"sh", "-c", hookJobWrapper, "--", "/antiopa/global-hooks/startup"}, container.Command)
+		assert.Equal(t, []v1.EnvVar{
+			{Name: "ANTIOPA_HOOK_JOB_OUTPUTS", Value: "config-values.json-patch values.json-patch"},
+			{Name: "VALUES_PATH", Value: "/antiopa/inputs/values.json"},
+		}, container.Env)
+	}
+
+	// Job and Secret are deleted after the run
+	jobsList, err := clientset.BatchV1().Jobs("antiopa").List(metav1.ListOptions{})
+	if assert.NoError(t, err) {
+		assert.Empty(t, jobsList.Items)
+	}
+	secretsList, err := clientset.CoreV1().Secrets("antiopa").List(metav1.ListOptions{})
+	if assert.NoError(t, err) {
+		assert.Empty(t, secretsList.Items)
+	}
+}
+
+func TestRunHookJob_Limits(t *testing.T) {
+	defer func(k kubernetes.Interface, namespace string, period time.Duration, logs func(string) ([]byte, error)) {
+		Kubernetes = k
+		KubernetesAntiopaNamespace = namespace
+		HookJobPollPeriod = period
+		hookJobPodLogs = logs
+	}(Kubernetes, KubernetesAntiopaNamespace, HookJobPollPeriod, hookJobPodLogs)
+	KubernetesAntiopaNamespace = "antiopa"
+	HookJobPollPeriod = time.Millisecond
+	hookJobPodLogs = func(podName string) ([]byte, error) {
+		return nil, nil
+	}
+
+	// inputs are not fit into the Secret, nothing is created
+	clientset, jobs, secrets := fakeHookJobs(t, 0, "end")
+	Kubernetes = clientset
+	_, err := RunHookJob(HookJobSpec{Owner: "hook", Inputs: map[string][]byte{"values.json": make([]byte, HookJobInputsMaxSize+1)}})
+	assert.EqualError(t, err, "inputs of the hook are 1048577 bytes, limit is 1048576 bytes")
+	assert.Empty(t, *jobs)
+	assert.Empty(t, *secrets)
+
+	// kubelet truncates the message with outputs
+	clientset, _, _ = fakeHookJobs(t, 1, "values.json-patch W3sib3AiOiJhZGQiL")
+	Kubernetes = clientset
+	result, err := RunHookJob(HookJobSpec{Owner: "hook", Outputs: []string{"values.json-patch"}})
+	assert.EqualError(t, err, "Job 'antiopa-hook-job-abcde': outputs of the hook are lost or exceed 4096 bytes limit of the termination message")
+	if assert.NotNil(t, result) {
+		assert.Equal(t, 1, result.ExitCode)
+	}
+}
+
+func TestHookJobWrapper(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "hook-job")
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer os.RemoveAll(tmpDir)
+
+	// wrapper is run locally with dirs of the container replaced
+	messagePath := filepath.Join(tmpDir, "termination-log")
+	wrapper := strings.Replace(hookJobWrapper, "/dev/termination-log", messagePath, -1)
+	wrapper = strings.Replace(wrapper, HookJobOutputsDir, tmpDir, -1)
+
+	cmd := exec.Command("sh", "-c", wrapper, "--", "sh", "-c", `printf '[]' > "$1/values.json-patch"; exit 3`, "hook", tmpDir)
+	cmd.Env = append(os.Environ(), "ANTIOPA_HOOK_JOB_OUTPUTS=config-values.json-patch values.json-patch")
+	err = cmd.Run()
+	if exitErr, ok := err.(*exec.ExitError); assert.True(t, ok, "%v", err) {
+		assert.Equal(t, 3, exitErr.ExitCode())
+	}
+
+	message, err := ioutil.ReadFile(messagePath)
+	if !assert.NoError(t, err) {
+		return
+	}
+	outputs, err := parseHookJobOutputs(string(message))
+	if assert.NoError(t, err) {
+		assert.Equal(t, map[string][]byte{"values.json-patch": []byte("[]")}, outputs)
+	}
+}
+
+func TestCleanupHookJobs(t *testing.T) {
+	defer func(k kubernetes.Interface, namespace string) {
+		Kubernetes = k
+		KubernetesAntiopaNamespace = namespace
+	}(Kubernetes, KubernetesAntiopaNamespace)
+	KubernetesAntiopaNamespace = "antiopa"
+
+	meta := func(name string, labels map[string]string) metav1.ObjectMeta {
+		return metav1.ObjectMeta{Name: name, Namespace: "antiopa", Labels: labels}
+	}
+	clientset := fake.NewSimpleClientset(
+		&batchv1.Job{ObjectMeta: meta("antiopa-hook-job-abcde", map[string]string{HookJobLabel: "hook"})},
+		&batchv1.Job{ObjectMeta: meta("backup", nil)},
+		&v1.Secret{ObjectMeta: meta("antiopa-hook-job-fghij", map[string]string{HookJobLabel: "hook"})},
+		&v1.Secret{ObjectMeta: meta("registry", nil)},
+	)
+	Kubernetes = clientset
+
+	CleanupHookJobs()
+
+	jobs, err := clientset.BatchV1().Jobs("antiopa").List(metav1.ListOptions{})
+	if assert.NoError(t, err) && assert.Len(t, jobs.Items, 1) {
+		assert.Equal(t, "backup", jobs.Items[0].Name)
+	}
+	secrets, err := clientset.CoreV1().Secrets("antiopa").List(metav1.ListOptions{})
+	if assert.NoError(t, err) && assert.Len(t, secrets.Items, 1) {
+		assert.Equal(t, "registry", secrets.Items[0].Name)
+	}
+}
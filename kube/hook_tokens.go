@@ -0,0 +1,301 @@
+package kube
+
+import (
+	"crypto/sha256"
+	"fmt"
+	"reflect"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/romana/rlog"
+	authenticationv1 "k8s.io/api/authentication/v1"
+	v1 "k8s.io/api/core/v1"
+	rbacv1 "k8s.io/api/rbac/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+// HookClusterRole is bound to ServiceAccounts of hooks without clusterRole in kubernetesScope.
+// ServiceAccounts of these hooks have no bindings if it is empty.
+var HookClusterRole = ""
+
+// HookTokenExpiration is used for hooks without timeout. TokenRequest API does not issue
+// tokens for less than 10 minutes.
+var HookTokenExpiration = time.Hour
+
+const hookTokenMinExpiration = 10 * time.Minute
+
+// Label of RoleBindings and ClusterRoleBindings with the name of ServiceAccount of the hook
+const HookServiceAccountLabel = "antiopa.flant.com/hook-service-account"
+
+// HookScope is RBAC of kubectl in the hook. ServiceAccount is created in the namespace of antiopa.
+type HookScope struct {
+	ServiceAccount string
+	ClusterRole    string
+	// ClusterRole is bound with RoleBindings in these namespaces, with ClusterRoleBinding if empty
+	Namespaces []string
+}
+
+func (s HookScope) key() string {
+	namespaces := append([]string{}, s.Namespaces...)
+	sort.Strings(namespaces)
+	return fmt.Sprintf("%s/%s", s.ClusterRole, strings.Join(namespaces, ","))
+}
+
+var notNameChars = regexp.MustCompile(`[^a-z0-9]+`)
+
+// HookServiceAccountName returns a name of ServiceAccount of the hook, e.g. antiopa-hook-nginx-hooks-certs.
+// Long names are cut and suffixed with a checksum of the hook name.
+func HookServiceAccountName(hookName string) string {
+	name := "antiopa-hook-" + strings.Trim(notNameChars.ReplaceAllString(strings.ToLower(hookName), "-"), "-")
+	if len(name) <= 63 {
+		return name
+	}
+	sum := fmt.Sprintf("%x", sha256.Sum256([]byte(hookName)))[:8]
+	return strings.TrimRight(name[:54], "-") + "-" + sum
+}
+
+// scopes of ServiceAccounts that are ensured since start, RBAC objects are checked once for each scope
+var ensuredHookScopes = struct {
+	m      sync.Mutex
+	scopes map[string]string
+}{scopes: make(map[string]string)}
+
+// HookToken returns a new token of ServiceAccount of the hook. ServiceAccount and bindings
+// are created on the first use and repaired when the scope is changed. Token expires after
+// the timeout of the hook, HookTokenExpiration is used if timeout is 0.
+func HookToken(scope HookScope, timeout time.Duration) (string, error) {
+	if err := ensureHookScope(scope); err != nil {
+		return "", fmt.Errorf("hook ServiceAccount '%s': %s", scope.ServiceAccount, err)
+	}
+
+	expiration := timeout
+	if expiration <= 0 {
+		expiration = HookTokenExpiration
+	}
+	if expiration < hookTokenMinExpiration {
+		expiration = hookTokenMinExpiration
+	}
+	seconds := int64(expiration.Seconds())
+	tokenRequest := &authenticationv1.TokenRequest{
+		Spec: authenticationv1.TokenRequestSpec{ExpirationSeconds: &seconds},
+	}
+
+	res, err := KubernetesClient.CoreV1().ServiceAccounts(KubernetesAntiopaNamespace).CreateToken(scope.ServiceAccount, tokenRequest)
+	if err != nil {
+		return "", fmt.Errorf("cannot request token of ServiceAccount '%s': %s", scope.ServiceAccount, err)
+	}
+	return res.Status.Token, nil
+}
+
+// EnsureHookScope creates ServiceAccount of the hook with bindings of the scope without
+// a token, it is used by hooks that run in pods with this ServiceAccount.
+func EnsureHookScope(scope HookScope) error {
+	if err := ensureHookScope(scope); err != nil {
+		return fmt.Errorf("hook ServiceAccount '%s': %s", scope.ServiceAccount, err)
+	}
+	return nil
+}
+
+func ensureHookScope(scope HookScope) error {
+	ensuredHookScopes.m.Lock()
+	defer ensuredHookScopes.m.Unlock()
+
+	if ensuredHookScopes.scopes[scope.ServiceAccount] == scope.key() {
+		return nil
+	}
+
+	if err := ensureHookServiceAccount(scope.ServiceAccount); err != nil {
+		return err
+	}
+	if err := ensureHookBindings(scope); err != nil {
+		return err
+	}
+
+	if scope.ClusterRole == "" {
+		rlog.Infof("KUBE hook ServiceAccount '%s/%s' has no bindings", KubernetesAntiopaNamespace, scope.ServiceAccount)
+	} else {
+		rlog.Infof("KUBE hook ServiceAccount '%s/%s' is bound to ClusterRole '%s' in %s", KubernetesAntiopaNamespace, scope.ServiceAccount, scope.ClusterRole, hookScopeNamespaces(scope))
+	}
+	ensuredHookScopes.scopes[scope.ServiceAccount] = scope.key()
+	return nil
+}
+
+func hookScopeNamespaces(scope HookScope) string {
+	if len(scope.Namespaces) == 0 {
+		return "all namespaces"
+	}
+	return fmt.Sprintf("namespaces %s", strings.Join(scope.Namespaces, ", "))
+}
+
+func ensureHookServiceAccount(name string) error {
+	serviceAccounts := KubernetesClient.CoreV1().ServiceAccounts(KubernetesAntiopaNamespace)
+
+	_, err := serviceAccounts.Get(name, metav1.GetOptions{})
+	if err == nil || !errors.IsNotFound(err) {
+		return err
+	}
+
+	sa := &v1.ServiceAccount{}
+	sa.Name = name
+	sa.Labels = map[string]string{HookServiceAccountLabel: name}
+	_, err = serviceAccounts.Create(sa)
+	return err
+}
+
+// ensureHookBindings creates bindings of the scope and deletes bindings of the previous scope.
+// Scope without ClusterRole has no bindings.
+func ensureHookBindings(scope HookScope) error {
+	name := scope.ServiceAccount
+	labels := map[string]string{HookServiceAccountLabel: name}
+	roleRef := rbacv1.RoleRef{APIGroup: "rbac.authorization.k8s.io", Kind: "ClusterRole", Name: scope.ClusterRole}
+	subjects := []rbacv1.Subject{{Kind: "ServiceAccount", Name: name, Namespace: KubernetesAntiopaNamespace}}
+	selector := metav1.ListOptions{LabelSelector: fmt.Sprintf("%s=%s", HookServiceAccountLabel, name)}
+
+	clusterRoleBindings := Kubernetes.RbacV1().ClusterRoleBindings()
+	if scope.ClusterRole != "" && len(scope.Namespaces) == 0 {
+		if err := ensureHookClusterRoleBinding(name, labels, roleRef, subjects); err != nil {
+			return err
+		}
+	} else if err := clusterRoleBindings.Delete(name, &metav1.DeleteOptions{}); err != nil && !errors.IsNotFound(err) {
+		return err
+	}
+
+	namespaces := make(map[string]bool)
+	if scope.ClusterRole != "" {
+		for _, namespace := range scope.Namespaces {
+			namespaces[namespace] = true
+			if err := ensureHookRoleBinding(namespace, name, labels, roleRef, subjects); err != nil {
+				return err
+			}
+		}
+	}
+
+	list, err := Kubernetes.RbacV1().RoleBindings("").List(selector)
+	if err != nil {
+		return err
+	}
+	for _, roleBinding := range list.Items {
+		if namespaces[roleBinding.Namespace] {
+			continue
+		}
+		err := Kubernetes.RbacV1().RoleBindings(roleBinding.Namespace).Delete(roleBinding.Name, &metav1.DeleteOptions{})
+		if err != nil && !errors.IsNotFound(err) {
+			return err
+		}
+	}
+	return nil
+}
+
+// roleRef cannot be changed, binding with other roleRef is recreated
+func ensureHookClusterRoleBinding(name string, labels map[string]string, roleRef rbacv1.RoleRef, subjects []rbacv1.Subject) error {
+	clusterRoleBindings := Kubernetes.RbacV1().ClusterRoleBindings()
+
+	binding, err := clusterRoleBindings.Get(name, metav1.GetOptions{})
+	if err == nil && reflect.DeepEqual(binding.RoleRef, roleRef) && reflect.DeepEqual(binding.Subjects, subjects) {
+		return nil
+	}
+	if err == nil {
+		if err := clusterRoleBindings.Delete(name, &metav1.DeleteOptions{}); err != nil && !errors.IsNotFound(err) {
+			return err
+		}
+	} else if !errors.IsNotFound(err) {
+		return err
+	}
+
+	binding = &rbacv1.ClusterRoleBinding{RoleRef: roleRef, Subjects: subjects}
+	binding.Name = name
+	binding.Labels = labels
+	_, err = clusterRoleBindings.Create(binding)
+	return err
+}
+
+func ensureHookRoleBinding(namespace string, name string, labels map[string]string, roleRef rbacv1.RoleRef, subjects []rbacv1.Subject) error {
+	roleBindings := Kubernetes.RbacV1().RoleBindings(namespace)
+
+	binding, err := roleBindings.Get(name, metav1.GetOptions{})
+	if err == nil && reflect.DeepEqual(binding.RoleRef, roleRef) && reflect.DeepEqual(binding.Subjects, subjects) {
+		return nil
+	}
+	if err == nil {
+		if err := roleBindings.Delete(name, &metav1.DeleteOptions{}); err != nil && !errors.IsNotFound(err) {
+			return err
+		}
+	} else if !errors.IsNotFound(err) {
+		return err
+	}
+
+	binding = &rbacv1.RoleBinding{RoleRef: roleRef, Subjects: subjects}
+	binding.Name = name
+	binding.Namespace = namespace
+	binding.Labels = labels
+	_, err = roleBindings.Create(binding)
+	return err
+}
+
+// CleanupHookServiceAccounts deletes labeled ServiceAccounts that are not in owned with
+// their bindings. Hooks can be removed or renamed, their rights should not be kept.
+func CleanupHookServiceAccounts(owned []string) {
+	ownedNames := make(map[string]bool)
+	for _, name := range owned {
+		ownedNames[name] = true
+	}
+
+	serviceAccounts, err := KubernetesClient.CoreV1().ServiceAccounts(KubernetesAntiopaNamespace).List(metav1.ListOptions{
+		LabelSelector: HookServiceAccountLabel,
+	})
+	if err != nil {
+		rlog.Errorf("KUBE cannot list ServiceAccounts of hooks: %s", err)
+		return
+	}
+
+	for _, sa := range serviceAccounts.Items {
+		if ownedNames[sa.Name] {
+			continue
+		}
+		if err := deleteHookServiceAccount(sa.Name); err != nil {
+			rlog.Errorf("KUBE cannot delete ServiceAccount '%s' of removed hook: %s", sa.Name, err)
+			continue
+		}
+		rlog.Infof("KUBE ServiceAccount '%s' of removed hook is deleted with its bindings", sa.Name)
+	}
+}
+
+func deleteHookServiceAccount(name string) error {
+	selector := metav1.ListOptions{LabelSelector: fmt.Sprintf("%s=%s", HookServiceAccountLabel, name)}
+
+	clusterRoleBindings, err := Kubernetes.RbacV1().ClusterRoleBindings().List(selector)
+	if err != nil {
+		return err
+	}
+	for _, binding := range clusterRoleBindings.Items {
+		err := Kubernetes.RbacV1().ClusterRoleBindings().Delete(binding.Name, &metav1.DeleteOptions{})
+		if err != nil && !errors.IsNotFound(err) {
+			return err
+		}
+	}
+
+	roleBindings, err := Kubernetes.RbacV1().RoleBindings("").List(selector)
+	if err != nil {
+		return err
+	}
+	for _, binding := range roleBindings.Items {
+		err := Kubernetes.RbacV1().RoleBindings(binding.Namespace).Delete(binding.Name, &metav1.DeleteOptions{})
+		if err != nil && !errors.IsNotFound(err) {
+			return err
+		}
+	}
+
+	err = KubernetesClient.CoreV1().ServiceAccounts(KubernetesAntiopaNamespace).Delete(name, &metav1.DeleteOptions{})
+	if err != nil && !errors.IsNotFound(err) {
+		return err
+	}
+
+	ensuredHookScopes.m.Lock()
+	delete(ensuredHookScopes.scopes, name)
+	ensuredHookScopes.m.Unlock()
+	return nil
+}
@@ -0,0 +1,121 @@
+package kube
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	authenticationv1 "k8s.io/api/authentication/v1"
+	v1 "k8s.io/api/core/v1"
+	rbacv1 "k8s.io/api/rbac/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+func TestHookServiceAccountName(t *testing.T) {
+	assert.Equal(t, "antiopa-hook-nginx-hooks-certs-sh", HookServiceAccountName("nginx/hooks/certs.sh"))
+
+	name := HookServiceAccountName("very-long-module-name/hooks/very/long/path/to/the/hook/of/the/module.py")
+	assert.Len(t, name, 63)
+	assert.NotEqual(t, name, HookServiceAccountName("very-long-module-name/hooks/very/long/path/to/the/hook/of/the/other.py"))
+}
+
+func TestHookToken(t *testing.T) {
+	defer func(c Client, k kubernetes.Interface, namespace string) {
+		KubernetesClient = c
+		Kubernetes = k
+		KubernetesAntiopaNamespace = namespace
+	}(KubernetesClient, Kubernetes, KubernetesAntiopaNamespace)
+	KubernetesAntiopaNamespace = "antiopa"
+
+	clientset := fake.NewSimpleClientset()
+	clientset.PrependReactor("create", "serviceaccounts", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		if action.GetSubresource() != "token" {
+			return false, nil, nil
+		}
+		tokenRequest := action.(k8stesting.CreateAction).GetObject().(*authenticationv1.TokenRequest)
+		assert.Equal(t, int64(3600), *tokenRequest.Spec.ExpirationSeconds)
+		return true, &authenticationv1.TokenRequest{Status: authenticationv1.TokenRequestStatus{Token: "hook-token"}}, nil
+	})
+	KubernetesClient = clientset
+	Kubernetes = clientset
+
+	scope := HookScope{ServiceAccount: "antiopa-hook-ingress", ClusterRole: "view", Namespaces: []string{"kube-system", "ingress"}}
+	token, err := HookToken(scope, 0)
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.Equal(t, "hook-token", token)
+
+	_, err = clientset.CoreV1().ServiceAccounts("antiopa").Get("antiopa-hook-ingress", metav1.GetOptions{})
+	assert.NoError(t, err)
+	binding, err := clientset.RbacV1().RoleBindings("ingress").Get("antiopa-hook-ingress", metav1.GetOptions{})
+	if assert.NoError(t, err) {
+		assert.Equal(t, "view", binding.RoleRef.Name)
+		assert.Equal(t, "antiopa", binding.Subjects[0].Namespace)
+	}
+	_, err = clientset.RbacV1().ClusterRoleBindings().Get("antiopa-hook-ingress", metav1.GetOptions{})
+	assert.Error(t, err)
+
+	// scope is changed to the whole cluster, RoleBindings are deleted
+	scope.Namespaces = nil
+	_, err = HookToken(scope, 0)
+	assert.NoError(t, err)
+	clusterBinding, err := clientset.RbacV1().ClusterRoleBindings().Get("antiopa-hook-ingress", metav1.GetOptions{})
+	if assert.NoError(t, err) {
+		assert.Equal(t, "view", clusterBinding.RoleRef.Name)
+	}
+	_, err = clientset.RbacV1().RoleBindings("ingress").Get("antiopa-hook-ingress", metav1.GetOptions{})
+	assert.Error(t, err)
+
+	// scope without ClusterRole has no bindings
+	scope.ClusterRole = ""
+	_, err = HookToken(scope, 0)
+	assert.NoError(t, err)
+	_, err = clientset.RbacV1().ClusterRoleBindings().Get("antiopa-hook-ingress", metav1.GetOptions{})
+	assert.Error(t, err)
+	_, err = clientset.CoreV1().ServiceAccounts("antiopa").Get("antiopa-hook-ingress", metav1.GetOptions{})
+	assert.NoError(t, err)
+}
+
+func TestCleanupHookServiceAccounts(t *testing.T) {
+	defer func(c Client, k kubernetes.Interface, namespace string) {
+		KubernetesClient = c
+		Kubernetes = k
+		KubernetesAntiopaNamespace = namespace
+	}(KubernetesClient, Kubernetes, KubernetesAntiopaNamespace)
+	KubernetesAntiopaNamespace = "antiopa"
+
+	labels := func(name string) map[string]string {
+		return map[string]string{HookServiceAccountLabel: name}
+	}
+	clientset := fake.NewSimpleClientset(
+		&v1.ServiceAccount{ObjectMeta: metav1.ObjectMeta{Name: "antiopa-hook-ingress", Namespace: "antiopa", Labels: labels("antiopa-hook-ingress")}},
+		&v1.ServiceAccount{ObjectMeta: metav1.ObjectMeta{Name: "antiopa-hook-removed", Namespace: "antiopa", Labels: labels("antiopa-hook-removed")}},
+		&v1.ServiceAccount{ObjectMeta: metav1.ObjectMeta{Name: "antiopa", Namespace: "antiopa"}},
+		&rbacv1.ClusterRoleBinding{ObjectMeta: metav1.ObjectMeta{Name: "antiopa-hook-removed", Labels: labels("antiopa-hook-removed")}},
+		&rbacv1.RoleBinding{ObjectMeta: metav1.ObjectMeta{Name: "antiopa-hook-removed", Namespace: "kube-system", Labels: labels("antiopa-hook-removed")}},
+		&rbacv1.RoleBinding{ObjectMeta: metav1.ObjectMeta{Name: "antiopa-hook-ingress", Namespace: "ingress", Labels: labels("antiopa-hook-ingress")}},
+	)
+	KubernetesClient = clientset
+	Kubernetes = clientset
+
+	CleanupHookServiceAccounts([]string{"antiopa-hook-ingress"})
+
+	serviceAccounts, err := clientset.CoreV1().ServiceAccounts("antiopa").List(metav1.ListOptions{})
+	if assert.NoError(t, err) {
+		names := make([]string, 0)
+		for _, sa := range serviceAccounts.Items {
+			names = append(names, sa.Name)
+		}
+		assert.ElementsMatch(t, []string{"antiopa", "antiopa-hook-ingress"}, names)
+	}
+	_, err = clientset.RbacV1().ClusterRoleBindings().Get("antiopa-hook-removed", metav1.GetOptions{})
+	assert.Error(t, err)
+	_, err = clientset.RbacV1().RoleBindings("kube-system").Get("antiopa-hook-removed", metav1.GetOptions{})
+	assert.Error(t, err)
+	_, err = clientset.RbacV1().RoleBindings("ingress").Get("antiopa-hook-ingress", metav1.GetOptions{})
+	assert.NoError(t, err)
+}
@@ -0,0 +1,47 @@
+package kube
+
+import (
+	"strings"
+)
+
+type builtinKind struct {
+	Group      string
+	Resource   string
+	Namespaced bool
+}
+
+// kinds that are watched with typed clients, apiVersion is not required for them
+var builtinKinds = map[string]builtinKind{
+	"namespace":             {"", "namespaces", false},
+	"cronjob":               {"batch", "cronjobs", true},
+	"daemonset":             {"apps", "daemonsets", true},
+	"deployment":            {"apps", "deployments", true},
+	"job":                   {"batch", "jobs", true},
+	"pod":                   {"", "pods", true},
+	"replicaset":            {"apps", "replicasets", true},
+	"replicationcontroller": {"", "replicationcontrollers", true},
+	"statefulset":           {"apps", "statefulsets", true},
+	"endpoints":             {"", "endpoints", true},
+	"ingress":               {"extensions", "ingresses", true},
+	"service":               {"", "services", true},
+	"configmap":             {"", "configmaps", true},
+	"secret":                {"", "secrets", true},
+	"persistentvolumeclaim": {"", "persistentvolumeclaims", true},
+	"storageclass":          {"storage.k8s.io", "storageclasses", false},
+	"node":                  {"", "nodes", false},
+	"serviceaccount":        {"", "serviceaccounts", true},
+}
+
+// KindResource returns api group and resource of the kind. Kinds that are not built-in are
+// resolved with discovery, so custom resources require apiVersion and a registered CRD.
+func KindResource(apiVersion string, kind string) (group string, resource string, namespaced bool, err error) {
+	if builtin, ok := builtinKinds[strings.ToLower(kind)]; ok {
+		return builtin.Group, builtin.Resource, builtin.Namespaced, nil
+	}
+
+	gvr, namespaced, err := GroupVersionResource(apiVersion, kind)
+	if err != nil {
+		return "", "", false, err
+	}
+	return gvr.Group, gvr.Resource, namespaced, nil
+}
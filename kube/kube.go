@@ -54,59 +54,87 @@ func IsRunningOutOfKubeCluster() bool {
 func InitKube() {
 	rlog.Info("KUBE Init Kubernetes client")
 
-	var err error
-	var config *rest.Config
+	config, err := LoadRestConfig()
+	if err != nil {
+		rlog.Errorf("KUBE-INIT %s", err)
+		os.Exit(1)
+	}
 
-	if IsRunningOutOfKubeCluster() {
-		rlog.Info("KUBE-INIT Connecting to kubernetes out-of-cluster")
+	namespace, err := DetectNamespace()
+	if err != nil {
+		rlog.Errorf("KUBE-INIT %s", err)
+		os.Exit(1)
+	}
 
-		var kubeconfig string
-		if kubeconfig = os.Getenv("KUBECONFIG"); kubeconfig == "" {
-			kubeconfig = filepath.Join(os.Getenv("HOME"), ".kube", "config")
-		}
-		rlog.Infof("KUBE-INIT Using kube config at %s", kubeconfig)
+	if err := InitKubeWithConfig(config, namespace); err != nil {
+		rlog.Errorf("KUBE-INIT %s", err)
+		os.Exit(1)
+	}
 
-		// use the current context in kubeconfig
-		config, err = clientcmd.BuildConfigFromFlags("", kubeconfig)
-		if err != nil {
-			rlog.Errorf("KUBE-INIT Kubernetes out-of-cluster configuration problem: %s", err)
-			os.Exit(1)
-		}
-	} else {
-		rlog.Info("KUBE-INIT Connecting to kubernetes in-cluster")
+	rlog.Info("KUBE-INIT Successfully connected to kubernetes")
+}
 
-		config, err = rest.InClusterConfig()
+// LoadRestConfig returns in-cluster config or config from KUBECONFIG or ~/.kube/config
+// when antiopa is running out of cluster
+func LoadRestConfig() (*rest.Config, error) {
+	if !IsRunningOutOfKubeCluster() {
+		rlog.Info("KUBE-INIT Connecting to kubernetes in-cluster")
+		config, err := rest.InClusterConfig()
 		if err != nil {
-			rlog.Errorf("KUBE-INIT Kubernetes in-cluster configuration problem: %s", err)
-			os.Exit(1)
+			return nil, fmt.Errorf("kubernetes in-cluster configuration problem: %s", err)
 		}
+		return config, nil
 	}
 
+	rlog.Info("KUBE-INIT Connecting to kubernetes out-of-cluster")
+
+	var kubeconfig string
+	if kubeconfig = os.Getenv("KUBECONFIG"); kubeconfig == "" {
+		kubeconfig = filepath.Join(os.Getenv("HOME"), ".kube", "config")
+	}
+	rlog.Infof("KUBE-INIT Using kube config at %s", kubeconfig)
+
+	// use the current context in kubeconfig
+	config, err := clientcmd.BuildConfigFromFlags("", kubeconfig)
+	if err != nil {
+		return nil, fmt.Errorf("kubernetes out-of-cluster configuration problem: %s", err)
+	}
+	return config, nil
+}
+
+// DetectNamespace returns namespace of the ServiceAccount, ANTIOPA_NAMESPACE or DefaultNamespace
+func DetectNamespace() (string, error) {
 	if _, err := os.Stat(KubeNamespaceFilePath); !os.IsNotExist(err) {
 		res, err := ioutil.ReadFile(KubeNamespaceFilePath)
 		if err != nil {
-			rlog.Errorf("KUBE-INIT Cannot read namespace from %s: %s", KubeNamespaceFilePath, err)
-			os.Exit(1)
+			return "", fmt.Errorf("cannot read namespace from %s: %s", KubeNamespaceFilePath, err)
+		}
+		if namespace := string(res); namespace != "" {
+			return namespace, nil
 		}
-
-		KubernetesAntiopaNamespace = string(res)
-	}
-	if KubernetesAntiopaNamespace == "" {
-		KubernetesAntiopaNamespace = os.Getenv("ANTIOPA_NAMESPACE")
 	}
-	if KubernetesAntiopaNamespace == "" {
-		KubernetesAntiopaNamespace = DefaultNamespace
+	if namespace := os.Getenv("ANTIOPA_NAMESPACE"); namespace != "" {
+		return namespace, nil
 	}
+	return DefaultNamespace, nil
+}
 
+// InitKubeWithConfig initializes clients of the package with the config. It is used by
+// InitKube and by custom distributions that load config and namespace by themselves.
+// The config is shared by all clients, the dynamic client and discovery are created on first use.
+func InitKubeWithConfig(config *rest.Config, namespace string) error {
 	clientset, err := kubernetes.NewForConfig(config)
 	if err != nil {
-		rlog.Errorf("KUBE-INIT Kubernetes connection problem: %s", err)
-		os.Exit(1)
+		return fmt.Errorf("kubernetes connection problem: %s", err)
 	}
+
+	KubernetesAntiopaNamespace = namespace
 	Kubernetes = clientset
 	KubernetesClient = clientset
+	RestConfig = config
+	resetDynamicClients()
 
-	rlog.Info("KUBE-INIT Successfully connected to kubernetes")
+	return nil
 }
 
 func KubeGetDeploymentImageName() string {
@@ -0,0 +1,49 @@
+package kube
+
+import (
+	"fmt"
+
+	"k8s.io/client-go/rest"
+	"k8s.io/client-go/tools/clientcmd"
+	clientcmdapi "k8s.io/client-go/tools/clientcmd/api"
+)
+
+const HookKubeConfigContext = "antiopa"
+
+// RestConfig is a configuration of connection to the cluster.
+// It is nil if fake client is used.
+var RestConfig *rest.Config
+
+// WriteKubeConfig writes a kubeconfig for hooks into path. Kubeconfig has one context
+// with apiserver address, the token of ServiceAccount of the hook and a default namespace.
+// Credentials of antiopa are not written, only the CA of apiserver is copied.
+func WriteKubeConfig(path string, namespace string, token string) error {
+	if RestConfig == nil {
+		return fmt.Errorf("kubernetes client is not initialized")
+	}
+
+	cluster := clientcmdapi.NewCluster()
+	cluster.Server = RestConfig.Host
+	cluster.CertificateAuthority = RestConfig.TLSClientConfig.CAFile
+	cluster.CertificateAuthorityData = RestConfig.TLSClientConfig.CAData
+	cluster.InsecureSkipTLSVerify = RestConfig.TLSClientConfig.Insecure
+
+	authInfo := clientcmdapi.NewAuthInfo()
+	authInfo.Token = token
+
+	context := clientcmdapi.NewContext()
+	context.Cluster = HookKubeConfigContext
+	context.AuthInfo = HookKubeConfigContext
+	context.Namespace = namespace
+
+	config := clientcmdapi.NewConfig()
+	config.Clusters[HookKubeConfigContext] = cluster
+	config.AuthInfos[HookKubeConfigContext] = authInfo
+	config.Contexts[HookKubeConfigContext] = context
+	config.CurrentContext = HookKubeConfigContext
+
+	if err := clientcmd.WriteToFile(*config, path); err != nil {
+		return fmt.Errorf("cannot write kubeconfig to '%s': %s", path, err)
+	}
+	return nil
+}
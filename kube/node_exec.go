@@ -0,0 +1,205 @@
+package kube
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/romana/rlog"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	kblabels "k8s.io/apimachinery/pkg/labels"
+)
+
+const (
+	// Image for node exec pods, it should have nsenter
+	DefaultNodeExecImage = "debian:stretch-slim"
+	// Default timeout for command on a node
+	DefaultNodeExecTimeout = 5 * time.Minute
+	// Label for node exec pods
+	NodeExecLabel = "antiopa-node-exec"
+)
+
+// Period of node exec pods status polling
+var NodeExecPollPeriod = 2 * time.Second
+
+// Max number of node exec pods of one command run at once
+var NodeExecParallelism = 10
+
+// NodeExecSpec describes a command to run in the host namespaces of selected nodes
+type NodeExecSpec struct {
+	// name of the owner (hook) for pods labels and logs
+	Owner        string
+	Command      []string
+	NodeSelector map[string]string
+	Image        string
+	Timeout      time.Duration
+}
+
+// NodeExecResult is an output of the command on one node
+type NodeExecResult struct {
+	Node     string `json:"node"`
+	ExitCode int    `json:"exitCode"`
+	Output   string `json:"output"`
+	// error if command is not completed: pod is not started, timeout, etc.
+	Error string `json:"error,omitempty"`
+}
+
+// RunOnNodes runs the command on selected nodes with privileged pods that enter the host namespaces
+// of PID 1 with nsenter. Pods are run in parallel, at most NodeExecParallelism at once,
+// and deleted after logs are collected. It is an error if no node matches the selector.
+func RunOnNodes(spec NodeExecSpec) ([]NodeExecResult, error) {
+	selector := kblabels.Set(spec.NodeSelector).AsSelector().String()
+	nodes, err := KubernetesClient.CoreV1().Nodes().List(metav1.ListOptions{
+		LabelSelector: selector,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("cannot list nodes: %s", err)
+	}
+	if len(nodes.Items) == 0 {
+		return nil, fmt.Errorf("no nodes match selector '%s'", selector)
+	}
+
+	rlog.Infof("NODE_EXEC '%s': run on %d nodes: %s", spec.Owner, len(nodes.Items), strings.Join(spec.Command, " "))
+
+	parallelism := NodeExecParallelism
+	if parallelism <= 0 {
+		parallelism = 1
+	}
+	slots := make(chan struct{}, parallelism)
+
+	results := make([]NodeExecResult, len(nodes.Items))
+	var wg sync.WaitGroup
+	for i, node := range nodes.Items {
+		wg.Add(1)
+		go func(i int, nodeName string) {
+			defer wg.Done()
+			slots <- struct{}{}
+			defer func() { <-slots }()
+			results[i] = runOnNode(spec, nodeName)
+		}(i, node.Name)
+	}
+	wg.Wait()
+
+	return results, nil
+}
+
+func runOnNode(spec NodeExecSpec, nodeName string) NodeExecResult {
+	result := NodeExecResult{Node: nodeName, ExitCode: -1}
+
+	pod, err := KubernetesClient.CoreV1().Pods(KubernetesAntiopaNamespace).Create(nodeExecPod(spec, nodeName))
+	if err != nil {
+		result.Error = fmt.Sprintf("cannot create pod: %s", err)
+		return result
+	}
+	defer func() {
+		err := KubernetesClient.CoreV1().Pods(KubernetesAntiopaNamespace).Delete(pod.Name, &metav1.DeleteOptions{})
+		if err != nil {
+			rlog.Errorf("NODE_EXEC '%s': cannot delete pod '%s': %s", spec.Owner, pod.Name, err)
+		}
+	}()
+
+	timeout := spec.Timeout
+	if timeout <= 0 {
+		timeout = DefaultNodeExecTimeout
+	}
+	deadline := time.Now().Add(timeout)
+
+	for {
+		pod, err = KubernetesClient.CoreV1().Pods(KubernetesAntiopaNamespace).Get(pod.Name, metav1.GetOptions{})
+		if err != nil {
+			result.Error = fmt.Sprintf("cannot get pod: %s", err)
+			return result
+		}
+		if pod.Status.Phase == v1.PodSucceeded || pod.Status.Phase == v1.PodFailed {
+			break
+		}
+		if time.Now().After(deadline) {
+			result.Error = fmt.Sprintf("timeout %s exceeded, pod phase is '%s'", timeout, pod.Status.Phase)
+			return result
+		}
+		time.Sleep(NodeExecPollPeriod)
+	}
+
+	for _, status := range pod.Status.ContainerStatuses {
+		if status.State.Terminated != nil {
+			result.ExitCode = int(status.State.Terminated.ExitCode)
+		}
+	}
+
+	logs, err := nodeExecPodLogs(pod.Name)
+	if err != nil {
+		result.Error = fmt.Sprintf("cannot get logs: %s", err)
+		return result
+	}
+	result.Output = string(logs)
+
+	rlog.Debugf("NODE_EXEC '%s': node '%s' exit code %d", spec.Owner, nodeName, result.ExitCode)
+
+	return result
+}
+
+// CleanupNodeExecPods deletes node exec pods left by the previous antiopa process,
+// e.g. if it is restarted during the hook run.
+func CleanupNodeExecPods() {
+	pods, err := KubernetesClient.CoreV1().Pods(KubernetesAntiopaNamespace).List(metav1.ListOptions{
+		LabelSelector: NodeExecLabel,
+	})
+	if err != nil {
+		rlog.Errorf("NODE_EXEC cannot list pods left by previous run: %s", err)
+		return
+	}
+	for _, pod := range pods.Items {
+		rlog.Infof("NODE_EXEC delete pod '%s' left by previous run", pod.Name)
+		err := KubernetesClient.CoreV1().Pods(KubernetesAntiopaNamespace).Delete(pod.Name, &metav1.DeleteOptions{})
+		if err != nil {
+			rlog.Errorf("NODE_EXEC cannot delete pod '%s': %s", pod.Name, err)
+		}
+	}
+}
+
+// nodeExecPodLogs returns output of the node exec pod, fake clientset has no logs
+var nodeExecPodLogs = func(podName string) ([]byte, error) {
+	return KubernetesClient.CoreV1().
+		Pods(KubernetesAntiopaNamespace).
+		GetLogs(podName, &v1.PodLogOptions{}).
+		Do().Raw()
+}
+
+func nodeExecPod(spec NodeExecSpec, nodeName string) *v1.Pod {
+	image := spec.Image
+	if image == "" {
+		image = DefaultNodeExecImage
+	}
+	privileged := true
+
+	command := append([]string{"nsenter", "--target", "1", "--mount", "--uts", "--ipc", "--net", "--pid", "--"}, spec.Command...)
+
+	return &v1.Pod{
+		ObjectMeta: metav1.ObjectMeta{
+			GenerateName: "antiopa-node-exec-",
+			Labels: map[string]string{
+				NodeExecLabel: NormalizeLabelValue(spec.Owner),
+			},
+		},
+		Spec: v1.PodSpec{
+			NodeName:      nodeName,
+			HostPID:       true,
+			HostNetwork:   true,
+			RestartPolicy: v1.RestartPolicyNever,
+			// command should run on all selected nodes including masters and tainted nodes
+			Tolerations: []v1.Toleration{{Operator: v1.TolerationOpExists}},
+			Containers: []v1.Container{
+				{
+					Name:    "exec",
+					Image:   image,
+					Command: command,
+					SecurityContext: &v1.SecurityContext{
+						Privileged: &privileged,
+					},
+				},
+			},
+		},
+	}
+}
@@ -0,0 +1,185 @@
+package kube
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/client-go/kubernetes/fake"
+	k8stesting "k8s.io/client-go/testing"
+)
+
+func TestNodeExecPod(t *testing.T) {
+	pod := nodeExecPod(NodeExecSpec{Owner: "nginx/hooks/sysctl", Command: []string{"sysctl", "-w", "vm.max_map_count=262144"}}, "node-1")
+
+	assert.Equal(t, "antiopa-node-exec-", pod.GenerateName)
+	assert.Equal(t, "nginx_hooks_sysctl", pod.Labels[NodeExecLabel])
+
+	// pod is pinned to the node without scheduler and is not restarted
+	assert.Equal(t, "node-1", pod.Spec.NodeName)
+	assert.Equal(t, v1.RestartPolicyNever, pod.Spec.RestartPolicy)
+	assert.Equal(t, []v1.Toleration{{Operator: v1.TolerationOpExists}}, pod.Spec.Tolerations)
+
+	// command enters namespaces of the host
+	assert.True(t, pod.Spec.HostPID)
+	assert.True(t, pod.Spec.HostNetwork)
+	if assert.Len(t, pod.Spec.Containers, 1) {
+		container := pod.Spec.Containers[0]
+		assert.Equal(t, DefaultNodeExecImage, container.Image)
+		assert.Equal(t, []string{"nsenter", "--target", "1", "--mount", "--uts", "--ipc", "--net", "--pid", "--", "sysctl", "-w", "vm.max_map_count=262144"}, container.Command)
+		if assert.NotNil(t, container.SecurityContext) && assert.NotNil(t, container.SecurityContext.Privileged) {
+			assert.True(t, *container.SecurityContext.Privileged)
+		}
+	}
+
+	pod = nodeExecPod(NodeExecSpec{Owner: "hook", Command: []string{"true"}, Image: "alpine"}, "node-2")
+	assert.Equal(t, "alpine", pod.Spec.Containers[0].Image)
+}
+
+func TestRunOnNodes(t *testing.T) {
+	defer func(c Client, namespace string, period time.Duration, logs func(string) ([]byte, error)) {
+		KubernetesClient = c
+		KubernetesAntiopaNamespace = namespace
+		NodeExecPollPeriod = period
+		nodeExecPodLogs = logs
+	}(KubernetesClient, KubernetesAntiopaNamespace, NodeExecPollPeriod, nodeExecPodLogs)
+	KubernetesAntiopaNamespace = "antiopa"
+	NodeExecPollPeriod = time.Millisecond
+
+	node := func(name string, role string) *v1.Node {
+		return &v1.Node{ObjectMeta: metav1.ObjectMeta{Name: name, Labels: map[string]string{"role": role}}}
+	}
+	clientset := fake.NewSimpleClientset(node("master-1", "master"), node("master-2", "master"), node("master-3", "master"), node("worker-1", "worker"))
+
+	// fake clientset does not generate names and has no kubelet: pods are completed at once,
+	// the command fails on master-2 and is never started on master-3
+	clientset.PrependReactor("create", "pods", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		pod := action.(k8stesting.CreateAction).GetObject().(*v1.Pod)
+		pod.Name = pod.GenerateName + pod.Spec.NodeName
+		switch pod.Spec.NodeName {
+		case "master-1":
+			pod.Status = completedPodStatus(v1.PodSucceeded, 0)
+		case "master-2":
+			pod.Status = completedPodStatus(v1.PodFailed, 2)
+		default:
+			pod.Status.Phase = v1.PodPending
+		}
+		return false, nil, nil
+	})
+	KubernetesClient = clientset
+	nodeExecPodLogs = func(podName string) ([]byte, error) {
+		return []byte(fmt.Sprintf("output of %s", podName)), nil
+	}
+
+	results, err := RunOnNodes(NodeExecSpec{
+		Owner:        "hook",
+		Command:      []string{"uname"},
+		NodeSelector: map[string]string{"role": "master"},
+		Timeout:      50 * time.Millisecond,
+	})
+	if !assert.NoError(t, err) || !assert.Len(t, results, 3) {
+		return
+	}
+
+	assert.Equal(t, NodeExecResult{Node: "master-1", ExitCode: 0, Output: "output of antiopa-node-exec-master-1"}, results[0])
+	assert.Equal(t, NodeExecResult{Node: "master-2", ExitCode: 2, Output: "output of antiopa-node-exec-master-2"}, results[1])
+	assert.Equal(t, "master-3", results[2].Node)
+	assert.Equal(t, -1, results[2].ExitCode)
+	assert.True(t, strings.HasPrefix(results[2].Error, "timeout 50ms exceeded"), results[2].Error)
+
+	// pods are deleted after results are collected
+	pods, err := clientset.CoreV1().Pods("antiopa").List(metav1.ListOptions{})
+	if assert.NoError(t, err) {
+		assert.Empty(t, pods.Items)
+	}
+}
+
+func TestRunOnNodes_Parallelism(t *testing.T) {
+	defer func(c Client, namespace string, parallelism int, logs func(string) ([]byte, error)) {
+		KubernetesClient = c
+		KubernetesAntiopaNamespace = namespace
+		NodeExecParallelism = parallelism
+		nodeExecPodLogs = logs
+	}(KubernetesClient, KubernetesAntiopaNamespace, NodeExecParallelism, nodeExecPodLogs)
+	KubernetesAntiopaNamespace = "antiopa"
+	NodeExecParallelism = 2
+
+	clientset := fake.NewSimpleClientset()
+	for i := 0; i < 5; i++ {
+		assert.NoError(t, clientset.Tracker().Add(&v1.Node{ObjectMeta: metav1.ObjectMeta{Name: fmt.Sprintf("node-%d", i)}}))
+	}
+
+	var m sync.Mutex
+	running, maxRunning := 0, 0
+	clientset.PrependReactor("create", "pods", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		pod := action.(k8stesting.CreateAction).GetObject().(*v1.Pod)
+		pod.Name = pod.GenerateName + pod.Spec.NodeName
+		pod.Status = completedPodStatus(v1.PodSucceeded, 0)
+		m.Lock()
+		running++
+		if running > maxRunning {
+			maxRunning = running
+		}
+		m.Unlock()
+		return false, nil, nil
+	})
+	clientset.PrependReactor("delete", "pods", func(action k8stesting.Action) (bool, runtime.Object, error) {
+		m.Lock()
+		running--
+		m.Unlock()
+		return false, nil, nil
+	})
+	KubernetesClient = clientset
+	nodeExecPodLogs = func(podName string) ([]byte, error) {
+		time.Sleep(10 * time.Millisecond)
+		return nil, nil
+	}
+
+	results, err := RunOnNodes(NodeExecSpec{Owner: "hook", Command: []string{"uname"}})
+	assert.NoError(t, err)
+	assert.Len(t, results, 5)
+	assert.Equal(t, 2, maxRunning)
+
+	// command is not run if selector matches no nodes
+	_, err = RunOnNodes(NodeExecSpec{Owner: "hook", Command: []string{"uname"}, NodeSelector: map[string]string{"role": "master"}})
+	assert.EqualError(t, err, "no nodes match selector 'role=master'")
+}
+
+func TestCleanupNodeExecPods(t *testing.T) {
+	defer func(c Client, namespace string) {
+		KubernetesClient = c
+		KubernetesAntiopaNamespace = namespace
+	}(KubernetesClient, KubernetesAntiopaNamespace)
+	KubernetesAntiopaNamespace = "antiopa"
+
+	pod := func(name string, labels map[string]string) *v1.Pod {
+		return &v1.Pod{ObjectMeta: metav1.ObjectMeta{Name: name, Namespace: "antiopa", Labels: labels}}
+	}
+	clientset := fake.NewSimpleClientset(
+		pod("antiopa-node-exec-abcde", map[string]string{NodeExecLabel: "hook"}),
+		pod("antiopa-5d4f8", map[string]string{"app": "antiopa"}),
+	)
+	KubernetesClient = clientset
+
+	CleanupNodeExecPods()
+
+	pods, err := clientset.CoreV1().Pods("antiopa").List(metav1.ListOptions{})
+	if assert.NoError(t, err) && assert.Len(t, pods.Items, 1) {
+		assert.Equal(t, "antiopa-5d4f8", pods.Items[0].Name)
+	}
+}
+
+func completedPodStatus(phase v1.PodPhase, exitCode int32) v1.PodStatus {
+	return v1.PodStatus{
+		Phase: phase,
+		ContainerStatuses: []v1.ContainerStatus{
+			{Name: "exec", State: v1.ContainerState{Terminated: &v1.ContainerStateTerminated{ExitCode: exitCode}}},
+		},
+	}
+}
@@ -0,0 +1,60 @@
+package kube
+
+import (
+	"fmt"
+	"sort"
+
+	"k8s.io/api/core/v1"
+)
+
+// Labels of nodes with OS and architecture, beta labels are set by kubelets before 1.14
+var (
+	nodeOSLabels   = []string{"kubernetes.io/os", "beta.kubernetes.io/os"}
+	nodeArchLabels = []string{"kubernetes.io/arch", "beta.kubernetes.io/arch"}
+)
+
+// NodePlatform is a pair of OS and architecture of nodes, e.g. linux/amd64
+type NodePlatform struct {
+	OS    string `json:"os"`
+	Arch  string `json:"arch"`
+	Nodes int    `json:"nodes"`
+}
+
+func (p NodePlatform) String() string {
+	return fmt.Sprintf("%s/%s", p.OS, p.Arch)
+}
+
+// NodePlatforms returns platforms of nodes sorted by OS and architecture
+func NodePlatforms(nodes []v1.Node) []NodePlatform {
+	counts := make(map[NodePlatform]int)
+	for _, node := range nodes {
+		platform := NodePlatform{
+			OS:   nodeInfoOrLabel(node, node.Status.NodeInfo.OperatingSystem, nodeOSLabels),
+			Arch: nodeInfoOrLabel(node, node.Status.NodeInfo.Architecture, nodeArchLabels),
+		}
+		counts[platform]++
+	}
+
+	res := make([]NodePlatform, 0, len(counts))
+	for platform, count := range counts {
+		platform.Nodes = count
+		res = append(res, platform)
+	}
+	sort.Slice(res, func(i, j int) bool {
+		return res[i].String() < res[j].String()
+	})
+	return res
+}
+
+// nodeInfoOrLabel returns value reported by kubelet or value of the first present label
+func nodeInfoOrLabel(node v1.Node, value string, labels []string) string {
+	if value != "" {
+		return value
+	}
+	for _, label := range labels {
+		if value, ok := node.Labels[label]; ok && value != "" {
+			return value
+		}
+	}
+	return "unknown"
+}
@@ -0,0 +1,31 @@
+package kube
+
+import (
+	"fmt"
+
+	authnv1 "k8s.io/api/authentication/v1"
+)
+
+// ReviewToken checks a bearer token with TokenReview. User name and groups of
+// the token owner are returned if the token is authenticated.
+func ReviewToken(token string) (user string, groups []string, err error) {
+	review := &authnv1.TokenReview{
+		Spec: authnv1.TokenReviewSpec{
+			Token: token,
+		},
+	}
+
+	res, err := Kubernetes.AuthenticationV1().TokenReviews().Create(review)
+	if err != nil {
+		return "", nil, fmt.Errorf("cannot create TokenReview: %s", err)
+	}
+
+	if !res.Status.Authenticated {
+		if res.Status.Error != "" {
+			return "", nil, fmt.Errorf("token is not authenticated: %s", res.Status.Error)
+		}
+		return "", nil, fmt.Errorf("token is not authenticated")
+	}
+
+	return res.Status.User.Username, res.Status.User.Groups, nil
+}
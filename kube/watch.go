@@ -0,0 +1,170 @@
+package kube
+
+import (
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/romana/rlog"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/util/wait"
+	"k8s.io/apimachinery/pkg/watch"
+	"k8s.io/client-go/tools/cache"
+)
+
+// Watches are expired after this period to force a full relist of resources by informer.
+// Informer then sends Add, Update and Delete events for changes missed by the watch,
+// e.g. while apiserver was restarted. 0 disables periodic relist.
+var WatchRelistPeriod = 30 * time.Minute
+
+// relistingListWatch enables watch bookmarks and expires watches after WatchRelistPeriod
+type relistingListWatch struct {
+	cache.ListerWatcher
+}
+
+// NewRelistingListWatch wraps ListerWatcher of informer. Bookmarks keep resourceVersion
+// of the watch fresh, so the watch is resumed after reconnect instead of failing
+// with 'too old resource version'. Expired watches make informer relist all resources.
+func NewRelistingListWatch(lw cache.ListerWatcher) cache.ListerWatcher {
+	return &relistingListWatch{ListerWatcher: lw}
+}
+
+func (lw *relistingListWatch) List(options metav1.ListOptions) (runtime.Object, error) {
+	return lw.ListerWatcher.List(options)
+}
+
+func (lw *relistingListWatch) Watch(options metav1.ListOptions) (watch.Interface, error) {
+	options.AllowWatchBookmarks = true
+	w, err := lw.ListerWatcher.Watch(options)
+	if err != nil {
+		return w, err
+	}
+	w = trackWatch(w)
+	if WatchRelistPeriod <= 0 {
+		return w, nil
+	}
+	// jitter to not relist all resources at once
+	return newExpiringWatch(w, wait.Jitter(WatchRelistPeriod, 0.1)), nil
+}
+
+// expiringWatch passes events of the watch and sends the 'Expired' error after period
+type expiringWatch struct {
+	watch.Interface
+	result   chan watch.Event
+	stopCh   chan struct{}
+	stopOnce sync.Once
+}
+
+func newExpiringWatch(w watch.Interface, period time.Duration) *expiringWatch {
+	ew := &expiringWatch{
+		Interface: w,
+		result:    make(chan watch.Event),
+		stopCh:    make(chan struct{}),
+	}
+	go ew.run(period)
+	return ew
+}
+
+func (w *expiringWatch) ResultChan() <-chan watch.Event {
+	return w.result
+}
+
+func (w *expiringWatch) Stop() {
+	w.stopOnce.Do(func() {
+		close(w.stopCh)
+		w.Interface.Stop()
+	})
+}
+
+func (w *expiringWatch) run(period time.Duration) {
+	defer close(w.result)
+
+	timer := time.NewTimer(period)
+	defer timer.Stop()
+
+	for {
+		select {
+		case <-w.stopCh:
+			return
+		case <-timer.C:
+			rlog.Debugf("KUBE watch is open for %s, expire it to relist resources", period.String())
+			w.Interface.Stop()
+			select {
+			case w.result <- watch.Event{Type: watch.Error, Object: expiredStatus()}:
+			case <-w.stopCh:
+			}
+			return
+		case event, ok := <-w.Interface.ResultChan():
+			if !ok {
+				return
+			}
+			select {
+			case w.result <- event:
+			case <-w.stopCh:
+				return
+			}
+		}
+	}
+}
+
+// expiredStatus is the same error as apiserver sends for expired resourceVersion
+func expiredStatus() *metav1.Status {
+	return &metav1.Status{
+		Status:  metav1.StatusFailure,
+		Code:    http.StatusGone,
+		Reason:  metav1.StatusReasonExpired,
+		Message: "watch is expired for periodic relist",
+	}
+}
+
+var (
+	openWatchesMutex sync.Mutex
+	openWatches      = make(map[*trackedWatch]bool)
+)
+
+// trackedWatch is an open watch of informer that can be closed by DisconnectWatches
+type trackedWatch struct {
+	watch.Interface
+}
+
+func trackWatch(w watch.Interface) watch.Interface {
+	tw := &trackedWatch{Interface: w}
+	openWatchesMutex.Lock()
+	openWatches[tw] = true
+	openWatchesMutex.Unlock()
+	return tw
+}
+
+// Stop is called by informer when the watch is finished
+func (w *trackedWatch) Stop() {
+	openWatchesMutex.Lock()
+	delete(openWatches, w)
+	openWatchesMutex.Unlock()
+	w.Interface.Stop()
+}
+
+// DisconnectWatches closes open watches of informers as if connection to apiserver is lost.
+// Informers open new watches from the last resourceVersion. Number of closed watches is returned.
+func DisconnectWatches() int {
+	openWatchesMutex.Lock()
+	watches := make([]*trackedWatch, 0, len(openWatches))
+	for w := range openWatches {
+		watches = append(watches, w)
+	}
+	openWatchesMutex.Unlock()
+
+	for _, w := range watches {
+		w.Stop()
+	}
+	return len(watches)
+}
+
+// DeletedObject returns the last known state of deleted object.
+// Informer passes DeletedFinalStateUnknown to delete handlers if deletion was missed by the watch and found by relist.
+func DeletedObject(obj interface{}) interface{} {
+	if tombstone, ok := obj.(cache.DeletedFinalStateUnknown); ok {
+		return tombstone.Obj
+	}
+	return obj
+}
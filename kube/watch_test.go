@@ -0,0 +1,40 @@
+package kube
+
+import (
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	v1 "k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/watch"
+	"k8s.io/client-go/tools/cache"
+)
+
+func TestExpiringWatch(t *testing.T) {
+	fakeWatch := watch.NewFake()
+	w := newExpiringWatch(fakeWatch, 100*time.Millisecond)
+
+	go fakeWatch.Add(&v1.Pod{})
+	event := <-w.ResultChan()
+	assert.Equal(t, watch.Added, event.Type)
+
+	event = <-w.ResultChan()
+	if !assert.Equal(t, watch.Error, event.Type) {
+		return
+	}
+	status := event.Object.(*metav1.Status)
+	assert.Equal(t, int32(http.StatusGone), status.Code)
+	assert.Equal(t, metav1.StatusReasonExpired, status.Reason)
+
+	_, ok := <-w.ResultChan()
+	assert.False(t, ok)
+	w.Stop()
+}
+
+func TestDeletedObject(t *testing.T) {
+	pod := &v1.Pod{}
+	assert.Equal(t, pod, DeletedObject(pod))
+	assert.Equal(t, pod, DeletedObject(cache.DeletedFinalStateUnknown{Key: "default/test", Obj: pod}))
+}
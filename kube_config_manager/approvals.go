@@ -0,0 +1,43 @@
+package kube_config_manager
+
+import (
+	"strings"
+
+	"github.com/romana/rlog"
+	"k8s.io/api/core/v1"
+
+	"github.com/flant/antiopa/utils"
+)
+
+// Annotation on antiopa ConfigMap with comma separated keys of approved destructive
+// operations, e.g. "delete-release:prometheus". Keys added to annotation are approved once.
+const ApproveAnnotation = "antiopa/approve"
+
+// OperationsApproved chan receives keys added to the approve annotation
+var OperationsApproved chan []string
+
+func approvedOperations(cm *v1.ConfigMap) []string {
+	if cm == nil || cm.Annotations[ApproveAnnotation] == "" {
+		return []string{}
+	}
+	keys := make([]string, 0)
+	for _, key := range strings.Split(cm.Annotations[ApproveAnnotation], ",") {
+		if key = strings.TrimSpace(key); key != "" {
+			keys = append(keys, key)
+		}
+	}
+	return keys
+}
+
+// handleApprovals sends keys added to the approve annotation over OperationsApproved channel
+func (kcm *MainKubeConfigManager) handleApprovals(cm *v1.ConfigMap) {
+	keys := approvedOperations(cm)
+	added := utils.ListSubtract(keys, kcm.ApprovedOperations)
+	kcm.ApprovedOperations = keys
+	if len(added) == 0 {
+		return
+	}
+
+	rlog.Infof("KUBE_CONFIG operations are approved with '%s' annotation: %v", ApproveAnnotation, added)
+	OperationsApproved <- added
+}
@@ -0,0 +1,51 @@
+package kube_config_manager
+
+import (
+	"github.com/romana/rlog"
+	"k8s.io/api/core/v1"
+)
+
+// Annotation on antiopa ConfigMap with the error of the rejected config. Values of the rejected
+// config are not applied, modules are run with the last valid values until the config is fixed.
+// Annotation is removed when a valid config is applied.
+const ConfigErrorAnnotation = "antiopa/config-error"
+
+// max length of the error in annotation, errors may contain the whole bad section
+const configErrorMaxLength = 1024
+
+// reportConfigError sets the error of the config into the annotation of ConfigMap or removes
+// the annotation if err is nil. ConfigMap is updated only if the annotation is changed.
+func (kcm *MainKubeConfigManager) reportConfigError(cm *v1.ConfigMap, err error) {
+	message := ""
+	if err != nil {
+		message = err.Error()
+		if len(message) > configErrorMaxLength {
+			message = message[:configErrorMaxLength] + "..."
+		}
+	}
+	kcm.ConfigError = message
+	if cm.Annotations[ConfigErrorAnnotation] == message {
+		return
+	}
+
+	updateErr := kcm.changeOrCreateKubeConfig(func(obj *v1.ConfigMap) error {
+		if message == "" {
+			delete(obj.Annotations, ConfigErrorAnnotation)
+			return nil
+		}
+		if obj.Annotations == nil {
+			obj.Annotations = make(map[string]string)
+		}
+		obj.Annotations[ConfigErrorAnnotation] = message
+		return nil
+	})
+	if updateErr != nil {
+		rlog.Errorf("KUBE_CONFIG cannot update '%s' annotation: %s", ConfigErrorAnnotation, updateErr)
+		return
+	}
+	if message != "" {
+		rlog.Errorf("KUBE_CONFIG ConfigMap '%s' is rejected, last valid values are used: %s", cm.Name, message)
+	} else {
+		rlog.Infof("KUBE_CONFIG ConfigMap '%s' is valid again", cm.Name)
+	}
+}
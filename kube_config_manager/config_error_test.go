@@ -0,0 +1,39 @@
+package kube_config_manager
+
+import (
+	"fmt"
+	"testing"
+
+	"k8s.io/api/core/v1"
+
+	"github.com/flant/antiopa/kube"
+)
+
+func TestReportConfigError(t *testing.T) {
+	cm := v1.ConfigMap{}
+	cm.Name = ConfigMapName
+	cm.Data = map[string]string{"nginx": "bad: [yaml"}
+	mockConfigMapList = &v1.ConfigMapList{Items: []v1.ConfigMap{cm}}
+	kube.KubernetesClient = &MockKubernetesClientset{}
+	kcm := &MainKubeConfigManager{}
+
+	kcm.reportConfigError(&cm, fmt.Errorf("module 'nginx' has bad values"))
+	if got := mockConfigMapList.Items[0].Annotations[ConfigErrorAnnotation]; got != "module 'nginx' has bad values" {
+		t.Errorf("expected error in annotation, got '%s'", got)
+	}
+	if kcm.ConfigError == "" {
+		t.Errorf("expected ConfigError to be set")
+	}
+
+	reported := mockConfigMapList.Items[0]
+	kcm.reportConfigError(&reported, nil)
+	if _, has := mockConfigMapList.Items[0].Annotations[ConfigErrorAnnotation]; has {
+		t.Errorf("expected annotation to be removed for a valid config")
+	}
+	if kcm.ConfigError != "" {
+		t.Errorf("expected ConfigError to be reset, got '%s'", kcm.ConfigError)
+	}
+	if mockConfigMapList.Items[0].Data["nginx"] != "bad: [yaml" {
+		t.Errorf("expected data to be kept")
+	}
+}
@@ -0,0 +1,50 @@
+package kube_config_manager
+
+import (
+	"github.com/romana/rlog"
+	"k8s.io/api/core/v1"
+)
+
+// Annotation on antiopa ConfigMap to pause module runs, e.g. during maintenance.
+// Module runs are resumed when annotation is removed or is not "true".
+const ConvergeDisabledAnnotation = "antiopa/converge-disabled"
+
+// ConvergeDisabledChanged chan receives a new state of converge lock
+var ConvergeDisabledChanged chan bool
+
+func isConvergeDisabled(cm *v1.ConfigMap) bool {
+	if cm == nil {
+		return false
+	}
+	return cm.Annotations[ConvergeDisabledAnnotation] == "true"
+}
+
+// handleConvergeLock sends a new state of converge lock over ConvergeDisabledChanged
+// channel if annotation is changed. cm is nil if ConfigMap is deleted.
+func (kcm *MainKubeConfigManager) handleConvergeLock(cm *v1.ConfigMap) {
+	convergeDisabled := isConvergeDisabled(cm)
+	if convergeDisabled == kcm.ConvergeDisabled {
+		return
+	}
+	kcm.ConvergeDisabled = convergeDisabled
+
+	if convergeDisabled {
+		rlog.Warnf("KUBE_CONFIG Converge is disabled with '%s' annotation", ConvergeDisabledAnnotation)
+	} else {
+		rlog.Infof("KUBE_CONFIG Converge is enabled")
+	}
+
+	// informer is not blocked, the last state replaces the state that is not received yet
+	select {
+	case ConvergeDisabledChanged <- convergeDisabled:
+	default:
+		select {
+		case <-ConvergeDisabledChanged:
+		default:
+		}
+		select {
+		case ConvergeDisabledChanged <- convergeDisabled:
+		default:
+		}
+	}
+}
@@ -0,0 +1,73 @@
+package kube_config_manager
+
+import (
+	"bytes"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/romana/rlog"
+
+	"github.com/flant/antiopa/utils"
+)
+
+// GroupValuesKeyPrefix is a prefix of ConfigMap keys with values shared by modules of a group,
+// e.g. 'group.monitoring' for modules with 'group: monitoring' in module.yaml. Values of the key
+// are merged into sections of these modules under their own sections. It is not a module section.
+const GroupValuesKeyPrefix = "group."
+
+// GroupsValuesUpdated chan receives values of all groups when any group key is changed
+var GroupsValuesUpdated chan map[string]utils.Values
+
+func IsGroupValuesKey(key string) bool {
+	return strings.HasPrefix(key, GroupValuesKeyPrefix)
+}
+
+// GroupValuesKey returns a ConfigMap key of the group
+func GroupValuesKey(groupName string) string {
+	return GroupValuesKeyPrefix + groupName
+}
+
+// GetGroupsValuesFromConfigData parses group keys, values are returned by group name with
+// a checksum of all group keys. Empty values and checksum are returned if there are no group keys.
+func GetGroupsValuesFromConfigData(configData map[string]string) (map[string]utils.Values, string, error) {
+	keys := make([]string, 0)
+	for key := range configData {
+		if IsGroupValuesKey(key) {
+			keys = append(keys, key)
+		}
+	}
+	sort.Strings(keys)
+
+	res := make(map[string]utils.Values)
+	if len(keys) == 0 {
+		return res, "", nil
+	}
+	var checksumData bytes.Buffer
+	for _, key := range keys {
+		groupName := strings.TrimPrefix(key, GroupValuesKeyPrefix)
+		if groupName == "" {
+			return nil, "", fmt.Errorf("'%s' ConfigMap key '%s' has no group name", ConfigMapName, key)
+		}
+		values, err := utils.NewValuesFromBytes([]byte(configData[key]))
+		if err != nil {
+			return nil, "", fmt.Errorf("'%s' ConfigMap bad yaml at key '%s': %s", ConfigMapName, key, err)
+		}
+		if values == nil {
+			values = make(utils.Values)
+		}
+		res[groupName] = values
+		checksumData.WriteString(key + "\n" + configData[key] + "\n")
+	}
+	return res, utils.CalculateChecksum(checksumData.String()), nil
+}
+
+// handleGroupsValues sends values of groups over GroupsValuesUpdated channel if they are changed
+func (kcm *MainKubeConfigManager) handleGroupsValues(values map[string]utils.Values, checksum string) {
+	if checksum == kcm.GroupsValuesChecksum {
+		return
+	}
+	kcm.GroupsValuesChecksum = checksum
+	rlog.Infof("KUBE_CONFIG Detect group sections changes: %d groups", len(values))
+	GroupsValuesUpdated <- values
+}
@@ -0,0 +1,41 @@
+package kube_config_manager
+
+import (
+	"testing"
+)
+
+func TestGetGroupsValuesFromConfigData(t *testing.T) {
+	values, checksum, err := GetGroupsValuesFromConfigData(map[string]string{"global": "a: 1\n"})
+	if err != nil || len(values) != 0 || checksum != "" {
+		t.Errorf("expected no groups without group keys, got %v '%s' %v", values, checksum, err)
+	}
+
+	configData := map[string]string{
+		GroupValuesKey("monitoring"): "retention: 7d\n",
+		GroupValuesKey("logging"):    "",
+		"prometheus":                 "{}",
+	}
+	values, checksum, err = GetGroupsValuesFromConfigData(configData)
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if len(values) != 2 || values["monitoring"]["retention"] != "7d" || values["logging"] == nil || checksum == "" {
+		t.Errorf("unexpected values %v, checksum '%s'", values, checksum)
+	}
+
+	configData[GroupValuesKey("logging")] = "level: debug\n"
+	if _, newChecksum, _ := GetGroupsValuesFromConfigData(configData); newChecksum == checksum {
+		t.Errorf("checksum should be changed with values of any group")
+	}
+
+	if _, _, err := GetGroupsValuesFromConfigData(map[string]string{GroupValuesKey("monitoring"): "- 7d\n"}); err == nil {
+		t.Errorf("expected error for list")
+	}
+	if _, _, err := GetGroupsValuesFromConfigData(map[string]string{GroupValuesKey(""): "a: 1\n"}); err == nil {
+		t.Errorf("expected error for empty group name")
+	}
+
+	if names := GetModulesNamesFromConfigData(configData); len(names) != 1 || !names["prometheus"] {
+		t.Errorf("group keys should not be module sections, got %v", names)
+	}
+}
@@ -0,0 +1,45 @@
+package kube_config_manager
+
+import (
+	"fmt"
+
+	"github.com/romana/rlog"
+	"gopkg.in/yaml.v2"
+
+	"github.com/flant/antiopa/utils"
+)
+
+// ImageTagsKey is a key of the antiopa ConfigMap with tags of images maintained externally,
+// e.g. by CI: yaml map of image name to tag. It is not a module section.
+const ImageTagsKey = "imageTags"
+
+// ImageTagsUpdated chan receives all image tags when imageTags key is changed
+var ImageTagsUpdated chan map[string]string
+
+// GetImageTagsFromConfigData parses imageTags key, empty tags and checksum are returned if key is absent
+func GetImageTagsFromConfigData(configData map[string]string) (map[string]string, string, error) {
+	tags := make(map[string]string)
+	data, hasKey := configData[ImageTagsKey]
+	if !hasKey {
+		return tags, "", nil
+	}
+	if err := yaml.Unmarshal([]byte(data), &tags); err != nil {
+		return nil, "", fmt.Errorf("bad %s: expected map of image name to tag: %s", ImageTagsKey, err)
+	}
+	for image, tag := range tags {
+		if tag == "" {
+			return nil, "", fmt.Errorf("bad %s: empty tag of image '%s'", ImageTagsKey, image)
+		}
+	}
+	return tags, utils.CalculateChecksum(data), nil
+}
+
+// handleImageTags sends image tags over ImageTagsUpdated channel if they are changed
+func (kcm *MainKubeConfigManager) handleImageTags(tags map[string]string, checksum string) {
+	if checksum == kcm.ImageTagsChecksum {
+		return
+	}
+	kcm.ImageTagsChecksum = checksum
+	rlog.Infof("KUBE_CONFIG Detect %s changes: %d tags", ImageTagsKey, len(tags))
+	ImageTagsUpdated <- tags
+}
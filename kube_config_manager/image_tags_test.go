@@ -0,0 +1,31 @@
+package kube_config_manager
+
+import (
+	"testing"
+)
+
+func TestGetImageTagsFromConfigData(t *testing.T) {
+	tags, checksum, err := GetImageTagsFromConfigData(map[string]string{"global": "a: 1\n"})
+	if err != nil || len(tags) != 0 || checksum != "" {
+		t.Errorf("expected no tags without %s key, got %v '%s' %v", ImageTagsKey, tags, checksum, err)
+	}
+
+	tags, checksum, err = GetImageTagsFromConfigData(map[string]string{ImageTagsKey: "ingress/controller: v0.25.1\ndashboard: v2.0.0\n"})
+	if err != nil {
+		t.Fatalf("unexpected error: %s", err)
+	}
+	if tags["ingress/controller"] != "v0.25.1" || tags["dashboard"] != "v2.0.0" || checksum == "" {
+		t.Errorf("unexpected tags %v, checksum '%s'", tags, checksum)
+	}
+
+	if _, _, err := GetImageTagsFromConfigData(map[string]string{ImageTagsKey: "- v1\n"}); err == nil {
+		t.Errorf("expected error for list")
+	}
+	if _, _, err := GetImageTagsFromConfigData(map[string]string{ImageTagsKey: "dashboard: \"\"\n"}); err == nil {
+		t.Errorf("expected error for empty tag")
+	}
+
+	if names := GetModulesNamesFromConfigData(map[string]string{ImageTagsKey: "a: b\n", "dashboard": "{}"}); len(names) != 1 || !names["dashboard"] {
+		t.Errorf("%s should not be a module section, got %v", ImageTagsKey, names)
+	}
+}
@@ -13,6 +13,8 @@ import (
 	"github.com/flant/antiopa/utils"
 	"gopkg.in/yaml.v2"
 	"k8s.io/apimachinery/pkg/fields"
+	"k8s.io/apimachinery/pkg/runtime"
+	"k8s.io/apimachinery/pkg/watch"
 	"k8s.io/client-go/tools/cache"
 	"os"
 )
@@ -34,6 +36,15 @@ type MainKubeConfigManager struct {
 
 	GlobalValuesChecksum  string
 	ModulesValuesChecksum map[string]string
+	ImageTagsChecksum     string
+	GroupsValuesChecksum  string
+
+	// module runs are paused with annotation on ConfigMap
+	ConvergeDisabled bool
+	// keys of destructive operations approved with annotation on ConfigMap
+	ApprovedOperations []string
+	// error of the rejected ConfigMap, empty if the last ConfigMap is applied
+	ConfigError string
 }
 
 type ModuleConfigs map[string]utils.ModuleConfig
@@ -41,12 +52,18 @@ type ModuleConfigs map[string]utils.ModuleConfig
 type Config struct {
 	Values        utils.Values
 	ModuleConfigs ModuleConfigs
+	// tags from imageTags key by image name
+	ImageTags map[string]string
+	// values from group keys by group name
+	GroupsValues map[string]utils.Values
 }
 
 func NewConfig() *Config {
 	return &Config{
 		Values:        make(utils.Values),
 		ModuleConfigs: make(map[string]utils.ModuleConfig),
+		ImageTags:     make(map[string]string),
+		GroupsValues:  make(map[string]utils.Values),
 	}
 }
 
@@ -58,6 +75,37 @@ var (
 	ModuleConfigsUpdated chan ModuleConfigs
 )
 
+// NewConfigFromConfigData parses data of the antiopa ConfigMap: 'global' key and module sections
+func NewConfigFromConfigData(configData map[string]string) (*Config, error) {
+	config := NewConfig()
+
+	globalKubeConfig, err := GetGlobalKubeConfigFromConfigData(configData)
+	if err != nil {
+		return nil, err
+	}
+	if globalKubeConfig != nil {
+		config.Values = globalKubeConfig.Values
+	}
+
+	for module := range GetModulesNamesFromConfigData(configData) {
+		moduleKubeConfig, err := ModuleKubeConfigMustExist(GetModuleKubeConfigFromConfigData(module, configData))
+		if err != nil {
+			return nil, err
+		}
+		config.ModuleConfigs[moduleKubeConfig.ModuleName] = moduleKubeConfig.ModuleConfig
+	}
+
+	if config.ImageTags, _, err = GetImageTagsFromConfigData(configData); err != nil {
+		return nil, err
+	}
+
+	if config.GroupsValues, _, err = GetGroupsValuesFromConfigData(configData); err != nil {
+		return nil, err
+	}
+
+	return config, nil
+}
+
 func simpleMergeConfigMapData(data map[string]string, newData map[string]string) map[string]string {
 	for k, v := range newData {
 		data[k] = v
@@ -221,6 +269,9 @@ func (kcm *MainKubeConfigManager) initConfig() error {
 		return nil
 	}
 
+	kcm.handleConvergeLock(obj)
+	kcm.handleApprovals(obj)
+
 	initialConfig := NewConfig()
 	globalValuesChecksum := ""
 	modulesValuesChecksum := make(map[string]string)
@@ -245,6 +296,14 @@ func (kcm *MainKubeConfigManager) initConfig() error {
 		modulesValuesChecksum[moduleKubeConfig.ModuleName] = moduleKubeConfig.Checksum
 	}
 
+	if initialConfig.ImageTags, kcm.ImageTagsChecksum, err = GetImageTagsFromConfigData(obj.Data); err != nil {
+		return err
+	}
+
+	if initialConfig.GroupsValues, kcm.GroupsValuesChecksum, err = GetGroupsValuesFromConfigData(obj.Data); err != nil {
+		return err
+	}
+
 	kcm.initialConfig = initialConfig
 	kcm.GlobalValuesChecksum = globalValuesChecksum
 	kcm.ModulesValuesChecksum = modulesValuesChecksum
@@ -262,6 +321,10 @@ func Init() (KubeConfigManager, error) {
 
 	ConfigUpdated = make(chan Config, 1)
 	ModuleConfigsUpdated = make(chan ModuleConfigs, 1)
+	ConvergeDisabledChanged = make(chan bool, 1)
+	OperationsApproved = make(chan []string, 1)
+	ImageTagsUpdated = make(chan map[string]string, 1)
+	GroupsValuesUpdated = make(chan map[string]utils.Values, 1)
 
 	kcm := NewMainKubeConfigManager()
 
@@ -318,6 +381,16 @@ func (kcm *MainKubeConfigManager) handleNewCm(obj *v1.ConfigMap) error {
 		return err
 	}
 
+	imageTags, imageTagsChecksum, err := GetImageTagsFromConfigData(obj.Data)
+	if err != nil {
+		return err
+	}
+
+	groupsValues, groupsValuesChecksum, err := GetGroupsValuesFromConfigData(obj.Data)
+	if err != nil {
+		return err
+	}
+
 	// if global values are changed or deleted then new config should be sent over ConfigUpdated channel
 	isGlobalUpdated := globalKubeConfig != nil &&
 		globalKubeConfig.Checksum != savedChecksums[utils.GlobalValuesKey] &&
@@ -334,7 +407,6 @@ func (kcm *MainKubeConfigManager) handleNewCm(obj *v1.ConfigMap) error {
 			newConfig.Values = globalKubeConfig.Values
 			newGlobalValuesChecksum = globalKubeConfig.Checksum
 		}
-		kcm.GlobalValuesChecksum = newGlobalValuesChecksum
 
 		// calculate new checksums of a module sections
 		newModulesValuesChecksum := make(map[string]string)
@@ -348,6 +420,8 @@ func (kcm *MainKubeConfigManager) handleNewCm(obj *v1.ConfigMap) error {
 			newConfig.ModuleConfigs[moduleKubeConfig.ModuleName] = moduleKubeConfig.ModuleConfig
 			newModulesValuesChecksum[moduleKubeConfig.ModuleName] = moduleKubeConfig.Checksum
 		}
+		// checksums are saved only for a valid config, so the fixed config is detected as changed
+		kcm.GlobalValuesChecksum = newGlobalValuesChecksum
 		kcm.ModulesValuesChecksum = newModulesValuesChecksum
 
 		rlog.Debugf("Kube config manager: global section new values:\n%s",
@@ -361,6 +435,7 @@ func (kcm *MainKubeConfigManager) handleNewCm(obj *v1.ConfigMap) error {
 		actualModulesNames := GetModulesNamesFromConfigData(obj.Data)
 
 		moduleConfigsActual := make(ModuleConfigs)
+		updatedChecksums := make(map[string]string)
 		updatedCount := 0
 		removedCount := 0
 
@@ -374,7 +449,7 @@ func (kcm *MainKubeConfigManager) handleNewCm(obj *v1.ConfigMap) error {
 			}
 
 			if moduleKubeConfig.Checksum != savedChecksums[module] && moduleKubeConfig.Checksum != kcm.ModulesValuesChecksum[module] {
-				kcm.ModulesValuesChecksum[module] = moduleKubeConfig.Checksum
+				updatedChecksums[module] = moduleKubeConfig.Checksum
 				moduleKubeConfig.ModuleConfig.IsUpdated = true
 				updatedCount++
 			} else {
@@ -382,6 +457,9 @@ func (kcm *MainKubeConfigManager) handleNewCm(obj *v1.ConfigMap) error {
 			}
 			moduleConfigsActual[module] = moduleKubeConfig.ModuleConfig
 		}
+		for module, checksum := range updatedChecksums {
+			kcm.ModulesValuesChecksum[module] = checksum
+		}
 
 		// delete checksums for removed module sections
 		for module := range kcm.ModulesValuesChecksum {
@@ -401,6 +479,11 @@ func (kcm *MainKubeConfigManager) handleNewCm(obj *v1.ConfigMap) error {
 		}
 	}
 
+	// image tags do not change global or module sections, affected modules are rerun by module manager
+	kcm.handleImageTags(imageTags, imageTagsChecksum)
+	// group sections are merged into sections of modules of the group by module manager
+	kcm.handleGroupsValues(groupsValues, groupsValuesChecksum)
+
 	return nil
 }
 
@@ -413,7 +496,12 @@ func (kcm *MainKubeConfigManager) handleCmAdd(obj *v1.ConfigMap) error {
 		rlog.Debugf("Kube config manager: informer: handle ConfigMap '%s' add:\n%s", obj.Name, objYaml)
 	}
 
-	return kcm.handleNewCm(obj)
+	kcm.handleConvergeLock(obj)
+	kcm.handleApprovals(obj)
+
+	err := kcm.handleNewCm(obj)
+	kcm.reportConfigError(obj, err)
+	return err
 }
 
 func (kcm *MainKubeConfigManager) handleCmUpdate(_ *v1.ConfigMap, obj *v1.ConfigMap) error {
@@ -425,7 +513,12 @@ func (kcm *MainKubeConfigManager) handleCmUpdate(_ *v1.ConfigMap, obj *v1.Config
 		rlog.Debugf("Kube config manager: informer: handle ConfigMap '%s' update:\n%s", obj.Name, objYaml)
 	}
 
-	return kcm.handleNewCm(obj)
+	kcm.handleConvergeLock(obj)
+	kcm.handleApprovals(obj)
+
+	err := kcm.handleNewCm(obj)
+	kcm.reportConfigError(obj, err)
+	return err
 }
 
 func (kcm *MainKubeConfigManager) handleCmDelete(obj *v1.ConfigMap) error {
@@ -437,6 +530,9 @@ func (kcm *MainKubeConfigManager) handleCmDelete(obj *v1.ConfigMap) error {
 		rlog.Debugf("Kube config manager: handle ConfigMap '%s' delete:\n%s", obj.Name, objYaml)
 	}
 
+	kcm.handleConvergeLock(nil)
+	kcm.handleApprovals(nil)
+
 	if kcm.GlobalValuesChecksum != "" {
 		kcm.GlobalValuesChecksum = ""
 		kcm.ModulesValuesChecksum = make(map[string]string)
@@ -474,13 +570,20 @@ func (kcm *MainKubeConfigManager) handleCmDelete(obj *v1.ConfigMap) error {
 func (kcm *MainKubeConfigManager) Run() {
 	rlog.Debugf("Run kube config manager")
 
-	lw := cache.NewListWatchFromClient(
-		kube.KubernetesClient.CoreV1().RESTClient(),
-		"configmaps",
-		kube.KubernetesAntiopaNamespace,
-		fields.OneTermEqualSelector("metadata.name", ConfigMapName))
+	// ListWatch with typed client to work with fake clientset in dev mode
+	fieldSelector := fields.OneTermEqualSelector("metadata.name", ConfigMapName).String()
+	lw := &cache.ListWatch{
+		ListFunc: func(options metav1.ListOptions) (runtime.Object, error) {
+			options.FieldSelector = fieldSelector
+			return kube.KubernetesClient.CoreV1().ConfigMaps(kube.KubernetesAntiopaNamespace).List(options)
+		},
+		WatchFunc: func(options metav1.ListOptions) (watch.Interface, error) {
+			options.FieldSelector = fieldSelector
+			return kube.KubernetesClient.CoreV1().ConfigMaps(kube.KubernetesAntiopaNamespace).Watch(options)
+		},
+	}
 
-	cmInformer := cache.NewSharedInformer(lw,
+	cmInformer := cache.NewSharedInformer(kube.NewRelistingListWatch(lw),
 		&v1.ConfigMap{},
 		time.Duration(15)*time.Second)
 
@@ -498,7 +601,7 @@ func (kcm *MainKubeConfigManager) Run() {
 			}
 		},
 		DeleteFunc: func(obj interface{}) {
-			err := kcm.handleCmDelete(obj.(*v1.ConfigMap))
+			err := kcm.handleCmDelete(kube.DeletedObject(obj).(*v1.ConfigMap))
 			if err != nil {
 				rlog.Errorf("Kube config manager: cannot handle ConfigMap delete: %s", err)
 			}
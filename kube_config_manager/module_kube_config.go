@@ -12,7 +12,7 @@ func GetModulesNamesFromConfigData(configData map[string]string) map[string]bool
 	res := make(map[string]bool, 0)
 
 	for key := range configData {
-		if key != utils.GlobalValuesKey {
+		if key != utils.GlobalValuesKey && key != ImageTagsKey && !IsGroupValuesKey(key) {
 			if utils.ModuleNameToValuesKey(utils.ModuleNameFromValuesKey(key)) != key {
 				rlog.Warnf("Bad module name '%s': should be camelCased module name: ignoring data", key)
 				continue
@@ -0,0 +1,116 @@
+package main
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/romana/rlog"
+
+	"github.com/flant/antiopa/module_manager"
+	"github.com/flant/antiopa/task"
+)
+
+// KubeEventsAggregator collects binding contexts of onKubernetesEvent bindings with aggregationPeriod,
+// so a hook is run once for a batch of events instead of a run per event, e.g. on Endpoints churn.
+// Only the last event of each object is kept in the batch.
+type KubeEventsAggregator struct {
+	m       sync.Mutex
+	batches map[string]*kubeEventsBatch
+	// adds the task with collected binding contexts into its queue
+	add func(t task.Task)
+}
+
+type kubeEventsBatch struct {
+	task     *task.BaseTask
+	contexts []module_manager.BindingContext
+	// index of the object in contexts by namespace/kind/name
+	objects map[string]int
+	events  int
+}
+
+func NewKubeEventsAggregator(add func(t task.Task)) *KubeEventsAggregator {
+	return &KubeEventsAggregator{
+		batches: make(map[string]*kubeEventsBatch),
+		add:     add,
+	}
+}
+
+// Add puts binding contexts of the task into the batch of the binding. The first task of the batch
+// is queued after the period with binding contexts of all events collected during the period.
+func (a *KubeEventsAggregator) Add(configId string, t *task.BaseTask, period time.Duration) {
+	a.m.Lock()
+	defer a.m.Unlock()
+
+	batch, hasBatch := a.batches[configId]
+	if !hasBatch {
+		batch = &kubeEventsBatch{task: t, objects: make(map[string]int)}
+		a.batches[configId] = batch
+		time.AfterFunc(period, func() {
+			a.flush(configId, batch)
+		})
+	}
+	for _, context := range t.GetBindingContext() {
+		batch.merge(context)
+	}
+}
+
+// merge keeps the last event of the object. Object added and updated during the period is
+// still added for the hook, object added and deleted during the period is not passed at all.
+func (b *kubeEventsBatch) merge(context module_manager.BindingContext) {
+	b.events++
+	key := fmt.Sprintf("%s/%s/%s", context.ResourceNamespace, context.ResourceKind, context.ResourceName)
+
+	i, hasObject := b.objects[key]
+	if !hasObject {
+		b.objects[key] = len(b.contexts)
+		b.contexts = append(b.contexts, context)
+		return
+	}
+
+	previousEvent := b.contexts[i].ResourceEvent
+	switch {
+	case previousEvent == "ADDED" && context.ResourceEvent == "DELETED":
+		b.contexts = append(b.contexts[:i], b.contexts[i+1:]...)
+		delete(b.objects, key)
+		for k, j := range b.objects {
+			if j > i {
+				b.objects[k] = j - 1
+			}
+		}
+	case previousEvent == "ADDED":
+		context.ResourceEvent = previousEvent
+		b.contexts[i] = context
+	default:
+		b.contexts[i] = context
+	}
+}
+
+// flush queues the task of the batch, nothing is queued if objects were added and deleted
+// during the period or the batch is forgotten
+func (a *KubeEventsAggregator) flush(configId string, batch *kubeEventsBatch) {
+	a.m.Lock()
+	if a.batches[configId] != batch {
+		a.m.Unlock()
+		return
+	}
+	delete(a.batches, configId)
+	a.m.Unlock()
+
+	MetricsStorage.SendCounterMetric("antiopa_kube_events_aggregated", float64(batch.events), map[string]string{"hook": batch.task.GetName()})
+	if len(batch.contexts) == 0 {
+		rlog.Infof("QUEUE skip %s@%s %s: %d events are aggregated into no changes", batch.task.GetType(), batch.task.GetBinding(), batch.task.GetName(), batch.events)
+		return
+	}
+
+	a.add(batch.task.WithBindingContext(batch.contexts))
+	rlog.Infof("QUEUE add %s@%s %s: %d events of %d objects are aggregated", batch.task.GetType(), batch.task.GetBinding(), batch.task.GetName(), batch.events, len(batch.contexts))
+}
+
+// Forget drops collected events of the binding, e.g. when hooks of the module are disabled
+func (a *KubeEventsAggregator) Forget(configId string) {
+	a.m.Lock()
+	defer a.m.Unlock()
+
+	delete(a.batches, configId)
+}
@@ -0,0 +1,58 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/flant/antiopa/module_manager"
+	"github.com/flant/antiopa/task"
+)
+
+func TestKubeEventsAggregator(t *testing.T) {
+	queued := make([]task.Task, 0)
+	aggregator := NewKubeEventsAggregator(func(t task.Task) {
+		queued = append(queued, t)
+	})
+
+	event := func(eventType string, name string) *task.BaseTask {
+		return task.NewTask(task.ModuleHookRun, "endpoints-hook").
+			WithBinding(module_manager.KubeEvents).
+			AppendBindingContext(module_manager.BindingContext{
+				Binding:           "endpoints",
+				ResourceEvent:     eventType,
+				ResourceNamespace: "default",
+				ResourceKind:      "Endpoints",
+				ResourceName:      name,
+			})
+	}
+
+	aggregator.Add("config-1", event("MODIFIED", "web"), time.Hour)
+	aggregator.Add("config-1", event("ADDED", "api"), time.Hour)
+	aggregator.Add("config-1", event("MODIFIED", "web"), time.Hour)
+	aggregator.Add("config-1", event("MODIFIED", "api"), time.Hour)
+	aggregator.Add("config-1", event("ADDED", "tmp"), time.Hour)
+	aggregator.Add("config-1", event("DELETED", "tmp"), time.Hour)
+	assert.Len(t, queued, 0)
+
+	aggregator.flush("config-1", aggregator.batches["config-1"])
+	if !assert.Len(t, queued, 1) {
+		return
+	}
+	contexts := queued[0].GetBindingContext()
+	if assert.Len(t, contexts, 2) {
+		assert.Equal(t, "web", contexts[0].ResourceName)
+		assert.Equal(t, "MODIFIED", contexts[0].ResourceEvent)
+		// added and then updated object is still added for the hook
+		assert.Equal(t, "api", contexts[1].ResourceName)
+		assert.Equal(t, "ADDED", contexts[1].ResourceEvent)
+	}
+
+	// forgotten batch is not queued by the timer
+	aggregator.Add("config-1", event("MODIFIED", "web"), time.Hour)
+	batch := aggregator.batches["config-1"]
+	aggregator.Forget("config-1")
+	aggregator.flush("config-1", batch)
+	assert.Len(t, queued, 1)
+}
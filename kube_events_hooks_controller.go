@@ -16,6 +16,7 @@ type KubeEventHook struct {
 	Name     string
 
 	EventTypes   []module_manager.OnKubernetesEventType
+	ApiVersion   string
 	Kind         string
 	Namespace    string
 	Selector     *metav1.LabelSelector
@@ -47,12 +48,14 @@ func ConvertOnKubernetesEventToKubeEventHook(hook *module_manager.Hook, config m
 		HookName:     hook.Name,
 		Name:         config.Name,
 		EventTypes:   config.EventTypes,
+		ApiVersion:   config.ApiVersion,
 		Kind:         config.Kind,
 		Namespace:    namespace,
 		Selector:     config.Selector,
 		JqFilter:     config.JqFilter,
 		AllowFailure: config.AllowFailure,
 		Debug:        !config.DisableDebug,
+		Config:       config,
 	}
 }
 
@@ -67,6 +70,8 @@ type MainKubeEventsHooksController struct {
 	GlobalHooks    map[string]*KubeEventHook
 	ModuleHooks    map[string]*KubeEventHook
 	EnabledModules []string
+	// batches of events for bindings with aggregationPeriod
+	Aggregator *KubeEventsAggregator
 }
 
 func NewMainKubeEventsHooksController() *MainKubeEventsHooksController {
@@ -74,6 +79,7 @@ func NewMainKubeEventsHooksController() *MainKubeEventsHooksController {
 	obj.GlobalHooks = make(map[string]*KubeEventHook)
 	obj.ModuleHooks = make(map[string]*KubeEventHook)
 	obj.EnabledModules = make([]string, 0)
+	obj.Aggregator = NewKubeEventsAggregator(AddHookTask)
 	return obj
 }
 
@@ -84,7 +90,7 @@ func (obj *MainKubeEventsHooksController) EnableGlobalHooks(moduleManager module
 		globalHook, _ := ModuleManager.GetGlobalHook(globalHookName)
 
 		for _, desc := range MakeKubeEventHookDescriptors(globalHook.Hook, &globalHook.Config.HookConfig) {
-			configId, err := eventsManager.Run(desc.EventTypes, desc.Kind, desc.Namespace, desc.Selector, desc.JqFilter, desc.Debug)
+			configId, err := eventsManager.Run(desc.EventTypes, desc.ApiVersion, desc.Kind, desc.Namespace, desc.Selector, desc.JqFilter, desc.Debug)
 			if err != nil {
 				return err
 			}
@@ -110,11 +116,20 @@ func (obj *MainKubeEventsHooksController) EnableModuleHooks(moduleName string, m
 		return err
 	}
 
+	descsByHook := make(map[string][]*KubeEventHook)
+	allDescs := make([]*KubeEventHook, 0)
+	for _, moduleHookName := range moduleHooks {
+		moduleHook, _ := ModuleManager.GetModuleHook(moduleHookName)
+		descsByHook[moduleHookName] = MakeKubeEventHookDescriptors(moduleHook.Hook, &moduleHook.Config.HookConfig)
+		allDescs = append(allDescs, descsByHook[moduleHookName]...)
+	}
+	CheckModuleHooksPermissions(moduleName, allDescs)
+
 	for _, moduleHookName := range moduleHooks {
 		moduleHook, _ := ModuleManager.GetModuleHook(moduleHookName)
 
-		for _, desc := range MakeKubeEventHookDescriptors(moduleHook.Hook, &moduleHook.Config.HookConfig) {
-			configId, err := eventsManager.Run(desc.EventTypes, desc.Kind, desc.Namespace, desc.Selector, desc.JqFilter, desc.Debug)
+		for _, desc := range descsByHook[moduleHookName] {
+			configId, err := eventsManager.Run(desc.EventTypes, desc.ApiVersion, desc.Kind, desc.Namespace, desc.Selector, desc.JqFilter, desc.Debug)
 			if err != nil {
 				return err
 			}
@@ -156,6 +171,7 @@ func (obj *MainKubeEventsHooksController) DisableModuleHooks(moduleName string,
 				}
 
 				delete(obj.ModuleHooks, configId)
+				obj.Aggregator.Forget(configId)
 
 				break
 			}
@@ -192,13 +208,21 @@ func (obj *MainKubeEventsHooksController) HandleEvent(kubeEvent kube_events_mana
 				ResourceNamespace: kubeEvent.Namespace,
 				ResourceKind:      kubeEvent.Kind,
 				ResourceName:      kubeEvent.Name,
+				Object:            kubeEvent.Object,
 			})
 		}
 
 		newTask := task.NewTask(taskType, desc.HookName).
 			WithBinding(module_manager.KubeEvents).
 			WithBindingContext(bindingContext).
-			WithAllowFailure(desc.Config.AllowFailure)
+			WithAllowFailure(desc.Config.AllowFailure).
+			WithQueueName(desc.Config.Queue)
+
+		if period := desc.Config.AggregationPeriodDuration(); period > 0 {
+			// task is queued by the aggregator after the period
+			obj.Aggregator.Add(kubeEvent.ConfigId, newTask, period)
+			return res, nil
+		}
 
 		res.Tasks = append(res.Tasks, newTask)
 	} else {
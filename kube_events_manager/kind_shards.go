@@ -0,0 +1,205 @@
+package kube_events_manager
+
+import (
+	"strings"
+	"sync"
+
+	"github.com/romana/rlog"
+	"k8s.io/client-go/tools/cache"
+
+	"github.com/flant/antiopa/utils"
+)
+
+// Size of buffers of informer handlers and of ready events of each kind. Informer handlers
+// of the kind wait when the buffer is full, handlers of other kinds are not affected.
+var KindShardBufferSize = 1000
+
+// kindShards process events of bindings in a worker per kind: checksums with jq filters
+// are calculated in the worker of the kind and ready events are sent to KubeEventCh
+// round-robin across kinds, so a storm of Pod events cannot starve Node or custom
+// resources bindings. Kinds with the same name in different groups share a shard.
+var kindShards = newKindShardsRegistry()
+
+type kindShard struct {
+	kind string
+	// informer handlers of bindings of the kind
+	work chan func()
+	// events ready to be sent to KubeEventCh
+	events chan KubeEvent
+	stopCh chan struct{}
+	// notify of the registry
+	notify chan struct{}
+	refs   int
+}
+
+type kindShardsRegistry struct {
+	m      sync.Mutex
+	shards map[string]*kindShard
+	// kinds in order of round-robin
+	order []string
+	next  int
+	// dispatcher is notified when a shard has a ready event
+	notify chan struct{}
+	// events are sent into this channel, KubeEventCh if nil
+	out               chan KubeEvent
+	dispatcherStarted bool
+}
+
+func newKindShardsRegistry() *kindShardsRegistry {
+	return &kindShardsRegistry{
+		shards: make(map[string]*kindShard),
+		notify: make(chan struct{}, 1),
+	}
+}
+
+// acquire returns the shard of the kind, a new shard starts its worker and the dispatcher if needed
+func (r *kindShardsRegistry) acquire(kind string) *kindShard {
+	r.m.Lock()
+	defer r.m.Unlock()
+
+	kind = strings.ToLower(kind)
+	shard, ok := r.shards[kind]
+	if !ok {
+		rlog.Debugf("Kube events manager: start worker for kind '%s'", kind)
+		shard = &kindShard{
+			kind:   kind,
+			work:   make(chan func(), KindShardBufferSize),
+			events: make(chan KubeEvent, KindShardBufferSize),
+			stopCh: make(chan struct{}),
+			notify: r.notify,
+		}
+		r.shards[kind] = shard
+		r.order = append(r.order, kind)
+		go shard.run()
+	}
+	if !r.dispatcherStarted {
+		r.dispatcherStarted = true
+		go r.dispatch()
+	}
+	shard.refs++
+	return shard
+}
+
+// release stops the worker of the kind when there are no more bindings for it,
+// pending events of the kind are dropped
+func (r *kindShardsRegistry) release(kind string) {
+	r.m.Lock()
+	defer r.m.Unlock()
+
+	kind = strings.ToLower(kind)
+	shard, ok := r.shards[kind]
+	if !ok {
+		return
+	}
+	shard.refs--
+	if shard.refs > 0 {
+		return
+	}
+
+	rlog.Debugf("Kube events manager: stop worker for kind '%s'", kind)
+	close(shard.stopCh)
+	delete(r.shards, kind)
+	for i, k := range r.order {
+		if k == kind {
+			r.order = append(r.order[:i], r.order[i+1:]...)
+			break
+		}
+	}
+}
+
+// count returns number of shards
+func (r *kindShardsRegistry) count() int {
+	r.m.Lock()
+	defer r.m.Unlock()
+	return len(r.shards)
+}
+
+// handler returns informer handlers that queue calls of handlers into the worker of the kind
+func (s *kindShard) handler(handlers cache.ResourceEventHandlerFuncs) cache.ResourceEventHandlerFuncs {
+	return cache.ResourceEventHandlerFuncs{
+		AddFunc: func(obj interface{}) {
+			s.enqueue(func() { handlers.OnAdd(obj) })
+		},
+		UpdateFunc: func(oldObj interface{}, obj interface{}) {
+			s.enqueue(func() { handlers.OnUpdate(oldObj, obj) })
+		},
+		DeleteFunc: func(obj interface{}) {
+			s.enqueue(func() { handlers.OnDelete(obj) })
+		},
+	}
+}
+
+// enqueue waits for a free place in the buffer of the kind
+func (s *kindShard) enqueue(f func()) {
+	select {
+	case s.work <- f:
+		return
+	case <-s.stopCh:
+		return
+	default:
+	}
+
+	utils.SampledWarnf("kind-shard-"+s.kind, "Kube events manager: buffer of %d events of kind '%s' is full, informer waits for the worker", KindShardBufferSize, s.kind)
+	select {
+	case s.work <- f:
+	case <-s.stopCh:
+	}
+}
+
+// run calls informer handlers of the kind one by one, so checksums of each binding are not shared between goroutines
+func (s *kindShard) run() {
+	for {
+		select {
+		case f := <-s.work:
+			f()
+		case <-s.stopCh:
+			return
+		}
+	}
+}
+
+// send puts the ready event of the kind for the dispatcher
+func (s *kindShard) send(event KubeEvent) {
+	select {
+	case s.events <- event:
+	case <-s.stopCh:
+		return
+	}
+	select {
+	case s.notify <- struct{}{}:
+	default:
+	}
+}
+
+// dispatch sends ready events to KubeEventCh taking one event of each kind in turn
+func (r *kindShardsRegistry) dispatch() {
+	for {
+		event, ok := r.nextEvent()
+		if !ok {
+			<-r.notify
+			continue
+		}
+		out := r.out
+		if out == nil {
+			out = KubeEventCh
+		}
+		out <- event
+	}
+}
+
+// nextEvent returns a ready event of the next kind after the kind of the previous event
+func (r *kindShardsRegistry) nextEvent() (KubeEvent, bool) {
+	r.m.Lock()
+	defer r.m.Unlock()
+
+	for i := 0; i < len(r.order); i++ {
+		idx := (r.next + i) % len(r.order)
+		select {
+		case event := <-r.shards[r.order[idx]].events:
+			r.next = idx + 1
+			return event, true
+		default:
+		}
+	}
+	return KubeEvent{}, false
+}
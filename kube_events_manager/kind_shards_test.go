@@ -0,0 +1,69 @@
+package kube_events_manager
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+	"k8s.io/client-go/tools/cache"
+)
+
+func TestKindShardsRegistry_RoundRobin(t *testing.T) {
+	r := newKindShardsRegistry()
+	// events are taken with nextEvent
+	r.dispatcherStarted = true
+
+	pods := r.acquire("Pod")
+	nodes := r.acquire("Node")
+	assert.Equal(t, pods, r.acquire("pod"))
+	assert.Equal(t, 2, r.count())
+
+	for _, name := range []string{"a", "b", "c"} {
+		pods.send(KubeEvent{Kind: "Pod", Name: name})
+	}
+	nodes.send(KubeEvent{Kind: "Node", Name: "node-1"})
+
+	// node event is not behind the storm of pod events
+	names := make([]string, 0)
+	for {
+		event, ok := r.nextEvent()
+		if !ok {
+			break
+		}
+		names = append(names, event.Name)
+	}
+	assert.Equal(t, []string{"a", "node-1", "b", "c"}, names)
+
+	// the worker of the kind is stopped with the last binding
+	r.release("Pod")
+	assert.Equal(t, 2, r.count())
+	r.release("Pod")
+	assert.Equal(t, 1, r.count())
+	select {
+	case <-pods.stopCh:
+	default:
+		t.Errorf("stop channel of released shard should be closed")
+	}
+}
+
+func TestKindShard_Handler(t *testing.T) {
+	r := newKindShardsRegistry()
+	r.out = make(chan KubeEvent, 1)
+	shard := r.acquire("ConfigMap")
+	defer r.release("ConfigMap")
+
+	handler := shard.handler(cache.ResourceEventHandlerFuncs{
+		AddFunc: func(obj interface{}) {
+			shard.send(KubeEvent{Kind: "ConfigMap", Name: obj.(string), Events: []string{"ADDED"}})
+		},
+	})
+	handler.OnAdd("test")
+
+	select {
+	case event := <-r.out:
+		assert.Equal(t, "test", event.Name)
+		assert.Equal(t, []string{"ADDED"}, event.Events)
+	case <-time.After(5 * time.Second):
+		t.Errorf("event is not dispatched")
+	}
+}
@@ -6,20 +6,24 @@ import (
 	"fmt"
 	"os/exec"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"time"
 
 	"github.com/romana/rlog"
 	"gopkg.in/satori/go.uuid.v1"
 
+	appsV1 "k8s.io/api/apps/v1"
+	batchV1 "k8s.io/api/batch/v1"
+	batchV2Alpha1 "k8s.io/api/batch/v2alpha1"
+	coreV1 "k8s.io/api/core/v1"
+	extensionsV1Beta1 "k8s.io/api/extensions/v1beta1"
+	storageV1 "k8s.io/api/storage/v1"
 	"k8s.io/apimachinery/pkg/api/meta"
 	metaV1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/apis/meta/v1/unstructured"
 	"k8s.io/apimachinery/pkg/runtime"
-	appsV1 "k8s.io/client-go/informers/apps/v1"
-	batchV1 "k8s.io/client-go/informers/batch/v1"
-	batchV2Alpha1 "k8s.io/client-go/informers/batch/v2alpha1"
-	coreV1 "k8s.io/client-go/informers/core/v1"
-	extensionsV1Beta1 "k8s.io/client-go/informers/extensions/v1beta1"
-	storageV1 "k8s.io/client-go/informers/storage/v1"
+	"k8s.io/apimachinery/pkg/watch"
 	"k8s.io/client-go/tools/cache"
 
 	"github.com/flant/antiopa/executor"
@@ -39,10 +43,12 @@ type KubeEvent struct {
 	Namespace string
 	Kind      string
 	Name      string
+	// the object that triggered the event, the last known state for deleted objects
+	Object map[string]interface{}
 }
 
 type KubeEventsManager interface {
-	Run(eventTypes []module_manager.OnKubernetesEventType, kind, namespace string, labelSelector *metaV1.LabelSelector, jqFilter string, debug bool) (string, error)
+	Run(eventTypes []module_manager.OnKubernetesEventType, apiVersion, kind, namespace string, labelSelector *metaV1.LabelSelector, jqFilter string, debug bool) (string, error)
 	Stop(configId string) error
 }
 
@@ -62,8 +68,10 @@ func Init() (KubeEventsManager, error) {
 	return em, nil
 }
 
-func (em *MainKubeEventsManager) Run(eventTypes []module_manager.OnKubernetesEventType, kind, namespace string, labelSelector *metaV1.LabelSelector, jqFilter string, debug bool) (string, error) {
-	kubeEventsInformer, err := em.addKubeEventsInformer(kind, namespace, labelSelector, eventTypes, jqFilter, debug, func(kubeEventsInformer *KubeEventsInformer) cache.ResourceEventHandlerFuncs {
+// Run starts informer for the binding. apiVersion is required for kinds without built-in informer,
+// e.g. custom resources. If the kind is not registered yet, informer is started when it appears.
+func (em *MainKubeEventsManager) Run(eventTypes []module_manager.OnKubernetesEventType, apiVersion, kind, namespace string, labelSelector *metaV1.LabelSelector, jqFilter string, debug bool) (string, error) {
+	kubeEventsInformer, err := em.addKubeEventsInformer(apiVersion, kind, namespace, labelSelector, eventTypes, jqFilter, debug, func(kubeEventsInformer *KubeEventsInformer) cache.ResourceEventHandlerFuncs {
 		return cache.ResourceEventHandlerFuncs{
 			AddFunc: func(obj interface{}) {
 				objectId, err := runtimeResourceId(obj)
@@ -72,43 +80,34 @@ func (em *MainKubeEventsManager) Run(eventTypes []module_manager.OnKubernetesEve
 					return
 				}
 
-				filtered, err := resourceFilter(obj, jqFilter, debug)
+				checksum, err := kubeEventsInformer.objectChecksum(obj, "add", debug)
 				if err != nil {
 					rlog.Error("Kube events manager: %+v informer %s: %s object %s: %s", eventTypes, kubeEventsInformer.ConfigId, kind, objectId, err)
 					return
 				}
 
-				checksum := utils.CalculateChecksum(filtered)
-
-				if debug {
-					rlog.Debugf("Kube events manager: %+v informer %s: add %s object %s: jqFilter '%s': calculated checksum '%s' of object being watched:\n%s",
-						eventTypes, kubeEventsInformer.ConfigId, kind, objectId, jqFilter, checksum, utils.FormatJsonDataOrError(utils.FormatPrettyJson(filtered)))
-				}
-
 				err = kubeEventsInformer.HandleKubeEvent(obj, kind, checksum, "ADDED", kubeEventsInformer.ShouldHandleEvent(module_manager.KubernetesEventOnAdd), debug)
 				if err != nil {
 					rlog.Error("Kube events manager: %+v informer %s: %s object %s: %s", eventTypes, kubeEventsInformer.ConfigId, kind, objectId, err)
 					return
 				}
 			},
-			UpdateFunc: func(_ interface{}, obj interface{}) {
+			UpdateFunc: func(oldObj interface{}, obj interface{}) {
 				objectId, err := runtimeResourceId(obj)
 				if err != nil {
 					rlog.Errorf("failed to get object id: %s", err)
 					return
 				}
 
-				filtered, err := resourceFilter(obj, jqFilter, debug)
-				if err != nil {
-					rlog.Error("Kube events manager: %+v informer %s: %s object %s: %s", eventTypes, kubeEventsInformer.ConfigId, kind, objectId, err)
+				// periodic resync of informer sends the same object
+				if sameResourceVersion(oldObj, obj) {
 					return
 				}
 
-				checksum := utils.CalculateChecksum(filtered)
-
-				if debug {
-					rlog.Debugf("Kube events manager: %+v informer %s: update %s object %s: jqFilter '%s': calculated checksum '%s' of object being watched:\n%s",
-						eventTypes, kubeEventsInformer.ConfigId, kind, objectId, jqFilter, checksum, utils.FormatJsonDataOrError(utils.FormatPrettyJson(filtered)))
+				checksum, err := kubeEventsInformer.objectChecksum(obj, "update", debug)
+				if err != nil {
+					rlog.Error("Kube events manager: %+v informer %s: %s object %s: %s", eventTypes, kubeEventsInformer.ConfigId, kind, objectId, err)
+					return
 				}
 
 				err = kubeEventsInformer.HandleKubeEvent(obj, kind, checksum, "MODIFIED", kubeEventsInformer.ShouldHandleEvent(module_manager.KubernetesEventOnUpdate), debug)
@@ -118,6 +117,7 @@ func (em *MainKubeEventsManager) Run(eventTypes []module_manager.OnKubernetesEve
 				}
 			},
 			DeleteFunc: func(obj interface{}) {
+				obj = kube.DeletedObject(obj)
 				objectId, err := runtimeResourceId(obj)
 				if err != nil {
 					rlog.Errorf("failed to get object id: %s", err)
@@ -141,14 +141,15 @@ func (em *MainKubeEventsManager) Run(eventTypes []module_manager.OnKubernetesEve
 		return "", err
 	}
 
-	go kubeEventsInformer.Run()
+	kubeEventsInformer.Run()
 
 	return kubeEventsInformer.ConfigId, nil
 }
 
-func (em *MainKubeEventsManager) addKubeEventsInformer(kind, namespace string, labelSelector *metaV1.LabelSelector, eventTypes []module_manager.OnKubernetesEventType, jqFilter string, debug bool, resourceEventHandlerFuncs func(kubeEventsInformer *KubeEventsInformer) cache.ResourceEventHandlerFuncs) (*KubeEventsInformer, error) {
+func (em *MainKubeEventsManager) addKubeEventsInformer(apiVersion, kind, namespace string, labelSelector *metaV1.LabelSelector, eventTypes []module_manager.OnKubernetesEventType, jqFilter string, debug bool, resourceEventHandlerFuncs func(kubeEventsInformer *KubeEventsInformer) cache.ResourceEventHandlerFuncs) (*KubeEventsInformer, error) {
 	kubeEventsInformer := NewKubeEventsInformer()
 	kubeEventsInformer.ConfigId = uuid.NewV4().String()
+	kubeEventsInformer.ApiVersion = apiVersion
 	kubeEventsInformer.Kind = kind
 	kubeEventsInformer.EventTypes = eventTypes
 	kubeEventsInformer.JqFilter = jqFilter
@@ -158,116 +159,314 @@ func (em *MainKubeEventsManager) addKubeEventsInformer(kind, namespace string, l
 		return nil, fmt.Errorf("failed format label selector '%s'", labelSelector.String())
 	}
 
-	indexers := cache.Indexers{cache.NamespaceIndex: cache.MetaNamespaceIndexFunc}
-	resyncPeriod := time.Duration(2) * time.Hour
-	tweakListOptions := func(options *metaV1.ListOptions) {
-		if formatSelector != "" {
-			options.LabelSelector = formatSelector
+	err = kubeEventsInformer.attach(namespace, formatSelector, true, debug, resourceEventHandlerFuncs)
+	if kube.IsKindNotRegistered(err) {
+		// CRD can be created later, e.g. by a module, so binding waits for it
+		rlog.Warnf("Kube events manager: %+v informer %s: %s, wait for it to be registered", eventTypes, kubeEventsInformer.ConfigId, err)
+		go kubeEventsInformer.waitForKind(namespace, formatSelector, debug, resourceEventHandlerFuncs)
+	} else if err != nil {
+		return nil, err
+	}
+
+	em.KubeEventsInformersByConfigId[kubeEventsInformer.ConfigId] = kubeEventsInformer
+
+	return kubeEventsInformer, nil
+}
+
+// How often the kind of pending binding is looked up in apiserver
+var KindRegistrationRetryInterval = 30 * time.Second
+
+// waitForKind retries attach until the kind is registered or the binding is stopped.
+// Objects that exist when the kind appears are new for the binding, so Added events are sent for them.
+func (ei *KubeEventsInformer) waitForKind(namespace string, formatSelector string, debug bool, resourceEventHandlerFuncs func(kubeEventsInformer *KubeEventsInformer) cache.ResourceEventHandlerFuncs) {
+	for {
+		select {
+		case <-ei.stopCh:
+			return
+		case <-time.After(KindRegistrationRetryInterval):
+		}
+
+		err := ei.attach(namespace, formatSelector, false, debug, resourceEventHandlerFuncs)
+		if kube.IsKindNotRegistered(err) {
+			continue
+		}
+		if err != nil {
+			utils.SampledErrorf(ei.ConfigId, "Kube events manager: %+v informer %s: %s", ei.EventTypes, ei.ConfigId, err)
+			continue
 		}
+
+		rlog.Infof("Kube events manager: %+v informer %s: kind '%s' of apiVersion '%s' is registered, start informer", ei.EventTypes, ei.ConfigId, ei.Kind, ei.ApiVersion)
+		ei.Run()
+		return
 	}
+}
 
-	listOptions := metaV1.ListOptions{}
-	if formatSelector != "" {
-		listOptions.LabelSelector = formatSelector
+// attach subscribes binding to the shared informer for its kind, namespace and selector.
+// Existing objects are saved to IGNORE watch.Added events about them if initializeItems is true.
+func (ei *KubeEventsInformer) attach(namespace string, formatSelector string, initializeItems bool, debug bool, resourceEventHandlerFuncs func(kubeEventsInformer *KubeEventsInformer) cache.ResourceEventHandlerFuncs) error {
+	informerKey, newInformer, listResources, err := informerFactory(ei.ApiVersion, ei.Kind, namespace, formatSelector)
+	if err != nil {
+		return err
 	}
 
-	var sharedInformer cache.SharedIndexInformer
-	var resourceList runtime.Object
-	var listErr error
+	shared := sharedInformers.acquire(informerKey, newInformer)
+
+	if initializeItems {
+		// Resources are taken from the cache of running informer, so apiserver is not requested.
+		if shared.informer.HasSynced() {
+			err = ei.InitializeItems(shared.informer.GetStore().List(), debug)
+		} else {
+			var resourceList runtime.Object
+			resourceList, err = listResources()
+			if err != nil {
+				sharedInformers.release(informerKey)
+				return fmt.Errorf("failed to list '%s' resources: %v", ei.Kind, err)
+			}
+			err = ei.InitializeItemsList(resourceList, debug)
+		}
+		if err != nil {
+			sharedInformers.release(informerKey)
+			return err
+		}
+	}
+
+	ei.m.Lock()
+	defer ei.m.Unlock()
+	if atomic.LoadInt32(&ei.stopped) == 1 {
+		sharedInformers.release(informerKey)
+		return nil
+	}
+	ei.informerKey = informerKey
+	ei.SharedInformer = shared.informer
+	ei.shard = kindShards.acquire(ei.Kind)
+	ei.SharedInformer.AddEventHandler(ei.shard.handler(resourceEventHandlerFuncs(ei)))
+	return nil
+}
+
+// informerFactory returns key of the shared informer, its constructor and a function to list resources
+func informerFactory(apiVersion, kind, namespace string, formatSelector string) (informerKey string, newInformer func() cache.SharedIndexInformer, listResources func() (runtime.Object, error), err error) {
+	var objType runtime.Object
+	var listFunc func(options metaV1.ListOptions) (runtime.Object, error)
+	var watchFunc func(options metaV1.ListOptions) (watch.Interface, error)
+
+	// informers with the same kind, namespace and selector share one watch
+	informerKey = fmt.Sprintf("%s/%s/%s", strings.ToLower(kind), namespace, formatSelector)
 
 	switch strings.ToLower(kind) {
 	case "namespace":
-		sharedInformer = coreV1.NewFilteredNamespaceInformer(kube.Kubernetes, resyncPeriod, indexers, tweakListOptions)
-		resourceList, listErr = kube.Kubernetes.CoreV1().Namespaces().List(listOptions)
+		objType = &coreV1.Namespace{}
+		listFunc = func(options metaV1.ListOptions) (runtime.Object, error) {
+			return kube.Kubernetes.CoreV1().Namespaces().List(options)
+		}
+		watchFunc = func(options metaV1.ListOptions) (watch.Interface, error) {
+			return kube.Kubernetes.CoreV1().Namespaces().Watch(options)
+		}
 
 	case "cronjob":
-		sharedInformer = batchV2Alpha1.NewFilteredCronJobInformer(kube.Kubernetes, namespace, resyncPeriod, indexers, tweakListOptions)
-		resourceList, listErr = kube.Kubernetes.BatchV2alpha1().CronJobs(namespace).List(listOptions)
+		objType = &batchV2Alpha1.CronJob{}
+		listFunc = func(options metaV1.ListOptions) (runtime.Object, error) {
+			return kube.Kubernetes.BatchV2alpha1().CronJobs(namespace).List(options)
+		}
+		watchFunc = func(options metaV1.ListOptions) (watch.Interface, error) {
+			return kube.Kubernetes.BatchV2alpha1().CronJobs(namespace).Watch(options)
+		}
 
 	case "daemonset":
-		sharedInformer = appsV1.NewFilteredDaemonSetInformer(kube.Kubernetes, namespace, resyncPeriod, indexers, tweakListOptions)
-		resourceList, listErr = kube.Kubernetes.AppsV1().DaemonSets(namespace).List(listOptions)
+		objType = &appsV1.DaemonSet{}
+		listFunc = func(options metaV1.ListOptions) (runtime.Object, error) {
+			return kube.Kubernetes.AppsV1().DaemonSets(namespace).List(options)
+		}
+		watchFunc = func(options metaV1.ListOptions) (watch.Interface, error) {
+			return kube.Kubernetes.AppsV1().DaemonSets(namespace).Watch(options)
+		}
 
 	case "deployment":
-		sharedInformer = appsV1.NewFilteredDeploymentInformer(kube.Kubernetes, namespace, resyncPeriod, indexers, tweakListOptions)
-		resourceList, listErr = kube.Kubernetes.AppsV1().Deployments(namespace).List(listOptions)
+		objType = &appsV1.Deployment{}
+		listFunc = func(options metaV1.ListOptions) (runtime.Object, error) {
+			return kube.Kubernetes.AppsV1().Deployments(namespace).List(options)
+		}
+		watchFunc = func(options metaV1.ListOptions) (watch.Interface, error) {
+			return kube.Kubernetes.AppsV1().Deployments(namespace).Watch(options)
+		}
 
 	case "job":
-		sharedInformer = batchV1.NewFilteredJobInformer(kube.Kubernetes, namespace, resyncPeriod, indexers, tweakListOptions)
-		resourceList, listErr = kube.Kubernetes.BatchV1().Jobs(namespace).List(listOptions)
+		objType = &batchV1.Job{}
+		listFunc = func(options metaV1.ListOptions) (runtime.Object, error) {
+			return kube.Kubernetes.BatchV1().Jobs(namespace).List(options)
+		}
+		watchFunc = func(options metaV1.ListOptions) (watch.Interface, error) {
+			return kube.Kubernetes.BatchV1().Jobs(namespace).Watch(options)
+		}
 
 	case "pod":
-		sharedInformer = coreV1.NewFilteredPodInformer(kube.Kubernetes, namespace, resyncPeriod, indexers, tweakListOptions)
-		resourceList, listErr = kube.Kubernetes.CoreV1().Pods(namespace).List(listOptions)
+		objType = &coreV1.Pod{}
+		listFunc = func(options metaV1.ListOptions) (runtime.Object, error) {
+			return kube.Kubernetes.CoreV1().Pods(namespace).List(options)
+		}
+		watchFunc = func(options metaV1.ListOptions) (watch.Interface, error) {
+			return kube.Kubernetes.CoreV1().Pods(namespace).Watch(options)
+		}
 
 	case "replicaset":
-		sharedInformer = appsV1.NewFilteredReplicaSetInformer(kube.Kubernetes, namespace, resyncPeriod, indexers, tweakListOptions)
-		resourceList, listErr = kube.Kubernetes.AppsV1().ReplicaSets(namespace).List(listOptions)
+		objType = &appsV1.ReplicaSet{}
+		listFunc = func(options metaV1.ListOptions) (runtime.Object, error) {
+			return kube.Kubernetes.AppsV1().ReplicaSets(namespace).List(options)
+		}
+		watchFunc = func(options metaV1.ListOptions) (watch.Interface, error) {
+			return kube.Kubernetes.AppsV1().ReplicaSets(namespace).Watch(options)
+		}
 
 	case "replicationcontroller":
-		sharedInformer = coreV1.NewFilteredReplicationControllerInformer(kube.Kubernetes, namespace, resyncPeriod, indexers, tweakListOptions)
-		resourceList, listErr = kube.Kubernetes.CoreV1().ReplicationControllers(namespace).List(listOptions)
+		objType = &coreV1.ReplicationController{}
+		listFunc = func(options metaV1.ListOptions) (runtime.Object, error) {
+			return kube.Kubernetes.CoreV1().ReplicationControllers(namespace).List(options)
+		}
+		watchFunc = func(options metaV1.ListOptions) (watch.Interface, error) {
+			return kube.Kubernetes.CoreV1().ReplicationControllers(namespace).Watch(options)
+		}
 
 	case "statefulset":
-		sharedInformer = appsV1.NewFilteredStatefulSetInformer(kube.Kubernetes, namespace, resyncPeriod, indexers, tweakListOptions)
-		resourceList, listErr = kube.Kubernetes.AppsV1().StatefulSets(namespace).List(listOptions)
+		objType = &appsV1.StatefulSet{}
+		listFunc = func(options metaV1.ListOptions) (runtime.Object, error) {
+			return kube.Kubernetes.AppsV1().StatefulSets(namespace).List(options)
+		}
+		watchFunc = func(options metaV1.ListOptions) (watch.Interface, error) {
+			return kube.Kubernetes.AppsV1().StatefulSets(namespace).Watch(options)
+		}
 
 	case "endpoints":
-		sharedInformer = coreV1.NewFilteredEndpointsInformer(kube.Kubernetes, namespace, resyncPeriod, indexers, tweakListOptions)
-		resourceList, listErr = kube.Kubernetes.CoreV1().Endpoints(namespace).List(listOptions)
+		objType = &coreV1.Endpoints{}
+		listFunc = func(options metaV1.ListOptions) (runtime.Object, error) {
+			return kube.Kubernetes.CoreV1().Endpoints(namespace).List(options)
+		}
+		watchFunc = func(options metaV1.ListOptions) (watch.Interface, error) {
+			return kube.Kubernetes.CoreV1().Endpoints(namespace).Watch(options)
+		}
 
 	case "ingress":
-		sharedInformer = extensionsV1Beta1.NewFilteredIngressInformer(kube.Kubernetes, namespace, resyncPeriod, indexers, tweakListOptions)
-		resourceList, listErr = kube.Kubernetes.ExtensionsV1beta1().Ingresses(namespace).List(listOptions)
+		objType = &extensionsV1Beta1.Ingress{}
+		listFunc = func(options metaV1.ListOptions) (runtime.Object, error) {
+			return kube.Kubernetes.ExtensionsV1beta1().Ingresses(namespace).List(options)
+		}
+		watchFunc = func(options metaV1.ListOptions) (watch.Interface, error) {
+			return kube.Kubernetes.ExtensionsV1beta1().Ingresses(namespace).Watch(options)
+		}
 
 	case "service":
-		sharedInformer = coreV1.NewFilteredServiceInformer(kube.Kubernetes, namespace, resyncPeriod, indexers, tweakListOptions)
-		resourceList, listErr = kube.Kubernetes.CoreV1().Services(namespace).List(listOptions)
+		objType = &coreV1.Service{}
+		listFunc = func(options metaV1.ListOptions) (runtime.Object, error) {
+			return kube.Kubernetes.CoreV1().Services(namespace).List(options)
+		}
+		watchFunc = func(options metaV1.ListOptions) (watch.Interface, error) {
+			return kube.Kubernetes.CoreV1().Services(namespace).Watch(options)
+		}
 
 	case "configmap":
-		sharedInformer = coreV1.NewFilteredConfigMapInformer(kube.Kubernetes, namespace, resyncPeriod, indexers, tweakListOptions)
-		resourceList, listErr = kube.Kubernetes.CoreV1().ConfigMaps(namespace).List(listOptions)
+		objType = &coreV1.ConfigMap{}
+		listFunc = func(options metaV1.ListOptions) (runtime.Object, error) {
+			return kube.Kubernetes.CoreV1().ConfigMaps(namespace).List(options)
+		}
+		watchFunc = func(options metaV1.ListOptions) (watch.Interface, error) {
+			return kube.Kubernetes.CoreV1().ConfigMaps(namespace).Watch(options)
+		}
 
 	case "secret":
-		sharedInformer = coreV1.NewFilteredSecretInformer(kube.Kubernetes, namespace, resyncPeriod, indexers, tweakListOptions)
-		resourceList, listErr = kube.Kubernetes.CoreV1().Secrets(namespace).List(listOptions)
+		objType = &coreV1.Secret{}
+		listFunc = func(options metaV1.ListOptions) (runtime.Object, error) {
+			return kube.Kubernetes.CoreV1().Secrets(namespace).List(options)
+		}
+		watchFunc = func(options metaV1.ListOptions) (watch.Interface, error) {
+			return kube.Kubernetes.CoreV1().Secrets(namespace).Watch(options)
+		}
 
 	case "persistentvolumeclaim":
-		sharedInformer = coreV1.NewFilteredPersistentVolumeClaimInformer(kube.Kubernetes, namespace, resyncPeriod, indexers, tweakListOptions)
-		resourceList, listErr = kube.Kubernetes.CoreV1().PersistentVolumeClaims(namespace).List(listOptions)
+		objType = &coreV1.PersistentVolumeClaim{}
+		listFunc = func(options metaV1.ListOptions) (runtime.Object, error) {
+			return kube.Kubernetes.CoreV1().PersistentVolumeClaims(namespace).List(options)
+		}
+		watchFunc = func(options metaV1.ListOptions) (watch.Interface, error) {
+			return kube.Kubernetes.CoreV1().PersistentVolumeClaims(namespace).Watch(options)
+		}
 
 	case "storageclass":
-		sharedInformer = storageV1.NewFilteredStorageClassInformer(kube.Kubernetes, resyncPeriod, indexers, tweakListOptions)
-		resourceList, listErr = kube.Kubernetes.StorageV1().StorageClasses().List(listOptions)
+		objType = &storageV1.StorageClass{}
+		listFunc = func(options metaV1.ListOptions) (runtime.Object, error) {
+			return kube.Kubernetes.StorageV1().StorageClasses().List(options)
+		}
+		watchFunc = func(options metaV1.ListOptions) (watch.Interface, error) {
+			return kube.Kubernetes.StorageV1().StorageClasses().Watch(options)
+		}
 
 	case "node":
-		sharedInformer = coreV1.NewFilteredNodeInformer(kube.Kubernetes, resyncPeriod, indexers, tweakListOptions)
-		resourceList, listErr = kube.Kubernetes.CoreV1().Nodes().List(listOptions)
+		objType = &coreV1.Node{}
+		listFunc = func(options metaV1.ListOptions) (runtime.Object, error) {
+			return kube.Kubernetes.CoreV1().Nodes().List(options)
+		}
+		watchFunc = func(options metaV1.ListOptions) (watch.Interface, error) {
+			return kube.Kubernetes.CoreV1().Nodes().Watch(options)
+		}
 
 	case "serviceaccount":
-		sharedInformer = coreV1.NewFilteredServiceAccountInformer(kube.Kubernetes, namespace, resyncPeriod, indexers, tweakListOptions)
-		resourceList, err = kube.Kubernetes.CoreV1().ServiceAccounts(namespace).List(listOptions)
+		objType = &coreV1.ServiceAccount{}
+		listFunc = func(options metaV1.ListOptions) (runtime.Object, error) {
+			return kube.Kubernetes.CoreV1().ServiceAccounts(namespace).List(options)
+		}
+		watchFunc = func(options metaV1.ListOptions) (watch.Interface, error) {
+			return kube.Kubernetes.CoreV1().ServiceAccounts(namespace).Watch(options)
+		}
 
 	default:
-		return nil, fmt.Errorf("kind '%s' isn't supported", kind)
+		if apiVersion == "" {
+			return "", nil, nil, fmt.Errorf("kind '%s' isn't supported, apiVersion is required for custom resources", kind)
+		}
+		gvr, namespaced, err := kube.GroupVersionResource(apiVersion, kind)
+		if err != nil {
+			return "", nil, nil, err
+		}
+		if !namespaced {
+			namespace = ""
+		}
+		dynamicClient, err := kube.DynamicClient()
+		if err != nil {
+			return "", nil, nil, err
+		}
+		objType = &unstructured.Unstructured{}
+		listFunc = func(options metaV1.ListOptions) (runtime.Object, error) {
+			return dynamicClient.Resource(gvr).Namespace(namespace).List(options)
+		}
+		watchFunc = func(options metaV1.ListOptions) (watch.Interface, error) {
+			return dynamicClient.Resource(gvr).Namespace(namespace).Watch(options)
+		}
+		// the same kind can be served by several api groups
+		informerKey = fmt.Sprintf("%s/%s/%s/%s", apiVersion, strings.ToLower(kind), namespace, formatSelector)
 	}
 
-	if listErr != nil {
-		return nil, fmt.Errorf("failed to list '%s' resources: %v", kind, err)
+	lw := &cache.ListWatch{
+		ListFunc: func(options metaV1.ListOptions) (runtime.Object, error) {
+			if formatSelector != "" {
+				options.LabelSelector = formatSelector
+			}
+			return listFunc(options)
+		},
+		WatchFunc: func(options metaV1.ListOptions) (watch.Interface, error) {
+			if formatSelector != "" {
+				options.LabelSelector = formatSelector
+			}
+			return watchFunc(options)
+		},
 	}
 
-	// Save already existed resources to IGNORE watch.Added events about them
-	err = kubeEventsInformer.InitializeItemsList(resourceList, debug)
-	if err != nil {
-		return nil, err
+	newInformer = func() cache.SharedIndexInformer {
+		return cache.NewSharedIndexInformer(kube.NewRelistingListWatch(lw),
+			objType,
+			time.Duration(2)*time.Hour,
+			cache.Indexers{cache.NamespaceIndex: cache.MetaNamespaceIndexFunc})
+	}
+	listResources = func() (runtime.Object, error) {
+		return lw.List(metaV1.ListOptions{})
 	}
 
-	kubeEventsInformer.SharedInformer = sharedInformer
-	kubeEventsInformer.SharedInformer.AddEventHandler(resourceEventHandlerFuncs(kubeEventsInformer))
-
-	em.KubeEventsInformersByConfigId[kubeEventsInformer.ConfigId] = kubeEventsInformer
-
-	return kubeEventsInformer, nil
+	return informerKey, newInformer, listResources, nil
 }
 
 func formatLabelSelector(selector *metaV1.LabelSelector) (string, error) {
@@ -298,10 +497,28 @@ func resourceFilter(obj interface{}, jqFilter string, debug bool) (res string, e
 	return
 }
 
+// eventObject converts the object into a json map for the binding context. Objects of
+// informers have no kind, so it is set from the binding.
+func eventObject(obj interface{}, kind string) (map[string]interface{}, error) {
+	data, err := json.Marshal(obj)
+	if err != nil {
+		return nil, err
+	}
+	res := make(map[string]interface{})
+	if err := json.Unmarshal(data, &res); err != nil {
+		return nil, err
+	}
+	if _, has := res["kind"]; !has && kind != "" {
+		res["kind"] = kind
+	}
+	return res, nil
+}
+
 func (em *MainKubeEventsManager) Stop(configId string) error {
 	kubeEventsInformer, ok := em.KubeEventsInformersByConfigId[configId]
 	if ok {
 		kubeEventsInformer.Stop()
+		delete(em.KubeEventsInformersByConfigId, configId)
 	} else {
 		rlog.Errorf("Kube events informer '%s' not found!", configId)
 	}
@@ -309,19 +526,29 @@ func (em *MainKubeEventsManager) Stop(configId string) error {
 }
 
 type KubeEventsInformer struct {
-	ConfigId           string
-	Kind               string
-	EventTypes         []module_manager.OnKubernetesEventType
-	JqFilter           string
-	Checksum           map[string]string
-	SharedInformer     cache.SharedInformer
-	SharedInformerStop chan struct{}
+	ConfigId       string
+	ApiVersion     string
+	Kind           string
+	EventTypes     []module_manager.OnKubernetesEventType
+	JqFilter       string
+	Checksum       map[string]string
+	SharedInformer cache.SharedInformer
+
+	// key of the shared informer in sharedInformers, empty while the kind is not registered
+	informerKey string
+	// handler cannot be removed from the shared informer, so events are ignored after Stop
+	stopped int32
+	// closed on Stop to cancel waiting for the kind
+	stopCh chan struct{}
+	// worker of the kind that runs handlers of the binding, nil while the kind is not registered
+	shard *kindShard
+	m     sync.Mutex
 }
 
 func NewKubeEventsInformer() *KubeEventsInformer {
 	kubeEventsInformer := &KubeEventsInformer{}
 	kubeEventsInformer.Checksum = make(map[string]string)
-	kubeEventsInformer.SharedInformerStop = make(chan struct{}, 1)
+	kubeEventsInformer.stopCh = make(chan struct{})
 	return kubeEventsInformer
 }
 
@@ -331,39 +558,79 @@ func (ei *KubeEventsInformer) InitializeItemsList(list runtime.Object, debug boo
 		rlog.Errorf("InitializeItemsList got invalid List of type %T from API: %v", list, err)
 	}
 
+	items := make([]interface{}, 0, len(objects))
+	for _, obj := range objects {
+		items = append(items, obj)
+	}
+	return ei.InitializeItems(items, debug)
+}
+
+// InitializeItems saves checksums of existing objects
+func (ei *KubeEventsInformer) InitializeItems(objects []interface{}, debug bool) error {
 	for _, obj := range objects {
 		resourceId, err := runtimeResourceId(obj)
 		if err != nil {
 			return err
 		}
 
-		filtered, err := resourceFilter(obj, ei.JqFilter, debug)
+		checksum, err := ei.objectChecksum(obj, "initialization", debug)
 		if err != nil {
 			return err
 		}
+		ei.Checksum[resourceId] = checksum
+	}
 
-		ei.Checksum[resourceId] = utils.CalculateChecksum(filtered)
+	return nil
+}
 
-		if debug {
-			rlog.Debugf("Kube events manager: %+v informer %s: %s object %s initialization: jqFilter '%s': calculated checksum '%s' of object being watched:\n%s",
-				ei.EventTypes,
-				ei.ConfigId,
-				ei.Kind,
-				resourceId,
-				ei.JqFilter,
-				ei.Checksum[resourceId],
-				utils.FormatJsonDataOrError(utils.FormatPrettyJson(filtered)))
-		}
+// Checksum of objects for bindings without add and update events, existence of object is enough to detect deletion
+const objectExistsChecksum = "exists"
+
+// objectChecksum returns checksum of the object filtered with jqFilter.
+// Objects are not filtered if binding has only delete event.
+func (ei *KubeEventsInformer) objectChecksum(obj interface{}, action string, debug bool) (string, error) {
+	if !ei.ShouldHandleEvent(module_manager.KubernetesEventOnAdd) && !ei.ShouldHandleEvent(module_manager.KubernetesEventOnUpdate) {
+		return objectExistsChecksum, nil
 	}
 
-	return nil
+	filtered, err := resourceFilter(obj, ei.JqFilter, debug)
+	if err != nil {
+		return "", err
+	}
+
+	checksum := utils.CalculateChecksum(filtered)
+
+	if debug {
+		objectId, _ := runtimeResourceId(obj)
+		rlog.Debugf("Kube events manager: %+v informer %s: %s %s object %s: jqFilter '%s': calculated checksum '%s' of object being watched:\n%s",
+			ei.EventTypes, ei.ConfigId, action, ei.Kind, objectId, ei.JqFilter, checksum, utils.FormatJsonDataOrError(utils.FormatPrettyJson(filtered)))
+	}
+
+	return checksum, nil
+}
+
+// sameResourceVersion returns true if objects have the same non empty resourceVersion
+func sameResourceVersion(oldObj interface{}, obj interface{}) bool {
+	oldAccessor, err := meta.Accessor(oldObj)
+	if err != nil {
+		return false
+	}
+	accessor, err := meta.Accessor(obj)
+	if err != nil {
+		return false
+	}
+	return accessor.GetResourceVersion() != "" && oldAccessor.GetResourceVersion() == accessor.GetResourceVersion()
 }
 
-// HandleKubeEvent sends new KubeEvent to KubeEventCh
+// HandleKubeEvent sends new KubeEvent to KubeEventCh through the worker of the kind
 // obj doesn't contains Kind information, so kind is passed from Run() argument.
 // TODO refactor: pass KubeEvent as argument
 // TODO add delay to merge Added and Modified events (node added and then labels applied — one hook run on Added+Modifed is enough)
 func (ei *KubeEventsInformer) HandleKubeEvent(obj interface{}, kind string, newChecksum string, eventType string, sendSignal bool, debug bool) error {
+	if atomic.LoadInt32(&ei.stopped) == 1 {
+		return nil
+	}
+
 	objectId, err := runtimeResourceId(obj.(runtime.Object))
 	if err != nil {
 		return fmt.Errorf("failed to get object id: %s", err)
@@ -371,7 +638,11 @@ func (ei *KubeEventsInformer) HandleKubeEvent(obj interface{}, kind string, newC
 
 	if ei.Checksum[objectId] != newChecksum {
 		oldChecksum := ei.Checksum[objectId]
-		ei.Checksum[objectId] = newChecksum
+		if newChecksum == "" {
+			delete(ei.Checksum, objectId)
+		} else {
+			ei.Checksum[objectId] = newChecksum
+		}
 
 		if debug {
 			rlog.Debugf("Kube events manager: %+v informer %s: %s object %s: checksum has changed: '%s' -> '%s'", ei.EventTypes, ei.ConfigId, ei.Kind, objectId, oldChecksum, newChecksum)
@@ -383,12 +654,22 @@ func (ei *KubeEventsInformer) HandleKubeEvent(obj interface{}, kind string, newC
 			}
 			// Safe to ignore an error because of previous call to runtimeResourceId()
 			namespace, name, _ := metaFromEventObject(obj.(runtime.Object))
-			KubeEventCh <- KubeEvent{
+			object, err := eventObject(obj, kind)
+			if err != nil {
+				rlog.Errorf("Kube events manager: %+v informer %s: %s object %s: cannot pass object to binding context: %s", ei.EventTypes, ei.ConfigId, ei.Kind, objectId, err)
+			}
+			event := KubeEvent{
 				ConfigId:  ei.ConfigId,
 				Events:    []string{eventType},
 				Namespace: namespace,
 				Kind:      kind,
 				Name:      name,
+				Object:    object,
+			}
+			if ei.shard != nil {
+				ei.shard.send(event)
+			} else {
+				KubeEventCh <- event
 			}
 		}
 	} else if debug {
@@ -431,14 +712,34 @@ func (ei *KubeEventsInformer) ShouldHandleEvent(checkEvent module_manager.OnKube
 	return false
 }
 
+// Run starts the shared informer if it is not started by other bindings
 func (ei *KubeEventsInformer) Run() {
+	ei.m.Lock()
+	defer ei.m.Unlock()
+	if ei.informerKey == "" {
+		return
+	}
 	rlog.Debugf("Kube events manager: run informer %s", ei.ConfigId)
-	ei.SharedInformer.Run(ei.SharedInformerStop)
+	sharedInformers.start(ei.informerKey)
 }
 
+// Stop stops the shared informer if there are no other bindings for it.
+// Events for stopped binding are ignored.
 func (ei *KubeEventsInformer) Stop() {
+	ei.m.Lock()
+	defer ei.m.Unlock()
+	if atomic.LoadInt32(&ei.stopped) == 1 {
+		return
+	}
 	rlog.Debugf("Kube events manager: stop informer %s", ei.ConfigId)
-	close(ei.SharedInformerStop)
+	atomic.StoreInt32(&ei.stopped, 1)
+	close(ei.stopCh)
+	if ei.informerKey != "" {
+		sharedInformers.release(ei.informerKey)
+	}
+	if ei.shard != nil {
+		kindShards.release(ei.Kind)
+	}
 }
 
 func execJq(jqFilter string, jsonData []byte, debug bool) (stdout string, stderr string, err error) {
@@ -0,0 +1,74 @@
+package kube_events_manager
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	v1 "k8s.io/api/core/v1"
+
+	"github.com/flant/antiopa/module_manager"
+)
+
+func TestKubeEventsInformer_DeleteOnly(t *testing.T) {
+	KubeEventCh = make(chan KubeEvent, 1)
+
+	pod := &v1.Pod{}
+	pod.Name = "test"
+	pod.Namespace = "default"
+
+	ei := NewKubeEventsInformer()
+	ei.Kind = "Pod"
+	ei.EventTypes = []module_manager.OnKubernetesEventType{module_manager.KubernetesEventOnDelete}
+	// jq is not executed for delete-only bindings
+	ei.JqFilter = ".bad jq filter"
+
+	if !assert.NoError(t, ei.InitializeItems([]interface{}{pod}, false)) {
+		return
+	}
+	assert.Equal(t, objectExistsChecksum, ei.Checksum["name=test namespace=default"])
+
+	if !assert.NoError(t, ei.HandleKubeEvent(pod, "Pod", "", "DELETED", true, false)) {
+		return
+	}
+	event := <-KubeEventCh
+	assert.Equal(t, []string{"DELETED"}, event.Events)
+	assert.Equal(t, "Pod", event.Object["kind"])
+	assert.Equal(t, "test", event.Object["metadata"].(map[string]interface{})["name"])
+	assert.Len(t, ei.Checksum, 0)
+}
+
+func TestSameResourceVersion(t *testing.T) {
+	oldPod := &v1.Pod{}
+	oldPod.ResourceVersion = "100"
+	pod := oldPod.DeepCopy()
+
+	assert.True(t, sameResourceVersion(oldPod, pod))
+
+	pod.ResourceVersion = "101"
+	assert.False(t, sameResourceVersion(oldPod, pod))
+}
+
+func TestInformerFactory(t *testing.T) {
+	informerKey, _, _, err := informerFactory("v1", "Pod", "default", "app=test")
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.Equal(t, "pod/default/app=test", informerKey)
+
+	// custom kind requires apiVersion
+	_, _, _, err = informerFactory("", "Certificate", "default", "")
+	assert.Error(t, err)
+}
+
+func TestKubeEventsInformer_StopPending(t *testing.T) {
+	ei := NewKubeEventsInformer()
+	ei.ApiVersion = "example.com/v1"
+	ei.Kind = "Certificate"
+
+	// Stop of binding waiting for the kind does not release any shared informer
+	ei.Stop()
+	ei.Stop()
+	assert.Equal(t, 0, sharedInformers.count())
+	_, ok := <-ei.stopCh
+	assert.False(t, ok)
+}
@@ -0,0 +1,89 @@
+package kube_events_manager
+
+import (
+	"sync"
+
+	"github.com/romana/rlog"
+	"k8s.io/client-go/tools/cache"
+)
+
+// sharedInformers keeps one informer for bindings with the same kind, namespace
+// and label selector. Informer is created on first binding, started on first Run
+// and stopped when the last binding is stopped, so hundreds of bindings do not
+// create hundreds of watches at startup.
+var sharedInformers = newSharedInformersRegistry()
+
+type sharedInformer struct {
+	informer cache.SharedIndexInformer
+	stopCh   chan struct{}
+	refs     int
+	started  bool
+}
+
+type sharedInformersRegistry struct {
+	m         sync.Mutex
+	informers map[string]*sharedInformer
+}
+
+func newSharedInformersRegistry() *sharedInformersRegistry {
+	return &sharedInformersRegistry{
+		informers: make(map[string]*sharedInformer),
+	}
+}
+
+// acquire returns informer by key, new informer is created with newInformer
+func (r *sharedInformersRegistry) acquire(key string, newInformer func() cache.SharedIndexInformer) *sharedInformer {
+	r.m.Lock()
+	defer r.m.Unlock()
+
+	shared, ok := r.informers[key]
+	if !ok {
+		rlog.Debugf("Kube events manager: create shared informer '%s'", key)
+		shared = &sharedInformer{
+			informer: newInformer(),
+			stopCh:   make(chan struct{}),
+		}
+		r.informers[key] = shared
+	}
+	shared.refs++
+	return shared
+}
+
+// start runs informer if it is not running
+func (r *sharedInformersRegistry) start(key string) {
+	r.m.Lock()
+	defer r.m.Unlock()
+
+	shared, ok := r.informers[key]
+	if !ok || shared.started {
+		return
+	}
+	shared.started = true
+	go shared.informer.Run(shared.stopCh)
+}
+
+// release stops informer when there are no more bindings for it
+func (r *sharedInformersRegistry) release(key string) {
+	r.m.Lock()
+	defer r.m.Unlock()
+
+	shared, ok := r.informers[key]
+	if !ok {
+		return
+	}
+	shared.refs--
+	if shared.refs > 0 {
+		return
+	}
+
+	rlog.Debugf("Kube events manager: stop shared informer '%s'", key)
+	close(shared.stopCh)
+	delete(r.informers, key)
+}
+
+// count returns number of shared informers
+func (r *sharedInformersRegistry) count() int {
+	r.m.Lock()
+	defer r.m.Unlock()
+	return len(r.informers)
+}
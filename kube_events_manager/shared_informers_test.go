@@ -0,0 +1,38 @@
+package kube_events_manager
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"k8s.io/client-go/tools/cache"
+)
+
+func TestSharedInformersRegistry(t *testing.T) {
+	r := newSharedInformersRegistry()
+
+	created := 0
+	newInformer := func() cache.SharedIndexInformer {
+		created++
+		return nil
+	}
+
+	first := r.acquire("pod/default/app=test", newInformer)
+	second := r.acquire("pod/default/app=test", newInformer)
+	r.acquire("pod/kube-system/", newInformer)
+
+	assert.Equal(t, first, second)
+	assert.Equal(t, 2, created)
+	assert.Equal(t, 2, r.count())
+
+	// informer is stopped with the last binding
+	r.release("pod/default/app=test")
+	assert.Equal(t, 2, r.count())
+	r.release("pod/default/app=test")
+	assert.Equal(t, 1, r.count())
+
+	select {
+	case <-first.stopCh:
+	default:
+		t.Errorf("stop channel of released informer should be closed")
+	}
+}
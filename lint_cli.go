@@ -0,0 +1,112 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"gopkg.in/yaml.v2"
+
+	"github.com/flant/antiopa/helm"
+	"github.com/flant/antiopa/module_manager"
+)
+
+// LintCheck is a machine-readable result of one `antiopa lint` check
+type LintCheck struct {
+	Name  string `json:"name"`
+	Ok    bool   `json:"ok"`
+	Error string `json:"error,omitempty"`
+}
+
+// LintReport is printed by `antiopa lint -format json`
+type LintReport struct {
+	Ok     bool        `json:"ok"`
+	Checks []LintCheck `json:"checks"`
+}
+
+// RunLintCommand handles `antiopa lint`: modules are discovered, hooks configs and values are
+// validated and charts are rendered offline. It is intended for CI of modules repositories.
+func RunLintCommand(args []string) error {
+	flags := flag.NewFlagSet("lint", flag.ContinueOnError)
+	modulesDir := flags.String("modules-dir", "modules", "'modules' directory of the working dir, global hooks are loaded from 'global-hooks' near it")
+	valuesPath := flags.String("values", "", "yaml file with config values as in the ConfigMap: 'global' and modules sections, 'false' in module section disables module")
+	format := flags.String("format", "json", "report format: 'json' or 'text'")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+	if *format != "json" && *format != "text" {
+		return fmt.Errorf("unknown format '%s', expected 'json' or 'text'", *format)
+	}
+
+	absModulesDir, err := filepath.Abs(*modulesDir)
+	if err != nil {
+		return err
+	}
+	if filepath.Base(absModulesDir) != "modules" {
+		return fmt.Errorf("modules dir '%s' should be named 'modules'", *modulesDir)
+	}
+
+	configValues, err := readConfigValuesFile(*valuesPath)
+	if err != nil {
+		return err
+	}
+
+	// enabled scripts and hooks write files into temp dir
+	tempDir, err := ioutil.TempDir("", "antiopa-lint")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(tempDir)
+
+	checks := module_manager.LintWorkingDir(filepath.Dir(absModulesDir), tempDir, configValues, helm.NewOfflineHelm("default"))
+
+	report := LintReport{Ok: true, Checks: make([]LintCheck, 0, len(checks))}
+	for _, check := range checks {
+		lintCheck := LintCheck{Name: check.Name, Ok: check.Error == nil}
+		if check.Error != nil {
+			report.Ok = false
+			lintCheck.Error = check.Error.Error()
+		}
+		report.Checks = append(report.Checks, lintCheck)
+	}
+
+	if *format == "json" {
+		data, err := json.MarshalIndent(report, "", "  ")
+		if err != nil {
+			return err
+		}
+		fmt.Println(string(data))
+	} else {
+		for _, check := range report.Checks {
+			if check.Ok {
+				fmt.Printf("OK   %s\n", check.Name)
+			} else {
+				fmt.Printf("FAIL %s: %s\n", check.Name, check.Error)
+			}
+		}
+	}
+
+	if !report.Ok {
+		return fmt.Errorf("lint failed")
+	}
+	return nil
+}
+
+// readConfigValuesFile reads yaml with config values as in the ConfigMap, empty values are returned if path is empty
+func readConfigValuesFile(path string) (map[interface{}]interface{}, error) {
+	configValues := make(map[interface{}]interface{})
+	if path == "" {
+		return configValues, nil
+	}
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("cannot read values: %s", err)
+	}
+	if err := yaml.Unmarshal(data, &configValues); err != nil {
+		return nil, fmt.Errorf("bad values '%s': %s", path, err)
+	}
+	return configValues, nil
+}
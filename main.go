@@ -1,27 +1,42 @@
 package main
 
 import (
+	"bytes"
+	"encoding/json"
 	"flag"
+	"fmt"
 	"io"
 	"net/http"
 	_ "net/http/pprof"
 	"os"
 	"path"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"sync/atomic"
 	"time"
 
 	"github.com/prometheus/client_golang/prometheus/promhttp"
 	"github.com/romana/rlog"
 
+	"github.com/flant/antiopa/approval"
+	"github.com/flant/antiopa/chart_repo"
 	"github.com/flant/antiopa/docker_registry_manager"
 	"github.com/flant/antiopa/executor"
+	"github.com/flant/antiopa/failure_injection"
 	"github.com/flant/antiopa/helm"
+	"github.com/flant/antiopa/http_poller"
 	"github.com/flant/antiopa/kube"
+	"github.com/flant/antiopa/kube_config_manager"
 	"github.com/flant/antiopa/kube_events_manager"
 	"github.com/flant/antiopa/metrics_storage"
 	"github.com/flant/antiopa/module_manager"
 	"github.com/flant/antiopa/schedule_manager"
+	"github.com/flant/antiopa/state_store"
 	"github.com/flant/antiopa/task"
 	"github.com/flant/antiopa/utils"
+	"github.com/flant/antiopa/vault"
+	"github.com/flant/antiopa/version"
 )
 
 var (
@@ -46,10 +61,48 @@ var (
 	// schedule manager
 	ScheduleManager schedule_manager.ScheduleManager
 	ScheduledHooks  ScheduledHooksStorage
+	// last runs of schedule bindings for the schedule calendar
+	ScheduleRuns *ScheduleRunResults
+
+	// pollers of HTTP endpoints that run modules and hooks on response change
+	HttpPoller http_poller.HttpPoller
 
 	KubeEventsManager kube_events_manager.KubeEventsManager
 	KubeEventsHooks   KubeEventsHooksController
 
+	// watcher for resources of modules releases
+	ReleaseWatcher ReleaseResourcesWatcher
+
+	// history of recent converge cycles for debug API
+	ConvergeCycles *ConvergeHistory
+
+	// module runs deferred until maintenance windows
+	DeferredRuns *DeferredModuleRuns
+
+	// module runs kept out of the queue while converge is disabled
+	PausedRuns *PausedModuleRuns
+
+	// failed module runs removed from the queue until backoff delays are over
+	ModuleRetries *ModuleRunRetries
+
+	// module runs removed from the queue while helm upgrades wait for gates
+	HelmUpgradeWaits *HelmUpgradeWaitsStorage
+
+	// results of the last module runs for flapping detection
+	ModulesHealth *ModulesHealthTracker
+
+	// composite health condition of critical modules and queues for paging alerts
+	AddonsHealth *AddonsHealthChecker
+
+	// hook runs held until modules from waitForModules are converged
+	HeldHookRuns *HeldHookRunsStorage
+
+	// plans of converge cycles and the plan waiting for approval
+	ConvergePlanner *ConvergePlans
+
+	// release notes of modules for operators
+	AddonsReports *AddonsReport
+
 	MetricsStorage *metrics_storage.MetricStorage
 
 	// chan for stopping ManagersEventsHandler infinite loop
@@ -57,10 +110,46 @@ var (
 
 	// helm client object
 	HelmClient helm.HelmClient
+	// helm clients for the default tiller and tillers of module groups
+	HelmClients *helm.ClientsPool
+
+	// run tiller process on localhost instead of tiller Deployment
+	EmbeddedTiller bool
+	// tiller is provisioned by cluster admins, helm init is skipped
+	ExternalTiller bool
+
+	// dev mode: fake kube client and helm recorder instead of the cluster
+	DevMode bool
+	// directory with yaml fixtures for the fake kube client
+	DevFixturesDir string
+
+	// Vault for secrets in values
+	VaultAddress  string
+	VaultRole     string
+	VaultAuthPath string
 )
 
 const DefaultTasksQueueDumpFilePath = "/tmp/antiopa-tasks-queue"
 
+// TasksQueueDumpInterval is a minimal period between snapshots of the queue in -state-storage
+var TasksQueueDumpInterval = 10 * time.Second
+
+// Module runs are paused while converge is disabled with annotation on antiopa ConfigMap.
+// Triggered runs are kept in PausedRuns and are queued again when converge is enabled.
+var convergeDisabled int32
+
+func SetConvergeDisabled(disabled bool) {
+	var value int32
+	if disabled {
+		value = 1
+	}
+	atomic.StoreInt32(&convergeDisabled, value)
+}
+
+func IsConvergeDisabled() bool {
+	return atomic.LoadInt32(&convergeDisabled) == 1
+}
+
 // Задержки при обработке тасков из очереди
 var (
 	QueueIsEmptyDelay = 3 * time.Second
@@ -77,6 +166,41 @@ func Init() {
 
 	var err error
 
+	if err = CheckConvergeOnce(); err != nil {
+		rlog.Errorf("MAIN Fatal: bad converge once mode: %s", err)
+		os.Exit(1)
+	}
+
+	if err = checkRbacSelfCheckMode(); err != nil {
+		rlog.Errorf("MAIN Fatal: bad -rbac-self-check: %s", err)
+		os.Exit(1)
+	}
+
+	if err = helm.CheckClientType(); err != nil {
+		rlog.Errorf("MAIN Fatal: bad -helm-client: %s", err)
+		os.Exit(1)
+	}
+
+	if err = checkSelfResourcesMode(); err != nil {
+		rlog.Errorf("MAIN Fatal: bad -self-resources: %s", err)
+		os.Exit(1)
+	}
+
+	if err = state_store.CheckKind(state_store.Kind); err != nil {
+		rlog.Errorf("MAIN Fatal: bad -state-storage: %s", err)
+		os.Exit(1)
+	}
+
+	if EmbeddedTiller && ExternalTiller {
+		rlog.Errorf("MAIN Fatal: -embedded-tiller and -external-tiller cannot be used together")
+		os.Exit(1)
+	}
+
+	if failure_injection.Enabled {
+		rlog.Warnf("MAIN failure injection is enabled, failures can be injected with POST /debug/failures/inject")
+		failure_injection.SetWatchDisconnector(kube.DisconnectWatches)
+	}
+
 	WorkingDir, err = os.Getwd()
 	if err != nil {
 		rlog.Errorf("MAIN Fatal: Cannot determine antiopa working dir: %s", err)
@@ -84,14 +208,28 @@ func Init() {
 	}
 	rlog.Infof("Antiopa working dir: %s", WorkingDir)
 
-	TempDir := "/tmp/antiopa"
-	err = os.Mkdir(TempDir, os.FileMode(0777))
+	// another process with the same directories would remove the temporary session
+	// and run helm for the same releases
+	if err = os.MkdirAll(TempDirBase, os.FileMode(0777)); err == nil {
+		err = LockDirs(TempDirBase, filepath.Join(WorkingDir, "modules"))
+	}
+	if err != nil {
+		rlog.Errorf("MAIN Fatal: cannot lock directories: %s", err)
+		os.Exit(1)
+	}
+
+	TempDir, err = InitTempDir()
 	if err != nil {
 		rlog.Errorf("MAIN Fatal: Cannot create antiopa temporary dir: %s", err)
 		os.Exit(1)
 	}
 	rlog.Infof("Antiopa temporary dir: %s", TempDir)
 
+	if err = InitCommandWrapperShims(TempDir); err != nil {
+		rlog.Errorf("MAIN Fatal: -command-wrapper: %s", err)
+		os.Exit(1)
+	}
+
 	Hostname, err = os.Hostname()
 	if err != nil {
 		rlog.Errorf("MAIN Fatal: Cannot get pod name from hostname: %s", err)
@@ -99,42 +237,98 @@ func Init() {
 	}
 	rlog.Infof("Antiopa hostname: %s", Hostname)
 
-	// Инициализация подключения к kube
-	kube.InitKube()
+	if DevMode {
+		rlog.Infof("Antiopa is running in dev mode")
 
-	// Инициализация слежения за образом
-	// TODO Antiopa может и не следить, если кластер заморожен?
-	RegistryManager, err = docker_registry_manager.Init(Hostname)
-	if err != nil {
-		rlog.Errorf("MAIN Fatal: Cannot initialize registry manager: %s", err)
-		os.Exit(1)
-	}
+		err = kube.InitFakeKube(DevFixturesDir)
+		if err != nil {
+			rlog.Errorf("MAIN Fatal: Cannot initialize fake kube client: %s", err)
+			os.Exit(1)
+		}
 
-	// Инициализация helm — установка tiller, если его нет
-	// TODO KubernetesAntiopaNamespace — имя поменяется, это старая переменная
-	tillerNamespace := kube.KubernetesAntiopaNamespace
-	rlog.Debugf("Antiopa tiller namespace: %s", tillerNamespace)
-	HelmClient, err = helm.Init(tillerNamespace)
-	if err != nil {
-		rlog.Errorf("MAIN Fatal: cannot initialize helm: %s", err)
-		os.Exit(1)
+		// Обновления образа не отслеживаются, tiller не устанавливается
+		HelmClient = helm.NewRecorderHelm(kube.KubernetesAntiopaNamespace)
+		HelmClients = helm.NewClientsPool(HelmClient, func(tillerNamespace string) (helm.HelmClient, error) {
+			return helm.NewRecorderHelm(tillerNamespace), nil
+		})
+	} else {
+		// Инициализация подключения к kube
+		kube.InitKube()
+
+		// Инициализация слежения за образом
+		// TODO Antiopa может и не следить, если кластер заморожен?
+		RegistryManager, err = docker_registry_manager.Init(Hostname)
+		if err != nil {
+			rlog.Errorf("MAIN Fatal: Cannot initialize registry manager: %s", err)
+			os.Exit(1)
+		}
+
+		// Инициализация helm — установка tiller, если его нет
+		// TODO KubernetesAntiopaNamespace — имя поменяется, это старая переменная
+		tillerNamespace := kube.KubernetesAntiopaNamespace
+		rlog.Debugf("Antiopa tiller namespace: %s", tillerNamespace)
+		tillerMode := helm.TillerModeDeployment
+		if EmbeddedTiller {
+			tillerMode = helm.TillerModeEmbedded
+		}
+		if ExternalTiller {
+			tillerMode = helm.TillerModeExternal
+		}
+		initHelm, _ := helm.InitFunc(tillerMode)
+		HelmClient, err = initHelm(tillerNamespace)
+		if err != nil {
+			rlog.Errorf("MAIN Fatal: cannot initialize helm: %s", err)
+			os.Exit(1)
+		}
+		// tillers for module groups are installed on first use
+		HelmClients = helm.NewClientsPool(HelmClient, initHelm)
+
+		// vault:path#key references in values are resolved with secrets from Vault
+		if VaultAddress != "" {
+			err = vault.Init(VaultAddress, VaultRole, VaultAuthPath)
+			if err != nil {
+				rlog.Errorf("MAIN Fatal: cannot initialize vault client: %s", err)
+				os.Exit(1)
+			}
+		}
+
+		// hooks are not run yet, labeled pods, Jobs and Secrets are left by the previous process
+		kube.CleanupNodeExecPods()
+		kube.CleanupHookJobs()
 	}
 
 	// Инициализация слежения за конфигом и за values
-	ModuleManager, err = module_manager.Init(WorkingDir, TempDir, HelmClient)
+	ModuleManager, err = module_manager.Init(WorkingDir, TempDir, HelmClients)
 	if err != nil {
 		rlog.Errorf("MAIN Fatal: Cannot initialize module manager: %s", err)
 		os.Exit(1)
 	}
 
+	if err = RunRbacSelfCheck(ModuleManager); err != nil {
+		rlog.Errorf("MAIN Fatal: RBAC self-check: %s", err)
+		os.Exit(1)
+	}
+
 	// Пустая очередь задач.
 	TasksQueue = task.NewTasksQueue()
 
 	// Дампер для сброса изменений в очереди во временный файл
 	// TODO определить файл через переменную окружения?
 	TasksQueueDumpFilePath = DefaultTasksQueueDumpFilePath
-	rlog.Debugf("Antiopa tasks queue dump file '%s'", TasksQueueDumpFilePath)
-	queueWatcher := task.NewTasksQueueDumper(TasksQueueDumpFilePath, TasksQueue)
+	var queueWatcher *task.TasksQueueDumper
+	if state_store.Kind == "" {
+		rlog.Debugf("Antiopa tasks queue dump file '%s'", TasksQueueDumpFilePath)
+		queueWatcher = task.NewTasksQueueDumper(TasksQueueDumpFilePath, TasksQueue)
+	} else {
+		// snapshot of the queue is kept with other states
+		queueStore, err := state_store.New(state_store.Kind, "antiopa-tasks-queue", "tasks-queue.txt")
+		if err != nil {
+			rlog.Errorf("MAIN Fatal: cannot create tasks queue store: %s", err)
+			os.Exit(1)
+		}
+		rlog.Debugf("Antiopa tasks queue is dumped into %s", queueStore)
+		queueWatcher = task.NewTasksQueueStoreDumper(queueStore, TasksQueueDumpInterval, TasksQueue)
+	}
 	TasksQueue.AddWatcher(queueWatcher)
 
 	// Инициализация хуков по расписанию - карта scheduleId → []ScheduleHook
@@ -144,12 +338,53 @@ func Init() {
 		os.Exit(1)
 	}
 
+	HttpPoller, err = http_poller.Init(filepath.Join(WorkingDir, http_poller.PollersFileName))
+	if err != nil {
+		rlog.Errorf("MAIN Fatal: Cannot initialize http poller: %s", err)
+		os.Exit(1)
+	}
+
 	KubeEventsManager, err = kube_events_manager.Init()
 	if err != nil {
 		rlog.Errorf("MAIN Fatal: Cannot initialize kube events manager: %s", err)
 		os.Exit(1)
 	}
 	KubeEventsHooks = NewMainKubeEventsHooksController()
+	ReleaseWatcher = NewMainReleaseResourcesWatcher()
+	ScheduleRuns = NewScheduleRunResults()
+	ConvergeCycles = NewConvergeHistory(ConvergeHistoryLength)
+	if ConvergeHistoryStorageName != "" {
+		historyStore, err := state_store.New(state_store.KindConfigMap, ConvergeHistoryStorageName, convergeHistoryStorageKey)
+		if err != nil {
+			rlog.Errorf("MAIN Fatal: cannot create converge history store: %s", err)
+			os.Exit(1)
+		}
+		// history is not critical, antiopa starts with empty history
+		if err := ConvergeCycles.Restore(historyStore); err != nil {
+			rlog.Errorf("MAIN %s", err)
+		}
+	}
+	DeferredRuns = NewDeferredModuleRuns()
+	PausedRuns = NewPausedModuleRuns()
+	ModuleRetries = NewModuleRunRetries()
+	if ModuleRunRetriesStorageName != "" {
+		retriesStore, err := state_store.New(state_store.KindConfigMap, ModuleRunRetriesStorageName, moduleRunRetriesStorageKey)
+		if err != nil {
+			rlog.Errorf("MAIN Fatal: cannot create module runs store: %s", err)
+			os.Exit(1)
+		}
+		// modules are converged at start anyway, only failure counts and retry times are lost
+		if err := ModuleRetries.Restore(retriesStore, time.Now()); err != nil {
+			rlog.Errorf("MAIN %s", err)
+		}
+	}
+	HelmUpgradeWaits = NewHelmUpgradeWaits()
+	ModulesHealth = NewModulesHealthTracker(ModuleHealthWindow)
+	AddonsHealth = NewAddonsHealthChecker()
+	ApiserverBreaker = NewApiserverCircuitBreaker(kube.CheckApiserver)
+	HeldHookRuns = NewHeldHookRuns()
+	ConvergePlanner = NewConvergePlans()
+	AddonsReports = NewAddonsReport()
 
 	MetricsStorage = metrics_storage.Init()
 }
@@ -165,25 +400,39 @@ func Run() {
 	TasksQueue.ChangesDisable()
 
 	CreateOnStartupTasks()
-	CreateReloadAllTasks(true)
+	CreateReloadAllTasks(true, "startup")
 
 	KubeEventsHooks.EnableGlobalHooks(ModuleManager, KubeEventsManager)
 
 	TasksQueue.ChangesEnable(true)
 
-	if RegistryManager != nil {
+	// antiopa exits after converge in converge once mode, image updates are not watched
+	if RegistryManager != nil && !ConvergeOnce {
 		// менеджеры - отдельные go-рутины, посылающие события в свои каналы
 		RegistryManager.SetErrorCallback(func() {
 			MetricsStorage.SendCounterMetric("antiopa_registry_errors", 1.0, map[string]string{})
 		})
 		go RegistryManager.Run()
 	}
+	if RegistryManager != nil && !EmbeddedTiller && !ExternalTiller {
+		// nodeSelector and tolerations of antiopa Deployment are copied into tiller Deployments
+		go helm.NewTillerSchedulingWatcher(func() []string {
+			namespaces := make([]string, 0)
+			for _, helmClient := range HelmClients.Clients() {
+				namespaces = append(namespaces, helmClient.TillerNamespace())
+			}
+			return namespaces
+		}).Run(make(chan struct{}))
+	}
 	go ModuleManager.Run()
 	go ScheduleManager.Run()
+	go HttpPoller.Run()
 
 	// обработчик добавления метрик
 	go MetricsStorage.Run()
 
+	go RunTempDirQuota(TempDir)
+
 	// обработчик событий от менеджеров — события превращаются в таски и
 	// добавляются в очередь
 	go ManagersEventsHandler()
@@ -191,6 +440,35 @@ func Run() {
 	// TasksRunner запускает задания из очереди
 	go TasksRunner()
 
+	if ConvergeOnce {
+		go RunConvergeOnceTimeout()
+	}
+
+	go DeferredRuns.Run()
+	go ModuleRetries.Run()
+	go HelmUpgradeWaits.Run()
+	go AddonsHealth.Run()
+
+	if ControlPlaneUpgradeCheckInterval > 0 && !ConvergeOnce {
+		go RunControlPlaneUpgradesWatcher()
+	}
+
+	if RegistryManager != nil {
+		go RunSelfMonitor()
+	}
+
+	if ConfigWebhookServiceName != "" && !DevMode && !ConvergeOnce {
+		if err := StartConfigValidatingWebhook(); err != nil {
+			rlog.Errorf("MAIN cannot start config validating webhook: %s", err)
+		}
+	}
+
+	if SelfResources != SelfResourcesOff && !DevMode && !ConvergeOnce {
+		if err := ApplySelfResources(); err != nil {
+			rlog.Errorf("MAIN cannot apply self resources: %s", err)
+		}
+	}
+
 	RunAntiopaMetrics()
 }
 
@@ -222,7 +500,9 @@ func ManagersEventsHandler() {
 						// TODO этого события по сути нет. Нужно реализовать для вызова onStartup!
 						rlog.Infof("EVENT ModulesChanged, type=Enabled")
 						newTask := task.NewTask(task.ModuleRun, moduleChange.Name).
-							WithOnStartupHooks(true)
+							WithOnStartupHooks(true).
+							WithCause("module enabled").
+							WithUrgent(true)
 						TasksQueue.Add(newTask)
 						rlog.Infof("QUEUE add ModuleRun %s", newTask.Name)
 
@@ -233,13 +513,15 @@ func ManagersEventsHandler() {
 
 					case module_manager.Changed:
 						rlog.Infof("EVENT ModulesChanged, type=Changed")
-						newTask := task.NewTask(task.ModuleRun, moduleChange.Name)
+						newTask := task.NewTask(task.ModuleRun, moduleChange.Name).
+							WithCause("module values changed")
 						TasksQueue.Add(newTask)
 						rlog.Infof("QUEUE add ModuleRun %s", newTask.Name)
 
 					case module_manager.Disabled:
 						rlog.Infof("EVENT ModulesChanged, type=Disabled")
-						newTask := task.NewTask(task.ModuleDelete, moduleChange.Name)
+						newTask := task.NewTask(task.ModuleDelete, moduleChange.Name).
+							WithCause("module disabled")
 						TasksQueue.Add(newTask)
 						rlog.Infof("QUEUE add ModuleDelete %s", newTask.Name)
 
@@ -250,7 +532,8 @@ func ManagersEventsHandler() {
 
 					case module_manager.Purged:
 						rlog.Infof("EVENT ModulesChanged, type=Purged")
-						newTask := task.NewTask(task.ModulePurge, moduleChange.Name)
+						newTask := task.NewTask(task.ModulePurge, moduleChange.Name).
+							WithCause("module purged")
 						TasksQueue.Add(newTask)
 						rlog.Infof("QUEUE add ModulePurge %s", newTask.Name)
 
@@ -266,7 +549,7 @@ func ManagersEventsHandler() {
 			case module_manager.GlobalChanged:
 				rlog.Infof("EVENT GlobalChanged")
 				TasksQueue.ChangesDisable()
-				CreateReloadAllTasks(false)
+				CreateReloadAllTasks(false, "global values changed")
 				TasksQueue.ChangesEnable(true)
 				// Пересоздать индекс хуков по расписанию
 				ScheduledHooks = UpdateScheduleHooks(ScheduledHooks)
@@ -283,6 +566,24 @@ func ManagersEventsHandler() {
 				TasksQueue.ChangesEnable(true)
 				rlog.Infof("QUEUE push ModuleManagerRetry, push FailedModuleDelay")
 			}
+		case disabled := <-kube_config_manager.ConvergeDisabledChanged:
+			rlog.Infof("EVENT ConvergeDisabledChanged: %v", disabled)
+			SetConvergeDisabled(disabled)
+			var metricValue float64
+			if disabled {
+				metricValue = 1.0
+			} else {
+				PausedRuns.Resume()
+			}
+			MetricsStorage.SendGaugeMetric("antiopa_converge_disabled", metricValue, map[string]string{})
+		case keys := <-kube_config_manager.OperationsApproved:
+			rlog.Infof("EVENT OperationsApproved: %v", keys)
+			for _, key := range keys {
+				approval.Approve(key)
+			}
+		case change := <-http_poller.ResponseChangedCh:
+			rlog.Infof("EVENT HttpPollerResponseChanged %s", change.Poller.Name)
+			QueueHttpPollerChange(change)
 		case crontab := <-schedule_manager.ScheduleCh:
 			scheduleHooks := ScheduledHooks.GetHooksForSchedule(crontab)
 			for _, hook := range scheduleHooks {
@@ -298,9 +599,11 @@ func ManagersEventsHandler() {
 						newTask := task.NewTask(task.GlobalHookRun, hook.Name).
 							WithBinding(module_manager.Schedule).
 							AppendBindingContext(module_manager.BindingContext{Binding: bindingName}).
-							WithAllowFailure(scheduleConfig.AllowFailure)
-						TasksQueue.Add(newTask)
-						rlog.Debugf("QUEUE add GlobalHookRun@Schedule '%s'", hook.Name)
+							WithAllowFailure(scheduleConfig.AllowFailure).
+							WithQueueName(scheduleConfig.Queue)
+						offset := schedule_manager.Offset(hook.Name, crontab)
+						QueueScheduledHookTask(newTask, offset, scheduleConfig.ConcurrencyPolicy)
+						rlog.Debugf("QUEUE add GlobalHookRun@Schedule '%s' after %s", hook.Name, offset)
 					}
 					continue
 				}
@@ -315,18 +618,30 @@ func ManagersEventsHandler() {
 						newTask := task.NewTask(task.ModuleHookRun, hook.Name).
 							WithBinding(module_manager.Schedule).
 							AppendBindingContext(module_manager.BindingContext{Binding: bindingName}).
-							WithAllowFailure(scheduleConfig.AllowFailure)
-						TasksQueue.Add(newTask)
-						rlog.Debugf("QUEUE add ModuleHookRun@Schedule '%s'", hook.Name)
+							WithAllowFailure(scheduleConfig.AllowFailure).
+							WithQueueName(scheduleConfig.Queue)
+						offset := schedule_manager.Offset(hook.Name, crontab)
+						QueueScheduledHookTask(newTask, offset, scheduleConfig.ConcurrencyPolicy)
+						rlog.Debugf("QUEUE add ModuleHookRun@Schedule '%s' after %s", hook.Name, offset)
 					}
 					continue
 				}
 
 				rlog.Errorf("MAIN_LOOP hook '%s' scheduled but not found by module_manager", hook.Name)
 			}
+		case operation := <-helm.ManualReleaseOperations:
+			HandleManualReleaseOperation(operation)
 		case kubeEvent := <-kube_events_manager.KubeEventCh:
 			rlog.Infof("EVENT Kube event '%s'", kubeEvent.ConfigId)
 
+			if releaseRes, handled := ReleaseWatcher.HandleEvent(kubeEvent, ModuleManager); handled {
+				for _, task := range releaseRes.Tasks {
+					TasksQueue.Add(task)
+					rlog.Infof("QUEUE add %s %s: release resources changed", task.GetType(), task.GetName())
+				}
+				break
+			}
+
 			res, err := KubeEventsHooks.HandleEvent(kubeEvent)
 			if err != nil {
 				rlog.Errorf("MAIN_LOOP error handling kube event '%s': %s", kubeEvent.ConfigId, err)
@@ -334,7 +649,7 @@ func ManagersEventsHandler() {
 			}
 
 			for _, task := range res.Tasks {
-				TasksQueue.Add(task)
+				AddHookTask(task)
 				rlog.Infof("QUEUE add %s@%s %s", task.GetType(), task.GetBinding(), task.GetName())
 			}
 		case <-ManagersEventsHandlerStopCh:
@@ -350,22 +665,51 @@ func runDiscoverModulesState(t task.Task) error {
 		return err
 	}
 
-	for _, moduleName := range modulesState.EnabledModules {
+	plan := NewConvergePlan(t.GetCause(), modulesState)
+	return ConvergePlanner.Propose(plan, func() error {
+		return queueModulesState(t, modulesState)
+	})
+}
+
+// queueModulesState adds tasks for discovered modules state to the main queue
+// and updates hooks of enabled and disabled modules.
+func queueModulesState(t task.Task, modulesState *module_manager.ModulesState) error {
+	var err error
+
+	// barriers wait for runs of modules queued in this cycle
+	ModuleManager.StartConvergeCycle(modulesState.ModulesToRun)
+
+	var prevStage module_manager.ModuleStage
+	for i, moduleName := range modulesState.ModulesToRun {
+		stage := module_manager.StageCluster
+		if module, err := ModuleManager.GetModule(moduleName); err == nil {
+			stage = module.Stage()
+		}
+		if i > 0 && stage != prevStage {
+			TasksQueue.Add(task.NewTask(task.StageBarrier, string(stage)).WithCause(t.GetCause()))
+			rlog.Infof("QUEUE add StageBarrier %s", stage)
+		}
+		prevStage = stage
+
 		newTask := task.NewTask(task.ModuleRun, moduleName).
-			WithOnStartupHooks(t.GetOnStartupHooks())
+			WithOnStartupHooks(t.GetOnStartupHooks()).
+			WithCause(t.GetCause()).
+			WithUrgent(t.GetUrgent())
 
 		TasksQueue.Add(newTask)
 		rlog.Infof("QUEUE add ModuleRun %s", moduleName)
 	}
 
 	for _, moduleName := range modulesState.ModulesToDisable {
-		newTask := task.NewTask(task.ModuleDelete, moduleName)
+		newTask := task.NewTask(task.ModuleDelete, moduleName).
+			WithCause(t.GetCause())
 		TasksQueue.Add(newTask)
 		rlog.Infof("QUEUE add ModuleDelete %s", moduleName)
 	}
 
 	for _, moduleName := range modulesState.ReleasedUnknownModules {
-		newTask := task.NewTask(task.ModulePurge, moduleName)
+		newTask := task.NewTask(task.ModulePurge, moduleName).
+			WithCause(t.GetCause())
 		TasksQueue.Add(newTask)
 		rlog.Infof("QUEUE add ModulePurge %s", moduleName)
 	}
@@ -410,124 +754,249 @@ func runDiscoverModulesState(t task.Task) error {
 // Т.е. кто взял в обработку задание, тот его и удалил из очереди. Сейчас Peek-нуть может одна го-рутина, другая добавит,
 // первая Pop-нет задание — новое задание пропало, второй раз будет обработано одно и тоже.
 func TasksRunner() {
+	RunTasksQueue(MainQueueName, TasksQueue)
+}
+
+// RunTasksQueue runs tasks from the queue one by one. Main queue and named queues
+// are run concurrently, order is guaranteed only inside a queue.
+func RunTasksQueue(queueName string, queue *task.TasksQueue) {
+	atomic.AddInt32(&runningTasksQueues, 1)
+	defer atomic.AddInt32(&runningTasksQueues, -1)
+
 	for {
-		if TasksQueue.IsEmpty() {
+		if queue.IsEmpty() {
 			time.Sleep(QueueIsEmptyDelay)
 		}
 		for {
-			t, _ := TasksQueue.Peek()
+			if IsShuttingDown() {
+				rlog.Infof("TASK_RUN queue '%s' is stopped for shutdown", queueName)
+				return
+			}
+			t, _ := queue.Peek()
 			if t == nil {
+				// main queue is empty — converge cycle is done
+				if queueName == MainQueueName {
+					ConvergeCycles.FinishCycle()
+					if err := AddonsReports.PublishIfChanged(); err != nil {
+						rlog.Errorf("TASK_RUN cannot publish addons report: %s", err)
+					}
+					CheckConvergeOnceDone()
+				}
 				break
 			}
 
+			// apiserver is not available, failed task is retried when it is back
+			if t.GetType() != task.Stop && ApiserverBreaker.IsOpen() {
+				time.Sleep(QueueIsEmptyDelay)
+				continue
+			}
+
+			// task is cancelled before start
+			if t.IsCancelled() {
+				rlog.Infof("TASK_RUN [%s] %s '%s' is cancelled", t.GetCorrelationId(), t.GetType(), t.GetName())
+				queue.Pop()
+				recordCancelledTask(t)
+				continue
+			}
+
 			switch t.GetType() {
 			case task.DiscoverModulesState:
-				rlog.Infof("TASK_RUN DiscoverModulesState")
+				rlog.Infof("TASK_RUN [%s] DiscoverModulesState", t.GetCorrelationId())
+				ConvergeCycles.StartCycle(t.GetCause())
 				err := runDiscoverModulesState(t)
 				if err != nil {
+					if popIfCancelled(queue, t) {
+						break
+					}
+					if ApiserverBreaker.Trip(err) {
+						break
+					}
 					MetricsStorage.SendCounterMetric("antiopa_modules_discover_errors", 1.0, map[string]string{})
 					t.IncrementFailureCount()
-					rlog.Errorf("TASK_RUN %s failed. Will retry after delay. Failed count is %d. Error: %s", t.GetType(), t.GetFailureCount(), err)
-					TasksQueue.Push(task.NewTaskDelay(FailedModuleDelay))
+					rlog.Errorf("TASK_RUN [%s] %s failed. Will retry after delay. Failed count is %d. Error: %s", t.GetCorrelationId(), t.GetType(), t.GetFailureCount(), err)
+					CheckConvergeOnceFailure(t, err)
+					queue.Push(task.NewTaskDelay(FailedModuleDelay))
 					rlog.Infof("QUEUE push FailedModuleDelay")
 					break
 				}
 
-				TasksQueue.Pop()
+				queue.Pop()
+				ConvergeOnceDiscovered()
 
 			case task.ModuleRun:
-				rlog.Infof("TASK_RUN ModuleRun %s", t.GetName())
-				err := ModuleManager.RunModule(t.GetName(), t.GetOnStartupHooks())
-				if err != nil {
-					MetricsStorage.SendCounterMetric("antiopa_module_run_errors", 1.0, map[string]string{"module": t.GetName()})
-					t.IncrementFailureCount()
-					rlog.Errorf("TASK_RUN %s '%s' failed. Will retry after delay. Failed count is %d. Error: %s", t.GetType(), t.GetName(), t.GetFailureCount(), err)
-					TasksQueue.Push(task.NewTaskDelay(FailedModuleDelay))
+				if PausedRuns.Pause(t) {
+					// other tasks are not blocked, the run is queued again when converge is enabled
+					rlog.Infof("TASK_RUN [%s] ModuleRun %s: converge is disabled, run is paused", t.GetCorrelationId(), t.GetName())
+					queue.Pop()
+					break
+				}
+				if module_manager.ModulesParallelism > 1 {
+					runModuleTasksInParallel(queue)
+					break
+				}
+				if delay, _ := runModuleTask(queue, t); delay > 0 {
+					queue.Push(task.NewTaskDelay(delay))
+					rlog.Infof("QUEUE push FailedModuleDelay %s", delay)
+				}
+			case task.StageBarrier:
+				pending := ModuleManager.PendingModulesBeforeStage(module_manager.ModuleStage(t.GetName()))
+				if len(pending) > 0 {
+					rlog.Infof("TASK_RUN [%s] StageBarrier %s: wait for modules of previous stages: %v", t.GetCorrelationId(), t.GetName(), pending)
+					queue.Push(task.NewTaskDelay(FailedModuleDelay))
 					rlog.Infof("QUEUE push FailedModuleDelay")
-				} else {
-					TasksQueue.Pop()
+					break
 				}
+				rlog.Infof("TASK_RUN [%s] StageBarrier %s: previous stages are converged", t.GetCorrelationId(), t.GetName())
+				queue.Pop()
 			case task.ModuleDelete:
-				rlog.Infof("TASK_RUN ModuleDelete %s", t.GetName())
-				err := ModuleManager.DeleteModule(t.GetName())
+				if err := ModuleManager.CheckModuleDisable(t.GetName()); err != nil {
+					// release is kept, deletion is queued again by the next discovery after dependents are disabled
+					rlog.Errorf("TASK_RUN [%s] ModuleDelete %s: release is not deleted: %s", t.GetCorrelationId(), t.GetName(), err)
+					moduleQueueLog(t.GetName()).Recordf("ERROR", "task %s: release is not deleted: %s", t.GetCorrelationId(), err)
+					MetricsStorage.SendCounterMetric("antiopa_module_delete_refused", 1.0, map[string]string{"module": t.GetName()})
+					queue.Pop()
+					break
+				}
+				deleteTask := t
+				if !approval.Request(approval.DeleteReleaseKey(t.GetName()), fmt.Sprintf("delete release of disabled module '%s'", t.GetName()), func() {
+					TasksQueue.Add(task.NewTask(task.ModuleDelete, deleteTask.GetName()).WithCause(deleteTask.GetCause()))
+					rlog.Infof("QUEUE add ModuleDelete %s: deletion is approved", deleteTask.GetName())
+				}) {
+					rlog.Infof("TASK_RUN [%s] ModuleDelete %s: waits for approval", t.GetCorrelationId(), t.GetName())
+					queue.Pop()
+					break
+				}
+				rlog.Infof("TASK_RUN [%s] ModuleDelete %s", t.GetCorrelationId(), t.GetName())
+				startedAt := time.Now()
+				err := ModuleManager.DeleteModule(t.GetName(), t.GetCorrelationId())
+				RecordModuleTask(t, startedAt, nil, nil, err)
 				if err != nil {
+					if popIfCancelled(queue, t) {
+						break
+					}
+					if ApiserverBreaker.Trip(err) {
+						break
+					}
 					MetricsStorage.SendCounterMetric("antiopa_module_delete_errors", 1.0, map[string]string{"module": t.GetName()})
 					t.IncrementFailureCount()
-					rlog.Errorf("%s '%s' failed. Will retry after delay. Failed count is %d. Error: %s", t.GetType(), t.GetName(), t.GetFailureCount(), err)
-					TasksQueue.Push(task.NewTaskDelay(FailedModuleDelay))
+					rlog.Errorf("TASK_RUN [%s] %s '%s' failed. Will retry after delay. Failed count is %d. Error: %s", t.GetCorrelationId(), t.GetType(), t.GetName(), t.GetFailureCount(), err)
+					CheckConvergeOnceFailure(t, err)
+					queue.Push(task.NewTaskDelay(failedTaskDelay(FailedModuleDelay, t.GetFailureCount())))
 					rlog.Infof("QUEUE push FailedModuleDelay")
 				} else {
-					TasksQueue.Pop()
+					queue.Pop()
+					AddonsReports.Delete(t.GetName())
+					ModulesHealth.Forget(t.GetName())
+					err = ReleaseWatcher.UnwatchModule(t.GetName(), KubeEventsManager)
+					if err != nil {
+						rlog.Errorf("TASK_RUN [%s] %s '%s': cannot stop watching release resources: %s", t.GetCorrelationId(), t.GetType(), t.GetName(), err)
+					}
 				}
 			case task.ModuleHookRun:
-				rlog.Infof("TASK_RUN ModuleHookRun@%s %s", t.GetBinding(), t.GetName())
-				err := ModuleManager.RunModuleHook(t.GetName(), t.GetBinding(), t.GetBindingContext())
+				if pending := ModuleManager.HookPendingModules(t.GetName()); len(pending) > 0 {
+					rlog.Infof("TASK_RUN [%s] ModuleHookRun@%s %s: held until modules are converged: %v", t.GetCorrelationId(), t.GetBinding(), t.GetName(), pending)
+					HeldHookRuns.Hold(t, pending)
+					queue.Pop()
+					break
+				}
+				rlog.Infof("TASK_RUN [%s] ModuleHookRun@%s %s", t.GetCorrelationId(), t.GetBinding(), t.GetName())
+				startedAt := time.Now()
+				err := ModuleManager.RunModuleHook(t.GetName(), t.GetBinding(), t.GetBindingContext(), t.GetCorrelationId())
+				SendHookRunMetrics(t, startedAt, err)
+				ScheduleRuns.Record(t, startedAt, err)
 				if err != nil {
-					moduleHook, _ := ModuleManager.GetModuleHook(t.GetName())
-					hookLabel := path.Base(moduleHook.Path)
-					moduleLabel := moduleHook.Module.Name
+					if popIfCancelled(queue, t) {
+						break
+					}
+					if ApiserverBreaker.Trip(err) {
+						break
+					}
+					moduleLabel, hookLabel := hookErrorLabels(err, t.GetName())
 
 					if t.GetAllowFailure() {
 						MetricsStorage.SendCounterMetric("antiopa_module_hook_allowed_errors", 1.0, map[string]string{"module": moduleLabel, "hook": hookLabel})
-						TasksQueue.Pop()
+						queue.Pop()
 					} else {
 						MetricsStorage.SendCounterMetric("antiopa_module_hook_errors", 1.0, map[string]string{"module": moduleLabel, "hook": hookLabel})
 						t.IncrementFailureCount()
-						rlog.Errorf("%s '%s' failed. Will retry after delay. Failed count is %d. Error: %s", t.GetType(), t.GetName(), t.GetFailureCount(), err)
-						TasksQueue.Push(task.NewTaskDelay(FailedModuleDelay))
+						rlog.Errorf("TASK_RUN [%s] %s '%s' failed. Will retry after delay. Failed count is %d. Error: %s", t.GetCorrelationId(), t.GetType(), t.GetName(), t.GetFailureCount(), err)
+						CheckConvergeOnceFailure(t, err)
+						queue.Push(task.NewTaskDelay(failedTaskDelay(FailedModuleDelay, t.GetFailureCount())))
 						rlog.Infof("QUEUE push FailedModuleDelay")
 					}
 				} else {
-					TasksQueue.Pop()
+					queue.Pop()
 				}
 			case task.GlobalHookRun:
-				rlog.Infof("TASK_RUN GlobalHookRun@%s %s", t.GetBinding(), t.GetName())
-				err := ModuleManager.RunGlobalHook(t.GetName(), t.GetBinding(), t.GetBindingContext())
+				if pending := ModuleManager.HookPendingModules(t.GetName()); len(pending) > 0 {
+					rlog.Infof("TASK_RUN [%s] GlobalHookRun@%s %s: held until modules are converged: %v", t.GetCorrelationId(), t.GetBinding(), t.GetName(), pending)
+					HeldHookRuns.Hold(t, pending)
+					queue.Pop()
+					break
+				}
+				rlog.Infof("TASK_RUN [%s] GlobalHookRun@%s %s", t.GetCorrelationId(), t.GetBinding(), t.GetName())
+				startedAt := time.Now()
+				err := ModuleManager.RunGlobalHook(t.GetName(), t.GetBinding(), t.GetBindingContext(), t.GetCorrelationId())
+				SendHookRunMetrics(t, startedAt, err)
+				ScheduleRuns.Record(t, startedAt, err)
 				if err != nil {
-					globalHook, _ := ModuleManager.GetGlobalHook(t.GetName())
-					hookLabel := path.Base(globalHook.Path)
+					if popIfCancelled(queue, t) {
+						break
+					}
+					if ApiserverBreaker.Trip(err) {
+						break
+					}
+					_, hookLabel := hookErrorLabels(err, t.GetName())
 
 					if t.GetAllowFailure() {
 						MetricsStorage.SendCounterMetric("antiopa_global_hook_allowed_errors", 1.0, map[string]string{"hook": hookLabel})
-						TasksQueue.Pop()
+						queue.Pop()
 					} else {
 						MetricsStorage.SendCounterMetric("antiopa_global_hook_errors", 1.0, map[string]string{"hook": hookLabel})
 						t.IncrementFailureCount()
-						rlog.Errorf("TASK_RUN %s '%s' on '%s' failed. Will retry after delay. Failed count is %d. Error: %s", t.GetType(), t.GetName(), t.GetBinding(), t.GetFailureCount(), err)
-						TasksQueue.Push(task.NewTaskDelay(FailedHookDelay))
+						rlog.Errorf("TASK_RUN [%s] %s '%s' on '%s' failed. Will retry after delay. Failed count is %d. Error: %s", t.GetCorrelationId(), t.GetType(), t.GetName(), t.GetBinding(), t.GetFailureCount(), err)
+						CheckConvergeOnceFailure(t, err)
+						queue.Push(task.NewTaskDelay(failedTaskDelay(FailedHookDelay, t.GetFailureCount())))
 					}
 				} else {
-					TasksQueue.Pop()
+					queue.Pop()
 				}
 			case task.ModulePurge:
-				rlog.Infof("TASK_RUN ModulePurge %s", t.GetName())
+				rlog.Infof("TASK_RUN [%s] ModulePurge %s", t.GetCorrelationId(), t.GetName())
 				// Module for purge is unknown so log deletion error is enough
-				err := HelmClient.DeleteRelease(t.GetName())
+				startedAt := time.Now()
+				err := PurgeRelease(t.GetName())
+				RecordModuleTask(t, startedAt, nil, nil, err)
+				if err != nil {
+					rlog.Errorf("TASK_RUN [%s] %s helm delete '%s' failed. Error: %s", t.GetCorrelationId(), t.GetType(), t.GetName(), err)
+				}
+				AddonsReports.Delete(t.GetName())
+				err = ReleaseWatcher.UnwatchModule(t.GetName(), KubeEventsManager)
 				if err != nil {
-					rlog.Errorf("TASK_RUN %s helm delete '%s' failed. Error: %s", t.GetType(), t.GetName(), err)
+					rlog.Errorf("TASK_RUN [%s] %s '%s': cannot stop watching release resources: %s", t.GetCorrelationId(), t.GetType(), t.GetName(), err)
 				}
-				TasksQueue.Pop()
+				queue.Pop()
 			case task.ModuleManagerRetry:
-				rlog.Infof("TASK_RUN ModuleManagerRetry")
+				rlog.Infof("TASK_RUN [%s] ModuleManagerRetry", t.GetCorrelationId())
 				// TODO метрику нужно отсылать из module_manager. Cделать metric_storage глобальным!
 				MetricsStorage.SendCounterMetric("antiopa_modules_discover_errors", 1.0, map[string]string{})
 				ModuleManager.Retry()
-				TasksQueue.Pop()
+				queue.Pop()
 				// Add delay before retry module/hook task again
-				TasksQueue.Push(task.NewTaskDelay(FailedModuleDelay))
+				queue.Push(task.NewTaskDelay(FailedModuleDelay))
 				rlog.Infof("QUEUE push FailedModuleDelay")
 			case task.Delay:
-				rlog.Infof("TASK_RUN Delay for %s", t.GetDelay().String())
-				TasksQueue.Pop()
+				rlog.Infof("TASK_RUN [%s] Delay for %s", t.GetCorrelationId(), t.GetDelay().String())
+				queue.Pop()
 				time.Sleep(t.GetDelay())
 			case task.Stop:
 				rlog.Infof("TASK_RUN Stop: Exiting TASK_RUN loop.")
-				TasksQueue.Pop()
+				queue.Pop()
 				return
 			}
 
 			// break if empty to prevent infinity loop
-			if TasksQueue.IsEmpty() {
+			if queue.IsEmpty() {
 				rlog.Debug("Task queue is empty. Will sleep now.")
 				break
 			}
@@ -706,7 +1175,7 @@ func CreateOnStartupTasks() {
 	return
 }
 
-func CreateReloadAllTasks(onStartup bool) {
+func CreateReloadAllTasks(onStartup bool, cause string) {
 	rlog.Infof("QUEUE add all GlobalHookRun@BeforeAll, add DiscoverModulesState")
 
 	// Queue beforeAll global hooks
@@ -721,10 +1190,13 @@ func CreateReloadAllTasks(onStartup bool) {
 		rlog.Debugf("QUEUE GlobalHookRun@BeforeAll '%s'", module_manager.BeforeAll, hookName)
 	}
 
-	TasksQueue.Add(task.NewTask(task.DiscoverModulesState, "").WithOnStartupHooks(onStartup))
+	// modules are installed on startup regardless of maintenance windows
+	TasksQueue.Add(task.NewTask(task.DiscoverModulesState, "").WithOnStartupHooks(onStartup).WithCause(cause).WithUrgent(onStartup))
 }
 
 func RunAntiopaMetrics() {
+	MetricsStorage.SendGaugeMetric("antiopa_build_info", 1.0, version.Get().Labels())
+
 	// antiopa live ticks
 	go func() {
 		for {
@@ -733,16 +1205,96 @@ func RunAntiopaMetrics() {
 		}
 	}()
 
-	// TasksQueue length
+	// length of main and named queues
 	go func() {
 		for {
-			queueLen := float64(TasksQueue.Length())
-			MetricsStorage.SendGaugeMetric("antiopa_tasks_queue_length", queueLen, map[string]string{})
+			MetricsStorage.SendGaugeMetric("antiopa_approvals_pending", float64(len(approval.Pending())), map[string]string{})
+			for _, status := range HelmClients.ClustersStatus() {
+				ready := 0.0
+				if status.Ready {
+					ready = 1.0
+				}
+				MetricsStorage.SendGaugeMetric("antiopa_remote_cluster_ready", ready, map[string]string{"cluster": status.Name})
+			}
+			for queueName, queue := range AllTasksQueues() {
+				queueLen := float64(queue.Length())
+				MetricsStorage.SendGaugeMetric("antiopa_tasks_queue_length", queueLen, map[string]string{"queue": queueName})
+				MetricsStorage.SendGaugeMetric("antiopa_tasks_queue_saturation", QueueSaturation(queue), map[string]string{"queue": queueName})
+				saturated := 0.0
+				if IsQueueSaturated(queueName, queue) {
+					saturated = 1.0
+				}
+				MetricsStorage.SendGaugeMetric("antiopa_tasks_queue_coalescing", saturated, map[string]string{"queue": queueName})
+			}
 			time.Sleep(5 * time.Second)
 		}
 	}()
 }
 
+// PurgeRelease deletes the release of unknown module. Tiller of the module
+// is unknown, so release is searched in all tillers and remote clusters.
+func PurgeRelease(releaseName string) error {
+	for _, helmClient := range HelmClients.AllClients() {
+		exists, err := helmClient.IsReleaseExists(releaseName)
+		if err != nil {
+			return err
+		}
+		if exists {
+			err := helmClient.DeleteRelease(releaseName)
+			if helm.IsReleaseNotFound(err) {
+				return nil
+			}
+			return err
+		}
+	}
+	return nil
+}
+
+// hookErrorLabels returns module and hook labels for hook errors metrics.
+// Module of the hook is unknown if hook is not found, e.g. after modules discovery.
+func hookErrorLabels(err error, hookName string) (moduleLabel string, hookLabel string) {
+	switch e := err.(type) {
+	case *module_manager.ErrHookFailed:
+		return e.Module, path.Base(e.Hook)
+	case *module_manager.ErrValuesInvalid:
+		return e.Module, path.Base(e.Hook)
+	}
+	if moduleHook, getErr := ModuleManager.GetModuleHook(hookName); getErr == nil {
+		return moduleHook.Module.Name, path.Base(moduleHook.Path)
+	}
+	return "", path.Base(hookName)
+}
+
+// SendReleaseUpgradeMetrics sends revision and resources count of the upgraded module release
+func SendReleaseUpgradeMetrics(moduleName string, result *helm.ReleaseUpgradeResult) {
+	if result == nil {
+		return
+	}
+	rlog.Infof("TASK_RUN ModuleRun %s: %s", moduleName, result)
+
+	labels := map[string]string{"module": moduleName}
+	MetricsStorage.SendCounterMetric("antiopa_module_release_upgrades", 1.0, labels)
+	MetricsStorage.SendGaugeMetric("antiopa_module_release_revision", float64(result.Revision), labels)
+	MetricsStorage.SendGaugeMetric("antiopa_module_release_resources", float64(result.ResourcesCount()), labels)
+}
+
+// SendValuesStatsMetrics sends sizes of values layers, merge and schema durations of the module values
+func SendValuesStatsMetrics(moduleName string, stats *module_manager.ValuesStats) {
+	if stats == nil {
+		return
+	}
+
+	for layer, size := range stats.LayersSizes {
+		MetricsStorage.SendGaugeMetric("antiopa_module_values_layer_bytes", float64(size), map[string]string{"module": moduleName, "layer": layer})
+	}
+	labels := map[string]string{"module": moduleName}
+	MetricsStorage.SendGaugeMetric("antiopa_module_values_merge_seconds", stats.MergeDuration.Seconds(), labels)
+	MetricsStorage.SendGaugeMetric("antiopa_module_values_schema_seconds", stats.SchemaDuration.Seconds(), labels)
+}
+
+// ListenAddress of the HTTP server with /metrics, debug handlers and API
+var ListenAddress = ":9115"
+
 func InitHttpServer() {
 	http.HandleFunc("/", func(writer http.ResponseWriter, request *http.Request) {
 		writer.Write([]byte(`<html>
@@ -756,25 +1308,835 @@ func InitHttpServer() {
 	http.Handle("/metrics", promhttp.Handler())
 
 	http.HandleFunc("/queue", func(writer http.ResponseWriter, request *http.Request) {
-		io.Copy(writer, TasksQueue.DumpReader())
+		io.Copy(writer, DumpAllTasksQueues())
+	})
+
+	http.HandleFunc("/hooks", func(writer http.ResponseWriter, request *http.Request) {
+		if ModuleManager == nil {
+			http.Error(writer, "module manager is not initialized", http.StatusServiceUnavailable)
+			return
+		}
+		writer.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(writer).Encode(ModuleManager.DumpHookConfigs())
+	})
+
+	http.HandleFunc("/converge-history", func(writer http.ResponseWriter, request *http.Request) {
+		if ConvergeCycles == nil {
+			http.Error(writer, "converge history is not initialized", http.StatusServiceUnavailable)
+			return
+		}
+		writer.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(writer).Encode(ConvergeCycles.Dump())
+	})
+
+	http.HandleFunc("/addons-report", func(writer http.ResponseWriter, request *http.Request) {
+		if AddonsReports == nil {
+			http.Error(writer, "addons report is not initialized", http.StatusServiceUnavailable)
+			return
+		}
+		notes := AddonsReports.Dump()
+		if moduleName := request.URL.Query().Get("module"); moduleName != "" {
+			moduleNotes, has := AddonsReports.Get(moduleName)
+			if !has {
+				http.Error(writer, fmt.Sprintf("module '%s' has no notes", moduleName), http.StatusNotFound)
+				return
+			}
+			notes = []ModuleReleaseNotes{moduleNotes}
+		}
+		if request.URL.Query().Get("format") == "json" {
+			writer.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(writer).Encode(notes)
+			return
+		}
+		writer.Header().Set("Content-Type", "text/markdown; charset=utf-8")
+		io.WriteString(writer, addonsReportMarkdown(notes))
+	})
+
+	http.HandleFunc("/values/export", func(writer http.ResponseWriter, request *http.Request) {
+		if ModuleManager == nil {
+			http.Error(writer, "module manager is not initialized", http.StatusServiceUnavailable)
+			return
+		}
+		writer.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(writer).Encode(ModuleManager.ExportValues())
+	})
+
+	// values as hooks see them now: global values or values of the module from the module param
+	http.HandleFunc("/values/current", func(writer http.ResponseWriter, request *http.Request) {
+		if ModuleManager == nil {
+			http.Error(writer, "module manager is not initialized", http.StatusServiceUnavailable)
+			return
+		}
+		values, err := ModuleManager.CurrentValues(request.URL.Query().Get("module"))
+		if err != nil {
+			http.Error(writer, err.Error(), http.StatusNotFound)
+			return
+		}
+		writer.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(writer).Encode(values)
+	})
+
+	http.HandleFunc("/values/import", func(writer http.ResponseWriter, request *http.Request) {
+		if request.Method != http.MethodPost {
+			http.Error(writer, "POST is expected", http.StatusMethodNotAllowed)
+			return
+		}
+		if ModuleManager == nil {
+			http.Error(writer, "module manager is not initialized", http.StatusServiceUnavailable)
+			return
+		}
+
+		snapshot := &module_manager.ValuesSnapshot{}
+		if err := json.NewDecoder(request.Body).Decode(snapshot); err != nil {
+			http.Error(writer, fmt.Sprintf("bad values snapshot: %s", err), http.StatusBadRequest)
+			return
+		}
+		if err := ModuleManager.ImportValues(snapshot); err != nil {
+			http.Error(writer, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		rlog.Infof("MAIN values snapshot is imported")
+		writer.Write([]byte("values are imported, modules will be rerun\n"))
+	})
+
+	// body is data of the antiopa ConfigMap, enabled scripts are run with it without changes of the state
+	http.HandleFunc("/modules/enabled-simulation", func(writer http.ResponseWriter, request *http.Request) {
+		if request.Method != http.MethodPost {
+			http.Error(writer, "POST is expected", http.StatusMethodNotAllowed)
+			return
+		}
+		if ModuleManager == nil {
+			http.Error(writer, "module manager is not initialized", http.StatusServiceUnavailable)
+			return
+		}
+
+		configData := make(map[string]string)
+		if err := json.NewDecoder(request.Body).Decode(&configData); err != nil {
+			http.Error(writer, fmt.Sprintf("bad ConfigMap data: %s", err), http.StatusBadRequest)
+			return
+		}
+		simulation, err := ModuleManager.SimulateEnabledModules(configData)
+		if err != nil {
+			http.Error(writer, err.Error(), http.StatusBadRequest)
+			return
+		}
+		writer.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(writer).Encode(simulation)
+	})
+
+	http.HandleFunc("/deferred-runs", func(writer http.ResponseWriter, request *http.Request) {
+		if DeferredRuns == nil {
+			http.Error(writer, "deferred runs are not initialized", http.StatusServiceUnavailable)
+			return
+		}
+		writer.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(writer).Encode(DeferredRuns.Dump())
+	})
+
+	http.HandleFunc("/paused-runs", func(writer http.ResponseWriter, request *http.Request) {
+		if PausedRuns == nil {
+			http.Error(writer, "paused runs are not initialized", http.StatusServiceUnavailable)
+			return
+		}
+		writer.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(writer).Encode(PausedRuns.Dump())
 	})
 
+	http.HandleFunc("/helm-upgrade-waits", func(writer http.ResponseWriter, request *http.Request) {
+		if HelmUpgradeWaits == nil {
+			http.Error(writer, "helm upgrade waits are not initialized", http.StatusServiceUnavailable)
+			return
+		}
+		writer.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(writer).Encode(HelmUpgradeWaits.Dump())
+	})
+
+	http.HandleFunc("/held-hook-runs", func(writer http.ResponseWriter, request *http.Request) {
+		if HeldHookRuns == nil {
+			http.Error(writer, "held hook runs are not initialized", http.StatusServiceUnavailable)
+			return
+		}
+		writer.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(writer).Encode(HeldHookRuns.Dump())
+	})
+
+	if chart_repo.Enabled {
+		http.Handle(chart_repo.PathPrefix, chart_repo.NewRepository(func() []string {
+			dirs := make([]string, 0)
+			if ModuleManager == nil {
+				return dirs
+			}
+			for _, moduleName := range ModuleManager.GetModuleNamesInOrder() {
+				if module, err := ModuleManager.GetModule(moduleName); err == nil {
+					dirs = append(dirs, module.Path)
+				}
+			}
+			return dirs
+		}))
+	}
+
+	http.HandleFunc("/clusters", func(writer http.ResponseWriter, request *http.Request) {
+		if HelmClients == nil || ModuleManager == nil {
+			http.Error(writer, "helm is not initialized", http.StatusServiceUnavailable)
+			return
+		}
+		writer.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(writer).Encode(DumpRemoteClusters())
+	})
+
+	http.HandleFunc("/addons-health", func(writer http.ResponseWriter, request *http.Request) {
+		if AddonsHealth == nil || AddonsHealth.Condition() == nil {
+			http.Error(writer, "addons health is not checked yet", http.StatusServiceUnavailable)
+			return
+		}
+		writer.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(writer).Encode(AddonsHealth.Condition())
+	})
+
+	http.HandleFunc("/modules/health", func(writer http.ResponseWriter, request *http.Request) {
+		if ModulesHealth == nil {
+			http.Error(writer, "modules health is not initialized", http.StatusServiceUnavailable)
+			return
+		}
+		writer.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(writer).Encode(ModulesHealth.Dump())
+	})
+
+	// modules enabled by config but disabled at discovery with the reason: platforms or cluster size
+	http.HandleFunc("/modules/skipped", func(writer http.ResponseWriter, request *http.Request) {
+		if ModuleManager == nil {
+			http.Error(writer, "module manager is not initialized", http.StatusServiceUnavailable)
+			return
+		}
+		writer.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(writer).Encode(ModuleManager.SkippedModules())
+	})
+
+	http.HandleFunc("/modules/resources", func(writer http.ResponseWriter, request *http.Request) {
+		if ModuleManager == nil {
+			http.Error(writer, "module manager is not initialized", http.StatusServiceUnavailable)
+			return
+		}
+		res := make(map[string]*helm.ResourcesTotals)
+		for _, moduleName := range ModuleManager.GetModuleNamesInOrder() {
+			module, err := ModuleManager.GetModule(moduleName)
+			if err != nil {
+				continue
+			}
+			if totals := module.ResourcesTotals(); totals != nil {
+				res[moduleName] = totals
+			}
+		}
+		writer.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(writer).Encode(res)
+	})
+
+	// objects with deprecated API versions in releases of modules, checked against the apiserver version
+	http.HandleFunc("/modules/deprecated-apis", func(writer http.ResponseWriter, request *http.Request) {
+		if ModuleManager == nil {
+			http.Error(writer, "module manager is not initialized", http.StatusServiceUnavailable)
+			return
+		}
+		res := make(map[string][]helm.DeprecatedApiUsage)
+		for _, moduleName := range ModuleManager.GetModuleNamesInOrder() {
+			module, err := ModuleManager.GetModule(moduleName)
+			if err != nil {
+				continue
+			}
+			if usages := module.DeprecatedApis(); len(usages) > 0 {
+				res[moduleName] = usages
+			}
+		}
+		writer.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(writer).Encode(res)
+	})
+
+	http.HandleFunc("/module/release-values", func(writer http.ResponseWriter, request *http.Request) {
+		if ModuleManager == nil {
+			http.Error(writer, "module manager is not initialized", http.StatusServiceUnavailable)
+			return
+		}
+		moduleName := request.URL.Query().Get("name")
+		if _, err := ModuleManager.GetModule(moduleName); err != nil {
+			http.Error(writer, err.Error(), http.StatusNotFound)
+			return
+		}
+		values, err := GetModuleReleaseValues(moduleName)
+		if helm.IsReleaseNotFound(err) {
+			http.Error(writer, err.Error(), http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			http.Error(writer, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		writer.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(writer).Encode(values)
+	})
+
+	http.HandleFunc("/module/logs", func(writer http.ResponseWriter, request *http.Request) {
+		if ModuleManager == nil {
+			http.Error(writer, "module manager is not initialized", http.StatusServiceUnavailable)
+			return
+		}
+		moduleName := request.URL.Query().Get("name")
+		if _, err := ModuleManager.GetModule(moduleName); err != nil {
+			http.Error(writer, err.Error(), http.StatusNotFound)
+			return
+		}
+		tail := 0
+		if tailParam := request.URL.Query().Get("tail"); tailParam != "" {
+			var err error
+			if tail, err = strconv.Atoi(tailParam); err != nil {
+				http.Error(writer, fmt.Sprintf("bad tail '%s': %s", tailParam, err), http.StatusBadRequest)
+				return
+			}
+		}
+		lines := module_manager.ModuleLogTail(moduleName, tail)
+		if request.URL.Query().Get("format") == "json" {
+			writer.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(writer).Encode(lines)
+			return
+		}
+		for _, line := range lines {
+			fmt.Fprintln(writer, line.String())
+		}
+	})
+
+	http.HandleFunc("/module/failure-artifacts", func(writer http.ResponseWriter, request *http.Request) {
+		if ModuleManager == nil {
+			http.Error(writer, "module manager is not initialized", http.StatusServiceUnavailable)
+			return
+		}
+		moduleName := request.URL.Query().Get("name")
+		module, err := ModuleManager.GetModule(moduleName)
+		if err != nil {
+			http.Error(writer, err.Error(), http.StatusNotFound)
+			return
+		}
+
+		id := request.URL.Query().Get("id")
+		if id == "" {
+			bundles, err := module.FailureArtifacts()
+			if err != nil {
+				http.Error(writer, err.Error(), http.StatusInternalServerError)
+				return
+			}
+			writer.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(writer).Encode(bundles)
+			return
+		}
+
+		var archive bytes.Buffer
+		err = module.WriteFailureArtifactsArchive(&archive, id)
+		if module_manager.IsFailureArtifactsNotFound(err) {
+			http.Error(writer, err.Error(), http.StatusNotFound)
+			return
+		}
+		if err != nil {
+			http.Error(writer, err.Error(), http.StatusBadRequest)
+			return
+		}
+		writer.Header().Set("Content-Type", "application/gzip")
+		writer.Header().Set("Content-Disposition", fmt.Sprintf("attachment; filename=%s-%s.tar.gz", module.SafeName(), id))
+		writer.Write(archive.Bytes())
+	})
+
+	http.HandleFunc("/feature-gates", func(writer http.ResponseWriter, request *http.Request) {
+		if ModuleManager == nil {
+			http.Error(writer, "module manager is not initialized", http.StatusServiceUnavailable)
+			return
+		}
+		writer.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(writer).Encode(ModuleManager.FeatureGates())
+	})
+
+	http.HandleFunc("/schedules", func(writer http.ResponseWriter, request *http.Request) {
+		next := 1
+		if nextParam := request.URL.Query().Get("next"); nextParam != "" {
+			var err error
+			if next, err = strconv.Atoi(nextParam); err != nil || next < 1 || next > MaxScheduleNextRuns {
+				http.Error(writer, fmt.Sprintf("bad next '%s': expected number from 1 to %d", nextParam, MaxScheduleNextRuns), http.StatusBadRequest)
+				return
+			}
+		}
+		writer.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(writer).Encode(DumpEffectiveSchedules(ScheduledHooks, next))
+	})
+
+	http.HandleFunc("/converge-plan", func(writer http.ResponseWriter, request *http.Request) {
+		if ConvergePlanner == nil {
+			http.Error(writer, "converge plans are not initialized", http.StatusServiceUnavailable)
+			return
+		}
+		writer.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(writer).Encode(ConvergePlanner.Last())
+	})
+
+	http.HandleFunc("/converge-plan/approve", func(writer http.ResponseWriter, request *http.Request) {
+		if request.Method != http.MethodPost {
+			http.Error(writer, "POST is expected", http.StatusMethodNotAllowed)
+			return
+		}
+		if ConvergePlanner == nil {
+			http.Error(writer, "converge plans are not initialized", http.StatusServiceUnavailable)
+			return
+		}
+
+		id, err := strconv.Atoi(request.URL.Query().Get("id"))
+		if err != nil {
+			http.Error(writer, fmt.Sprintf("bad plan id: %s", err), http.StatusBadRequest)
+			return
+		}
+		if err := ConvergePlanner.Approve(id); err != nil {
+			http.Error(writer, err.Error(), http.StatusConflict)
+			return
+		}
+		writer.Write([]byte(fmt.Sprintf("converge plan #%d is approved, tasks are queued\n", id)))
+	})
+
+	http.HandleFunc("/approvals", func(writer http.ResponseWriter, request *http.Request) {
+		writer.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(writer).Encode(approval.Pending())
+	})
+
+	http.HandleFunc("/approvals/approve", func(writer http.ResponseWriter, request *http.Request) {
+		if request.Method != http.MethodPost {
+			http.Error(writer, "POST is expected", http.StatusMethodNotAllowed)
+			return
+		}
+
+		key := request.URL.Query().Get("operation")
+		if key == "" {
+			http.Error(writer, "operation is required", http.StatusBadRequest)
+			return
+		}
+		approval.Approve(key)
+		writer.Write([]byte(fmt.Sprintf("operation '%s' is approved\n", key)))
+	})
+
+	// Failures for validation of alerts and retries, endpoints exist only with -failure-injection
+	if failure_injection.Enabled {
+		http.HandleFunc("/debug/failures", func(writer http.ResponseWriter, request *http.Request) {
+			writer.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(writer).Encode(failure_injection.List())
+		})
+
+		http.HandleFunc("/debug/failures/inject", func(writer http.ResponseWriter, request *http.Request) {
+			if request.Method != http.MethodPost {
+				http.Error(writer, "POST is expected", http.StatusMethodNotAllowed)
+				return
+			}
+
+			query := request.URL.Query()
+			count := 1
+			if countParam := query.Get("count"); countParam != "" {
+				var err error
+				if count, err = strconv.Atoi(countParam); err != nil {
+					http.Error(writer, fmt.Sprintf("bad count '%s'", countParam), http.StatusBadRequest)
+					return
+				}
+			}
+			injection, err := failure_injection.Inject(failure_injection.Kind(query.Get("kind")), query.Get("target"), count)
+			if err != nil {
+				http.Error(writer, err.Error(), http.StatusBadRequest)
+				return
+			}
+			writer.Header().Set("Content-Type", "application/json")
+			json.NewEncoder(writer).Encode(injection)
+		})
+
+		http.HandleFunc("/debug/failures/clear", func(writer http.ResponseWriter, request *http.Request) {
+			if request.Method != http.MethodPost {
+				http.Error(writer, "POST is expected", http.StatusMethodNotAllowed)
+				return
+			}
+			failure_injection.Clear()
+			writer.Write([]byte("pending failures are cleared\n"))
+		})
+	}
+
+	// Manual run of the module ignores maintenance windows
+	http.HandleFunc("/module/run", func(writer http.ResponseWriter, request *http.Request) {
+		if request.Method != http.MethodPost {
+			http.Error(writer, "POST is expected", http.StatusMethodNotAllowed)
+			return
+		}
+		if ModuleManager == nil || TasksQueue == nil {
+			http.Error(writer, "module manager is not initialized", http.StatusServiceUnavailable)
+			return
+		}
+
+		moduleName := request.URL.Query().Get("name")
+		if _, err := ModuleManager.GetModule(moduleName); err != nil {
+			http.Error(writer, err.Error(), http.StatusNotFound)
+			return
+		}
+
+		newTask := task.NewTask(task.ModuleRun, moduleName).
+			WithCause("manual run").
+			WithUrgent(true)
+		TasksQueue.Add(newTask)
+		rlog.Infof("QUEUE add ModuleRun %s: manual run", moduleName)
+		writer.Write([]byte(fmt.Sprintf("module '%s' run is queued\n", moduleName)))
+	})
+
+	// Manual run of the global hook with live values, e.g. to rediscover cloud settings
+	// after credentials are changed. Modules are converged after beforeAll and onStartup runs.
+	http.HandleFunc("/global-hook/run", func(writer http.ResponseWriter, request *http.Request) {
+		if request.Method != http.MethodPost {
+			http.Error(writer, "POST is expected", http.StatusMethodNotAllowed)
+			return
+		}
+		if ModuleManager == nil || TasksQueue == nil {
+			http.Error(writer, "module manager is not initialized", http.StatusServiceUnavailable)
+			return
+		}
+
+		hookName := request.URL.Query().Get("name")
+		hook, err := ModuleManager.GetGlobalHook(hookName)
+		if err != nil && !strings.HasPrefix(hookName, "global-hooks/") {
+			hookName = path.Join("global-hooks", hookName)
+			hook, err = ModuleManager.GetGlobalHook(hookName)
+		}
+		if err != nil {
+			http.Error(writer, err.Error(), http.StatusNotFound)
+			return
+		}
+
+		binding, err := globalHookBinding(hook, request.URL.Query().Get("binding"))
+		if err != nil {
+			http.Error(writer, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		newTask := task.NewTask(task.GlobalHookRun, hookName).
+			WithBinding(binding).
+			AppendBindingContext(module_manager.BindingContext{Binding: module_manager.ContextBindingType[binding]}).
+			WithCause("manual global hook run")
+		TasksQueue.Add(newTask)
+		rlog.Infof("QUEUE add GlobalHookRun@%s '%s': manual run", binding, hookName)
+		if binding == module_manager.BeforeAll || binding == module_manager.OnStartup {
+			TasksQueue.Add(task.NewTask(task.DiscoverModulesState, "").WithCause("manual global hook run"))
+			rlog.Infof("QUEUE add DiscoverModulesState: manual global hook run")
+		}
+		writer.Write([]byte(fmt.Sprintf("global hook '%s' run with binding '%s' is queued: task #%s\n", hookName, module_manager.ContextBindingType[binding], newTask.GetId())))
+	})
+
+	// Adoption adds existing objects into the module release, module is run with forced helm upgrade after it
+	http.HandleFunc("/module/adopt", func(writer http.ResponseWriter, request *http.Request) {
+		if request.Method != http.MethodPost {
+			http.Error(writer, "POST is expected", http.StatusMethodNotAllowed)
+			return
+		}
+		if ModuleManager == nil || TasksQueue == nil {
+			http.Error(writer, "module manager is not initialized", http.StatusServiceUnavailable)
+			return
+		}
+
+		moduleName := request.URL.Query().Get("name")
+		if _, err := ModuleManager.GetModule(moduleName); err != nil {
+			http.Error(writer, err.Error(), http.StatusNotFound)
+			return
+		}
+		dryRun := request.URL.Query().Get("dryRun") == "true"
+
+		results, err := ModuleManager.AdoptModuleResources(moduleName, dryRun)
+		if err != nil {
+			http.Error(writer, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if !dryRun {
+			rlog.Infof("MAIN module '%s': objects are adopted into release", moduleName)
+			if err := ModuleManager.ForceModuleHelmUpgrade(moduleName); err != nil {
+				http.Error(writer, err.Error(), http.StatusInternalServerError)
+				return
+			}
+			TasksQueue.Add(task.NewTask(task.ModuleRun, moduleName).
+				WithCause("release adoption").
+				WithUrgent(true))
+			rlog.Infof("QUEUE add ModuleRun %s: release adoption", moduleName)
+		}
+		writer.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(writer).Encode(results)
+	})
+
+	// Release namespace migration moves the module release into the tiller namespace from module.yaml,
+	// module is run with forced helm upgrade after it
+	http.HandleFunc("/module/migrate-namespace", func(writer http.ResponseWriter, request *http.Request) {
+		if request.Method != http.MethodPost {
+			http.Error(writer, "POST is expected", http.StatusMethodNotAllowed)
+			return
+		}
+		if ModuleManager == nil || TasksQueue == nil {
+			http.Error(writer, "module manager is not initialized", http.StatusServiceUnavailable)
+			return
+		}
+
+		moduleName := request.URL.Query().Get("name")
+		if _, err := ModuleManager.GetModule(moduleName); err != nil {
+			http.Error(writer, err.Error(), http.StatusNotFound)
+			return
+		}
+		fromNamespace := request.URL.Query().Get("from")
+		if fromNamespace == "" {
+			http.Error(writer, "namespace of the current release is expected in 'from'", http.StatusBadRequest)
+			return
+		}
+		dryRun := request.URL.Query().Get("dryRun") == "true"
+
+		migration, err := ModuleManager.MigrateReleaseNamespace(moduleName, fromNamespace, dryRun)
+		if err != nil {
+			http.Error(writer, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		if !dryRun {
+			rlog.Infof("MAIN module '%s': release is moved from namespace '%s' to '%s'", moduleName, migration.From, migration.To)
+			TasksQueue.Add(task.NewTask(task.ModuleRun, moduleName).
+				WithCause("release namespace migration").
+				WithUrgent(true))
+			rlog.Infof("QUEUE add ModuleRun %s: release namespace migration", moduleName)
+		}
+		writer.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(writer).Encode(migration)
+	})
+
+	// Diff of releases of modules with current values, enabled modules are compared if no name is passed
+	http.HandleFunc("/modules/diff", func(writer http.ResponseWriter, request *http.Request) {
+		if ModuleManager == nil {
+			http.Error(writer, "module manager is not initialized", http.StatusServiceUnavailable)
+			return
+		}
+
+		moduleNames := request.URL.Query()["name"]
+		if len(moduleNames) == 0 {
+			moduleNames = ModuleManager.GetModuleNamesInOrder()
+		}
+		for _, moduleName := range moduleNames {
+			if _, err := ModuleManager.GetModule(moduleName); err != nil {
+				http.Error(writer, err.Error(), http.StatusNotFound)
+				return
+			}
+		}
+
+		diffs := make([]*module_manager.ModuleDiff, 0, len(moduleNames))
+		for _, moduleName := range moduleNames {
+			diff, err := ModuleManager.DiffModule(moduleName)
+			if err != nil {
+				http.Error(writer, fmt.Sprintf("module '%s': %s", moduleName, err), http.StatusInternalServerError)
+				return
+			}
+			diffs = append(diffs, diff)
+		}
+		writer.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(writer).Encode(diffs)
+	})
+
+	// Scoped converge runs enabled modules with the tag from module.yaml and/or release namespace
+	http.HandleFunc("/modules/run", func(writer http.ResponseWriter, request *http.Request) {
+		if request.Method != http.MethodPost {
+			http.Error(writer, "POST is expected", http.StatusMethodNotAllowed)
+			return
+		}
+		if ModuleManager == nil || TasksQueue == nil {
+			http.Error(writer, "module manager is not initialized", http.StatusServiceUnavailable)
+			return
+		}
+
+		tag := request.URL.Query().Get("tag")
+		namespace := request.URL.Query().Get("namespace")
+		if tag == "" && namespace == "" {
+			http.Error(writer, "tag or namespace is required", http.StatusBadRequest)
+			return
+		}
+
+		modulesNames, err := QueueScopedConverge(tag, namespace)
+		if err != nil {
+			http.Error(writer, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		writer.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(writer).Encode(modulesNames)
+	})
+
+	http.HandleFunc("/task/cancel", func(writer http.ResponseWriter, request *http.Request) {
+		if request.Method != http.MethodPost {
+			http.Error(writer, "POST is expected", http.StatusMethodNotAllowed)
+			return
+		}
+		if TasksQueue == nil {
+			http.Error(writer, "tasks queue is not initialized", http.StatusServiceUnavailable)
+			return
+		}
+
+		t, err := CancelTask(request.URL.Query().Get("id"))
+		if err != nil {
+			http.Error(writer, err.Error(), http.StatusNotFound)
+			return
+		}
+		writer.Write([]byte(fmt.Sprintf("task #%s %s '%s' is cancelled\n", t.GetId(), t.GetType(), t.GetName())))
+	})
+
+	// consolidated status for dashboards and status pages, format is stable within the version
+	http.HandleFunc("/dashboard/"+DashboardStatusVersion, func(writer http.ResponseWriter, request *http.Request) {
+		writer.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(writer).Encode(DashboardStatusNow())
+	})
+
+	http.HandleFunc("/version", func(writer http.ResponseWriter, request *http.Request) {
+		writer.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(writer).Encode(version.Get())
+	})
+
+	handler := http.Handler(http.DefaultServeMux)
+	if ApiAuthEnabled {
+		rlog.Info("API requests from non-loopback addresses require bearer token")
+		handler = NewApiAuthenticator(splitSubjects(ApiReadSubjects), splitSubjects(ApiTriggerSubjects)).Handler(handler)
+	} else {
+		rlog.Info("API auth is disabled: endpoints that change state are available only from localhost")
+		handler = LoopbackTriggerHandler(handler)
+	}
+
 	go func() {
-		rlog.Info("Listening on :9115")
-		if err := http.ListenAndServe(":9115", nil); err != nil {
+		rlog.Infof("Listening on %s", ListenAddress)
+		if err := http.ListenAndServe(ListenAddress, handler); err != nil {
 			rlog.Error("Error starting HTTP server: %s", err)
 		}
 	}()
 }
 
+// subcommands of antiopa binary, antiopa is started without a subcommand
+var subcommands = map[string]func(args []string) error{
+	"version": func(_ []string) error {
+		fmt.Println(version.Get().String())
+		return nil
+	},
+	"values":           RunValuesCommand,
+	"migrate-releases": RunMigrateReleasesCommand,
+	"compact-releases": RunCompactReleasesCommand,
+	"adopt-release":    RunAdoptReleaseCommand,
+	"diff":             RunDiffCommand,
+	"schedule":         RunScheduleCommand,
+	"task":             RunTaskCommand,
+	"module":           RunModuleCommand,
+	"global":           RunGlobalCommand,
+	"lint":             RunLintCommand,
+	"doctor":           RunDoctorCommand,
+}
+
 func main() {
-	// set flag.Parsed() for glog
-	flag.CommandLine.Parse([]string{})
+	flag.BoolVar(&DevMode, "dev", false, "run without a cluster: use fake kube client and record helm operations")
+	flag.StringVar(&DevFixturesDir, "dev-fixtures", "", "directory with yaml files to seed fake kube client in dev mode")
+	flag.StringVar(&ListenAddress, "listen-address", ListenAddress, "address of the HTTP server with /metrics, debug handlers and API")
+	flag.StringVar(&ApiAddress, "api-address", "http://127.0.0.1:9115", "address of running antiopa for CLI commands")
+	flag.StringVar(&ConfigWebhookServiceName, "config-webhook-service", "", "name of the Service of antiopa to register validating webhook for the antiopa ConfigMap, webhook is disabled if empty")
+	flag.StringVar(&ConfigWebhookListenAddress, "config-webhook-listen-address", ConfigWebhookListenAddress, "address of the TLS server of the config validating webhook")
+	flag.StringVar(&ConfigWebhookCertFile, "config-webhook-cert", "", "TLS certificate of the config validating webhook")
+	flag.StringVar(&ConfigWebhookKeyFile, "config-webhook-key", "", "TLS key of the config validating webhook")
+	flag.StringVar(&ConfigWebhookCAFile, "config-webhook-ca", "", "CA bundle of the config validating webhook certificate for apiserver")
+	flag.BoolVar(&ApiAuthEnabled, "api-auth", false, "require ServiceAccount bearer token for API requests from non-loopback addresses, without it endpoints that change state are available only from localhost")
+	flag.StringVar(&ApiReadSubjects, "api-read-subjects", "", "comma separated users and groups allowed to read API dumps, any authenticated subject if empty")
+	flag.StringVar(&ApiTriggerSubjects, "api-trigger-subjects", "", "comma separated users and groups allowed to run modules and import or export values with API")
+	flag.BoolVar(&EmbeddedTiller, "embedded-tiller", false, "run tiller process on localhost instead of tiller Deployment in the cluster")
+	flag.BoolVar(&ExternalTiller, "external-tiller", false, "use tiller provisioned by cluster admins: tiller is not installed or upgraded, only connection to tiller is checked at start")
+	if helmClient := os.Getenv("ANTIOPA_HELM_CLIENT"); helmClient != "" {
+		helm.ClientType = helmClient
+	}
+	flag.StringVar(&helm.ClientType, "helm-client", helm.ClientType, "implementation of helm operations: 'cli' runs helm binary, 'native' calls tiller over gRPC with helm Go packages, also set with ANTIOPA_HELM_CLIENT")
+	flag.BoolVar(&helm.BootstrapTillerRBAC, "bootstrap-tiller-rbac", false, "create or repair ServiceAccount, ClusterRole and ClusterRoleBinding for tiller before helm init")
+	flag.StringVar(&VaultAddress, "vault-address", os.Getenv("VAULT_ADDR"), "address of Vault to resolve vault:path#key references in values")
+	flag.StringVar(&VaultRole, "vault-role", "antiopa", "role of Vault kubernetes auth method")
+	flag.StringVar(&VaultAuthPath, "vault-auth-path", "kubernetes", "path of Vault kubernetes auth method")
+	flag.BoolVar(&SelfThrottlingEnabled, "self-throttling", SelfThrottlingEnabled, "run hooks and helm commands one at a time when memory or cpu usage approaches container limits")
+	flag.Float64Var(&SelfThrottlingMemoryThreshold, "self-throttling-memory-threshold", SelfThrottlingMemoryThreshold, "memory usage to limit ratio to start throttling")
+	flag.Float64Var(&SelfThrottlingCpuThreshold, "self-throttling-cpu-threshold", SelfThrottlingCpuThreshold, "cpu usage to limit ratio to start throttling")
+	flag.StringVar(&module_manager.ChartValuesLayout, "chart-values-layout", module_manager.ChartValuesLayout, "values passed to modules charts: 'helm' for global and module sections only, 'legacy' for all merged values")
+	flag.DurationVar(&utils.LogSamplingWindow, "log-sampling-window", 0, "identical errors of a module, hook or informer are written once per window with the number of repeats, 0 disables sampling")
+	flag.StringVar(&kube.HookClusterRole, "hook-cluster-role", kube.HookClusterRole, "ClusterRole bound to ServiceAccounts of hooks without clusterRole in kubernetesScope, kubectl in hooks uses tokens of these ServiceAccounts, ServiceAccounts have no bindings if empty")
+	flag.DurationVar(&module_manager.HooksTimeout, "hooks-timeout", 0, "default timeout for hooks without timeout in config, hooks get HOOK_DEADLINE and are killed after it, 0 disables timeout")
+	flag.StringVar(&executor.CommandWrapper, "command-wrapper", "", "command with arguments to run helm and kubectl of antiopa and hooks through, e.g. an auditing shim, path and arguments of helm or kubectl are appended to it")
+	flag.DurationVar(&helm.CommandTimeout, "helm-timeout", helm.CommandTimeout, "timeout for helm commands, hung helm is killed with its children, 0 disables timeout")
+	flag.StringVar(&module_manager.HooksValuesFormat, "hooks-values-format", module_manager.HookValuesFormatJson, "default format of CONFIG_VALUES_PATH and VALUES_PATH files for hooks: 'json' or 'yaml', json files are always in CONFIG_VALUES_JSON_PATH and VALUES_JSON_PATH")
+	flag.BoolVar(&module_manager.HooksIsolation, "hooks-isolation", false, "run module hooks from a copy of the module directory with an empty working directory per run, so hooks cannot change files of the module chart")
+	flag.BoolVar(&ConvergeOnce, "converge-once", false, "run onStartup hooks, modules discovery and one converge of all modules, then exit: 0 on success, 1 if a task fails converge-once-max-failures times, 2 on timeout")
+	flag.IntVar(&ConvergeOnceMaxFailures, "converge-once-max-failures", ConvergeOnceMaxFailures, "number of failed runs of a task to fail the converge in converge once mode")
+	flag.DurationVar(&ConvergeOnceTimeout, "converge-once-timeout", ConvergeOnceTimeout, "timeout of the converge in converge once mode")
+	flag.IntVar(&ModuleHealthWindow, "module-health-window", ModuleHealthWindow, "number of the last module runs to calculate success rate and flapping")
+	flag.IntVar(&ModuleFlappingTransitions, "module-flapping-transitions", ModuleFlappingTransitions, "module is flapping if result of runs in the health window is changed this number of times")
+	flag.DurationVar(&FailedTaskMaxDelay, "failed-task-max-delay", FailedTaskMaxDelay, "limit of the exponential backoff of failed module and hook runs")
+	flag.IntVar(&FailedModuleRequeueAfter, "failed-module-requeue-after", FailedModuleRequeueAfter, "number of consecutive failures of a module run after which it is retried out of the queue, so other modules are not blocked; 0 retries it at the head of the queue")
+	flag.IntVar(&ModuleFailedRuns, "module-failed-runs", ModuleFailedRuns, "module is failed if this number of the last runs are failed")
+	flag.BoolVar(&ApiserverBreakerEnabled, "apiserver-breaker", ApiserverBreakerEnabled, "pause tasks queues while apiserver is not available instead of retrying failed tasks")
+	flag.BoolVar(&chart_repo.Enabled, "chart-repo", false, "serve charts of enabled modules as a helm chart repository at /charts/ of the http server")
+	flag.StringVar(&module_manager.ModuleDisableSafety, "module-disable-safety", module_manager.ModuleDisableSafetyRefuse, "policy for release deletion of disabled module that is required or imported by enabled modules: 'refuse', 'warn' or 'off'")
+	flag.StringVar(&module_manager.ValuesBackfillMode, "values-backfill", module_manager.ValuesBackfillDisabled, "backfill config values of modules without a section in ConfigMap from their deployed releases on start: 'dry-run' only logs values, 'apply' saves them into ConfigMap, disabled if empty")
+	flag.StringVar(&state_store.Kind, "state-storage", "", "storage for all persisted states (dynamic values, hooks state, module versions, converge history, module runs, tasks queue snapshot): 'configmap', 'secret' or 'file'. Each state uses its default storage if empty: Secret for dynamic values, ConfigMap for others, local file for the queue snapshot")
+	flag.StringVar(&state_store.Dir, "state-storage-dir", state_store.Dir, "directory for -state-storage=file, should be a persistent volume to keep states between restarts")
+	flag.DurationVar(&TasksQueueDumpInterval, "tasks-queue-dump-interval", TasksQueueDumpInterval, "minimal period between snapshots of the tasks queue in -state-storage")
+	flag.StringVar(&ModuleRunRetriesStorageName, "module-runs-storage", ModuleRunRetriesStorageName, "ConfigMap in antiopa namespace (or a name in -state-storage) to keep retries of failed modules and module runs in progress, interrupted runs are retried after restart, retries are kept only in memory if empty")
+	flag.StringVar(&ConvergeHistoryStorageName, "converge-history-storage", "", "ConfigMap in antiopa namespace (or a name in -state-storage) to keep converge history between restarts, history is kept only in memory if empty")
+	flag.StringVar(&module_manager.DynamicValuesSecretName, "dynamic-values-secret", "", "Secret in antiopa namespace to persist dynamic values from hooks between restarts, dynamic values are kept only in memory if empty")
+	flag.StringVar(&module_manager.HooksStateConfigMapName, "hooks-state-configmap", module_manager.HooksStateConfigMapName, "ConfigMap in antiopa namespace to persist key-value state of hooks between restarts, state is kept only in memory if empty")
+	flag.StringVar(&module_manager.ModuleVersionsConfigMapName, "module-versions-configmap", module_manager.ModuleVersionsConfigMapName, "ConfigMap in antiopa namespace with versions of antiopa of the last successful module runs to check upgrade paths and run migrations from module.yaml, versions are kept only in memory if empty")
+	flag.IntVar(&module_manager.HooksStateMaxSize, "hooks-state-max-size", module_manager.HooksStateMaxSize, "limit of hooks state of a module in bytes")
+	flag.StringVar(&module_manager.FailureArtifactsDir, "failure-artifacts-dir", "", "directory to save bundles of failed module runs: redacted values, rendered manifest, hooks output and error, bundles are not saved if empty")
+	flag.IntVar(&module_manager.ModuleLogLines, "module-log-lines", module_manager.ModuleLogLines, "number of the last lines of hooks output, helm operations and queue decisions kept for each module for 'antiopa module logs'")
+	flag.IntVar(&module_manager.FailureArtifactsRetention, "failure-artifacts-retention", module_manager.FailureArtifactsRetention, "number of failure artifacts bundles kept for each module")
+	flag.BoolVar(&DirLocks, "dir-locks", DirLocks, "lock the temporary dir and the modules dir with advisory file locks, start fails if another antiopa process holds them longer than -dir-locks-timeout")
+	flag.DurationVar(&DirLocksTimeout, "dir-locks-timeout", DirLocksTimeout, "time to wait for directory locks held by another antiopa process")
+	flag.DurationVar(&ShutdownTimeout, "shutdown-timeout", ShutdownTimeout, "time to finish running tasks and run onShutdown global hooks after SIGTERM, should be less than terminationGracePeriodSeconds")
+	flag.BoolVar(&module_manager.NodePlatformsDiscovery, "node-platforms-discovery", module_manager.NodePlatformsDiscovery, "discover OS and architecture of nodes into global.nodePlatforms values and disable modules with unsupported platforms in module.yaml")
+	flag.DurationVar(&ControlPlaneUpgradeCheckInterval, "control-plane-upgrade-check-interval", ControlPlaneUpgradeCheckInterval, "period of apiserver version checks to rerun modules with kubernetesVersionSensitive in module.yaml after the control plane upgrade, checks are disabled if 0")
+	flag.IntVar(&QueueSaturationThreshold, "queue-saturation-threshold", QueueSaturationThreshold, "length of the tasks queue when events of onKubernetesEvent bindings are merged into queued tasks of hooks, only the last event of each object is kept, 0 disables coalescing")
+	flag.StringVar(&SelfResources, "self-resources", SelfResources, "NetworkPolicies of antiopa and tiller and PodDisruptionBudget of antiopa rendered from flags and Deployments: 'apply' creates or updates them at start, 'dry-run' logs them, 'off'")
+	flag.StringVar(&RbacSelfCheck, "rbac-self-check", RbacSelfCheck, "check permissions of antiopa and watches of hooks with SelfSubjectAccessReview: 'enforce' fails start if permissions are missing, 'warn' logs them, 'off'")
+	flag.DurationVar(&module_manager.DynamicValuesFlushInterval, "dynamic-values-flush-interval", module_manager.DynamicValuesFlushInterval, "period of saving changed dynamic values into the Secret")
+	flag.DurationVar(&schedule_manager.Jitter, "schedule-jitter", 0, "spread runs of hooks with the same crontab over this interval, each hook gets a stable offset not greater than a half of the crontab period, e.g. '20s'")
+	flag.StringVar(&module_manager.ChartVerification, "chart-verification", module_manager.ChartVerificationOff, "verification of signed chart archives in the charts directory of modules before install: 'enforce' fails module run, 'warn' logs errors, 'off' skips verification")
+	flag.StringVar(&module_manager.ChartVerificationKeyring, "chart-verification-keyring", "", "public keyring to verify provenance files of chart archives with 'helm verify'")
+	flag.StringVar(&module_manager.ChartVerificationCosignKey, "chart-verification-cosign-key", "", "public key to verify cosign signatures of chart archives")
+	flag.StringVar(&module_manager.HookStaticChecks, "hook-static-checks", module_manager.HookStaticChecks, "checks of hook files at discovery (shebang, interpreter, 'bash -n' for shell hooks): 'enforce' fails discovery, 'warn' logs errors, 'off' skips checks")
+	flag.IntVar(&module_manager.HooksParallelism, "hooks-parallelism", module_manager.DefaultHooksParallelism, "max number of parallel beforeHelm or afterHelm hooks of a module")
+	flag.IntVar(&module_manager.FailedRevisionsCleanupWorkers, "failed-revisions-cleanup-workers", module_manager.FailedRevisionsCleanupWorkers, "number of workers to delete old FAILED revisions of releases in background")
+	flag.DurationVar(&module_manager.FailedRevisionsCleanupInterval, "failed-revisions-cleanup-interval", module_manager.FailedRevisionsCleanupInterval, "min interval between background deletions of old FAILED revisions of releases")
+	flag.IntVar(&module_manager.ModulesParallelism, "modules-parallelism", 1, "max number of modules run at once, modules without dependsOn between them in module.yaml are run concurrently if greater than 1")
+	flag.StringVar(&module_manager.ModuleArchivesDir, "module-archives-dir", "", "directory to store archives of modules directories used for releases, checksum of the module directory is always recorded in release values")
+	flag.BoolVar(&ConvergePlanApproval, "converge-plan-approval", false, "queue converge plans with enabled, changed, deleted or purged modules only after approval with POST /converge-plan/approve?id=N")
+	flag.BoolVar(&failure_injection.Enabled, "failure-injection", false, "enable POST /debug/failures/inject?kind=helm|hook-timeout|watch-disconnect&target=T&count=N to inject failures for validation of alerts and retries in staging clusters, do not use in production")
+	flag.BoolVar(&approval.Required, "destructive-approval", false, "delete releases of disabled modules and many old failed revisions only after approval with POST /approvals/approve?operation=KEY or 'antiopa/approve' annotation on ConfigMap")
+	flag.IntVar(&helm.ValuesMaxSize, "helm-values-max-size", helm.ValuesMaxSize, "helm upgrade fails with a clear error if size of values files of the release is greater, tiller accepts 20MiB messages")
+	flag.IntVar(&helm.ValuesFileChunkSize, "helm-values-chunk-size", helm.ValuesFileChunkSize, "values files larger than this size are split by keys into several --values files, 0 disables splitting")
+	flag.IntVar(&helm.FailedRevisionsApprovalThreshold, "failed-revisions-approval-threshold", helm.FailedRevisionsApprovalThreshold, "deletion of more old failed revisions of a release requires approval if destructive approval is enabled")
+	flag.Int64Var(&TempDirQuota, "tmp-dir-quota", TempDirQuota, "disk usage quota of temporary dir in bytes, the oldest files are removed when it is exceeded, 0 disables the quota")
+	flag.IntVar(&kube.NodeExecParallelism, "node-exec-parallelism", kube.NodeExecParallelism, "max number of pods run at once by nodeExec of a hook")
+	flag.DurationVar(&kube.WatchRelistPeriod, "kube-watch-relist-period", kube.WatchRelistPeriod, "period to relist resources of kube watchers to catch up events missed by watches, 0 disables relist")
+	flag.IntVar(&kube_events_manager.KindShardBufferSize, "kube-events-kind-buffer", kube_events_manager.KindShardBufferSize, "events of onKubernetesEvent bindings are processed by a worker per kind with a buffer of this size, events of kinds are dispatched in turn so a storm of one kind does not delay others")
+	hooksEnv := flag.String("hooks-env", os.Getenv("ANTIOPA_HOOKS_ENV"), "comma separated names of extra environment variables passed to hooks, 'PREFIX_*' passes all variables with prefix")
+	// also sets flag.Parsed() for glog
+	flag.Parse()
+
+	if *hooksEnv != "" {
+		module_manager.HooksExtraEnv = strings.Split(*hooksEnv, ",")
+	}
+
+	if run, ok := subcommands[flag.Arg(0)]; ok {
+		if err := run(flag.Args()[1:]); err != nil {
+			fmt.Fprintln(os.Stderr, err)
+			os.Exit(1)
+		}
+		return
+	}
+
+	rlog.Infof("Starting %s", version.Get().String())
 
 	// Be a good parent - clean up behind the children processes.
 	// Antiopa is PID1, no special config required
 	go executor.Reap()
 
+	// SIGUSR1 toggles debug logging, SIGHUP reloads logging config
+	go utils.RunLogSignalsHandler()
+	go utils.RunLogSamplingSummaries()
+
 	// Включить Http сервер для pprof и prometheus client
 	InitHttpServer()
 
@@ -786,4 +2148,13 @@ func main() {
 
 	// Блокировка main на сигналах от os.
 	utils.WaitForProcessInterruption()
+
+	Shutdown()
+
+	// dynamic values changed since the last flush are saved before exit
+	if ModuleManager != nil {
+		if err := ModuleManager.FlushDynamicValues(); err != nil {
+			rlog.Errorf("MAIN %s", err)
+		}
+	}
 }
@@ -16,8 +16,11 @@ import (
 	"gopkg.in/satori/go.uuid.v1"
 
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
 
 	"github.com/flant/antiopa/helm"
+	"github.com/flant/antiopa/http_poller"
+	"github.com/flant/antiopa/kube"
 	"github.com/flant/antiopa/kube_events_manager"
 	"github.com/flant/antiopa/metrics_storage"
 	"github.com/flant/antiopa/module_manager"
@@ -39,13 +42,13 @@ func (obj *KubeEventsHooksControllerMock) DisableModuleHooks(moduleName string,
 	return nil
 }
 
-func (obj *KubeEventsHooksControllerMock) HandleEvent(configId string) (*struct{ Tasks []task.Task }, error) {
+func (obj *KubeEventsHooksControllerMock) HandleEvent(kubeEvent kube_events_manager.KubeEvent) (*struct{ Tasks []task.Task }, error) {
 	return nil, nil
 }
 
 type KubeEventsManagerMock struct{}
 
-func (kem *KubeEventsManagerMock) Run(eventTypes []module_manager.OnKubernetesEventType, kind, namespace string, labelSelector *metav1.LabelSelector, jqFilter string, debug bool) (string, error) {
+func (kem *KubeEventsManagerMock) Run(eventTypes []module_manager.OnKubernetesEventType, apiVersion, kind, namespace string, labelSelector *metav1.LabelSelector, jqFilter string, debug bool) (string, error) {
 	return uuid.NewV4().String(), nil
 }
 
@@ -54,6 +57,7 @@ func (kem *KubeEventsManagerMock) Stop(configId string) error {
 }
 
 type ModuleManagerMock struct {
+	module_manager.ModuleManager
 	BeforeHookErrorsCount    int
 	TestModuleErrorsCount    int
 	DeleteModuleErrorsCount  int
@@ -100,7 +104,7 @@ func (m *ModuleManagerMock) Run() {
 }
 
 func (m *ModuleManagerMock) GetModule(name string) (*module_manager.Module, error) {
-	panic("implement GetModule")
+	return &module_manager.Module{Name: name}, nil
 }
 
 func (m *ModuleManagerMock) GetModuleNamesInOrder() []string {
@@ -109,9 +113,10 @@ func (m *ModuleManagerMock) GetModuleNamesInOrder() []string {
 
 func (m *ModuleManagerMock) DiscoverModulesState() (*module_manager.ModulesState, error) {
 	return &module_manager.ModulesState{
-		[]string{"test_module_1__101", "test_module_2__102"},
-		[]string{"disabled_module_1__111", "disabled_2__112", "disabled_3.14__113"},
-		[]string{"unknown_module_1__121", "abandoned_1__122", "forgotten_3.14__123"},
+		EnabledModules:         []string{"test_module_1__101", "test_module_2__102"},
+		ModulesToRun:           []string{"test_module_1__101", "test_module_2__102"},
+		ModulesToDisable:       []string{"disabled_module_1__111", "disabled_2__112", "disabled_3.14__113"},
+		ReleasedUnknownModules: []string{"unknown_module_1__121", "abandoned_1__122", "forgotten_3.14__123"},
 	}, nil
 }
 
@@ -172,7 +177,7 @@ func (m *ModuleManagerMock) GetModuleHook(name string) (*module_manager.ModuleHo
 			},
 		}, nil
 	}
-	return nil, nil
+	return nil, fmt.Errorf("module hook '%s' not found", name)
 }
 
 func (m *ModuleManagerMock) GetGlobalHooksInOrder(bindingType module_manager.BindingType) []string {
@@ -201,7 +206,7 @@ func (m *ModuleManagerMock) GetModuleHooksInOrder(moduleName string, bindingType
 	return []string{"test_module_hook_1", "test_module_hook_2"}, nil
 }
 
-func (m *ModuleManagerMock) DeleteModule(moduleName string) error {
+func (m *ModuleManagerMock) DeleteModule(moduleName string, taskId string) error {
 	addRunOrder(moduleName)
 	fmt.Printf("ModuleManagerMock DeleteModule '%s'\n", moduleName)
 	if strings.Contains(moduleName, "disabled_module_1") && m.DeleteModuleErrorsCount > 0 {
@@ -211,7 +216,7 @@ func (m *ModuleManagerMock) DeleteModule(moduleName string) error {
 	return nil
 }
 
-func (m *ModuleManagerMock) RunModule(moduleName string) error {
+func (m *ModuleManagerMock) RunModule(moduleName string, onStartup bool, taskId string) error {
 	addRunOrder(moduleName)
 	fmt.Printf("ModuleManagerMock RunModule '%s'\n", moduleName)
 	if strings.Contains(moduleName, "test_module_2") && m.TestModuleErrorsCount > 0 {
@@ -221,7 +226,7 @@ func (m *ModuleManagerMock) RunModule(moduleName string) error {
 	return nil
 }
 
-func (m *ModuleManagerMock) RunGlobalHook(hookName string, binding module_manager.BindingType) error {
+func (m *ModuleManagerMock) RunGlobalHook(hookName string, binding module_manager.BindingType, bindingContext []module_manager.BindingContext, taskId string) error {
 	addRunOrder(hookName)
 	fmt.Printf("Run global hook name '%s' binding '%s'\n", hookName, binding)
 	if strings.Contains(hookName, "before_hook_1") && m.BeforeHookErrorsCount > 0 {
@@ -231,7 +236,7 @@ func (m *ModuleManagerMock) RunGlobalHook(hookName string, binding module_manage
 	return nil
 }
 
-func (m *ModuleManagerMock) RunModuleHook(hookName string, binding module_manager.BindingType) error {
+func (m *ModuleManagerMock) RunModuleHook(hookName string, binding module_manager.BindingType, bindingContext []module_manager.BindingContext, taskId string) error {
 	addRunOrder(hookName)
 	fmt.Printf("Run module hook name '%s' binding '%s'\n", hookName, binding)
 	if strings.Contains(hookName, "scheduled_module_1") && m.ScheduledHookErrorsCount > 0 {
@@ -241,6 +246,36 @@ func (m *ModuleManagerMock) RunModuleHook(hookName string, binding module_manage
 	return nil
 }
 
+func (m *ModuleManagerMock) AdoptModuleResources(moduleName string, dryRun bool) ([]helm.AdoptedResource, error) {
+	fmt.Printf("ModuleManagerMock AdoptModuleResources '%s' dryRun=%v\n", moduleName, dryRun)
+	return []helm.AdoptedResource{}, nil
+}
+
+func (m *ModuleManagerMock) CheckModuleDisable(moduleName string) error {
+	return nil
+}
+
+func (m *ModuleManagerMock) StartConvergeCycle(modulesToRun []string) {
+}
+
+func (m *ModuleManagerMock) PendingModulesBeforeStage(stage module_manager.ModuleStage) []string {
+	return nil
+}
+
+func (m *ModuleManagerMock) RunModulesGraph(modulesNames []string, run func(moduleName string) error) map[string]error {
+	errs := make(map[string]error)
+	for _, moduleName := range modulesNames {
+		if err := run(moduleName); err != nil {
+			errs[moduleName] = err
+		}
+	}
+	return errs
+}
+
+func (m *ModuleManagerMock) HookPendingModules(hookName string) []string {
+	return nil
+}
+
 func (m *ModuleManagerMock) Retry() {
 	fmt.Println("ModuleManagerMock Retry")
 }
@@ -254,6 +289,14 @@ func (h MockHelmClient) CommandEnv() []string {
 	return []string{}
 }
 
+func (h MockHelmClient) TillerNamespace() string {
+	return "antiopa"
+}
+
+func (h MockHelmClient) IsReleaseExists(_ string) (bool, error) {
+	return true, nil
+}
+
 func (h MockHelmClient) DeleteRelease(name string) error {
 	addRunOrder(name)
 	fmt.Printf("HelmClient: DeleteRelease '%s'\n", name)
@@ -384,6 +427,24 @@ func TestMain_ModulesEventsHandler(t *testing.T) {
 func TestMain(m *testing.M) {
 
 	MetricsStorage = metrics_storage.Init()
+	ReleaseWatcher = NewMainReleaseResourcesWatcher()
+	ConvergeCycles = NewConvergeHistory(ConvergeHistoryLength)
+	ScheduleRuns = NewScheduleRunResults()
+	DeferredRuns = NewDeferredModuleRuns()
+	PausedRuns = NewPausedModuleRuns()
+	ModuleRetries = NewModuleRunRetries()
+	HelmUpgradeWaits = NewHelmUpgradeWaits()
+	ModulesHealth = NewModulesHealthTracker(ModuleHealthWindow)
+	ApiserverBreaker = NewApiserverCircuitBreaker(func() error { return nil })
+	HeldHookRuns = NewHeldHookRuns()
+	ConvergePlanner = NewConvergePlans()
+	AddonsReports = NewAddonsReport()
+	AddonsHealth = NewAddonsHealthChecker()
+	HttpPoller = &MockHttpPoller{}
+
+	clientset := fake.NewSimpleClientset()
+	kube.Kubernetes = clientset
+	kube.KubernetesClient = clientset
 
 	os.Exit(m.Run())
 }
@@ -396,6 +457,9 @@ func TestMain_Run_With_InfiniteModuleError(t *testing.T) {
 	QueueIsEmptyDelay = 50 * time.Millisecond
 	FailedHookDelay = 50 * time.Millisecond
 	FailedModuleDelay = 50 * time.Millisecond
+	FailedTaskMaxDelay = 50 * time.Millisecond
+	// failed module is retried at the head of the queue
+	FailedModuleRequeueAfter = 0
 
 	module_manager.EventCh = make(chan module_manager.Event, 1)
 	ManagersEventsHandlerStopCh = make(chan struct{}, 1)
@@ -407,6 +471,7 @@ func TestMain_Run_With_InfiniteModuleError(t *testing.T) {
 	HelmClient = MockHelmClient{
 		DeleteReleaseErrorsCount: 0,
 	}
+	HelmClients = helm.NewClientsPool(HelmClient, nil)
 
 	// Mock ModuleManager
 	ModuleManager = &ModuleManagerMock{
@@ -456,6 +521,9 @@ func TestMain_Run_With_RecoverableErrors(t *testing.T) {
 	QueueIsEmptyDelay = 50 * time.Millisecond
 	FailedHookDelay = 50 * time.Millisecond
 	FailedModuleDelay = 50 * time.Millisecond
+	FailedTaskMaxDelay = 50 * time.Millisecond
+	// failed modules are retried at the head of the queue to keep the order
+	FailedModuleRequeueAfter = 0
 
 	module_manager.EventCh = make(chan module_manager.Event, 1)
 	ManagersEventsHandlerStopCh = make(chan struct{}, 1)
@@ -467,6 +535,7 @@ func TestMain_Run_With_RecoverableErrors(t *testing.T) {
 	HelmClient = MockHelmClient{
 		DeleteReleaseErrorsCount: 3,
 	}
+	HelmClients = helm.NewClientsPool(HelmClient, nil)
 
 	// Mock ModuleManager
 	ModuleManager = &ModuleManagerMock{
@@ -525,6 +594,16 @@ func (m *MockScheduleManager) Run() {
 	fmt.Printf("MockScheduleManager: Run\n")
 }
 
+type MockHttpPoller struct{}
+
+func (m *MockHttpPoller) Pollers() []*http_poller.Poller {
+	return nil
+}
+
+func (m *MockHttpPoller) Run() {
+	fmt.Printf("MockHttpPoller: Run\n")
+}
+
 // Тесты scheduled_tasks
 // Проинициализировать первый раз хуки по расписанию
 // Забросить в scheduled канал несколько расписаний
@@ -545,6 +624,7 @@ func TestMain_ScheduledTasks(t *testing.T) {
 	HelmClient = MockHelmClient{
 		DeleteReleaseErrorsCount: 3,
 	}
+	HelmClients = helm.NewClientsPool(HelmClient, nil)
 
 	// Mock ModuleManager
 	ModuleManager = &ModuleManagerMock{
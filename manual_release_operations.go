@@ -0,0 +1,47 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/romana/rlog"
+	"k8s.io/api/core/v1"
+
+	"github.com/flant/antiopa/helm"
+	"github.com/flant/antiopa/kube"
+	"github.com/flant/antiopa/module_manager"
+	"github.com/flant/antiopa/task"
+)
+
+// HandleManualReleaseOperation reports a release revision created not by antiopa.
+// Module is rerun to restore its release if module watches release with rerun mode.
+func HandleManualReleaseOperation(operation helm.ManualReleaseOperation) {
+	moduleName := operation.Release
+	message := fmt.Sprintf("release '%s' revision %d with status '%s' is created not by antiopa", operation.Release, operation.Revision, operation.Status)
+	rlog.Warnf("MAIN_LOOP %s", message)
+
+	MetricsStorage.SendCounterMetric("antiopa_manual_release_operations", 1.0, map[string]string{"module": moduleName})
+
+	err := kube.CreateWarningEvent(v1.ObjectReference{
+		Kind:       "ConfigMap",
+		APIVersion: "v1",
+		Namespace:  operation.TillerNamespace,
+		Name:       operation.ConfigMap,
+	}, "ManualReleaseOperation", message)
+	if err != nil {
+		rlog.Errorf("MAIN_LOOP release '%s': %s", operation.Release, err)
+	}
+
+	module, err := ModuleManager.GetModule(moduleName)
+	if err != nil || module.Definition == nil || module.Definition.WatchRelease != module_manager.ReleaseWatchRerun {
+		return
+	}
+
+	if err := ModuleManager.ForceModuleHelmUpgrade(moduleName); err != nil {
+		rlog.Errorf("MAIN_LOOP module '%s': cannot force helm upgrade: %s", moduleName, err)
+		return
+	}
+	newTask := task.NewTask(task.ModuleRun, moduleName).
+		WithCause("manual helm operation")
+	TasksQueue.Add(newTask)
+	rlog.Infof("QUEUE add ModuleRun %s: restore release after manual helm operation", moduleName)
+}
@@ -15,6 +15,8 @@ import (
 // - antiopa_registry_errors{namespace="" }
 // счётчик работы antiopa
 // - antiopa_live_ticks{namespace=""} counter increase every 5 sec while antiopa runs
+// длительность запусков модулей и хуков
+// - antiopa_module_run_seconds{module="xxx" result="success|error"} histogram
 
 type Metric interface {
 	store(*MetricStorage)
@@ -77,6 +79,21 @@ func NewCounterMetric(metric string, value float64, labels map[string]string) *C
 	}}
 }
 
+// DurationBuckets are buckets of histograms with durations of module and hook runs in seconds
+var DurationBuckets = []float64{0.1, 0.5, 1, 2.5, 5, 10, 30, 60, 120, 300, 600}
+
+type HistogramMetric struct {
+	BaseMetric
+}
+
+func NewHistogramMetric(metric string, value float64, labels map[string]string) *HistogramMetric {
+	return &HistogramMetric{BaseMetric{
+		Metric: metric,
+		Value:  value,
+		Labels: labels,
+	}}
+}
+
 func (metric *GaugeMetric) store(storage *MetricStorage) {
 	metricVec := metric.getOrCreateMetricVec(storage, func() (prometheus.Collector, MetricVec) {
 		prometheusVec := prometheus.NewGaugeVec(
@@ -105,6 +122,21 @@ func (metric *CounterMetric) store(storage *MetricStorage) {
 	metricVec.UpdateValue(metric.Labels, metric.Value)
 }
 
+func (metric *HistogramMetric) store(storage *MetricStorage) {
+	metricVec := metric.getOrCreateMetricVec(storage, func() (prometheus.Collector, MetricVec) {
+		prometheusVec := prometheus.NewHistogramVec(
+			prometheus.HistogramOpts{
+				Name:    metric.Metric,
+				Help:    metric.Metric,
+				Buckets: DurationBuckets,
+			},
+			metric.LabelsNames(),
+		)
+		return prometheusVec, NewMetricHistogramVec(prometheusVec, metric.Metric, metric.LabelsNames())
+	})
+	metricVec.UpdateValue(metric.Labels, metric.Value)
+}
+
 type MetricGaugeVec struct {
 	*prometheus.GaugeVec
 	Name       string
@@ -133,6 +165,20 @@ func NewMetricCounterVec(counter *prometheus.CounterVec, name string, labelNames
 	return metricCounterVec
 }
 
+type MetricHistogramVec struct {
+	*prometheus.HistogramVec
+	Name       string
+	LabelNames []string
+}
+
+func NewMetricHistogramVec(histogram *prometheus.HistogramVec, name string, labelNames []string) *MetricHistogramVec {
+	metricHistogramVec := &MetricHistogramVec{histogram, name, make([]string, 0)}
+	for _, labelName := range labelNames {
+		metricHistogramVec.LabelNames = append(metricHistogramVec.LabelNames, labelName)
+	}
+	return metricHistogramVec
+}
+
 type MetricVec interface {
 	UpdateValue(labels prometheus.Labels, value float64)
 }
@@ -153,6 +199,14 @@ func (metricVec *MetricCounterVec) UpdateValue(labels prometheus.Labels, value f
 	}()
 	metricVec.With(labels).Add(value)
 }
+func (metricVec *MetricHistogramVec) UpdateValue(labels prometheus.Labels, value float64) {
+	defer func() {
+		if r := recover(); r != nil {
+			rlog.Errorf("MSTOR Panic! Metric %s %v update with %v error: %v", metricVec.Name, metricVec.LabelNames, labels, r)
+		}
+	}()
+	metricVec.With(labels).Observe(value)
+}
 
 func Init() *MetricStorage {
 	return NewMetricStorage()
@@ -188,3 +242,6 @@ func (storage *MetricStorage) SendGaugeMetric(metric string, value float64, labe
 func (storage *MetricStorage) SendCounterMetric(metric string, value float64, labels map[string]string) {
 	storage.MetricChan <- NewCounterMetric(metric, value, labels)
 }
+func (storage *MetricStorage) SendHistogramMetric(metric string, value float64, labels map[string]string) {
+	storage.MetricChan <- NewHistogramMetric(metric, value, labels)
+}
@@ -0,0 +1,71 @@
+package main
+
+import (
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/flant/antiopa/helm"
+	"github.com/flant/antiopa/kube"
+	"github.com/flant/antiopa/module_manager"
+)
+
+// RunMigrateReleasesCommand handles `antiopa migrate-releases`: tiller ConfigMaps
+// of releases of modules from working dir are converted into helm 3 secrets.
+func RunMigrateReleasesCommand(args []string) error {
+	flags := flag.NewFlagSet("migrate-releases", flag.ContinueOnError)
+	workingDir := flags.String("working-dir", "", "directory with modules, only their releases are migrated, current dir is used if empty")
+	tillerNamespaces := flags.String("tiller-namespaces", "", "comma separated tiller namespaces, antiopa namespace is used if empty")
+	dryRun := flags.Bool("dry-run", false, "only convert and print releases, do not create secrets")
+	deleteV2Releases := flags.Bool("delete-v2-releases", false, "delete tiller ConfigMaps after successful conversion")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	if *workingDir == "" {
+		dir, err := os.Getwd()
+		if err != nil {
+			return err
+		}
+		*workingDir = dir
+	}
+	releases, err := module_manager.ModulesReleasesNames(*workingDir)
+	if err != nil {
+		return fmt.Errorf("cannot load modules from '%s': %s", *workingDir, err)
+	}
+
+	kube.InitKube()
+
+	namespaces := []string{kube.KubernetesAntiopaNamespace}
+	if *tillerNamespaces != "" {
+		namespaces = strings.Split(*tillerNamespaces, ",")
+	}
+
+	failed := 0
+	for _, namespace := range namespaces {
+		results, err := helm.MigrateReleases(helm.MigrateReleasesOptions{
+			TillerNamespace:  namespace,
+			Releases:         releases,
+			DryRun:           *dryRun,
+			DeleteV2Releases: *deleteV2Releases,
+		})
+		if err != nil {
+			return err
+		}
+
+		for _, res := range results {
+			if res.Error != nil {
+				failed++
+				fmt.Printf("FAIL %s revision %d: %s\n", res.Release, res.Revision, res.Error)
+				continue
+			}
+			fmt.Printf("OK   %s revision %d -> secret/%s\n", res.Release, res.Revision, res.Secret)
+		}
+	}
+
+	if failed > 0 {
+		return fmt.Errorf("%d releases revisions are not migrated", failed)
+	}
+	return nil
+}
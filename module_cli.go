@@ -0,0 +1,266 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"path/filepath"
+	"strconv"
+	"time"
+
+	"github.com/flant/antiopa/helm"
+	"github.com/flant/antiopa/kube"
+	"github.com/flant/antiopa/module_manager"
+	"github.com/flant/antiopa/task"
+)
+
+// moduleQueueLog returns a logger for decisions of the tasks queue about the module
+func moduleQueueLog(moduleName string) *module_manager.ModuleLogger {
+	return module_manager.ModuleLog(moduleName, module_manager.ModuleLogSourceQueue)
+}
+
+const moduleCommandUsage = "usage: antiopa module logs <name> [--tail N] | antiopa module run <module-dir> [--values file] | antiopa module migrate-namespace <name> --from namespace [--dry-run] | antiopa module test <name> [--modules-dir modules] [--update]"
+
+// RunModuleCommand handles `antiopa module logs`, `antiopa module run`, `antiopa module migrate-namespace` and `antiopa module test`
+func RunModuleCommand(args []string) error {
+	if len(args) < 2 {
+		return fmt.Errorf(moduleCommandUsage)
+	}
+	switch args[0] {
+	case "logs":
+		return runModuleLogsCommand(args)
+	case "run":
+		return runModuleRunCommand(args)
+	case "migrate-namespace":
+		return runModuleMigrateNamespaceCommand(args)
+	case "test":
+		return runModuleTestCommand(args)
+	}
+	return fmt.Errorf(moduleCommandUsage)
+}
+
+// runModuleLogsCommand handles `antiopa module logs <name> [--tail N]`: lines of hooks output,
+// helm operations and queue decisions of the module are requested from the running antiopa.
+func runModuleLogsCommand(args []string) error {
+	moduleName := args[1]
+
+	flags := flag.NewFlagSet("module logs", flag.ContinueOnError)
+	tail := flags.Int("tail", 100, "number of the last lines, all kept lines if 0")
+	if err := flags.Parse(args[2:]); err != nil {
+		return err
+	}
+
+	client := &http.Client{Timeout: 60 * time.Second}
+	query := url.Values{}
+	query.Set("name", moduleName)
+	query.Set("tail", strconv.Itoa(*tail))
+
+	resp, err := client.Get(ApiAddress + "/module/logs?" + query.Encode())
+	if err != nil {
+		return fmt.Errorf("cannot get module logs: %s", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		body, _ := ioutil.ReadAll(resp.Body)
+		return fmt.Errorf("cannot get module logs: %s: %s", resp.Status, string(body))
+	}
+	_, err = io.Copy(os.Stdout, resp.Body)
+	return err
+}
+
+// runModuleRunCommand handles `antiopa module run <module-dir> [--values file]`: the module is run
+// once against the cluster from the current kube context without other modules and global hooks,
+// so module developers can test it in CI clusters. Exit code is not zero if the run fails.
+func runModuleRunCommand(args []string) error {
+	moduleDir := args[1]
+
+	flags := flag.NewFlagSet("module run", flag.ContinueOnError)
+	valuesPath := flags.String("values", "", "yaml file with config values as in the ConfigMap: 'global' and the module section")
+	tillerMode := flags.String("tiller-mode", helm.TillerModeEmbedded, "tiller for the release: 'embedded' starts tiller process, 'external' uses tiller in the tiller namespace, 'deployment' installs it")
+	tillerNamespace := flags.String("tiller-namespace", "", "namespace of tiller and its releases, namespace from the ServiceAccount or ANTIOPA_NAMESPACE is used if empty")
+	if err := flags.Parse(args[2:]); err != nil {
+		return err
+	}
+
+	configValues, err := readConfigValuesFile(*valuesPath)
+	if err != nil {
+		return err
+	}
+	initHelm, err := helm.InitFunc(*tillerMode)
+	if err != nil {
+		return err
+	}
+
+	config, err := kube.LoadRestConfig()
+	if err != nil {
+		return err
+	}
+	namespace := *tillerNamespace
+	if namespace == "" {
+		if namespace, err = kube.DetectNamespace(); err != nil {
+			return err
+		}
+	}
+	if err := kube.InitKubeWithConfig(config, namespace); err != nil {
+		return err
+	}
+
+	// hooks and helm write values files into temp dir
+	tempDir, err := ioutil.TempDir("", "antiopa-module-run")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(tempDir)
+
+	helmClient, err := initHelm(namespace)
+	if err != nil {
+		return fmt.Errorf("cannot initialize helm: %s", err)
+	}
+
+	taskId := task.NewTask(task.ModuleRun, moduleDir).GetCorrelationId()
+	if err := module_manager.RunStandaloneModule(moduleDir, tempDir, configValues, helmClient, taskId); err != nil {
+		return fmt.Errorf("module run failed: %s", err)
+	}
+	fmt.Printf("module '%s' is converged\n", moduleDir)
+	return nil
+}
+
+// runModuleMigrateNamespaceCommand handles `antiopa module migrate-namespace <name> --from namespace [--dry-run]`:
+// the release of the module is moved by the running antiopa from the tiller in the old namespace into
+// the tiller namespace from module.yaml. Objects are adopted by the new release without downtime
+// and objects left in the old namespace are deleted after the next run of the module.
+func runModuleMigrateNamespaceCommand(args []string) error {
+	moduleName := args[1]
+
+	flags := flag.NewFlagSet("module migrate-namespace", flag.ContinueOnError)
+	from := flags.String("from", "", "tiller namespace of the current release")
+	dryRun := flags.Bool("dry-run", false, "only print objects that would be adopted and deleted")
+	if err := flags.Parse(args[2:]); err != nil {
+		return err
+	}
+	if *from == "" {
+		return fmt.Errorf(moduleCommandUsage)
+	}
+
+	client := &http.Client{Timeout: 5 * time.Minute}
+	query := url.Values{"name": {moduleName}, "from": {*from}}
+	if *dryRun {
+		query.Set("dryRun", "true")
+	}
+
+	resp, err := client.Post(ApiAddress+"/module/migrate-namespace?"+query.Encode(), "text/plain", nil)
+	if err != nil {
+		return fmt.Errorf("cannot migrate release namespace: %s", err)
+	}
+	defer resp.Body.Close()
+	body, _ := ioutil.ReadAll(resp.Body)
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("cannot migrate release namespace: %s: %s", resp.Status, string(body))
+	}
+
+	migration := &helm.ReleaseNamespaceMigration{}
+	if err := json.Unmarshal(body, migration); err != nil {
+		return fmt.Errorf("bad response: %s", err)
+	}
+	fmt.Printf("release '%s': namespace '%s' -> '%s'\n", migration.Release, migration.From, migration.To)
+	for _, res := range migration.Adopted {
+		fmt.Printf("%-9s %s\n", res.Result, res.Resource)
+	}
+	for _, resource := range migration.Stale {
+		fmt.Printf("%-9s %s\n", "delete", resource)
+	}
+	if !*dryRun {
+		fmt.Println("module is queued to run, objects to delete are deleted after the successful run")
+	}
+	return nil
+}
+
+// ModuleTestCaseReport is a machine-readable result of one case of `antiopa module test`
+type ModuleTestCaseReport struct {
+	Name    string `json:"name"`
+	Ok      bool   `json:"ok"`
+	Updated bool   `json:"updated,omitempty"`
+	Error   string `json:"error,omitempty"`
+}
+
+// ModuleTestReport is printed by `antiopa module test --format json`
+type ModuleTestReport struct {
+	Ok    bool                   `json:"ok"`
+	Cases []ModuleTestCaseReport `json:"cases"`
+}
+
+// runModuleTestCommand handles `antiopa module test <name> [--modules-dir modules] [--update]`: the chart
+// of the module is rendered offline with values of each case from the tests directory of the module
+// and compared with golden manifests and assertions. Golden manifests are rewritten with --update.
+func runModuleTestCommand(args []string) error {
+	moduleName := args[1]
+
+	flags := flag.NewFlagSet("module test", flag.ContinueOnError)
+	modulesDir := flags.String("modules-dir", "modules", "'modules' directory of the working dir")
+	update := flags.Bool("update", false, "write rendered manifests into golden files")
+	format := flags.String("format", "text", "report format: 'json' or 'text'")
+	if err := flags.Parse(args[2:]); err != nil {
+		return err
+	}
+	if *format != "json" && *format != "text" {
+		return fmt.Errorf("unknown format '%s', expected 'json' or 'text'", *format)
+	}
+
+	absModulesDir, err := filepath.Abs(*modulesDir)
+	if err != nil {
+		return err
+	}
+	if filepath.Base(absModulesDir) != "modules" {
+		return fmt.Errorf("modules dir '%s' should be named 'modules'", *modulesDir)
+	}
+
+	tempDir, err := ioutil.TempDir("", "antiopa-module-test")
+	if err != nil {
+		return err
+	}
+	defer os.RemoveAll(tempDir)
+
+	results, err := module_manager.RunModuleTests(filepath.Dir(absModulesDir), tempDir, moduleName, helm.NewOfflineHelm("default"), *update)
+	if err != nil {
+		return err
+	}
+
+	report := ModuleTestReport{Ok: true, Cases: make([]ModuleTestCaseReport, 0, len(results))}
+	for _, result := range results {
+		caseReport := ModuleTestCaseReport{Name: result.Name, Ok: result.Error == nil, Updated: result.Updated}
+		if result.Error != nil {
+			report.Ok = false
+			caseReport.Error = result.Error.Error()
+		}
+		report.Cases = append(report.Cases, caseReport)
+	}
+
+	if *format == "json" {
+		data, err := json.MarshalIndent(report, "", "  ")
+		if err != nil {
+			return err
+		}
+		fmt.Println(string(data))
+	} else {
+		for _, caseReport := range report.Cases {
+			switch {
+			case !caseReport.Ok:
+				fmt.Printf("FAIL    %s: %s\n", caseReport.Name, caseReport.Error)
+			case caseReport.Updated:
+				fmt.Printf("UPDATED %s\n", caseReport.Name)
+			default:
+				fmt.Printf("OK      %s\n", caseReport.Name)
+			}
+		}
+	}
+
+	if !report.Ok {
+		return fmt.Errorf("module '%s' tests failed", moduleName)
+	}
+	return nil
+}
@@ -0,0 +1,139 @@
+package main
+
+import (
+	"sort"
+	"sync"
+
+	"github.com/flant/antiopa/task"
+)
+
+// Number of the last module runs to calculate success rate and flapping
+var ModuleHealthWindow = 20
+
+// Module is flapping if result of runs in window is changed this number of times
+var ModuleFlappingTransitions = 4
+
+// Module is failed if this number of the last runs are failed
+var ModuleFailedRuns = 3
+
+const (
+	ModuleHealthHealthy  = "Healthy"
+	ModuleHealthFlapping = "Flapping"
+	ModuleHealthFailed   = "Failed"
+)
+
+// ModuleHealthStatus is a condition of the module calculated from the last runs.
+// Failed module fails constantly, flapping module alternates between success and failure.
+type ModuleHealthStatus struct {
+	Module      string  `json:"module"`
+	Condition   string  `json:"condition"`
+	SuccessRate float64 `json:"successRate"`
+	Runs        int     `json:"runs"`
+	Transitions int     `json:"transitions"`
+	// number of the last failed runs in a row
+	ConsecutiveFailures int `json:"consecutiveFailures"`
+}
+
+// ModulesHealthTracker keeps results of the last runs of modules
+type ModulesHealthTracker struct {
+	m       sync.Mutex
+	window  int
+	results map[string][]bool
+}
+
+func NewModulesHealthTracker(window int) *ModulesHealthTracker {
+	return &ModulesHealthTracker{
+		window:  window,
+		results: make(map[string][]bool),
+	}
+}
+
+// Record saves a result of the module run and returns a new status of the module
+func (h *ModulesHealthTracker) Record(moduleName string, success bool) ModuleHealthStatus {
+	h.m.Lock()
+	defer h.m.Unlock()
+
+	results := append(h.results[moduleName], success)
+	if len(results) > h.window {
+		results = results[len(results)-h.window:]
+	}
+	h.results[moduleName] = results
+
+	return moduleHealthStatus(moduleName, results)
+}
+
+// Forget removes results of the module, e.g. after the module is disabled
+func (h *ModulesHealthTracker) Forget(moduleName string) {
+	h.m.Lock()
+	defer h.m.Unlock()
+
+	delete(h.results, moduleName)
+}
+
+// Dump returns statuses of modules sorted by name
+func (h *ModulesHealthTracker) Dump() []ModuleHealthStatus {
+	h.m.Lock()
+	defer h.m.Unlock()
+
+	res := make([]ModuleHealthStatus, 0, len(h.results))
+	for moduleName, results := range h.results {
+		res = append(res, moduleHealthStatus(moduleName, results))
+	}
+	sort.Slice(res, func(i, j int) bool {
+		return res[i].Module < res[j].Module
+	})
+	return res
+}
+
+func moduleHealthStatus(moduleName string, results []bool) ModuleHealthStatus {
+	status := ModuleHealthStatus{
+		Module:    moduleName,
+		Condition: ModuleHealthHealthy,
+		Runs:      len(results),
+	}
+
+	successes := 0
+	for i, success := range results {
+		if success {
+			successes++
+			status.ConsecutiveFailures = 0
+		} else {
+			status.ConsecutiveFailures++
+		}
+		if i > 0 && success != results[i-1] {
+			status.Transitions++
+		}
+	}
+	if len(results) > 0 {
+		status.SuccessRate = float64(successes) / float64(len(results))
+	}
+
+	switch {
+	case status.ConsecutiveFailures >= ModuleFailedRuns:
+		status.Condition = ModuleHealthFailed
+	case status.Transitions >= ModuleFlappingTransitions:
+		status.Condition = ModuleHealthFlapping
+	}
+	return status
+}
+
+// RecordModuleHealth saves a result of ModuleRun task and sends health metrics of the module.
+// Cancelled runs are not counted.
+func RecordModuleHealth(t task.Task, err error) {
+	if t.IsCancelled() {
+		return
+	}
+	status := ModulesHealth.Record(t.GetName(), err == nil)
+
+	labels := map[string]string{"module": t.GetName()}
+	MetricsStorage.SendGaugeMetric("antiopa_module_run_success_rate", status.SuccessRate, labels)
+	flapping, failed := 0.0, 0.0
+	switch status.Condition {
+	case ModuleHealthFlapping:
+		flapping = 1.0
+	case ModuleHealthFailed:
+		failed = 1.0
+	}
+	MetricsStorage.SendGaugeMetric("antiopa_module_flapping", flapping, labels)
+	MetricsStorage.SendGaugeMetric("antiopa_module_failed", failed, labels)
+}
@@ -0,0 +1,51 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestModulesHealthTracker(t *testing.T) {
+	h := NewModulesHealthTracker(6)
+
+	for _, success := range []bool{true, true, false, true} {
+		h.Record("stable", success)
+	}
+	for _, success := range []bool{true, false, true, false, true} {
+		h.Record("flapping", success)
+	}
+	for _, success := range []bool{true, false, true, false, false, false} {
+		h.Record("failed", success)
+	}
+
+	statuses := h.Dump()
+	if !assert.Len(t, statuses, 3) {
+		return
+	}
+
+	assert.Equal(t, "failed", statuses[0].Module)
+	assert.Equal(t, ModuleHealthFailed, statuses[0].Condition)
+	assert.Equal(t, 3, statuses[0].ConsecutiveFailures)
+
+	assert.Equal(t, "flapping", statuses[1].Module)
+	assert.Equal(t, ModuleHealthFlapping, statuses[1].Condition)
+	assert.Equal(t, 4, statuses[1].Transitions)
+	assert.Equal(t, 0.6, statuses[1].SuccessRate)
+
+	assert.Equal(t, "stable", statuses[2].Module)
+	assert.Equal(t, ModuleHealthHealthy, statuses[2].Condition)
+	assert.Equal(t, 0.75, statuses[2].SuccessRate)
+
+	// old results are out of the window
+	for i := 0; i < 6; i++ {
+		h.Record("flapping", true)
+	}
+	status := h.Record("flapping", true)
+	assert.Equal(t, ModuleHealthHealthy, status.Condition)
+	assert.Equal(t, 6, status.Runs)
+	assert.Equal(t, 1.0, status.SuccessRate)
+
+	h.Forget("stable")
+	assert.Len(t, h.Dump(), 2)
+}
@@ -0,0 +1,43 @@
+package module_manager
+
+import (
+	"github.com/romana/rlog"
+
+	"github.com/flant/antiopa/utils"
+)
+
+// Layouts of values passed to modules charts
+const (
+	// global values under `global:` key and module values under camelCase module key,
+	// subcharts get global values and values under their names as usual in helm
+	ChartValuesLayoutHelm = "helm"
+	// all merged values including sections of other modules from modules/values.yaml
+	ChartValuesLayoutLegacy = "legacy"
+)
+
+// ChartValuesLayout is a layout of values for helm upgrade of modules charts
+var ChartValuesLayout = ChartValuesLayoutLegacy
+
+// chartValues returns values of the module in ChartValuesLayout
+func (m *Module) chartValues(values utils.Values) utils.Values {
+	if ChartValuesLayout == ChartValuesLayoutLegacy {
+		return values
+	}
+
+	valuesKey := m.moduleValuesKey()
+	res := utils.Values{
+		"global":  map[string]interface{}{},
+		valuesKey: map[string]interface{}{},
+	}
+
+	for key, value := range values {
+		switch key {
+		case "global", valuesKey:
+			res[key] = value
+		default:
+			rlog.Debugf("Module '%s': key '%s' is not passed to chart in '%s' values layout", m.Name, key, ChartValuesLayout)
+		}
+	}
+
+	return res
+}
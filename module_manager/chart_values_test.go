@@ -0,0 +1,29 @@
+package module_manager
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/flant/antiopa/utils"
+)
+
+func TestModule_chartValues(t *testing.T) {
+	m := &Module{Name: "nginx-ingress"}
+	values := utils.Values{
+		"global":       map[string]interface{}{"clusterName": "main"},
+		"nginxIngress": map[string]interface{}{"replicas": 2},
+		"prometheus":   map[string]interface{}{"retention": "7d"},
+	}
+
+	assert.Equal(t, values, m.chartValues(values))
+
+	ChartValuesLayout = ChartValuesLayoutHelm
+	defer func() {
+		ChartValuesLayout = ChartValuesLayoutLegacy
+	}()
+	assert.Equal(t, utils.Values{
+		"global":       map[string]interface{}{"clusterName": "main"},
+		"nginxIngress": map[string]interface{}{"replicas": 2},
+	}, m.chartValues(values))
+}
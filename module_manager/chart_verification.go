@@ -0,0 +1,128 @@
+package module_manager
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"github.com/romana/rlog"
+
+	"github.com/flant/antiopa/executor"
+	"github.com/flant/antiopa/helm"
+)
+
+const (
+	ChartVerificationOff     = "off"
+	ChartVerificationWarn    = "warn"
+	ChartVerificationEnforce = "enforce"
+)
+
+// ChartVerification is a mode of signature verification for chart archives in the charts
+// directory of modules. Archives are fetched from external repositories by helm dependency,
+// so they are verified before release is installed:
+// - archive.tgz.prov is checked by 'helm verify' with ChartVerificationKeyring;
+// - archive.tgz.sig is checked by 'cosign verify-blob' with ChartVerificationCosignKey.
+// Unsigned archives and failed checks fail the module run in enforce mode and are logged in warn mode.
+var ChartVerification = ChartVerificationOff
+
+// ChartVerificationKeyring is a path to the public keyring for provenance files
+var ChartVerificationKeyring string
+
+// ChartVerificationCosignKey is a path or KMS URI of the public key for cosign signatures
+var ChartVerificationCosignKey string
+
+// CosignBinPath is a path to cosign binary
+var CosignBinPath = "cosign"
+
+func checkChartVerification() error {
+	switch ChartVerification {
+	case ChartVerificationOff:
+		return nil
+	case ChartVerificationWarn, ChartVerificationEnforce:
+		if ChartVerificationKeyring == "" && ChartVerificationCosignKey == "" {
+			return fmt.Errorf("keyring or cosign key is required for mode '%s'", ChartVerification)
+		}
+		return nil
+	}
+	return fmt.Errorf("unknown mode '%s', expected '%s', '%s' or '%s'", ChartVerification, ChartVerificationEnforce, ChartVerificationWarn, ChartVerificationOff)
+}
+
+// chartArchives returns chart archives from the charts directory of the chart
+func chartArchives(chartPath string) ([]string, error) {
+	chartsDir := filepath.Join(chartPath, "charts")
+	files, err := ioutil.ReadDir(chartsDir)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	archives := make([]string, 0)
+	for _, file := range files {
+		if file.IsDir() || !strings.HasSuffix(file.Name(), ".tgz") {
+			continue
+		}
+		archives = append(archives, filepath.Join(chartsDir, file.Name()))
+	}
+	return archives, nil
+}
+
+// verifyChartArchive checks provenance file or cosign signature of the chart archive
+func verifyChartArchive(helmClient helm.HelmClient, archive string) error {
+	if _, err := os.Stat(archive + ".prov"); err == nil {
+		if ChartVerificationKeyring == "" {
+			return fmt.Errorf("'%s' has provenance file, but keyring is not set", filepath.Base(archive))
+		}
+		_, stderr, err := helmClient.Cmd("verify", "--keyring", ChartVerificationKeyring, archive)
+		if err != nil {
+			return fmt.Errorf("'%s' provenance verification failed: %s: %s", filepath.Base(archive), err, stderr)
+		}
+		return nil
+	}
+
+	if _, err := os.Stat(archive + ".sig"); err == nil {
+		if ChartVerificationCosignKey == "" {
+			return fmt.Errorf("'%s' has cosign signature, but cosign key is not set", filepath.Base(archive))
+		}
+		cmd := exec.Command(CosignBinPath, "verify-blob", "--key", ChartVerificationCosignKey, "--signature", archive+".sig", archive)
+		var stderr bytes.Buffer
+		cmd.Stderr = &stderr
+		if _, err := executor.Output(cmd); err != nil {
+			return fmt.Errorf("'%s' cosign verification failed: %s: %s", filepath.Base(archive), err, strings.TrimSpace(stderr.String()))
+		}
+		return nil
+	}
+
+	return fmt.Errorf("'%s' is not signed: no provenance file or cosign signature", filepath.Base(archive))
+}
+
+// verifyChart checks signatures of chart archives of the module according to ChartVerification mode
+func (m *Module) verifyChart(helmClient helm.HelmClient, chartPath string) error {
+	if ChartVerification == ChartVerificationOff {
+		return nil
+	}
+
+	archives, err := chartArchives(chartPath)
+	if err != nil {
+		return fmt.Errorf("cannot list chart archives: %s", err)
+	}
+
+	for _, archive := range archives {
+		err := verifyChartArchive(helmClient, archive)
+		if err == nil {
+			rlog.Debugf("MODULE_RUN '%s': chart '%s' is verified", m.Name, filepath.Base(archive))
+			continue
+		}
+		if ChartVerification == ChartVerificationEnforce {
+			return fmt.Errorf("chart verification: %s", err)
+		}
+		rlog.Warnf("MODULE_RUN '%s': chart verification: %s", m.Name, err)
+	}
+
+	return nil
+}
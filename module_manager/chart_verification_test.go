@@ -0,0 +1,79 @@
+package module_manager
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/flant/antiopa/helm"
+)
+
+type verifyHelmClient struct {
+	helm.HelmClient
+	verified []string
+}
+
+func (h *verifyHelmClient) Cmd(args ...string) (string, string, error) {
+	archive := args[len(args)-1]
+	if filepath.Base(archive) == "bad-0.1.0.tgz" {
+		return "", "openpgp: signature made by unknown entity", fmt.Errorf("exit status 1")
+	}
+	h.verified = append(h.verified, filepath.Base(archive))
+	return "", "", nil
+}
+
+func TestModule_VerifyChart(t *testing.T) {
+	chartDir, err := ioutil.TempDir("", "chart-verification")
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer os.RemoveAll(chartDir)
+	defer func() { ChartVerification, ChartVerificationKeyring = ChartVerificationOff, "" }()
+
+	os.MkdirAll(filepath.Join(chartDir, "charts"), 0755)
+	for _, name := range []string{"redis-1.0.0.tgz", "redis-1.0.0.tgz.prov"} {
+		ioutil.WriteFile(filepath.Join(chartDir, "charts", name), []byte("data"), 0644)
+	}
+
+	m := &Module{Name: "cache"}
+	helmClient := &verifyHelmClient{}
+	ChartVerificationKeyring = "/etc/antiopa/pubring.gpg"
+
+	ChartVerification = ChartVerificationEnforce
+	assert.NoError(t, m.verifyChart(helmClient, chartDir))
+	assert.Equal(t, []string{"redis-1.0.0.tgz"}, helmClient.verified)
+
+	// failed provenance check
+	for _, name := range []string{"bad-0.1.0.tgz", "bad-0.1.0.tgz.prov"} {
+		ioutil.WriteFile(filepath.Join(chartDir, "charts", name), []byte("data"), 0644)
+	}
+	assert.Error(t, m.verifyChart(helmClient, chartDir))
+
+	ChartVerification = ChartVerificationWarn
+	assert.NoError(t, m.verifyChart(helmClient, chartDir))
+
+	// unsigned archive
+	os.Remove(filepath.Join(chartDir, "charts", "bad-0.1.0.tgz.prov"))
+	ChartVerification = ChartVerificationEnforce
+	assert.Error(t, m.verifyChart(helmClient, chartDir))
+
+	ChartVerification = ChartVerificationOff
+	assert.NoError(t, m.verifyChart(helmClient, chartDir))
+}
+
+func TestCheckChartVerification(t *testing.T) {
+	defer func() { ChartVerification, ChartVerificationKeyring = ChartVerificationOff, "" }()
+
+	ChartVerification = "strict"
+	assert.Error(t, checkChartVerification())
+
+	ChartVerification = ChartVerificationEnforce
+	assert.Error(t, checkChartVerification())
+
+	ChartVerificationKeyring = "/etc/antiopa/pubring.gpg"
+	assert.NoError(t, checkChartVerification())
+}
@@ -0,0 +1,129 @@
+package module_manager
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"gopkg.in/yaml.v2"
+)
+
+// ChangelogFileName is a changelog in the module directory. Excerpt for the new chart version
+// is a section with the version in the heading.
+const ChangelogFileName = "CHANGELOG.md"
+
+// Lines of changelog section in the excerpt
+var ChangelogExcerptMaxLines = 30
+
+// ChartVersionChange is a change of version in Chart.yaml of the module since the previous
+// successful run. Versions are kept in memory, so the first run after restart detects nothing.
+type ChartVersionChange struct {
+	PreviousVersion string `json:"previousVersion"`
+	Version         string `json:"version"`
+	// section of CHANGELOG.md for the new version, empty if there is no changelog
+	Changelog string `json:"changelog,omitempty"`
+}
+
+// chartMetadata is a part of Chart.yaml
+type chartMetadata struct {
+	Name    string `yaml:"name"`
+	Version string `yaml:"version"`
+}
+
+// readChartMetadata returns metadata from Chart.yaml of the module or empty metadata if module has no chart
+func readChartMetadata(modulePath string) (*chartMetadata, error) {
+	chart := &chartMetadata{}
+
+	data, err := ioutil.ReadFile(filepath.Join(modulePath, "Chart.yaml"))
+	if os.IsNotExist(err) {
+		return chart, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if err := yaml.Unmarshal(data, chart); err != nil {
+		return nil, fmt.Errorf("bad Chart.yaml: %s", err)
+	}
+	return chart, nil
+}
+
+// chartVersion returns version from Chart.yaml of the module or empty string if module has no chart
+func chartVersion(modulePath string) (string, error) {
+	chart, err := readChartMetadata(modulePath)
+	if err != nil {
+		return "", err
+	}
+	return chart.Version, nil
+}
+
+// changelogExcerpt returns a section of the changelog from the heading with the version
+// until the next heading of the same or upper level
+func changelogExcerpt(data []byte, version string) string {
+	lines := make([]string, 0)
+	level := 0
+	scanner := bufio.NewScanner(bytes.NewReader(data))
+	for scanner.Scan() {
+		line := scanner.Text()
+		headingLevel := len(line) - len(strings.TrimLeft(line, "#"))
+
+		if level == 0 {
+			if headingLevel > 0 && containsVersion(line[headingLevel:], version) {
+				level = headingLevel
+				lines = append(lines, line)
+			}
+			continue
+		}
+		if headingLevel > 0 && headingLevel <= level {
+			break
+		}
+		if len(lines) == ChangelogExcerptMaxLines {
+			lines = append(lines, "...")
+			break
+		}
+		lines = append(lines, line)
+	}
+	return strings.TrimSpace(strings.Join(lines, "\n"))
+}
+
+// containsVersion returns true if heading has the version as a separate word, e.g. '[1.2.0] - 2019-01-01'
+func containsVersion(heading string, version string) bool {
+	words := strings.FieldsFunc(heading, func(r rune) bool {
+		return strings.ContainsRune(" \t[]()-:", r)
+	})
+	for _, word := range words {
+		if strings.TrimPrefix(word, "v") == strings.TrimPrefix(version, "v") {
+			return true
+		}
+	}
+	return false
+}
+
+// updateChartVersion saves the version of the module chart after successful run
+// and returns a change since the previous run or nil
+func (m *Module) updateChartVersion() (*ChartVersionChange, error) {
+	version, err := chartVersion(m.Path)
+	if err != nil {
+		return nil, err
+	}
+	previousVersion := m.lastRunChartVersion
+	m.lastRunChartVersion = version
+	if previousVersion == "" || version == "" || previousVersion == version {
+		return nil, nil
+	}
+
+	change := &ChartVersionChange{PreviousVersion: previousVersion, Version: version}
+	if data, err := ioutil.ReadFile(filepath.Join(m.Path, ChangelogFileName)); err == nil {
+		change.Changelog = changelogExcerpt(data, version)
+	}
+	return change, nil
+}
+
+// LastRunChartVersionChange returns a change of the chart version detected in the last run of the module or nil
+func (m *Module) LastRunChartVersionChange() *ChartVersionChange {
+	return m.lastRunChartVersionChange
+}
@@ -0,0 +1,59 @@
+package module_manager
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestChangelogExcerpt(t *testing.T) {
+	changelog := []byte(`# Changelog
+
+## [1.3.0] - 2019-03-01
+### Added
+- new parameter
+
+## [1.2.0] - 2019-02-01
+- fix
+`)
+
+	assert.Equal(t, "## [1.3.0] - 2019-03-01\n### Added\n- new parameter", changelogExcerpt(changelog, "1.3.0"))
+	assert.Equal(t, "## [1.2.0] - 2019-02-01\n- fix", changelogExcerpt(changelog, "v1.2.0"))
+	assert.Equal(t, "", changelogExcerpt(changelog, "1.3"))
+}
+
+func TestModule_updateChartVersion(t *testing.T) {
+	dir, err := ioutil.TempDir("", "chart-version")
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer os.RemoveAll(dir)
+
+	writeChart := func(version string) {
+		assert.NoError(t, ioutil.WriteFile(filepath.Join(dir, "Chart.yaml"), []byte("name: test\nversion: "+version+"\n"), 0644))
+	}
+	assert.NoError(t, ioutil.WriteFile(filepath.Join(dir, ChangelogFileName), []byte("## 0.2.0\n- changed\n"), 0644))
+
+	m := &Module{Name: "test", Path: dir}
+
+	writeChart("0.1.0")
+	change, err := m.updateChartVersion()
+	assert.NoError(t, err)
+	assert.Nil(t, change)
+
+	writeChart("0.2.0")
+	change, err = m.updateChartVersion()
+	assert.NoError(t, err)
+	if assert.NotNil(t, change) {
+		assert.Equal(t, "0.1.0", change.PreviousVersion)
+		assert.Equal(t, "0.2.0", change.Version)
+		assert.Equal(t, "## 0.2.0\n- changed", change.Changelog)
+	}
+
+	change, err = m.updateChartVersion()
+	assert.NoError(t, err)
+	assert.Nil(t, change)
+}
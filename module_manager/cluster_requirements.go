@@ -0,0 +1,154 @@
+package module_manager
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+
+	"k8s.io/apimachinery/pkg/api/resource"
+
+	"github.com/flant/antiopa/kube"
+)
+
+// ClusterRequirements in module.yaml are minimal size of the cluster for the module, e.g.
+// a distributed storage that needs 3 nodes. Capacity of schedulable nodes is discovered
+// with platforms of nodes, modules are disabled while the cluster is smaller.
+type ClusterRequirements struct {
+	// minimal number of schedulable nodes
+	Nodes int `yaml:"nodes"`
+	// minimal allocatable CPU of all schedulable nodes, e.g. "8" or "7500m"
+	CPU string `yaml:"cpu"`
+	// minimal allocatable memory of all schedulable nodes, e.g. "16Gi"
+	Memory string `yaml:"memory"`
+
+	cpu    int64
+	memory int64
+}
+
+func (r *ClusterRequirements) init() error {
+	if r.Nodes < 0 {
+		return fmt.Errorf("nodes should be positive, got %d", r.Nodes)
+	}
+	if r.CPU != "" {
+		quantity, err := resource.ParseQuantity(r.CPU)
+		if err != nil {
+			return fmt.Errorf("bad cpu '%s': %s", r.CPU, err)
+		}
+		r.cpu = quantity.MilliValue()
+	}
+	if r.Memory != "" {
+		quantity, err := resource.ParseQuantity(r.Memory)
+		if err != nil {
+			return fmt.Errorf("bad memory '%s': %s", r.Memory, err)
+		}
+		r.memory = quantity.Value()
+	}
+	return nil
+}
+
+func (r *ClusterRequirements) empty() bool {
+	return r.Nodes == 0 && r.CPU == "" && r.Memory == ""
+}
+
+// unmet returns descriptions of requirements that are not satisfied by the capacity
+func (r *ClusterRequirements) unmet(capacity kube.ClusterCapacity) []string {
+	res := make([]string, 0)
+	if capacity.Nodes < r.Nodes {
+		res = append(res, fmt.Sprintf("%d nodes required, cluster has %d", r.Nodes, capacity.Nodes))
+	}
+	if capacity.CPU < r.cpu {
+		res = append(res, fmt.Sprintf("%s CPU required, cluster has %dm", r.CPU, capacity.CPU))
+	}
+	if capacity.Memory < r.memory {
+		res = append(res, fmt.Sprintf("%s memory required, cluster has %dMi", r.Memory, capacity.Memory/(1024*1024)))
+	}
+	return res
+}
+
+// Conditions of modules that are enabled by config but skipped at discovery
+const (
+	ModuleSkipUnsupportedPlatforms    = "UnsupportedPlatforms"
+	ModuleSkipInsufficientClusterSize = "InsufficientClusterSize"
+)
+
+// ModuleSkip explains why the module enabled by config is disabled at the last discovery
+type ModuleSkip struct {
+	Module    string `json:"module"`
+	Condition string `json:"condition"`
+	Message   string `json:"message"`
+}
+
+// clusterCapacity is capacity of nodes from the last discovery, nil if it is not discovered
+type clusterCapacity struct {
+	m        sync.Mutex
+	capacity *kube.ClusterCapacity
+}
+
+func (c *clusterCapacity) set(capacity *kube.ClusterCapacity) {
+	c.m.Lock()
+	c.capacity = capacity
+	c.m.Unlock()
+}
+
+func (c *clusterCapacity) get() *kube.ClusterCapacity {
+	c.m.Lock()
+	defer c.m.Unlock()
+	return c.capacity
+}
+
+// moduleSkips are skipped modules from the last discovery by module name
+type moduleSkips struct {
+	m     sync.Mutex
+	skips map[string]ModuleSkip
+}
+
+func (s *moduleSkips) set(skips map[string]ModuleSkip) {
+	s.m.Lock()
+	s.skips = skips
+	s.m.Unlock()
+}
+
+func (s *moduleSkips) list() []ModuleSkip {
+	s.m.Lock()
+	defer s.m.Unlock()
+	res := make([]ModuleSkip, 0, len(s.skips))
+	for _, skip := range s.skips {
+		res = append(res, skip)
+	}
+	sort.Slice(res, func(i, j int) bool {
+		return res[i].Module < res[j].Module
+	})
+	return res
+}
+
+// hasClusterRequirements returns true if some module has requirements in module.yaml
+func (mm *MainModuleManager) hasClusterRequirements() bool {
+	for _, module := range mm.allModulesByName {
+		if module.Definition != nil && !module.Definition.Requirements.empty() {
+			return true
+		}
+	}
+	return false
+}
+
+// ClusterCapacity returns capacity of nodes from the last discovery
+func (mm *MainModuleManager) ClusterCapacity() *kube.ClusterCapacity {
+	return mm.clusterCapacity.get()
+}
+
+// SkippedModules returns modules that are enabled by config but disabled at the last discovery
+// because of unsupported platforms or insufficient cluster size
+func (mm *MainModuleManager) SkippedModules() []ModuleSkip {
+	return mm.moduleSkips.list()
+}
+
+// UnmetClusterRequirements returns a message with requirements from module.yaml that are not
+// satisfied by the capacity. Empty message is returned if capacity is not discovered. Modules
+// released into remote clusters are not checked: nodes are discovered only in the cluster of antiopa.
+func (m *Module) UnmetClusterRequirements(capacity *kube.ClusterCapacity) string {
+	if m.Definition == nil || m.Definition.Requirements.empty() || m.Definition.Cluster != "" || capacity == nil {
+		return ""
+	}
+	return strings.Join(m.Definition.Requirements.unmet(*capacity), ", ")
+}
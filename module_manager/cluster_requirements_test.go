@@ -0,0 +1,61 @@
+package module_manager
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/resource"
+	"k8s.io/client-go/kubernetes/fake"
+
+	"github.com/flant/antiopa/kube"
+)
+
+func newSizedNode(name string, cpu string, memory string, unschedulable bool) *v1.Node {
+	node := newPlatformNode(name, "linux", "amd64", nil)
+	node.Spec.Unschedulable = unschedulable
+	node.Status.Allocatable = v1.ResourceList{
+		v1.ResourceCPU:    resource.MustParse(cpu),
+		v1.ResourceMemory: resource.MustParse(memory),
+	}
+	return node
+}
+
+func TestModule_UnmetClusterRequirements(t *testing.T) {
+	defer func(c kube.Client) { kube.KubernetesClient = c }(kube.KubernetesClient)
+	defer func(d bool) { NodePlatformsDiscovery = d }(NodePlatformsDiscovery)
+	NodePlatformsDiscovery = false
+	kube.KubernetesClient = fake.NewSimpleClientset(
+		newSizedNode("a", "4", "8Gi", false),
+		newSizedNode("b", "3500m", "8Gi", false),
+		newSizedNode("c", "4", "8Gi", true),
+	)
+
+	m := &Module{Name: "ceph", Definition: NewModuleDefinition()}
+	m.Definition.Requirements = ClusterRequirements{Nodes: 3, CPU: "6", Memory: "32Gi"}
+	if !assert.NoError(t, m.Definition.validate()) {
+		return
+	}
+
+	mm := NewMainModuleManager(&MockHelmClient{}, nil)
+	mm.allModulesByName = map[string]*Module{m.Name: m}
+	// capacity is not discovered
+	assert.Equal(t, "", m.UnmetClusterRequirements(mm.ClusterCapacity()))
+
+	mm.refreshNodePlatforms()
+	assert.Nil(t, mm.NodePlatforms())
+	assert.Equal(t, &kube.ClusterCapacity{Nodes: 2, CPU: 7500, Memory: 16 * 1024 * 1024 * 1024}, mm.ClusterCapacity())
+	assert.Equal(t, "3 nodes required, cluster has 2, 32Gi memory required, cluster has 16384Mi", m.UnmetClusterRequirements(mm.ClusterCapacity()))
+
+	m.Definition.Requirements = ClusterRequirements{Nodes: 2, CPU: "7500m"}
+	assert.NoError(t, m.Definition.validate())
+	assert.Equal(t, "", m.UnmetClusterRequirements(mm.ClusterCapacity()))
+
+	// nodes of remote cluster are not known
+	m.Definition.Requirements = ClusterRequirements{Nodes: 5}
+	m.Definition.Cluster = "edge"
+	assert.Equal(t, "", m.UnmetClusterRequirements(mm.ClusterCapacity()))
+
+	m.Definition.Requirements = ClusterRequirements{Memory: "lots"}
+	assert.Error(t, m.Definition.Requirements.init())
+}
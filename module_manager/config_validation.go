@@ -0,0 +1,41 @@
+package module_manager
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/flant/antiopa/kube_config_manager"
+)
+
+// ValidateConfigData checks data of the antiopa ConfigMap before it is applied: sections should be
+// valid yaml and effective values of modules enabled by the config should match their values schemas.
+// Values storage is copied, so state of antiopa is not changed. It is used by the validating webhook.
+func (mm *MainModuleManager) ValidateConfigData(configData map[string]string) error {
+	config, err := kube_config_manager.NewConfigFromConfigData(configData)
+	if err != nil {
+		return err
+	}
+
+	enabledByConfig, modulesConfigValues, _ := mm.calculateEnabledModulesByConfig(config.ModuleConfigs, config.Values)
+
+	storage := mm.valuesStorage.Copy()
+	storage.SetKubeGlobalConfigValues(config.Values)
+	storage.SetKubeModulesConfigValues(modulesConfigValues)
+	storage.SetGroupsValues(config.GroupsValues)
+
+	errs := make([]string, 0)
+	for _, name := range enabledByConfig {
+		module := mm.allModulesByName[name]
+		if module == nil || module.ValuesSchema == nil {
+			continue
+		}
+		values, _ := module.constructValuesFrom(storage, mm.enabledModulesInOrder)
+		if err := validateValuesBySchema(module.moduleValuesKey(), values[module.moduleValuesKey()], module.ValuesSchema); err != nil {
+			errs = append(errs, fmt.Sprintf("module '%s': %s", name, err))
+		}
+	}
+	if len(errs) > 0 {
+		return fmt.Errorf("%s", strings.Join(errs, "; "))
+	}
+	return nil
+}
@@ -0,0 +1,36 @@
+package module_manager
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/flant/antiopa/utils"
+)
+
+func TestMainModuleManager_ValidateConfigData(t *testing.T) {
+	mm := NewMainModuleManager(&MockHelmClient{}, nil)
+	module := &Module{Name: "dex", moduleManager: mm, StaticConfig: utils.NewModuleConfig("dex")}
+	module.StaticConfig.IsEnabled = true
+	module.ValuesSchema = utils.Values{
+		"type":     "object",
+		"required": []interface{}{"host"},
+		"properties": map[string]interface{}{
+			"host":     map[string]interface{}{"type": "string"},
+			"replicas": map[string]interface{}{"type": "integer"},
+		},
+	}
+	mm.allModulesByName["dex"] = module
+	mm.allModulesNamesInOrder = []string{"dex"}
+
+	assert.NoError(t, mm.ValidateConfigData(map[string]string{"dex": "host: dex.example.com\n"}))
+	// disabled module is not validated
+	assert.NoError(t, mm.ValidateConfigData(map[string]string{"dex": "false\n"}))
+
+	err := mm.ValidateConfigData(map[string]string{"dex": "host: dex.example.com\nreplicas: two\n"})
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), "module 'dex'")
+	}
+	assert.Error(t, mm.ValidateConfigData(map[string]string{"dex": "{bad yaml\n"}))
+	assert.Error(t, mm.ValidateConfigData(map[string]string{}))
+}
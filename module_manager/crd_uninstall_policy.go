@@ -0,0 +1,137 @@
+package module_manager
+
+import (
+	"fmt"
+
+	"github.com/flant/antiopa/helm"
+)
+
+// CRDUninstallPolicy is how CRDs of the module release are handled when the module is deleted.
+// helm delete deletes CRDs of the release manifest and custom resources of users with them,
+// so with other policies CRDs get 'keep' resource policy on helm upgrade and are deleted by antiopa.
+type CRDUninstallPolicy string
+
+const (
+	// CRDs are deleted by helm delete
+	CRDUninstallHelm CRDUninstallPolicy = ""
+	// CRDs are kept after the release is deleted
+	CRDUninstallKeep CRDUninstallPolicy = "keep"
+	// CRDs without custom resources are deleted after the release, CRDs with custom resources are kept
+	CRDUninstallDeleteIfUnused CRDUninstallPolicy = "delete-if-unused"
+	// release is not deleted while custom resources of its CRDs exist, then CRDs are deleted
+	CRDUninstallBlock CRDUninstallPolicy = "block"
+)
+
+func (p CRDUninstallPolicy) validate() error {
+	switch p {
+	case CRDUninstallHelm, CRDUninstallKeep, CRDUninstallDeleteIfUnused, CRDUninstallBlock:
+		return nil
+	}
+	return fmt.Errorf("unsupported crdUninstallPolicy '%s', expected '%s', '%s' or '%s'", p, CRDUninstallKeep, CRDUninstallDeleteIfUnused, CRDUninstallBlock)
+}
+
+// CRDUninstallPolicy returns crdUninstallPolicy from module.yaml
+func (m *Module) CRDUninstallPolicy() CRDUninstallPolicy {
+	if m.Definition == nil {
+		return CRDUninstallHelm
+	}
+	return m.Definition.CRDUninstallPolicy
+}
+
+// prepareCRDsUninstall is called before helm delete of the release. It returns ErrCustomResourcesExist
+// for 'block' policy if CRDs have custom resources. Releases installed before the policy is set have
+// CRDs without 'keep' resource policy, such release is upgraded with the annotated manifest first.
+// CRDs of the release are returned for deleteUnusedCRDs.
+func (m *Module) prepareCRDsUninstall(helmClient helm.HelmClient, helmReleaseName string) ([]helm.CustomResourceDefinition, error) {
+	policy := m.CRDUninstallPolicy()
+	if policy == CRDUninstallHelm {
+		return nil, nil
+	}
+
+	manifest, err := helmClient.GetReleaseManifest(helmReleaseName)
+	if err != nil {
+		return nil, err
+	}
+	crds, err := helm.ManifestCustomResourceDefinitions(manifest, false)
+	if err != nil || len(crds) == 0 {
+		return nil, err
+	}
+
+	if policy == CRDUninstallBlock {
+		used, err := countCustomResources(crds)
+		if err != nil {
+			return nil, err
+		}
+		if len(used) > 0 {
+			return nil, &ErrCustomResourcesExist{Module: m.Name, CustomResources: used}
+		}
+	}
+
+	unkept, err := helm.ManifestCustomResourceDefinitions(manifest, true)
+	if err != nil {
+		return nil, err
+	}
+	if len(unkept) > 0 {
+		keptManifest, err := helm.KeepCustomResourceDefinitions(manifest)
+		if err != nil {
+			return nil, err
+		}
+		runChartPath, err := m.prepareRunChart()
+		if err != nil {
+			return nil, err
+		}
+		chartPath, err := m.prepareStaticChart(helmReleaseName, runChartPath, keptManifest)
+		if err != nil {
+			return nil, err
+		}
+		if _, err := helmClient.UpgradeRelease(helmReleaseName, chartPath, []string{}, []helm.SetValue{}, helmClient.TillerNamespace(), false); err != nil {
+			return nil, fmt.Errorf("cannot set resource policy of CRDs before delete of release '%s': %s", helmReleaseName, err)
+		}
+		m.log(ModuleLogSourceHelm).Infof("delete: %d CRDs of helm release '%s' get 'keep' resource policy", len(unkept), helmReleaseName)
+	}
+
+	return crds, nil
+}
+
+// deleteUnusedCRDs is called after helm delete of the release: CRDs without custom resources
+// are deleted for 'delete-if-unused' and 'block' policies. Custom resources could be created
+// after the check of 'block' policy, CRDs with them are kept.
+func (m *Module) deleteUnusedCRDs(crds []helm.CustomResourceDefinition) error {
+	if m.CRDUninstallPolicy() == CRDUninstallKeep {
+		for _, crd := range crds {
+			m.log(ModuleLogSourceHelm).Infof("delete: %s is kept by crdUninstallPolicy", crd.Resource)
+		}
+		return nil
+	}
+
+	for _, crd := range crds {
+		count, err := helm.CountCustomResources(crd)
+		if err != nil {
+			return err
+		}
+		if count > 0 {
+			m.log(ModuleLogSourceHelm).Warnf("delete: %s has %d custom resources, it is kept", crd.Resource, count)
+			continue
+		}
+		if err := helm.DeleteResources([]helm.ReleaseResource{crd.Resource}); err != nil {
+			return err
+		}
+		m.log(ModuleLogSourceHelm).Infof("delete: %s without custom resources is deleted", crd.Resource)
+	}
+	return nil
+}
+
+// countCustomResources returns the number of custom resources of CRDs that have them
+func countCustomResources(crds []helm.CustomResourceDefinition) (map[string]int, error) {
+	res := make(map[string]int)
+	for _, crd := range crds {
+		count, err := helm.CountCustomResources(crd)
+		if err != nil {
+			return nil, err
+		}
+		if count > 0 {
+			res[crd.Resource.Name] = count
+		}
+	}
+	return res, nil
+}
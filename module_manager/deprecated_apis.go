@@ -0,0 +1,39 @@
+package module_manager
+
+import (
+	"github.com/romana/rlog"
+
+	"github.com/flant/antiopa/helm"
+	"github.com/flant/antiopa/kube"
+)
+
+// DeprecatedApis returns objects of the release manifest with API versions deprecated
+// in the cluster after the last run of the module
+func (m *Module) DeprecatedApis() []helm.DeprecatedApiUsage {
+	m.resourcesMutex.Lock()
+	defer m.resourcesMutex.Unlock()
+	return m.deprecatedApis
+}
+
+// updateDeprecatedApis checks the release manifest against the apiserver version and warns
+// about deprecated API versions in the module log. Errors are logged: the check should not
+// fail the module run.
+func (m *Module) updateDeprecatedApis(manifest string) {
+	serverVersion, err := kube.ServerGitVersion()
+	if err != nil {
+		rlog.Debugf("MODULE_RUN '%s': cannot get apiserver version to check deprecated APIs: %s", m.Name, err)
+		return
+	}
+	usages, err := helm.FindDeprecatedApis(manifest, serverVersion)
+	if err != nil {
+		rlog.Errorf("MODULE_RUN '%s': cannot check deprecated APIs of release: %s", m.Name, err)
+		return
+	}
+	for _, usage := range usages {
+		m.log(ModuleLogSourceHelm).Warnf("%s", usage)
+	}
+
+	m.resourcesMutex.Lock()
+	m.deprecatedApis = usages
+	m.resourcesMutex.Unlock()
+}
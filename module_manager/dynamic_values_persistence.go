@@ -0,0 +1,153 @@
+package module_manager
+
+import (
+	"encoding/json"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/romana/rlog"
+
+	"github.com/flant/antiopa/state_store"
+	"github.com/flant/antiopa/utils"
+)
+
+// DynamicValuesSecretName is a Secret to persist dynamic values from hooks between restarts
+// of antiopa pod. Dynamic values are kept only in memory if name is empty.
+var DynamicValuesSecretName string
+
+// DynamicValuesFlushInterval is a period of writing changed dynamic values into the Secret.
+// Changes of hooks are batched, so dynamic values of the last interval can be lost on crash.
+var DynamicValuesFlushInterval = 5 * time.Second
+
+const dynamicValuesSecretKey = "dynamic-values.json"
+
+const DynamicValuesFormat = "antiopa-dynamic-values/v1"
+
+// dynamicValuesState is a content of the Secret. The whole state is written
+// with one update, so the Secret has either an old or a new state.
+type dynamicValuesState struct {
+	Format                      string                         `json:"format"`
+	GlobalDynamicValuesPatches  []utils.ValuesPatch            `json:"globalDynamicValuesPatches"`
+	ModulesDynamicValuesPatches map[string][]utils.ValuesPatch `json:"modulesDynamicValuesPatches"`
+}
+
+// dynamicValuesPersistence writes dynamic values into the store when generation of dynamic values is changed
+type dynamicValuesPersistence struct {
+	m               sync.Mutex
+	store           state_store.Store
+	savedGeneration uint64
+}
+
+func (mm *MainModuleManager) initDynamicValuesPersistence() error {
+	if DynamicValuesSecretName == "" {
+		return nil
+	}
+	if DynamicValuesFlushInterval <= 0 {
+		return fmt.Errorf("dynamic values flush interval should be positive")
+	}
+	store, err := state_store.New(state_store.KindSecret, DynamicValuesSecretName, dynamicValuesSecretKey)
+	if err != nil {
+		return err
+	}
+	return mm.restoreDynamicValues(store)
+}
+
+// restoreDynamicValues loads dynamic values saved by the previous antiopa pod. Patches are
+// checked against the current values: static values or ConfigMap can be changed during restart.
+// Patches of unknown modules and patches that do not apply are dropped.
+func (mm *MainModuleManager) restoreDynamicValues(store state_store.Store) error {
+	data, err := store.Load()
+	if err != nil {
+		return fmt.Errorf("cannot load dynamic values: %s", err)
+	}
+
+	mm.dynamicValuesPersistence = &dynamicValuesPersistence{store: store}
+	if data == nil {
+		return nil
+	}
+
+	var state dynamicValuesState
+	if err := json.Unmarshal(data, &state); err != nil {
+		return fmt.Errorf("bad saved dynamic values: %s", err)
+	}
+	if state.Format != DynamicValuesFormat {
+		return fmt.Errorf("unsupported dynamic values format '%s', expected '%s'", state.Format, DynamicValuesFormat)
+	}
+
+	globalHook := &GlobalHook{Hook: &Hook{moduleManager: mm}}
+	globalPatches := applicablePatches(globalHook.values(), state.GlobalDynamicValuesPatches, "global")
+	mm.valuesStorage.SetGlobalDynamicValuesPatches(globalPatches)
+
+	for moduleName, patches := range state.ModulesDynamicValuesPatches {
+		module, hasModule := mm.allModulesByName[moduleName]
+		if !hasModule {
+			rlog.Warnf("MODULE_MANAGER dynamic values: drop values of unknown module '%s'", moduleName)
+			continue
+		}
+		mm.valuesStorage.SetModuleDynamicValuesPatches(moduleName, applicablePatches(module.values(), patches, moduleName))
+	}
+
+	// restored state is saved again by the first flush without dropped patches
+	rlog.Infof("MODULE_MANAGER dynamic values are restored from %s", store)
+	return nil
+}
+
+// applicablePatches returns patches that apply to values one by one. Patches after
+// the failed one are dropped, they can depend on the failed patch.
+func applicablePatches(values utils.Values, patches []utils.ValuesPatch, owner string) []utils.ValuesPatch {
+	var err error
+	for i, patch := range patches {
+		values, _, err = utils.ApplyValuesPatch(values, patch)
+		if err != nil {
+			rlog.Warnf("MODULE_MANAGER dynamic values: drop %d patches of '%s', patch does not apply: %s", len(patches)-i, owner, err)
+			return patches[:i]
+		}
+	}
+	return patches
+}
+
+// FlushDynamicValues saves dynamic values if they are changed since the last save
+func (mm *MainModuleManager) FlushDynamicValues() error {
+	p := mm.dynamicValuesPersistence
+	if p == nil {
+		return nil
+	}
+	p.m.Lock()
+	defer p.m.Unlock()
+
+	globalPatches, modulesPatches, generation := mm.valuesStorage.DynamicValuesPatches()
+	if generation == p.savedGeneration {
+		return nil
+	}
+
+	data, err := json.Marshal(dynamicValuesState{
+		Format:                      DynamicValuesFormat,
+		GlobalDynamicValuesPatches:  globalPatches,
+		ModulesDynamicValuesPatches: modulesPatches,
+	})
+	if err != nil {
+		return err
+	}
+	if err := p.store.Save(data); err != nil {
+		return fmt.Errorf("cannot save dynamic values: %s", err)
+	}
+	p.savedGeneration = generation
+	rlog.Debugf("MODULE_MANAGER dynamic values are saved, generation %d", generation)
+	return nil
+}
+
+// runDynamicValuesPersistence saves changed dynamic values with interval
+func (mm *MainModuleManager) runDynamicValuesPersistence() {
+	if mm.dynamicValuesPersistence == nil {
+		return
+	}
+	go func() {
+		for {
+			time.Sleep(DynamicValuesFlushInterval)
+			if err := mm.FlushDynamicValues(); err != nil {
+				rlog.Errorf("MODULE_MANAGER %s", err)
+			}
+		}
+	}()
+}
@@ -0,0 +1,84 @@
+package module_manager
+
+import (
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/flant/antiopa/utils"
+)
+
+type memoryDynamicValuesStore struct {
+	data  []byte
+	saves int
+}
+
+func (s *memoryDynamicValuesStore) Load() ([]byte, error) {
+	return s.data, nil
+}
+
+func (s *memoryDynamicValuesStore) String() string {
+	return "memory"
+}
+
+func (s *memoryDynamicValuesStore) Save(data []byte) error {
+	s.data = data
+	s.saves++
+	return nil
+}
+
+func addPatch(path string, value interface{}) utils.ValuesPatch {
+	return utils.ValuesPatch{Operations: []*utils.ValuesPatchOperation{{Op: "add", Path: path, Value: value}}}
+}
+
+func TestDynamicValuesPersistence(t *testing.T) {
+	store := &memoryDynamicValuesStore{}
+
+	mm := NewMainModuleManager(&MockHelmClient{}, nil)
+	assert.NoError(t, mm.restoreDynamicValues(store))
+
+	// nothing is changed
+	assert.NoError(t, mm.FlushDynamicValues())
+	assert.Equal(t, 0, store.saves)
+
+	mm.valuesStorage.AppendGlobalDynamicValuesPatch(addPatch("/global/password", "secret"))
+	mm.valuesStorage.AppendModuleDynamicValuesPatch("dashboard", addPatch("/dashboard/clusterId", "abc"))
+	assert.NoError(t, mm.FlushDynamicValues())
+	assert.NoError(t, mm.FlushDynamicValues())
+	assert.Equal(t, 1, store.saves)
+
+	// restart
+	mm = NewMainModuleManager(&MockHelmClient{}, nil)
+	mm.allModulesByName["dashboard"] = &Module{Name: "dashboard", moduleManager: mm, StaticConfig: utils.NewModuleConfig("dashboard")}
+	assert.NoError(t, mm.restoreDynamicValues(store))
+
+	assert.Len(t, mm.valuesStorage.GlobalDynamicValuesPatches(), 1)
+	assert.Len(t, mm.valuesStorage.ModuleDynamicValuesPatches("dashboard"), 1)
+	assert.Equal(t, "abc", mm.allModulesByName["dashboard"].values()["dashboard"].(map[string]interface{})["clusterId"])
+}
+
+func TestDynamicValuesPersistence_DropInapplicable(t *testing.T) {
+	data, _ := json.Marshal(dynamicValuesState{
+		Format: DynamicValuesFormat,
+		GlobalDynamicValuesPatches: []utils.ValuesPatch{
+			addPatch("/global/password", "secret"),
+			addPatch("/global/absent/key", "value"),
+			addPatch("/global/other", "value"),
+		},
+		ModulesDynamicValuesPatches: map[string][]utils.ValuesPatch{
+			"unknown": {addPatch("/unknown/key", "value")},
+		},
+	})
+	store := &memoryDynamicValuesStore{data: data}
+
+	mm := NewMainModuleManager(&MockHelmClient{}, nil)
+	assert.NoError(t, mm.restoreDynamicValues(store))
+
+	assert.Len(t, mm.valuesStorage.GlobalDynamicValuesPatches(), 1)
+	assert.Len(t, mm.valuesStorage.ModuleDynamicValuesPatches("unknown"), 0)
+
+	// state without dropped patches is saved
+	assert.NoError(t, mm.FlushDynamicValues())
+	assert.Equal(t, 1, store.saves)
+}
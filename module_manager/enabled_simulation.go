@@ -0,0 +1,145 @@
+package module_manager
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"github.com/romana/rlog"
+
+	"github.com/flant/antiopa/kube_config_manager"
+	"github.com/flant/antiopa/utils"
+	"github.com/flant/antiopa/vault"
+)
+
+// EnabledSimulationEnv is set for enabled scripts run by SimulateEnabledModules,
+// scripts should not change anything in the cluster if it is set
+const EnabledSimulationEnv = "ANTIOPA_ENABLED_SIMULATION"
+
+const (
+	SimulatedEnabled           = "enabled"
+	SimulatedDisabledByConfig  = "disabled by config"
+	SimulatedDisabledPlatforms = "no nodes with supported platforms"
+	SimulatedDisabledCapacity  = "cluster is too small"
+	SimulatedDisabledByScript  = "disabled by enabled script"
+	SimulatedScriptError       = "enabled script failed"
+)
+
+// SimulatedModule is a state of the module with hypothetical config values
+type SimulatedModule struct {
+	Module  string `json:"module"`
+	Enabled bool   `json:"enabled"`
+	// module is enabled by the last discovery
+	CurrentlyEnabled bool   `json:"currentlyEnabled"`
+	Reason           string `json:"reason"`
+	Error            string `json:"error,omitempty"`
+}
+
+// EnabledModulesSimulation is a result of SimulateEnabledModules
+type EnabledModulesSimulation struct {
+	EnabledModules   []string          `json:"enabledModules"`
+	ModulesToEnable  []string          `json:"modulesToEnable"`
+	ModulesToDisable []string          `json:"modulesToDisable"`
+	UnknownModules   []string          `json:"unknownModules,omitempty"`
+	Modules          []SimulatedModule `json:"modules"`
+}
+
+// SimulateEnabledModules evaluates module flags and enabled scripts with the data
+// of the antiopa ConfigMap instead of the current config. Values storage is copied,
+// so state of antiopa is not changed. Enabled scripts are run with EnabledSimulationEnv.
+// Script errors are reported for the module and the module is considered as disabled.
+func (mm *MainModuleManager) SimulateEnabledModules(configData map[string]string) (*EnabledModulesSimulation, error) {
+	config, err := kube_config_manager.NewConfigFromConfigData(configData)
+	if err != nil {
+		return nil, err
+	}
+
+	enabledByConfig, modulesConfigValues, unknown := mm.calculateEnabledModulesByConfig(config.ModuleConfigs, config.Values)
+
+	storage := mm.valuesStorage.Copy()
+	storage.SetKubeGlobalConfigValues(config.Values)
+	storage.SetKubeModulesConfigValues(modulesConfigValues)
+
+	res := &EnabledModulesSimulation{
+		EnabledModules: make([]string, 0),
+		Modules:        make([]SimulatedModule, 0, len(mm.allModulesNamesInOrder)),
+	}
+	for _, moduleConfig := range unknown {
+		res.UnknownModules = append(res.UnknownModules, moduleConfig.ModuleName)
+	}
+
+	currentlyEnabled := mm.enabledModulesInOrder
+	nodePlatforms := mm.nodePlatforms.get()
+	capacity := mm.clusterCapacity.get()
+	for _, name := range mm.allModulesNamesInOrder {
+		module := mm.allModulesByName[name]
+		state := SimulatedModule{
+			Module:           name,
+			CurrentlyEnabled: utils.ListFullyIn([]string{name}, currentlyEnabled),
+		}
+
+		switch {
+		case !utils.ListFullyIn([]string{name}, enabledByConfig):
+			state.Reason = SimulatedDisabledByConfig
+		case !module.SupportsNodePlatforms(nodePlatforms):
+			state.Reason = SimulatedDisabledPlatforms
+		case module.UnmetClusterRequirements(capacity) != "":
+			state.Reason = SimulatedDisabledCapacity
+		default:
+			enabled, err := module.simulateEnabledScript(storage, res.EnabledModules)
+			if err != nil {
+				rlog.Warnf("MODULE_MANAGER enabled simulation: module '%s': %s", name, err)
+				state.Reason = SimulatedScriptError
+				state.Error = err.Error()
+			} else if enabled {
+				state.Enabled = true
+				state.Reason = SimulatedEnabled
+				res.EnabledModules = append(res.EnabledModules, name)
+			} else {
+				state.Reason = SimulatedDisabledByScript
+			}
+		}
+
+		res.Modules = append(res.Modules, state)
+	}
+
+	res.ModulesToEnable = utils.ListSubtract(res.EnabledModules, currentlyEnabled)
+	res.ModulesToDisable = utils.ListSubtract(currentlyEnabled, res.EnabledModules)
+
+	return res, nil
+}
+
+// simulateEnabledScript runs enabled script with values from the storage. Files have
+// their own names to not interfere with the discovery.
+func (m *Module) simulateEnabledScript(storage *ValuesStorage, precedingEnabledModules []string) (bool, error) {
+	enabledScriptPath := filepath.Join(m.Path, "enabled")
+
+	f, err := os.Stat(enabledScriptPath)
+	if os.IsNotExist(err) {
+		return true, nil
+	} else if err != nil {
+		return false, err
+	}
+	if !utils.IsFileExecutable(f) {
+		return false, fmt.Errorf("cannot execute non-executable enable script '%s'", enabledScriptPath)
+	}
+
+	configValuesPath, err := dumpValuesJson(fmt.Sprintf("%s.simulation-config-values.json", m.SafeName()), m.configValuesFrom(storage))
+	if err != nil {
+		return false, err
+	}
+	values, _ := m.constructValuesFrom(storage, precedingEnabledModules)
+	if values, err = vault.ResolveValues(values); err != nil {
+		return false, fmt.Errorf("module '%s' values: %s", m.Name, err)
+	}
+	valuesPath, err := dumpValuesJson(fmt.Sprintf("%s.simulation-values.json", m.SafeName()), values)
+	if err != nil {
+		return false, err
+	}
+	resultPath := filepath.Join(TempDir, fmt.Sprintf("%s.simulation-enabled-result", m.SafeName()))
+	if err := createHookResultValuesFile(resultPath); err != nil {
+		return false, err
+	}
+
+	return m.runEnabledScript(enabledScriptPath, configValuesPath, valuesPath, resultPath, []string{fmt.Sprintf("%s=true", EnabledSimulationEnv)})
+}
@@ -0,0 +1,80 @@
+package module_manager
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/flant/antiopa/utils"
+)
+
+func TestMainModuleManager_SimulateEnabledModules(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "enabled-simulation")
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer os.RemoveAll(tmpDir)
+	TempDir = tmpDir
+	WorkingDir = tmpDir
+
+	mm := NewMainModuleManager(&MockHelmClient{}, nil)
+	for _, name := range []string{"cert-manager", "dex"} {
+		module := &Module{Name: name, Path: filepath.Join(tmpDir, name), moduleManager: mm, StaticConfig: utils.NewModuleConfig(name)}
+		module.StaticConfig.IsEnabled = true
+		mm.allModulesByName[name] = module
+		mm.allModulesNamesInOrder = append(mm.allModulesNamesInOrder, name)
+		if !assert.NoError(t, os.MkdirAll(module.Path, 0755)) {
+			return
+		}
+	}
+	mm.enabledModulesInOrder = []string{"cert-manager"}
+
+	// dex is enabled only with a host and after cert-manager
+	enabledScript := `#!/bin/sh
+[ "$ANTIOPA_ENABLED_SIMULATION" = "true" ] || exit 1
+if grep -q '"host":' "$VALUES_PATH" && grep -q '"cert-manager"' "$VALUES_PATH"; then
+  echo true > "$MODULE_ENABLED_RESULT"
+else
+  echo false > "$MODULE_ENABLED_RESULT"
+fi
+`
+	if !assert.NoError(t, ioutil.WriteFile(filepath.Join(tmpDir, "dex", "enabled"), []byte(enabledScript), 0755)) {
+		return
+	}
+
+	simulation, err := mm.SimulateEnabledModules(map[string]string{
+		"dex": "host: dex.example.com\n",
+	})
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.Equal(t, []string{"cert-manager", "dex"}, simulation.EnabledModules)
+	assert.Equal(t, []string{"dex"}, simulation.ModulesToEnable)
+	assert.Len(t, simulation.ModulesToDisable, 0)
+
+	simulation, err = mm.SimulateEnabledModules(map[string]string{
+		"certManager": "false\n",
+		"dex":         "host: dex.example.com\n",
+		"unknown":     "a: 1\n",
+	})
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.Equal(t, []string{}, simulation.EnabledModules)
+	assert.Equal(t, []string{"cert-manager"}, simulation.ModulesToDisable)
+	assert.Equal(t, []string{"unknown"}, simulation.UnknownModules)
+	assert.Equal(t, []SimulatedModule{
+		{Module: "cert-manager", CurrentlyEnabled: true, Reason: SimulatedDisabledByConfig},
+		{Module: "dex", Reason: SimulatedDisabledByScript},
+	}, simulation.Modules)
+
+	// state is not changed
+	assert.Equal(t, []string{"cert-manager"}, mm.enabledModulesInOrder)
+	assert.False(t, mm.valuesStorage.HasKubeModuleConfigValues("dex"))
+
+	_, err = mm.SimulateEnabledModules(map[string]string{"global": "a: [\n"})
+	assert.Error(t, err)
+}
@@ -0,0 +1,158 @@
+package module_manager
+
+import (
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+// ErrHookFailed is returned if hook exits with error, exceeds deadline or writes a broken json patch
+type ErrHookFailed struct {
+	// empty for global hooks
+	Module string
+	Hook   string
+	Err    error
+}
+
+func (e *ErrHookFailed) Error() string {
+	if e.Module == "" {
+		return fmt.Sprintf("global hook '%s' failed: %s", e.Hook, e.Err)
+	}
+	return fmt.Sprintf("module hook '%s' failed: %s", e.Hook, e.Err)
+}
+
+// ErrValuesInvalid is returned if values patch from hook cannot be applied
+type ErrValuesInvalid struct {
+	// empty for global values
+	Module string
+	Hook   string
+	Err    error
+}
+
+func (e *ErrValuesInvalid) Error() string {
+	if e.Module == "" {
+		return fmt.Sprintf("global hook '%s': merge global values failed: %s", e.Hook, e.Err)
+	}
+	return fmt.Sprintf("module hook '%s': merge module '%s' values failed: %s", e.Hook, e.Module, e.Err)
+}
+
+// IsHookFailed returns true if err is ErrHookFailed
+func IsHookFailed(err error) bool {
+	_, ok := err.(*ErrHookFailed)
+	return ok
+}
+
+// IsValuesInvalid returns true if err is ErrValuesInvalid
+func IsValuesInvalid(err error) bool {
+	_, ok := err.(*ErrValuesInvalid)
+	return ok
+}
+
+// ErrHelmUpgradeFailed is returned if helm upgrade of the module release fails
+type ErrHelmUpgradeFailed struct {
+	Module  string
+	Release string
+	Err     error
+}
+
+func (e *ErrHelmUpgradeFailed) Error() string {
+	return fmt.Sprintf("module '%s': upgrade of helm release '%s' failed: %s", e.Module, e.Release, e.Err)
+}
+
+// IsHelmUpgradeFailed returns true if err is ErrHelmUpgradeFailed
+func IsHelmUpgradeFailed(err error) bool {
+	_, ok := err.(*ErrHelmUpgradeFailed)
+	return ok
+}
+
+// ErrModuleHasDependents is returned if release of the disabled module is not deleted
+// because enabled modules depend on it
+type ErrModuleHasDependents struct {
+	Module     string
+	Dependents []string
+}
+
+func (e *ErrModuleHasDependents) Error() string {
+	return fmt.Sprintf("module '%s' is required by enabled modules: %s", e.Module, strings.Join(e.Dependents, ", "))
+}
+
+// IsModuleHasDependents returns true if err is ErrModuleHasDependents
+func IsModuleHasDependents(err error) bool {
+	_, ok := err.(*ErrModuleHasDependents)
+	return ok
+}
+
+// ErrModuleDependencyFailed is returned for the module that is not run because
+// the module from its dependsOn is failed in the same run of modules graph
+type ErrModuleDependencyFailed struct {
+	Module     string
+	Dependency string
+}
+
+func (e *ErrModuleDependencyFailed) Error() string {
+	return fmt.Sprintf("module '%s' is not run: dependency '%s' is failed", e.Module, e.Dependency)
+}
+
+// IsModuleDependencyFailed returns true if err is ErrModuleDependencyFailed
+func IsModuleDependencyFailed(err error) bool {
+	_, ok := err.(*ErrModuleDependencyFailed)
+	return ok
+}
+
+// ErrFailureArtifactsNotFound is returned if the bundle of failed module run is not saved or is removed
+type ErrFailureArtifactsNotFound struct {
+	Module string
+	Id     string
+}
+
+func (e *ErrFailureArtifactsNotFound) Error() string {
+	return fmt.Sprintf("failure artifacts '%s' of module '%s' are not found", e.Id, e.Module)
+}
+
+// IsFailureArtifactsNotFound returns true if err is ErrFailureArtifactsNotFound
+func IsFailureArtifactsNotFound(err error) bool {
+	_, ok := err.(*ErrFailureArtifactsNotFound)
+	return ok
+}
+
+// ErrCustomResourcesExist is returned if release of the deleted module is not deleted because
+// its CRDs have custom resources and crdUninstallPolicy is 'block'
+type ErrCustomResourcesExist struct {
+	Module string
+	// number of custom resources by CRD name
+	CustomResources map[string]int
+}
+
+func (e *ErrCustomResourcesExist) Error() string {
+	crds := make([]string, 0, len(e.CustomResources))
+	for name, count := range e.CustomResources {
+		crds = append(crds, fmt.Sprintf("%s: %d", name, count))
+	}
+	sort.Strings(crds)
+	return fmt.Sprintf("module '%s' is not deleted: CRDs have custom resources: %s", e.Module, strings.Join(crds, ", "))
+}
+
+// IsCustomResourcesExist returns true if err is ErrCustomResourcesExist
+func IsCustomResourcesExist(err error) bool {
+	_, ok := err.(*ErrCustomResourcesExist)
+	return ok
+}
+
+// ErrHelmUpgradeWaiting is returned if helm upgrade of the module waits for helmUpgradeGate
+// from module.yaml. The run should be retried at RetryAt, it is not a failure.
+type ErrHelmUpgradeWaiting struct {
+	Module  string
+	Reason  string
+	RetryAt time.Time
+}
+
+func (e *ErrHelmUpgradeWaiting) Error() string {
+	return fmt.Sprintf("helm upgrade of module '%s' waits: %s", e.Module, e.Reason)
+}
+
+// IsHelmUpgradeWaiting returns true if err is ErrHelmUpgradeWaiting
+func IsHelmUpgradeWaiting(err error) bool {
+	_, ok := err.(*ErrHelmUpgradeWaiting)
+	return ok
+}
@@ -0,0 +1,54 @@
+package module_manager
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/flant/antiopa/utils"
+)
+
+func TestHandleModuleValuesPatch_ErrValuesInvalid(t *testing.T) {
+	hook := &ModuleHook{
+		Hook:   &Hook{Name: "002-nginx/hooks/discovery"},
+		Module: &Module{Name: "nginx"},
+	}
+
+	patch := utils.ValuesPatch{Operations: []*utils.ValuesPatchOperation{
+		{Op: "add", Path: "/global/clusterName", Value: "main"},
+	}}
+
+	_, err := hook.handleModuleValuesPatch(utils.Values{"nginx": map[string]interface{}{}}, patch)
+	if !assert.True(t, IsValuesInvalid(err)) {
+		return
+	}
+	assert.Equal(t, "nginx", err.(*ErrValuesInvalid).Module)
+	assert.Contains(t, err.Error(), "merge module 'nginx' values failed")
+	assert.False(t, IsHookFailed(err))
+}
+
+func TestErrHookFailed(t *testing.T) {
+	err := error(&ErrHookFailed{Module: "nginx", Hook: "002-nginx/hooks/discovery", Err: fmt.Errorf("exit status 1")})
+	assert.True(t, IsHookFailed(err))
+	assert.Equal(t, "module hook '002-nginx/hooks/discovery' failed: exit status 1", err.Error())
+
+	err = &ErrHookFailed{Hook: "global-hooks/startup", Err: fmt.Errorf("exit status 1")}
+	assert.Equal(t, "global hook 'global-hooks/startup' failed: exit status 1", err.Error())
+}
+
+func TestErrHelmUpgradeFailed(t *testing.T) {
+	err := error(&ErrHelmUpgradeFailed{Module: "nginx", Release: "nginx", Err: fmt.Errorf("timed out waiting for the condition")})
+	assert.True(t, IsHelmUpgradeFailed(err))
+	assert.False(t, IsHookFailed(err))
+	assert.Equal(t, "module 'nginx': upgrade of helm release 'nginx' failed: timed out waiting for the condition", err.Error())
+}
+
+func TestErrCustomResourcesExist(t *testing.T) {
+	err := error(&ErrCustomResourcesExist{Module: "cert-manager", CustomResources: map[string]int{
+		"issuers.certmanager.k8s.io":      1,
+		"certificates.certmanager.k8s.io": 12,
+	}})
+	assert.True(t, IsCustomResourcesExist(err))
+	assert.Equal(t, "module 'cert-manager' is not deleted: CRDs have custom resources: certificates.certmanager.k8s.io: 12, issuers.certmanager.k8s.io: 1", err.Error())
+}
@@ -0,0 +1,175 @@
+package module_manager
+
+import (
+	"fmt"
+	"reflect"
+	"strings"
+
+	"github.com/romana/rlog"
+
+	"github.com/flant/antiopa/utils"
+)
+
+// Values exported by other modules are available in the module section under this key:
+// <moduleValuesKey>.imported.<exporterValuesKey>.<exported path>
+const ImportedValuesKey = "imported"
+
+// exportedValues returns values of the module section at paths from exports of module.yaml.
+// Path is a dot separated list of keys, absent paths are skipped.
+func (m *Module) exportedValues(values utils.Values) utils.Values {
+	res := utils.Values{}
+	if m.Definition == nil {
+		return res
+	}
+
+	moduleValues, ok := values[m.moduleValuesKey()].(map[string]interface{})
+	if !ok {
+		return res
+	}
+
+	for _, path := range m.Definition.Exports {
+		keys := strings.Split(path, ".")
+		value, found := valueByKeys(moduleValues, keys)
+		if !found {
+			rlog.Debugf("module '%s': exported value '%s' is not set", m.Name, path)
+			continue
+		}
+		setValueByKeys(res, keys, utils.DeepCopyValue(value))
+	}
+
+	return res
+}
+
+func valueByKeys(values map[string]interface{}, keys []string) (interface{}, bool) {
+	var value interface{} = values
+	for _, key := range keys {
+		node, ok := value.(map[string]interface{})
+		if !ok {
+			return nil, false
+		}
+		value, ok = node[key]
+		if !ok {
+			return nil, false
+		}
+	}
+	return value, true
+}
+
+func setValueByKeys(values map[string]interface{}, keys []string, value interface{}) {
+	node := values
+	for _, key := range keys[:len(keys)-1] {
+		child, ok := node[key].(map[string]interface{})
+		if !ok {
+			child = make(map[string]interface{})
+			node[key] = child
+		}
+		node = child
+	}
+	node[keys[len(keys)-1]] = value
+}
+
+// importedValues returns values exported by modules from imports of module.yaml
+func (m *Module) importedValues(storage *ValuesStorage) utils.Values {
+	if m.Definition == nil || len(m.Definition.Imports) == 0 {
+		return utils.Values{}
+	}
+
+	imported := make(map[string]interface{})
+	for _, exporterName := range m.Definition.Imports {
+		exported := storage.ModuleExportedValues(exporterName)
+		if exported == nil {
+			continue
+		}
+		imported[utils.ModuleNameToValuesKey(exporterName)] = map[string]interface{}(exported)
+	}
+	if len(imported) == 0 {
+		return utils.Values{}
+	}
+
+	return utils.Values{
+		m.moduleValuesKey(): map[string]interface{}{
+			ImportedValuesKey: imported,
+		},
+	}
+}
+
+// updateExportedValues saves exported values of the module after successful run.
+// Enabled modules that import changed values are rerun.
+func (m *Module) updateExportedValues(values utils.Values) {
+	if m.Definition == nil || len(m.Definition.Exports) == 0 {
+		return
+	}
+
+	exported := m.exportedValues(values)
+	old := m.moduleManager.valuesStorage.ModuleExportedValues(m.Name)
+	if old != nil && reflect.DeepEqual(old, exported) {
+		return
+	}
+
+	rlog.Infof("module '%s': exported values are changed", m.Name)
+	m.moduleManager.valuesStorage.SetModuleExportedValues(m.Name, exported)
+	m.moduleManager.rerunImporters(m.Name)
+}
+
+// deleteExportedValues forgets exported values of deleted module and reruns enabled importers
+func (m *Module) deleteExportedValues() {
+	if m.moduleManager.valuesStorage.ModuleExportedValues(m.Name) == nil {
+		return
+	}
+	m.moduleManager.valuesStorage.DeleteModuleExportedValues(m.Name)
+	m.moduleManager.rerunImporters(m.Name)
+}
+
+// importers returns enabled modules that import values of the module
+func (mm *MainModuleManager) importers(exporterName string) []string {
+	res := make([]string, 0)
+	for _, moduleName := range mm.enabledModulesInOrder {
+		module := mm.allModulesByName[moduleName]
+		if module == nil || module.Definition == nil {
+			continue
+		}
+		for _, name := range module.Definition.Imports {
+			if name == exporterName {
+				res = append(res, moduleName)
+				break
+			}
+		}
+	}
+	return res
+}
+
+func (mm *MainModuleManager) rerunImporters(exporterName string) {
+	for _, moduleName := range mm.importers(exporterName) {
+		rlog.Infof("module '%s': rerun because values imported from '%s' are changed", moduleName, exporterName)
+		mm.moduleValuesChanged <- moduleName
+	}
+}
+
+// validateModulesImports checks that imported modules exist. Importer that runs before
+// the exporter gets values on rerun after the exporter run, so it is only reported.
+func (mm *MainModuleManager) validateModulesImports() error {
+	positions := make(map[string]int)
+	for i, moduleName := range mm.allModulesNamesInOrder {
+		positions[moduleName] = i
+	}
+
+	for i, moduleName := range mm.allModulesNamesInOrder {
+		module := mm.allModulesByName[moduleName]
+		if module.Definition == nil {
+			continue
+		}
+		for _, exporterName := range module.Definition.Imports {
+			exporter, ok := mm.allModulesByName[exporterName]
+			if !ok {
+				return fmt.Errorf("module '%s' imports values of unknown module '%s'", moduleName, exporterName)
+			}
+			if exporter.Definition == nil || len(exporter.Definition.Exports) == 0 {
+				rlog.Warnf("module '%s' imports values of module '%s' without exports", moduleName, exporterName)
+			}
+			if positions[exporterName] > i {
+				rlog.Warnf("module '%s' imports values of module '%s' that runs later, module will be rerun after '%s'", moduleName, exporterName, exporterName)
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,77 @@
+package module_manager
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/flant/antiopa/utils"
+)
+
+func TestModule_ExportedValues(t *testing.T) {
+	mm := NewMainModuleManager(&MockHelmClient{}, nil)
+
+	exporter := mm.NewModule()
+	exporter.Name = "cert-manager"
+	exporter.Definition = NewModuleDefinition()
+	exporter.Definition.Exports = []string{"ca.cert", "endpoint", "absent.value"}
+
+	importer := mm.NewModule()
+	importer.Name = "dex"
+	importer.Definition = NewModuleDefinition()
+	importer.Definition.Imports = []string{"cert-manager"}
+
+	mm.allModulesByName = map[string]*Module{"cert-manager": exporter, "dex": importer}
+	mm.allModulesNamesInOrder = []string{"cert-manager", "dex"}
+	mm.enabledModulesInOrder = []string{"cert-manager", "dex"}
+
+	values := utils.Values{
+		"certManager": map[string]interface{}{
+			"ca":       map[string]interface{}{"cert": "CERT", "key": "KEY"},
+			"endpoint": "https://cm:443",
+		},
+	}
+
+	expected := utils.Values{
+		"ca":       map[string]interface{}{"cert": "CERT"},
+		"endpoint": "https://cm:443",
+	}
+	assert.Equal(t, expected, exporter.exportedValues(values))
+
+	// importer is rerun on change
+	exporter.updateExportedValues(values)
+	assert.Equal(t, "dex", <-mm.moduleValuesChanged)
+
+	assert.Equal(t, utils.Values{
+		"dex": map[string]interface{}{
+			ImportedValuesKey: map[string]interface{}{
+				"certManager": map[string]interface{}(expected),
+			},
+		},
+	}, importer.importedValues(mm.valuesStorage))
+
+	// the same values do not rerun importer
+	exporter.updateExportedValues(values)
+	assert.Len(t, mm.moduleValuesChanged, 0)
+
+	exporter.deleteExportedValues()
+	assert.Equal(t, "dex", <-mm.moduleValuesChanged)
+	assert.Equal(t, utils.Values{}, importer.importedValues(mm.valuesStorage))
+}
+
+func TestMainModuleManager_validateModulesImports(t *testing.T) {
+	mm := NewMainModuleManager(&MockHelmClient{}, nil)
+
+	importer := mm.NewModule()
+	importer.Name = "dex"
+	importer.Definition = NewModuleDefinition()
+	importer.Definition.Imports = []string{"unknown"}
+
+	mm.allModulesByName = map[string]*Module{"dex": importer}
+	mm.allModulesNamesInOrder = []string{"dex"}
+
+	assert.Error(t, mm.validateModulesImports())
+
+	importer.Definition.Imports = []string{}
+	assert.NoError(t, mm.validateModulesImports())
+}
@@ -0,0 +1,90 @@
+package module_manager
+
+import (
+	"sync"
+	"time"
+
+	"github.com/romana/rlog"
+)
+
+// Old FAILED revisions of module releases are deleted in background instead of each module run:
+// all releases are cleaned at start and a release is cleaned again after a failed module run.
+var (
+	FailedRevisionsCleanupWorkers = 2
+	// min interval between cleanups of releases, so tiller and apiserver are not overloaded at start
+	FailedRevisionsCleanupInterval = 500 * time.Millisecond
+)
+
+type failedRevisionsCleanup struct {
+	m       sync.Mutex
+	pending map[string]bool
+	queue   chan *Module
+}
+
+func (mm *MainModuleManager) initFailedRevisionsCleanup() {
+	mm.failedRevisionsCleanup = &failedRevisionsCleanup{
+		pending: make(map[string]bool),
+		// each module is queued once until its cleanup is started
+		queue: make(chan *Module, len(mm.allModulesByName)),
+	}
+}
+
+// runFailedRevisionsCleanup starts workers and queues cleanup of all modules with charts
+func (mm *MainModuleManager) runFailedRevisionsCleanup() {
+	cleanup := mm.failedRevisionsCleanup
+	if cleanup == nil {
+		return
+	}
+
+	workers := FailedRevisionsCleanupWorkers
+	if workers < 1 {
+		workers = 1
+	}
+	limiter := time.NewTicker(FailedRevisionsCleanupInterval)
+	for i := 0; i < workers; i++ {
+		go func() {
+			for module := range cleanup.queue {
+				<-limiter.C
+				cleanup.m.Lock()
+				delete(cleanup.pending, module.Name)
+				cleanup.m.Unlock()
+
+				if err := module.deleteOldFailedRevisions(); err != nil {
+					rlog.Errorf("MODULE_MANAGER cannot delete old FAILED revisions of module '%s': %s", module.Name, err)
+				}
+			}
+		}()
+	}
+
+	for _, moduleName := range mm.allModulesNamesInOrder {
+		mm.cleanupFailedRevisionsInBackground(mm.allModulesByName[moduleName])
+	}
+}
+
+// cleanupFailedRevisionsInBackground queues cleanup of the module release if it is not queued yet
+func (mm *MainModuleManager) cleanupFailedRevisionsInBackground(module *Module) {
+	cleanup := mm.failedRevisionsCleanup
+	if cleanup == nil || module == nil {
+		return
+	}
+	if chartExists, _ := module.checkHelmChart(); !chartExists {
+		return
+	}
+
+	cleanup.m.Lock()
+	defer cleanup.m.Unlock()
+	if cleanup.pending[module.Name] {
+		return
+	}
+	cleanup.pending[module.Name] = true
+	cleanup.queue <- module
+}
+
+// deleteOldFailedRevisions deletes FAILED revisions of the release except the last one
+func (m *Module) deleteOldFailedRevisions() error {
+	helmClient, err := m.HelmClient()
+	if err != nil {
+		return err
+	}
+	return helmClient.DeleteOldFailedRevisions(m.generateHelmReleaseName())
+}
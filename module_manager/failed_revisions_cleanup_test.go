@@ -0,0 +1,78 @@
+package module_manager
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type cleanupHelmClient struct {
+	MockHelmClient
+	m        sync.Mutex
+	releases []string
+}
+
+func (h *cleanupHelmClient) DeleteOldFailedRevisions(releaseName string) error {
+	h.m.Lock()
+	defer h.m.Unlock()
+	h.releases = append(h.releases, releaseName)
+	return nil
+}
+
+func (h *cleanupHelmClient) cleaned() int {
+	h.m.Lock()
+	defer h.m.Unlock()
+	return len(h.releases)
+}
+
+func TestMainModuleManager_runFailedRevisionsCleanup(t *testing.T) {
+	defer func(interval time.Duration) { FailedRevisionsCleanupInterval = interval }(FailedRevisionsCleanupInterval)
+	FailedRevisionsCleanupInterval = time.Millisecond
+
+	tmpDir, err := ioutil.TempDir("", "failed-revisions-cleanup")
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer os.RemoveAll(tmpDir)
+
+	helmClient := &cleanupHelmClient{}
+	mm := NewMainModuleManager(helmClient, nil)
+	for _, name := range []string{"cert-manager", "dex", "no-chart"} {
+		module := mm.NewModule()
+		module.Name = name
+		module.Path = filepath.Join(tmpDir, name)
+		if !assert.NoError(t, os.MkdirAll(module.Path, 0755)) {
+			return
+		}
+		if name != "no-chart" {
+			if !assert.NoError(t, ioutil.WriteFile(filepath.Join(module.Path, "Chart.yaml"), []byte("name: "+name+"\n"), 0644)) {
+				return
+			}
+		}
+		mm.allModulesByName[name] = module
+		mm.allModulesNamesInOrder = append(mm.allModulesNamesInOrder, name)
+	}
+
+	mm.initFailedRevisionsCleanup()
+	mm.runFailedRevisionsCleanup()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for helmClient.cleaned() < 2 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	time.Sleep(50 * time.Millisecond)
+	assert.Equal(t, 2, helmClient.cleaned())
+
+	// release is cleaned again after the failed run
+	mm.cleanupFailedRevisionsInBackground(mm.allModulesByName["dex"])
+	deadline = time.Now().Add(5 * time.Second)
+	for helmClient.cleaned() < 3 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	assert.Equal(t, 3, helmClient.cleaned())
+}
@@ -0,0 +1,355 @@
+package module_manager
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-yaml/yaml"
+	"github.com/romana/rlog"
+
+	"github.com/flant/antiopa/utils"
+)
+
+// FailureArtifactsDir is a directory for bundles of failed module runs: values with
+// redacted secrets, rendered manifest, output of hooks and the error with helm output.
+// Bundles are not saved if empty.
+var FailureArtifactsDir = ""
+
+// FailureArtifactsRetention is a number of bundles kept for each module
+var FailureArtifactsRetention = 5
+
+// Values with keys matching FailureArtifactsRedactKeys are replaced in bundles
+var FailureArtifactsRedactKeys = regexp.MustCompile(`(?i)(password|passwd|secret|token|credentials?|key|cert|crt|dockercfg)$`)
+
+const (
+	redactedValue = "<redacted>"
+	// limit of output of one hook in the bundle
+	hookRunLogLimit = 1024 * 1024
+
+	failureArtifactsInfoFile = "info.json"
+	// time in bundle id
+	failureArtifactsIdLayout = "20060102T150405.000Z"
+)
+
+var failureManifestSeparator = regexp.MustCompile(`(?m)^---\s*$`)
+
+// FailureArtifactsBundle describes a saved bundle of the failed module run
+type FailureArtifactsBundle struct {
+	Id     string    `json:"id"`
+	Module string    `json:"module"`
+	TaskId string    `json:"taskId"`
+	Time   time.Time `json:"time"`
+	Error  string    `json:"error"`
+	Files  []string  `json:"files"`
+}
+
+// hookRunLog is an output of a hook, the beginning is kept if output is too large
+type hookRunLog struct {
+	m         sync.Mutex
+	name      string
+	buf       bytes.Buffer
+	truncated bool
+}
+
+func (l *hookRunLog) Write(p []byte) (int, error) {
+	l.m.Lock()
+	defer l.m.Unlock()
+	if rest := hookRunLogLimit - l.buf.Len(); rest < len(p) {
+		l.buf.Write(p[:rest])
+		l.truncated = true
+	} else {
+		l.buf.Write(p)
+	}
+	return len(p), nil
+}
+
+func (l *hookRunLog) bytes() []byte {
+	l.m.Lock()
+	defer l.m.Unlock()
+	data := append([]byte{}, l.buf.Bytes()...)
+	if l.truncated {
+		data = append(data, []byte("\n... output is truncated\n")...)
+	}
+	return data
+}
+
+// moduleRunArtifacts are collected while module is running
+type moduleRunArtifacts struct {
+	m         sync.Mutex
+	hooksLogs []*hookRunLog
+	manifest  string
+}
+
+// captureOutput copies stdout and stderr of the hook command into the artifacts
+func (a *moduleRunArtifacts) captureOutput(cmd *exec.Cmd, hookSafeName string, binding BindingType) {
+	// binding as in hook config, e.g. beforeHelm
+	bindingName, ok := ContextBindingType[binding]
+	if !ok {
+		bindingName = string(binding)
+	}
+
+	a.m.Lock()
+	log := &hookRunLog{name: fmt.Sprintf("%02d-%s-%s.log", len(a.hooksLogs)+1, hookSafeName, bindingName)}
+	a.hooksLogs = append(a.hooksLogs, log)
+	a.m.Unlock()
+
+	cmd.Stdout = io.MultiWriter(cmd.Stdout, log)
+	cmd.Stderr = io.MultiWriter(cmd.Stderr, log)
+}
+
+func (a *moduleRunArtifacts) setManifest(manifest string) {
+	a.m.Lock()
+	a.manifest = manifest
+	a.m.Unlock()
+}
+
+// startRunArtifacts starts collection of artifacts, nil is returned if bundles are disabled
+func (m *Module) startRunArtifacts() *moduleRunArtifacts {
+	if FailureArtifactsDir == "" {
+		return nil
+	}
+	m.artifactsMutex.Lock()
+	defer m.artifactsMutex.Unlock()
+	m.artifacts = &moduleRunArtifacts{}
+	return m.artifacts
+}
+
+func (m *Module) stopRunArtifacts() {
+	m.artifactsMutex.Lock()
+	m.artifacts = nil
+	m.artifactsMutex.Unlock()
+}
+
+// runArtifacts returns artifacts of the current run or nil
+func (m *Module) runArtifacts() *moduleRunArtifacts {
+	m.artifactsMutex.Lock()
+	defer m.artifactsMutex.Unlock()
+	return m.artifacts
+}
+
+func (m *Module) failureArtifactsDir() string {
+	return filepath.Join(FailureArtifactsDir, m.SafeName())
+}
+
+// saveFailureArtifacts writes the bundle of the failed run and removes old bundles
+func (m *Module) saveFailureArtifacts(artifacts *moduleRunArtifacts, taskId string, runErr error) (string, error) {
+	now := time.Now().UTC()
+	id := now.Format(failureArtifactsIdLayout)
+	dir := filepath.Join(m.failureArtifactsDir(), id)
+	if err := os.MkdirAll(filepath.Join(dir, "hooks"), 0755); err != nil {
+		return "", err
+	}
+
+	files := map[string][]byte{
+		"error.txt": []byte(runErr.Error() + "\n"),
+	}
+
+	values, err := yaml.Marshal(RedactValues(m.values()))
+	if err != nil {
+		return "", err
+	}
+	files["values.yaml"] = values
+
+	artifacts.m.Lock()
+	if artifacts.manifest != "" {
+		files["manifest.yaml"] = []byte(RedactManifestSecrets(artifacts.manifest))
+	}
+	for _, log := range artifacts.hooksLogs {
+		files[filepath.Join("hooks", log.name)] = log.bytes()
+	}
+	artifacts.m.Unlock()
+
+	bundle := FailureArtifactsBundle{
+		Id:     id,
+		Module: m.Name,
+		TaskId: taskId,
+		Time:   now,
+		Error:  runErr.Error(),
+	}
+	for name, data := range files {
+		if err := dumpData(filepath.Join(dir, name), data); err != nil {
+			return "", err
+		}
+		bundle.Files = append(bundle.Files, name)
+	}
+	sort.Strings(bundle.Files)
+
+	info, err := json.MarshalIndent(bundle, "", "  ")
+	if err != nil {
+		return "", err
+	}
+	if err := dumpData(filepath.Join(dir, failureArtifactsInfoFile), info); err != nil {
+		return "", err
+	}
+
+	m.pruneFailureArtifacts()
+	return dir, nil
+}
+
+// pruneFailureArtifacts keeps the last FailureArtifactsRetention bundles of the module
+func (m *Module) pruneFailureArtifacts() {
+	ids, err := m.failureArtifactsIds()
+	if err != nil {
+		rlog.Errorf("MODULE_RUN '%s': cannot list failure artifacts: %s", m.Name, err)
+		return
+	}
+	for len(ids) > FailureArtifactsRetention {
+		if err := os.RemoveAll(filepath.Join(m.failureArtifactsDir(), ids[0])); err != nil {
+			rlog.Errorf("MODULE_RUN '%s': cannot remove failure artifacts '%s': %s", m.Name, ids[0], err)
+		}
+		ids = ids[1:]
+	}
+}
+
+// failureArtifactsIds returns ids of bundles from old to new
+func (m *Module) failureArtifactsIds() ([]string, error) {
+	entries, err := ioutil.ReadDir(m.failureArtifactsDir())
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	ids := make([]string, 0)
+	for _, entry := range entries {
+		if entry.IsDir() {
+			ids = append(ids, entry.Name())
+		}
+	}
+	sort.Strings(ids)
+	return ids, nil
+}
+
+// FailureArtifacts returns saved bundles of failed runs of the module from new to old
+func (m *Module) FailureArtifacts() ([]FailureArtifactsBundle, error) {
+	ids, err := m.failureArtifactsIds()
+	if err != nil {
+		return nil, err
+	}
+	res := make([]FailureArtifactsBundle, 0)
+	for i := len(ids) - 1; i >= 0; i-- {
+		data, err := ioutil.ReadFile(filepath.Join(m.failureArtifactsDir(), ids[i], failureArtifactsInfoFile))
+		if err != nil {
+			// bundle is being written
+			continue
+		}
+		var bundle FailureArtifactsBundle
+		if err := json.Unmarshal(data, &bundle); err != nil {
+			return nil, fmt.Errorf("bad failure artifacts '%s': %s", ids[i], err)
+		}
+		res = append(res, bundle)
+	}
+	return res, nil
+}
+
+// WriteFailureArtifactsArchive writes the bundle as tar.gz archive
+func (m *Module) WriteFailureArtifactsArchive(w io.Writer, id string) error {
+	if id == "" || id != filepath.Base(id) || strings.HasPrefix(id, ".") {
+		return fmt.Errorf("bad failure artifacts id '%s'", id)
+	}
+	dir := filepath.Join(m.failureArtifactsDir(), id)
+	if _, err := os.Stat(dir); err != nil {
+		return &ErrFailureArtifactsNotFound{Module: m.Name, Id: id}
+	}
+
+	gz := gzip.NewWriter(w)
+	tw := tar.NewWriter(gz)
+	err := filepath.Walk(dir, func(path string, info os.FileInfo, err error) error {
+		if err != nil || info.IsDir() {
+			return err
+		}
+		relPath, err := filepath.Rel(filepath.Dir(dir), path)
+		if err != nil {
+			return err
+		}
+		data, err := ioutil.ReadFile(path)
+		if err != nil {
+			return err
+		}
+		header := &tar.Header{Name: filepath.ToSlash(relPath), Mode: 0644, Size: int64(len(data)), ModTime: info.ModTime()}
+		if err := tw.WriteHeader(header); err != nil {
+			return err
+		}
+		_, err = tw.Write(data)
+		return err
+	})
+	if err != nil {
+		return err
+	}
+	if err := tw.Close(); err != nil {
+		return err
+	}
+	return gz.Close()
+}
+
+// RedactValues returns a copy of values where values of keys matching FailureArtifactsRedactKeys are replaced
+func RedactValues(values utils.Values) utils.Values {
+	res := make(utils.Values, len(values))
+	for key, value := range values {
+		res[key] = redactValue(key, value)
+	}
+	return res
+}
+
+func redactValue(key string, value interface{}) interface{} {
+	if FailureArtifactsRedactKeys.MatchString(key) {
+		return redactedValue
+	}
+	switch v := value.(type) {
+	case map[string]interface{}:
+		res := make(map[string]interface{}, len(v))
+		for k, item := range v {
+			res[k] = redactValue(k, item)
+		}
+		return res
+	case utils.Values:
+		return RedactValues(v)
+	case []interface{}:
+		res := make([]interface{}, len(v))
+		for i, item := range v {
+			res[i] = redactValue("", item)
+		}
+		return res
+	}
+	return value
+}
+
+// RedactManifestSecrets replaces data of Secrets in the manifest, other documents are kept as is
+func RedactManifestSecrets(manifest string) string {
+	docs := failureManifestSeparator.Split(manifest, -1)
+	for i, doc := range docs {
+		var obj map[string]interface{}
+		if err := yaml.Unmarshal([]byte(doc), &obj); err != nil || obj["kind"] != "Secret" {
+			continue
+		}
+		for _, field := range []string{"data", "stringData"} {
+			data, ok := obj[field].(map[interface{}]interface{})
+			if !ok {
+				continue
+			}
+			for key := range data {
+				data[key] = redactedValue
+			}
+		}
+		redacted, err := yaml.Marshal(obj)
+		if err != nil {
+			docs[i] = fmt.Sprintf("# Secret is redacted: %s\n", err)
+			continue
+		}
+		docs[i] = "\n" + string(redacted)
+	}
+	return strings.Join(docs, "---")
+}
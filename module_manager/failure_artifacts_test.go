@@ -0,0 +1,145 @@
+package module_manager
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/flant/antiopa/utils"
+)
+
+func TestRedactValues(t *testing.T) {
+	values := utils.Values{
+		"dex": map[string]interface{}{
+			"clientSecret": "s3cr3t",
+			"replicas":     2,
+			"users": []interface{}{
+				map[string]interface{}{"name": "admin", "password": "p@ss"},
+			},
+			"tls": map[string]interface{}{"key": "PRIVATE", "secretName": "dex-tls"},
+		},
+	}
+
+	assert.Equal(t, utils.Values{
+		"dex": map[string]interface{}{
+			"clientSecret": redactedValue,
+			"replicas":     2,
+			"users": []interface{}{
+				map[string]interface{}{"name": "admin", "password": redactedValue},
+			},
+			"tls": map[string]interface{}{"key": redactedValue, "secretName": "dex-tls"},
+		},
+	}, RedactValues(values))
+	// source values are not changed
+	assert.Equal(t, "s3cr3t", values["dex"].(map[string]interface{})["clientSecret"])
+}
+
+func TestRedactManifestSecrets(t *testing.T) {
+	manifest := `
+---
+apiVersion: v1
+kind: Secret
+metadata:
+  name: dex
+data:
+  password: cEBzcw==
+stringData:
+  token: abc
+---
+apiVersion: v1
+kind: ConfigMap
+metadata:
+  name: dex
+data:
+  config: keep
+`
+	res := RedactManifestSecrets(manifest)
+	assert.NotContains(t, res, "cEBzcw==")
+	assert.NotContains(t, res, "abc")
+	assert.Contains(t, res, "password: <redacted>")
+	assert.Contains(t, res, "config: keep")
+}
+
+func TestModule_saveFailureArtifacts(t *testing.T) {
+	dir, err := ioutil.TempDir("", "failure-artifacts")
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer os.RemoveAll(dir)
+
+	defer func(d string, r int) { FailureArtifactsDir, FailureArtifactsRetention = d, r }(FailureArtifactsDir, FailureArtifactsRetention)
+	FailureArtifactsDir = ""
+	FailureArtifactsRetention = 2
+
+	mm := NewMainModuleManager(&MockHelmClient{}, nil)
+	m := &Module{Name: "dex", moduleManager: mm, StaticConfig: utils.NewModuleConfig("dex")}
+	m.StaticConfig.Values = utils.Values{"dex": map[string]interface{}{"adminPassword": "p@ss"}}
+
+	assert.Nil(t, m.startRunArtifacts())
+	FailureArtifactsDir = dir
+
+	for i := 1; i <= 3; i++ {
+		artifacts := m.startRunArtifacts()
+		cmd := exec.Command("sh", "-c", fmt.Sprintf("echo run %d; echo oops >&2", i))
+		cmd.Stdout, cmd.Stderr = ioutil.Discard, ioutil.Discard
+		m.runArtifacts().captureOutput(cmd, "001-hook", BeforeHelm)
+		assert.NoError(t, cmd.Run())
+		artifacts.setManifest("kind: Secret\ndata:\n  token: dG9rZW4=\n")
+		m.stopRunArtifacts()
+		assert.Nil(t, m.runArtifacts())
+
+		_, err := m.saveFailureArtifacts(artifacts, fmt.Sprintf("task-%d", i), fmt.Errorf("helm upgrade failed: %d", i))
+		assert.NoError(t, err)
+		time.Sleep(2 * time.Millisecond)
+	}
+
+	bundles, err := m.FailureArtifacts()
+	if !assert.NoError(t, err) || !assert.Len(t, bundles, 2) {
+		return
+	}
+	assert.Equal(t, "task-3", bundles[0].TaskId)
+	assert.Equal(t, "helm upgrade failed: 2", bundles[1].Error)
+	assert.Equal(t, []string{"error.txt", "hooks/01-001-hook-beforeHelm.log", "manifest.yaml", "values.yaml"}, bundles[0].Files)
+
+	var archive bytes.Buffer
+	if !assert.NoError(t, m.WriteFailureArtifactsArchive(&archive, bundles[0].Id)) {
+		return
+	}
+	gz, err := gzip.NewReader(&archive)
+	if !assert.NoError(t, err) {
+		return
+	}
+	files := make(map[string]string)
+	tr := tar.NewReader(gz)
+	for {
+		header, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if !assert.NoError(t, err) {
+			return
+		}
+		data, _ := ioutil.ReadAll(tr)
+		files[header.Name] = string(data)
+	}
+	prefix := bundles[0].Id + "/"
+	assert.Contains(t, files[prefix+"hooks/01-001-hook-beforeHelm.log"], "run 3\n")
+	assert.Contains(t, files[prefix+"hooks/01-001-hook-beforeHelm.log"], "oops\n")
+	assert.Contains(t, files[prefix+"values.yaml"], "adminPassword: <redacted>")
+	assert.False(t, strings.Contains(files[prefix+"manifest.yaml"], "dG9rZW4="))
+	assert.Contains(t, files, prefix+"info.json")
+
+	assert.True(t, IsFailureArtifactsNotFound(m.WriteFailureArtifactsArchive(&archive, "20000101T000000.000Z")))
+	assert.Error(t, m.WriteFailureArtifactsArchive(&archive, filepath.Join("..", "dex")))
+}
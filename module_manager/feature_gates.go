@@ -0,0 +1,153 @@
+package module_manager
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/romana/rlog"
+	"gopkg.in/yaml.v2"
+
+	"github.com/flant/antiopa/utils"
+)
+
+// FeatureGatesFileName is a list of feature gates in the working dir
+const FeatureGatesFileName = "feature-gates.yaml"
+
+// Key in global section of the ConfigMap to toggle feature gates, e.g. {"featureGates": {"NewIngress": true}}
+const FeatureGatesValuesKey = "featureGates"
+
+// FeatureGatesEnv is a variable for hooks and enabled scripts with comma separated names of enabled feature gates
+const FeatureGatesEnv = "ANTIOPA_FEATURE_GATES"
+
+const (
+	FeatureGateStageAlpha = "alpha"
+	FeatureGateStageBeta  = "beta"
+	FeatureGateStageGA    = "ga"
+)
+
+// FeatureGate is an experimental behavior of modules that is toggled per installation in the
+// global section of the ConfigMap. Modules read state of all gates from global.featureGates values,
+// hooks and enabled scripts also get enabled gates in ANTIOPA_FEATURE_GATES.
+type FeatureGate struct {
+	Name        string `yaml:"name" json:"name"`
+	Default     bool   `yaml:"default" json:"default"`
+	Stage       string `yaml:"stage" json:"stage"`
+	Description string `yaml:"description" json:"description,omitempty"`
+}
+
+// FeatureGateStatus is a state of the gate for debug API
+type FeatureGateStatus struct {
+	FeatureGate
+	Enabled bool `json:"enabled"`
+}
+
+// initFeatureGates loads feature-gates.yaml from working dir if exists
+func (mm *MainModuleManager) initFeatureGates() error {
+	mm.featureGates = make([]FeatureGate, 0)
+
+	path := filepath.Join(WorkingDir, FeatureGatesFileName)
+	if _, err := os.Stat(path); os.IsNotExist(err) {
+		return nil
+	}
+
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("cannot read '%s': %s", path, err)
+	}
+
+	gates := make([]FeatureGate, 0)
+	if err := yaml.UnmarshalStrict(data, &gates); err != nil {
+		return fmt.Errorf("bad %s: %s\n%s", FeatureGatesFileName, err, string(data))
+	}
+	names := make(map[string]bool)
+	for i, gate := range gates {
+		if gate.Name == "" {
+			return fmt.Errorf("bad %s: name is required", FeatureGatesFileName)
+		}
+		if names[gate.Name] {
+			return fmt.Errorf("bad %s: duplicate gate '%s'", FeatureGatesFileName, gate.Name)
+		}
+		names[gate.Name] = true
+		switch gate.Stage {
+		case "":
+			gates[i].Stage = FeatureGateStageAlpha
+		case FeatureGateStageAlpha, FeatureGateStageBeta, FeatureGateStageGA:
+		default:
+			return fmt.Errorf("bad %s: gate '%s' has unknown stage '%s', expected '%s', '%s' or '%s'", FeatureGatesFileName, gate.Name, gate.Stage, FeatureGateStageAlpha, FeatureGateStageBeta, FeatureGateStageGA)
+		}
+	}
+	mm.featureGates = gates
+
+	rlog.Infof("Initialized %d feature gates", len(mm.featureGates))
+
+	return nil
+}
+
+// featureGatesState returns state of known gates: defaults are overridden by global config values.
+// Unknown gates and non-boolean values in config are ignored.
+func (mm *MainModuleManager) featureGatesState() map[string]bool {
+	configGates := map[string]interface{}{}
+	if global, ok := mm.valuesStorage.KubeGlobalConfigValues()[utils.GlobalValuesKey].(map[string]interface{}); ok {
+		if gates, ok := global[FeatureGatesValuesKey].(map[string]interface{}); ok {
+			configGates = gates
+		}
+	}
+
+	res := make(map[string]bool)
+	for _, gate := range mm.featureGates {
+		res[gate.Name] = gate.Default
+		if enabled, ok := configGates[gate.Name].(bool); ok {
+			res[gate.Name] = enabled
+		}
+	}
+	return res
+}
+
+// setFeatureGatesValues replaces global.featureGates in values with the state of known gates
+func (mm *MainModuleManager) setFeatureGatesValues(values utils.Values) utils.Values {
+	if len(mm.featureGates) == 0 {
+		return values
+	}
+
+	// global section can be shared with values storage, so it is copied
+	global := make(map[string]interface{})
+	if oldGlobal, ok := values[utils.GlobalValuesKey].(map[string]interface{}); ok {
+		for key, value := range oldGlobal {
+			global[key] = value
+		}
+	}
+	values[utils.GlobalValuesKey] = global
+
+	gates := make(map[string]interface{})
+	for name, enabled := range mm.featureGatesState() {
+		gates[name] = enabled
+	}
+	global[FeatureGatesValuesKey] = gates
+	return values
+}
+
+// featureGatesEnv returns ANTIOPA_FEATURE_GATES variable with sorted names of enabled gates
+func (mm *MainModuleManager) featureGatesEnv() string {
+	enabled := make([]string, 0)
+	for name, isEnabled := range mm.featureGatesState() {
+		if isEnabled {
+			enabled = append(enabled, name)
+		}
+	}
+	sort.Strings(enabled)
+	return fmt.Sprintf("%s=%s", FeatureGatesEnv, strings.Join(enabled, ","))
+}
+
+// FeatureGates returns known gates with their state
+func (mm *MainModuleManager) FeatureGates() []FeatureGateStatus {
+	state := mm.featureGatesState()
+	res := make([]FeatureGateStatus, 0, len(mm.featureGates))
+	for _, gate := range mm.featureGates {
+		res = append(res, FeatureGateStatus{FeatureGate: gate, Enabled: state[gate.Name]})
+	}
+	return res
+}
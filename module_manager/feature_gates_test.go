@@ -0,0 +1,50 @@
+package module_manager
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/flant/antiopa/utils"
+)
+
+func TestMainModuleManager_FeatureGates(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "feature-gates")
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer os.RemoveAll(tmpDir)
+	WorkingDir = tmpDir
+
+	ioutil.WriteFile(filepath.Join(tmpDir, FeatureGatesFileName), []byte(`
+- name: NewIngress
+  stage: beta
+  default: true
+- name: FastDNS
+  description: cache DNS on nodes
+`), 0644)
+
+	mm := NewMainModuleManager(&MockHelmClient{}, nil)
+	if !assert.NoError(t, mm.initFeatureGates()) {
+		return
+	}
+	assert.Equal(t, FeatureGateStageAlpha, mm.featureGates[1].Stage)
+
+	mm.valuesStorage.SetKubeGlobalConfigValues(utils.Values{"global": map[string]interface{}{
+		"featureGates": map[string]interface{}{"FastDNS": true, "NewIngress": false, "Unknown": true},
+	}})
+
+	dns := &Module{Name: "dns", moduleManager: mm, StaticConfig: utils.NewModuleConfig("dns")}
+	values := dns.values()
+	assert.Equal(t, map[string]interface{}{"FastDNS": true, "NewIngress": false}, values["global"].(map[string]interface{})["featureGates"])
+	assert.Equal(t, "ANTIOPA_FEATURE_GATES=FastDNS", mm.featureGatesEnv())
+
+	ioutil.WriteFile(filepath.Join(tmpDir, FeatureGatesFileName), []byte(`
+- name: FastDNS
+  stage: stable
+`), 0644)
+	assert.Error(t, mm.initFeatureGates())
+}
@@ -0,0 +1,59 @@
+package module_manager
+
+import (
+	"reflect"
+
+	"github.com/romana/rlog"
+
+	"github.com/flant/antiopa/utils"
+)
+
+// group returns the group of the module from module.yaml
+func (m *Module) group() string {
+	if m == nil || m.Definition == nil {
+		return ""
+	}
+	return m.Definition.Group
+}
+
+// groupValues returns values of the module group in the module section, e.g.
+// {"prometheus": {"retention": "7d"}} for 'group.monitoring' key with 'retention: 7d'
+func (m *Module) groupValues(storage *ValuesStorage) utils.Values {
+	if m.group() == "" {
+		return utils.Values{}
+	}
+	values := storage.GroupValues(m.group())
+	if len(values) == 0 {
+		return utils.Values{}
+	}
+	return utils.Values{m.moduleValuesKey(): map[string]interface{}(values)}
+}
+
+// handleGroupsValuesUpdate saves new values of groups and returns enabled modules of changed groups
+func (mm *MainModuleManager) handleGroupsValuesUpdate(groupsValues map[string]utils.Values) []string {
+	changedGroups := make(map[string]bool)
+	for _, moduleName := range mm.enabledModulesInOrder {
+		groupName := mm.allModulesByName[moduleName].group()
+		if groupName == "" {
+			continue
+		}
+		oldValues := mm.valuesStorage.GroupValues(groupName)
+		newValues := groupsValues[groupName]
+		if len(oldValues) == 0 && len(newValues) == 0 {
+			continue
+		}
+		if !reflect.DeepEqual(oldValues, newValues) {
+			changedGroups[groupName] = true
+		}
+	}
+	mm.valuesStorage.SetGroupsValues(groupsValues)
+
+	changed := make([]string, 0)
+	for _, moduleName := range mm.enabledModulesInOrder {
+		if groupName := mm.allModulesByName[moduleName].group(); changedGroups[groupName] {
+			rlog.Infof("MODULE_MANAGER module '%s': values of group '%s' are changed", moduleName, groupName)
+			changed = append(changed, moduleName)
+		}
+	}
+	return changed
+}
@@ -0,0 +1,43 @@
+package module_manager
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/flant/antiopa/utils"
+)
+
+func TestMainModuleManager_handleGroupsValuesUpdate(t *testing.T) {
+	mm := NewMainModuleManager(&MockHelmClient{}, nil)
+	newModule := func(name string, group string) *Module {
+		module := &Module{Name: name, moduleManager: mm, StaticConfig: utils.NewModuleConfig(name), Definition: NewModuleDefinition()}
+		module.Definition.Group = group
+		return module
+	}
+	mm.allModulesByName = map[string]*Module{
+		"prometheus":   newModule("prometheus", "monitoring"),
+		"grafana":      newModule("grafana", "monitoring"),
+		"fluent-bit":   newModule("fluent-bit", "logging"),
+		"cert-manager": newModule("cert-manager", ""),
+	}
+	mm.allModulesNamesInOrder = []string{"prometheus", "grafana", "fluent-bit", "cert-manager"}
+	mm.enabledModulesInOrder = mm.allModulesNamesInOrder
+
+	changed := mm.handleGroupsValuesUpdate(map[string]utils.Values{"monitoring": {"retention": "7d"}})
+	assert.Equal(t, []string{"prometheus", "grafana"}, changed)
+	assert.Equal(t, "7d", mm.allModulesByName["grafana"].values()["grafana"].(map[string]interface{})["retention"])
+
+	// module section overrides the group
+	mm.valuesStorage.SetKubeModuleConfigValues("prometheus", utils.Values{"prometheus": map[string]interface{}{"retention": "30d"}})
+	assert.Equal(t, "30d", mm.allModulesByName["prometheus"].values()["prometheus"].(map[string]interface{})["retention"])
+
+	changed = mm.handleGroupsValuesUpdate(map[string]utils.Values{"monitoring": {"retention": "7d"}, "logging": {"level": "debug"}})
+	assert.Equal(t, []string{"fluent-bit"}, changed)
+
+	// removed group reruns its modules
+	changed = mm.handleGroupsValuesUpdate(map[string]utils.Values{"logging": {"level": "debug"}})
+	assert.Equal(t, []string{"prometheus", "grafana"}, changed)
+	_, hasRetention := mm.allModulesByName["grafana"].values()["grafana"].(map[string]interface{})["retention"]
+	assert.False(t, hasRetention)
+}
@@ -0,0 +1,130 @@
+package module_manager
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/flant/antiopa/kube"
+)
+
+// Helm upgrade waits for objects of waitFor this long if timeout is not set
+const DefaultHelmUpgradeGateTimeout = 10 * time.Minute
+
+// Objects of waitFor are checked with this period
+var HelmUpgradeGateCheckPeriod = 5 * time.Second
+
+// HelmUpgradeGateObject is an object that should exist before helm upgrade
+type HelmUpgradeGateObject struct {
+	ApiVersion string `yaml:"apiVersion"`
+	Kind       string `yaml:"kind"`
+	// namespace of the module release if empty
+	Namespace string `yaml:"namespace"`
+	Name      string `yaml:"name"`
+}
+
+func (o HelmUpgradeGateObject) String() string {
+	if o.Namespace == "" {
+		return fmt.Sprintf("%s/%s", o.Kind, o.Name)
+	}
+	return fmt.Sprintf("%s/%s/%s", o.Namespace, o.Kind, o.Name)
+}
+
+// HelmUpgradeGateDefinition delays helm upgrade of the module release after beforeHelm hooks,
+// e.g. until a Secret requested by hooks is issued. ModuleRun task is removed from the queue
+// while helm upgrade waits and is queued again later, so the queue is not blocked.
+type HelmUpgradeGateDefinition struct {
+	// delay after beforeHelm hooks, e.g. "30s"
+	Delay string `yaml:"delay"`
+	// objects that should exist before helm upgrade
+	WaitFor []HelmUpgradeGateObject `yaml:"waitFor"`
+	// module run fails if objects do not exist after this timeout, e.g. "10m"
+	Timeout string `yaml:"timeout"`
+
+	delay   time.Duration
+	timeout time.Duration
+}
+
+func (g *HelmUpgradeGateDefinition) init() error {
+	var err error
+	if g.Delay != "" {
+		if g.delay, err = time.ParseDuration(g.Delay); err != nil || g.delay < 0 {
+			return fmt.Errorf("bad delay '%s'", g.Delay)
+		}
+	}
+	g.timeout = DefaultHelmUpgradeGateTimeout
+	if g.Timeout != "" {
+		if g.timeout, err = time.ParseDuration(g.Timeout); err != nil || g.timeout <= 0 {
+			return fmt.Errorf("bad timeout '%s'", g.Timeout)
+		}
+	}
+	for _, obj := range g.WaitFor {
+		if obj.ApiVersion == "" || obj.Kind == "" || obj.Name == "" {
+			return fmt.Errorf("waitFor object '%s' should have apiVersion, kind and name", obj)
+		}
+	}
+	return nil
+}
+
+func (g *HelmUpgradeGateDefinition) empty() bool {
+	return g.delay == 0 && len(g.WaitFor) == 0
+}
+
+// helmUpgradeWait is started when beforeHelm hooks are run and helm upgrade is needed
+type helmUpgradeWait struct {
+	since time.Time
+	// values after beforeHelm hooks, hooks are not rerun on retry if values are not changed
+	valuesChecksum string
+}
+
+// skipBeforeHelmHooks returns true if helm upgrade waits and values are not changed since beforeHelm hooks
+func (m *Module) skipBeforeHelmHooks() bool {
+	if m.helmUpgradeWait == nil {
+		return false
+	}
+	checksum, err := valuesChecksum(m.convergeValues())
+	return err == nil && checksum == m.helmUpgradeWait.valuesChecksum
+}
+
+// checkHelmUpgradeGate returns ErrHelmUpgradeWaiting if helm upgrade should wait for the delay
+// or for objects of waitFor. Error is returned if objects do not exist after the timeout.
+// hooksRun is true if beforeHelm hooks are run in this module run, waiting starts again then.
+func (m *Module) checkHelmUpgradeGate(releaseNamespace string, hooksRun bool, now time.Time) error {
+	if m.Definition == nil || m.Definition.HelmUpgradeGate.empty() {
+		return nil
+	}
+	gate := m.Definition.HelmUpgradeGate
+
+	if hooksRun || m.helmUpgradeWait == nil {
+		checksum, err := valuesChecksum(m.convergeValues())
+		if err != nil {
+			return err
+		}
+		m.helmUpgradeWait = &helmUpgradeWait{since: now, valuesChecksum: checksum}
+	}
+	since := m.helmUpgradeWait.since
+
+	if readyAt := since.Add(gate.delay); now.Before(readyAt) {
+		return &ErrHelmUpgradeWaiting{Module: m.Name, Reason: fmt.Sprintf("delay %s after beforeHelm hooks", gate.delay), RetryAt: readyAt}
+	}
+
+	for _, obj := range gate.WaitFor {
+		if obj.Namespace == "" {
+			obj.Namespace = releaseNamespace
+		}
+		exists, err := kube.ObjectExists(obj.ApiVersion, obj.Kind, obj.Namespace, obj.Name)
+		if err != nil {
+			return fmt.Errorf("module '%s': cannot check %s before helm upgrade: %s", m.Name, obj, err)
+		}
+		if exists {
+			continue
+		}
+		if now.Sub(since) >= gate.timeout {
+			m.helmUpgradeWait = nil
+			return fmt.Errorf("module '%s': %s does not exist %s after beforeHelm hooks", m.Name, obj, gate.timeout)
+		}
+		return &ErrHelmUpgradeWaiting{Module: m.Name, Reason: fmt.Sprintf("waiting for %s", obj), RetryAt: now.Add(HelmUpgradeGateCheckPeriod)}
+	}
+
+	m.helmUpgradeWait = nil
+	return nil
+}
@@ -0,0 +1,57 @@
+package module_manager
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/flant/antiopa/utils"
+)
+
+func TestHelmUpgradeGateDefinition_init(t *testing.T) {
+	gate := HelmUpgradeGateDefinition{Delay: "30s"}
+	assert.NoError(t, gate.init())
+	assert.Equal(t, 30*time.Second, gate.delay)
+	assert.Equal(t, DefaultHelmUpgradeGateTimeout, gate.timeout)
+	assert.False(t, gate.empty())
+
+	gate = HelmUpgradeGateDefinition{}
+	assert.NoError(t, gate.init())
+	assert.True(t, gate.empty())
+
+	assert.Error(t, (&HelmUpgradeGateDefinition{Delay: "soon"}).init())
+	assert.Error(t, (&HelmUpgradeGateDefinition{Timeout: "-1m"}).init())
+	assert.Error(t, (&HelmUpgradeGateDefinition{WaitFor: []HelmUpgradeGateObject{{Kind: "Secret", Name: "tls"}}}).init())
+}
+
+func TestModule_checkHelmUpgradeGate(t *testing.T) {
+	mm := NewMainModuleManager(&MockHelmClient{}, nil)
+	m := &Module{Name: "dex", moduleManager: mm, StaticConfig: utils.NewModuleConfig("dex"), Definition: NewModuleDefinition()}
+	mm.allModulesByName["dex"] = m
+
+	now := time.Now()
+	// module without gate is not delayed
+	assert.NoError(t, m.checkHelmUpgradeGate("default", true, now))
+	assert.False(t, m.skipBeforeHelmHooks())
+
+	m.Definition.HelmUpgradeGate = HelmUpgradeGateDefinition{Delay: "30s"}
+	assert.NoError(t, m.Definition.HelmUpgradeGate.init())
+
+	err := m.checkHelmUpgradeGate("default", true, now)
+	if assert.True(t, IsHelmUpgradeWaiting(err)) {
+		assert.Equal(t, now.Add(30*time.Second), err.(*ErrHelmUpgradeWaiting).RetryAt)
+		assert.Equal(t, "helm upgrade of module 'dex' waits: delay 30s after beforeHelm hooks", err.Error())
+	}
+	// values are not changed, so hooks are not run again on retry
+	assert.True(t, m.skipBeforeHelmHooks())
+
+	// delay is counted from the first run of hooks
+	err = m.checkHelmUpgradeGate("default", false, now.Add(10*time.Second))
+	if assert.True(t, IsHelmUpgradeWaiting(err)) {
+		assert.Equal(t, now.Add(30*time.Second), err.(*ErrHelmUpgradeWaiting).RetryAt)
+	}
+
+	assert.NoError(t, m.checkHelmUpgradeGate("default", false, now.Add(30*time.Second)))
+	assert.False(t, m.skipBeforeHelmHooks())
+}
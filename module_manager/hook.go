@@ -6,14 +6,22 @@ import (
 	"os"
 	"os/exec"
 	"path/filepath"
+	"sort"
 	"strings"
+	"sync"
+	"time"
 
 	"github.com/kennygrant/sanitize"
 	"github.com/romana/rlog"
+	"gopkg.in/robfig/cron.v2"
 	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
 
 	"github.com/flant/antiopa/executor"
+	"github.com/flant/antiopa/failure_injection"
+	"github.com/flant/antiopa/kube"
 	"github.com/flant/antiopa/utils"
+	"github.com/flant/antiopa/vault"
+	"github.com/flant/antiopa/version"
 )
 
 type GlobalHook struct {
@@ -34,12 +42,18 @@ type Hook struct {
 	OrderByBinding map[BindingType]float64
 
 	moduleManager *MainModuleManager
+
+	// files of the hook run are named after the hook, so runs of the same hook
+	// from different queues are serialized
+	execMutex sync.Mutex
 }
 
 type GlobalHookConfig struct {
 	HookConfig
 	BeforeAll interface{} `json:"beforeAll"`
 	AfterAll  interface{} `json:"afterAll"`
+	// hooks are run in order when antiopa receives SIGTERM, see ShutdownTimeout
+	OnShutdown interface{} `json:"onShutdown"`
 }
 
 type ModuleHookConfig struct {
@@ -47,18 +61,86 @@ type ModuleHookConfig struct {
 	BeforeHelm      interface{} `json:"beforeHelm"`
 	AfterHelm       interface{} `json:"afterHelm"`
 	AfterDeleteHelm interface{} `json:"afterDeleteHelm"`
+	// Parallel hook does not depend on results of other hooks of the module,
+	// so beforeHelm and afterHelm hooks with this flag can run concurrently.
+	Parallel bool `json:"parallel"`
 }
 
 type HookConfig struct {
 	OnStartup         interface{}               `json:"onStartup"`
 	Schedule          []ScheduleConfig          `json:"schedule"`
 	OnKubernetesEvent []OnKubernetesEventConfig `json:"onKubernetesEvent"`
+	// NodeExec command is run on nodes before the hook, results are passed in the binding context
+	NodeExec *NodeExecConfig `json:"nodeExec"`
+	// hook is killed after timeout in seconds, HooksTimeout is used if 0
+	Timeout int `json:"timeout"`
+	// hook runs are held until these modules are converged successfully at least once
+	WaitForModules []string `json:"waitForModules"`
+	// format of CONFIG_VALUES_PATH and VALUES_PATH files: json or yaml, HooksValuesFormat is used if empty
+	ValuesFormat string `json:"valuesFormat"`
+	// RBAC of the ServiceAccount of the hook for kubectl in KUBECONFIG
+	KubernetesScope *KubernetesScopeConfig `json:"kubernetesScope"`
+	// hook is run in a Kubernetes Job with the ServiceAccount of the hook
+	Job *HookJobConfig `json:"job"`
+}
+
+// KubernetesScopeConfig is RBAC of the ServiceAccount of the hook
+type KubernetesScopeConfig struct {
+	// ClusterRole bound to the ServiceAccount, kube.HookClusterRole is used if empty.
+	// ServiceAccount has no bindings if both are empty.
+	ClusterRole string `json:"clusterRole"`
+	// ClusterRole is bound in these namespaces, in all namespaces if empty
+	Namespaces []string `json:"namespaces"`
+}
+
+// NodeExecConfig is a command to run in host namespaces of selected nodes
+type NodeExecConfig struct {
+	Command      []string          `json:"command"`
+	NodeSelector map[string]string `json:"nodeSelector"`
+	Image        string            `json:"image"`
+	// timeout in seconds
+	Timeout int `json:"timeout"`
 }
 
 type ScheduleConfig struct {
 	Name         string `json:"name"`
 	Crontab      string `json:"crontab"`
 	AllowFailure bool   `json:"allowFailure"`
+	// named queue for hook runs, main queue is used if empty
+	Queue string `json:"queue"`
+	// what to do if the previous run of the binding is queued or running, Allow if empty
+	ConcurrencyPolicy ScheduleConcurrencyPolicy `json:"concurrencyPolicy"`
+}
+
+// ScheduleConcurrencyPolicy is like concurrencyPolicy of CronJob
+type ScheduleConcurrencyPolicy string
+
+const (
+	// runs are queued on each tick
+	ScheduleConcurrencyAllow ScheduleConcurrencyPolicy = "Allow"
+	// tick is skipped if the previous run is not done
+	ScheduleConcurrencyForbid ScheduleConcurrencyPolicy = "Forbid"
+	// the previous run is cancelled and a new run is queued
+	ScheduleConcurrencyReplace ScheduleConcurrencyPolicy = "Replace"
+)
+
+// validateSchedules checks crontabs and policies, so a bad schedule fails hook loading
+// instead of a missing hook run
+func validateSchedules(schedules []ScheduleConfig) error {
+	for _, schedule := range schedules {
+		if strings.TrimSpace(schedule.Crontab) == "" {
+			return fmt.Errorf("crontab of schedule '%s' is empty", schedule.Name)
+		}
+		if _, err := cron.Parse(schedule.Crontab); err != nil {
+			return fmt.Errorf("bad crontab '%s' of schedule '%s': %s", schedule.Crontab, schedule.Name, err)
+		}
+		switch schedule.ConcurrencyPolicy {
+		case "", ScheduleConcurrencyAllow, ScheduleConcurrencyForbid, ScheduleConcurrencyReplace:
+		default:
+			return fmt.Errorf("unsupported concurrencyPolicy '%s' for schedule '%s', expected '%s', '%s' or '%s'", schedule.ConcurrencyPolicy, schedule.Crontab, ScheduleConcurrencyAllow, ScheduleConcurrencyForbid, ScheduleConcurrencyReplace)
+		}
+	}
+	return nil
 }
 
 type OnKubernetesEventType string
@@ -69,15 +151,57 @@ const (
 	KubernetesEventOnDelete OnKubernetesEventType = "delete"
 )
 
+// Names of watch events are accepted as aliases of event types in hook config
+var onKubernetesEventTypeAliases = map[string]OnKubernetesEventType{
+	"add":      KubernetesEventOnAdd,
+	"added":    KubernetesEventOnAdd,
+	"update":   KubernetesEventOnUpdate,
+	"modified": KubernetesEventOnUpdate,
+	"delete":   KubernetesEventOnDelete,
+	"deleted":  KubernetesEventOnDelete,
+}
+
+// normalizeKubernetesEventTypes replaces aliases with event types and removes duplicates
+func normalizeKubernetesEventTypes(eventTypes []OnKubernetesEventType) ([]OnKubernetesEventType, error) {
+	res := make([]OnKubernetesEventType, 0, len(eventTypes))
+	seen := make(map[OnKubernetesEventType]bool)
+	for _, eventType := range eventTypes {
+		normalized, ok := onKubernetesEventTypeAliases[strings.ToLower(string(eventType))]
+		if !ok {
+			return nil, fmt.Errorf("unknown event type '%s', expected add (Added), update (Modified) or delete (Deleted)", eventType)
+		}
+		if !seen[normalized] {
+			seen[normalized] = true
+			res = append(res, normalized)
+		}
+	}
+	if len(res) == 0 {
+		return nil, fmt.Errorf("event types are empty")
+	}
+	return res, nil
+}
+
 type OnKubernetesEventConfig struct {
 	Name              string                  `json:"name"`
 	EventTypes        []OnKubernetesEventType `json:"event"`
+	ApiVersion        string                  `json:"apiVersion"`
 	Kind              string                  `json:"kind"`
 	Selector          *metav1.LabelSelector   `json:"selector"`
 	NamespaceSelector *KubeNamespaceSelector  `json:"namespaceSelector"`
 	JqFilter          string                  `json:"jqFilter"`
 	AllowFailure      bool                    `json:"allowFailure"`
 	DisableDebug      bool                    `json:"disableDebug"`
+	// named queue for hook runs, main queue is used if empty
+	Queue string `json:"queue"`
+	// events are collected during this period (e.g. "10s") and the hook is run once with
+	// the last event of each changed object, the hook is run on each event if empty
+	AggregationPeriod string `json:"aggregationPeriod"`
+}
+
+// AggregationPeriodDuration returns the parsed aggregation period, 0 if events are not aggregated
+func (c OnKubernetesEventConfig) AggregationPeriodDuration() time.Duration {
+	period, _ := time.ParseDuration(c.AggregationPeriod)
+	return period
 }
 
 type KubeNamespaceSelector struct {
@@ -136,7 +260,18 @@ func (mm *MainModuleManager) addGlobalHook(name, path string, config *GlobalHook
 		mm.globalHooksOrder[OnStartup] = append(mm.globalHooksOrder[OnStartup], globalHook)
 	}
 
+	if config.OnShutdown != nil {
+		globalHook.Bindings = append(globalHook.Bindings, OnShutdown)
+		if globalHook.OrderByBinding[OnShutdown], ok = config.OnShutdown.(float64); !ok {
+			return fmt.Errorf("unsuported value '%v' for binding '%s'", config.OnShutdown, OnShutdown)
+		}
+		mm.globalHooksOrder[OnShutdown] = append(mm.globalHooksOrder[OnShutdown], globalHook)
+	}
+
 	if len(config.Schedule) != 0 {
+		if err := validateSchedules(config.Schedule); err != nil {
+			return err
+		}
 		globalHook.Bindings = append(globalHook.Bindings, Schedule)
 		mm.globalHooksOrder[Schedule] = append(mm.globalHooksOrder[Schedule], globalHook)
 	}
@@ -193,6 +328,9 @@ func (mm *MainModuleManager) addModuleHook(moduleName, name, path string, config
 	}
 
 	if len(config.Schedule) != 0 {
+		if err := validateSchedules(config.Schedule); err != nil {
+			return err
+		}
 		moduleHook.Bindings = append(moduleHook.Bindings, Schedule)
 		mm.addModulesHooksOrderByName(moduleName, Schedule, moduleHook)
 	}
@@ -229,12 +367,12 @@ func (h *GlobalHook) handleGlobalValuesPatch(currentValues utils.Values, valuesP
 	acceptableKey := "global"
 
 	if err := validateHookValuesPatch(valuesPatch, acceptableKey); err != nil {
-		return nil, fmt.Errorf("merge global values failed: %s", err)
+		return nil, &ErrValuesInvalid{Hook: h.Name, Err: err}
 	}
 
 	newValuesRaw, valuesChanged, err := utils.ApplyValuesPatch(currentValues, valuesPatch)
 	if err != nil {
-		return nil, fmt.Errorf("merge global values failed: %s", err)
+		return nil, &ErrValuesInvalid{Hook: h.Name, Err: err}
 	}
 
 	result := &globalValuesMergeResult{
@@ -246,7 +384,7 @@ func (h *GlobalHook) handleGlobalValuesPatch(currentValues utils.Values, valuesP
 	if globalValuesRaw, hasKey := newValuesRaw[acceptableKey]; hasKey {
 		globalValues, ok := globalValuesRaw.(map[string]interface{})
 		if !ok {
-			return nil, fmt.Errorf("expected map at key '%s', got:\n%s", acceptableKey, utils.YamlToString(globalValuesRaw))
+			return nil, &ErrValuesInvalid{Hook: h.Name, Err: fmt.Errorf("expected map at key '%s', got:\n%s", acceptableKey, utils.YamlToString(globalValuesRaw))}
 		}
 
 		result.Values[acceptableKey] = globalValues
@@ -256,43 +394,45 @@ func (h *GlobalHook) handleGlobalValuesPatch(currentValues utils.Values, valuesP
 	return result, nil
 }
 
-func (h *GlobalHook) run(bindingType BindingType, context []BindingContext) error {
-	rlog.Infof("Running global hook '%s' binding '%s' ...", h.Name, bindingType)
+func (h *GlobalHook) run(bindingType BindingType, context []BindingContext, taskId string) error {
+	rlog.Infof("Running global hook '%s' binding '%s' task '%s' ...", h.Name, bindingType, taskId)
 
-	configValuesPatch, valuesPatch, err := h.exec(context)
+	configValuesPatch, valuesPatch, err := h.exec(context, taskId)
 	if err != nil {
-		return fmt.Errorf("global hook '%s' failed: %s", h.Name, err)
+		return &ErrHookFailed{Hook: h.Name, Err: err}
 	}
 
 	if configValuesPatch != nil {
 		preparedConfigValues := utils.MergeValues(
 			utils.Values{"global": map[string]interface{}{}},
-			h.moduleManager.kubeGlobalConfigValues,
+			h.moduleManager.valuesStorage.KubeGlobalConfigValues(),
 		)
 
 		configValuesPatchResult, err := h.handleGlobalValuesPatch(preparedConfigValues, *configValuesPatch)
 		if err != nil {
-			return fmt.Errorf("global hook '%s': kube config global values update error: %s", h.Name, err)
+			rlog.Errorf("Global hook '%s': kube config global values update error", h.Name)
+			return err
 		}
 
 		if configValuesPatchResult.ValuesChanged {
 			if err := h.moduleManager.kubeConfigManager.SetKubeGlobalValues(configValuesPatchResult.Values); err != nil {
-				rlog.Debugf("Global hook '%s' kube config global values stay unchanged:\n%s", utils.ValuesToString(h.moduleManager.kubeGlobalConfigValues))
+				rlog.Debugf("Global hook '%s' kube config global values stay unchanged:\n%s", utils.ValuesToString(h.moduleManager.valuesStorage.KubeGlobalConfigValues()))
 				return fmt.Errorf("global hook '%s': set kube config failed: %s", h.Name, err)
 			}
 
-			h.moduleManager.kubeGlobalConfigValues = configValuesPatchResult.Values
-			rlog.Debugf("Global hook '%s': kube config global values updated:\n%s", h.Name, utils.ValuesToString(h.moduleManager.kubeGlobalConfigValues))
+			h.moduleManager.valuesStorage.SetKubeGlobalConfigValues(configValuesPatchResult.Values)
+			rlog.Debugf("Global hook '%s': kube config global values updated:\n%s", h.Name, utils.ValuesToString(configValuesPatchResult.Values))
 		}
 	}
 
 	if valuesPatch != nil {
 		valuesPatchResult, err := h.handleGlobalValuesPatch(h.values(), *valuesPatch)
 		if err != nil {
-			return fmt.Errorf("global hook '%s': dynamic global values update error: %s", h.Name, err)
+			rlog.Errorf("Global hook '%s': dynamic global values update error", h.Name)
+			return err
 		}
 		if valuesPatchResult.ValuesChanged {
-			h.moduleManager.globalDynamicValuesPatches = utils.AppendValuesPatch(h.moduleManager.globalDynamicValuesPatches, valuesPatchResult.ValuesPatch)
+			h.moduleManager.valuesStorage.AppendGlobalDynamicValuesPatch(valuesPatchResult.ValuesPatch)
 			rlog.Debugf("Global hook '%s': global values updated:\n%s", h.Name, utils.ValuesToString(h.values()))
 		}
 	}
@@ -300,12 +440,15 @@ func (h *GlobalHook) run(bindingType BindingType, context []BindingContext) erro
 	return nil
 }
 
-func (h *GlobalHook) exec(context []BindingContext) (*utils.ValuesPatch, *utils.ValuesPatch, error) {
-	configValuesPath, err := h.prepareConfigValuesJsonFile()
+func (h *GlobalHook) exec(context []BindingContext, taskId string) (*utils.ValuesPatch, *utils.ValuesPatch, error) {
+	h.execMutex.Lock()
+	defer h.execMutex.Unlock()
+
+	context, err := h.runNodeExec(h.Config.NodeExec, context)
 	if err != nil {
 		return nil, nil, err
 	}
-	valuesPath, err := h.prepareValuesJsonFile()
+	configValuesPath, valuesPath, valuesEnvs, err := prepareHookValuesFiles(h, h.Config.valuesFormat())
 	if err != nil {
 		return nil, nil, err
 	}
@@ -313,7 +456,16 @@ func (h *GlobalHook) exec(context []BindingContext) (*utils.ValuesPatch, *utils.
 	if err != nil {
 		return nil, nil, err
 	}
-	cmd := h.moduleManager.makeHookCommand(WorkingDir, configValuesPath, valuesPath, contextPath, h.Path, []string{}, []string{})
+	kubeConfigPath, err := h.prepareKubeConfigFile()
+	if err != nil {
+		return nil, nil, err
+	}
+	stateEnvs, statePatchPath, err := h.moduleManager.hooksState.prepareFiles(GlobalHooksStateNamespace, h.SafeName())
+	if err != nil {
+		return nil, nil, err
+	}
+	envs := append(append(valuesEnvs, taskIdEnvs(taskId)...), stateEnvs...)
+	cmd := h.moduleManager.makeHookCommand(WorkingDir, configValuesPath, valuesPath, contextPath, kubeConfigPath, h.Path, []string{}, envs)
 
 	configValuesPatchPath, err := h.prepareConfigValuesJsonPatchFile()
 	if err != nil {
@@ -323,35 +475,53 @@ func (h *GlobalHook) exec(context []BindingContext) (*utils.ValuesPatch, *utils.
 	if err != nil {
 		return nil, nil, err
 	}
-	return h.moduleManager.execHook(h.Name, configValuesPatchPath, valuesPatchPath, cmd)
+	configValuesPatch, valuesPatch, err := h.moduleManager.execHook(h.Hook, &h.Config.HookConfig, configValuesPatchPath, valuesPatchPath, cmd)
+	if err != nil {
+		return nil, nil, err
+	}
+	if err := h.moduleManager.hooksState.applyPatchFile(GlobalHooksStateNamespace, statePatchPath); err != nil {
+		return nil, nil, fmt.Errorf("got bad state patch from hook %s: %s", h.Name, err)
+	}
+	return configValuesPatch, valuesPatch, nil
 }
 
 func (h *GlobalHook) configValues() utils.Values {
 	return utils.MergeValues(
 		utils.Values{"global": map[string]interface{}{}},
-		h.moduleManager.kubeGlobalConfigValues,
+		h.moduleManager.valuesStorage.KubeGlobalConfigValues(),
 	)
 }
 
 func (h *GlobalHook) values() utils.Values {
+	return h.moduleManager.globalHooksValues(fmt.Sprintf("global hook '%s'", h.Name))
+}
+
+// globalHooksValues returns effective values for global hooks, owner is used in errors of values templates
+func (mm *MainModuleManager) globalHooksValues(owner string) utils.Values {
 	var err error
 
 	res := utils.MergeValues(
 		utils.Values{"global": map[string]interface{}{}},
-		h.moduleManager.globalStaticValues,
-		h.moduleManager.kubeGlobalConfigValues,
+		mm.valuesStorage.GlobalStaticValues(),
+		mm.valuesStorage.ExternalValuesSection("global"),
+		mm.valuesStorage.KubeGlobalConfigValues(),
 	)
 
 	// Invariant: do not store patches that does not apply
 	// Give user error for patches early, after patch receive
-	for _, patch := range h.moduleManager.globalDynamicValuesPatches {
+	for _, patch := range mm.valuesStorage.GlobalDynamicValuesPatches() {
 		res, _, err = utils.ApplyValuesPatch(res, patch)
 		if err != nil {
 			panic(err)
 		}
 	}
 
-	return res
+	res = evaluateValuesTemplates(res, owner)
+
+	// global hooks see modules enabled by the last discovery, e.g. afterAll hooks
+	res = utils.MergeValues(res, enabledModulesValues(mm.enabledModulesInOrder))
+
+	return mm.setNodePlatformsValues(mm.setFeatureGatesValues(res))
 }
 
 func (h *GlobalHook) prepareConfigValuesYamlFile() (string, error) {
@@ -387,9 +557,14 @@ func (h *GlobalHook) prepareConfigValuesJsonFile() (string, error) {
 func (h *GlobalHook) prepareValuesYamlFile() (string, error) {
 	values := h.values()
 
-	data := utils.MustDump(utils.DumpValuesYaml(values))
+	resolvedValues, err := vault.ResolveValues(values)
+	if err != nil {
+		return "", fmt.Errorf("global hook '%s' values: %s", h.Name, err)
+	}
+
+	data := utils.MustDump(utils.DumpValuesYaml(resolvedValues))
 	path := filepath.Join(TempDir, fmt.Sprintf("global-hook-%s-values.yaml", h.SafeName()))
-	err := dumpData(path, data)
+	err = dumpData(path, data)
 	if err != nil {
 		return "", err
 	}
@@ -402,9 +577,14 @@ func (h *GlobalHook) prepareValuesYamlFile() (string, error) {
 func (h *GlobalHook) prepareValuesJsonFile() (string, error) {
 	values := h.values()
 
-	data := utils.MustDump(utils.DumpValuesJson(values))
+	resolvedValues, err := vault.ResolveValues(values)
+	if err != nil {
+		return "", fmt.Errorf("global hook '%s' values: %s", h.Name, err)
+	}
+
+	data := utils.MustDump(utils.DumpValuesJson(resolvedValues))
 	path := filepath.Join(TempDir, fmt.Sprintf("global-hook-%s-values.json", h.SafeName()))
-	err := dumpData(path, data)
+	err = dumpData(path, data)
 	if err != nil {
 		return "", err
 	}
@@ -446,12 +626,12 @@ func (h *ModuleHook) handleModuleValuesPatch(currentValues utils.Values, valuesP
 	moduleValuesKey := utils.ModuleNameToValuesKey(h.Module.Name)
 
 	if err := validateHookValuesPatch(valuesPatch, moduleValuesKey); err != nil {
-		return nil, fmt.Errorf("merge module '%s' values failed: %s", h.Module.Name, err)
+		return nil, &ErrValuesInvalid{Module: h.Module.Name, Hook: h.Name, Err: err}
 	}
 
 	newValuesRaw, valuesChanged, err := utils.ApplyValuesPatch(currentValues, valuesPatch)
 	if err != nil {
-		return nil, fmt.Errorf("merge module '%s' values failed: %s", h.Module.Name, err)
+		return nil, &ErrValuesInvalid{Module: h.Module.Name, Hook: h.Name, Err: err}
 	}
 
 	result := &moduleValuesMergeResult{
@@ -464,7 +644,7 @@ func (h *ModuleHook) handleModuleValuesPatch(currentValues utils.Values, valuesP
 	if moduleValuesRaw, hasKey := newValuesRaw[result.ModuleValuesKey]; hasKey {
 		moduleValues, ok := moduleValuesRaw.(map[string]interface{})
 		if !ok {
-			return nil, fmt.Errorf("expected map at key '%s', got:\n%s", result.ModuleValuesKey, utils.YamlToString(moduleValuesRaw))
+			return nil, &ErrValuesInvalid{Module: h.Module.Name, Hook: h.Name, Err: fmt.Errorf("expected map at key '%s', got:\n%s", result.ModuleValuesKey, utils.YamlToString(moduleValuesRaw))}
 		}
 		result.Values[result.ModuleValuesKey] = moduleValues
 		result.ModuleValues = moduleValues
@@ -479,11 +659,18 @@ func validateHookValuesPatch(valuesPatch utils.ValuesPatch, acceptableKey string
 			return fmt.Errorf("unsupported patch operation '%s': '%s'", op.Op, op.ToString())
 		}
 
-		pathParts := strings.Split(op.Path, "/")
-		if len(pathParts) > 1 {
-			affectedKey := pathParts[1]
-			if affectedKey != acceptableKey {
-				return fmt.Errorf("unacceptable patch operation path '%s' (only '%s' accepted): '%s'", affectedKey, acceptableKey, op.ToString())
+		paths := []string{op.Path}
+		// move and copy read values from another path
+		if op.Op == "move" || op.Op == "copy" {
+			paths = append(paths, op.From)
+		}
+		for _, path := range paths {
+			pathParts := strings.Split(path, "/")
+			if len(pathParts) > 1 {
+				affectedKey := pathParts[1]
+				if affectedKey != acceptableKey {
+					return fmt.Errorf("unacceptable patch operation path '%s' (only '%s' accepted): '%s'", affectedKey, acceptableKey, op.ToString())
+				}
 			}
 		}
 	}
@@ -491,44 +678,52 @@ func validateHookValuesPatch(valuesPatch utils.ValuesPatch, acceptableKey string
 	return nil
 }
 
-func (h *ModuleHook) run(bindingType BindingType, context []BindingContext) error {
+func (h *ModuleHook) run(bindingType BindingType, context []BindingContext, taskId string) error {
 	moduleName := h.Module.Name
-	rlog.Infof("Running module hook '%s' binding '%s' ...", h.Name, bindingType)
+	logger := h.Module.log(fmt.Sprintf("hook %s", h.Name))
+	logger.Infof("run binding '%s' task '%s' ...", bindingType, taskId)
 
-	configValuesPatch, valuesPatch, err := h.exec(context)
+	configValuesPatch, valuesPatch, err := h.exec(bindingType, context, taskId)
 	if err != nil {
-		return fmt.Errorf("module hook '%s' failed: %s", h.Name, err)
+		logger.Errorf("%s", err)
+		return &ErrHookFailed{Module: moduleName, Hook: h.Name, Err: err}
 	}
 
+	// results of parallel hooks are applied one by one
+	h.Module.hooksResultsMutex.Lock()
+	defer h.Module.hooksResultsMutex.Unlock()
+
 	if configValuesPatch != nil {
 		preparedConfigValues := utils.MergeValues(
 			utils.Values{utils.ModuleNameToValuesKey(moduleName): map[string]interface{}{}},
-			h.moduleManager.kubeModulesConfigValues[moduleName],
+			h.moduleManager.valuesStorage.KubeModuleConfigValues(moduleName),
 		)
 
 		configValuesPatchResult, err := h.handleModuleValuesPatch(preparedConfigValues, *configValuesPatch)
 		if err != nil {
-			return fmt.Errorf("module hook '%s': kube module config values update error: %s", h.Name, err)
+			rlog.Errorf("Module hook '%s': kube module config values update error", h.Name)
+			return err
 		}
 		if configValuesPatchResult.ValuesChanged {
 			err := h.moduleManager.kubeConfigManager.SetKubeModuleValues(moduleName, configValuesPatchResult.Values)
 			if err != nil {
-				rlog.Debugf("Module hook '%s' kube module config values stay unchanged:\n%s", utils.ValuesToString(h.moduleManager.kubeModulesConfigValues[moduleName]))
+				rlog.Debugf("Module hook '%s' kube module config values stay unchanged:\n%s", utils.ValuesToString(h.moduleManager.valuesStorage.KubeModuleConfigValues(moduleName)))
 				return fmt.Errorf("module hook '%s': set kube module config failed: %s", h.Name, err)
 			}
 
-			h.moduleManager.kubeModulesConfigValues[moduleName] = configValuesPatchResult.Values
-			rlog.Debugf("Module hook '%s': kube module '%s' config values updated:\n%s", h.Name, moduleName, utils.ValuesToString(h.moduleManager.kubeModulesConfigValues[moduleName]))
+			h.moduleManager.valuesStorage.SetKubeModuleConfigValues(moduleName, configValuesPatchResult.Values)
+			rlog.Debugf("Module hook '%s': kube module '%s' config values updated:\n%s", h.Name, moduleName, utils.ValuesToString(configValuesPatchResult.Values))
 		}
 	}
 
 	if valuesPatch != nil {
 		valuesPatchResult, err := h.handleModuleValuesPatch(h.values(), *valuesPatch)
 		if err != nil {
-			return fmt.Errorf("module hook '%s': dynamic module values update error: %s", h.Name, err)
+			rlog.Errorf("Module hook '%s': dynamic module values update error", h.Name)
+			return err
 		}
 		if valuesPatchResult.ValuesChanged {
-			h.moduleManager.modulesDynamicValuesPatches[moduleName] = utils.AppendValuesPatch(h.moduleManager.modulesDynamicValuesPatches[moduleName], valuesPatchResult.ValuesPatch)
+			h.moduleManager.valuesStorage.AppendModuleDynamicValuesPatch(moduleName, valuesPatchResult.ValuesPatch)
 			rlog.Debugf("Module hook '%s': dynamic module '%s' values updated:\n%s", h.Name, moduleName, utils.ValuesToString(h.values()))
 		}
 	}
@@ -536,12 +731,15 @@ func (h *ModuleHook) run(bindingType BindingType, context []BindingContext) erro
 	return nil
 }
 
-func (h *ModuleHook) exec(context []BindingContext) (*utils.ValuesPatch, *utils.ValuesPatch, error) {
-	configValuesPath, err := h.prepareConfigValuesJsonFile()
+func (h *ModuleHook) exec(bindingType BindingType, context []BindingContext, taskId string) (*utils.ValuesPatch, *utils.ValuesPatch, error) {
+	h.execMutex.Lock()
+	defer h.execMutex.Unlock()
+
+	context, err := h.runNodeExec(h.Config.NodeExec, context)
 	if err != nil {
 		return nil, nil, err
 	}
-	valuesPath, err := h.prepareValuesJsonFile()
+	configValuesPath, valuesPath, valuesEnvs, err := prepareHookValuesFiles(h, h.Config.valuesFormat())
 	if err != nil {
 		return nil, nil, err
 	}
@@ -549,7 +747,29 @@ func (h *ModuleHook) exec(context []BindingContext) (*utils.ValuesPatch, *utils.
 	if err != nil {
 		return nil, nil, err
 	}
-	cmd := h.moduleManager.makeHookCommand(WorkingDir, configValuesPath, valuesPath, contextPath, h.Path, []string{}, []string{})
+	kubeConfigPath, err := h.prepareKubeConfigFile()
+	if err != nil {
+		return nil, nil, err
+	}
+	dir, entrypoint := WorkingDir, h.Path
+	if HooksIsolation {
+		runDir, err := h.prepareRunDir()
+		if err != nil {
+			return nil, nil, err
+		}
+		defer runDir.cleanup()
+		dir, entrypoint = runDir.workDir, runDir.entrypoint
+	}
+	stateEnvs, statePatchPath, err := h.moduleManager.hooksState.prepareFiles(h.Module.Name, h.SafeName())
+	if err != nil {
+		return nil, nil, err
+	}
+	envs := append(append(valuesEnvs, taskIdEnvs(taskId)...), stateEnvs...)
+	cmd := h.moduleManager.makeHookCommand(dir, configValuesPath, valuesPath, contextPath, kubeConfigPath, entrypoint, []string{}, envs)
+	if artifacts := h.Module.runArtifacts(); artifacts != nil {
+		artifacts.captureOutput(cmd, h.SafeName(), bindingType)
+	}
+	defer captureModuleLog(cmd, h.Module.Name, h.Name)()
 
 	configValuesPatchPath, err := h.prepareConfigValuesJsonPatchFile()
 	if err != nil {
@@ -560,7 +780,14 @@ func (h *ModuleHook) exec(context []BindingContext) (*utils.ValuesPatch, *utils.
 		return nil, nil, err
 	}
 
-	return h.moduleManager.execHook(h.Name, configValuesPatchPath, valuesPatchPath, cmd)
+	configValuesPatch, valuesPatch, err := h.moduleManager.execHook(h.Hook, &h.Config.HookConfig, configValuesPatchPath, valuesPatchPath, cmd)
+	if err != nil {
+		return nil, nil, err
+	}
+	if err := h.moduleManager.hooksState.applyPatchFile(h.Module.Name, statePatchPath); err != nil {
+		return nil, nil, fmt.Errorf("got bad state patch from hook %s: %s", h.Name, err)
+	}
+	return configValuesPatch, valuesPatch, nil
 }
 
 func (h *ModuleHook) configValues() utils.Values {
@@ -571,20 +798,47 @@ func (h *ModuleHook) values() utils.Values {
 	return h.Module.values()
 }
 
+// values files are separate for each hook because hooks can run in parallel
 func (h *ModuleHook) prepareValuesJsonFile() (string, error) {
-	return h.Module.prepareValuesJsonFile()
+	resolvedValues, err := vault.ResolveValues(h.values())
+	if err != nil {
+		return "", fmt.Errorf("module hook '%s' values: %s", h.Name, err)
+	}
+
+	path := filepath.Join(TempDir, fmt.Sprintf("%s.module-hook-%s-values.json", h.Module.SafeName(), h.SafeName()))
+	if err := dumpData(path, utils.MustDump(utils.DumpValuesJson(resolvedValues))); err != nil {
+		return "", err
+	}
+	return path, nil
 }
 
 func (h *ModuleHook) prepareValuesYamlFile() (string, error) {
-	return h.Module.prepareValuesYamlFile()
+	resolvedValues, err := vault.ResolveValues(h.values())
+	if err != nil {
+		return "", fmt.Errorf("module hook '%s' values: %s", h.Name, err)
+	}
+
+	path := filepath.Join(TempDir, fmt.Sprintf("%s.module-hook-%s-values.yaml", h.Module.SafeName(), h.SafeName()))
+	if err := dumpData(path, utils.MustDump(utils.DumpValuesYaml(resolvedValues))); err != nil {
+		return "", err
+	}
+	return path, nil
 }
 
 func (h *ModuleHook) prepareConfigValuesJsonFile() (string, error) {
-	return h.Module.prepareConfigValuesJsonFile()
+	path := filepath.Join(TempDir, fmt.Sprintf("%s.module-hook-%s-config-values.json", h.Module.SafeName(), h.SafeName()))
+	if err := dumpData(path, utils.MustDump(utils.DumpValuesJson(h.configValues()))); err != nil {
+		return "", err
+	}
+	return path, nil
 }
 
 func (h *ModuleHook) prepareConfigValuesYamlFile() (string, error) {
-	return h.Module.prepareConfigValuesYamlFile()
+	path := filepath.Join(TempDir, fmt.Sprintf("%s.module-hook-%s-config-values.yaml", h.Module.SafeName(), h.SafeName()))
+	if err := dumpData(path, utils.MustDump(utils.DumpValuesYaml(h.configValues()))); err != nil {
+		return "", err
+	}
+	return path, nil
 }
 
 func (h *ModuleHook) prepareBindingContextJsonFile(context []BindingContext) (string, error) {
@@ -601,18 +855,38 @@ func (h *ModuleHook) prepareBindingContextJsonFile(context []BindingContext) (st
 	return path, nil
 }
 
-func prepareHookConfig(hookConfig *HookConfig) {
+func prepareHookConfig(hookConfig *HookConfig) error {
+	if hookConfig.ValuesFormat != "" {
+		if err := checkHookValuesFormat(hookConfig.ValuesFormat); err != nil {
+			return fmt.Errorf("valuesFormat: %s", err)
+		}
+	}
+
 	for i := range hookConfig.OnKubernetesEvent {
 		config := &hookConfig.OnKubernetesEvent[i]
 
 		if config.EventTypes == nil {
 			config.EventTypes = []OnKubernetesEventType{KubernetesEventOnAdd, KubernetesEventOnUpdate, KubernetesEventOnDelete}
+		} else {
+			eventTypes, err := normalizeKubernetesEventTypes(config.EventTypes)
+			if err != nil {
+				return fmt.Errorf("onKubernetesEvent '%s': %s", config.Name, err)
+			}
+			config.EventTypes = eventTypes
 		}
 
 		if config.NamespaceSelector == nil {
 			config.NamespaceSelector = &KubeNamespaceSelector{Any: true}
 		}
+
+		if config.AggregationPeriod != "" {
+			if period, err := time.ParseDuration(config.AggregationPeriod); err != nil || period <= 0 {
+				return fmt.Errorf("onKubernetesEvent '%s': bad aggregationPeriod '%s', expected positive duration like '10s'", config.Name, config.AggregationPeriod)
+			}
+		}
 	}
+
+	return nil
 }
 
 func (mm *MainModuleManager) initGlobalHooks() error {
@@ -636,7 +910,9 @@ func (mm *MainModuleManager) initGlobalHooks() error {
 			return fmt.Errorf("unmarshaling global hook '%s' json failed: %s\nhook --config output: %s", hookName, err.Error(), output)
 		}
 
-		prepareHookConfig(&hookConfig.HookConfig)
+		if err := prepareHookConfig(&hookConfig.HookConfig); err != nil {
+			return fmt.Errorf("global hook '%s' config: %s", hookName, err)
+		}
 
 		if err := mm.addGlobalHook(hookName, hookPath, hookConfig); err != nil {
 			return fmt.Errorf("adding global hook '%s' failed: %s", hookName, err.Error())
@@ -675,7 +951,13 @@ func (mm *MainModuleManager) initModuleHooks(module *Module) error {
 			return fmt.Errorf("unmarshaling module hook '%s' json failed: %s", hookName, err.Error())
 		}
 
-		prepareHookConfig(&hookConfig.HookConfig)
+		if err := prepareHookConfig(&hookConfig.HookConfig); err != nil {
+			return fmt.Errorf("module hook '%s' config: %s", hookName, err)
+		}
+
+		if err := mm.checkWaitForModules(&hookConfig.HookConfig); err != nil {
+			return fmt.Errorf("module hook '%s' config: %s", hookName, err)
+		}
 
 		if err := mm.addModuleHook(module.Name, hookName, hookPath, hookConfig); err != nil {
 			return fmt.Errorf("adding module hook '%s' failed: %s", hookName, err.Error())
@@ -701,6 +983,10 @@ func (mm *MainModuleManager) initHooks(hooksDir string, addHook func(hookPath st
 		return err
 	}
 
+	if err := staticCheckHooks(hooksRelativePaths); err != nil {
+		return err
+	}
+
 	for _, hookPath := range hooksRelativePaths {
 		cmd := makeCommand(WorkingDir, hookPath, []string{}, []string{"--config"})
 		output, err := execCommandOutput(cmd)
@@ -716,6 +1002,72 @@ func (mm *MainModuleManager) initHooks(hooksDir string, addHook func(hookPath st
 	return nil
 }
 
+// kubernetesScope returns RBAC of the ServiceAccount of the hook
+func (c *HookConfig) kubernetesScope(hookName string) kube.HookScope {
+	scope := kube.HookScope{
+		ServiceAccount: kube.HookServiceAccountName(hookName),
+		ClusterRole:    kube.HookClusterRole,
+	}
+	if c.KubernetesScope != nil {
+		if c.KubernetesScope.ClusterRole != "" {
+			scope.ClusterRole = c.KubernetesScope.ClusterRole
+		}
+		scope.Namespaces = c.KubernetesScope.Namespaces
+	}
+	return scope
+}
+
+// hooksServiceAccounts returns names of ServiceAccounts of all global and module hooks
+func (mm *MainModuleManager) hooksServiceAccounts() []string {
+	res := make([]string, 0, len(mm.globalHooksByName)+len(mm.modulesHooksByName))
+	for name := range mm.globalHooksByName {
+		res = append(res, kube.HookServiceAccountName(name))
+	}
+	for name := range mm.modulesHooksByName {
+		res = append(res, kube.HookServiceAccountName(name))
+	}
+	sort.Strings(res)
+	return res
+}
+
+// writeHookKubeConfig writes a kubeconfig with a new token of the ServiceAccount of the hook
+func writeHookKubeConfig(path string, namespace string, hookName string, config *HookConfig) error {
+	token, err := kube.HookToken(config.kubernetesScope(hookName), config.ExecutionTimeout())
+	if err != nil {
+		return err
+	}
+	return kube.WriteKubeConfig(path, namespace, token)
+}
+
+// prepareKubeConfigFile writes a kubeconfig for kubectl in hook. Empty path is
+// returned if there is no connection to the cluster (e.g. fake client in dev mode).
+func (h *GlobalHook) prepareKubeConfigFile() (string, error) {
+	if kube.RestConfig == nil {
+		return "", nil
+	}
+	path := filepath.Join(TempDir, fmt.Sprintf("global-hook-%s-kubeconfig", h.SafeName()))
+	if err := writeHookKubeConfig(path, kube.KubernetesAntiopaNamespace, h.Name, &h.Config.HookConfig); err != nil {
+		return "", err
+	}
+	return path, nil
+}
+
+func (h *ModuleHook) prepareKubeConfigFile() (string, error) {
+	if kube.RestConfig == nil {
+		return "", nil
+	}
+	// module release is installed into the namespace of its tiller
+	helmClient, err := h.Module.HelmClient()
+	if err != nil {
+		return "", err
+	}
+	path := filepath.Join(TempDir, fmt.Sprintf("%s.module-hook-%s-kubeconfig", h.Module.SafeName(), h.SafeName()))
+	if err := writeHookKubeConfig(path, helmClient.TillerNamespace(), h.Name, &h.Config.HookConfig); err != nil {
+		return "", err
+	}
+	return path, nil
+}
+
 func (h *GlobalHook) prepareConfigValuesJsonPatchFile() (string, error) {
 	path := filepath.Join(TempDir, fmt.Sprintf("%s.global-hook-config-values.json-patch", h.SafeName()))
 	if err := createHookResultValuesFile(path); err != nil {
@@ -748,18 +1100,48 @@ func (h *ModuleHook) prepareValuesJsonPatchFile() (string, error) {
 	return path, nil
 }
 
-func (mm *MainModuleManager) execHook(hookName string, configValuesJsonPatchPath string, valuesJsonPatchPath string, cmd *exec.Cmd) (*utils.ValuesPatch, *utils.ValuesPatch, error) {
+func (mm *MainModuleManager) execHook(hook *Hook, config *HookConfig, configValuesJsonPatchPath string, valuesJsonPatchPath string, cmd *exec.Cmd) (*utils.ValuesPatch, *utils.ValuesPatch, error) {
+	hookName := hook.Name
+	timeout := config.ExecutionTimeout()
+
 	cmd.Env = append(
 		cmd.Env,
 		fmt.Sprintf("CONFIG_VALUES_JSON_PATCH_PATH=%s", configValuesJsonPatchPath),
 		fmt.Sprintf("VALUES_JSON_PATCH_PATH=%s", valuesJsonPatchPath),
 	)
 
+	if err := failure_injection.Take(failure_injection.HookTimeout, hookName); err != nil {
+		return nil, nil, fmt.Errorf("%s FAILED: deadline %s is exceeded: %s", hookName, timeout, err)
+	}
+
+	if config.Job != nil {
+		// Job is stopped by its activeDeadlineSeconds
+		if err := runHookJob(hook, config, cmd); err != nil {
+			return nil, nil, fmt.Errorf("%s FAILED: %s", hookName, err)
+		}
+		return readHookValuesPatches(hookName, configValuesJsonPatchPath, valuesJsonPatchPath)
+	}
+
+	deadline := newHookDeadline(hook, timeout)
+	if deadline != nil {
+		cmd.Env = append(cmd.Env, deadline.Env()...)
+		if err := deadline.Start(cmd); err != nil {
+			return nil, nil, err
+		}
+	}
+
 	err := executor.Run(cmd, true)
+	if deadline != nil && deadline.Stop() {
+		return nil, nil, fmt.Errorf("%s FAILED: deadline %s is exceeded", hookName, timeout)
+	}
 	if err != nil {
 		return nil, nil, fmt.Errorf("%s FAILED: %s", hookName, err)
 	}
 
+	return readHookValuesPatches(hookName, configValuesJsonPatchPath, valuesJsonPatchPath)
+}
+
+func readHookValuesPatches(hookName string, configValuesJsonPatchPath string, valuesJsonPatchPath string) (*utils.ValuesPatch, *utils.ValuesPatch, error) {
 	configValuesPatch, err := utils.ValuesPatchFromFile(configValuesJsonPatchPath)
 	if err != nil {
 		return nil, nil, fmt.Errorf("got bad config values json patch from hook %s: %s", hookName, err)
@@ -773,6 +1155,31 @@ func (mm *MainModuleManager) execHook(hookName string, configValuesJsonPatchPath
 	return configValuesPatch, valuesPatch, nil
 }
 
+// runNodeExec runs nodeExec command of the hook and adds results to each binding context
+func (h *Hook) runNodeExec(config *NodeExecConfig, context []BindingContext) ([]BindingContext, error) {
+	if config == nil || len(config.Command) == 0 {
+		return context, nil
+	}
+
+	results, err := kube.RunOnNodes(kube.NodeExecSpec{
+		Owner:        h.Name,
+		Command:      config.Command,
+		NodeSelector: config.NodeSelector,
+		Image:        config.Image,
+		Timeout:      time.Duration(config.Timeout) * time.Second,
+	})
+	if err != nil {
+		return nil, fmt.Errorf("hook '%s' nodeExec failed: %s", h.Name, err)
+	}
+
+	res := make([]BindingContext, len(context))
+	for i := range context {
+		res[i] = context[i]
+		res[i].NodeExecResults = results
+	}
+	return res, nil
+}
+
 func createHookResultValuesFile(filePath string) error {
 	file, err := os.OpenFile(filePath, os.O_RDWR|os.O_CREATE|os.O_TRUNC, 0666)
 	if err != nil {
@@ -784,7 +1191,8 @@ func createHookResultValuesFile(filePath string) error {
 }
 
 func makeCommand(dir string, entrypoint string, envs []string, args []string) *exec.Cmd {
-	envs = append(os.Environ(), envs...)
+	envs = append(hooksEnviron(), envs...)
+	envs = append(envs, fmt.Sprintf("%s=%s", version.VersionEnv, version.Version))
 	return utils.MakeCommand(dir, entrypoint, args, envs)
 }
 
@@ -794,7 +1202,7 @@ func execCommandOutput(cmd *exec.Cmd) ([]byte, error) {
 
 	output, err := executor.Output(cmd)
 	if err != nil {
-		rlog.Errorf("Hook '%s' output:\n%s", strings.Join(cmd.Args, " "), string(output))
+		utils.SampledErrorf(cmd.Path, "Hook '%s' output:\n%s", strings.Join(cmd.Args, " "), string(output))
 		return output, err
 	}
 
@@ -803,11 +1211,15 @@ func execCommandOutput(cmd *exec.Cmd) ([]byte, error) {
 	return output, nil
 }
 
-func (mm *MainModuleManager) makeHookCommand(dir string, configValuesPath string, valuesPath string, contextPath string, entrypoint string, args []string, envs []string) *exec.Cmd {
+func (mm *MainModuleManager) makeHookCommand(dir string, configValuesPath string, valuesPath string, contextPath string, kubeConfigPath string, entrypoint string, args []string, envs []string) *exec.Cmd {
 	envs = append(envs, fmt.Sprintf("CONFIG_VALUES_PATH=%s", configValuesPath))
 	envs = append(envs, fmt.Sprintf("VALUES_PATH=%s", valuesPath))
 	if contextPath != "" {
 		envs = append(envs, fmt.Sprintf("BINDING_CONTEXT_PATH=%s", contextPath))
 	}
+	if kubeConfigPath != "" {
+		envs = append(envs, fmt.Sprintf("KUBECONFIG=%s", kubeConfigPath))
+	}
+	envs = append(envs, mm.featureGatesEnv())
 	return mm.makeCommand(dir, entrypoint, args, envs)
 }
@@ -0,0 +1,98 @@
+package module_manager
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sync/atomic"
+	"time"
+
+	"github.com/romana/rlog"
+
+	"github.com/flant/antiopa/executor"
+)
+
+// Default timeout for hooks without timeout in config, hooks are not limited if it is 0
+var HooksTimeout time.Duration
+
+// Hook gets a cancellation file this time before the deadline
+const HookCancelGracePeriod = 10 * time.Second
+
+// ExecutionTimeout returns timeout for the hook from config or default HooksTimeout
+func (c *HookConfig) ExecutionTimeout() time.Duration {
+	if c.Timeout > 0 {
+		return time.Duration(c.Timeout) * time.Second
+	}
+	return HooksTimeout
+}
+
+// hookDeadline passes HOOK_DEADLINE (RFC3339) and HOOK_CANCEL_PATH to the hook.
+// The cancel file is created HookCancelGracePeriod before the deadline, so hook
+// can checkpoint and exit. Process group of the hook is killed at the deadline.
+type hookDeadline struct {
+	hookName   string
+	deadline   time.Time
+	cancelPath string
+
+	cancelTimer *time.Timer
+	killTimer   *time.Timer
+	exceeded    int32
+}
+
+func newHookDeadline(hook *Hook, timeout time.Duration) *hookDeadline {
+	if timeout <= 0 {
+		return nil
+	}
+	return &hookDeadline{
+		hookName:   hook.Name,
+		deadline:   time.Now().Add(timeout),
+		cancelPath: filepath.Join(TempDir, fmt.Sprintf("%s.hook-cancel", hook.SafeName())),
+	}
+}
+
+func (d *hookDeadline) Env() []string {
+	return []string{
+		fmt.Sprintf("HOOK_DEADLINE=%s", d.deadline.Format(time.RFC3339)),
+		fmt.Sprintf("HOOK_CANCEL_PATH=%s", d.cancelPath),
+	}
+}
+
+// Start removes cancel file from previous run and starts timers for the command
+func (d *hookDeadline) Start(cmd *exec.Cmd) error {
+	if err := os.Remove(d.cancelPath); err != nil && !os.IsNotExist(err) {
+		return fmt.Errorf("cannot remove cancel file '%s': %s", d.cancelPath, err)
+	}
+
+	timeout := d.deadline.Sub(time.Now())
+	grace := HookCancelGracePeriod
+	if grace > timeout/2 {
+		grace = timeout / 2
+	}
+
+	d.cancelTimer = time.AfterFunc(timeout-grace, func() {
+		rlog.Infof("Hook '%s' should exit before deadline %s", d.hookName, d.deadline.Format(time.RFC3339))
+		if err := ioutil.WriteFile(d.cancelPath, []byte("deadline\n"), 0644); err != nil {
+			rlog.Errorf("Hook '%s': cannot create cancel file '%s': %s", d.hookName, d.cancelPath, err)
+		}
+	})
+
+	d.killTimer = time.AfterFunc(timeout, func() {
+		atomic.StoreInt32(&d.exceeded, 1)
+		rlog.Errorf("Hook '%s' exceeded deadline %s, kill it", d.hookName, d.deadline.Format(time.RFC3339))
+		executor.KillRunning(func(c *exec.Cmd) bool {
+			return c == cmd
+		})
+	})
+
+	return nil
+}
+
+// Stop stops timers after the command is finished. True is returned if the command is killed.
+func (d *hookDeadline) Stop() bool {
+	d.cancelTimer.Stop()
+	d.killTimer.Stop()
+	os.Remove(d.cancelPath)
+	return atomic.LoadInt32(&d.exceeded) == 1
+}
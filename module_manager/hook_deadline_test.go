@@ -0,0 +1,47 @@
+package module_manager
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/flant/antiopa/executor"
+	"github.com/flant/antiopa/utils"
+)
+
+func TestHookDeadline(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "hook-deadline")
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer os.RemoveAll(tmpDir)
+	TempDir = tmpDir
+
+	// hook checkpoints and exits when cancel file is created
+	wellBehaved := filepath.Join(tmpDir, "well-behaved")
+	ioutil.WriteFile(wellBehaved, []byte("#!/bin/sh\n[ -n \"$HOOK_DEADLINE\" ] || exit 1\nwhile [ ! -f \"$HOOK_CANCEL_PATH\" ]; do sleep 0.1; done\n"), 0755)
+
+	deadline := newHookDeadline(&Hook{Name: "well-behaved"}, 2*time.Second)
+	cmd := utils.MakeCommand(tmpDir, wellBehaved, []string{}, deadline.Env())
+	assert.NoError(t, deadline.Start(cmd))
+	assert.NoError(t, executor.Run(cmd, false))
+	assert.False(t, deadline.Stop())
+
+	// hook ignores cancel file and is killed
+	stubborn := filepath.Join(tmpDir, "stubborn")
+	ioutil.WriteFile(stubborn, []byte("#!/bin/sh\nsleep 30\n"), 0755)
+
+	deadline = newHookDeadline(&Hook{Name: "stubborn"}, time.Second)
+	cmd = utils.MakeCommand(tmpDir, stubborn, []string{}, deadline.Env())
+	startedAt := time.Now()
+	assert.NoError(t, deadline.Start(cmd))
+	assert.Error(t, executor.Run(cmd, false))
+	assert.True(t, deadline.Stop())
+	assert.True(t, time.Since(startedAt) < 10*time.Second)
+
+	assert.Nil(t, newHookDeadline(&Hook{Name: "no-timeout"}, 0))
+}
@@ -0,0 +1,73 @@
+package module_manager
+
+import (
+	"os"
+	"strings"
+
+	"github.com/flant/antiopa/executor"
+)
+
+// Variables from antiopa environment that are passed to hooks, policies and
+// hooks --config runs. Other variables (tokens, Vault address, etc.) are not
+// passed, so secrets of antiopa do not leak into every hook.
+var DefaultHooksEnv = []string{
+	// to find executables and helm home
+	"PATH",
+	"HOME",
+	// locale and timezone for hooks output and schedule calculations
+	"LANG",
+	"LC_ALL",
+	"TZ",
+	"TMPDIR",
+	"HOSTNAME",
+	// in-cluster config for kubectl
+	"KUBERNETES_SERVICE_HOST",
+	"KUBERNETES_SERVICE_PORT",
+	// namespace of antiopa
+	"ANTIOPA_NAMESPACE",
+	// proxy settings for hooks that download something
+	"HTTP_PROXY",
+	"HTTPS_PROXY",
+	"NO_PROXY",
+	"http_proxy",
+	"https_proxy",
+	"no_proxy",
+}
+
+// Extra variables passed to hooks. Name with '*' at the end is a prefix, e.g. 'CLUSTER_*'.
+var HooksExtraEnv []string
+
+// hooksEnviron returns variables from antiopa environment allowed by
+// DefaultHooksEnv and HooksExtraEnv. Shims of the command wrapper are first in PATH.
+func hooksEnviron() []string {
+	environ := filterEnviron(os.Environ(), append(append([]string{}, DefaultHooksEnv...), HooksExtraEnv...))
+	return prependPath(environ, executor.WrapperShimsDir)
+}
+
+// prependPath adds dir to the beginning of PATH in environ
+func prependPath(environ []string, dir string) []string {
+	if dir == "" {
+		return environ
+	}
+	for i, env := range environ {
+		if strings.HasPrefix(env, "PATH=") {
+			environ[i] = "PATH=" + dir + ":" + strings.TrimPrefix(env, "PATH=")
+			return environ
+		}
+	}
+	return append(environ, "PATH="+dir)
+}
+
+func filterEnviron(environ []string, allowed []string) []string {
+	res := make([]string, 0)
+	for _, env := range environ {
+		name := strings.SplitN(env, "=", 2)[0]
+		for _, pattern := range allowed {
+			if pattern == name || (strings.HasSuffix(pattern, "*") && strings.HasPrefix(name, strings.TrimSuffix(pattern, "*"))) {
+				res = append(res, env)
+				break
+			}
+		}
+	}
+	return res
+}
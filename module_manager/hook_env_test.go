@@ -0,0 +1,32 @@
+package module_manager
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFilterEnviron(t *testing.T) {
+	environ := []string{
+		"PATH=/bin:/usr/bin",
+		"VAULT_TOKEN=secret",
+		"CLUSTER_NAME=main",
+		"CLUSTER_DOMAIN=cluster.local",
+		"CLUSTERED=true",
+		"EMPTY=",
+	}
+
+	res := filterEnviron(environ, []string{"PATH", "CLUSTER_*", "EMPTY"})
+	assert.Equal(t, []string{
+		"PATH=/bin:/usr/bin",
+		"CLUSTER_NAME=main",
+		"CLUSTER_DOMAIN=cluster.local",
+		"EMPTY=",
+	}, res)
+}
+
+func TestPrependPath(t *testing.T) {
+	assert.Equal(t, []string{"HOME=/root", "PATH=/tmp/shims:/bin:/usr/bin"}, prependPath([]string{"HOME=/root", "PATH=/bin:/usr/bin"}, "/tmp/shims"))
+	assert.Equal(t, []string{"HOME=/root", "PATH=/tmp/shims"}, prependPath([]string{"HOME=/root"}, "/tmp/shims"))
+	assert.Equal(t, []string{"PATH=/bin"}, prependPath([]string{"PATH=/bin"}, ""))
+}
@@ -0,0 +1,117 @@
+package module_manager
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"github.com/romana/rlog"
+
+	"github.com/flant/antiopa/kube"
+)
+
+// HookJobConfig runs the hook in a Kubernetes Job instead of the antiopa container
+type HookJobConfig struct {
+	// image with the hook at the same path as in antiopa, image of antiopa is used if empty
+	Image string `json:"image"`
+	// RuntimeClass of the pod for an alternate container runtime, e.g. gvisor or kata
+	RuntimeClassName string `json:"runtimeClassName"`
+}
+
+// runHookJob runs the hook command in a Job. Files of the hook run in TempDir are passed
+// to the Job and its output files are written back, so results of the hook are handled
+// as results of a local run.
+func runHookJob(hook *Hook, config *HookConfig, cmd *exec.Cmd) error {
+	spec, outputPaths, err := makeHookJobSpec(hook, config, cmd)
+	if err != nil {
+		return err
+	}
+	if spec.Image == "" {
+		spec.Image = kube.KubeGetDeploymentImageName()
+		if spec.Image == "" {
+			return fmt.Errorf("cannot get image of antiopa for the hook Job")
+		}
+	}
+	if err := kube.EnsureHookScope(config.kubernetesScope(hook.Name)); err != nil {
+		return err
+	}
+
+	result, err := kube.RunHookJob(spec)
+	if result != nil {
+		rlog.Debugf("Hook '%s' Job output:\n%s", hook.Name, result.Output)
+		if cmd.Stdout != nil {
+			cmd.Stdout.Write([]byte(result.Output))
+		}
+	}
+	if err != nil {
+		return err
+	}
+	if result.ExitCode != 0 {
+		return fmt.Errorf("Job exit status %d", result.ExitCode)
+	}
+
+	for name, path := range outputPaths {
+		if err := ioutil.WriteFile(path, result.Outputs[name], 0666); err != nil {
+			return fmt.Errorf("cannot write output '%s' of the hook Job: %s", name, err)
+		}
+	}
+	return nil
+}
+
+// makeHookJobSpec returns the Job spec for the hook command and local paths of its outputs
+// by names. Files in TempDir from the environment of the command are mounted into the
+// inputs dir, files of variables *_PATCH_PATH are collected from the outputs dir.
+// Environment of antiopa is not passed and kubectl uses the ServiceAccount of the pod.
+func makeHookJobSpec(hook *Hook, config *HookConfig, cmd *exec.Cmd) (kube.HookJobSpec, map[string]string, error) {
+	spec := kube.HookJobSpec{
+		Owner:          hook.Name,
+		Image:          config.Job.Image,
+		ServiceAccount: kube.HookServiceAccountName(hook.Name),
+		// run dirs of isolated hooks are local, the hook is run from the image
+		WorkingDir:       WorkingDir,
+		Command:          append([]string{hook.Path}, cmd.Args[1:]...),
+		RuntimeClassName: config.Job.RuntimeClassName,
+		Env:              make(map[string]string),
+		Inputs:           make(map[string][]byte),
+		Timeout:          config.ExecutionTimeout(),
+	}
+	outputPaths := make(map[string]string)
+
+	environ := make(map[string]bool)
+	for _, env := range hooksEnviron() {
+		environ[env] = true
+	}
+
+	tempDir := filepath.Clean(TempDir) + string(filepath.Separator)
+	for _, env := range cmd.Env {
+		if environ[env] {
+			continue
+		}
+		parts := strings.SplitN(env, "=", 2)
+		if len(parts) != 2 || parts[0] == "KUBECONFIG" {
+			continue
+		}
+		name, value := parts[0], parts[1]
+
+		if strings.HasPrefix(value, tempDir) {
+			key := filepath.Base(value)
+			if strings.HasSuffix(name, "_PATCH_PATH") {
+				spec.Outputs = append(spec.Outputs, key)
+				outputPaths[key] = value
+				value = filepath.Join(kube.HookJobOutputsDir, key)
+			} else {
+				data, err := ioutil.ReadFile(value)
+				if err != nil {
+					return spec, nil, fmt.Errorf("cannot read input '%s' of the hook Job: %s", name, err)
+				}
+				spec.Inputs[key] = data
+				value = filepath.Join(kube.HookJobInputsDir, key)
+			}
+		}
+		spec.Env[name] = value
+	}
+
+	return spec, outputPaths, nil
+}
@@ -0,0 +1,71 @@
+package module_manager
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/flant/antiopa/kube"
+	"github.com/flant/antiopa/utils"
+)
+
+func TestMakeHookJobSpec(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "hook-job")
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer os.RemoveAll(tmpDir)
+	defer func(dir string) { WorkingDir = dir }(WorkingDir)
+	TempDir = tmpDir
+	WorkingDir = "/antiopa"
+
+	valuesPath := filepath.Join(tmpDir, "startup.global-hook-values.json")
+	contextPath := filepath.Join(tmpDir, "startup.global-hook-binding-context.json")
+	patchPath := filepath.Join(tmpDir, "startup.global-hook-values.json-patch")
+	assert.NoError(t, ioutil.WriteFile(valuesPath, []byte(`{"global":{}}`), 0644))
+	assert.NoError(t, ioutil.WriteFile(contextPath, []byte(`[{"binding":"onStartup"}]`), 0644))
+
+	envs := append(hooksEnviron(),
+		fmt.Sprintf("VALUES_PATH=%s", valuesPath),
+		fmt.Sprintf("BINDING_CONTEXT_PATH=%s", contextPath),
+		fmt.Sprintf("KUBECONFIG=%s", filepath.Join(tmpDir, "global-hook-startup-kubeconfig")),
+		fmt.Sprintf("VALUES_JSON_PATCH_PATH=%s", patchPath),
+		"ANTIOPA_TASK_ID=42",
+	)
+	cmd := utils.MakeCommand(tmpDir, filepath.Join(tmpDir, "run", "startup"), []string{}, envs)
+
+	hook := &Hook{Name: "global-hooks/startup", Path: "/antiopa/global-hooks/startup"}
+	config := &HookConfig{Timeout: 30, Job: &HookJobConfig{Image: "hooks:stable", RuntimeClassName: "kata"}}
+
+	spec, outputPaths, err := makeHookJobSpec(hook, config, cmd)
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	assert.Equal(t, "hooks:stable", spec.Image)
+	assert.Equal(t, "kata", spec.RuntimeClassName)
+	assert.Equal(t, "antiopa-hook-global-hooks-startup", spec.ServiceAccount)
+	assert.Equal(t, 30*time.Second, spec.Timeout)
+	// hook is run from the image, not from the local run dir
+	assert.Equal(t, "/antiopa", spec.WorkingDir)
+	assert.Equal(t, []string{"/antiopa/global-hooks/startup"}, spec.Command)
+
+	// environment of antiopa and kubeconfig are not passed, paths point to dirs of the Job
+	assert.Equal(t, map[string]string{
+		"VALUES_PATH":            kube.HookJobInputsDir + "/startup.global-hook-values.json",
+		"BINDING_CONTEXT_PATH":   kube.HookJobInputsDir + "/startup.global-hook-binding-context.json",
+		"VALUES_JSON_PATCH_PATH": kube.HookJobOutputsDir + "/startup.global-hook-values.json-patch",
+		"ANTIOPA_TASK_ID":        "42",
+	}, spec.Env)
+	assert.Equal(t, map[string][]byte{
+		"startup.global-hook-values.json":          []byte(`{"global":{}}`),
+		"startup.global-hook-binding-context.json": []byte(`[{"binding":"onStartup"}]`),
+	}, spec.Inputs)
+	assert.Equal(t, []string{"startup.global-hook-values.json-patch"}, spec.Outputs)
+	assert.Equal(t, map[string]string{"startup.global-hook-values.json-patch": patchPath}, outputPaths)
+}
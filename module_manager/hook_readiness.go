@@ -0,0 +1,47 @@
+package module_manager
+
+import (
+	"fmt"
+)
+
+// checkWaitForModules returns error if hook waits for unknown modules
+func (mm *MainModuleManager) checkWaitForModules(hookConfig *HookConfig) error {
+	for _, moduleName := range hookConfig.WaitForModules {
+		if _, ok := mm.allModulesByName[moduleName]; !ok {
+			return fmt.Errorf("waitForModules: unknown module '%s'", moduleName)
+		}
+	}
+	return nil
+}
+
+// validateGlobalHooksWaitForModules checks waitForModules of global hooks. Global hooks
+// are loaded before modules, so the check is done after modules index is loaded.
+func (mm *MainModuleManager) validateGlobalHooksWaitForModules() error {
+	for hookName, hook := range mm.globalHooksByName {
+		if err := mm.checkWaitForModules(&hook.Config.HookConfig); err != nil {
+			return fmt.Errorf("global hook '%s' config: %s", hookName, err)
+		}
+	}
+	return nil
+}
+
+// HookPendingModules returns modules from waitForModules of the hook
+// that have not been converged successfully yet. Hook should not run
+// until the list is empty.
+func (mm *MainModuleManager) HookPendingModules(hookName string) []string {
+	var waitForModules []string
+	if globalHook, ok := mm.globalHooksByName[hookName]; ok {
+		waitForModules = globalHook.Config.WaitForModules
+	} else if moduleHook, ok := mm.modulesHooksByName[hookName]; ok {
+		waitForModules = moduleHook.Config.WaitForModules
+	}
+
+	pending := make([]string, 0)
+	for _, moduleName := range waitForModules {
+		module, ok := mm.allModulesByName[moduleName]
+		if !ok || !module.IsConverged() {
+			pending = append(pending, moduleName)
+		}
+	}
+	return pending
+}
@@ -0,0 +1,34 @@
+package module_manager
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMainModuleManager_HookPendingModules(t *testing.T) {
+	mm := NewMainModuleManager(&MockHelmClient{}, nil)
+
+	prometheus := mm.NewModule()
+	prometheus.Name = "prometheus"
+	mm.allModulesByName["prometheus"] = prometheus
+
+	hookConfig := &GlobalHookConfig{}
+	hookConfig.WaitForModules = []string{"prometheus"}
+	mm.globalHooksByName["global-hooks/monitoring"] = &GlobalHook{
+		Hook:   &Hook{Name: "global-hooks/monitoring"},
+		Config: hookConfig,
+	}
+
+	assert.NoError(t, mm.validateGlobalHooksWaitForModules())
+	assert.Equal(t, []string{"prometheus"}, mm.HookPendingModules("global-hooks/monitoring"))
+
+	prometheus.converged = 1
+	assert.Equal(t, []string{}, mm.HookPendingModules("global-hooks/monitoring"))
+
+	// hooks without waitForModules are never held
+	assert.Equal(t, []string{}, mm.HookPendingModules("global-hooks/other"))
+
+	hookConfig.WaitForModules = []string{"unknown"}
+	assert.Error(t, mm.validateGlobalHooksWaitForModules())
+}
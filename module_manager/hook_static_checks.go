@@ -0,0 +1,130 @@
+package module_manager
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+
+	"github.com/romana/rlog"
+
+	"github.com/flant/antiopa/executor"
+)
+
+const (
+	HookStaticChecksOff     = "off"
+	HookStaticChecksWarn    = "warn"
+	HookStaticChecksEnforce = "enforce"
+)
+
+// HookStaticChecks is a mode of hook files checks at discovery: shebang presence,
+// existence of the interpreter and 'bash -n' syntax check of shell hooks.
+// Broken hooks fail discovery in enforce mode and are logged in warn mode.
+var HookStaticChecks = HookStaticChecksWarn
+
+// shells that support syntax check with -n
+var syntaxCheckShells = map[string]bool{
+	"sh":   true,
+	"bash": true,
+	"dash": true,
+	"ash":  true,
+	"zsh":  true,
+	"ksh":  true,
+}
+
+func checkHookStaticChecks() error {
+	switch HookStaticChecks {
+	case HookStaticChecksOff, HookStaticChecksWarn, HookStaticChecksEnforce:
+		return nil
+	}
+	return fmt.Errorf("unknown mode '%s', expected '%s', '%s' or '%s'", HookStaticChecks, HookStaticChecksEnforce, HookStaticChecksWarn, HookStaticChecksOff)
+}
+
+// hookInterpreter returns interpreter and its arguments from the shebang line
+func hookInterpreter(shebang string) (string, []string) {
+	fields := strings.Fields(strings.TrimPrefix(shebang, "#!"))
+	if len(fields) == 0 {
+		return "", nil
+	}
+	// #!/usr/bin/env bash
+	if filepath.Base(fields[0]) == "env" && len(fields) > 1 {
+		return fields[1], fields[2:]
+	}
+	return fields[0], fields[1:]
+}
+
+// staticCheckHook returns an error with file and line of the problem in the hook file
+func staticCheckHook(hookPath string) error {
+	f, err := os.Open(hookPath)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	firstLine, err := bufio.NewReader(f).ReadString('\n')
+	if err != nil && firstLine == "" {
+		return fmt.Errorf("%s: file is empty", hookPath)
+	}
+
+	// compiled hooks
+	if strings.HasPrefix(firstLine, "\x7fELF") {
+		return nil
+	}
+
+	if !strings.HasPrefix(firstLine, "#!") {
+		return fmt.Errorf("%s: line 1: no shebang, hook should start with '#!' and an interpreter", hookPath)
+	}
+	if strings.HasSuffix(firstLine, "\r\n") {
+		return fmt.Errorf("%s: line 1: shebang ends with CRLF, hook has Windows line endings", hookPath)
+	}
+
+	interpreter, _ := hookInterpreter(strings.TrimSpace(firstLine))
+	if interpreter == "" {
+		return fmt.Errorf("%s: line 1: shebang has no interpreter", hookPath)
+	}
+	interpreterPath, err := exec.LookPath(interpreter)
+	if err != nil {
+		return fmt.Errorf("%s: line 1: interpreter '%s' is not found", hookPath, interpreter)
+	}
+
+	if !syntaxCheckShells[filepath.Base(interpreter)] {
+		return nil
+	}
+
+	// output is like 'hooks/a: line 12: syntax error near unexpected token `fi''
+	cmd := exec.Command(interpreterPath, "-n", hookPath)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	if _, err := executor.Output(cmd); err != nil {
+		output := strings.TrimSpace(stderr.String())
+		if output == "" {
+			output = err.Error()
+		}
+		return fmt.Errorf("%s: syntax check failed: %s", hookPath, output)
+	}
+
+	return nil
+}
+
+// staticCheckHooks checks hook files according to HookStaticChecks mode
+func staticCheckHooks(hooksPaths []string) error {
+	if HookStaticChecks == HookStaticChecksOff {
+		return nil
+	}
+
+	for _, hookPath := range hooksPaths {
+		err := staticCheckHook(hookPath)
+		if err == nil {
+			continue
+		}
+		if HookStaticChecks == HookStaticChecksEnforce {
+			return fmt.Errorf("hook static check: %s", err)
+		}
+		rlog.Warnf("MODULE_MANAGER hook static check: %s", err)
+	}
+
+	return nil
+}
@@ -0,0 +1,55 @@
+package module_manager
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestStaticCheckHook(t *testing.T) {
+	dir, err := ioutil.TempDir("", "hook-static-checks")
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer os.RemoveAll(dir)
+
+	writeHook := func(name, content string) string {
+		path := filepath.Join(dir, name)
+		ioutil.WriteFile(path, []byte(content), 0755)
+		return path
+	}
+
+	assert.NoError(t, staticCheckHook(writeHook("ok", "#!/usr/bin/env bash\nif true; then\n  echo ok\nfi\n")))
+
+	err = staticCheckHook(writeHook("no-shebang", "echo ok\n"))
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), "line 1: no shebang")
+	}
+
+	err = staticCheckHook(writeHook("crlf", "#!/bin/bash\r\necho ok\r\n"))
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), "CRLF")
+	}
+
+	err = staticCheckHook(writeHook("no-interpreter", "#!/usr/bin/env no-such-interpreter\n"))
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), "'no-such-interpreter' is not found")
+	}
+
+	err = staticCheckHook(writeHook("syntax", "#!/bin/bash\nif true; then\n  echo ok\n"))
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), "syntax check failed")
+	}
+}
+
+func TestHookInterpreter(t *testing.T) {
+	interpreter, args := hookInterpreter("#!/usr/bin/env python3 -u")
+	assert.Equal(t, "python3", interpreter)
+	assert.Equal(t, []string{"-u"}, args)
+
+	interpreter, _ = hookInterpreter("#!/bin/bash -e")
+	assert.Equal(t, "/bin/bash", interpreter)
+}
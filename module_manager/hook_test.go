@@ -0,0 +1,79 @@
+package module_manager
+
+import (
+	"testing"
+
+	"github.com/flant/antiopa/utils"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPrepareHookConfig_EventTypes(t *testing.T) {
+	config := &HookConfig{OnKubernetesEvent: []OnKubernetesEventConfig{
+		{Name: "default"},
+		{Name: "deletions", EventTypes: []OnKubernetesEventType{"Deleted", "delete"}},
+	}}
+
+	if !assert.NoError(t, prepareHookConfig(config)) {
+		return
+	}
+	assert.Equal(t, []OnKubernetesEventType{KubernetesEventOnAdd, KubernetesEventOnUpdate, KubernetesEventOnDelete}, config.OnKubernetesEvent[0].EventTypes)
+	assert.Equal(t, []OnKubernetesEventType{KubernetesEventOnDelete}, config.OnKubernetesEvent[1].EventTypes)
+
+	config = &HookConfig{OnKubernetesEvent: []OnKubernetesEventConfig{
+		{Name: "bad", EventTypes: []OnKubernetesEventType{"Bookmark"}},
+	}}
+	assert.Error(t, prepareHookConfig(config))
+}
+
+func TestMainModuleManager_addGlobalHook_OnShutdown(t *testing.T) {
+	mm := NewMainModuleManager(&MockHelmClient{}, nil)
+
+	assert.NoError(t, mm.addGlobalHook("global-hooks/flush", "/hooks/flush", &GlobalHookConfig{OnShutdown: 20.0}))
+	assert.NoError(t, mm.addGlobalHook("global-hooks/cleanup", "/hooks/cleanup", &GlobalHookConfig{HookConfig: HookConfig{OnStartup: 1.0}, OnShutdown: 10.0}))
+
+	assert.Equal(t, []string{"global-hooks/cleanup", "global-hooks/flush"}, mm.GetGlobalHooksInOrder(OnShutdown))
+	assert.Equal(t, []string{"global-hooks/cleanup"}, mm.GetGlobalHooksInOrder(OnStartup))
+
+	assert.Error(t, mm.addGlobalHook("global-hooks/bad", "/hooks/bad", &GlobalHookConfig{OnShutdown: "last"}))
+}
+
+func TestValidateSchedules(t *testing.T) {
+	assert.NoError(t, validateSchedules([]ScheduleConfig{
+		{Name: "every minute", Crontab: "0 * * * * *", AllowFailure: true},
+		{Name: "hourly", Crontab: "@every 1h", ConcurrencyPolicy: ScheduleConcurrencyForbid},
+	}))
+
+	err := validateSchedules([]ScheduleConfig{{Name: "typo", Crontab: "*/5 * * *"}})
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), "schedule 'typo'")
+	}
+	assert.Error(t, validateSchedules([]ScheduleConfig{{Name: "empty"}}))
+	assert.Error(t, validateSchedules([]ScheduleConfig{{Crontab: "@every 1h", ConcurrencyPolicy: "Skip"}}))
+}
+
+func TestValidateHookValuesPatch_MoveAndCopy(t *testing.T) {
+	patch, err := utils.ValuesPatchFromBytes([]byte(`[{"op":"move","from":"/module/old","path":"/module/new"}]`))
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.NoError(t, validateHookValuesPatch(*patch, "module"))
+
+	patch, err = utils.ValuesPatchFromBytes([]byte(`[{"op":"copy","from":"/global/secret","path":"/module/secret"}]`))
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.Error(t, validateHookValuesPatch(*patch, "module"))
+}
+
+func TestGlobalHook_values_EnabledModules(t *testing.T) {
+	mm := NewMainModuleManager(&MockHelmClient{}, nil)
+	mm.enabledModulesInOrder = []string{"cert-manager", "ingress"}
+	if !assert.NoError(t, mm.addGlobalHook("global-hooks/discovery", "/hooks/discovery", &GlobalHookConfig{HookConfig: HookConfig{OnStartup: 1.0}})) {
+		return
+	}
+
+	values := mm.globalHooksByName["global-hooks/discovery"].values()
+	global := values["global"].(map[string]interface{})
+	assert.Equal(t, []string{"cert-manager", "ingress"}, global["enabledModules"])
+}
@@ -0,0 +1,69 @@
+package module_manager
+
+import (
+	"fmt"
+)
+
+const (
+	HookValuesFormatJson = "json"
+	HookValuesFormatYaml = "yaml"
+)
+
+// HooksValuesFormat is a default format of CONFIG_VALUES_PATH and VALUES_PATH files
+// for hooks without valuesFormat in config
+var HooksValuesFormat = HookValuesFormatJson
+
+// valuesFormat returns format of values files from hook config or default HooksValuesFormat
+func (c *HookConfig) valuesFormat() string {
+	if c.ValuesFormat != "" {
+		return c.ValuesFormat
+	}
+	return HooksValuesFormat
+}
+
+func checkHookValuesFormat(format string) error {
+	switch format {
+	case HookValuesFormatJson, HookValuesFormatYaml:
+		return nil
+	}
+	return fmt.Errorf("unknown values format '%s', expected '%s' or '%s'", format, HookValuesFormatJson, HookValuesFormatYaml)
+}
+
+type hookValuesFilesPreparer interface {
+	prepareConfigValuesJsonFile() (string, error)
+	prepareValuesJsonFile() (string, error)
+	prepareConfigValuesYamlFile() (string, error)
+	prepareValuesYamlFile() (string, error)
+}
+
+// prepareHookValuesFiles dumps values files for the hook run. JSON files are always passed
+// in CONFIG_VALUES_JSON_PATH and VALUES_JSON_PATH for jq-based hooks, YAML files are dumped
+// only for hooks with yaml format. CONFIG_VALUES_PATH and VALUES_PATH are files in the hook format.
+func prepareHookValuesFiles(h hookValuesFilesPreparer, format string) (configValuesPath string, valuesPath string, envs []string, err error) {
+	configValuesJsonPath, err := h.prepareConfigValuesJsonFile()
+	if err != nil {
+		return "", "", nil, err
+	}
+	valuesJsonPath, err := h.prepareValuesJsonFile()
+	if err != nil {
+		return "", "", nil, err
+	}
+	envs = []string{
+		fmt.Sprintf("CONFIG_VALUES_JSON_PATH=%s", configValuesJsonPath),
+		fmt.Sprintf("VALUES_JSON_PATH=%s", valuesJsonPath),
+	}
+
+	if format != HookValuesFormatYaml {
+		return configValuesJsonPath, valuesJsonPath, envs, nil
+	}
+
+	configValuesPath, err = h.prepareConfigValuesYamlFile()
+	if err != nil {
+		return "", "", nil, err
+	}
+	valuesPath, err = h.prepareValuesYamlFile()
+	if err != nil {
+		return "", "", nil, err
+	}
+	return configValuesPath, valuesPath, envs, nil
+}
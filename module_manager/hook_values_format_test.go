@@ -0,0 +1,63 @@
+package module_manager
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+type fakeHookValuesFiles struct {
+	dumped []string
+}
+
+func (f *fakeHookValuesFiles) prepareConfigValuesJsonFile() (string, error) {
+	f.dumped = append(f.dumped, "config-values.json")
+	return "config-values.json", nil
+}
+
+func (f *fakeHookValuesFiles) prepareValuesJsonFile() (string, error) {
+	f.dumped = append(f.dumped, "values.json")
+	return "values.json", nil
+}
+
+func (f *fakeHookValuesFiles) prepareConfigValuesYamlFile() (string, error) {
+	f.dumped = append(f.dumped, "config-values.yaml")
+	return "config-values.yaml", nil
+}
+
+func (f *fakeHookValuesFiles) prepareValuesYamlFile() (string, error) {
+	f.dumped = append(f.dumped, "values.yaml")
+	return "values.yaml", nil
+}
+
+func TestPrepareHookValuesFiles(t *testing.T) {
+	jsonEnvs := []string{"CONFIG_VALUES_JSON_PATH=config-values.json", "VALUES_JSON_PATH=values.json"}
+
+	files := &fakeHookValuesFiles{}
+	configValuesPath, valuesPath, envs, err := prepareHookValuesFiles(files, HookValuesFormatJson)
+	assert.NoError(t, err)
+	assert.Equal(t, "config-values.json", configValuesPath)
+	assert.Equal(t, "values.json", valuesPath)
+	assert.Equal(t, jsonEnvs, envs)
+	// yaml files are not dumped for json hooks
+	assert.Equal(t, []string{"config-values.json", "values.json"}, files.dumped)
+
+	files = &fakeHookValuesFiles{}
+	configValuesPath, valuesPath, envs, err = prepareHookValuesFiles(files, HookValuesFormatYaml)
+	assert.NoError(t, err)
+	assert.Equal(t, "config-values.yaml", configValuesPath)
+	assert.Equal(t, "values.yaml", valuesPath)
+	assert.Equal(t, jsonEnvs, envs)
+}
+
+func TestHookConfigValuesFormat(t *testing.T) {
+	config := &HookConfig{}
+	assert.Equal(t, HooksValuesFormat, config.valuesFormat())
+
+	config.ValuesFormat = HookValuesFormatYaml
+	assert.NoError(t, prepareHookConfig(config))
+	assert.Equal(t, HookValuesFormatYaml, config.valuesFormat())
+
+	config.ValuesFormat = "toml"
+	assert.Error(t, prepareHookConfig(config))
+}
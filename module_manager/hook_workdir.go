@@ -0,0 +1,57 @@
+package module_manager
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/otiai10/copy"
+	"github.com/romana/rlog"
+)
+
+// HooksIsolation runs module hooks from a copy of the module directory with a working
+// directory per run, so files written by hooks cannot change the chart of the module.
+var HooksIsolation bool
+
+// hookRunDir is a temporary directory for one run of the module hook:
+// a copy of the module directory and an empty working directory.
+type hookRunDir struct {
+	path       string
+	workDir    string
+	entrypoint string
+}
+
+func (h *ModuleHook) prepareRunDir() (*hookRunDir, error) {
+	hookRelPath, err := filepath.Rel(h.Module.Path, h.Path)
+	if err != nil {
+		return nil, err
+	}
+
+	path, err := ioutil.TempDir(TempDir, fmt.Sprintf("%s.module-hook-%s-run-", h.Module.SafeName(), h.SafeName()))
+	if err != nil {
+		return nil, err
+	}
+	runDir := &hookRunDir{
+		path:       path,
+		workDir:    filepath.Join(path, "work"),
+		entrypoint: filepath.Join(path, "module", hookRelPath),
+	}
+
+	if err := copy.Copy(h.Module.Path, filepath.Join(path, "module")); err != nil {
+		runDir.cleanup()
+		return nil, fmt.Errorf("cannot copy module directory for hook '%s': %s", h.Name, err)
+	}
+	if err := os.Mkdir(runDir.workDir, 0755); err != nil {
+		runDir.cleanup()
+		return nil, err
+	}
+
+	return runDir, nil
+}
+
+func (d *hookRunDir) cleanup() {
+	if err := os.RemoveAll(d.path); err != nil {
+		rlog.Errorf("cannot remove hook run directory '%s': %s", d.path, err)
+	}
+}
@@ -0,0 +1,49 @@
+package module_manager
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/flant/antiopa/executor"
+	"github.com/flant/antiopa/utils"
+)
+
+func TestModuleHookRunDir(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "hook-run-dir")
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer os.RemoveAll(tmpDir)
+	TempDir = tmpDir
+
+	modulePath := filepath.Join(tmpDir, "modules", "001-alpha")
+	hookPath := filepath.Join(modulePath, "hooks", "stray")
+	assert.NoError(t, os.MkdirAll(filepath.Join(modulePath, "templates"), 0755))
+	assert.NoError(t, os.MkdirAll(filepath.Dir(hookPath), 0755))
+	// hook writes to the working directory and to the templates of its module
+	ioutil.WriteFile(hookPath, []byte("#!/bin/sh\ntouch stray.yaml\ntouch $(dirname $0)/../templates/stray.yaml\n"), 0755)
+
+	hook := &ModuleHook{
+		Hook:   &Hook{Name: "001-alpha/hooks/stray", Path: hookPath},
+		Module: &Module{Name: "alpha", Path: modulePath},
+	}
+
+	runDir, err := hook.prepareRunDir()
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.NoError(t, executor.Run(utils.MakeCommand(runDir.workDir, runDir.entrypoint, []string{}, []string{}), true))
+
+	_, err = os.Stat(filepath.Join(runDir.workDir, "stray.yaml"))
+	assert.NoError(t, err)
+	_, err = os.Stat(filepath.Join(modulePath, "templates", "stray.yaml"))
+	assert.True(t, os.IsNotExist(err))
+
+	runDir.cleanup()
+	_, err = os.Stat(runDir.path)
+	assert.True(t, os.IsNotExist(err))
+}
@@ -0,0 +1,260 @@
+package module_manager
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/romana/rlog"
+
+	"github.com/flant/antiopa/state_store"
+)
+
+// Hooks state is a key-value store shared by hooks of a module (global hooks share
+// the 'global' namespace). Hook reads the current state as a JSON object from the file
+// in HOOK_STATE_PATH and writes changes as JSON lines into the file in HOOK_STATE_PATCH_PATH:
+//
+//	{"op": "set", "key": "token", "value": {"id": 1}, "ttl": "24h"}
+//	{"op": "delete", "key": "token"}
+//
+// Changes are applied only if hook is succeeded. Keys with ttl are expired after it.
+const (
+	HooksStatePathEnv         = "HOOK_STATE_PATH"
+	HooksStatePatchPathEnv    = "HOOK_STATE_PATCH_PATH"
+	GlobalHooksStateNamespace = "global"
+)
+
+// HooksStateConfigMapName is a ConfigMap to persist hooks state between restarts
+// of antiopa pod. State is kept only in memory if name is empty.
+var HooksStateConfigMapName = "antiopa-hooks-state"
+
+// HooksStateMaxSize is a limit of the state of one namespace in bytes of JSON,
+// all namespaces are stored in one ConfigMap
+var HooksStateMaxSize = 128 * 1024
+
+const hooksStateConfigMapKey = "hooks-state.json"
+
+type hooksStateEntry struct {
+	Value     json.RawMessage `json:"value"`
+	ExpiresAt *time.Time      `json:"expiresAt,omitempty"`
+}
+
+func (e hooksStateEntry) expired(now time.Time) bool {
+	return e.ExpiresAt != nil && !now.Before(*e.ExpiresAt)
+}
+
+type hooksStateOperation struct {
+	Op    string          `json:"op"`
+	Key   string          `json:"key"`
+	Value json.RawMessage `json:"value"`
+	Ttl   string          `json:"ttl"`
+}
+
+type hooksState struct {
+	m          sync.Mutex
+	namespaces map[string]map[string]hooksStateEntry
+	// nil store keeps state in memory
+	store state_store.Store
+}
+
+func newHooksState() *hooksState {
+	return &hooksState{namespaces: make(map[string]map[string]hooksStateEntry)}
+}
+
+func (mm *MainModuleManager) initHooksStatePersistence() error {
+	if HooksStateConfigMapName == "" {
+		return nil
+	}
+	store, err := state_store.New(state_store.KindConfigMap, HooksStateConfigMapName, hooksStateConfigMapKey)
+	if err != nil {
+		return err
+	}
+	return mm.hooksState.restore(store)
+}
+
+// restore loads the state saved by the previous antiopa pod and saves changes into the store
+func (s *hooksState) restore(store state_store.Store) error {
+	data, err := store.Load()
+	if err != nil {
+		return fmt.Errorf("cannot load hooks state: %s", err)
+	}
+
+	s.m.Lock()
+	defer s.m.Unlock()
+	s.store = store
+	if data == nil {
+		return nil
+	}
+	if err := json.Unmarshal(data, &s.namespaces); err != nil {
+		return fmt.Errorf("bad saved hooks state: %s", err)
+	}
+	rlog.Infof("MODULE_MANAGER hooks state is restored from %s", store)
+	return nil
+}
+
+// prepareFiles writes the current state of namespace for the hook and creates an empty patch file
+func (s *hooksState) prepareFiles(namespace string, hookSafeName string) (envs []string, patchPath string, err error) {
+	now := time.Now()
+	state := make(map[string]json.RawMessage)
+
+	s.m.Lock()
+	for key, entry := range s.namespaces[namespace] {
+		if !entry.expired(now) {
+			state[key] = entry.Value
+		}
+	}
+	s.m.Unlock()
+
+	data, err := json.Marshal(state)
+	if err != nil {
+		return nil, "", err
+	}
+	statePath := filepath.Join(TempDir, fmt.Sprintf("%s.hook-state.json", hookSafeName))
+	if err := dumpData(statePath, data); err != nil {
+		return nil, "", err
+	}
+
+	patchPath = filepath.Join(TempDir, fmt.Sprintf("%s.hook-state-patch.jsonl", hookSafeName))
+	if err := createHookResultValuesFile(patchPath); err != nil {
+		return nil, "", err
+	}
+
+	envs = []string{
+		fmt.Sprintf("%s=%s", HooksStatePathEnv, statePath),
+		fmt.Sprintf("%s=%s", HooksStatePatchPathEnv, patchPath),
+	}
+	return envs, patchPath, nil
+}
+
+// applyPatchFile applies operations written by the hook. Operations are validated
+// before the state is changed, so a broken patch does not change the state.
+func (s *hooksState) applyPatchFile(namespace string, patchPath string) error {
+	operations, err := readHooksStatePatch(patchPath)
+	if err != nil {
+		return err
+	}
+	if len(operations) == 0 {
+		return nil
+	}
+
+	s.m.Lock()
+	defer s.m.Unlock()
+
+	now := time.Now()
+	state := make(map[string]hooksStateEntry)
+	for key, entry := range s.namespaces[namespace] {
+		if !entry.expired(now) {
+			state[key] = entry
+		}
+	}
+
+	for _, op := range operations {
+		switch op.Op {
+		case "set":
+			entry := hooksStateEntry{Value: op.Value}
+			if op.Ttl != "" {
+				ttl, _ := time.ParseDuration(op.Ttl)
+				expiresAt := now.Add(ttl)
+				entry.ExpiresAt = &expiresAt
+			}
+			state[op.Key] = entry
+		case "delete":
+			delete(state, op.Key)
+		}
+	}
+
+	data, err := json.Marshal(state)
+	if err != nil {
+		return err
+	}
+	if len(data) > HooksStateMaxSize {
+		return fmt.Errorf("state of '%s' is %d bytes, limit is %d bytes", namespace, len(data), HooksStateMaxSize)
+	}
+
+	if len(state) == 0 {
+		delete(s.namespaces, namespace)
+	} else {
+		s.namespaces[namespace] = state
+	}
+	s.save(now)
+	return nil
+}
+
+// save writes all namespaces without expired keys into the store, errors are logged:
+// state is kept in memory and is saved by the next change
+func (s *hooksState) save(now time.Time) {
+	if s.store == nil {
+		return
+	}
+	for namespace, state := range s.namespaces {
+		for key, entry := range state {
+			if entry.expired(now) {
+				delete(state, key)
+			}
+		}
+		if len(state) == 0 {
+			delete(s.namespaces, namespace)
+		}
+	}
+
+	data, err := json.Marshal(s.namespaces)
+	if err != nil {
+		rlog.Errorf("MODULE_MANAGER cannot save hooks state: %s", err)
+		return
+	}
+	if err := s.store.Save(data); err != nil {
+		rlog.Errorf("MODULE_MANAGER cannot save hooks state: %s", err)
+	}
+}
+
+func readHooksStatePatch(patchPath string) ([]hooksStateOperation, error) {
+	f, err := os.Open(patchPath)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	operations := make([]hooksStateOperation, 0)
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 64*1024), HooksStateMaxSize+1024)
+	line := 0
+	for scanner.Scan() {
+		line++
+		data := scanner.Bytes()
+		if len(data) == 0 {
+			continue
+		}
+
+		var op hooksStateOperation
+		if err := json.Unmarshal(data, &op); err != nil {
+			return nil, fmt.Errorf("line %d: %s", line, err)
+		}
+		if op.Key == "" {
+			return nil, fmt.Errorf("line %d: key is required", line)
+		}
+		switch op.Op {
+		case "set":
+			if len(op.Value) == 0 {
+				return nil, fmt.Errorf("line %d: value is required for 'set'", line)
+			}
+			if op.Ttl != "" {
+				if ttl, err := time.ParseDuration(op.Ttl); err != nil || ttl <= 0 {
+					return nil, fmt.Errorf("line %d: bad ttl '%s'", line, op.Ttl)
+				}
+			}
+		case "delete":
+		default:
+			return nil, fmt.Errorf("line %d: unknown op '%s', expected 'set' or 'delete'", line, op.Op)
+		}
+		operations = append(operations, op)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+
+	return operations, nil
+}
@@ -0,0 +1,75 @@
+package module_manager
+
+import (
+	"encoding/json"
+	"io/ioutil"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func readHookState(t *testing.T, envs []string) map[string]json.RawMessage {
+	path := strings.TrimPrefix(envs[0], HooksStatePathEnv+"=")
+	data, err := ioutil.ReadFile(path)
+	assert.NoError(t, err)
+	state := make(map[string]json.RawMessage)
+	assert.NoError(t, json.Unmarshal(data, &state))
+	return state
+}
+
+func TestHooksState(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "hooks-state")
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer os.RemoveAll(tmpDir)
+	TempDir = tmpDir
+
+	store := &memoryDynamicValuesStore{}
+	s := newHooksState()
+	assert.NoError(t, s.restore(store))
+
+	_, patchPath, err := s.prepareFiles("module-a", "hook-1")
+	if !assert.NoError(t, err) {
+		return
+	}
+	ioutil.WriteFile(patchPath, []byte(`{"op": "set", "key": "token", "value": {"id": 1}}
+{"op": "set", "key": "lock", "value": true, "ttl": "1ms"}
+`), 0644)
+	assert.NoError(t, s.applyPatchFile("module-a", patchPath))
+	assert.Equal(t, 1, store.saves)
+
+	time.Sleep(5 * time.Millisecond)
+
+	// expired keys are not visible, namespaces are separated
+	envs, patchPath, err := s.prepareFiles("module-a", "hook-2")
+	if assert.NoError(t, err) {
+		state := readHookState(t, envs)
+		assert.Len(t, state, 1)
+		assert.JSONEq(t, `{"id": 1}`, string(state["token"]))
+	}
+	envs, _, err = s.prepareFiles("module-b", "hook-3")
+	if assert.NoError(t, err) {
+		assert.Len(t, readHookState(t, envs), 0)
+	}
+
+	// broken patch does not change the state
+	ioutil.WriteFile(patchPath, []byte(`{"op": "delete", "key": "token"}
+{"op": "replace", "key": "token"}
+`), 0644)
+	err = s.applyPatchFile("module-a", patchPath)
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), "line 2")
+	}
+
+	// state is restored after restart
+	restored := newHooksState()
+	assert.NoError(t, restored.restore(store))
+	envs, _, err = restored.prepareFiles("module-a", "hook-1")
+	if assert.NoError(t, err) {
+		assert.Len(t, readHookState(t, envs), 1)
+	}
+}
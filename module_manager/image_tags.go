@@ -0,0 +1,56 @@
+package module_manager
+
+import (
+	"reflect"
+
+	"github.com/romana/rlog"
+
+	"github.com/flant/antiopa/utils"
+)
+
+// ImageTagsValuesKey is a key in the module section with tags of images declared in module.yaml
+// from imageTags key of the ConfigMap: <moduleValuesKey>.imageTags.<image>, use index in templates.
+const ImageTagsValuesKey = "imageTags"
+
+// imageTagsValues returns tags of images declared by the module, e.g. {"ingress": {"imageTags": {"ingress/controller": "v0.25.1"}}}
+func (m *Module) imageTagsValues(storage *ValuesStorage) utils.Values {
+	tags := m.imageTags(storage.ImageTags())
+	if len(tags) == 0 {
+		return utils.Values{}
+	}
+	return utils.Values{
+		m.moduleValuesKey(): map[string]interface{}{
+			ImageTagsValuesKey: tags,
+		},
+	}
+}
+
+// imageTags returns tags of images declared by the module, images without tags are skipped
+func (m *Module) imageTags(allTags map[string]string) map[string]interface{} {
+	res := make(map[string]interface{})
+	if m.Definition == nil {
+		return res
+	}
+	for _, image := range m.Definition.Images {
+		if tag, hasTag := allTags[image]; hasTag {
+			res[image] = tag
+		}
+	}
+	return res
+}
+
+// handleImageTagsUpdate saves new image tags and returns enabled modules with changed tags of declared images
+func (mm *MainModuleManager) handleImageTagsUpdate(tags map[string]string) []string {
+	oldTags := mm.valuesStorage.ImageTags()
+	mm.valuesStorage.SetImageTags(tags)
+
+	changed := make([]string, 0)
+	for _, moduleName := range mm.enabledModulesInOrder {
+		module := mm.allModulesByName[moduleName]
+		if !reflect.DeepEqual(module.imageTags(oldTags), module.imageTags(tags)) {
+			rlog.Infof("MODULE_MANAGER module '%s': image tags are changed: %v", moduleName, module.imageTags(tags))
+			changed = append(changed, moduleName)
+		}
+	}
+	return changed
+}
@@ -0,0 +1,38 @@
+package module_manager
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/flant/antiopa/utils"
+)
+
+func TestMainModuleManager_handleImageTagsUpdate(t *testing.T) {
+	mm := NewMainModuleManager(&MockHelmClient{}, nil)
+	newModule := func(name string, images ...string) *Module {
+		module := &Module{Name: name, moduleManager: mm, StaticConfig: utils.NewModuleConfig(name), Definition: NewModuleDefinition()}
+		module.Definition.Images = images
+		return module
+	}
+	mm.allModulesByName = map[string]*Module{
+		"ingress":   newModule("ingress", "ingress/controller", "ingress/default-backend"),
+		"dashboard": newModule("dashboard", "dashboard"),
+		"dns":       newModule("dns"),
+	}
+	mm.allModulesNamesInOrder = []string{"ingress", "dashboard", "dns"}
+	mm.enabledModulesInOrder = []string{"ingress", "dashboard", "dns"}
+	mm.valuesStorage.SetImageTags(map[string]string{"ingress/controller": "v0.25.0", "dashboard": "v2.0.0"})
+
+	changed := mm.handleImageTagsUpdate(map[string]string{"ingress/controller": "v0.25.1", "dashboard": "v2.0.0", "unused": "v1"})
+	assert.Equal(t, []string{"ingress"}, changed)
+
+	values := mm.allModulesByName["ingress"].values()
+	assert.Equal(t, map[string]interface{}{"ingress/controller": "v0.25.1"}, values["ingress"].(map[string]interface{})[ImageTagsValuesKey])
+
+	// removed tag reruns the module
+	changed = mm.handleImageTagsUpdate(map[string]string{"ingress/controller": "v0.25.1"})
+	assert.Equal(t, []string{"dashboard"}, changed)
+	_, hasTags := mm.allModulesByName["dashboard"].values()["dashboard"].(map[string]interface{})[ImageTagsValuesKey]
+	assert.False(t, hasTags)
+}
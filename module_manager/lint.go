@@ -0,0 +1,228 @@
+package module_manager
+
+import (
+	"fmt"
+	"math"
+	"sort"
+
+	"github.com/flant/antiopa/helm"
+	"github.com/flant/antiopa/kube_config_manager"
+	"github.com/flant/antiopa/utils"
+)
+
+// LintWorkingDir runs checks of ValidateWorkingDir and then checks modules with config values
+// as if they were in the ConfigMap: enabled modules are discovered with enabled scripts, values of
+// enabled modules are validated with values schemas and charts are rendered with 'helm template'.
+// Config values have 'global' section and module sections, 'false' in module section disables module.
+// It is used by `antiopa lint`.
+func LintWorkingDir(workingDir string, tempDir string, configValues map[interface{}]interface{}, helmClient helm.HelmClient) []ValidationCheck {
+	checks := ValidateWorkingDir(workingDir, tempDir)
+	for _, check := range checks {
+		if check.Error != nil {
+			// modules and hooks are needed to run enabled scripts and render charts
+			return checks
+		}
+	}
+
+	mm := NewMainModuleManager(helmClient, nil)
+	if err := mm.initGlobalHooks(); err != nil {
+		return append(checks, ValidationCheck{Name: "global hooks configs", Error: err})
+	}
+	if err := mm.initModulesIndex(); err != nil {
+		return append(checks, ValidationCheck{Name: "modules directory", Error: err})
+	}
+	if err := mm.initFeatureGates(); err != nil {
+		return append(checks, ValidationCheck{Name: "feature gates", Error: err})
+	}
+
+	err := mm.applyLintConfigValues(configValues)
+	checks = append(checks, ValidationCheck{Name: "config values", Error: err})
+	if err != nil {
+		return checks
+	}
+
+	enabledModules, err := mm.determineEnableStateWithScript(mm.enabledModulesByConfig)
+	checks = append(checks, ValidationCheck{Name: "modules discovery", Error: err})
+	if err != nil {
+		return checks
+	}
+	mm.enabledModulesInOrder = enabledModules
+
+	for _, moduleName := range enabledModules {
+		module := mm.allModulesByName[moduleName]
+
+		if module.ValuesSchema != nil {
+			moduleValues := module.values()[module.moduleValuesKey()]
+			checks = append(checks, ValidationCheck{
+				Name:  fmt.Sprintf("module '%s' values", moduleName),
+				Error: validateValuesBySchema(module.moduleValuesKey(), moduleValues, module.ValuesSchema),
+			})
+		}
+
+		if chartExists, _ := module.checkHelmChart(); chartExists {
+			checks = append(checks, ValidationCheck{
+				Name:  fmt.Sprintf("module '%s' chart rendering", moduleName),
+				Error: module.lintChart(helmClient),
+			})
+		}
+	}
+
+	return checks
+}
+
+// applyLintConfigValues sets config values as values from the ConfigMap
+func (mm *MainModuleManager) applyLintConfigValues(configValues map[interface{}]interface{}) error {
+	globalValues := make(utils.Values)
+	if _, hasGlobal := configValues[utils.GlobalValuesKey]; hasGlobal {
+		values, err := utils.NewValues(map[interface{}]interface{}{utils.GlobalValuesKey: configValues[utils.GlobalValuesKey]})
+		if err != nil {
+			return err
+		}
+		globalValues = values
+	}
+
+	moduleConfigs := make(kube_config_manager.ModuleConfigs)
+	for key := range configValues {
+		keyStr, ok := key.(string)
+		if !ok {
+			return fmt.Errorf("key should be a string, got '%v'", key)
+		}
+		if keyStr == utils.GlobalValuesKey {
+			continue
+		}
+		moduleName := utils.ModuleNameFromValuesKey(keyStr)
+		if _, isFlag := mm.moduleNameFromEnabledFlagConfig(utils.ModuleConfig{ModuleName: moduleName}); isFlag {
+			if _, isBool := configValues[key].(bool); !isBool {
+				return fmt.Errorf("'%s' should be bool, got: %#v", keyStr, configValues[key])
+			}
+		} else if _, hasModule := mm.allModulesByName[moduleName]; !hasModule {
+			return fmt.Errorf("unknown module '%s' at key '%s'", moduleName, keyStr)
+		}
+		moduleConfig, err := utils.NewModuleConfig(moduleName).WithValues(configValues)
+		if err != nil {
+			return fmt.Errorf("module '%s': %s", moduleName, err)
+		}
+		moduleConfigs[moduleName] = *moduleConfig
+	}
+
+	mm.valuesStorage.SetKubeGlobalConfigValues(globalValues)
+	var modulesConfigValues map[string]utils.Values
+	mm.enabledModulesByConfig, modulesConfigValues, _ = mm.calculateEnabledModulesByConfig(moduleConfigs, globalValues)
+	mm.valuesStorage.SetKubeModulesConfigValues(modulesConfigValues)
+
+	return nil
+}
+
+// lintChart renders the module chart with values offline, tiller is not used
+func (m *Module) lintChart(helmClient helm.HelmClient) error {
+	_, err := m.renderChartOffline(helmClient)
+	return err
+}
+
+// renderChartOffline returns the manifest of the module chart rendered with values by 'helm template'
+func (m *Module) renderChartOffline(helmClient helm.HelmClient) (string, error) {
+	valuesPath, err := m.prepareValuesYamlFile()
+	if err != nil {
+		return "", err
+	}
+	runChartPath, err := m.prepareRunChart()
+	if err != nil {
+		return "", err
+	}
+
+	namespace := helmClient.TillerNamespace()
+	if m.Definition != nil && m.Definition.TillerNamespace != "" {
+		namespace = m.Definition.TillerNamespace
+	}
+	valuesChecksumSetValues, err := m.valuesChecksumSetValues()
+	if err != nil {
+		return "", err
+	}
+	return helmClient.RenderRelease(m.generateHelmReleaseName(), runChartPath, []string{valuesPath}, append(m.helmSetValues(""), valuesChecksumSetValues...), namespace)
+}
+
+// validateValuesBySchema checks type, enum and required properties of values.
+// Schema keywords that are not supported are ignored.
+func validateValuesBySchema(path string, value interface{}, schema map[string]interface{}) error {
+	if schemaType, ok := schema["type"].(string); ok && value != nil {
+		if !valueHasType(value, schemaType) {
+			return fmt.Errorf("'%s' should be %s, got '%v'", path, schemaType, value)
+		}
+	}
+
+	if enum, ok := schema["enum"].([]interface{}); ok && value != nil {
+		found := false
+		for _, item := range enum {
+			if fmt.Sprintf("%v", item) == fmt.Sprintf("%v", value) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return fmt.Errorf("'%s' should be one of %v, got '%v'", path, enum, value)
+		}
+	}
+
+	switch v := value.(type) {
+	case map[string]interface{}:
+		if required, ok := schema["required"].([]interface{}); ok {
+			for _, name := range required {
+				if _, hasKey := v[fmt.Sprintf("%v", name)]; !hasKey {
+					return fmt.Errorf("'%s.%v' is required", path, name)
+				}
+			}
+		}
+		properties, _ := schema["properties"].(map[string]interface{})
+		keys := make([]string, 0, len(v))
+		for key := range v {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+		for _, key := range keys {
+			propSchema, ok := properties[key].(map[string]interface{})
+			if !ok {
+				continue
+			}
+			if err := validateValuesBySchema(path+"."+key, v[key], propSchema); err != nil {
+				return err
+			}
+		}
+	case []interface{}:
+		items, ok := schema["items"].(map[string]interface{})
+		if !ok {
+			return nil
+		}
+		for i, item := range v {
+			if err := validateValuesBySchema(fmt.Sprintf("%s[%d]", path, i), item, items); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+func valueHasType(value interface{}, schemaType string) bool {
+	switch schemaType {
+	case "object":
+		_, ok := value.(map[string]interface{})
+		return ok
+	case "array":
+		_, ok := value.([]interface{})
+		return ok
+	case "string":
+		_, ok := value.(string)
+		return ok
+	case "boolean":
+		_, ok := value.(bool)
+		return ok
+	case "number":
+		_, ok := value.(float64)
+		return ok
+	case "integer":
+		f, ok := value.(float64)
+		return ok && f == math.Trunc(f)
+	}
+	// unknown types are not checked
+	return true
+}
@@ -0,0 +1,41 @@
+package module_manager
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestValidateValuesBySchema(t *testing.T) {
+	schema := map[string]interface{}{
+		"type":     "object",
+		"required": []interface{}{"replicas"},
+		"properties": map[string]interface{}{
+			"replicas": map[string]interface{}{"type": "integer"},
+			"mode":     map[string]interface{}{"type": "string", "enum": []interface{}{"ha", "single"}},
+			"hosts": map[string]interface{}{
+				"type":  "array",
+				"items": map[string]interface{}{"type": "string"},
+			},
+		},
+	}
+
+	assert.NoError(t, validateValuesBySchema("test", map[string]interface{}{
+		"replicas": 2.0,
+		"mode":     "ha",
+		"hosts":    []interface{}{"a", "b"},
+		"unknown":  true,
+	}, schema))
+
+	err := validateValuesBySchema("test", map[string]interface{}{"mode": "ha"}, schema)
+	assert.EqualError(t, err, "'test.replicas' is required")
+
+	err = validateValuesBySchema("test", map[string]interface{}{"replicas": 1.5}, schema)
+	assert.EqualError(t, err, "'test.replicas' should be integer, got '1.5'")
+
+	err = validateValuesBySchema("test", map[string]interface{}{"replicas": 1.0, "mode": "cluster"}, schema)
+	assert.EqualError(t, err, "'test.mode' should be one of [ha single], got 'cluster'")
+
+	err = validateValuesBySchema("test", map[string]interface{}{"replicas": 1.0, "hosts": []interface{}{"a", 1.0}}, schema)
+	assert.EqualError(t, err, "'test.hosts[1]' should be string, got '1'")
+}
@@ -0,0 +1,136 @@
+package module_manager
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/romana/rlog"
+	"gopkg.in/robfig/cron.v2"
+	"gopkg.in/yaml.v2"
+)
+
+// File with global maintenance windows in working dir. Windows from module.yaml
+// override global windows for the module.
+const MaintenanceWindowsFileName = "maintenance.yaml"
+
+// MaintenanceWindow is an interval when helm upgrades of the module are allowed.
+// Window starts by crontab (in the same format as schedule hooks) and lasts for duration.
+type MaintenanceWindow struct {
+	Crontab  string `yaml:"crontab" json:"crontab"`
+	Duration string `yaml:"duration" json:"duration"`
+
+	schedule cron.Schedule
+	duration time.Duration
+}
+
+func (w *MaintenanceWindow) init() error {
+	schedule, err := cron.Parse(w.Crontab)
+	if err != nil {
+		return fmt.Errorf("bad crontab '%s': %s", w.Crontab, err)
+	}
+	duration, err := time.ParseDuration(w.Duration)
+	if err != nil {
+		return fmt.Errorf("bad duration '%s': %s", w.Duration, err)
+	}
+	if duration <= 0 {
+		return fmt.Errorf("duration should be positive, got '%s'", w.Duration)
+	}
+
+	w.schedule = schedule
+	w.duration = duration
+	return nil
+}
+
+// IsOpen returns true if the window is started not earlier than duration before now
+func (w *MaintenanceWindow) IsOpen(now time.Time) bool {
+	start := w.schedule.Next(now.Add(-w.duration))
+	return !start.After(now)
+}
+
+// NextOpen returns the start of the next window after now
+func (w *MaintenanceWindow) NextOpen(now time.Time) time.Time {
+	return w.schedule.Next(now)
+}
+
+// MaintenanceWindows is a set of windows. Empty set means that upgrades are allowed at any time.
+type MaintenanceWindows []*MaintenanceWindow
+
+func (ws MaintenanceWindows) init() error {
+	for _, w := range ws {
+		if err := w.init(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (ws MaintenanceWindows) IsOpen(now time.Time) bool {
+	if len(ws) == 0 {
+		return true
+	}
+	for _, w := range ws {
+		if w.IsOpen(now) {
+			return true
+		}
+	}
+	return false
+}
+
+// NextOpen returns the nearest start of window after now or zero time for empty set
+func (ws MaintenanceWindows) NextOpen(now time.Time) time.Time {
+	var next time.Time
+	for _, w := range ws {
+		start := w.NextOpen(now)
+		if next.IsZero() || start.Before(next) {
+			next = start
+		}
+	}
+	return next
+}
+
+// initGlobalMaintenanceWindows loads maintenance.yaml from working dir if exists
+func (mm *MainModuleManager) initGlobalMaintenanceWindows() error {
+	mm.maintenanceWindows = make(MaintenanceWindows, 0)
+
+	path := filepath.Join(WorkingDir, MaintenanceWindowsFileName)
+	if _, err := os.Stat(path); os.IsNotExist(err) {
+		return nil
+	}
+
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("cannot read '%s': %s", path, err)
+	}
+
+	windows := make(MaintenanceWindows, 0)
+	if err := yaml.UnmarshalStrict(data, &windows); err != nil {
+		return fmt.Errorf("bad %s: %s\n%s", MaintenanceWindowsFileName, err, string(data))
+	}
+	if err := windows.init(); err != nil {
+		return fmt.Errorf("bad %s: %s", MaintenanceWindowsFileName, err)
+	}
+	mm.maintenanceWindows = windows
+
+	rlog.Infof("Initialized %d global maintenance windows", len(mm.maintenanceWindows))
+
+	return nil
+}
+
+// MaintenanceWindows returns windows from module.yaml or global windows
+func (m *Module) MaintenanceWindows() MaintenanceWindows {
+	if m.Definition != nil && len(m.Definition.MaintenanceWindows) > 0 {
+		return m.Definition.MaintenanceWindows
+	}
+	if m.moduleManager != nil {
+		return m.moduleManager.maintenanceWindows
+	}
+	return nil
+}
+
+// IsInMaintenanceWindow returns true if helm upgrade of the module is allowed now
+func (m *Module) IsInMaintenanceWindow(now time.Time) bool {
+	return m.MaintenanceWindows().IsOpen(now)
+}
@@ -0,0 +1,40 @@
+package module_manager
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMaintenanceWindows_IsOpen(t *testing.T) {
+	windows := MaintenanceWindows{
+		// every day from 02:00 to 04:00
+		{Crontab: "0 0 2 * * *", Duration: "2h"},
+		// saturday from 12:00 to 12:30
+		{Crontab: "0 0 12 * * 6", Duration: "30m"},
+	}
+	if !assert.NoError(t, windows.init()) {
+		return
+	}
+
+	// friday
+	day := time.Date(2018, time.June, 1, 0, 0, 0, 0, time.Local)
+
+	assert.False(t, windows.IsOpen(day.Add(time.Hour)))
+	assert.True(t, windows.IsOpen(day.Add(2*time.Hour)))
+	assert.True(t, windows.IsOpen(day.Add(3*time.Hour+59*time.Minute)))
+	assert.False(t, windows.IsOpen(day.Add(4*time.Hour+time.Minute)))
+	assert.False(t, windows.IsOpen(day.Add(12*time.Hour+10*time.Minute)))
+	assert.True(t, windows.IsOpen(day.Add(36*time.Hour+10*time.Minute)))
+
+	assert.Equal(t, day.Add(26*time.Hour), windows.NextOpen(day.Add(5*time.Hour)))
+
+	// no windows means no restrictions
+	assert.True(t, MaintenanceWindows{}.IsOpen(day.Add(time.Hour)))
+}
+
+func TestMaintenanceWindow_init(t *testing.T) {
+	assert.Error(t, (&MaintenanceWindow{Crontab: "bad", Duration: "1h"}).init())
+	assert.Error(t, (&MaintenanceWindow{Crontab: "0 0 2 * * *", Duration: "-1h"}).init())
+}
@@ -7,8 +7,11 @@ import (
 	"os"
 	"os/exec"
 	"path/filepath"
-	"regexp"
+	"strconv"
 	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
 
 	"github.com/kennygrant/sanitize"
 	"github.com/otiai10/copy"
@@ -16,7 +19,10 @@ import (
 	"gopkg.in/yaml.v2"
 
 	"github.com/flant/antiopa/executor"
+	"github.com/flant/antiopa/helm"
 	"github.com/flant/antiopa/utils"
+	"github.com/flant/antiopa/vault"
+	"github.com/flant/antiopa/version"
 )
 
 type Module struct {
@@ -24,8 +30,55 @@ type Module struct {
 	DirectoryName string
 	Path          string
 	StaticConfig  *utils.ModuleConfig
+	Definition    *ModuleDefinition
+	// OpenAPI schema for module values with defaults
+	ValuesSchema utils.Values
 
 	moduleManager *MainModuleManager
+
+	// 1 if helm upgrade should be run even if module checksum is not changed,
+	// set from API and events handlers while the module is run
+	forceHelmUpgrade int32
+
+	// objects left in the old namespace after release namespace migration, deleted after helm upgrade
+	staleReleaseResources []helm.ReleaseResource
+
+	// values and its checksum after last successful run to detect modules with changed values,
+	// read by discovery while modules are run in parallel
+	lastRunValuesMutex    sync.Mutex
+	lastRunValues         utils.Values
+	lastRunValuesChecksum string
+
+	// result of helm upgrade in the last run, nil if upgrade was skipped
+	lastRunReleaseUpgrade *helm.ReleaseUpgradeResult
+
+	// checksums of the deployed revision to skip helm upgrade without requests to tiller
+	deployedRelease *deployedRelease
+
+	// chart version after last successful run and its change in the last run
+	lastRunChartVersion       string
+	lastRunChartVersionChange *ChartVersionChange
+
+	// 1 after the first successful run, read by hooks in named queues
+	converged int32
+
+	// values patches of parallel hooks are applied one by one
+	hooksResultsMutex sync.Mutex
+
+	// sizes of values layers and durations of the last values construction
+	valuesStats valuesStatsRecorder
+
+	// resources of pods and deprecated APIs in the release manifest after the last run, read by API
+	resourcesMutex  sync.Mutex
+	resourcesTotals *helm.ResourcesTotals
+	deprecatedApis  []helm.DeprecatedApiUsage
+
+	// artifacts of the current run, saved if run is failed
+	artifactsMutex sync.Mutex
+	artifacts      *moduleRunArtifacts
+
+	// helm upgrade waits for helmUpgradeGate since beforeHelm hooks, nil if it does not wait
+	helmUpgradeWait *helmUpgradeWait
 }
 
 func (mm *MainModuleManager) NewModule() *Module {
@@ -38,32 +91,143 @@ func (m *Module) SafeName() string {
 	return sanitize.BaseName(m.Name)
 }
 
-func (m *Module) run(onStartup bool) error {
+func (m *Module) run(onStartup bool, taskId string) error {
+	m.lastRunReleaseUpgrade = nil
+	m.lastRunChartVersionChange = nil
+
 	if err := m.cleanup(); err != nil {
 		return err
 	}
 
+	if err := m.checkUpgradePath(); err != nil {
+		return err
+	}
+
 	if onStartup {
-		if err := m.runHooksByBinding(OnStartup); err != nil {
+		if err := m.runHooksByBinding(OnStartup, taskId); err != nil {
 			return err
 		}
 	}
 
-	if err := m.runHooksByBinding(BeforeHelm); err != nil {
+	// hooks are already run if the run is retried while helm upgrade waits for the gate
+	beforeHelmHooksRun := onStartup || !m.skipBeforeHelmHooks()
+	if beforeHelmHooksRun {
+		if err := m.runHooksByBinding(BeforeHelm, taskId); err != nil {
+			return err
+		}
+	} else {
+		m.log(ModuleLogSourceModule).Infof("values are not changed while helm upgrade waits: skip beforeHelm hooks")
+	}
+
+	err := m.execRun(taskId, beforeHelmHooksRun)
+	if !IsHelmUpgradeWaiting(err) {
+		// helm upgrade is done, failed or not needed
+		m.helmUpgradeWait = nil
+	}
+	if err != nil {
+		return err
+	}
+
+	if err := m.deleteStaleReleaseResources(); err != nil {
 		return err
 	}
 
-	if err := m.execRun(); err != nil {
+	if err := m.runHooksByBinding(AfterHelm, taskId); err != nil {
 		return err
 	}
 
-	if err := m.runHooksByBinding(AfterHelm); err != nil {
+	values := m.convergeValues()
+	checksum, err := valuesChecksum(values)
+	if err != nil {
 		return err
 	}
+	m.lastRunValuesMutex.Lock()
+	m.lastRunValues = values
+	m.lastRunValuesChecksum = checksum
+	m.lastRunValuesMutex.Unlock()
+	atomic.StoreInt32(&m.converged, 1)
+
+	if m.lastRunChartVersionChange, err = m.updateChartVersion(); err != nil {
+		rlog.Errorf("MODULE_RUN '%s': cannot detect chart version: %s", m.Name, err)
+	}
+
+	m.updateExportedValues(values)
+	m.recordVersion()
 
 	return nil
 }
 
+// IsConverged returns true if module has been run successfully at least once
+func (m *Module) IsConverged() bool {
+	return atomic.LoadInt32(&m.converged) == 1
+}
+
+func (m *Module) setForceHelmUpgrade(force bool) {
+	var v int32
+	if force {
+		v = 1
+	}
+	atomic.StoreInt32(&m.forceHelmUpgrade, v)
+}
+
+func (m *Module) isHelmUpgradeForced() bool {
+	return atomic.LoadInt32(&m.forceHelmUpgrade) == 1
+}
+
+// IsCritical returns critical from module.yaml
+func (m *Module) IsCritical() bool {
+	return m.Definition != nil && m.Definition.Critical
+}
+
+// LastRunReleaseUpgrade returns a result of helm upgrade in the last run of the module.
+// Nil is returned if module has no chart or release is not changed.
+func (m *Module) LastRunReleaseUpgrade() *helm.ReleaseUpgradeResult {
+	return m.lastRunReleaseUpgrade
+}
+
+// convergeValues returns module values without the list of enabled modules,
+// so module is not considered as changed when other module is enabled or disabled.
+func (m *Module) convergeValues() utils.Values {
+	return m.constructValues([]string{})
+}
+
+func (m *Module) convergeValuesChecksum() (string, error) {
+	return valuesChecksum(m.convergeValues())
+}
+
+// ValuesChangesSinceLastRun returns a summary of changes in values since last successful run.
+// Nil is returned if module has not been run yet.
+func (m *Module) ValuesChangesSinceLastRun() []string {
+	m.lastRunValuesMutex.Lock()
+	lastRunValues := m.lastRunValues
+	m.lastRunValuesMutex.Unlock()
+
+	if lastRunValues == nil {
+		return nil
+	}
+	return utils.ValuesChangesSummary(lastRunValues, m.convergeValues())
+}
+
+// hasChangedValues returns true if module values are changed since last successful run
+// or module has not been run yet.
+func (m *Module) hasChangedValues() bool {
+	m.lastRunValuesMutex.Lock()
+	lastRunValuesChecksum := m.lastRunValuesChecksum
+	m.lastRunValuesMutex.Unlock()
+
+	if lastRunValuesChecksum == "" {
+		return true
+	}
+
+	checksum, err := m.convergeValuesChecksum()
+	if err != nil {
+		rlog.Debugf("module '%s': cannot calculate values checksum: %s", m.Name, err)
+		return true
+	}
+
+	return checksum != lastRunValuesChecksum
+}
+
 func (m *Module) cleanup() error {
 	chartExists, err := m.checkHelmChart()
 	if !chartExists {
@@ -73,88 +237,202 @@ func (m *Module) cleanup() error {
 		}
 	}
 
-	//rlog.Infof("MODULE '%s': cleanup helm revisions...", m.Name)
-	if err := m.moduleManager.helm.DeleteSingleFailedRevision(m.generateHelmReleaseName()); err != nil {
+	helmClient, err := m.HelmClient()
+	if err != nil {
 		return err
 	}
 
-	if err := m.moduleManager.helm.DeleteOldFailedRevisions(m.generateHelmReleaseName()); err != nil {
+	//rlog.Infof("MODULE '%s': cleanup helm revisions...", m.Name)
+	// old FAILED revisions are deleted in background, see runFailedRevisionsCleanup
+	if err := helmClient.DeleteSingleFailedRevision(m.generateHelmReleaseName()); err != nil {
 		return err
 	}
 
 	return nil
 }
 
-func (m *Module) execRun() error {
-	err := m.execHelm(func(valuesPath, helmReleaseName string) error {
-		var err error
-
-		runChartPath := filepath.Join(TempDir, fmt.Sprintf("%s.chart", m.SafeName()))
-
-		err = os.RemoveAll(runChartPath)
+func (m *Module) execRun(taskId string, beforeHelmHooksRun bool) error {
+	err := m.execHelm(func(helmClient helm.HelmClient, valuesPath, helmReleaseName string) error {
+		helmClient = helm.WithTaskId(helmClient, taskId)
+		runChartPath, err := m.prepareRunChart()
 		if err != nil {
 			return err
 		}
-		err = copy.Copy(m.Path, runChartPath)
+
+		checksum, err := utils.CalculateChecksumOfPaths(runChartPath, valuesPath)
 		if err != nil {
 			return err
 		}
-
-		// Prepare dummy empty values.yaml for helm not to fail
-		err = os.Truncate(filepath.Join(runChartPath, "values.yaml"), 0)
+		chartChecksum, err := utils.CalculateChecksumOfPaths(runChartPath)
 		if err != nil {
 			return err
 		}
-
-		checksum, err := utils.CalculateChecksumOfPaths(runChartPath, valuesPath)
+		valuesChecksum, err := utils.CalculateChecksumOfPaths(valuesPath)
 		if err != nil {
 			return err
 		}
 
+		// fast no-op run: the deployed revision has the same chart and values
+		if m.isDeployedReleaseUnchanged(helmClient, helmReleaseName, chartChecksum, valuesChecksum) {
+			m.log(ModuleLogSourceHelm).Infof("chart and values of deployed revision %d of helm release '%s' are not changed: skip helm upgrade", m.deployedRelease.Revision, helmReleaseName)
+			return nil
+		}
+		m.forgetDeployedRelease()
+
 		doRelease := true
 
-		isReleaseExists, err := m.moduleManager.helm.IsReleaseExists(helmReleaseName)
+		isReleaseExists, err := helmClient.IsReleaseExists(helmReleaseName)
 		if err != nil {
 			return err
 		}
 
-		if isReleaseExists {
-			_, status, err := m.moduleManager.helm.LastReleaseStatus(helmReleaseName)
+		if isReleaseExists && !m.isHelmUpgradeForced() {
+			revision, status, err := helmClient.LastReleaseStatus(helmReleaseName)
 			if err != nil {
 				return err
 			}
 
 			// Skip helm release for unchanged modules only for non FAILED releases
 			if status != "FAILED" {
-				releaseValues, err := m.moduleManager.helm.GetReleaseValues(helmReleaseName)
+				releaseValues, err := helmClient.GetReleaseValues(helmReleaseName, false)
 				if err != nil {
 					return err
 				}
 
-				if recordedChecksum, hasKey := releaseValues["_antiopaModuleChecksum"]; hasKey {
+				recordedChartChecksum, _ := releaseValues[ReleaseChartChecksumKey].(string)
+				recordedValuesChecksum, _ := releaseValues[ReleaseValuesChecksumKey].(string)
+				if recordedChartChecksum != "" && recordedValuesChecksum != "" {
+					if recordedChartChecksum == chartChecksum && recordedValuesChecksum == valuesChecksum {
+						doRelease = false
+						m.log(ModuleLogSourceHelm).Infof("chart and values of helm release '%s' are not changed: skip helm upgrade", helmReleaseName)
+					} else {
+						rlog.Debugf("MODULE_RUN '%s': helm release '%s' chart changed: %v, values changed: %v: upgrade helm release", m.Name, helmReleaseName, recordedChartChecksum != chartChecksum, recordedValuesChecksum != valuesChecksum)
+					}
+				} else if recordedChecksum, hasKey := releaseValues["_antiopaModuleChecksum"]; hasKey {
 					if recordedChecksumStr, ok := recordedChecksum.(string); ok {
 						if recordedChecksumStr == checksum {
 							doRelease = false
-							rlog.Infof("MODULE_RUN '%s': helm release '%s' checksum '%s' does not changed: skip helm upgrade", m.Name, helmReleaseName, checksum)
+							m.log(ModuleLogSourceHelm).Infof("helm release '%s' checksum '%s' does not changed: skip helm upgrade", helmReleaseName, checksum)
 						} else {
 							rlog.Debugf("MODULE_RUN '%s': helm release '%s' checksum changed '%s' -> '%s': upgrade helm release", m.Name, helmReleaseName, recordedChecksumStr, checksum)
 						}
 					}
 				}
+
+				if !doRelease && status == "DEPLOYED" {
+					if revisionNumber, err := strconv.Atoi(revision); err == nil {
+						m.rememberDeployedRelease(revisionNumber, chartChecksum, valuesChecksum)
+					}
+				}
 			}
 		}
 
 		if doRelease {
-			rlog.Debugf("MODULE_RUN '%s': helm release '%s' checksum '%s': installing/upgrading release", m.Name, helmReleaseName, checksum)
+			if err := m.checkHelmUpgradeGate(helmClient.TillerNamespace(), beforeHelmHooksRun, time.Now()); err != nil {
+				return err
+			}
+			if err := m.verifyChart(helmClient, runChartPath); err != nil {
+				return err
+			}
+
+			manifest, err := m.renderChart(helmClient, helmReleaseName, runChartPath, valuesPath)
+			if err != nil {
+				return err
+			}
+			if artifacts := m.runArtifacts(); artifacts != nil {
+				artifacts.setManifest(manifest)
+			}
+
+			if len(m.moduleManager.policies) > 0 {
+				if err := m.checkPolicies(helmClient, helmReleaseName, manifest); err != nil {
+					return err
+				}
+			}
+
+			// Objects with skip annotations and CRDs with uninstall policy are changed
+			// in the rendered manifest, so the manifest is installed as a static chart.
+			upgradeChartPath := runChartPath
+			upgradeManifest := manifest
+			if isReleaseExists && helm.HasSkipAnnotations(manifest) {
+				upgradeManifest, err = m.applySkipAnnotations(helmClient, helmReleaseName, upgradeManifest)
+				if err != nil {
+					return err
+				}
+			}
+			if m.CRDUninstallPolicy() != CRDUninstallHelm {
+				upgradeManifest, err = helm.KeepCustomResourceDefinitions(upgradeManifest)
+				if err != nil {
+					return fmt.Errorf("cannot set resource policy of CRDs: %s", err)
+				}
+			}
+			if upgradeManifest != manifest {
+				upgradeChartPath, err = m.prepareStaticChart(helmReleaseName, runChartPath, upgradeManifest)
+				if err != nil {
+					return err
+				}
+			}
+
+			snapshot, err := m.snapshot()
+			if err != nil {
+				return err
+			}
+			valuesChecksumSetValues, err := m.valuesChecksumSetValues()
+			if err != nil {
+				return err
+			}
 
-			return m.moduleManager.helm.UpgradeRelease(
-				helmReleaseName, runChartPath,
+			rlog.Debugf("MODULE_RUN '%s': helm release '%s' checksum '%s', module source checksum '%s': installing/upgrading release", m.Name, helmReleaseName, checksum, snapshot.Checksum)
+
+			setValues := m.helmSetValues(checksum)
+			setValues = append(setValues, valuesChecksumSetValues...)
+			setValues = append(setValues, releaseChecksumsSetValues(chartChecksum, valuesChecksum)...)
+			setValues = append(setValues, snapshot.helmSetValues()...)
+			setValues = append(setValues, taskIdSetValues(taskId)...)
+
+			upgradeResult, err := helmClient.UpgradeRelease(
+				helmReleaseName, upgradeChartPath,
 				[]string{valuesPath},
-				[]string{fmt.Sprintf("_antiopaModuleChecksum=%s", checksum)},
-				m.moduleManager.helm.TillerNamespace(),
+				setValues,
+				helmClient.TillerNamespace(),
+				m.Definition != nil && m.Definition.ReuseValues,
 			)
+			if err != nil {
+				m.log(ModuleLogSourceHelm).Errorf("upgrade of helm release '%s' failed: %s", helmReleaseName, err)
+				return &ErrHelmUpgradeFailed{Module: m.Name, Release: helmReleaseName, Err: err}
+			}
+			m.log(ModuleLogSourceHelm).Infof("%s", upgradeResult)
+			m.lastRunReleaseUpgrade = upgradeResult
+
+			m.setForceHelmUpgrade(false)
+
+			m.updateResourcesTotals(manifest)
+			m.updateDeprecatedApis(manifest)
+
+			if m.Definition != nil && m.Definition.HelmTest.Enabled {
+				err = m.runHelmTest(helmClient, helmReleaseName)
+				if err != nil {
+					// Release checksum is already updated: force upgrade on retry to run tests again
+					m.setForceHelmUpgrade(true)
+					return err
+				}
+			}
+
+			if upgradeResult != nil && upgradeResult.Status == "DEPLOYED" {
+				m.rememberDeployedRelease(upgradeResult.Revision, chartChecksum, valuesChecksum)
+			}
 		} else {
 			rlog.Debugf("MODULE_RUN '%s': helm release '%s' checksum '%s': release install/upgrade is skipped", m.Name, helmReleaseName, checksum)
+
+			// the first run after restart
+			if m.ResourcesTotals() == nil {
+				manifest, err := helmClient.GetReleaseManifest(helmReleaseName)
+				if err != nil {
+					rlog.Errorf("MODULE_RUN '%s': cannot get manifest of release '%s': %s", m.Name, helmReleaseName, err)
+				} else {
+					m.updateResourcesTotals(manifest)
+					m.updateDeprecatedApis(manifest)
+				}
+			}
 		}
 
 		return nil
@@ -167,38 +445,183 @@ func (m *Module) execRun() error {
 	return nil
 }
 
-func (m *Module) delete() error {
+// prepareRunChart copies module chart into the temp dir with empty values.yaml
+func (m *Module) prepareRunChart() (string, error) {
+	runChartPath := filepath.Join(TempDir, fmt.Sprintf("%s.chart", m.SafeName()))
+
+	err := os.RemoveAll(runChartPath)
+	if err != nil {
+		return "", err
+	}
+	err = copy.Copy(m.Path, runChartPath)
+	if err != nil {
+		return "", err
+	}
+
+	// Prepare dummy empty values.yaml for helm not to fail
+	err = os.Truncate(filepath.Join(runChartPath, "values.yaml"), 0)
+	if err != nil {
+		return "", err
+	}
+
+	return runChartPath, nil
+}
+
+// applySkipAnnotations returns the rendered manifest where objects with skip-update
+// and preserve-fields annotations are taken from the current release
+func (m *Module) applySkipAnnotations(helmClient helm.HelmClient, helmReleaseName string, manifest string) (string, error) {
+	releaseManifest, err := helmClient.GetReleaseManifest(helmReleaseName)
+	if err != nil {
+		return "", err
+	}
+
+	manifest, err = helm.ApplySkipAnnotations(manifest, releaseManifest)
+	if err != nil {
+		return "", fmt.Errorf("cannot apply skip annotations: %s", err)
+	}
+	return manifest, nil
+}
+
+// prepareStaticChart creates a chart with the manifest that is changed after rendering.
+// Chart has only Chart.yaml from the module chart and a template that outputs the manifest.
+func (m *Module) prepareStaticChart(helmReleaseName string, runChartPath string, manifest string) (string, error) {
+	chartPath := filepath.Join(TempDir, fmt.Sprintf("%s.static-chart", m.SafeName()))
+	if err := os.RemoveAll(chartPath); err != nil {
+		return "", err
+	}
+	if err := os.MkdirAll(filepath.Join(chartPath, "templates"), 0755); err != nil {
+		return "", err
+	}
+
+	chartYaml, err := ioutil.ReadFile(filepath.Join(runChartPath, "Chart.yaml"))
+	if err != nil {
+		return "", err
+	}
+	if err := dumpData(filepath.Join(chartPath, "Chart.yaml"), chartYaml); err != nil {
+		return "", err
+	}
+	// manifest is not a template: it is passed through .Files to keep '{{' as is
+	if err := dumpData(filepath.Join(chartPath, "manifest.yaml"), []byte(manifest)); err != nil {
+		return "", err
+	}
+	if err := dumpData(filepath.Join(chartPath, "templates", "manifest.yaml"), []byte(`{{ .Files.Get "manifest.yaml" }}`)); err != nil {
+		return "", err
+	}
+
+	rlog.Infof("MODULE_RUN '%s': manifest of release '%s' is changed after rendering, use static chart", m.Name, helmReleaseName)
+
+	return chartPath, nil
+}
+
+// renderManifest returns a manifest of the module release rendered with current values.
+// Manifest is served from the render cache if chart and values are not changed.
+// Empty manifest is returned for module without chart.
+func (m *Module) renderManifest() (string, error) {
+	manifest := ""
+
+	err := m.execHelm(func(helmClient helm.HelmClient, valuesPath, helmReleaseName string) error {
+		runChartPath, err := m.prepareRunChart()
+		if err != nil {
+			return err
+		}
+
+		manifest, err = m.renderChart(helmClient, helmReleaseName, runChartPath, valuesPath)
+		return err
+	})
+
+	return manifest, err
+}
+
+// renderChart renders prepared chart with values or returns a manifest from the render cache
+func (m *Module) renderChart(helmClient helm.HelmClient, helmReleaseName, runChartPath, valuesPath string) (string, error) {
+	// setValues and files for them are in module.yaml and chart directory
+	chartChecksum, err := utils.CalculateChecksumOfPaths(runChartPath)
+	if err != nil {
+		return "", err
+	}
+	valuesChecksum, err := utils.CalculateChecksumOfPaths(valuesPath)
+	if err != nil {
+		return "", err
+	}
+
+	cacheKey := helm.RenderCacheKey(chartChecksum, valuesChecksum)
+	if manifest, hasManifest := m.moduleManager.renderCache.Get(cacheKey); hasManifest {
+		rlog.Debugf("MODULE '%s': use cached manifest for release '%s'", m.Name, helmReleaseName)
+		return manifest, nil
+	}
+
+	valuesChecksumSetValues, err := m.valuesChecksumSetValues()
+	if err != nil {
+		return "", err
+	}
+	manifest, err := helmClient.RenderRelease(
+		helmReleaseName, runChartPath,
+		[]string{valuesPath},
+		append(m.helmSetValues(""), valuesChecksumSetValues...),
+		helmClient.TillerNamespace(),
+	)
+	if err != nil {
+		return "", err
+	}
+	m.moduleManager.renderCache.Put(cacheKey, manifest)
+
+	return manifest, nil
+}
+
+func (m *Module) delete(taskId string) error {
+	m.forgetDeployedRelease()
+	m.helmUpgradeWait = nil
+
 	// Если есть chart, но нет релиза — warning
 	// если нет чарта — молча перейти к хукам
 	// если есть и chart и релиз — удалить
 	chartExists, _ := m.checkHelmChart()
 	if chartExists {
-		releaseExists, err := m.moduleManager.helm.IsReleaseExists(m.generateHelmReleaseName())
+		helmClient, err := m.HelmClient()
+		if err != nil {
+			return err
+		}
+		helmClient = helm.WithTaskId(helmClient, taskId)
+		releaseExists, err := helmClient.IsReleaseExists(m.generateHelmReleaseName())
 		if !releaseExists {
 			if err != nil {
-				rlog.Warnf("Module delete: Cannot find helm release '%s' for module '%s'. Helm error: %s", m.generateHelmReleaseName(), m.Name, err)
+				m.log(ModuleLogSourceHelm).Warnf("delete: cannot find helm release '%s': %s", m.generateHelmReleaseName(), err)
 			} else {
-				rlog.Warnf("Module delete: Cannot find helm release '%s' for module '%s'.", m.generateHelmReleaseName(), m.Name)
+				m.log(ModuleLogSourceHelm).Warnf("delete: cannot find helm release '%s'", m.generateHelmReleaseName())
 			}
 		} else {
 			// Есть чарт и есть релиз — запуск удаления
-			err := m.moduleManager.helm.DeleteRelease(m.generateHelmReleaseName())
+			crds, err := m.prepareCRDsUninstall(helmClient, m.generateHelmReleaseName())
 			if err != nil {
 				return err
 			}
+			err = helmClient.DeleteRelease(m.generateHelmReleaseName())
+			if helm.IsReleaseNotFound(err) {
+				// release is deleted by someone else
+				m.log(ModuleLogSourceHelm).Warnf("delete: helm release '%s' is already deleted", m.generateHelmReleaseName())
+			} else if err != nil {
+				return err
+			}
+			if err := m.deleteUnusedCRDs(crds); err != nil {
+				return err
+			}
 		}
 	}
 
-	if err := m.runHooksByBinding(AfterDeleteHelm); err != nil {
+	m.setResourcesTotals(nil)
+
+	if err := m.runHooksByBinding(AfterDeleteHelm, taskId); err != nil {
 		return err
 	}
 
+	m.deleteExportedValues()
+
 	return nil
 }
 
 func (m *Module) execDelete() error {
-	err := m.execHelm(func(_, helmReleaseName string) error {
-		return m.moduleManager.helm.DeleteRelease(helmReleaseName)
+	err := m.execHelm(func(helmClient helm.HelmClient, _, helmReleaseName string) error {
+		return helmClient.DeleteRelease(helmReleaseName)
 	})
 
 	if err != nil {
@@ -208,7 +631,7 @@ func (m *Module) execDelete() error {
 	return nil
 }
 
-func (m *Module) execHelm(executeHelm func(valuesPath, helmReleaseName string) error) error {
+func (m *Module) execHelm(executeHelm func(helmClient helm.HelmClient, valuesPath, helmReleaseName string) error) error {
 	chartExists, err := m.checkHelmChart()
 	if !chartExists {
 		if err != nil {
@@ -217,36 +640,164 @@ func (m *Module) execHelm(executeHelm func(valuesPath, helmReleaseName string) e
 		}
 	}
 
+	helmClient, err := m.HelmClient()
+	if err != nil {
+		return err
+	}
+
 	helmReleaseName := m.generateHelmReleaseName()
 	valuesPath, err := m.prepareValuesYamlFile()
 	if err != nil {
 		return err
 	}
 
-	if err = executeHelm(valuesPath, helmReleaseName); err != nil {
+	if err = executeHelm(helmClient, valuesPath, helmReleaseName); err != nil {
 		return err
 	}
 
 	return nil
 }
 
-func (m *Module) runHooksByBinding(binding BindingType) error {
+// HelmClient returns a client for the tiller of the module
+func (m *Module) HelmClient() (helm.HelmClient, error) {
+	if cluster := m.Cluster(); cluster != "" {
+		if m.moduleManager.helmClients == nil {
+			return nil, fmt.Errorf("cluster '%s' is not supported", cluster)
+		}
+		return m.moduleManager.helmClients.GetCluster(cluster)
+	}
+
+	tillerNamespace := ""
+	if m.Definition != nil {
+		tillerNamespace = m.Definition.TillerNamespace
+	}
+	return m.moduleManager.helmClient(tillerNamespace)
+}
+
+// ReleaseNotes returns rendered NOTES.txt of the module release.
+// Empty notes are returned if module has no chart or release is not installed.
+func (m *Module) ReleaseNotes() (string, error) {
+	if chartExists, _ := m.checkHelmChart(); !chartExists {
+		return "", nil
+	}
+
+	helmClient, err := m.HelmClient()
+	if err != nil {
+		return "", err
+	}
+
+	notes, err := helmClient.GetReleaseNotes(m.generateHelmReleaseName())
+	if helm.IsReleaseNotFound(err) {
+		return "", nil
+	}
+	return notes, err
+}
+
+// helmSetValues returns values for helm upgrade: setValues from module.yaml and
+// the checksum of the release if it is not empty. Relative paths of files are resolved from the module directory.
+func (m *Module) helmSetValues(checksum string) []helm.SetValue {
+	setValues := make([]helm.SetValue, 0)
+	if m.Definition != nil {
+		for _, setValue := range m.Definition.SetValues {
+			if setValue.Type == helm.SetValueFile && !filepath.IsAbs(setValue.Value) {
+				setValue.Value = filepath.Join(m.Path, setValue.Value)
+			}
+			setValues = append(setValues, setValue)
+		}
+	}
+	if checksum == "" {
+		return setValues
+	}
+	// checksum should not be coerced into a number by helm
+	return append(setValues, helm.NewSetStringValue("_antiopaModuleChecksum", checksum))
+}
+
+// runHelmTest runs `helm test` for the module release. Logs of test pods are
+// written to the log and returned in error if tests are failed.
+func (m *Module) runHelmTest(helmClient helm.HelmClient, helmReleaseName string) error {
+	m.log(ModuleLogSourceHelm).Infof("run helm test for release '%s'", helmReleaseName)
+
+	output, err := helmClient.TestRelease(helmReleaseName, m.Definition.HelmTest.Timeout, m.Definition.HelmTest.Cleanup)
+	if err != nil {
+		m.log(ModuleLogSourceHelm).Errorf("helm test of release '%s' failed: %s", helmReleaseName, err)
+		return fmt.Errorf("module '%s' tests failed: %s", m.Name, err)
+	}
+
+	m.log(ModuleLogSourceHelm).Debugf("helm test output:\n%s", output)
+
+	return nil
+}
+
+func (m *Module) runHooksByBinding(binding BindingType, taskId string) error {
 	moduleHooksAfterHelm, err := m.moduleManager.GetModuleHooksInOrder(m.Name, binding)
 	if err != nil {
 		return err
 	}
 
+	// Consecutive parallel hooks form a group. Groups and sequential hooks are run in order.
+	parallelHooks := make([]*ModuleHook, 0)
 	for _, moduleHookName := range moduleHooksAfterHelm {
 		moduleHook, err := m.moduleManager.GetModuleHook(moduleHookName)
 		if err != nil {
 			return err
 		}
 
-		if err := moduleHook.run(binding, []BindingContext{{Binding: ContextBindingType[binding]}}); err != nil {
+		if moduleHook.Config != nil && moduleHook.Config.Parallel {
+			parallelHooks = append(parallelHooks, moduleHook)
+			continue
+		}
+
+		if err := runHooksInParallel(parallelHooks, binding, taskId); err != nil {
 			return err
 		}
+		parallelHooks = parallelHooks[:0]
+
+		if err := moduleHook.run(binding, []BindingContext{{Binding: ContextBindingType[binding]}}, taskId); err != nil {
+			return err
+		}
+	}
+
+	return runHooksInParallel(parallelHooks, binding, taskId)
+}
+
+// runHooksInParallel runs hooks with at most HooksParallelism hooks at once.
+// The error of the first failed hook in order is returned.
+func runHooksInParallel(hooks []*ModuleHook, binding BindingType, taskId string) error {
+	if len(hooks) == 0 {
+		return nil
+	}
+	if len(hooks) == 1 {
+		return hooks[0].run(binding, []BindingContext{{Binding: ContextBindingType[binding]}}, taskId)
 	}
 
+	rlog.Infof("Running %d module hooks binding '%s' in parallel ...", len(hooks), binding)
+
+	parallelism := HooksParallelism
+	if parallelism < 1 {
+		parallelism = 1
+	}
+	sem := make(chan struct{}, parallelism)
+	errs := make([]error, len(hooks))
+
+	var wg sync.WaitGroup
+	for i, hook := range hooks {
+		wg.Add(1)
+		sem <- struct{}{}
+		go func(i int, hook *ModuleHook) {
+			defer func() {
+				<-sem
+				wg.Done()
+			}()
+			errs[i] = hook.run(binding, []BindingContext{{Binding: ContextBindingType[binding]}}, taskId)
+		}(i, hook)
+	}
+	wg.Wait()
+
+	for _, err := range errs {
+		if err != nil {
+			return err
+		}
+	}
 	return nil
 }
 
@@ -281,11 +832,17 @@ func (m *Module) prepareConfigValuesJsonFile() (string, error) {
 }
 
 func (m *Module) prepareValuesYamlFile() (string, error) {
-	values := m.values()
+	values := m.chartValues(m.values())
 
-	data := utils.MustDump(utils.DumpValuesYaml(values))
+	// secrets are only in the file, not in logs
+	resolvedValues, err := vault.ResolveValues(values)
+	if err != nil {
+		return "", fmt.Errorf("module '%s' values: %s", m.Name, err)
+	}
+
+	data := utils.MustDump(utils.DumpValuesYaml(resolvedValues))
 	path := filepath.Join(TempDir, fmt.Sprintf("%s.module-values.yaml", m.SafeName()))
-	err := dumpData(path, data)
+	err = dumpData(path, data)
 	if err != nil {
 		return "", err
 	}
@@ -296,9 +853,14 @@ func (m *Module) prepareValuesYamlFile() (string, error) {
 }
 
 func (m *Module) prepareValuesJsonFileWith(values utils.Values) (string, error) {
-	data := utils.MustDump(utils.DumpValuesJson(values))
+	resolvedValues, err := vault.ResolveValues(values)
+	if err != nil {
+		return "", fmt.Errorf("module '%s' values: %s", m.Name, err)
+	}
+
+	data := utils.MustDump(utils.DumpValuesJson(resolvedValues))
 	path := filepath.Join(TempDir, fmt.Sprintf("%s.module-values.json", m.SafeName()))
-	err := dumpData(path, data)
+	err = dumpData(path, data)
 	if err != nil {
 		return "", err
 	}
@@ -326,18 +888,22 @@ func (m *Module) checkHelmChart() (bool, error) {
 }
 
 func (m *Module) generateHelmReleaseName() string {
-	return m.Name
+	return utils.ModuleReleaseName(m.Name)
 }
 
 // configValues returns values from ConfigMap: global section and module section
 func (m *Module) configValues() utils.Values {
+	return m.configValuesFrom(m.moduleManager.valuesStorage)
+}
+
+func (m *Module) configValuesFrom(storage *ValuesStorage) utils.Values {
 	return utils.MergeValues(
 		// global section
 		utils.Values{"global": map[string]interface{}{}},
-		m.moduleManager.kubeGlobalConfigValues,
+		storage.KubeGlobalConfigValues(),
 		// module section
 		utils.Values{utils.ModuleNameToValuesKey(m.Name): map[string]interface{}{}},
-		m.moduleManager.kubeModulesConfigValues[m.Name],
+		storage.KubeModuleConfigValues(m.Name),
 	)
 }
 
@@ -345,27 +911,70 @@ func (m *Module) configValues() utils.Values {
 //
 // global: static + kube + patches from hooks
 //
-// module: static + kube + patches from hooks
+// module: static + kube + imported from other modules + patches from hooks
 //
-// global section also contains enabledModules key with previously enabled modules
+// global section also contains enabledModules key with previously enabled modules,
+// values templates are evaluated before enabledModules key is added
 func (m *Module) constructValues(enabledModules []string) utils.Values {
+	res, stats := m.constructValuesFrom(m.moduleManager.valuesStorage, enabledModules)
+	m.valuesStats.set(stats)
+	return res
+}
+
+// constructValuesFrom returns effective values from the storage and stats of values layers
+func (m *Module) constructValuesFrom(storage *ValuesStorage, enabledModules []string) (utils.Values, *ValuesStats) {
 	var err error
 
-	res := utils.MergeValues(
+	moduleValuesKey := utils.ModuleNameToValuesKey(m.Name)
+	layers := []struct {
+		name   string
+		values utils.Values
+	}{
 		// global
-		utils.Values{"global": map[string]interface{}{}},
-		m.moduleManager.globalStaticValues,
-		m.moduleManager.kubeGlobalConfigValues,
+		{"", utils.Values{"global": map[string]interface{}{}}},
+		{ValuesLayerGlobalStatic, storage.GlobalStaticValues()},
+		{ValuesLayerGlobalExternal, storage.ExternalValuesSection("global")},
+		{ValuesLayerGlobalCluster, m.clusterValuesSection("global")},
+		{ValuesLayerGlobalConfig, storage.KubeGlobalConfigValues()},
 		// module
-		utils.Values{utils.ModuleNameToValuesKey(m.Name): map[string]interface{}{}},
-		m.StaticConfig.Values,
-		m.moduleManager.kubeModulesConfigValues[m.Name],
-	)
+		{"", utils.Values{moduleValuesKey: map[string]interface{}{}}},
+		{ValuesLayerModuleStatic, m.StaticConfig.Values},
+		{ValuesLayerModuleExternal, storage.ExternalValuesSection(moduleValuesKey)},
+		{ValuesLayerModuleCluster, m.clusterValuesSection(moduleValuesKey)},
+		// values shared by modules of the group, module section overrides them
+		{ValuesLayerGroupConfig, m.groupValues(storage)},
+		{ValuesLayerModuleConfig, storage.KubeModuleConfigValues(m.Name)},
+		// values exported by other modules
+		{ValuesLayerImported, m.importedValues(storage)},
+		// tags of images from imageTags key of the ConfigMap
+		{ValuesLayerImageTags, m.imageTagsValues(storage)},
+	}
+
+	stats := &ValuesStats{LayersSizes: make(map[string]int)}
+	valuesLayers := make([]utils.Values, 0, len(layers))
+	for _, layer := range layers {
+		if layer.name != "" {
+			stats.LayersSizes[layer.name] = valuesSize(layer.values)
+		}
+		valuesLayers = append(valuesLayers, layer.values)
+	}
 
-	for _, patches := range [][]utils.ValuesPatch{
-		m.moduleManager.globalDynamicValuesPatches,
-		m.moduleManager.modulesDynamicValuesPatches[m.Name],
-	} {
+	mergeStartedAt := time.Now()
+	res := utils.MergeValues(valuesLayers...)
+	stats.MergeDuration = time.Since(mergeStartedAt)
+
+	// defaults from schema are used for fields that are absent in static and kube values
+	schemaStartedAt := time.Now()
+	res = m.applyValuesSchemaDefaults(res)
+	stats.SchemaDuration = time.Since(schemaStartedAt)
+
+	dynamicPatches := [][]utils.ValuesPatch{
+		storage.GlobalDynamicValuesPatches(),
+		storage.ModuleDynamicValuesPatches(m.Name),
+	}
+	stats.LayersSizes[ValuesLayerDynamic] = valuesPatchesSize(dynamicPatches...)
+
+	for _, patches := range dynamicPatches {
 		for _, patch := range patches {
 			// Invariant: do not store patches that does not apply
 			// Give user error for patches early, after patch receive
@@ -377,15 +986,21 @@ func (m *Module) constructValues(enabledModules []string) utils.Values {
 		}
 	}
 
-	res = utils.MergeValues(res, m.constructEnabledModulesValues(enabledModules))
+	res = evaluateValuesTemplates(res, fmt.Sprintf("module '%s'", m.Name))
 
-	return res
+	res = utils.MergeValues(res, enabledModulesValues(enabledModules))
+	res = m.moduleManager.setFeatureGatesValues(res)
+	res = m.moduleManager.setNodePlatformsValues(res)
+
+	return res, stats
 }
 
-func (m *Module) constructEnabledModulesValues(enabledModules []string) utils.Values {
+// enabledModulesValues returns global.enabledModules with names of enabled modules in order of run,
+// so hooks and charts can integrate with other modules only if they are enabled
+func enabledModulesValues(enabledModules []string) utils.Values {
 	return utils.Values{
 		"global": map[string]interface{}{
-			"enabledModules": enabledModules,
+			"enabledModules": append([]string{}, enabledModules...),
 		},
 	}
 }
@@ -457,13 +1072,31 @@ func (m *Module) checkIsEnabledByScript(precedingEnabledModules []string) (bool,
 		return false, err
 	}
 
-	rlog.Infof("MODULE '%s': run enabled script '%s'...", m.Name, enabledScriptPath)
+	m.log(ModuleLogSourceModule).Infof("run enabled script '%s'...", enabledScriptPath)
+
+	moduleEnabled, err := m.runEnabledScript(enabledScriptPath, configValuesPath, valuesPath, enabledResultFilePath, []string{})
+	if err != nil {
+		m.log(ModuleLogSourceModule).Errorf("enabled script failed: %s", err)
+		return false, err
+	}
+
+	if moduleEnabled {
+		m.log(ModuleLogSourceModule).Debugf("ENABLED with script. Preceding: %s", precedingEnabledModules)
+		return true, nil
+	}
+
+	m.log(ModuleLogSourceModule).Debugf("DISABLED with script. Preceding: %s", precedingEnabledModules)
+	return false, nil
+}
 
+func (m *Module) runEnabledScript(enabledScriptPath string, configValuesPath string, valuesPath string, enabledResultFilePath string, envs []string) (bool, error) {
 	cmd := m.moduleManager.makeHookCommand(
-		WorkingDir, configValuesPath, valuesPath, "", enabledScriptPath, []string{},
-		[]string{
+		WorkingDir, configValuesPath, valuesPath, "", "", enabledScriptPath, []string{},
+		append([]string{
 			fmt.Sprintf("MODULE_ENABLED_RESULT=%s", enabledResultFilePath),
-		},
+			fmt.Sprintf("CONFIG_VALUES_JSON_PATH=%s", configValuesPath),
+			fmt.Sprintf("VALUES_JSON_PATH=%s", valuesPath),
+		}, envs...),
 	)
 
 	if err := executor.Run(cmd, true); err != nil {
@@ -474,14 +1107,7 @@ func (m *Module) checkIsEnabledByScript(precedingEnabledModules []string) (bool,
 	if err != nil {
 		return false, fmt.Errorf("bad enabled result in file MODULE_ENABLED_RESULT=\"%s\" from enabled script '%s' for module '%s': %s", enabledResultFilePath, enabledScriptPath, m.Name, err)
 	}
-
-	if moduleEnabled {
-		rlog.Debugf("Module '%s'  ENABLED with script. Preceding: %s", m.Name, precedingEnabledModules)
-		return true, nil
-	}
-
-	rlog.Debugf("Module '%s' DISABLED with script. Preceding: %s ", m.Name, precedingEnabledModules)
-	return false, nil
+	return moduleEnabled, nil
 }
 
 // initModulesIndex load all available modules from modules directory
@@ -499,17 +1125,20 @@ func (mm *MainModuleManager) initModulesIndex() error {
 	if err := mm.initGlobalConfigValues(); err != nil {
 		return err
 	}
-	rlog.Debugf("Set mm.configValues:\n%s", utils.ValuesToString(mm.globalStaticValues))
-
-	var validModuleName = regexp.MustCompile(`^[0-9][0-9][0-9]-(.*)$`)
+	rlog.Debugf("Set mm.configValues:\n%s", utils.ValuesToString(mm.valuesStorage.GlobalStaticValues()))
 
 	badModulesDirs := make([]string, 0)
+	modulesNames := make([]*utils.ModuleNames, 0)
 
 	for _, file := range files {
 		if file.IsDir() {
-			matchRes := validModuleName.FindStringSubmatch(file.Name())
-			if matchRes != nil {
-				moduleName := matchRes[1]
+			names, err := utils.NewModuleNames(file.Name())
+			if err != nil {
+				rlog.Errorf("Module directory '%s': %s", filepath.Join(modulesDir, file.Name()), err)
+			}
+			if names != nil {
+				modulesNames = append(modulesNames, names)
+				moduleName := names.Name
 				rlog.Infof("Load and register module '%s' ...", moduleName)
 
 				modulePath := filepath.Join(modulesDir, file.Name())
@@ -525,6 +1154,18 @@ func (mm *MainModuleManager) initModulesIndex() error {
 					return err
 				}
 
+				// load module settings from module.yaml
+				err = module.loadDefinition()
+				if err != nil {
+					return err
+				}
+
+				// load schema from openapi/values.yaml
+				err = module.loadValuesSchema()
+				if err != nil {
+					return err
+				}
+
 				mm.allModulesByName[module.Name] = module
 				mm.allModulesNamesInOrder = append(mm.allModulesNamesInOrder, module.Name)
 			} else {
@@ -533,10 +1174,35 @@ func (mm *MainModuleManager) initModulesIndex() error {
 		}
 	}
 
+	// numeric prefixes define order of modules inside a stage
+	mm.allModulesNamesInOrder = mm.sortModulesByStage(mm.allModulesNamesInOrder)
+
 	rlog.Debugf("initModulesIndex: %v", mm.allModulesByName)
 
 	if len(badModulesDirs) > 0 {
-		return fmt.Errorf("bad module directory names, must match regex '%s': %s", validModuleName, strings.Join(badModulesDirs, ", "))
+		return fmt.Errorf("bad module directory names, must be '<NNN>-<kebab-case-name>': %s", strings.Join(badModulesDirs, ", "))
+	}
+
+	if err := utils.DetectModuleNamesCollisions(modulesNames); err != nil {
+		return err
+	}
+
+	if err := mm.validateModulesImports(); err != nil {
+		return err
+	}
+
+	if err := mm.validateModulesRequires(); err != nil {
+		return err
+	}
+
+	if err := mm.validateModulesDependsOn(); err != nil {
+		return err
+	}
+	// dependencies are run before dependent modules even if ModulesParallelism is 1
+	mm.allModulesNamesInOrder = mm.sortModulesByDependsOn(mm.allModulesNamesInOrder)
+
+	if err := mm.loadRemoteClusters(); err != nil {
+		return err
 	}
 
 	return nil
@@ -547,9 +1213,9 @@ func (mm *MainModuleManager) initGlobalConfigValues() (err error) {
 	if err != nil {
 		return
 	}
-	mm.globalStaticValues = values
+	mm.valuesStorage.SetGlobalStaticValues(values)
 
-	rlog.Debugf("Initialized global static values:\n%s", utils.ValuesToString(mm.globalStaticValues))
+	rlog.Debugf("Initialized global static values:\n%s", utils.ValuesToString(values))
 
 	return
 }
@@ -578,6 +1244,50 @@ func (m *Module) loadStaticValues() error {
 	return nil
 }
 
+// loadValuesSchema loads OpenAPI schema for module values from openapi/values.yaml.
+// Schema is optional.
+func (m *Module) loadValuesSchema() error {
+	schemaPath := filepath.Join(m.Path, "openapi", "values.yaml")
+
+	if _, err := os.Stat(schemaPath); os.IsNotExist(err) {
+		return nil
+	}
+
+	data, err := ioutil.ReadFile(schemaPath)
+	if err != nil {
+		return fmt.Errorf("cannot read '%s': %s", schemaPath, err)
+	}
+
+	m.ValuesSchema, err = utils.NewValuesFromBytes(data)
+	if err != nil {
+		return fmt.Errorf("module '%s' has bad values schema '%s': %s", m.Name, schemaPath, err)
+	}
+
+	rlog.Debugf("module %s values schema: %s", m.Name, utils.ValuesToString(m.ValuesSchema))
+	return nil
+}
+
+// applyValuesSchemaDefaults sets defaults from values schema into module section of values.
+// Defaults are set into an empty section if the module has no values.
+func (m *Module) applyValuesSchemaDefaults(values utils.Values) utils.Values {
+	if m.ValuesSchema == nil {
+		return values
+	}
+
+	moduleValues := make(map[string]interface{})
+	if section, has := values[m.moduleValuesKey()]; has && section != nil {
+		var ok bool
+		moduleValues, ok = section.(map[string]interface{})
+		if !ok {
+			return values
+		}
+	}
+
+	values[m.moduleValuesKey()] = map[string]interface{}(utils.ApplyValuesDefaults(moduleValues, m.ValuesSchema))
+
+	return values
+}
+
 func loadGlobalModulesValues() (utils.Values, error) {
 	filePath := filepath.Join(WorkingDir, "modules", "values.yaml")
 	if _, err := os.Stat(filePath); os.IsNotExist(err) {
@@ -641,6 +1351,11 @@ func dumpValuesYaml(fileName string, values utils.Values) (string, error) {
 }
 
 func dumpValuesJson(fileName string, values interface{}) (string, error) {
+	values, err := utils.NormalizeValue(values)
+	if err != nil {
+		return "", err
+	}
+
 	valuesJson, err := json.Marshal(&values)
 	if err != nil {
 		return "", err
@@ -663,7 +1378,8 @@ func dumpData(filePath string, data []byte) error {
 }
 
 func (mm *MainModuleManager) makeCommand(dir string, entrypoint string, args []string, envs []string) *exec.Cmd {
-	envs = append(envs, os.Environ()...)
-	envs = append(envs, mm.helm.CommandEnv()...)
+	// values prepared by antiopa override variables from environment
+	envs = append(append(hooksEnviron(), mm.helm.CommandEnv()...), envs...)
+	envs = append(envs, fmt.Sprintf("%s=%s", version.VersionEnv, version.Version))
 	return utils.MakeCommand(dir, entrypoint, args, envs)
 }
@@ -0,0 +1,195 @@
+package module_manager
+
+import (
+	"fmt"
+
+	"github.com/romana/rlog"
+)
+
+// ModulesParallelism is a max number of modules that are run at once in a converge cycle.
+// Modules are run one by one in order if it is 1, otherwise independent branches of
+// the dependsOn graph are run concurrently. Hooks of each module are run in order.
+var ModulesParallelism = 1
+
+// dependsOn returns modules from dependsOn of module.yaml
+func (m *Module) dependsOn() []string {
+	if m == nil || m.Definition == nil {
+		return nil
+	}
+	return m.Definition.DependsOn
+}
+
+// validateModulesDependsOn checks that dependencies exist, are not in later stages and have no cycles
+func (mm *MainModuleManager) validateModulesDependsOn() error {
+	for _, moduleName := range mm.allModulesNamesInOrder {
+		module := mm.allModulesByName[moduleName]
+		for _, dependencyName := range module.dependsOn() {
+			dependency, ok := mm.allModulesByName[dependencyName]
+			if !ok {
+				return fmt.Errorf("module '%s' depends on unknown module '%s'", moduleName, dependencyName)
+			}
+			if dependencyName == moduleName {
+				return fmt.Errorf("module '%s' depends on itself", moduleName)
+			}
+			if dependency.Stage().Index() > module.Stage().Index() {
+				return fmt.Errorf("module '%s' of stage '%s' depends on module '%s' of later stage '%s'", moduleName, module.Stage(), dependencyName, dependency.Stage())
+			}
+		}
+	}
+
+	const (
+		visiting = 1
+		visited  = 2
+	)
+	state := make(map[string]int)
+	var visit func(moduleName string, path []string) error
+	visit = func(moduleName string, path []string) error {
+		switch state[moduleName] {
+		case visited:
+			return nil
+		case visiting:
+			return fmt.Errorf("modules have a dependsOn cycle: %v", append(path, moduleName))
+		}
+		state[moduleName] = visiting
+		for _, dependencyName := range mm.allModulesByName[moduleName].dependsOn() {
+			if err := visit(dependencyName, append(path, moduleName)); err != nil {
+				return err
+			}
+		}
+		state[moduleName] = visited
+		return nil
+	}
+	for _, moduleName := range mm.allModulesNamesInOrder {
+		if err := visit(moduleName, nil); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// sortModulesByDependsOn moves modules after their dependencies from the list.
+// Order of independent modules is kept.
+func (mm *MainModuleManager) sortModulesByDependsOn(modulesNames []string) []string {
+	inList := make(map[string]bool, len(modulesNames))
+	for _, name := range modulesNames {
+		inList[name] = true
+	}
+
+	res := make([]string, 0, len(modulesNames))
+	added := make(map[string]bool, len(modulesNames))
+	for len(res) < len(modulesNames) {
+		next := ""
+		for _, name := range modulesNames {
+			if !added[name] && mm.dependenciesAreDone(name, inList, added) {
+				next = name
+				break
+			}
+		}
+		if next == "" {
+			// cycle: keep the first remaining module in place
+			for _, name := range modulesNames {
+				if !added[name] {
+					next = name
+					break
+				}
+			}
+		}
+		added[next] = true
+		res = append(res, next)
+	}
+	return res
+}
+
+// dependenciesAreDone returns true if all dependencies of the module from the list are done
+func (mm *MainModuleManager) dependenciesAreDone(moduleName string, inList map[string]bool, done map[string]bool) bool {
+	for _, dependencyName := range mm.allModulesByName[moduleName].dependsOn() {
+		if inList[dependencyName] && !done[dependencyName] {
+			return false
+		}
+	}
+	return true
+}
+
+type moduleGraphResult struct {
+	moduleName string
+	err        error
+}
+
+// RunModulesGraph calls run for modules with at most ModulesParallelism modules at once. Module
+// is started after its dependencies from the list are run successfully, dependencies that are not
+// in the list are considered converged. Modules are started in the order of the list.
+// Module with a failed dependency is not run and has ErrModuleDependencyFailed in the result.
+// Result has an error or nil for each module.
+func (mm *MainModuleManager) RunModulesGraph(modulesNames []string, run func(moduleName string) error) map[string]error {
+	parallelism := ModulesParallelism
+	if parallelism < 1 {
+		parallelism = 1
+	}
+
+	inList := make(map[string]bool, len(modulesNames))
+	for _, name := range modulesNames {
+		inList[name] = true
+	}
+
+	errs := make(map[string]error, len(modulesNames))
+	started := make(map[string]bool, len(modulesNames))
+	done := make(map[string]bool, len(modulesNames))
+	results := make(chan moduleGraphResult)
+	running := 0
+
+	for len(done) < len(inList) {
+		progress := false
+		for _, name := range modulesNames {
+			if started[name] || running >= parallelism {
+				continue
+			}
+			if !mm.dependenciesAreDone(name, inList, done) {
+				continue
+			}
+			started[name] = true
+			progress = true
+
+			if failed := mm.failedDependency(name, inList, errs); failed != "" {
+				errs[name] = &ErrModuleDependencyFailed{Module: name, Dependency: failed}
+				done[name] = true
+				rlog.Errorf("MODULE_RUN %s", errs[name])
+				continue
+			}
+
+			running++
+			go func(name string) {
+				results <- moduleGraphResult{moduleName: name, err: run(name)}
+			}(name)
+		}
+
+		if running == 0 {
+			if !progress {
+				// cycle: modules are validated on start, so this is not expected
+				for _, name := range modulesNames {
+					if !started[name] {
+						started[name] = true
+						done[name] = true
+						errs[name] = fmt.Errorf("module '%s' is not run: dependsOn cycle", name)
+					}
+				}
+			}
+			continue
+		}
+
+		res := <-results
+		running--
+		done[res.moduleName] = true
+		errs[res.moduleName] = res.err
+	}
+	return errs
+}
+
+// failedDependency returns the first dependency of the module from the list that is failed
+func (mm *MainModuleManager) failedDependency(moduleName string, inList map[string]bool, errs map[string]error) string {
+	for _, dependencyName := range mm.allModulesByName[moduleName].dependsOn() {
+		if inList[dependencyName] && errs[dependencyName] != nil {
+			return dependencyName
+		}
+	}
+	return ""
+}
@@ -0,0 +1,83 @@
+package module_manager
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func newModulesGraphManager(dependsOn map[string][]string, names ...string) *MainModuleManager {
+	mm := NewMainModuleManager(&MockHelmClient{}, nil)
+	mm.allModulesByName = make(map[string]*Module)
+	for _, name := range names {
+		module := mm.NewModule()
+		module.Name = name
+		module.Definition = NewModuleDefinition()
+		module.Definition.DependsOn = dependsOn[name]
+		mm.allModulesByName[name] = module
+	}
+	mm.allModulesNamesInOrder = names
+	return mm
+}
+
+func TestMainModuleManager_validateModulesDependsOn(t *testing.T) {
+	mm := newModulesGraphManager(map[string][]string{
+		"ingress":    {"cert-manager"},
+		"dashboard":  {"ingress", "cert-manager"},
+		"monitoring": {"absent"},
+	}, "cert-manager", "ingress", "dashboard", "monitoring")
+	assert.Error(t, mm.validateModulesDependsOn())
+
+	mm.allModulesByName["monitoring"].Definition.DependsOn = nil
+	assert.NoError(t, mm.validateModulesDependsOn())
+
+	mm.allModulesByName["cert-manager"].Definition.DependsOn = []string{"dashboard"}
+	err := mm.validateModulesDependsOn()
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), "cycle")
+	}
+}
+
+func TestMainModuleManager_sortModulesByDependsOn(t *testing.T) {
+	mm := newModulesGraphManager(map[string][]string{
+		"cert-manager": {"cni"},
+		"dashboard":    {"ingress"},
+	}, "dashboard", "cert-manager", "ingress", "cni")
+
+	assert.Equal(t, []string{"ingress", "dashboard", "cni", "cert-manager"}, mm.sortModulesByDependsOn(mm.allModulesNamesInOrder))
+}
+
+func TestMainModuleManager_RunModulesGraph(t *testing.T) {
+	defer func() { ModulesParallelism = 1 }()
+	ModulesParallelism = 4
+
+	mm := newModulesGraphManager(map[string][]string{
+		"ingress":    {"cert-manager"},
+		"dashboard":  {"ingress"},
+		"monitoring": {"prometheus"},
+	}, "cert-manager", "ingress", "dashboard", "prometheus", "monitoring", "dns")
+
+	var m sync.Mutex
+	finished := make(map[string]bool)
+	errs := mm.RunModulesGraph(mm.allModulesNamesInOrder, func(moduleName string) error {
+		m.Lock()
+		defer m.Unlock()
+		for _, dependency := range mm.allModulesByName[moduleName].dependsOn() {
+			assert.True(t, finished[dependency], "%s is run before %s", moduleName, dependency)
+		}
+		finished[moduleName] = true
+		if moduleName == "prometheus" {
+			return fmt.Errorf("helm upgrade failed")
+		}
+		return nil
+	})
+
+	assert.Len(t, errs, 6)
+	assert.NoError(t, errs["dashboard"])
+	assert.NoError(t, errs["dns"])
+	assert.EqualError(t, errs["prometheus"], "helm upgrade failed")
+	assert.True(t, IsModuleDependencyFailed(errs["monitoring"]))
+	assert.False(t, finished["monitoring"])
+}
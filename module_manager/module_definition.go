@@ -0,0 +1,229 @@
+package module_manager
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"github.com/romana/rlog"
+	"gopkg.in/yaml.v2"
+
+	"github.com/flant/antiopa/helm"
+)
+
+const ModuleDefinitionFileName = "module.yaml"
+
+// Режимы слежения за ресурсами helm-релиза модуля
+type ReleaseWatchMode string
+
+const (
+	// ресурсы релиза не отслеживаются
+	ReleaseWatchDisabled ReleaseWatchMode = ""
+	// изменение или удаление ресурса релиза вызывает перезапуск модуля
+	ReleaseWatchRerun ReleaseWatchMode = "rerun"
+	// изменение или удаление ресурса релиза только логируется и отправляется в метрики
+	ReleaseWatchReport ReleaseWatchMode = "report"
+)
+
+// Default timeout for `helm test` in seconds
+const DefaultHelmTestTimeout = 300
+
+// HelmTestDefinition enables `helm test` after helm upgrade of the module release
+type HelmTestDefinition struct {
+	Enabled bool `yaml:"enabled"`
+	// timeout for each test pod in seconds
+	Timeout int `yaml:"timeout"`
+	// delete test pods after logs are collected
+	Cleanup bool `yaml:"cleanup"`
+}
+
+// ModuleDefinition is an optional module.yaml manifest with module settings
+// that are not values: how antiopa should treat the module.
+type ModuleDefinition struct {
+	// WatchRelease enables watching of the module's release resources
+	WatchRelease ReleaseWatchMode `yaml:"watchRelease"`
+	// HelmTest runs chart tests after successful helm upgrade
+	HelmTest HelmTestDefinition `yaml:"helmTest"`
+	// SetValues are passed to helm upgrade with --set, --set-string or --set-file flags.
+	// Use type string for values like versions to avoid coercion into numbers.
+	SetValues []helm.SetValue `yaml:"setValues"`
+	// ReuseValues runs helm upgrade with --reuse-values: values are merged on top of values
+	// of the deployed release instead of replacing them, so values set by third-party
+	// controllers with helm upgrade are kept. Keys removed from module values are kept too.
+	ReuseValues bool `yaml:"reuseValues"`
+	// TillerNamespace of the tiller for the module release. Modules with the same
+	// namespace form a group served by a separate tiller. Default tiller is used if empty.
+	TillerNamespace string `yaml:"tillerNamespace"`
+	// MaintenanceWindows when helm upgrades triggered by non-urgent changes are allowed.
+	// Global windows from maintenance.yaml are used if empty.
+	MaintenanceWindows MaintenanceWindows `yaml:"maintenanceWindows"`
+	// Stage of the module: pre-cluster, cluster or post-cluster. Modules of the
+	// next stage are run after all modules of the previous stage are converged.
+	Stage ModuleStage `yaml:"stage"`
+	// Exports are dot separated paths in module values that are shared with
+	// other modules after successful run, e.g. generated CA certificate.
+	Exports []string `yaml:"exports"`
+	// Imports are names of modules whose exported values are available in
+	// <moduleValuesKey>.imported.<exporterValuesKey>. Module is rerun when they change.
+	Imports []string `yaml:"imports"`
+	// Images are names of images from imageTags key of the ConfigMap, their tags are
+	// available in <moduleValuesKey>.imageTags. Module is rerun when they change.
+	Images []string `yaml:"images"`
+	// Requires are names of modules the module depends on, e.g. cert-manager. Release of the
+	// required module is not deleted while the module is enabled, see ModuleDisableSafety.
+	Requires []string `yaml:"requires"`
+	// DependsOn are names of modules that should be run before the module in a converge cycle.
+	// Modules without dependencies between them are run concurrently, see ModulesParallelism.
+	DependsOn []string `yaml:"dependsOn"`
+	// Tags to run a group of modules with API, e.g. "networking"
+	Tags []string `yaml:"tags"`
+	// Group of modules with shared values from 'group.<group>' key of the ConfigMap,
+	// e.g. "monitoring". Values of the group are merged into the module section.
+	Group string `yaml:"group"`
+	// Cluster is a name of the remote cluster from clusters.yaml for the module release.
+	// Release is installed into the cluster of antiopa if empty.
+	Cluster string `yaml:"cluster"`
+	// Platforms of nodes supported by the module: 'os' or 'os/arch', e.g. ["linux/amd64", "linux/arm64"].
+	// Module is disabled if there are no nodes with these platforms. All platforms are supported if empty.
+	Platforms []string `yaml:"platforms"`
+	// Requirements are minimal number of nodes and allocatable resources of the cluster.
+	// Module is disabled while the cluster is smaller.
+	Requirements ClusterRequirements `yaml:"requirements"`
+	// KubernetesVersionSensitive modules render APIs that depend on the version of kubernetes,
+	// they are rerun with helm upgrade after the upgrade of the control plane.
+	KubernetesVersionSensitive bool `yaml:"kubernetesVersionSensitive"`
+	// Upgrade declares the oldest version of antiopa that can be upgraded directly
+	// and migrations run before the first run after the upgrade.
+	Upgrade UpgradeDefinition `yaml:"upgrade"`
+	// CRDUninstallPolicy is how CRDs of the release are handled when the module is deleted:
+	// 'keep', 'delete-if-unused' or 'block'. CRDs are deleted by helm delete if empty.
+	CRDUninstallPolicy CRDUninstallPolicy `yaml:"crdUninstallPolicy"`
+	// HelmUpgradeGate delays helm upgrade after beforeHelm hooks or waits for objects to appear
+	HelmUpgradeGate HelmUpgradeGateDefinition `yaml:"helmUpgradeGate"`
+	// Critical modules are checked by the composite addons health condition for paging alerts.
+	// All enabled modules are checked if no module is critical.
+	Critical bool `yaml:"critical"`
+}
+
+func NewModuleDefinition() *ModuleDefinition {
+	return &ModuleDefinition{
+		HelmTest: HelmTestDefinition{
+			Timeout: DefaultHelmTestTimeout,
+		},
+	}
+}
+
+// loadDefinition loads module.yaml from the module directory.
+// Default definition is used if module.yaml is not exists.
+func (m *Module) loadDefinition() error {
+	definitionPath := filepath.Join(m.Path, ModuleDefinitionFileName)
+
+	m.Definition = NewModuleDefinition()
+
+	if _, err := os.Stat(definitionPath); os.IsNotExist(err) {
+		return nil
+	}
+
+	data, err := ioutil.ReadFile(definitionPath)
+	if err != nil {
+		return fmt.Errorf("cannot read '%s': %s", definitionPath, err)
+	}
+
+	if err := yaml.UnmarshalStrict(data, m.Definition); err != nil {
+		return fmt.Errorf("module '%s' has bad %s: %s\n%s", m.Name, ModuleDefinitionFileName, err, string(data))
+	}
+
+	if err := m.Definition.validate(); err != nil {
+		return fmt.Errorf("module '%s' has bad %s: %s", m.Name, ModuleDefinitionFileName, err)
+	}
+
+	rlog.Debugf("module %s definition: %+v", m.Name, *m.Definition)
+
+	return nil
+}
+
+func (d *ModuleDefinition) validate() error {
+	switch d.WatchRelease {
+	case ReleaseWatchDisabled, ReleaseWatchRerun, ReleaseWatchReport:
+	default:
+		return fmt.Errorf("unsupported watchRelease '%s', expected '%s' or '%s'", d.WatchRelease, ReleaseWatchRerun, ReleaseWatchReport)
+	}
+
+	if d.HelmTest.Timeout < 0 {
+		return fmt.Errorf("helmTest.timeout should be positive, got %d", d.HelmTest.Timeout)
+	}
+
+	for _, setValue := range d.SetValues {
+		if err := setValue.Validate(); err != nil {
+			return fmt.Errorf("bad setValues: %s", err)
+		}
+	}
+
+	if d.Stage != "" {
+		if err := d.Stage.validate(); err != nil {
+			return err
+		}
+	}
+
+	for _, path := range d.Exports {
+		keys := strings.Split(path, ".")
+		for _, key := range keys {
+			if key == "" {
+				return fmt.Errorf("bad exports path '%s'", path)
+			}
+		}
+		if keys[0] == ImportedValuesKey {
+			return fmt.Errorf("bad exports path '%s': imported values cannot be exported", path)
+		}
+	}
+
+	for _, tag := range d.Tags {
+		if tag == "" || strings.ContainsAny(tag, ", ") {
+			return fmt.Errorf("bad tag '%s'", tag)
+		}
+	}
+
+	for _, platform := range d.Platforms {
+		if err := validatePlatform(platform); err != nil {
+			return err
+		}
+	}
+
+	if err := d.Requirements.init(); err != nil {
+		return fmt.Errorf("bad requirements: %s", err)
+	}
+
+	if err := d.Upgrade.validate(); err != nil {
+		return fmt.Errorf("bad upgrade: %s", err)
+	}
+
+	if err := d.MaintenanceWindows.init(); err != nil {
+		return fmt.Errorf("bad maintenanceWindows: %s", err)
+	}
+
+	if err := d.CRDUninstallPolicy.validate(); err != nil {
+		return err
+	}
+
+	if err := d.HelmUpgradeGate.init(); err != nil {
+		return fmt.Errorf("bad helmUpgradeGate: %s", err)
+	}
+
+	if d.Cluster != "" {
+		if d.TillerNamespace != "" {
+			return fmt.Errorf("tillerNamespace cannot be used with cluster, tiller of the cluster is set in %s", helm.RemoteClustersFileName)
+		}
+		// resources of the release are watched only in the cluster of antiopa
+		if d.WatchRelease != ReleaseWatchDisabled {
+			return fmt.Errorf("watchRelease is not supported for release in remote cluster '%s'", d.Cluster)
+		}
+		// custom resources are counted and CRDs are deleted only in the cluster of antiopa
+		if d.CRDUninstallPolicy != CRDUninstallHelm {
+			return fmt.Errorf("crdUninstallPolicy is not supported for release in remote cluster '%s'", d.Cluster)
+		}
+	}
+
+	return nil
+}
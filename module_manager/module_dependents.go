@@ -0,0 +1,147 @@
+package module_manager
+
+import (
+	"fmt"
+	"sort"
+
+	"github.com/romana/rlog"
+
+	"github.com/flant/antiopa/utils"
+)
+
+const (
+	ModuleDisableSafetyRefuse = "refuse"
+	ModuleDisableSafetyWarn   = "warn"
+	ModuleDisableSafetyOff    = "off"
+)
+
+// ModuleDisableSafety is a policy for deletion of the release of disabled module that is
+// required or imported by enabled modules: 'refuse' keeps the release, 'warn' deletes it
+// with a warning, 'off' disables the check.
+var ModuleDisableSafety = ModuleDisableSafetyRefuse
+
+func checkModuleDisableSafety() error {
+	switch ModuleDisableSafety {
+	case ModuleDisableSafetyRefuse, ModuleDisableSafetyWarn, ModuleDisableSafetyOff:
+		return nil
+	}
+	return fmt.Errorf("unknown policy '%s', expected '%s', '%s' or '%s'", ModuleDisableSafety, ModuleDisableSafetyRefuse, ModuleDisableSafetyWarn, ModuleDisableSafetyOff)
+}
+
+// validateModulesRequires checks that required modules exist
+func (mm *MainModuleManager) validateModulesRequires() error {
+	for _, moduleName := range mm.allModulesNamesInOrder {
+		module := mm.allModulesByName[moduleName]
+		if module.Definition == nil {
+			continue
+		}
+		for _, requiredName := range module.Definition.Requires {
+			if _, ok := mm.allModulesByName[requiredName]; !ok {
+				return fmt.Errorf("module '%s' requires unknown module '%s'", moduleName, requiredName)
+			}
+		}
+	}
+	return nil
+}
+
+// dependents returns enabled modules that require the module or import its values
+func (mm *MainModuleManager) dependents(moduleName string) []string {
+	res := make([]string, 0)
+	for _, name := range mm.enabledModulesInOrder {
+		module := mm.allModulesByName[name]
+		if name == moduleName || module == nil || module.Definition == nil {
+			continue
+		}
+		for _, requiredName := range module.requiredModules() {
+			if requiredName == moduleName {
+				res = append(res, name)
+				break
+			}
+		}
+	}
+	return res
+}
+
+// CheckModuleDisable returns ErrModuleHasDependents if release of the disabled module
+// should not be deleted because of enabled dependent modules
+func (mm *MainModuleManager) CheckModuleDisable(moduleName string) error {
+	if ModuleDisableSafety == ModuleDisableSafetyOff {
+		return nil
+	}
+
+	dependents := mm.dependents(moduleName)
+	if len(dependents) == 0 {
+		return nil
+	}
+
+	err := &ErrModuleHasDependents{Module: moduleName, Dependents: dependents}
+	if ModuleDisableSafety == ModuleDisableSafetyWarn {
+		rlog.Warnf("MODULE_MANAGER delete release of disabled module: %s", err)
+		return nil
+	}
+	return err
+}
+
+// requiredModules returns modules that the module requires or imports values from
+func (m *Module) requiredModules() []string {
+	if m == nil || m.Definition == nil {
+		return nil
+	}
+	return append(append([]string{}, m.Definition.Requires...), m.Definition.Imports...)
+}
+
+// sortModulesForDeletion returns modules names in order of releases deletion: stages
+// in reverse order and consumers before providers within the list, so finalizers of
+// dependent charts can use the provider. Other modules are in reverse module order,
+// modules in a dependency cycle are kept in this order.
+func (mm *MainModuleManager) sortModulesForDeletion(modulesNames []string) []string {
+	ordered := utils.SortReverseByReference(modulesNames, mm.allModulesNamesInOrder)
+	sort.SliceStable(ordered, func(i, j int) bool {
+		return mm.allModulesByName[ordered[i]].Stage().Index() > mm.allModulesByName[ordered[j]].Stage().Index()
+	})
+
+	inList := make(map[string]bool, len(ordered))
+	for _, name := range ordered {
+		inList[name] = true
+	}
+	// number of not yet deleted consumers of the module
+	consumers := make(map[string]int, len(ordered))
+	for _, name := range ordered {
+		for _, providerName := range mm.allModulesByName[name].requiredModules() {
+			if inList[providerName] && providerName != name {
+				consumers[providerName]++
+			}
+		}
+	}
+
+	res := make([]string, 0, len(ordered))
+	deleted := make(map[string]bool, len(ordered))
+	for len(res) < len(ordered) {
+		next := ""
+		for _, name := range ordered {
+			if !deleted[name] && consumers[name] == 0 {
+				next = name
+				break
+			}
+		}
+		if next == "" {
+			// cycle: take the first remaining module
+			for _, name := range ordered {
+				if !deleted[name] {
+					next = name
+					break
+				}
+			}
+			rlog.Warnf("MODULE_MANAGER modules to delete have a dependency cycle, delete '%s' first", next)
+		}
+
+		deleted[next] = true
+		res = append(res, next)
+		for _, providerName := range mm.allModulesByName[next].requiredModules() {
+			if inList[providerName] && providerName != next && consumers[providerName] > 0 {
+				consumers[providerName]--
+			}
+		}
+	}
+	return res
+}
@@ -0,0 +1,87 @@
+package module_manager
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMainModuleManager_CheckModuleDisable(t *testing.T) {
+	defer func() { ModuleDisableSafety = ModuleDisableSafetyRefuse }()
+
+	mm := NewMainModuleManager(&MockHelmClient{}, nil)
+
+	certManager := mm.NewModule()
+	certManager.Name = "cert-manager"
+	certManager.Definition = NewModuleDefinition()
+
+	ingress := mm.NewModule()
+	ingress.Name = "ingress"
+	ingress.Definition = NewModuleDefinition()
+	ingress.Definition.Requires = []string{"cert-manager"}
+
+	dex := mm.NewModule()
+	dex.Name = "dex"
+	dex.Definition = NewModuleDefinition()
+	dex.Definition.Imports = []string{"cert-manager"}
+
+	mm.allModulesByName = map[string]*Module{"cert-manager": certManager, "ingress": ingress, "dex": dex}
+	mm.allModulesNamesInOrder = []string{"cert-manager", "ingress", "dex"}
+	mm.enabledModulesInOrder = []string{"ingress", "dex"}
+
+	assert.NoError(t, mm.validateModulesRequires())
+
+	err := mm.CheckModuleDisable("cert-manager")
+	assert.True(t, IsModuleHasDependents(err))
+	assert.Equal(t, []string{"ingress", "dex"}, err.(*ErrModuleHasDependents).Dependents)
+
+	ModuleDisableSafety = ModuleDisableSafetyWarn
+	assert.NoError(t, mm.CheckModuleDisable("cert-manager"))
+
+	// dependents are disabled too
+	ModuleDisableSafety = ModuleDisableSafetyRefuse
+	mm.enabledModulesInOrder = []string{}
+	assert.NoError(t, mm.CheckModuleDisable("cert-manager"))
+
+	ingress.Definition.Requires = []string{"absent"}
+	assert.Error(t, mm.validateModulesRequires())
+}
+
+func TestMainModuleManager_sortModulesForDeletion(t *testing.T) {
+	mm := NewMainModuleManager(&MockHelmClient{}, nil)
+
+	newModule := func(name string, stage ModuleStage, requires ...string) *Module {
+		module := mm.NewModule()
+		module.Name = name
+		module.Definition = NewModuleDefinition()
+		module.Definition.Stage = stage
+		module.Definition.Requires = requires
+		mm.allModulesByName[name] = module
+		mm.allModulesNamesInOrder = append(mm.allModulesNamesInOrder, name)
+		return module
+	}
+	mm.allModulesByName = make(map[string]*Module)
+
+	newModule("cni", StagePreCluster)
+	newModule("cert-manager", "")
+	newModule("ingress", "", "cert-manager")
+	newModule("dashboard", "", "ingress")
+	newModule("monitoring", StagePostCluster, "cert-manager")
+	// consumer goes before provider in module order
+	newModule("backup", "", "storage")
+	newModule("storage", "")
+
+	assert.Equal(t,
+		[]string{"monitoring", "backup", "storage", "dashboard", "ingress", "cert-manager", "cni"},
+		mm.sortModulesForDeletion(mm.allModulesNamesInOrder))
+
+	assert.Equal(t,
+		[]string{"backup", "storage", "cert-manager"},
+		mm.sortModulesForDeletion([]string{"cert-manager", "storage", "backup"}))
+
+	// cycle
+	mm.allModulesByName["storage"].Definition.Imports = []string{"backup"}
+	assert.Equal(t,
+		[]string{"storage", "backup"},
+		mm.sortModulesForDeletion([]string{"backup", "storage"}))
+}
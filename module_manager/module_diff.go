@@ -0,0 +1,76 @@
+package module_manager
+
+import (
+	"github.com/flant/antiopa/helm"
+)
+
+// Actions of helm in ModuleDiff
+const (
+	ModuleDiffInstall   = "install"
+	ModuleDiffUpgrade   = "upgrade"
+	ModuleDiffUnchanged = "unchanged"
+	// module has no chart, only hooks are run
+	ModuleDiffNoChart = "no-chart"
+)
+
+// ModuleDiff is a preview of the helm upgrade of the module: objects of the chart rendered
+// with current values are compared with the manifest of the deployed release.
+type ModuleDiff struct {
+	Module    string              `json:"module"`
+	Release   string              `json:"release,omitempty"`
+	Action    string              `json:"action"`
+	Resources []helm.ResourceDiff `json:"resources,omitempty"`
+}
+
+// DiffModule renders the module chart with current values and compares it with the release manifest.
+// Hooks are not run and nothing is changed in the cluster, so values are as after the last run
+// of beforeHelm hooks. Data of Secrets is replaced with checksums.
+func (mm *MainModuleManager) DiffModule(moduleName string) (*ModuleDiff, error) {
+	module, err := mm.GetModule(moduleName)
+	if err != nil {
+		return nil, err
+	}
+
+	res := &ModuleDiff{Module: moduleName}
+	if chartExists, _ := module.checkHelmChart(); !chartExists {
+		res.Action = ModuleDiffNoChart
+		return res, nil
+	}
+	res.Release = module.generateHelmReleaseName()
+
+	manifest, err := module.renderManifest()
+	if err != nil {
+		return nil, err
+	}
+	helmClient, err := module.HelmClient()
+	if err != nil {
+		return nil, err
+	}
+
+	releaseManifest := ""
+	releaseExists, err := helmClient.IsReleaseExists(res.Release)
+	if err != nil {
+		return nil, err
+	}
+	if releaseExists {
+		releaseManifest, err = helmClient.GetReleaseManifest(res.Release)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	res.Resources, err = helm.DiffManifests(releaseManifest, manifest)
+	if err != nil {
+		return nil, err
+	}
+
+	switch {
+	case !releaseExists:
+		res.Action = ModuleDiffInstall
+	case len(res.Resources) > 0:
+		res.Action = ModuleDiffUpgrade
+	default:
+		res.Action = ModuleDiffUnchanged
+	}
+	return res, nil
+}
@@ -0,0 +1,81 @@
+package module_manager
+
+import (
+	"strings"
+
+	"github.com/romana/rlog"
+
+	"github.com/flant/antiopa/kube_config_manager"
+	"github.com/flant/antiopa/utils"
+)
+
+// Suffix of a flag key that explicitly enables or disables the module, e.g. certManagerEnabled
+const moduleEnabledFlagSuffix = "Enabled"
+
+// Suffix of a module name of the flag key in the ConfigMap: certManagerEnabled is 'cert-manager-enabled'
+const moduleEnabledFlagConfigSuffix = "-enabled"
+
+// ModuleEnabledFlagKey returns the key of the explicit enable flag of the module
+func ModuleEnabledFlagKey(moduleName string) string {
+	return utils.ModuleNameToValuesKey(moduleName) + moduleEnabledFlagSuffix
+}
+
+// moduleNameFromEnabledFlagConfig returns a name of the module if config is an explicit enable flag,
+// e.g. `nginxEnabled: "false"` in the ConfigMap is parsed as a config of the module 'nginx-enabled'
+// without values.
+func (mm *MainModuleManager) moduleNameFromEnabledFlagConfig(config utils.ModuleConfig) (string, bool) {
+	if len(config.Values) > 0 {
+		return "", false
+	}
+	if !strings.HasSuffix(config.ModuleName, moduleEnabledFlagConfigSuffix) {
+		return "", false
+	}
+	moduleName := strings.TrimSuffix(config.ModuleName, moduleEnabledFlagConfigSuffix)
+	if _, hasModule := mm.allModulesByName[moduleName]; !hasModule {
+		return "", false
+	}
+	return moduleName, true
+}
+
+// moduleEnabledFlags returns explicit enable flags of modules. Flags are read from 'global' section
+// of values.yaml, then from 'global' section of the ConfigMap and then from top-level
+// keys of the ConfigMap, the last one wins. The enabled script still can disable flagged modules.
+// flagConfigs are names of configs that are flags and not configs of unknown modules.
+func (mm *MainModuleManager) moduleEnabledFlags(moduleConfigs kube_config_manager.ModuleConfigs, globalConfigValues utils.Values) (flags map[string]bool, flagConfigs map[string]bool) {
+	flags = make(map[string]bool)
+	flagConfigs = make(map[string]bool)
+
+	for _, values := range []utils.Values{mm.valuesStorage.GlobalStaticValues(), globalConfigValues} {
+		globalSection, ok := values[utils.GlobalValuesKey].(map[string]interface{})
+		if !ok {
+			continue
+		}
+		for moduleName := range mm.allModulesByName {
+			flagValue, hasFlag := globalSection[ModuleEnabledFlagKey(moduleName)]
+			if !hasFlag {
+				continue
+			}
+			isEnabled, ok := flagValue.(bool)
+			if !ok {
+				rlog.Warnf("MODULE_MANAGER module '%s': ignore '%s.%s', should be bool, got: %#v",
+					moduleName, utils.GlobalValuesKey, ModuleEnabledFlagKey(moduleName), flagValue)
+				continue
+			}
+			flags[moduleName] = isEnabled
+		}
+	}
+
+	for configName, config := range moduleConfigs {
+		if _, hasModule := mm.allModulesByName[configName]; hasModule {
+			continue
+		}
+		moduleName, isFlag := mm.moduleNameFromEnabledFlagConfig(config)
+		if !isFlag {
+			continue
+		}
+		flags[moduleName] = config.IsEnabled
+		flagConfigs[configName] = true
+	}
+
+	return flags, flagConfigs
+}
@@ -0,0 +1,42 @@
+package module_manager
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/flant/antiopa/kube_config_manager"
+	"github.com/flant/antiopa/utils"
+)
+
+func TestMainModuleManager_calculateEnabledModulesByConfig_EnabledFlags(t *testing.T) {
+	mm := NewMainModuleManager(&MockHelmClient{}, nil)
+	for _, name := range []string{"cert-manager", "dex", "nginx"} {
+		module := &Module{Name: name, moduleManager: mm, StaticConfig: utils.NewModuleConfig(name)}
+		module.StaticConfig.IsEnabled = name != "nginx"
+		mm.allModulesByName[name] = module
+		mm.allModulesNamesInOrder = append(mm.allModulesNamesInOrder, name)
+	}
+	mm.valuesStorage.SetGlobalStaticValues(utils.Values{
+		"global": map[string]interface{}{"nginxEnabled": true, "dexEnabled": true},
+	})
+
+	config, err := kube_config_manager.NewConfigFromConfigData(map[string]string{
+		"global":      "dexEnabled: false\n",
+		"dex":         "host: dex.example.com\n",
+		"certManager": "email: admin@example.com\n",
+		// flag at the top level wins over the module section
+		"certManagerEnabled": "false",
+		"unknownEnabled":     "true",
+	})
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	enabled, values, unknown := mm.calculateEnabledModulesByConfig(config.ModuleConfigs, config.Values)
+	assert.Equal(t, []string{"nginx"}, enabled)
+	assert.NotContains(t, values, "dex")
+	if assert.Len(t, unknown, 1) {
+		assert.Equal(t, "unknown-enabled", unknown[0].ModuleName)
+	}
+}
@@ -0,0 +1,179 @@
+package module_manager
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os/exec"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/romana/rlog"
+
+	"github.com/flant/antiopa/utils"
+)
+
+// ModuleLogLines is a number of the last log lines kept for each module
+var ModuleLogLines = 1000
+
+// Sources of module log lines
+const (
+	ModuleLogSourceModule = "module"
+	ModuleLogSourceHelm   = "helm"
+	ModuleLogSourceQueue  = "queue"
+)
+
+// ModuleLogLine is a line of the module log
+type ModuleLogLine struct {
+	Time time.Time `json:"time"`
+	// module, helm, queue or 'hook <name>' for output of hooks
+	Source  string `json:"source"`
+	Level   string `json:"level"`
+	Message string `json:"message"`
+}
+
+func (l ModuleLogLine) String() string {
+	return fmt.Sprintf("%s %-5s [%s] %s", l.Time.Format(time.RFC3339), l.Level, l.Source, l.Message)
+}
+
+// moduleLogs keeps the last ModuleLogLines lines of each module
+type moduleLogs struct {
+	m     sync.Mutex
+	lines map[string][]ModuleLogLine
+}
+
+var modulesLogs = &moduleLogs{lines: make(map[string][]ModuleLogLine)}
+
+func (l *moduleLogs) append(moduleName string, line ModuleLogLine) {
+	l.m.Lock()
+	defer l.m.Unlock()
+	lines := append(l.lines[moduleName], line)
+	if ModuleLogLines > 0 && len(lines) > ModuleLogLines {
+		lines = append([]ModuleLogLine{}, lines[len(lines)-ModuleLogLines:]...)
+	}
+	l.lines[moduleName] = lines
+}
+
+// tail returns the last n lines, all lines if n is not positive
+func (l *moduleLogs) tail(moduleName string, n int) []ModuleLogLine {
+	l.m.Lock()
+	defer l.m.Unlock()
+	lines := l.lines[moduleName]
+	if n > 0 && len(lines) > n {
+		lines = lines[len(lines)-n:]
+	}
+	return append([]ModuleLogLine{}, lines...)
+}
+
+// ModuleLogTail returns the last n lines of the module log, all kept lines if n is not positive
+func ModuleLogTail(moduleName string, n int) []ModuleLogLine {
+	return modulesLogs.tail(moduleName, n)
+}
+
+// ModuleLogger writes lines into the main log and into the log of the module
+type ModuleLogger struct {
+	Module string
+	Source string
+}
+
+// ModuleLog returns a logger of the module for the source of lines
+func ModuleLog(moduleName string, source string) *ModuleLogger {
+	return &ModuleLogger{Module: moduleName, Source: source}
+}
+
+func (l *ModuleLogger) record(level string, message string) {
+	modulesLogs.append(l.Module, ModuleLogLine{Time: time.Now(), Source: l.Source, Level: level, Message: message})
+}
+
+func (l *ModuleLogger) Debugf(format string, args ...interface{}) {
+	message := fmt.Sprintf(format, args...)
+	rlog.Debugf("MODULE '%s' %s: %s", l.Module, l.Source, message)
+	l.record("DEBUG", message)
+}
+
+func (l *ModuleLogger) Infof(format string, args ...interface{}) {
+	message := fmt.Sprintf(format, args...)
+	rlog.Infof("MODULE '%s' %s: %s", l.Module, l.Source, message)
+	l.record("INFO", message)
+}
+
+func (l *ModuleLogger) Warnf(format string, args ...interface{}) {
+	message := fmt.Sprintf(format, args...)
+	// repeated warnings of the module are sampled in the main log, the module log has all lines
+	utils.SampledWarnf(l.Module, "MODULE '%s' %s: %s", l.Module, l.Source, message)
+	l.record("WARN", message)
+}
+
+func (l *ModuleLogger) Errorf(format string, args ...interface{}) {
+	message := fmt.Sprintf(format, args...)
+	utils.SampledErrorf(l.Module, "MODULE '%s' %s: %s", l.Module, l.Source, message)
+	l.record("ERROR", message)
+}
+
+// Recordf adds a line only into the log of the module, e.g. for lines that are
+// already written into the main log in another format
+func (l *ModuleLogger) Recordf(level string, format string, args ...interface{}) {
+	l.record(level, fmt.Sprintf(format, args...))
+}
+
+// moduleLogWriter records complete lines of the command output into the module log
+type moduleLogWriter struct {
+	m      sync.Mutex
+	logger *ModuleLogger
+	level  string
+	buf    bytes.Buffer
+}
+
+func (w *moduleLogWriter) Write(p []byte) (int, error) {
+	w.m.Lock()
+	defer w.m.Unlock()
+	w.buf.Write(p)
+	for {
+		line, err := w.buf.ReadString('\n')
+		if err != nil {
+			// incomplete line is kept for the next write
+			w.buf.Reset()
+			w.buf.WriteString(line)
+			break
+		}
+		w.logger.record(w.level, strings.TrimRight(line, "\r\n"))
+	}
+	return len(p), nil
+}
+
+// flush records the last line without a newline
+func (w *moduleLogWriter) flush() {
+	w.m.Lock()
+	defer w.m.Unlock()
+	if w.buf.Len() > 0 {
+		w.logger.record(w.level, strings.TrimRight(w.buf.String(), "\r"))
+		w.buf.Reset()
+	}
+}
+
+// captureModuleLog copies stdout and stderr of the hook command into the module log.
+// Returned func should be called after the command is finished.
+func captureModuleLog(cmd *exec.Cmd, moduleName string, hookName string) func() {
+	logger := ModuleLog(moduleName, fmt.Sprintf("hook %s", hookName))
+	stdout := &moduleLogWriter{logger: logger, level: "INFO"}
+	stderr := &moduleLogWriter{logger: logger, level: "ERROR"}
+	cmd.Stdout = teeWriter(cmd.Stdout, stdout)
+	cmd.Stderr = teeWriter(cmd.Stderr, stderr)
+	return func() {
+		stdout.flush()
+		stderr.flush()
+	}
+}
+
+func teeWriter(w io.Writer, other io.Writer) io.Writer {
+	if w == nil {
+		return other
+	}
+	return io.MultiWriter(w, other)
+}
+
+// log returns a logger of the module for the source of lines
+func (m *Module) log(source string) *ModuleLogger {
+	return ModuleLog(m.Name, source)
+}
@@ -0,0 +1,54 @@
+package module_manager
+
+import (
+	"os/exec"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestModuleLogTail(t *testing.T) {
+	defer func(lines int) { ModuleLogLines = lines }(ModuleLogLines)
+	ModuleLogLines = 3
+
+	logger := ModuleLog("test-log-tail", ModuleLogSourceHelm)
+	for _, message := range []string{"one", "two", "three", "four"} {
+		logger.Infof("%s", message)
+	}
+
+	messages := func(lines []ModuleLogLine) []string {
+		res := make([]string, 0)
+		for _, line := range lines {
+			res = append(res, line.Message)
+		}
+		return res
+	}
+	assert.Equal(t, []string{"two", "three", "four"}, messages(ModuleLogTail("test-log-tail", 0)))
+	assert.Equal(t, []string{"four"}, messages(ModuleLogTail("test-log-tail", 1)))
+	assert.Len(t, ModuleLogTail("unknown", 10), 0)
+}
+
+func TestCaptureModuleLog(t *testing.T) {
+	cmd := exec.Command("sh", "-c", "echo first; printf 'second\\nthird'; echo error >&2")
+	flush := captureModuleLog(cmd, "test-capture", "hook.sh")
+	if !assert.NoError(t, cmd.Run()) {
+		return
+	}
+	flush()
+
+	lines := ModuleLogTail("test-capture", 0)
+	// stdout and stderr are copied concurrently, order is kept only inside each stream
+	stdout := make([]string, 0)
+	stderr := make([]string, 0)
+	for _, line := range lines {
+		assert.Equal(t, "hook hook.sh", line.Source)
+		if line.Level == "ERROR" {
+			stderr = append(stderr, line.Message)
+		} else {
+			stdout = append(stdout, line.Level+" "+line.Message)
+		}
+	}
+	// the line without a newline is recorded by flush
+	assert.Equal(t, []string{"INFO first", "INFO second", "INFO third"}, stdout)
+	assert.Equal(t, []string{"error"}, stderr)
+}
@@ -10,6 +10,7 @@ import (
 	"github.com/romana/rlog"
 
 	"github.com/flant/antiopa/helm"
+	"github.com/flant/antiopa/kube"
 	"github.com/flant/antiopa/kube_config_manager"
 	"github.com/flant/antiopa/utils"
 )
@@ -23,18 +24,43 @@ type ModuleManager interface {
 	GetModuleHook(name string) (*ModuleHook, error)
 	GetGlobalHooksInOrder(bindingType BindingType) []string
 	GetModuleHooksInOrder(moduleName string, bindingType BindingType) ([]string, error)
-	DeleteModule(moduleName string) error
-	RunModule(moduleName string, onStartup bool) error
-	RunGlobalHook(hookName string, binding BindingType, bindingContext []BindingContext) error
-	RunModuleHook(hookName string, binding BindingType, bindingContext []BindingContext) error
+	GetModuleHookNames(moduleName string) ([]string, error)
+	DumpHookConfigs() *HookConfigsDump
+	DeleteModule(moduleName string, taskId string) error
+	CheckModuleDisable(moduleName string) error
+	RunModule(moduleName string, onStartup bool, taskId string) error
+	RunGlobalHook(hookName string, binding BindingType, bindingContext []BindingContext, taskId string) error
+	RunModuleHook(hookName string, binding BindingType, bindingContext []BindingContext, taskId string) error
+	ForceModuleHelmUpgrade(moduleName string) error
+	RenderModule(moduleName string) (string, error)
+	DiffModule(moduleName string) (*ModuleDiff, error)
+	AdoptModuleResources(moduleName string, dryRun bool) ([]helm.AdoptedResource, error)
+	MigrateReleaseNamespace(moduleName string, fromNamespace string, dryRun bool) (*helm.ReleaseNamespaceMigration, error)
+	ExportValues() *ValuesSnapshot
+	CurrentValues(moduleName string) (utils.Values, error)
+	ImportValues(snapshot *ValuesSnapshot) error
+	SimulateEnabledModules(configData map[string]string) (*EnabledModulesSimulation, error)
+	SkippedModules() []ModuleSkip
+	FlushDynamicValues() error
+	FeatureGates() []FeatureGateStatus
+	StartConvergeCycle(modulesToRun []string)
+	PendingModulesBeforeStage(stage ModuleStage) []string
+	RunModulesGraph(modulesNames []string, run func(moduleName string) error) map[string]error
+	ValidateConfigData(configData map[string]string) error
+	HookPendingModules(hookName string) []string
 	Retry()
 }
 
 // All modules are in the right order to run/disable/purge
 type ModulesState struct {
-	EnabledModules         []string
+	EnabledModules []string
+	// Enabled modules in order to run: by stages and numeric prefixes
+	ModulesToRun           []string
 	ModulesToDisable       []string
 	ReleasedUnknownModules []string
+	// Modules enabled since previous discovery and modules with values changed since last run
+	NewlyEnabledModules      []string
+	ModulesWithChangedValues []string
 }
 
 type MainModuleManager struct {
@@ -60,21 +86,8 @@ type MainModuleManager struct {
 	modulesHooksByName      map[string]*ModuleHook
 	modulesHooksOrderByName map[string]map[BindingType][]*ModuleHook
 
-	// global static values from modules/values.yaml file
-	globalStaticValues utils.Values
-
-	// values для всех модулей, для конкретного кластера
-	kubeGlobalConfigValues utils.Values
-	// values для конкретного модуля, для конкретного кластера
-	kubeModulesConfigValues map[string]utils.Values
-
-	// Invariant: do not store patches that does not apply
-	// Give user error for patches early, after patch receive
-
-	// values для всех модулей, для конкретного инстанса antiopa-pod
-	globalDynamicValuesPatches []utils.ValuesPatch
-	// values для конкретного модуля, для конкретного инстанса antiopa-pod
-	modulesDynamicValuesPatches map[string][]utils.ValuesPatch
+	// static, kube config and dynamic values for global section and for modules
+	valuesStorage *ValuesStorage
 
 	// Внутреннее событие: изменились values модуля.
 	// Обработка -- генерация внешнего Event со всеми связанными модулями для рестарта.
@@ -83,20 +96,61 @@ type MainModuleManager struct {
 	// Обработка -- генерация внешнего Event для глобального рестарта всех модулей.
 	globalValuesChanged chan bool
 
+	// external value sources with the last values of each source
+	valueSources *valueSources
+
+	// remote clusters from clusters.yaml by name
+	remoteClusters map[string]*helm.RemoteCluster
+
+	// dynamic values are saved into Secret, nil if persistence is disabled
+	dynamicValuesPersistence *dynamicValuesPersistence
+
+	// key-value state of hooks
+	hooksState *hooksState
+
+	// versions of antiopa of the last successful runs of modules
+	moduleVersions *moduleVersions
+
+	// results of module runs in converge cycles for stage barriers
+	runOutcomes moduleRunOutcomes
+
 	helm              helm.HelmClient
 	kubeConfigManager kube_config_manager.KubeConfigManager
+	// clients for tillers of module groups, nil if only the default tiller is used
+	helmClients *helm.ClientsPool
+	// manifests rendered by helm template
+	renderCache *helm.RenderCache
+	// paths to policy executables that check module manifests before helm upgrade
+	policies []string
+	// global windows for helm upgrades from maintenance.yaml
+	maintenanceWindows MaintenanceWindows
+	// feature gates from feature-gates.yaml
+	featureGates []FeatureGate
+	// OS and architecture of nodes for modules with platforms in module.yaml
+	nodePlatforms nodePlatforms
+	// capacity of nodes for modules with requirements in module.yaml
+	clusterCapacity clusterCapacity
+	// modules enabled by config but disabled by platforms or requirements at the last discovery
+	moduleSkips moduleSkips
 
 	// Сохранение новых конфигов из kube, на случай ошибки обработки
 	moduleConfigsUpdateBeforeAmbiguos kube_config_manager.ModuleConfigs
 	retryOnAmbigous                   chan bool
+
+	// background deletion of old FAILED revisions of releases
+	failedRevisionsCleanup *failedRevisionsCleanup
 }
 
 var (
 	EventCh    chan Event
 	WorkingDir string
 	TempDir    string
+	// max number of parallel hooks of one module binding
+	HooksParallelism = DefaultHooksParallelism
 )
 
+const DefaultHooksParallelism = 4
+
 // Типы привязок для хуков — то, от чего могут сработать хуки
 type BindingType string
 
@@ -109,6 +163,8 @@ const (
 	Schedule        BindingType = "SCHEDULE"
 	OnStartup       BindingType = "ON_STARTUP"
 	KubeEvents      BindingType = "KUBE_EVENTS"
+	HttpPoll        BindingType = "HTTP_POLL"
+	OnShutdown      BindingType = "ON_SHUTDOWN"
 )
 
 var ContextBindingType = map[BindingType]string{
@@ -120,6 +176,8 @@ var ContextBindingType = map[BindingType]string{
 	Schedule:        "schedule",
 	OnStartup:       "onStartup",
 	KubeEvents:      "onKubernetesEvent",
+	HttpPoll:        "httpPoll",
+	OnShutdown:      "onShutdown",
 }
 
 // Additional info from schedule and kube events
@@ -129,6 +187,13 @@ type BindingContext struct {
 	ResourceNamespace string `json:"resourceNamespace,omitempty"`
 	ResourceKind      string `json:"resourceKind,omitempty"`
 	ResourceName      string `json:"resourceName,omitempty"`
+	// object of onKubernetesEvent binding that triggered the hook run
+	Object map[string]interface{} `json:"object,omitempty"`
+	// results of hook nodeExec command on nodes
+	NodeExecResults []kube.NodeExecResult `json:"nodeExecResults,omitempty"`
+	// name of http poller and its new response
+	HttpPoller   string      `json:"httpPoller,omitempty"`
+	HttpResponse interface{} `json:"httpResponse,omitempty"`
 }
 
 // Типы событий, отправляемые в Main — либо изменились какие-то модули и нужно
@@ -163,14 +228,35 @@ type Event struct {
 	Type           EventType
 }
 
-func Init(workingDir string, tempDir string, helmClient helm.HelmClient) (ModuleManager, error) {
+func Init(workingDir string, tempDir string, helmClients *helm.ClientsPool) (ModuleManager, error) {
 	rlog.Info("Initializing module manager ...")
 
 	TempDir = tempDir
 	WorkingDir = workingDir
 	EventCh = make(chan Event, 1)
 
-	mm := NewMainModuleManager(helmClient, nil)
+	mm := NewMainModuleManager(helmClients.Default(), nil)
+	mm.helmClients = helmClients
+
+	if err := checkHookValuesFormat(HooksValuesFormat); err != nil {
+		return nil, fmt.Errorf("hooks values format: %s", err)
+	}
+
+	if err := checkChartVerification(); err != nil {
+		return nil, fmt.Errorf("chart verification: %s", err)
+	}
+
+	if err := checkHookStaticChecks(); err != nil {
+		return nil, fmt.Errorf("hook static checks: %s", err)
+	}
+
+	if err := checkModuleDisableSafety(); err != nil {
+		return nil, fmt.Errorf("module disable safety: %s", err)
+	}
+
+	if err := checkValuesBackfillMode(ValuesBackfillMode); err != nil {
+		return nil, fmt.Errorf("values backfill: %s", err)
+	}
 
 	if err := mm.initGlobalHooks(); err != nil {
 		return nil, err
@@ -180,6 +266,33 @@ func Init(workingDir string, tempDir string, helmClient helm.HelmClient) (Module
 		return nil, err
 	}
 
+	if err := mm.validateGlobalHooksWaitForModules(); err != nil {
+		return nil, err
+	}
+
+	// rights of removed or renamed hooks should not be kept
+	if kube.RestConfig != nil {
+		kube.CleanupHookServiceAccounts(mm.hooksServiceAccounts())
+	}
+
+	mm.initRemoteClusters(helm.InitRemote)
+
+	if err := mm.initPolicies(); err != nil {
+		return nil, err
+	}
+
+	if err := mm.initGlobalMaintenanceWindows(); err != nil {
+		return nil, err
+	}
+
+	if err := mm.initFeatureGates(); err != nil {
+		return nil, err
+	}
+
+	if err := mm.initValueSources(); err != nil {
+		return nil, err
+	}
+
 	kcm, err := kube_config_manager.Init()
 	if err != nil {
 		return nil, err
@@ -187,10 +300,15 @@ func Init(workingDir string, tempDir string, helmClient helm.HelmClient) (Module
 	mm.kubeConfigManager = kcm
 	kubeConfig := mm.kubeConfigManager.InitialConfig()
 
-	mm.kubeGlobalConfigValues = kubeConfig.Values
+	mm.valuesStorage.SetKubeGlobalConfigValues(kubeConfig.Values)
 
 	var unknown []utils.ModuleConfig
-	mm.enabledModulesByConfig, mm.kubeModulesConfigValues, unknown = mm.calculateEnabledModulesByConfig(kubeConfig.ModuleConfigs)
+	var kubeModulesConfigValues map[string]utils.Values
+	mm.enabledModulesByConfig, kubeModulesConfigValues, unknown = mm.calculateEnabledModulesByConfig(kubeConfig.ModuleConfigs, kubeConfig.Values)
+	mm.valuesStorage.SetKubeModulesConfigValues(kubeModulesConfigValues)
+	mm.valuesStorage.SetImageTags(kubeConfig.ImageTags)
+	mm.valuesStorage.SetGroupsValues(kubeConfig.GroupsValues)
+	mm.backfillModulesConfigValues()
 
 	for _, config := range unknown {
 		rlog.Warnf("MODULE_MANAGER Init: ignore kube config for absent module: \n%s",
@@ -198,44 +316,132 @@ func Init(workingDir string, tempDir string, helmClient helm.HelmClient) (Module
 		)
 	}
 
+	// dynamic values are checked against values from ConfigMap
+	if err := mm.initDynamicValuesPersistence(); err != nil {
+		return nil, err
+	}
+
+	if err := mm.initHooksStatePersistence(); err != nil {
+		return nil, err
+	}
+
+	if err := mm.initModuleVersionsPersistence(); err != nil {
+		return nil, err
+	}
+
+	mm.initFailedRevisionsCleanup()
+
 	return mm, nil
 }
 
 func NewMainModuleManager(helmClient helm.HelmClient, kubeConfigManager kube_config_manager.KubeConfigManager) *MainModuleManager {
 	return &MainModuleManager{
-		allModulesByName:            make(map[string]*Module),
-		allModulesNamesInOrder:      make([]string, 0),
-		enabledModulesByConfig:      make([]string, 0),
-		enabledModulesInOrder:       make([]string, 0),
-		globalHooksByName:           make(map[string]*GlobalHook),
-		globalHooksOrder:            make(map[BindingType][]*GlobalHook),
-		modulesHooksByName:          make(map[string]*ModuleHook),
-		modulesHooksOrderByName:     make(map[string]map[BindingType][]*ModuleHook),
-		globalStaticValues:          make(utils.Values),
-		kubeGlobalConfigValues:      make(utils.Values),
-		kubeModulesConfigValues:     make(map[string]utils.Values),
-		globalDynamicValuesPatches:  make([]utils.ValuesPatch, 0),
-		modulesDynamicValuesPatches: make(map[string][]utils.ValuesPatch),
+		allModulesByName:        make(map[string]*Module),
+		allModulesNamesInOrder:  make([]string, 0),
+		enabledModulesByConfig:  make([]string, 0),
+		enabledModulesInOrder:   make([]string, 0),
+		globalHooksByName:       make(map[string]*GlobalHook),
+		globalHooksOrder:        make(map[BindingType][]*GlobalHook),
+		modulesHooksByName:      make(map[string]*ModuleHook),
+		modulesHooksOrderByName: make(map[string]map[BindingType][]*ModuleHook),
+		valuesStorage:           NewValuesStorage(),
+		hooksState:              newHooksState(),
+		moduleVersions:          newModuleVersions(),
 
 		moduleValuesChanged: make(chan string, 1),
 		globalValuesChanged: make(chan bool, 1),
 
 		helm:              helmClient,
 		kubeConfigManager: kubeConfigManager,
+		renderCache:       helm.NewRenderCache(helm.DefaultRenderCacheSize),
 
 		moduleConfigsUpdateBeforeAmbiguos: make(kube_config_manager.ModuleConfigs),
 		retryOnAmbigous:                   make(chan bool, 1),
 	}
 }
 
+// helmClient returns a client for the tiller namespace, default client for empty namespace
+func (mm *MainModuleManager) helmClient(tillerNamespace string) (helm.HelmClient, error) {
+	if mm.helmClients != nil {
+		return mm.helmClients.Get(tillerNamespace)
+	}
+	if tillerNamespace != "" && (mm.helm == nil || tillerNamespace != mm.helm.TillerNamespace()) {
+		return nil, fmt.Errorf("tiller namespace '%s' is not supported", tillerNamespace)
+	}
+	return mm.helm, nil
+}
+
+// listReleasesNames returns names of releases from all tillers. Errors of tillers in the cluster
+// of antiopa are returned, unreachable remote clusters are skipped: their releases are not
+// deleted or purged until the cluster is reachable again.
+func (mm *MainModuleManager) listReleasesNames() ([]string, error) {
+	helmClients := []helm.HelmClient{mm.helm}
+	remoteClients := make([]helm.HelmClient, 0)
+	if mm.helmClients != nil {
+		helmClients = mm.helmClients.Clients()
+		remoteClients = mm.helmClients.ClusterClients()
+	}
+
+	res := make([]string, 0)
+	seen := make(map[string]bool)
+	add := func(releases []string) {
+		for _, release := range releases {
+			if !seen[release] {
+				seen[release] = true
+				res = append(res, release)
+			}
+		}
+	}
+
+	for _, helmClient := range helmClients {
+		releases, err := helmClient.ListReleasesNames(nil)
+		if err != nil {
+			return nil, err
+		}
+		add(releases)
+	}
+	for _, helmClient := range remoteClients {
+		releases, err := helmClient.ListReleasesNames(nil)
+		if err != nil {
+			rlog.Warnf("DISCOVER releases of remote tiller '%s' are skipped: %s", helmClient.TillerNamespace(), err)
+			continue
+		}
+		add(releases)
+	}
+
+	return res, nil
+}
+
 // determineEnableStateWithScript runs enable script for each module that is enabled by config.
 // Enable script receives a list of previously enabled modules.
 func (mm *MainModuleManager) determineEnableStateWithScript(enabledByConfig []string) ([]string, error) {
 	enabledModules := make([]string, 0)
 	//rlog.Infof("Run enable scripts for modules list: %s", enabledByConfig)
 
+	nodePlatforms := mm.nodePlatforms.get()
+	capacity := mm.clusterCapacity.get()
+	skips := make(map[string]ModuleSkip)
+	defer mm.moduleSkips.set(skips)
 	for _, name := range utils.SortByReference(enabledByConfig, mm.allModulesNamesInOrder) {
 		module := mm.allModulesByName[name]
+		if !module.SupportsNodePlatforms(nodePlatforms) {
+			rlog.Infof("DISCOVER module '%s' is disabled: no nodes with platforms %v", name, module.Definition.Platforms)
+			skips[name] = ModuleSkip{
+				Module:    name,
+				Condition: ModuleSkipUnsupportedPlatforms,
+				Message:   fmt.Sprintf("no nodes with platforms %v", module.Definition.Platforms),
+			}
+			continue
+		}
+		if unmet := module.UnmetClusterRequirements(capacity); unmet != "" {
+			rlog.Infof("DISCOVER module '%s' is disabled: cluster is too small: %s", name, unmet)
+			skips[name] = ModuleSkip{
+				Module:    name,
+				Condition: ModuleSkipInsufficientClusterSize,
+				Message:   unmet,
+			}
+			continue
+		}
 		moduleIsEnabled, err := module.checkIsEnabledByScript(enabledModules)
 		if err != nil {
 			return nil, err
@@ -259,8 +465,8 @@ type kubeUpdate struct {
 
 func (mm *MainModuleManager) applyKubeUpdate(kubeUpdate *kubeUpdate) error {
 	rlog.Debugf("Apply kubeupdate %+v", kubeUpdate)
-	mm.kubeGlobalConfigValues = kubeUpdate.KubeGlobalConfigValues
-	mm.kubeModulesConfigValues = kubeUpdate.KubeModulesConfigValues
+	mm.valuesStorage.SetKubeGlobalConfigValues(kubeUpdate.KubeGlobalConfigValues)
+	mm.valuesStorage.SetKubeModulesConfigValues(kubeUpdate.KubeModulesConfigValues)
 	mm.enabledModulesByConfig = kubeUpdate.EnabledModulesByConfig
 
 	for _, event := range kubeUpdate.Events {
@@ -279,7 +485,7 @@ func (mm *MainModuleManager) handleNewKubeConfig(newConfig kube_config_manager.C
 	}
 
 	var unknown []utils.ModuleConfig
-	res.EnabledModulesByConfig, res.KubeModulesConfigValues, unknown = mm.calculateEnabledModulesByConfig(newConfig.ModuleConfigs)
+	res.EnabledModulesByConfig, res.KubeModulesConfigValues, unknown = mm.calculateEnabledModulesByConfig(newConfig.ModuleConfigs, newConfig.Values)
 
 	for _, moduleConfig := range unknown {
 		rlog.Warnf("MODULE_MANAGER new kube config: Ignore kube config for absent module: \n%s",
@@ -295,14 +501,14 @@ func (mm *MainModuleManager) handleNewKubeModuleConfigs(moduleConfigs kube_confi
 
 	res := &kubeUpdate{
 		Events:                 make([]Event, 0),
-		KubeGlobalConfigValues: mm.kubeGlobalConfigValues,
+		KubeGlobalConfigValues: mm.valuesStorage.KubeGlobalConfigValues(),
 	}
 
-	// NOTE: values for non changed modules were copied from mm.kubeModulesConfigValues[moduleName].
+	// NOTE: values for non changed modules were copied from mm.valuesStorage.KubeModuleConfigValues(moduleName).
 	// Now calculateEnabledModulesByConfig got values for modules from moduleConfigs — as they are in ConfigMap now.
 	// TODO this should not be a problem because of a checksum matching in kube_config_manager
 	var unknown []utils.ModuleConfig
-	res.EnabledModulesByConfig, res.KubeModulesConfigValues, unknown = mm.calculateEnabledModulesByConfig(moduleConfigs)
+	res.EnabledModulesByConfig, res.KubeModulesConfigValues, unknown = mm.calculateEnabledModulesByConfig(moduleConfigs, res.KubeGlobalConfigValues)
 
 	for _, moduleConfig := range unknown {
 		rlog.Warnf("HANDLE_CM_UPD ignore module section for unknown module '%s':\n%s",
@@ -315,7 +521,7 @@ func (mm *MainModuleManager) handleNewKubeModuleConfigs(moduleConfigs kube_confi
 	for moduleName, module := range mm.allModulesByName {
 		_, hasKubeConfig := moduleConfigs[moduleName]
 		if !hasKubeConfig && module.StaticConfig.IsEnabled {
-			if _, hasValues := mm.kubeModulesConfigValues[moduleName]; hasValues {
+			if mm.valuesStorage.HasKubeModuleConfigValues(moduleName) {
 				updateAfterRemoval[moduleName] = true
 			}
 		}
@@ -384,31 +590,39 @@ func (mm *MainModuleManager) handleNewKubeModuleConfigs(moduleConfigs kube_confi
 //
 // Module is enabled by config if module section in ConfigMap is a map or an array
 // or ConfigMap has no module section and module has a map or an array in values.yaml
-func (mm *MainModuleManager) calculateEnabledModulesByConfig(moduleConfigs kube_config_manager.ModuleConfigs) (enabled []string, values map[string]utils.Values, unknown []utils.ModuleConfig) {
+func (mm *MainModuleManager) calculateEnabledModulesByConfig(moduleConfigs kube_config_manager.ModuleConfigs, globalConfigValues utils.Values) (enabled []string, values map[string]utils.Values, unknown []utils.ModuleConfig) {
 	values = make(map[string]utils.Values)
+	flags, flagConfigs := mm.moduleEnabledFlags(moduleConfigs, globalConfigValues)
 
 	for moduleName, module := range mm.allModulesByName {
+		isEnabled := module.StaticConfig.IsEnabled
 		kubeConfig, hasKubeConfig := moduleConfigs[moduleName]
 		if hasKubeConfig {
-			if kubeConfig.IsEnabled {
-				enabled = append(enabled, moduleName)
-				values[moduleName] = kubeConfig.Values
-			}
+			isEnabled = kubeConfig.IsEnabled
 			rlog.Debugf("Module %s: static enabled %v, kubeConfig: enabled %v, updated %v",
 				module.Name,
 				module.StaticConfig.IsEnabled,
 				kubeConfig.IsEnabled,
 				kubeConfig.IsUpdated)
 		} else {
-			if module.StaticConfig.IsEnabled {
-				enabled = append(enabled, moduleName)
-			}
 			rlog.Debugf("Module %s: static enabled %v, no kubeConfig", module.Name, module.StaticConfig.IsEnabled)
 		}
+		// explicit flag overrides the module section
+		if flag, hasFlag := flags[moduleName]; hasFlag {
+			rlog.Debugf("Module %s: %s is %v", module.Name, ModuleEnabledFlagKey(moduleName), flag)
+			isEnabled = flag
+		}
+
+		if isEnabled {
+			enabled = append(enabled, moduleName)
+			if hasKubeConfig {
+				values[moduleName] = kubeConfig.Values
+			}
+		}
 	}
 
-	for _, kubeConfig := range moduleConfigs {
-		if _, hasKey := mm.allModulesByName[kubeConfig.ModuleName]; !hasKey {
+	for configName, kubeConfig := range moduleConfigs {
+		if _, hasKey := mm.allModulesByName[kubeConfig.ModuleName]; !hasKey && !flagConfigs[configName] {
 			unknown = append(unknown, kubeConfig)
 		}
 	}
@@ -421,6 +635,9 @@ func (mm *MainModuleManager) calculateEnabledModulesByConfig(moduleConfigs kube_
 // Module manager loop
 func (mm *MainModuleManager) Run() {
 	go mm.kubeConfigManager.Run()
+	mm.runValueSources()
+	mm.runDynamicValuesPersistence()
+	mm.runFailedRevisionsCleanup()
 
 	for {
 		select {
@@ -477,6 +694,24 @@ func (mm *MainModuleManager) Run() {
 				}
 			}
 
+		case imageTags := <-kube_config_manager.ImageTagsUpdated:
+			changes := make([]ModuleChange, 0)
+			for _, moduleName := range mm.handleImageTagsUpdate(imageTags) {
+				changes = append(changes, ModuleChange{Name: moduleName, ChangeType: Changed})
+			}
+			if len(changes) > 0 {
+				EventCh <- Event{Type: ModulesChanged, ModulesChanges: changes}
+			}
+
+		case groupsValues := <-kube_config_manager.GroupsValuesUpdated:
+			changes := make([]ModuleChange, 0)
+			for _, moduleName := range mm.handleGroupsValuesUpdate(groupsValues) {
+				changes = append(changes, ModuleChange{Name: moduleName, ChangeType: Changed})
+			}
+			if len(changes) > 0 {
+				EventCh <- Event{Type: ModulesChanged, ModulesChanges: changes}
+			}
+
 		case <-mm.retryOnAmbigous:
 			if len(mm.moduleConfigsUpdateBeforeAmbiguos) != 0 {
 				rlog.Infof("MODULE_MANAGER_RUN Retry saved moduleConfigs: %v", mm.moduleConfigsUpdateBeforeAmbiguos)
@@ -501,7 +736,7 @@ func (mm *MainModuleManager) discoverModulesState() (*ModulesState, error) {
 	// ReleasedUnknownModules — modules that should be purged
 	state := &ModulesState{}
 
-	releasedModules, err := mm.helm.ListReleasesNames(nil)
+	releasedModules, err := mm.listReleasesNames()
 	if err != nil {
 		return nil, err
 	}
@@ -516,6 +751,8 @@ func (mm *MainModuleManager) discoverModulesState() (*ModulesState, error) {
 	// ignore unknown released modules for next operations
 	releasedModules = utils.ListIntersection(releasedModules, mm.allModulesNamesInOrder)
 
+	mm.refreshNodePlatforms()
+
 	// modules finally enabled with enable script
 	// no need to refresh mm.enabledModulesByConfig because
 	// it is updated before in Init or applyKubeUpdate
@@ -533,18 +770,38 @@ func (mm *MainModuleManager) discoverModulesState() (*ModulesState, error) {
 	}
 
 	state.EnabledModules = enabledModules
+	state.NewlyEnabledModules = utils.ListSubtract(enabledModules, mm.enabledModulesInOrder)
+
+	// Modules are run in order of stages and numeric prefixes, modules depend on it.
+	// Modules with unchanged values skip helm upgrade by release checksums.
+	state.ModulesToRun = make([]string, 0, len(enabledModules))
+	for _, stage := range ModuleStages {
+		for _, moduleName := range enabledModules {
+			module := mm.allModulesByName[moduleName]
+			if module.Stage() != stage {
+				continue
+			}
+			state.ModulesToRun = append(state.ModulesToRun, moduleName)
+			if module.hasChangedValues() {
+				state.ModulesWithChangedValues = append(state.ModulesWithChangedValues, moduleName)
+			}
+		}
+	}
+	if len(state.ModulesWithChangedValues) > 0 {
+		rlog.Infof("DISCOVER modules with changed values: %s", state.ModulesWithChangedValues)
+	}
 
 	// Calculate modules that has helm release and are disabled for now.
-	// Sort them in reverse order for proper deletion.
+	// Sort them in reverse stage and dependency order for proper deletion.
 	state.ModulesToDisable = utils.ListSubtract(mm.allModulesNamesInOrder, enabledModules)
 	state.ModulesToDisable = utils.ListIntersection(state.ModulesToDisable, releasedModules)
-	state.ModulesToDisable = utils.SortReverseByReference(state.ModulesToDisable, mm.allModulesNamesInOrder)
+	state.ModulesToDisable = mm.sortModulesForDeletion(state.ModulesToDisable)
 
 	return state, nil
 }
 
 // DiscoverModulesState handles DiscoverModulesState event
-// This method needs updated mm.enabledModulesByConfig and mm.kubeModulesConfigValues
+// This method needs updated mm.enabledModulesByConfig and kube modules config values in mm.valuesStorage
 func (mm *MainModuleManager) DiscoverModulesState() (state *ModulesState, err error) {
 	rlog.Debugf("DISCOVER state:\n"+
 		"    mm.enabledModulesByConfig: %v\n"+
@@ -646,32 +903,180 @@ func (mm *MainModuleManager) GetModuleHooksInOrder(moduleName string, bindingTyp
 	return moduleHooksNames, nil
 }
 
-func (mm *MainModuleManager) DeleteModule(moduleName string) error {
+// GetModuleHookNames returns sorted names of all registered hooks of the module
+func (mm *MainModuleManager) GetModuleHookNames(moduleName string) ([]string, error) {
+	if _, err := mm.GetModule(moduleName); err != nil {
+		return nil, err
+	}
+
+	moduleHooksNames := make([]string, 0)
+	for _, moduleHook := range mm.modulesHooksByName {
+		if moduleHook.Module != nil && moduleHook.Module.Name == moduleName {
+			moduleHooksNames = append(moduleHooksNames, moduleHook.Name)
+		}
+	}
+	sort.Strings(moduleHooksNames)
+
+	return moduleHooksNames, nil
+}
+
+// HookConfigDump describes registered hook: its bindings with order and a config
+type HookConfigDump struct {
+	Name           string                  `json:"name"`
+	Path           string                  `json:"path"`
+	Bindings       []BindingType           `json:"bindings"`
+	OrderByBinding map[BindingType]float64 `json:"orderByBinding"`
+	Config         interface{}             `json:"config"`
+}
+
+type HookConfigsDump struct {
+	GlobalHooks []HookConfigDump            `json:"globalHooks"`
+	ModuleHooks map[string][]HookConfigDump `json:"moduleHooks"`
+}
+
+func newHookConfigDump(hook *Hook, config interface{}) HookConfigDump {
+	return HookConfigDump{
+		Name:           hook.Name,
+		Path:           hook.Path,
+		Bindings:       hook.Bindings,
+		OrderByBinding: hook.OrderByBinding,
+		Config:         config,
+	}
+}
+
+// DumpHookConfigs returns all registered global hooks and module hooks grouped by module.
+// Hooks are sorted by name.
+func (mm *MainModuleManager) DumpHookConfigs() *HookConfigsDump {
+	res := &HookConfigsDump{
+		GlobalHooks: make([]HookConfigDump, 0),
+		ModuleHooks: make(map[string][]HookConfigDump),
+	}
+
+	globalHooksNames := make([]string, 0)
+	for name := range mm.globalHooksByName {
+		globalHooksNames = append(globalHooksNames, name)
+	}
+	sort.Strings(globalHooksNames)
+	for _, name := range globalHooksNames {
+		globalHook := mm.globalHooksByName[name]
+		res.GlobalHooks = append(res.GlobalHooks, newHookConfigDump(globalHook.Hook, globalHook.Config))
+	}
+
+	for _, moduleName := range mm.allModulesNamesInOrder {
+		moduleHooksNames, _ := mm.GetModuleHookNames(moduleName)
+		if len(moduleHooksNames) == 0 {
+			continue
+		}
+		moduleHooks := make([]HookConfigDump, 0)
+		for _, name := range moduleHooksNames {
+			moduleHook := mm.modulesHooksByName[name]
+			moduleHooks = append(moduleHooks, newHookConfigDump(moduleHook.Hook, moduleHook.Config))
+		}
+		res.ModuleHooks[moduleName] = moduleHooks
+	}
+
+	return res
+}
+
+func (mm *MainModuleManager) DeleteModule(moduleName string, taskId string) error {
 	module, err := mm.GetModule(moduleName)
 	if err != nil {
 		return err
 	}
 
-	if err := module.delete(); err != nil {
+	if err := module.delete(taskId); err != nil {
 		return err
 	}
 
 	return nil
 }
 
-func (mm *MainModuleManager) RunModule(moduleName string, onStartup bool) error { // запускает before-helm + helm + after-helm
+func (mm *MainModuleManager) RunModule(moduleName string, onStartup bool, taskId string) error { // запускает before-helm + helm + after-helm
 	module, err := mm.GetModule(moduleName)
 	if err != nil {
 		return err
 	}
 
-	if err := module.run(onStartup); err != nil {
+	artifacts := module.startRunArtifacts()
+	err = module.run(onStartup, taskId)
+	module.stopRunArtifacts()
+	if IsHelmUpgradeWaiting(err) {
+		// module is not failed, the run is retried later
+		return err
+	}
+	mm.runOutcomes.record(moduleName, err == nil)
+	if err != nil {
+		mm.cleanupFailedRevisionsInBackground(module)
+		if artifacts != nil {
+			if dir, saveErr := module.saveFailureArtifacts(artifacts, taskId, err); saveErr != nil {
+				rlog.Errorf("MODULE_RUN '%s': cannot save failure artifacts: %s", moduleName, saveErr)
+			} else {
+				rlog.Infof("MODULE_RUN '%s': failure artifacts are saved into '%s'", moduleName, dir)
+			}
+		}
+		return err
+	}
+
+	return nil
+}
+
+// ForceModuleHelmUpgrade marks module to run helm upgrade on next run
+// even if module checksum is not changed. It is used to restore release
+// resources that were changed or deleted externally.
+func (mm *MainModuleManager) ForceModuleHelmUpgrade(moduleName string) error {
+	module, err := mm.GetModule(moduleName)
+	if err != nil {
 		return err
 	}
 
+	module.setForceHelmUpgrade(true)
+
 	return nil
 }
 
+// AdoptModuleResources adds existing objects from the rendered manifest of the module into its release.
+// Module should be run with forced helm upgrade after adoption to bring objects to the chart state.
+func (mm *MainModuleManager) AdoptModuleResources(moduleName string, dryRun bool) ([]helm.AdoptedResource, error) {
+	module, err := mm.GetModule(moduleName)
+	if err != nil {
+		return nil, err
+	}
+	if chartExists, _ := module.checkHelmChart(); !chartExists {
+		return nil, fmt.Errorf("module '%s' has no chart", moduleName)
+	}
+
+	manifest, err := module.renderManifest()
+	if err != nil {
+		return nil, err
+	}
+	chart, err := readChartMetadata(module.Path)
+	if err != nil {
+		return nil, err
+	}
+	helmClient, err := module.HelmClient()
+	if err != nil {
+		return nil, err
+	}
+
+	return helmClient.AdoptResources(helm.AdoptResourcesOptions{
+		Release:      module.generateHelmReleaseName(),
+		ChartName:    chart.Name,
+		ChartVersion: chart.Version,
+		Manifest:     manifest,
+		DryRun:       dryRun,
+	})
+}
+
+// RenderModule returns a manifest of the module release rendered with current values
+func (mm *MainModuleManager) RenderModule(moduleName string) (string, error) {
+	module, err := mm.GetModule(moduleName)
+	if err != nil {
+		return "", err
+	}
+
+	return module.renderManifest()
+}
+
 func valuesChecksum(valuesArr ...utils.Values) (string, error) {
 	valuesJson, err := json.Marshal(utils.MergeValues(valuesArr...))
 	if err != nil {
@@ -680,7 +1085,7 @@ func valuesChecksum(valuesArr ...utils.Values) (string, error) {
 	return utils.CalculateChecksum(string(valuesJson)), nil
 }
 
-func (mm *MainModuleManager) RunGlobalHook(hookName string, binding BindingType, bindingContext []BindingContext) error {
+func (mm *MainModuleManager) RunGlobalHook(hookName string, binding BindingType, bindingContext []BindingContext, taskId string) error {
 	globalHook, err := mm.GetGlobalHook(hookName)
 	if err != nil {
 		return err
@@ -691,7 +1096,7 @@ func (mm *MainModuleManager) RunGlobalHook(hookName string, binding BindingType,
 		return err
 	}
 
-	if err := globalHook.run(binding, bindingContext); err != nil {
+	if err := globalHook.run(binding, bindingContext, taskId); err != nil {
 		return err
 	}
 
@@ -702,7 +1107,7 @@ func (mm *MainModuleManager) RunGlobalHook(hookName string, binding BindingType,
 
 	if newValuesChecksum != oldValuesChecksum {
 		switch binding {
-		case Schedule, KubeEvents:
+		case Schedule, KubeEvents, HttpPoll:
 			mm.globalValuesChanged <- true
 		}
 	}
@@ -710,7 +1115,7 @@ func (mm *MainModuleManager) RunGlobalHook(hookName string, binding BindingType,
 	return nil
 }
 
-func (mm *MainModuleManager) RunModuleHook(hookName string, binding BindingType, bindingContext []BindingContext) error {
+func (mm *MainModuleManager) RunModuleHook(hookName string, binding BindingType, bindingContext []BindingContext, taskId string) error {
 	moduleHook, err := mm.GetModuleHook(hookName)
 	if err != nil {
 		return err
@@ -721,7 +1126,7 @@ func (mm *MainModuleManager) RunModuleHook(hookName string, binding BindingType,
 		return err
 	}
 
-	if err := moduleHook.run(binding, bindingContext); err != nil {
+	if err := moduleHook.run(binding, bindingContext, taskId); err != nil {
 		return err
 	}
 
@@ -732,10 +1137,26 @@ func (mm *MainModuleManager) RunModuleHook(hookName string, binding BindingType,
 
 	if newValuesChecksum != oldValuesChecksum {
 		switch binding {
-		case Schedule, KubeEvents:
+		case Schedule, KubeEvents, HttpPoll:
 			mm.moduleValuesChanged <- moduleHook.Module.Name
 		}
 	}
 
 	return nil
 }
+
+// ModulesReleasesNames loads modules from working dir and returns names of their helm releases
+func ModulesReleasesNames(workingDir string) ([]string, error) {
+	WorkingDir = workingDir
+
+	mm := NewMainModuleManager(nil, nil)
+	if err := mm.initModulesIndex(); err != nil {
+		return nil, err
+	}
+
+	releases := make([]string, 0, len(mm.allModulesNamesInOrder))
+	for _, moduleName := range mm.allModulesNamesInOrder {
+		releases = append(releases, mm.allModulesByName[moduleName].generateHelmReleaseName())
+	}
+	return releases, nil
+}
@@ -60,8 +60,8 @@ func TestMainModuleManager_globalStaticValues(t *testing.T) {
 		},
 	}
 
-	if !reflect.DeepEqual(mm.globalStaticValues, expectedValues) {
-		t.Errorf("\n[EXPECTED]: %#v\n[GOT]: %#v", expectedValues, mm.globalStaticValues)
+	if !reflect.DeepEqual(mm.valuesStorage.GlobalStaticValues(), expectedValues) {
+		t.Errorf("\n[EXPECTED]: %#v\n[GOT]: %#v", expectedValues, mm.valuesStorage.GlobalStaticValues())
 	}
 }
 
@@ -94,8 +94,9 @@ func TestMainModuleManager_modulesStaticValues(t *testing.T) {
 
 	for _, expectation := range expectations {
 		t.Run(expectation.moduleName, func(t *testing.T) {
-			if !reflect.DeepEqual(mm.modulesStaticValues[expectation.moduleName], expectation.values) {
-				t.Errorf("\n[EXPECTED]: %#v\n[GOT]: %#v", expectation.values, mm.modulesStaticValues[expectation.moduleName])
+			staticValues := mm.allModulesByName[expectation.moduleName].StaticConfig.Values
+			if !reflect.DeepEqual(staticValues, expectation.values) {
+				t.Errorf("\n[EXPECTED]: %#v\n[GOT]: %#v", expectation.values, staticValues)
 			}
 		})
 	}
@@ -111,6 +112,7 @@ func TestMainModuleManager_GetModule2(t *testing.T) {
 			Name:          "module",
 			Path:          filepath.Join(WorkingDir, "modules/000-module"),
 			DirectoryName: "000-module",
+			Definition:    NewModuleDefinition(),
 			moduleManager: mm,
 		},
 	}
@@ -157,14 +159,14 @@ func TestMainModuleManager_GetModuleHook2(t *testing.T) {
 
 	createModuleHook := func(moduleName, name string, bindings []BindingType, orderByBindings map[BindingType]interface{}, schedule []ScheduleConfig, onKubernetesEvent []OnKubernetesEventConfig) *ModuleHook {
 		config := &ModuleHookConfig{
-			HookConfig{
-				orderByBindings[OnStartup],
-				schedule,
-				onKubernetesEvent,
-			},
-			orderByBindings[BeforeHelm],
-			orderByBindings[AfterHelm],
-			orderByBindings[AfterDeleteHelm],
+			HookConfig: HookConfig{
+				OnStartup:         orderByBindings[OnStartup],
+				Schedule:          schedule,
+				OnKubernetesEvent: onKubernetesEvent,
+			},
+			BeforeHelm:      orderByBindings[BeforeHelm],
+			AfterHelm:       orderByBindings[AfterHelm],
+			AfterDeleteHelm: orderByBindings[AfterDeleteHelm],
 		}
 
 		moduleHook := mm.newModuleHook(name, filepath.Join(WorkingDir, "modules", name), config)
@@ -379,13 +381,25 @@ func (h *MockHelmClient) IsReleaseExists(_ string) (bool, error) {
 	return true, nil
 }
 
-func (h *MockHelmClient) GetReleaseValues(_ string) (utils.Values, error) {
+func (h *MockHelmClient) GetReleaseValues(_ string, _ bool) (utils.Values, error) {
 	return make(utils.Values), nil
 }
 
-func (h *MockHelmClient) UpgradeRelease(_, _ string, _ []string, _ []string, _ string) error {
+func (h *MockHelmClient) UpgradeRelease(releaseName, _ string, _ []string, _ []helm.SetValue, _ string, _ bool) (*helm.ReleaseUpgradeResult, error) {
 	h.UpgradeReleaseExecuted = true
-	return nil
+	return &helm.ReleaseUpgradeResult{Release: releaseName, Revision: 1}, nil
+}
+
+func (h *MockHelmClient) RenderRelease(_, _ string, _ []string, _ []helm.SetValue, _ string) (string, error) {
+	return "", nil
+}
+
+func (h *MockHelmClient) GetReleaseManifest(_ string) (string, error) {
+	return "", nil
+}
+
+func (h *MockHelmClient) GetReleaseNotes(_ string) (string, error) {
+	return "", nil
 }
 
 func (h *MockHelmClient) DeleteRelease(_ string) error {
@@ -429,7 +443,7 @@ func TestMainModuleManager_RunModule(t *testing.T) {
 		},
 	}
 
-	err := mm.RunModule(moduleName)
+	err := mm.RunModule(moduleName, false, "")
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -470,7 +484,7 @@ func TestMainModuleManager_DeleteModule(t *testing.T) {
 		},
 	}
 
-	err := mm.DeleteModule(moduleName)
+	err := mm.DeleteModule(moduleName, "")
 	if err != nil {
 		t.Fatal(err)
 	}
@@ -596,13 +610,13 @@ func TestMainModuleManager_RunModuleHook(t *testing.T) {
 		},
 	}
 
-	mm.kubeModulesConfigValues = make(map[string]utils.Values)
+	mm.valuesStorage.SetKubeModulesConfigValues(make(map[string]utils.Values))
 	for _, expectation := range expectations {
 		t.Run(expectation.testName, func(t *testing.T) {
-			mm.kubeModulesConfigValues[expectation.moduleName] = expectation.kubeModuleConfigValues
-			mm.modulesDynamicValuesPatches[expectation.moduleName] = expectation.moduleDynamicValuesPatches
+			mm.valuesStorage.SetKubeModuleConfigValues(expectation.moduleName, expectation.kubeModuleConfigValues)
+			mm.valuesStorage.SetModuleDynamicValuesPatches(expectation.moduleName, expectation.moduleDynamicValuesPatches)
 
-			if err := mm.RunModuleHook(expectation.hookName, BeforeHelm); err != nil {
+			if err := mm.RunModuleHook(expectation.hookName, BeforeHelm, []BindingContext{}, ""); err != nil {
 				t.Fatal(err)
 			}
 
@@ -629,13 +643,13 @@ func TestMainModuleManager_GetGlobalHook2(t *testing.T) {
 
 	createGlobalHook := func(name string, bindings []BindingType, orderByBindings map[BindingType]interface{}, schedule []ScheduleConfig, onKubernetesEvent []OnKubernetesEventConfig) *GlobalHook {
 		config := &GlobalHookConfig{
-			HookConfig{
-				orderByBindings[OnStartup],
-				schedule,
-				onKubernetesEvent,
+			HookConfig: HookConfig{
+				OnStartup:         orderByBindings[OnStartup],
+				Schedule:          schedule,
+				OnKubernetesEvent: onKubernetesEvent,
 			},
-			orderByBindings[BeforeAll],
-			orderByBindings[AfterAll],
+			BeforeAll: orderByBindings[BeforeAll],
+			AfterAll:  orderByBindings[AfterAll],
 		}
 
 		globalHook := mm.newGlobalHook(name, filepath.Join(WorkingDir, name), config)
@@ -832,6 +846,7 @@ func TestMainModuleManager_RunGlobalHook(t *testing.T) {
 			utils.Values{
 				"global": map[string]interface{}{
 					"a": 2.0, "c": []interface{}{3.0},
+					"enabledModules": []string{},
 				},
 			},
 		},
@@ -846,6 +861,7 @@ func TestMainModuleManager_RunGlobalHook(t *testing.T) {
 			utils.Values{
 				"global": map[string]interface{}{
 					"a": 9.0, "c": "10",
+					"enabledModules": []string{},
 				},
 			},
 		},
@@ -866,6 +882,7 @@ func TestMainModuleManager_RunGlobalHook(t *testing.T) {
 			utils.Values{
 				"global": map[string]interface{}{
 					"a": 2.0, "c": []interface{}{3.0}, "x": "123",
+					"enabledModules": []string{},
 				},
 			},
 		},
@@ -885,6 +902,7 @@ func TestMainModuleManager_RunGlobalHook(t *testing.T) {
 			utils.Values{
 				"global": map[string]interface{}{
 					"a": 9.0, "c": "10", "x": 10.0,
+					"enabledModules": []string{},
 				},
 			},
 		},
@@ -892,10 +910,10 @@ func TestMainModuleManager_RunGlobalHook(t *testing.T) {
 
 	for _, expectation := range expectations {
 		t.Run(expectation.testName, func(t *testing.T) {
-			mm.kubeGlobalConfigValues = expectation.kubeGlobalConfigValues
-			mm.globalDynamicValuesPatches = expectation.globalDynamicValuesPatches
+			mm.valuesStorage.SetKubeGlobalConfigValues(expectation.kubeGlobalConfigValues)
+			mm.valuesStorage.SetGlobalDynamicValuesPatches(expectation.globalDynamicValuesPatches)
 
-			if err := mm.RunGlobalHook(expectation.hookName, BeforeHelm, []BindingContext{}); err != nil {
+			if err := mm.RunGlobalHook(expectation.hookName, BeforeHelm, []BindingContext{}, ""); err != nil {
 				t.Fatal(err)
 			}
 
@@ -914,3 +932,45 @@ func TestMainModuleManager_RunGlobalHook(t *testing.T) {
 		})
 	}
 }
+
+func TestMainModuleManager_RunGlobalHook_Parallel(t *testing.T) {
+	mm := NewMainModuleManager(&MockHelmClient{}, MockKubeConfigManager{})
+
+	runInitGlobalHooks(t, mm, "test_run_global_hook_parallel")
+
+	// runs of the same hook from different queues use the same files
+	errs := make(chan error)
+	for i := 0; i < 2; i++ {
+		go func() {
+			errs <- mm.RunGlobalHook("global-hooks/run_once", BeforeHelm, []BindingContext{}, "")
+		}()
+	}
+	for i := 0; i < 2; i++ {
+		if err := <-errs; err != nil {
+			t.Error(err)
+		}
+	}
+}
+
+func TestModule_helmSetValues(t *testing.T) {
+	m := &Module{
+		Name: "module",
+		Path: "/modules/000-module",
+		Definition: &ModuleDefinition{
+			SetValues: []helm.SetValue{
+				helm.NewSetStringValue("image.tag", "1.10"),
+				{Name: "config", Value: "files/config.toml", Type: helm.SetValueFile},
+			},
+		},
+	}
+
+	expected := []helm.SetValue{
+		helm.NewSetStringValue("image.tag", "1.10"),
+		{Name: "config", Value: "/modules/000-module/files/config.toml", Type: helm.SetValueFile},
+		helm.NewSetStringValue("_antiopaModuleChecksum", "123"),
+	}
+	assert.Equal(t, expected, m.helmSetValues("123"))
+
+	// module.yaml is not modified
+	assert.Equal(t, "files/config.toml", m.Definition.SetValues[1].Value)
+}
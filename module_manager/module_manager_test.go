@@ -134,6 +134,32 @@ func TestMainModuleManager_GetModuleHooksInOrder(t *testing.T) {
 	})
 }
 
+func TestMainModuleManager_GetModuleHookNames(t *testing.T) {
+	mm := NewMainModuleManager(nil, nil)
+
+	module := &Module{Name: "module"}
+	otherModule := &Module{Name: "other-module"}
+	mm.allModulesByName = map[string]*Module{"module": module, "other-module": otherModule}
+	mm.modulesHooksByName = map[string]*ModuleHook{
+		"hook-2":       {Hook: &Hook{Name: "hook-2"}, Module: module},
+		"hook-1":       {Hook: &Hook{Name: "hook-1"}, Module: module},
+		"other-hook-1": {Hook: &Hook{Name: "other-hook-1"}, Module: otherModule},
+	}
+
+	hooksNames, err := mm.GetModuleHookNames("module")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual([]string{"hook-1", "hook-2"}, hooksNames) {
+		t.Errorf("\n[EXPECTED]: %#v\n[GOT]: %#v", []string{"hook-1", "hook-2"}, hooksNames)
+	}
+
+	_, err = mm.GetModuleHookNames("non-exist")
+	if err == nil {
+		t.Error("Expected error!")
+	}
+}
+
 func TestMainModuleManager_GetGlobalHook(t *testing.T) {
 	mm := NewMainModuleManager(nil, nil)
 
@@ -0,0 +1,118 @@
+package module_manager
+
+import (
+	"bytes"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strings"
+	"text/template"
+
+	"github.com/romana/rlog"
+
+	"github.com/flant/antiopa/executor"
+	"github.com/flant/antiopa/utils"
+)
+
+// Module notes are operator-facing messages rendered with live values after the module run:
+// endpoints, how to get credentials, next steps. Unlike NOTES.txt of the chart they are
+// rendered by antiopa, so they can use values computed by hooks. Notes are produced by
+// the executable 'notes' script that writes them into the file in MODULE_NOTES_RESULT or
+// by 'notes.tpl' Go template with .Module and .Values.
+//
+// Values are passed as is: secrets from vault are not resolved, because notes are
+// published in the addons report.
+const (
+	ModuleNotesScript   = "notes"
+	ModuleNotesTemplate = "notes.tpl"
+)
+
+// ModuleNotesLimit is a max size of notes in bytes, notes are stored in the report ConfigMap
+var ModuleNotesLimit = 16 * 1024
+
+// RenderNotes returns notes of the module rendered with current values.
+// Empty notes are returned if module has no notes script or template.
+func (m *Module) RenderNotes() (string, error) {
+	scriptPath := filepath.Join(m.Path, ModuleNotesScript)
+	templatePath := filepath.Join(m.Path, ModuleNotesTemplate)
+
+	var notes string
+	var err error
+	if f, statErr := os.Stat(scriptPath); statErr == nil {
+		if !utils.IsFileExecutable(f) {
+			return "", fmt.Errorf("cannot execute non-executable notes script '%s'", scriptPath)
+		}
+		notes, err = m.runNotesScript(scriptPath)
+	} else if _, statErr := os.Stat(templatePath); statErr == nil {
+		notes, err = m.renderNotesTemplate(templatePath)
+	} else {
+		return "", nil
+	}
+	if err != nil {
+		return "", err
+	}
+
+	notes = strings.TrimSpace(notes)
+	if len(notes) > ModuleNotesLimit {
+		return "", fmt.Errorf("notes are %d bytes, limit is %d bytes", len(notes), ModuleNotesLimit)
+	}
+	return notes, nil
+}
+
+func (m *Module) runNotesScript(scriptPath string) (string, error) {
+	configValuesPath, err := dumpValuesJson(fmt.Sprintf("%s.notes-config-values.json", m.SafeName()), m.configValues())
+	if err != nil {
+		return "", err
+	}
+	valuesPath, err := dumpValuesJson(fmt.Sprintf("%s.notes-values.json", m.SafeName()), m.values())
+	if err != nil {
+		return "", err
+	}
+	resultPath := filepath.Join(TempDir, fmt.Sprintf("%s.module-notes-result", m.SafeName()))
+	if err := createHookResultValuesFile(resultPath); err != nil {
+		return "", err
+	}
+
+	rlog.Debugf("MODULE_RUN '%s': run notes script '%s'", m.Name, scriptPath)
+
+	cmd := m.moduleManager.makeHookCommand(
+		WorkingDir, configValuesPath, valuesPath, "", "", scriptPath, []string{},
+		[]string{
+			fmt.Sprintf("MODULE_NOTES_RESULT=%s", resultPath),
+			fmt.Sprintf("CONFIG_VALUES_JSON_PATH=%s", configValuesPath),
+			fmt.Sprintf("VALUES_JSON_PATH=%s", valuesPath),
+		},
+	)
+	if err := executor.Run(cmd, true); err != nil {
+		return "", fmt.Errorf("notes script '%s' failed: %s", scriptPath, err)
+	}
+
+	data, err := ioutil.ReadFile(resultPath)
+	if err != nil {
+		return "", fmt.Errorf("cannot read notes result MODULE_NOTES_RESULT=\"%s\": %s", resultPath, err)
+	}
+	return string(data), nil
+}
+
+func (m *Module) renderNotesTemplate(templatePath string) (string, error) {
+	data, err := ioutil.ReadFile(templatePath)
+	if err != nil {
+		return "", err
+	}
+
+	tpl, err := template.New(ModuleNotesTemplate).Parse(string(data))
+	if err != nil {
+		return "", fmt.Errorf("bad notes template '%s': %s", templatePath, err)
+	}
+
+	buf := &bytes.Buffer{}
+	err = tpl.Execute(buf, map[string]interface{}{
+		"Module": m.Name,
+		"Values": map[string]interface{}(m.values()),
+	})
+	if err != nil {
+		return "", fmt.Errorf("notes template '%s': %s", templatePath, err)
+	}
+	return buf.String(), nil
+}
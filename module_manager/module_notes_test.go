@@ -0,0 +1,41 @@
+package module_manager
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/flant/antiopa/utils"
+)
+
+func TestModule_RenderNotes(t *testing.T) {
+	dir, err := ioutil.TempDir("", "module-notes")
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer os.RemoveAll(dir)
+
+	mm := NewMainModuleManager(&MockHelmClient{}, nil)
+	m := &Module{Name: "dex", Path: dir, moduleManager: mm, StaticConfig: utils.NewModuleConfig("dex")}
+	m.StaticConfig.Values = utils.Values{"dex": map[string]interface{}{"host": "dex.example.com"}}
+
+	notes, err := m.RenderNotes()
+	assert.NoError(t, err)
+	assert.Equal(t, "", notes)
+
+	tpl := "\n{{ .Module }}: https://{{ .Values.dex.host }}\n"
+	if !assert.NoError(t, ioutil.WriteFile(filepath.Join(dir, ModuleNotesTemplate), []byte(tpl), 0644)) {
+		return
+	}
+	notes, err = m.RenderNotes()
+	assert.NoError(t, err)
+	assert.Equal(t, "dex: https://dex.example.com", notes)
+
+	defer func(limit int) { ModuleNotesLimit = limit }(ModuleNotesLimit)
+	ModuleNotesLimit = 10
+	_, err = m.RenderNotes()
+	assert.Error(t, err)
+}
@@ -0,0 +1,32 @@
+package module_manager
+
+import (
+	"github.com/romana/rlog"
+
+	"github.com/flant/antiopa/helm"
+)
+
+// ResourcesTotals returns requests and limits of pods in the module release or nil
+// if module has no release or it is not run yet
+func (m *Module) ResourcesTotals() *helm.ResourcesTotals {
+	m.resourcesMutex.Lock()
+	defer m.resourcesMutex.Unlock()
+	return m.resourcesTotals
+}
+
+func (m *Module) setResourcesTotals(totals *helm.ResourcesTotals) {
+	m.resourcesMutex.Lock()
+	m.resourcesTotals = totals
+	m.resourcesMutex.Unlock()
+}
+
+// updateResourcesTotals aggregates resources declared in the release manifest, errors
+// are logged: resources reporting should not fail the module run
+func (m *Module) updateResourcesTotals(manifest string) {
+	totals, err := helm.ManifestResourcesTotals(manifest)
+	if err != nil {
+		rlog.Errorf("MODULE_RUN '%s': cannot count resources of release: %s", m.Name, err)
+		return
+	}
+	m.setResourcesTotals(totals)
+}
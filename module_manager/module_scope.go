@@ -0,0 +1,39 @@
+package module_manager
+
+// HasTag returns true if tag is in tags of the module definition
+func (m *Module) HasTag(tag string) bool {
+	if m.Definition == nil {
+		return false
+	}
+	for _, moduleTag := range m.Definition.Tags {
+		if moduleTag == tag {
+			return true
+		}
+	}
+	return false
+}
+
+// ReleaseNamespace returns namespace of the module release: the namespace of its tiller
+func (m *Module) ReleaseNamespace() (string, error) {
+	helmClient, err := m.HelmClient()
+	if err != nil {
+		return "", err
+	}
+	return helmClient.TillerNamespace(), nil
+}
+
+// MatchesScope returns true if module has the tag and its release is in the namespace.
+// Empty tag or namespace matches any module.
+func (m *Module) MatchesScope(tag string, namespace string) (bool, error) {
+	if tag != "" && !m.HasTag(tag) {
+		return false, nil
+	}
+	if namespace == "" {
+		return true, nil
+	}
+	releaseNamespace, err := m.ReleaseNamespace()
+	if err != nil {
+		return false, err
+	}
+	return releaseNamespace == namespace, nil
+}
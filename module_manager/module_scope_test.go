@@ -0,0 +1,42 @@
+package module_manager
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/flant/antiopa/helm"
+)
+
+func TestModule_MatchesScope(t *testing.T) {
+	mm := NewMainModuleManager(helm.NewRecorderHelm("antiopa"), nil)
+
+	m := &Module{Name: "cni", moduleManager: mm, Definition: NewModuleDefinition()}
+	m.Definition.Tags = []string{"networking"}
+
+	for _, tc := range []struct {
+		tag       string
+		namespace string
+		matches   bool
+	}{
+		{"", "", true},
+		{"networking", "", true},
+		{"monitoring", "", false},
+		{"networking", "antiopa", true},
+		{"networking", "kube-system", false},
+		{"", "antiopa", true},
+	} {
+		matches, err := m.MatchesScope(tc.tag, tc.namespace)
+		assert.NoError(t, err)
+		assert.Equal(t, tc.matches, matches, "tag '%s', namespace '%s'", tc.tag, tc.namespace)
+	}
+}
+
+func TestModuleDefinition_ValidateTags(t *testing.T) {
+	d := NewModuleDefinition()
+	d.Tags = []string{"networking", "ingress"}
+	assert.NoError(t, d.validate())
+
+	d.Tags = []string{"networking,ingress"}
+	assert.Error(t, d.validate())
+}
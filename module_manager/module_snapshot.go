@@ -0,0 +1,92 @@
+package module_manager
+
+import (
+	"bytes"
+	"compress/gzip"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+
+	"github.com/romana/rlog"
+
+	"github.com/flant/antiopa/helm"
+	"github.com/flant/antiopa/utils"
+)
+
+// Directory to store archives of modules directories used for releases, archives are not stored if empty
+var ModuleArchivesDir string
+
+// moduleSnapshot is a checksum of the module directory content used for a release revision
+// and a path of its archive. They are recorded in values of the release.
+type moduleSnapshot struct {
+	Checksum string
+	Archive  string
+}
+
+// snapshot calculates sha256 of the module directory archive. Archive is saved into
+// ModuleArchivesDir, failure to save it does not stop the release.
+func (m *Module) snapshot() (*moduleSnapshot, error) {
+	buf := &bytes.Buffer{}
+	if err := utils.ArchiveDirectory(m.Path, buf); err != nil {
+		return nil, fmt.Errorf("cannot archive module directory '%s': %s", m.Path, err)
+	}
+	sum := sha256.Sum256(buf.Bytes())
+	snapshot := &moduleSnapshot{Checksum: hex.EncodeToString(sum[:])}
+
+	if ModuleArchivesDir == "" {
+		return snapshot, nil
+	}
+
+	archive, err := saveModuleArchive(m.SafeName(), snapshot.Checksum, buf.Bytes())
+	if err != nil {
+		rlog.Errorf("MODULE_RUN '%s': cannot save module archive: %s", m.Name, err)
+		return snapshot, nil
+	}
+	snapshot.Archive = archive
+
+	return snapshot, nil
+}
+
+// saveModuleArchive writes gzipped archive into ModuleArchivesDir. Archive with the
+// same checksum is not written again.
+func saveModuleArchive(name string, checksum string, data []byte) (string, error) {
+	path := filepath.Join(ModuleArchivesDir, fmt.Sprintf("%s-%s.tar.gz", name, checksum))
+	if _, err := os.Stat(path); err == nil {
+		return path, nil
+	}
+
+	if err := os.MkdirAll(ModuleArchivesDir, 0755); err != nil {
+		return "", err
+	}
+	tmpFile, err := ioutil.TempFile(ModuleArchivesDir, ".tmp-")
+	if err != nil {
+		return "", err
+	}
+	defer os.Remove(tmpFile.Name())
+
+	gz := gzip.NewWriter(tmpFile)
+	_, err = gz.Write(data)
+	if err == nil {
+		err = gz.Close()
+	}
+	if closeErr := tmpFile.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return "", err
+	}
+
+	return path, os.Rename(tmpFile.Name(), path)
+}
+
+// helmSetValues returns values to record the snapshot in the release
+func (s *moduleSnapshot) helmSetValues() []helm.SetValue {
+	setValues := []helm.SetValue{helm.NewSetStringValue("_antiopaModuleSourceChecksum", s.Checksum)}
+	if s.Archive != "" {
+		setValues = append(setValues, helm.NewSetStringValue("_antiopaModuleArchive", s.Archive))
+	}
+	return setValues
+}
@@ -0,0 +1,40 @@
+package module_manager
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestModuleSnapshot(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "module-snapshot")
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer os.RemoveAll(tmpDir)
+
+	modulePath := filepath.Join(tmpDir, "001-alpha")
+	assert.NoError(t, os.MkdirAll(modulePath, 0755))
+	assert.NoError(t, ioutil.WriteFile(filepath.Join(modulePath, "Chart.yaml"), []byte("name: alpha\n"), 0644))
+	m := &Module{Name: "alpha", Path: modulePath}
+
+	ModuleArchivesDir = ""
+	snapshot, err := m.snapshot()
+	assert.NoError(t, err)
+	assert.Len(t, snapshot.Checksum, 64)
+	assert.Equal(t, "", snapshot.Archive)
+	assert.Len(t, snapshot.helmSetValues(), 1)
+
+	ModuleArchivesDir = filepath.Join(tmpDir, "archives")
+	defer func() { ModuleArchivesDir = "" }()
+	archived, err := m.snapshot()
+	assert.NoError(t, err)
+	assert.Equal(t, snapshot.Checksum, archived.Checksum)
+	assert.Equal(t, filepath.Join(ModuleArchivesDir, "alpha-"+snapshot.Checksum+".tar.gz"), archived.Archive)
+	_, err = os.Stat(archived.Archive)
+	assert.NoError(t, err)
+	assert.Len(t, archived.helmSetValues(), 2)
+}
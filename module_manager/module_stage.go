@@ -0,0 +1,130 @@
+package module_manager
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+)
+
+// ModuleStage is a named group of modules. All modules of a stage should
+// converge successfully before modules of the next stage are run.
+type ModuleStage string
+
+const (
+	// modules required by everything else: CNI, CSI, etc.
+	StagePreCluster ModuleStage = "pre-cluster"
+	// default stage
+	StageCluster ModuleStage = "cluster"
+	// modules that use cluster services: monitoring, dashboards, etc.
+	StagePostCluster ModuleStage = "post-cluster"
+)
+
+// ModuleStages in order of run
+var ModuleStages = []ModuleStage{StagePreCluster, StageCluster, StagePostCluster}
+
+// Index returns position of stage in ModuleStages or -1 for unknown stage
+func (s ModuleStage) Index() int {
+	for i, stage := range ModuleStages {
+		if stage == s {
+			return i
+		}
+	}
+	return -1
+}
+
+func (s ModuleStage) validate() error {
+	if s.Index() < 0 {
+		return fmt.Errorf("unsupported stage '%s', expected one of %v", s, ModuleStages)
+	}
+	return nil
+}
+
+// Stage returns a stage from module.yaml or default stage
+func (m *Module) Stage() ModuleStage {
+	if m.Definition == nil || m.Definition.Stage == "" {
+		return StageCluster
+	}
+	return m.Definition.Stage
+}
+
+// sortModulesByStage sorts modules names by stages. Order of modules in a stage is kept.
+func (mm *MainModuleManager) sortModulesByStage(modulesNames []string) []string {
+	res := make([]string, len(modulesNames))
+	copy(res, modulesNames)
+	sort.SliceStable(res, func(i, j int) bool {
+		return mm.allModulesByName[res[i]].Stage().Index() < mm.allModulesByName[res[j]].Stage().Index()
+	})
+	return res
+}
+
+// moduleRunOutcomes are results of module runs in converge cycles for stage barriers
+type moduleRunOutcomes struct {
+	m     sync.Mutex
+	cycle int
+	// modules queued to run in the current cycle
+	toRun map[string]bool
+	// cycle of the last successful run of the module
+	succeededCycle map[string]int
+	// result of the last run of the module in any cycle
+	succeeded map[string]bool
+}
+
+func (o *moduleRunOutcomes) startCycle(modulesNames []string) {
+	o.m.Lock()
+	defer o.m.Unlock()
+
+	o.cycle++
+	o.toRun = make(map[string]bool)
+	for _, moduleName := range modulesNames {
+		o.toRun[moduleName] = true
+	}
+}
+
+func (o *moduleRunOutcomes) record(moduleName string, succeeded bool) {
+	o.m.Lock()
+	defer o.m.Unlock()
+
+	if o.succeeded == nil {
+		o.succeeded = make(map[string]bool)
+		o.succeededCycle = make(map[string]int)
+	}
+	o.succeeded[moduleName] = succeeded
+	if succeeded {
+		o.succeededCycle[moduleName] = o.cycle
+	}
+}
+
+// converged is true if the module queued in the current cycle succeeded in this cycle
+// or if the last run of the module that is not queued in the cycle succeeded
+func (o *moduleRunOutcomes) converged(moduleName string) bool {
+	o.m.Lock()
+	defer o.m.Unlock()
+
+	if o.toRun[moduleName] {
+		cycle, has := o.succeededCycle[moduleName]
+		return has && cycle == o.cycle
+	}
+	return o.succeeded[moduleName]
+}
+
+// StartConvergeCycle is called when runs of modules are queued after discovery. Stage
+// barriers of the cycle wait for successful runs of these modules in this cycle.
+func (mm *MainModuleManager) StartConvergeCycle(modulesToRun []string) {
+	mm.runOutcomes.startCycle(modulesToRun)
+}
+
+// PendingModulesBeforeStage returns enabled modules of previous stages that
+// are not converged successfully in the current converge cycle yet.
+func (mm *MainModuleManager) PendingModulesBeforeStage(stage ModuleStage) []string {
+	pending := make([]string, 0)
+	for _, moduleName := range mm.enabledModulesInOrder {
+		module := mm.allModulesByName[moduleName]
+		if module.Stage().Index() >= stage.Index() {
+			continue
+		}
+		if !mm.runOutcomes.converged(moduleName) {
+			pending = append(pending, moduleName)
+		}
+	}
+	return pending
+}
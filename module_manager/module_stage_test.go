@@ -0,0 +1,45 @@
+package module_manager
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMainModuleManager_sortModulesByStage(t *testing.T) {
+	mm := NewMainModuleManager(&MockHelmClient{}, nil)
+	for name, stage := range map[string]ModuleStage{
+		"monitoring": StagePostCluster,
+		"ingress":    "",
+		"cni":        StagePreCluster,
+		"dns":        StageCluster,
+		"csi":        StagePreCluster,
+	} {
+		module := mm.NewModule()
+		module.Name = name
+		module.Definition = NewModuleDefinition()
+		module.Definition.Stage = stage
+		mm.allModulesByName[name] = module
+	}
+
+	sorted := mm.sortModulesByStage([]string{"monitoring", "ingress", "csi", "dns", "cni"})
+	assert.Equal(t, []string{"csi", "cni", "ingress", "dns", "monitoring"}, sorted)
+
+	mm.enabledModulesInOrder = sorted
+	mm.StartConvergeCycle(sorted)
+	mm.runOutcomes.record("csi", true)
+	mm.runOutcomes.record("cni", false)
+	assert.Equal(t, []string{"cni"}, mm.PendingModulesBeforeStage(StageCluster))
+	assert.Equal(t, []string{"cni", "ingress", "dns"}, mm.PendingModulesBeforeStage(StagePostCluster))
+	assert.Equal(t, []string{}, mm.PendingModulesBeforeStage(StagePreCluster))
+
+	// success of the previous cycle is not enough for modules queued in the new cycle
+	mm.runOutcomes.record("cni", true)
+	mm.StartConvergeCycle([]string{"cni", "dns"})
+	assert.Equal(t, []string{"cni"}, mm.PendingModulesBeforeStage(StageCluster))
+	mm.runOutcomes.record("cni", true)
+	assert.Equal(t, []string{}, mm.PendingModulesBeforeStage(StageCluster))
+	// ingress is not queued in the cycle and is not converged since its failed run
+	mm.runOutcomes.record("ingress", false)
+	assert.Equal(t, []string{"ingress", "dns"}, mm.PendingModulesBeforeStage(StagePostCluster))
+}
@@ -0,0 +1,275 @@
+package module_manager
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"regexp"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v2"
+
+	"github.com/flant/antiopa/helm"
+	"github.com/flant/antiopa/utils"
+)
+
+// ModuleTestsDir is a directory of the module with test cases of the chart. Case 'name' has config
+// values in name.values.yaml as in the ConfigMap: 'global' and modules sections. Rendered manifest
+// is compared with name.golden.yaml and checked with assertions from name.assertions.yaml.
+const ModuleTestsDir = "tests"
+
+const (
+	moduleTestValuesSuffix     = ".values.yaml"
+	moduleTestGoldenSuffix     = ".golden.yaml"
+	moduleTestAssertionsSuffix = ".assertions.yaml"
+)
+
+// ModuleTestAssertion checks a field of the rendered resource
+type ModuleTestAssertion struct {
+	Kind string `yaml:"kind"`
+	Name string `yaml:"name"`
+	// dot separated path in the resource, indexes of lists are numbers: spec.template.spec.containers.0.image.
+	// Only existence of the resource is checked if path is empty.
+	Path  string      `yaml:"path"`
+	Equal interface{} `yaml:"equal"`
+	// resource should not be rendered
+	Absent bool `yaml:"absent"`
+}
+
+// ModuleTestResult is a result of the test case, golden manifest is written if Updated is true
+type ModuleTestResult struct {
+	Name    string
+	Error   error
+	Updated bool
+}
+
+// RunModuleTests renders the chart of the module offline with values of each test case from
+// the tests directory. Golden manifests are written instead of comparison if update is true.
+// It is used by `antiopa module test`.
+func RunModuleTests(workingDir string, tempDir string, moduleName string, helmClient helm.HelmClient, update bool) ([]ModuleTestResult, error) {
+	TempDir = tempDir
+	WorkingDir = workingDir
+
+	mm := NewMainModuleManager(helmClient, nil)
+	if err := mm.initModulesIndex(); err != nil {
+		return nil, err
+	}
+	if err := mm.initFeatureGates(); err != nil {
+		return nil, err
+	}
+	module, hasModule := mm.allModulesByName[moduleName]
+	if !hasModule {
+		return nil, fmt.Errorf("unknown module '%s'", moduleName)
+	}
+	if chartExists, err := module.checkHelmChart(); !chartExists {
+		return nil, fmt.Errorf("module '%s' has no chart: %v", moduleName, err)
+	}
+
+	testsDir := filepath.Join(module.Path, ModuleTestsDir)
+	valuesPaths, err := filepath.Glob(filepath.Join(testsDir, "*"+moduleTestValuesSuffix))
+	if err != nil {
+		return nil, err
+	}
+	if len(valuesPaths) == 0 {
+		return nil, fmt.Errorf("module '%s' has no test cases: '%s' has no *%s files", moduleName, testsDir, moduleTestValuesSuffix)
+	}
+	sort.Strings(valuesPaths)
+
+	results := make([]ModuleTestResult, 0, len(valuesPaths))
+	for _, valuesPath := range valuesPaths {
+		name := strings.TrimSuffix(filepath.Base(valuesPath), moduleTestValuesSuffix)
+		result := ModuleTestResult{Name: name}
+		result.Updated, result.Error = mm.runModuleTestCase(module, filepath.Join(testsDir, name), helmClient, update)
+		results = append(results, result)
+	}
+	return results, nil
+}
+
+// runModuleTestCase renders the chart with values of the case and checks the manifest.
+// Path is a prefix of files of the case.
+func (mm *MainModuleManager) runModuleTestCase(module *Module, path string, helmClient helm.HelmClient, update bool) (bool, error) {
+	data, err := ioutil.ReadFile(path + moduleTestValuesSuffix)
+	if err != nil {
+		return false, err
+	}
+	configValues := make(map[interface{}]interface{})
+	if err := yaml.Unmarshal(data, &configValues); err != nil {
+		return false, fmt.Errorf("bad values: %s", err)
+	}
+	if err := mm.applyLintConfigValues(configValues); err != nil {
+		return false, fmt.Errorf("bad values: %s", err)
+	}
+	mm.enabledModulesInOrder = mm.enabledModulesByConfig
+
+	manifest, err := module.renderChartOffline(helmClient)
+	if err != nil {
+		return false, fmt.Errorf("cannot render chart: %s", err)
+	}
+
+	goldenPath := path + moduleTestGoldenSuffix
+	updated := false
+	if update {
+		if err := ioutil.WriteFile(goldenPath, []byte(normalizeManifest(manifest)), 0644); err != nil {
+			return false, err
+		}
+		updated = true
+	}
+
+	checked := false
+	golden, err := ioutil.ReadFile(goldenPath)
+	if err == nil {
+		checked = true
+		if err := compareManifests(string(golden), manifest); err != nil {
+			return updated, err
+		}
+	} else if !os.IsNotExist(err) {
+		return updated, err
+	}
+
+	assertionsData, err := ioutil.ReadFile(path + moduleTestAssertionsSuffix)
+	if err == nil {
+		checked = true
+		assertions := make([]ModuleTestAssertion, 0)
+		if err := yaml.Unmarshal(assertionsData, &assertions); err != nil {
+			return updated, fmt.Errorf("bad assertions: %s", err)
+		}
+		if err := checkManifestAssertions(manifest, assertions); err != nil {
+			return updated, err
+		}
+	} else if !os.IsNotExist(err) {
+		return updated, err
+	}
+
+	if !checked {
+		return updated, fmt.Errorf("no golden manifest or assertions, run with --update to write the golden manifest")
+	}
+	return updated, nil
+}
+
+// normalizeManifest removes trailing spaces and empty lines at the end, so golden files are stable
+func normalizeManifest(manifest string) string {
+	lines := strings.Split(manifest, "\n")
+	for i := range lines {
+		lines[i] = strings.TrimRight(lines[i], " \t\r")
+	}
+	return strings.TrimSpace(strings.Join(lines, "\n")) + "\n"
+}
+
+// compareManifests returns an error with the first different line
+func compareManifests(golden string, manifest string) error {
+	// normalized manifest ends with a new line, not with an empty line
+	expectedLines := strings.Split(strings.TrimSuffix(normalizeManifest(golden), "\n"), "\n")
+	actualLines := strings.Split(strings.TrimSuffix(normalizeManifest(manifest), "\n"), "\n")
+	for i := 0; i < len(expectedLines) || i < len(actualLines); i++ {
+		expected, actual := "<end of manifest>", "<end of manifest>"
+		if i < len(expectedLines) {
+			expected = expectedLines[i]
+		}
+		if i < len(actualLines) {
+			actual = actualLines[i]
+		}
+		if expected != actual {
+			return fmt.Errorf("manifest differs from golden at line %d:\n- %s\n+ %s", i+1, expected, actual)
+		}
+	}
+	return nil
+}
+
+var manifestDocumentSeparator = regexp.MustCompile(`(?m)^---\s*$`)
+
+// manifestResources returns resources of the manifest with normalized values
+func manifestResources(manifest string) ([]utils.Values, error) {
+	res := make([]utils.Values, 0)
+	for _, document := range manifestDocumentSeparator.Split(manifest, -1) {
+		resource, err := utils.NewValuesFromBytes([]byte(document))
+		if err != nil {
+			return nil, err
+		}
+		if len(resource) == 0 {
+			continue
+		}
+		res = append(res, resource)
+	}
+	return res, nil
+}
+
+// checkManifestAssertions returns errors of all failed assertions
+func checkManifestAssertions(manifest string, assertions []ModuleTestAssertion) error {
+	resources, err := manifestResources(manifest)
+	if err != nil {
+		return err
+	}
+
+	errs := make([]string, 0)
+	for _, assertion := range assertions {
+		if err := assertion.check(resources); err != nil {
+			errs = append(errs, err.Error())
+		}
+	}
+	if len(errs) > 0 {
+		return fmt.Errorf("%d of %d assertions failed:\n%s", len(errs), len(assertions), strings.Join(errs, "\n"))
+	}
+	return nil
+}
+
+func (a ModuleTestAssertion) check(resources []utils.Values) error {
+	var resource utils.Values
+	for _, r := range resources {
+		name, _ := utils.ValueAtPath(r, "metadata.name")
+		if r["kind"] == a.Kind && name == a.Name {
+			resource = r
+			break
+		}
+	}
+
+	if a.Absent {
+		if resource != nil {
+			return fmt.Errorf("%s '%s' should not be rendered", a.Kind, a.Name)
+		}
+		return nil
+	}
+	if resource == nil {
+		return fmt.Errorf("%s '%s' is not rendered", a.Kind, a.Name)
+	}
+	if a.Path == "" {
+		return nil
+	}
+
+	actual, found := utils.ValueAtPath(resource, a.Path)
+	if !found {
+		return fmt.Errorf("%s '%s': '%s' is not found", a.Kind, a.Name, a.Path)
+	}
+	expected, err := normalizeJsonValue(a.Equal)
+	if err != nil {
+		return fmt.Errorf("%s '%s': bad expected value of '%s': %s", a.Kind, a.Name, a.Path, err)
+	}
+	actual, err = normalizeJsonValue(actual)
+	if err != nil {
+		return err
+	}
+	if !reflect.DeepEqual(expected, actual) {
+		return fmt.Errorf("%s '%s': '%s' should be %v, got %v", a.Kind, a.Name, a.Path, expected, actual)
+	}
+	return nil
+}
+
+// normalizeJsonValue converts the value through json, so numbers and maps from yaml have the same types
+func normalizeJsonValue(value interface{}) (interface{}, error) {
+	values, err := utils.NewValues(map[interface{}]interface{}{"value": value})
+	if err != nil {
+		return nil, err
+	}
+	data, err := json.Marshal(values["value"])
+	if err != nil {
+		return nil, err
+	}
+	var res interface{}
+	if err := json.Unmarshal(data, &res); err != nil {
+		return nil, err
+	}
+	return res, nil
+}
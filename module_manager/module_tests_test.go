@@ -0,0 +1,89 @@
+package module_manager
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"gopkg.in/yaml.v2"
+)
+
+const moduleTestManifest = `---
+# Source: nginx/templates/deployment.yaml
+apiVersion: apps/v1
+kind: Deployment
+metadata:
+  name: nginx
+spec:
+  replicas: 2
+  template:
+    spec:
+      containers:
+      - name: nginx
+        image: nginx:1.15
+        ports:
+        - containerPort: 80
+---
+# Source: nginx/templates/service.yaml
+apiVersion: v1
+kind: Service
+metadata:
+  name: nginx
+`
+
+func TestCheckManifestAssertions(t *testing.T) {
+	assertions := make([]ModuleTestAssertion, 0)
+	err := yaml.Unmarshal([]byte(`
+- kind: Deployment
+  name: nginx
+  path: spec.replicas
+  equal: 2
+- kind: Deployment
+  name: nginx
+  path: spec.template.spec.containers.0.image
+  equal: nginx:1.15
+- kind: Deployment
+  name: nginx
+  path: spec.template.spec.containers.0.ports
+  equal:
+  - containerPort: 80
+- kind: Service
+  name: nginx
+- kind: Ingress
+  name: nginx
+  absent: true
+`), &assertions)
+	assert.NoError(t, err)
+	assert.NoError(t, checkManifestAssertions(moduleTestManifest, assertions))
+
+	failed := []ModuleTestAssertion{
+		{Kind: "Deployment", Name: "nginx", Path: "spec.replicas", Equal: 3},
+		{Kind: "Deployment", Name: "nginx", Path: "spec.template.spec.containers.1.image", Equal: "nginx"},
+		{Kind: "Service", Name: "nginx", Absent: true},
+		{Kind: "Ingress", Name: "nginx"},
+		{Kind: "Service", Name: "nginx"},
+	}
+	err = checkManifestAssertions(moduleTestManifest, failed)
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), "4 of 5 assertions failed")
+		assert.Contains(t, err.Error(), "'spec.replicas' should be 3, got 2")
+		assert.Contains(t, err.Error(), "'spec.template.spec.containers.1.image' is not found")
+		assert.Contains(t, err.Error(), "Service 'nginx' should not be rendered")
+		assert.Contains(t, err.Error(), "Ingress 'nginx' is not rendered")
+	}
+}
+
+func TestCompareManifests(t *testing.T) {
+	assert.NoError(t, compareManifests(moduleTestManifest, moduleTestManifest+"\n  \n"))
+
+	golden := normalizeManifest(moduleTestManifest)
+	err := compareManifests(golden, moduleTestManifest[:len(moduleTestManifest)-len("  name: nginx\n")])
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), "line 21")
+		assert.Contains(t, err.Error(), "+ <end of manifest>")
+	}
+
+	err = compareManifests(golden, "kind: Service\n")
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), "line 1:\n- ---\n+ kind: Service")
+	}
+}
@@ -0,0 +1,130 @@
+package module_manager
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+
+	"github.com/romana/rlog"
+
+	"github.com/flant/antiopa/kube"
+	"github.com/flant/antiopa/utils"
+)
+
+// Key in global values with the list of platforms of nodes, e.g. ["linux/amd64", "windows/amd64"]
+const NodePlatformsValuesKey = "nodePlatforms"
+
+// NodePlatformsDiscovery enables discovery of OS and architecture of nodes at modules discovery.
+// Modules with platforms in module.yaml are enabled only if some node has a supported platform.
+var NodePlatformsDiscovery = true
+
+// nodePlatforms are platforms of nodes from the last discovery, nil if they are not discovered
+type nodePlatforms struct {
+	m         sync.Mutex
+	platforms []kube.NodePlatform
+}
+
+func (p *nodePlatforms) set(platforms []kube.NodePlatform) {
+	p.m.Lock()
+	p.platforms = platforms
+	p.m.Unlock()
+}
+
+func (p *nodePlatforms) get() []kube.NodePlatform {
+	p.m.Lock()
+	defer p.m.Unlock()
+	return p.platforms
+}
+
+// validatePlatform checks a platform in module.yaml: 'os' or 'os/arch'
+func validatePlatform(platform string) error {
+	parts := strings.Split(platform, "/")
+	if len(parts) > 2 {
+		return fmt.Errorf("bad platform '%s', expected 'os' or 'os/arch'", platform)
+	}
+	for _, part := range parts {
+		if part == "" {
+			return fmt.Errorf("bad platform '%s', expected 'os' or 'os/arch'", platform)
+		}
+	}
+	return nil
+}
+
+// supportsPlatform returns true if the node platform matches 'os' or 'os/arch'
+func supportsPlatform(platform string, nodePlatform kube.NodePlatform) bool {
+	parts := strings.Split(platform, "/")
+	if parts[0] != nodePlatform.OS {
+		return false
+	}
+	return len(parts) == 1 || parts[1] == nodePlatform.Arch
+}
+
+// refreshNodePlatforms discovers platforms of nodes and capacity of the cluster if some module
+// has requirements. Results of the previous discovery are kept on errors, so modules are not
+// disabled by apiserver problems.
+func (mm *MainModuleManager) refreshNodePlatforms() {
+	hasRequirements := mm.hasClusterRequirements()
+	if (!NodePlatformsDiscovery && !hasRequirements) || kube.KubernetesClient == nil {
+		return
+	}
+	platforms, capacity, err := kube.DiscoverNodes()
+	if err != nil {
+		rlog.Errorf("DISCOVER cannot discover nodes: %s", err)
+		return
+	}
+	if NodePlatformsDiscovery {
+		rlog.Debugf("DISCOVER platforms of nodes: %v", platforms)
+		mm.nodePlatforms.set(platforms)
+	}
+	if hasRequirements {
+		rlog.Debugf("DISCOVER cluster capacity: %s", capacity)
+		mm.clusterCapacity.set(capacity)
+	}
+}
+
+// NodePlatforms returns platforms of nodes from the last discovery
+func (mm *MainModuleManager) NodePlatforms() []kube.NodePlatform {
+	return mm.nodePlatforms.get()
+}
+
+// SupportsNodePlatforms returns true if module has no platforms in module.yaml or some node has a
+// supported platform. Modules released into remote clusters are not checked: nodes are discovered
+// only in the cluster of antiopa.
+func (m *Module) SupportsNodePlatforms(nodePlatforms []kube.NodePlatform) bool {
+	if m.Definition == nil || len(m.Definition.Platforms) == 0 || m.Definition.Cluster != "" || nodePlatforms == nil {
+		return true
+	}
+	for _, nodePlatform := range nodePlatforms {
+		for _, platform := range m.Definition.Platforms {
+			if supportsPlatform(platform, nodePlatform) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// setNodePlatformsValues sets global.nodePlatforms in values. Numbers of nodes are not
+// in values, so modules are not rerun when nodes are added.
+func (mm *MainModuleManager) setNodePlatformsValues(values utils.Values) utils.Values {
+	platforms := mm.nodePlatforms.get()
+	if platforms == nil {
+		return values
+	}
+
+	// global section can be shared with values storage, so it is copied
+	global := make(map[string]interface{})
+	if oldGlobal, ok := values[utils.GlobalValuesKey].(map[string]interface{}); ok {
+		for key, value := range oldGlobal {
+			global[key] = value
+		}
+	}
+	values[utils.GlobalValuesKey] = global
+
+	list := make([]interface{}, 0, len(platforms))
+	for _, platform := range platforms {
+		list = append(list, platform.String())
+	}
+	global[NodePlatformsValuesKey] = list
+	return values
+}
@@ -0,0 +1,70 @@
+package module_manager
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	"k8s.io/api/core/v1"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/client-go/kubernetes/fake"
+
+	"github.com/flant/antiopa/kube"
+	"github.com/flant/antiopa/utils"
+)
+
+func newPlatformNode(name, os, arch string, labels map[string]string) *v1.Node {
+	node := &v1.Node{ObjectMeta: metav1.ObjectMeta{Name: name, Labels: labels}}
+	node.Status.NodeInfo.OperatingSystem = os
+	node.Status.NodeInfo.Architecture = arch
+	return node
+}
+
+func TestMainModuleManager_NodePlatforms(t *testing.T) {
+	defer func(c kube.Client) { kube.KubernetesClient = c }(kube.KubernetesClient)
+	kube.KubernetesClient = fake.NewSimpleClientset(
+		newPlatformNode("a", "linux", "amd64", nil),
+		newPlatformNode("b", "linux", "amd64", nil),
+		newPlatformNode("c", "", "", map[string]string{"beta.kubernetes.io/os": "windows", "kubernetes.io/arch": "amd64"}),
+	)
+
+	mm := NewMainModuleManager(&MockHelmClient{}, nil)
+	assert.Nil(t, mm.NodePlatforms())
+
+	mm.refreshNodePlatforms()
+	assert.Equal(t, []kube.NodePlatform{
+		{OS: "linux", Arch: "amd64", Nodes: 2},
+		{OS: "windows", Arch: "amd64", Nodes: 1},
+	}, mm.NodePlatforms())
+
+	values := mm.setNodePlatformsValues(utils.Values{"global": map[string]interface{}{"project": "test"}})
+	assert.Equal(t, map[string]interface{}{
+		"project":              "test",
+		NodePlatformsValuesKey: []interface{}{"linux/amd64", "windows/amd64"},
+	}, values["global"])
+}
+
+func TestModule_SupportsNodePlatforms(t *testing.T) {
+	linux := []kube.NodePlatform{{OS: "linux", Arch: "amd64", Nodes: 3}}
+	windows := []kube.NodePlatform{{OS: "windows", Arch: "amd64", Nodes: 3}}
+
+	m := &Module{Name: "node-exporter", Definition: NewModuleDefinition()}
+	assert.True(t, m.SupportsNodePlatforms(windows))
+
+	m.Definition.Platforms = []string{"linux"}
+	assert.True(t, m.SupportsNodePlatforms(linux))
+	assert.False(t, m.SupportsNodePlatforms(windows))
+	assert.True(t, m.SupportsNodePlatforms(append(windows, linux...)))
+	// platforms are not discovered
+	assert.True(t, m.SupportsNodePlatforms(nil))
+
+	m.Definition.Platforms = []string{"linux/arm64"}
+	assert.False(t, m.SupportsNodePlatforms(linux))
+
+	// nodes of remote cluster are not known
+	m.Definition.Cluster = "edge"
+	assert.True(t, m.SupportsNodePlatforms(linux))
+
+	assert.NoError(t, validatePlatform("linux/amd64"))
+	assert.Error(t, validatePlatform("linux/"))
+	assert.Error(t, validatePlatform("linux/arm/v7"))
+}
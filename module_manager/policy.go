@@ -0,0 +1,157 @@
+package module_manager
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"github.com/romana/rlog"
+
+	"github.com/flant/antiopa/executor"
+	"github.com/flant/antiopa/helm"
+	"github.com/flant/antiopa/utils"
+)
+
+// Directory with policy executables in working dir. Policy is an executable
+// (e.g. a wrapper around `opa eval` or `conftest`) that reads a json from
+// POLICY_INPUT_PATH and writes found violations into POLICY_RESULT_PATH.
+const PoliciesDir = "policies"
+
+const (
+	// violation blocks helm upgrade of the module
+	PolicySeverityDeny = "deny"
+	// violation is only logged
+	PolicySeverityWarn = "warn"
+)
+
+// PolicyInput is passed to policies before helm upgrade of the module release
+type PolicyInput struct {
+	Module        string                 `json:"module"`
+	Release       string                 `json:"release"`
+	Namespace     string                 `json:"namespace"`
+	Manifest      string                 `json:"manifest"`
+	Resources     []helm.ReleaseResource `json:"resources"`
+	Values        utils.Values           `json:"values"`
+	ValuesChanges []string               `json:"valuesChanges"`
+}
+
+type PolicyViolation struct {
+	Message string `json:"message"`
+	// deny or warn, deny is used if empty
+	Severity string `json:"severity"`
+}
+
+// PolicyResult is written by policy into POLICY_RESULT_PATH. Empty file means no violations.
+type PolicyResult struct {
+	Violations []PolicyViolation `json:"violations"`
+}
+
+// initPolicies finds policy executables in working dir
+func (mm *MainModuleManager) initPolicies() error {
+	mm.policies = make([]string, 0)
+
+	policiesDir := filepath.Join(WorkingDir, PoliciesDir)
+	if _, err := os.Stat(policiesDir); os.IsNotExist(err) {
+		return nil
+	}
+
+	paths, err := getExecutableHooksFilesPaths(policiesDir)
+	if err != nil {
+		return fmt.Errorf("cannot load policies: %s", err)
+	}
+	sort.Strings(paths)
+	mm.policies = paths
+
+	rlog.Infof("Initialized %d policies from '%s'", len(mm.policies), policiesDir)
+
+	return nil
+}
+
+// checkPolicies runs policies against the rendered manifest. Error is returned
+// if any policy reports a violation with deny severity or policy fails.
+func (m *Module) checkPolicies(helmClient helm.HelmClient, helmReleaseName string, manifest string) error {
+	resources, err := helm.ParseReleaseManifest(manifest, helmClient.TillerNamespace())
+	if err != nil {
+		return fmt.Errorf("module '%s' policy check: %s", m.Name, err)
+	}
+
+	input := PolicyInput{
+		Module:        m.Name,
+		Release:       helmReleaseName,
+		Namespace:     helmClient.TillerNamespace(),
+		Manifest:      manifest,
+		Resources:     resources,
+		Values:        m.values(),
+		ValuesChanges: m.ValuesChangesSinceLastRun(),
+	}
+	data, err := json.Marshal(input)
+	if err != nil {
+		return err
+	}
+
+	inputPath := filepath.Join(TempDir, fmt.Sprintf("%s.module-policy-input.json", m.SafeName()))
+	if err := dumpData(inputPath, data); err != nil {
+		return err
+	}
+
+	denied := make([]string, 0)
+	for _, policyPath := range m.moduleManager.policies {
+		policyName, _ := filepath.Rel(WorkingDir, policyPath)
+
+		violations, err := m.runPolicy(policyName, policyPath, inputPath)
+		if err != nil {
+			return fmt.Errorf("module '%s' policy '%s' failed: %s", m.Name, policyName, err)
+		}
+
+		for _, violation := range violations {
+			if violation.Severity == PolicySeverityWarn {
+				rlog.Warnf("MODULE_RUN '%s': policy '%s' warning: %s", m.Name, policyName, violation.Message)
+				continue
+			}
+			rlog.Errorf("MODULE_RUN '%s': policy '%s' violation: %s", m.Name, policyName, violation.Message)
+			denied = append(denied, fmt.Sprintf("%s: %s", policyName, violation.Message))
+		}
+	}
+
+	if len(denied) > 0 {
+		return fmt.Errorf("module '%s' is denied by policies:\n%s", m.Name, strings.Join(denied, "\n"))
+	}
+
+	return nil
+}
+
+func (m *Module) runPolicy(policyName string, policyPath string, inputPath string) ([]PolicyViolation, error) {
+	resultPath := filepath.Join(TempDir, fmt.Sprintf("%s.module-policy-result.json", m.SafeName()))
+	if err := createHookResultValuesFile(resultPath); err != nil {
+		return nil, err
+	}
+
+	cmd := m.moduleManager.makeCommand(WorkingDir, policyPath, []string{}, []string{
+		fmt.Sprintf("POLICY_INPUT_PATH=%s", inputPath),
+		fmt.Sprintf("POLICY_RESULT_PATH=%s", resultPath),
+	})
+	if err := executor.Run(cmd, true); err != nil {
+		return nil, err
+	}
+
+	data, err := ioutil.ReadFile(resultPath)
+	if err != nil {
+		return nil, fmt.Errorf("cannot read %s: %s", resultPath, err)
+	}
+	if strings.TrimSpace(string(data)) == "" {
+		return nil, nil
+	}
+
+	result := &PolicyResult{}
+	if err := json.Unmarshal(data, result); err != nil {
+		return nil, fmt.Errorf("bad policy result: %s\n%s", err, string(data))
+	}
+
+	rlog.Debugf("MODULE_RUN '%s': policy '%s' found %d violations", m.Name, policyName, len(result.Violations))
+
+	return result.Violations, nil
+}
@@ -0,0 +1,50 @@
+package module_manager
+
+import (
+	"os/exec"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/flant/antiopa/utils"
+)
+
+func TestModule_checkPolicies(t *testing.T) {
+	if _, err := exec.LookPath("jq"); err != nil {
+		t.Skip("jq is required for test policies")
+	}
+
+	initTempAndWorkingDirectories(t, "check_policies")
+
+	mm := NewMainModuleManager(&MockHelmClient{}, nil)
+	if !assert.NoError(t, mm.initPolicies()) {
+		return
+	}
+	assert.Equal(t, []string{
+		filepath.Join(WorkingDir, PoliciesDir, "001-deny-privileged"),
+		filepath.Join(WorkingDir, PoliciesDir, "002-warn-latest"),
+	}, mm.policies)
+
+	module := &Module{Name: "test", StaticConfig: utils.NewModuleConfig("test"), moduleManager: mm}
+
+	manifest := `---
+apiVersion: v1
+kind: Pod
+metadata:
+  name: test
+spec:
+  containers:
+  - name: test
+    image: nginx:latest
+`
+	assert.NoError(t, module.checkPolicies(&MockHelmClient{}, "test", manifest))
+
+	manifest += `    securityContext:
+      privileged: true
+`
+	err := module.checkPolicies(&MockHelmClient{}, "test", manifest)
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), "policies/001-deny-privileged: privileged containers are not allowed")
+	}
+}
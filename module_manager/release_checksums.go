@@ -0,0 +1,89 @@
+package module_manager
+
+import (
+	"fmt"
+
+	"github.com/romana/rlog"
+
+	"github.com/flant/antiopa/helm"
+)
+
+// Keys of set values with checksums of the chart and values of the release. Combined
+// _antiopaModuleChecksum is kept for releases installed by previous versions.
+const (
+	ReleaseChartChecksumKey  = "_antiopaChartChecksum"
+	ReleaseValuesChecksumKey = "_antiopaValuesChecksum"
+)
+
+// ModuleValuesChecksumKey is a top level value with a stable checksum of module values for
+// checksum annotations in charts, e.g. `checksum/values: {{ .Values.valuesChecksum }}` in
+// the pod template rolls pods out when values of the module are changed. The list of enabled
+// modules is not hashed, so enabling of other modules does not restart pods.
+const ModuleValuesChecksumKey = "valuesChecksum"
+
+// valuesChecksumSetValues returns the set value with the checksum of module values
+func (m *Module) valuesChecksumSetValues() ([]helm.SetValue, error) {
+	checksum, err := m.convergeValuesChecksum()
+	if err != nil {
+		return nil, fmt.Errorf("cannot calculate values checksum: %s", err)
+	}
+	// checksum should not be coerced into a number by helm
+	return []helm.SetValue{helm.NewSetStringValue(ModuleValuesChecksumKey, checksum)}, nil
+}
+
+// deployedRelease is a revision of the release deployed or checked by the module
+type deployedRelease struct {
+	Revision       int
+	UID            string
+	ChartChecksum  string
+	ValuesChecksum string
+}
+
+func releaseChecksumsSetValues(chartChecksum string, valuesChecksum string) []helm.SetValue {
+	// checksums should not be coerced into numbers by helm
+	return []helm.SetValue{
+		helm.NewSetStringValue(ReleaseChartChecksumKey, chartChecksum),
+		helm.NewSetStringValue(ReleaseValuesChecksumKey, valuesChecksum),
+	}
+}
+
+// rememberDeployedRelease saves checksums of the revision, so the next run with the same chart
+// and values skips helm upgrade without requests to tiller
+func (m *Module) rememberDeployedRelease(revision int, chartChecksum string, valuesChecksum string) {
+	m.deployedRelease = &deployedRelease{
+		Revision:       revision,
+		ChartChecksum:  chartChecksum,
+		ValuesChecksum: valuesChecksum,
+	}
+}
+
+func (m *Module) forgetDeployedRelease() {
+	m.deployedRelease = nil
+}
+
+// isDeployedReleaseUnchanged returns true if the last revision of the release in the cache of tiller
+// ConfigMaps is DEPLOYED, it is the revision remembered by the module and chart and values are not
+// changed. False is returned if the client has no cache.
+func (m *Module) isDeployedReleaseUnchanged(helmClient helm.HelmClient, releaseName string, chartChecksum string, valuesChecksum string) bool {
+	deployed := m.deployedRelease
+	if deployed == nil || m.isHelmUpgradeForced() {
+		return false
+	}
+	cachedClient, ok := helmClient.(helm.CachedRevisionClient)
+	if !ok {
+		return false
+	}
+	last, ok := cachedClient.CachedLastRevision(releaseName)
+	if !ok || last == nil || last.Status != "DEPLOYED" || last.Revision != deployed.Revision {
+		return false
+	}
+	// uid of the revision is not known right after helm upgrade
+	if deployed.UID == "" {
+		deployed.UID = last.UID
+	}
+	if last.UID != deployed.UID {
+		rlog.Debugf("MODULE_RUN '%s': revision %d of release '%s' is created again", m.Name, last.Revision, releaseName)
+		return false
+	}
+	return deployed.ChartChecksum == chartChecksum && deployed.ValuesChecksum == valuesChecksum
+}
@@ -0,0 +1,78 @@
+package module_manager
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/flant/antiopa/helm"
+	"github.com/flant/antiopa/utils"
+)
+
+type cachedRevisionHelmClient struct {
+	helm.HelmClient
+	last *helm.ReleaseRevision
+}
+
+func (h *cachedRevisionHelmClient) CachedLastRevision(releaseName string) (*helm.ReleaseRevision, bool) {
+	return h.last, true
+}
+
+func TestModule_isDeployedReleaseUnchanged(t *testing.T) {
+	m := &Module{Name: "dex"}
+	client := &cachedRevisionHelmClient{last: &helm.ReleaseRevision{Revision: 5, Status: "DEPLOYED", UID: "uid-1"}}
+
+	// nothing is deployed by the module yet
+	assert.False(t, m.isDeployedReleaseUnchanged(client, "dex", "chart", "values"))
+
+	m.rememberDeployedRelease(5, "chart", "values")
+	assert.True(t, m.isDeployedReleaseUnchanged(client, "dex", "chart", "values"))
+	assert.Equal(t, "uid-1", m.deployedRelease.UID)
+	assert.False(t, m.isDeployedReleaseUnchanged(client, "dex", "chart", "new-values"))
+	assert.False(t, m.isDeployedReleaseUnchanged(client, "dex", "new-chart", "values"))
+
+	// client without cache
+	assert.False(t, m.isDeployedReleaseUnchanged(&MockHelmClient{}, "dex", "chart", "values"))
+
+	m.setForceHelmUpgrade(true)
+	assert.False(t, m.isDeployedReleaseUnchanged(client, "dex", "chart", "values"))
+	m.setForceHelmUpgrade(false)
+
+	// release is deleted and installed again manually
+	client.last = &helm.ReleaseRevision{Revision: 5, Status: "DEPLOYED", UID: "uid-2"}
+	assert.False(t, m.isDeployedReleaseUnchanged(client, "dex", "chart", "values"))
+
+	// manual upgrade or rollback
+	client.last = &helm.ReleaseRevision{Revision: 6, Status: "DEPLOYED", UID: "uid-3"}
+	assert.False(t, m.isDeployedReleaseUnchanged(client, "dex", "chart", "values"))
+
+	client.last = nil
+	assert.False(t, m.isDeployedReleaseUnchanged(client, "dex", "chart", "values"))
+}
+
+func TestModule_valuesChecksumSetValues(t *testing.T) {
+	mm := NewMainModuleManager(&MockHelmClient{}, nil)
+	m := &Module{Name: "dex", moduleManager: mm, StaticConfig: utils.NewModuleConfig("dex"), Definition: NewModuleDefinition()}
+	mm.allModulesByName = map[string]*Module{"dex": m}
+	mm.enabledModulesInOrder = []string{"dex"}
+
+	checksum := func() string {
+		setValues, err := m.valuesChecksumSetValues()
+		if !assert.NoError(t, err) || !assert.Len(t, setValues, 1) {
+			return ""
+		}
+		assert.Equal(t, helm.NewSetStringValue(ModuleValuesChecksumKey, setValues[0].Value), setValues[0])
+		return setValues[0].Value
+	}
+
+	first := checksum()
+	assert.NotEmpty(t, first)
+	assert.Equal(t, first, checksum())
+
+	// other enabled modules do not change the checksum
+	mm.enabledModulesInOrder = []string{"dex", "dashboard"}
+	assert.Equal(t, first, checksum())
+
+	mm.valuesStorage.SetKubeModuleConfigValues("dex", utils.Values{"dex": map[string]interface{}{"replicas": 2}})
+	assert.NotEqual(t, first, checksum())
+}
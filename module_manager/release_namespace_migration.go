@@ -0,0 +1,70 @@
+package module_manager
+
+import (
+	"fmt"
+
+	"github.com/flant/antiopa/helm"
+)
+
+// MigrateReleaseNamespace moves the release of the module from the tiller in fromNamespace into the tiller
+// from module.yaml without downtime: objects are adopted into the release in the new namespace and
+// revisions of the old release are deleted. Module should be run after migration: helm upgrade is forced
+// to create objects in the new namespace, then objects left in the old namespace are deleted.
+func (mm *MainModuleManager) MigrateReleaseNamespace(moduleName string, fromNamespace string, dryRun bool) (*helm.ReleaseNamespaceMigration, error) {
+	module, err := mm.GetModule(moduleName)
+	if err != nil {
+		return nil, err
+	}
+	if chartExists, _ := module.checkHelmChart(); !chartExists {
+		return nil, fmt.Errorf("module '%s' has no chart", moduleName)
+	}
+	if module.Cluster() != "" {
+		return nil, fmt.Errorf("module '%s': namespace migration is not supported for releases in remote clusters", moduleName)
+	}
+
+	manifest, err := module.renderManifest()
+	if err != nil {
+		return nil, err
+	}
+	chart, err := readChartMetadata(module.Path)
+	if err != nil {
+		return nil, err
+	}
+	helmClient, err := module.HelmClient()
+	if err != nil {
+		return nil, err
+	}
+
+	migration, err := helm.MigrateReleaseNamespace(helmClient, helm.MigrateReleaseNamespaceOptions{
+		Release:       module.generateHelmReleaseName(),
+		FromNamespace: fromNamespace,
+		ChartName:     chart.Name,
+		ChartVersion:  chart.Version,
+		Manifest:      manifest,
+		DryRun:        dryRun,
+	})
+	if err != nil {
+		return nil, err
+	}
+	if !dryRun {
+		module.forgetDeployedRelease()
+		module.setForceHelmUpgrade(true)
+		module.staleReleaseResources = migration.Stale
+		module.log(ModuleLogSourceHelm).Infof("release is moved from namespace '%s' to '%s', %d objects in the old namespace are deleted after the next run", migration.From, migration.To, len(migration.Stale))
+	}
+	return migration, nil
+}
+
+// deleteStaleReleaseResources deletes objects of the release left in the old namespace after migration.
+// Objects are kept for the next run if deletion fails.
+func (m *Module) deleteStaleReleaseResources() error {
+	if len(m.staleReleaseResources) == 0 {
+		return nil
+	}
+	if err := helm.DeleteResources(m.staleReleaseResources); err != nil {
+		return fmt.Errorf("cannot delete objects left after namespace migration: %s", err)
+	}
+	m.log(ModuleLogSourceHelm).Infof("%d objects left after namespace migration are deleted", len(m.staleReleaseResources))
+	m.staleReleaseResources = nil
+	return nil
+}
@@ -0,0 +1,81 @@
+package module_manager
+
+import (
+	"fmt"
+	"path/filepath"
+
+	"github.com/romana/rlog"
+
+	"github.com/flant/antiopa/helm"
+	"github.com/flant/antiopa/utils"
+)
+
+// loadRemoteClusters reads clusters.yaml from the working dir and checks clusters of modules
+func (mm *MainModuleManager) loadRemoteClusters() error {
+	clusters, err := helm.LoadRemoteClusters(filepath.Join(WorkingDir, helm.RemoteClustersFileName))
+	if err != nil {
+		return err
+	}
+
+	mm.remoteClusters = make(map[string]*helm.RemoteCluster)
+	for _, cluster := range clusters {
+		mm.remoteClusters[cluster.Name] = cluster
+	}
+
+	for _, moduleName := range mm.allModulesNamesInOrder {
+		module := mm.allModulesByName[moduleName]
+		if module.Definition == nil || module.Definition.Cluster == "" {
+			continue
+		}
+		if _, ok := mm.remoteClusters[module.Definition.Cluster]; !ok {
+			return fmt.Errorf("module '%s' is released in unknown cluster '%s'", moduleName, module.Definition.Cluster)
+		}
+	}
+
+	return nil
+}
+
+// initRemoteClusters registers remote clusters in the helm clients pool. Clients are initialized
+// on start to list releases of remote clusters, unreachable clusters are retried on module run.
+func (mm *MainModuleManager) initRemoteClusters(newClient func(cluster *helm.RemoteCluster) (helm.HelmClient, error)) {
+	if mm.helmClients == nil || len(mm.remoteClusters) == 0 {
+		return
+	}
+
+	clusters := make([]*helm.RemoteCluster, 0, len(mm.remoteClusters))
+	for _, cluster := range mm.remoteClusters {
+		clusters = append(clusters, cluster)
+	}
+	mm.helmClients.AddClusters(clusters, newClient)
+
+	for _, cluster := range clusters {
+		if _, err := mm.helmClients.GetCluster(cluster.Name); err != nil {
+			rlog.Errorf("MODULE_MANAGER %s", err)
+		}
+	}
+	rlog.Infof("Initialized %d remote clusters", len(clusters))
+}
+
+// clusterValuesSection returns a section of values of the remote cluster of the module
+func (m *Module) clusterValuesSection(key string) utils.Values {
+	if m.Definition == nil || m.Definition.Cluster == "" {
+		return utils.Values{}
+	}
+	cluster, ok := m.moduleManager.remoteClusters[m.Definition.Cluster]
+	if !ok {
+		return utils.Values{}
+	}
+	section, ok := cluster.Values[key]
+	if !ok {
+		return utils.Values{}
+	}
+	return utils.Values{key: utils.DeepCopyValue(section)}
+}
+
+// Cluster returns the name of the remote cluster of the module release, empty for the cluster of antiopa
+func (m *Module) Cluster() string {
+	if m.Definition == nil {
+		return ""
+	}
+	return m.Definition.Cluster
+}
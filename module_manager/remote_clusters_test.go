@@ -0,0 +1,90 @@
+package module_manager
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/flant/antiopa/helm"
+	"github.com/flant/antiopa/utils"
+)
+
+func TestMainModuleManager_RemoteClusters(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "remote-clusters")
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer os.RemoveAll(tmpDir)
+	WorkingDir = tmpDir
+
+	ioutil.WriteFile(filepath.Join(tmpDir, helm.RemoteClustersFileName), []byte(`
+- name: edge
+  kubeconfig: /etc/antiopa/clusters/edge.kubeconfig
+  context: edge-admin
+  tillerNamespace: antiopa-edge
+  values:
+    global:
+      clusterName: edge
+    ingress:
+      replicas: 1
+`), 0644)
+
+	mm := NewMainModuleManager(&MockHelmClient{}, nil)
+	ingress := &Module{Name: "ingress", moduleManager: mm, Definition: NewModuleDefinition(), StaticConfig: utils.NewModuleConfig("ingress")}
+	ingress.Definition.Cluster = "edge"
+	mm.allModulesByName = map[string]*Module{"ingress": ingress}
+	mm.allModulesNamesInOrder = []string{"ingress"}
+
+	assert.NoError(t, mm.loadRemoteClusters())
+
+	values := ingress.values()
+	assert.Equal(t, "edge", values["global"].(map[string]interface{})["clusterName"])
+	assert.Equal(t, 1.0, values["ingress"].(map[string]interface{})["replicas"])
+
+	ingress.Definition.Cluster = "absent"
+	assert.Error(t, mm.loadRemoteClusters())
+}
+
+func TestModuleDefinition_ValidateCluster(t *testing.T) {
+	d := NewModuleDefinition()
+	d.Cluster = "edge"
+	assert.NoError(t, d.validate())
+
+	d.WatchRelease = ReleaseWatchRerun
+	assert.Error(t, d.validate())
+
+	d.WatchRelease = ReleaseWatchDisabled
+	d.TillerNamespace = "antiopa-heavy"
+	assert.Error(t, d.validate())
+}
+
+type unreachableHelm struct {
+	*helm.RecorderHelm
+}
+
+func (h *unreachableHelm) ListReleasesNames(labelSelector map[string]string) ([]string, error) {
+	return nil, fmt.Errorf("connection refused")
+}
+
+func TestMainModuleManager_listReleasesNames(t *testing.T) {
+	local := helm.NewRecorderHelm("antiopa")
+	local.Releases["ingress"] = &helm.RecordedRelease{Name: "ingress"}
+	pool := helm.NewClientsPool(local, nil)
+	pool.AddClusters([]*helm.RemoteCluster{{Name: "edge", TillerNamespace: "antiopa-edge"}}, func(cluster *helm.RemoteCluster) (helm.HelmClient, error) {
+		return &unreachableHelm{helm.NewRecorderHelm(cluster.TillerNamespace)}, nil
+	})
+	_, err := pool.GetCluster("edge")
+	assert.NoError(t, err)
+
+	mm := NewMainModuleManager(local, nil)
+	mm.helmClients = pool
+
+	// unreachable remote cluster does not fail discovery in the cluster of antiopa
+	releases, err := mm.listReleasesNames()
+	assert.NoError(t, err)
+	assert.Equal(t, []string{"ingress"}, releases)
+}
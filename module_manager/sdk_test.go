@@ -0,0 +1,103 @@
+package module_manager
+
+import (
+	"bytes"
+	"encoding/json"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"github.com/flant/antiopa/kube"
+	"github.com/flant/antiopa/sdk"
+	"github.com/flant/antiopa/utils"
+)
+
+// assertSameJson checks that all fields of from are decoded into to, so sdk types do not drift from antiopa types
+func assertSameJson(t *testing.T, from interface{}, to interface{}) {
+	data, err := json.Marshal(from)
+	if !assert.NoError(t, err) {
+		return
+	}
+	decoder := json.NewDecoder(bytes.NewReader(data))
+	decoder.DisallowUnknownFields()
+	if !assert.NoError(t, decoder.Decode(to), "%s", data) {
+		return
+	}
+	back, err := json.Marshal(to)
+	if assert.NoError(t, err) {
+		assert.JSONEq(t, string(data), string(back))
+	}
+}
+
+func TestSdk_BindingContext(t *testing.T) {
+	assertSameJson(t, []BindingContext{
+		{
+			Binding:           "pods",
+			ResourceEvent:     "ADDED",
+			ResourceNamespace: "default",
+			ResourceKind:      "Pod",
+			ResourceName:      "nginx",
+			Object:            map[string]interface{}{"kind": "Pod"},
+			NodeExecResults:   []kube.NodeExecResult{{Node: "node-1", ExitCode: 1, Output: "out", Error: "timeout"}},
+			HttpPoller:        "status",
+			HttpResponse:      map[string]interface{}{"ok": true},
+		},
+	}, &[]sdk.BindingContext{})
+}
+
+func TestSdk_ValuesPatchOperation(t *testing.T) {
+	assertSameJson(t, []utils.ValuesPatchOperation{
+		{Op: "add", Path: "/global/a", Value: 1.0},
+		{Op: "move", Path: "/global/b", From: "/global/a"},
+	}, &[]sdk.ValuesPatchOperation{})
+}
+
+func TestSdk_HookConfig(t *testing.T) {
+	hookConfig := HookConfig{
+		OnStartup: 10.0,
+		Schedule: []ScheduleConfig{
+			{Name: "every-minute", Crontab: "* * * * *", AllowFailure: true, Queue: "slow", ConcurrencyPolicy: ScheduleConcurrencyForbid},
+		},
+		OnKubernetesEvent: []OnKubernetesEventConfig{
+			{
+				Name:              "pods",
+				EventTypes:        []OnKubernetesEventType{KubernetesEventOnAdd},
+				ApiVersion:        "v1",
+				Kind:              "Pod",
+				Selector:          &metav1.LabelSelector{MatchLabels: map[string]string{"app": "nginx"}, MatchExpressions: []metav1.LabelSelectorRequirement{{Key: "tier", Operator: metav1.LabelSelectorOpIn, Values: []string{"web"}}}},
+				NamespaceSelector: &KubeNamespaceSelector{MatchNames: []string{"default"}, Any: true},
+				JqFilter:          ".metadata.labels",
+				AllowFailure:      true,
+				DisableDebug:      true,
+				Queue:             "pods",
+				AggregationPeriod: "10s",
+			},
+		},
+		NodeExec:        &NodeExecConfig{Command: []string{"uname"}, NodeSelector: map[string]string{"role": "master"}, Image: "alpine", Timeout: 30},
+		Timeout:         60,
+		WaitForModules:  []string{"ingress"},
+		ValuesFormat:    "yaml",
+		KubernetesScope: &KubernetesScopeConfig{ClusterRole: "view", Namespaces: []string{"default"}},
+		Job:             &HookJobConfig{Image: "alpine", RuntimeClassName: "gvisor"},
+	}
+
+	assertSameJson(t, &GlobalHookConfig{HookConfig: hookConfig, BeforeAll: 1.0, AfterAll: 2.0, OnShutdown: 3.0}, &sdk.GlobalHookConfig{})
+	assertSameJson(t, &ModuleHookConfig{HookConfig: hookConfig, BeforeHelm: 1.0, AfterHelm: 2.0, AfterDeleteHelm: 3.0, Parallel: true}, &sdk.ModuleHookConfig{})
+
+	// config printed by a hook with sdk is loaded by antiopa
+	afterHelm := 5.0
+	data, err := json.Marshal(&sdk.ModuleHookConfig{AfterHelm: &afterHelm, HookConfig: sdk.HookConfig{Schedule: []sdk.ScheduleConfig{{Crontab: "*/5 * * * *"}}}})
+	if assert.NoError(t, err) {
+		config := &ModuleHookConfig{}
+		assert.NoError(t, json.Unmarshal(data, config))
+		assert.Equal(t, 5.0, config.AfterHelm)
+		assert.Equal(t, "*/5 * * * *", config.Schedule[0].Crontab)
+	}
+}
+
+func TestSdk_HookStateOperation(t *testing.T) {
+	assertSameJson(t, []hooksStateOperation{
+		{Op: "set", Key: "token", Value: json.RawMessage(`{"id":1}`), Ttl: "24h"},
+	}, &[]sdk.HookStateOperation{})
+}
@@ -0,0 +1,66 @@
+package module_manager
+
+import (
+	"fmt"
+	"path/filepath"
+
+	"github.com/flant/antiopa/helm"
+	"github.com/flant/antiopa/utils"
+)
+
+// RunStandaloneModule runs the module from moduleDir once against the cluster: onStartup and beforeHelm
+// hooks, helm upgrade and afterHelm hooks. Other modules and global hooks are not loaded, enabled
+// script is not run. Config values are as in the ConfigMap: 'global' and the module section.
+// Global static values are loaded from values.yaml near moduleDir if it is in a 'modules' directory.
+// It is used by `antiopa module run` to test a module in isolation in CI clusters.
+func RunStandaloneModule(moduleDir string, tempDir string, configValues map[interface{}]interface{}, helmClient helm.HelmClient, taskId string) error {
+	absModuleDir, err := filepath.Abs(moduleDir)
+	if err != nil {
+		return err
+	}
+	names, err := utils.NewModuleNames(filepath.Base(absModuleDir))
+	if err != nil {
+		return err
+	}
+
+	WorkingDir = filepath.Dir(filepath.Dir(absModuleDir))
+	TempDir = tempDir
+
+	mm := NewMainModuleManager(helmClient, nil)
+	if err := mm.initGlobalConfigValues(); err != nil {
+		return err
+	}
+
+	module := mm.NewModule()
+	module.Name = names.Name
+	module.DirectoryName = filepath.Base(absModuleDir)
+	module.Path = absModuleDir
+	if err := module.loadStaticValues(); err != nil {
+		return err
+	}
+	if err := module.loadDefinition(); err != nil {
+		return err
+	}
+	if err := module.loadValuesSchema(); err != nil {
+		return err
+	}
+	mm.allModulesByName[module.Name] = module
+	mm.allModulesNamesInOrder = []string{module.Name}
+
+	if err := mm.applyLintConfigValues(configValues); err != nil {
+		return fmt.Errorf("bad config values: %s", err)
+	}
+	mm.enabledModulesInOrder = []string{module.Name}
+
+	if err := mm.initModuleHooks(module); err != nil {
+		return err
+	}
+
+	if module.ValuesSchema != nil {
+		if err := validateValuesBySchema(module.moduleValuesKey(), module.values()[module.moduleValuesKey()], module.ValuesSchema); err != nil {
+			return err
+		}
+	}
+
+	return mm.RunModule(module.Name, true, taskId)
+}
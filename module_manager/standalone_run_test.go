@@ -0,0 +1,36 @@
+package module_manager
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRunStandaloneModule_BadInput(t *testing.T) {
+	defer func(workingDir, tempDir string) { WorkingDir, TempDir = workingDir, tempDir }(WorkingDir, TempDir)
+
+	dir, err := ioutil.TempDir("", "antiopa-module-run")
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer os.RemoveAll(dir)
+
+	assert.NoError(t, os.MkdirAll(filepath.Join(dir, "modules", "001-echo"), 0755))
+	assert.NoError(t, os.MkdirAll(filepath.Join(dir, "modules", "echo"), 0755))
+
+	err = RunStandaloneModule(filepath.Join(dir, "modules", "echo"), dir, nil, &MockHelmClient{}, "test")
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), "bad module directory name 'echo'")
+	}
+
+	// config values of other modules are not accepted
+	configValues := map[interface{}]interface{}{"nginx": map[interface{}]interface{}{"replicas": 2}}
+	err = RunStandaloneModule(filepath.Join(dir, "modules", "001-echo"), dir, configValues, &MockHelmClient{}, "test")
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), "unknown module 'nginx'")
+	}
+	assert.Equal(t, dir, WorkingDir)
+}
@@ -0,0 +1,31 @@
+package module_manager
+
+import (
+	"fmt"
+
+	"github.com/flant/antiopa/executor"
+	"github.com/flant/antiopa/helm"
+)
+
+// Correlation id of antiopa task is passed to hooks in TASK_ID env and is saved
+// in release values by helm upgrade, so hook logs and release revisions can be
+// traced back to the task in aggregated logs. Processes of hooks and helm are
+// killed by the env when the task is cancelled.
+const (
+	TaskIdEnv       = executor.TaskIdEnv
+	TaskIdValuesKey = "_antiopaTaskId"
+)
+
+func taskIdEnvs(taskId string) []string {
+	if taskId == "" {
+		return []string{}
+	}
+	return []string{fmt.Sprintf("%s=%s", TaskIdEnv, taskId)}
+}
+
+func taskIdSetValues(taskId string) []helm.SetValue {
+	if taskId == "" {
+		return []helm.SetValue{}
+	}
+	return []helm.SetValue{helm.NewSetStringValue(TaskIdValuesKey, taskId)}
+}
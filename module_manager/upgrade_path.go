@@ -0,0 +1,254 @@
+package module_manager
+
+import (
+	"encoding/json"
+	"fmt"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/romana/rlog"
+
+	"github.com/flant/antiopa/executor"
+	"github.com/flant/antiopa/state_store"
+	"github.com/flant/antiopa/version"
+)
+
+// ModuleVersionsConfigMapName is a ConfigMap with versions of antiopa that performed the last
+// successful run of each module. Versions are kept only in memory if name is empty, so upgrade
+// paths are not checked after restarts.
+var ModuleVersionsConfigMapName = "antiopa-module-versions"
+
+const moduleVersionsConfigMapKey = "module-versions.json"
+
+// Env variable with the version of antiopa of the last successful run for migration hooks
+const PreviousVersionEnv = "ANTIOPA_PREVIOUS_VERSION"
+
+// UpgradeDefinition in module.yaml declares supported upgrades of the module between antiopa releases
+type UpgradeDefinition struct {
+	// MinVersion is the oldest version of antiopa of the last successful run that can be upgraded
+	// directly. Runs after an upgrade from older versions fail until antiopa is upgraded step by step.
+	MinVersion string `yaml:"minVersion"`
+	// Migrations are run before the first run after an upgrade
+	Migrations []ModuleMigration `yaml:"migrations"`
+}
+
+// ModuleMigration is an executable that is run before the first run of the module after
+// an upgrade from versions older than Before. Migrations are run again if the module run
+// fails, so they should be idempotent.
+type ModuleMigration struct {
+	Before string `yaml:"before"`
+	// path relative to the module directory, e.g. migrations/rename-values
+	Hook string `yaml:"hook"`
+}
+
+func (d *UpgradeDefinition) validate() error {
+	if d.MinVersion != "" {
+		if _, err := parseVersion(d.MinVersion); err != nil {
+			return fmt.Errorf("bad minVersion: %s", err)
+		}
+	}
+	for _, migration := range d.Migrations {
+		if _, err := parseVersion(migration.Before); err != nil {
+			return fmt.Errorf("bad migration before: %s", err)
+		}
+		if migration.Hook == "" || filepath.IsAbs(migration.Hook) || strings.HasPrefix(filepath.Clean(migration.Hook), "..") {
+			return fmt.Errorf("bad migration hook '%s', expected path in the module directory", migration.Hook)
+		}
+	}
+	return nil
+}
+
+// parseVersion parses vMAJOR.MINOR.PATCH, pre-release and build suffixes are ignored
+func parseVersion(v string) ([3]int, error) {
+	var res [3]int
+	s := strings.TrimPrefix(v, "v")
+	if i := strings.IndexAny(s, "-+"); i >= 0 {
+		s = s[:i]
+	}
+	parts := strings.Split(s, ".")
+	if len(parts) > 3 {
+		return res, fmt.Errorf("bad version '%s', expected vMAJOR.MINOR.PATCH", v)
+	}
+	for i, part := range parts {
+		n, err := strconv.Atoi(part)
+		if err != nil || n < 0 {
+			return res, fmt.Errorf("bad version '%s', expected vMAJOR.MINOR.PATCH", v)
+		}
+		res[i] = n
+	}
+	return res, nil
+}
+
+func versionLess(a [3]int, b [3]int) bool {
+	for i := range a {
+		if a[i] != b[i] {
+			return a[i] < b[i]
+		}
+	}
+	return false
+}
+
+// ModuleVersion is a version of antiopa that performed the last successful run of the module
+type ModuleVersion struct {
+	Version string    `json:"version"`
+	RunAt   time.Time `json:"runAt"`
+}
+
+type moduleVersions struct {
+	m        sync.Mutex
+	versions map[string]ModuleVersion
+	// nil store keeps versions in memory
+	store state_store.Store
+}
+
+func newModuleVersions() *moduleVersions {
+	return &moduleVersions{versions: make(map[string]ModuleVersion)}
+}
+
+func (mm *MainModuleManager) initModuleVersionsPersistence() error {
+	if ModuleVersionsConfigMapName == "" {
+		return nil
+	}
+	store, err := state_store.New(state_store.KindConfigMap, ModuleVersionsConfigMapName, moduleVersionsConfigMapKey)
+	if err != nil {
+		return err
+	}
+	return mm.moduleVersions.restore(store)
+}
+
+// restore loads versions saved by the previous antiopa pod and saves changes into the store
+func (v *moduleVersions) restore(store state_store.Store) error {
+	data, err := store.Load()
+	if err != nil {
+		return fmt.Errorf("cannot load module versions: %s", err)
+	}
+
+	v.m.Lock()
+	defer v.m.Unlock()
+	v.store = store
+	if data == nil {
+		return nil
+	}
+	if err := json.Unmarshal(data, &v.versions); err != nil {
+		return fmt.Errorf("bad saved module versions: %s", err)
+	}
+	return nil
+}
+
+func (v *moduleVersions) get(moduleName string) (ModuleVersion, bool) {
+	v.m.Lock()
+	defer v.m.Unlock()
+	res, ok := v.versions[moduleName]
+	return res, ok
+}
+
+// record saves the version of the successful run, store is updated only when the version is changed
+func (v *moduleVersions) record(moduleName string, antiopaVersion string, now time.Time) {
+	v.m.Lock()
+	defer v.m.Unlock()
+	if old, ok := v.versions[moduleName]; ok && old.Version == antiopaVersion {
+		return
+	}
+	v.versions[moduleName] = ModuleVersion{Version: antiopaVersion, RunAt: now}
+	if v.store == nil {
+		return
+	}
+	data, err := json.Marshal(v.versions)
+	if err == nil {
+		err = v.store.Save(data)
+	}
+	if err != nil {
+		rlog.Errorf("MODULE_RUN '%s': cannot save version of antiopa: %s", moduleName, err)
+	}
+}
+
+// pendingMigrations returns migrations for the upgrade from the previous version sorted by Before
+func (d *UpgradeDefinition) pendingMigrations(previous [3]int, current [3]int) []ModuleMigration {
+	res := make([]ModuleMigration, 0)
+	for _, migration := range d.Migrations {
+		before, _ := parseVersion(migration.Before)
+		if versionLess(previous, before) && !versionLess(current, before) {
+			res = append(res, migration)
+		}
+	}
+	sort.SliceStable(res, func(i, j int) bool {
+		a, _ := parseVersion(res[i].Before)
+		b, _ := parseVersion(res[j].Before)
+		return versionLess(a, b)
+	})
+	return res
+}
+
+// checkUpgradePath fails the run after an upgrade from a version older than minVersion and runs
+// migrations declared in module.yaml. Nothing is checked for the first run of the module and
+// for development builds without a version.
+func (m *Module) checkUpgradePath() error {
+	if m.Definition == nil || m.moduleManager == nil {
+		return nil
+	}
+	last, ok := m.moduleManager.moduleVersions.get(m.Name)
+	if !ok || last.Version == version.Version {
+		return nil
+	}
+	current, err := parseVersion(version.Version)
+	if err != nil {
+		return nil
+	}
+	previous, err := parseVersion(last.Version)
+	if err != nil {
+		m.log(ModuleLogSourceModule).Warnf("upgrade path from '%s' is not checked: %s", last.Version, err)
+		return nil
+	}
+
+	upgrade := m.Definition.Upgrade
+	if upgrade.MinVersion != "" {
+		minVersion, _ := parseVersion(upgrade.MinVersion)
+		if versionLess(previous, minVersion) {
+			return fmt.Errorf("upgrade from antiopa %s to %s is not supported, upgrade to %s or newer first", last.Version, version.Version, upgrade.MinVersion)
+		}
+	}
+
+	for _, migration := range upgrade.pendingMigrations(previous, current) {
+		m.log(ModuleLogSourceModule).Infof("run migration '%s' for upgrade from antiopa %s", migration.Hook, last.Version)
+		if err := m.runMigration(migration, last.Version); err != nil {
+			return fmt.Errorf("migration '%s' for upgrade from antiopa %s failed: %s", migration.Hook, last.Version, err)
+		}
+	}
+	return nil
+}
+
+func (m *Module) runMigration(migration ModuleMigration, previousVersion string) error {
+	configValuesPath, err := m.prepareConfigValuesJsonFile()
+	if err != nil {
+		return err
+	}
+	valuesPath, err := m.prepareValuesJsonFile()
+	if err != nil {
+		return err
+	}
+
+	cmd := m.moduleManager.makeHookCommand(
+		WorkingDir, configValuesPath, valuesPath, "", "", filepath.Join(m.Path, migration.Hook), []string{},
+		[]string{
+			fmt.Sprintf("%s=%s", PreviousVersionEnv, previousVersion),
+			fmt.Sprintf("%s=%s", version.VersionEnv, version.Version),
+			fmt.Sprintf("CONFIG_VALUES_JSON_PATH=%s", configValuesPath),
+			fmt.Sprintf("VALUES_JSON_PATH=%s", valuesPath),
+		},
+	)
+	defer captureModuleLog(cmd, m.Name, migration.Hook)()
+
+	return executor.Run(cmd, true)
+}
+
+// recordVersion remembers the version of antiopa after the successful run
+func (m *Module) recordVersion() {
+	if m.moduleManager == nil {
+		return
+	}
+	m.moduleManager.moduleVersions.record(m.Name, version.Version, time.Now())
+}
@@ -0,0 +1,65 @@
+package module_manager
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/flant/antiopa/version"
+)
+
+func TestParseVersion(t *testing.T) {
+	v, err := parseVersion("v1.15.3-rc.1")
+	assert.NoError(t, err)
+	assert.Equal(t, [3]int{1, 15, 3}, v)
+
+	v, err = parseVersion("2.1")
+	assert.NoError(t, err)
+	assert.Equal(t, [3]int{2, 1, 0}, v)
+
+	_, err = parseVersion("dev")
+	assert.Error(t, err)
+	assert.True(t, versionLess([3]int{1, 9, 0}, [3]int{1, 10, 0}))
+}
+
+func TestUpgradeDefinition_pendingMigrations(t *testing.T) {
+	upgrade := UpgradeDefinition{Migrations: []ModuleMigration{
+		{Before: "v1.6.0", Hook: "migrations/split-config"},
+		{Before: "v1.5.0", Hook: "migrations/rename-values"},
+		{Before: "v1.3.0", Hook: "migrations/old"},
+	}}
+	assert.NoError(t, upgrade.validate())
+
+	previous, _ := parseVersion("v1.4.2")
+	current, _ := parseVersion("v1.6.0")
+	var hooks []string
+	for _, migration := range upgrade.pendingMigrations(previous, current) {
+		hooks = append(hooks, migration.Hook)
+	}
+	assert.Equal(t, []string{"migrations/rename-values", "migrations/split-config"}, hooks)
+
+	assert.Error(t, (&UpgradeDefinition{Migrations: []ModuleMigration{{Before: "v1.0.0", Hook: "../escape"}}}).validate())
+}
+
+func TestModule_checkUpgradePath(t *testing.T) {
+	defer func(v string) { version.Version = v }(version.Version)
+	version.Version = "v1.8.0"
+
+	mm := NewMainModuleManager(&MockHelmClient{}, nil)
+	store := &memoryDynamicValuesStore{}
+	assert.NoError(t, mm.moduleVersions.restore(store))
+
+	module := &Module{Name: "nginx", Definition: NewModuleDefinition(), moduleManager: mm}
+	module.Definition.Upgrade.MinVersion = "v1.6.0"
+
+	// first run is not checked
+	assert.NoError(t, module.checkUpgradePath())
+
+	mm.moduleVersions.record("nginx", "v1.5.1", time.Now())
+	assert.Error(t, module.checkUpgradePath())
+
+	module.recordVersion()
+	assert.NoError(t, module.checkUpgradePath())
+	assert.Contains(t, string(store.data), `"version":"v1.8.0"`)
+}
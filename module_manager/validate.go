@@ -0,0 +1,103 @@
+package module_manager
+
+import (
+	"fmt"
+)
+
+// ValidationCheck is a result of one offline check of the working dir
+type ValidationCheck struct {
+	Name  string
+	Error error
+}
+
+// ValidateWorkingDir loads global hooks, modules, hooks configs, values schemas,
+// policies, maintenance windows and value sources the same way as Init does, but without
+// connecting to the cluster and without running modules. It is used by `antiopa doctor`.
+func ValidateWorkingDir(workingDir string, tempDir string) []ValidationCheck {
+	TempDir = tempDir
+	WorkingDir = workingDir
+	// broken hook files are validation errors
+	if HookStaticChecks == HookStaticChecksWarn {
+		HookStaticChecks = HookStaticChecksEnforce
+	}
+
+	mm := NewMainModuleManager(nil, nil)
+	checks := make([]ValidationCheck, 0)
+
+	checks = append(checks, ValidationCheck{Name: "global hooks configs", Error: mm.initGlobalHooks()})
+
+	err := mm.initModulesIndex()
+	checks = append(checks, ValidationCheck{Name: "modules directory", Error: err})
+	if err != nil {
+		// modules are needed for the next checks
+		return checks
+	}
+
+	checks = append(checks, ValidationCheck{Name: "global hooks waitForModules", Error: mm.validateGlobalHooksWaitForModules()})
+
+	for _, moduleName := range mm.allModulesNamesInOrder {
+		module := mm.allModulesByName[moduleName]
+
+		checks = append(checks, ValidationCheck{
+			Name:  fmt.Sprintf("module '%s' hooks configs", moduleName),
+			Error: mm.initModuleHooks(module),
+		})
+
+		if module.ValuesSchema != nil {
+			checks = append(checks, ValidationCheck{
+				Name:  fmt.Sprintf("module '%s' values schema", moduleName),
+				Error: validateValuesSchema(module.ValuesSchema),
+			})
+		}
+	}
+
+	checks = append(checks, ValidationCheck{Name: "policies", Error: mm.initPolicies()})
+	checks = append(checks, ValidationCheck{Name: "maintenance windows", Error: mm.initGlobalMaintenanceWindows()})
+	checks = append(checks, ValidationCheck{Name: "feature gates", Error: mm.initFeatureGates()})
+
+	_, err = loadValueSources()
+	checks = append(checks, ValidationCheck{Name: "value sources", Error: err})
+
+	return checks
+}
+
+// validateValuesSchema checks that schema is an object schema and all properties are schemas
+func validateValuesSchema(schema map[string]interface{}) error {
+	return validateValuesSchemaNode("", schema)
+}
+
+func validateValuesSchemaNode(path string, schema map[string]interface{}) error {
+	if rawType, hasType := schema["type"]; hasType {
+		if _, ok := rawType.(string); !ok {
+			return fmt.Errorf("'%stype' should be a string, got '%v'", path, rawType)
+		}
+	}
+
+	if rawProperties, hasProperties := schema["properties"]; hasProperties {
+		properties, ok := rawProperties.(map[string]interface{})
+		if !ok {
+			return fmt.Errorf("'%sproperties' should be an object", path)
+		}
+		for name, rawPropSchema := range properties {
+			propSchema, ok := rawPropSchema.(map[string]interface{})
+			if !ok {
+				return fmt.Errorf("'%sproperties.%s' should be an object", path, name)
+			}
+			if err := validateValuesSchemaNode(fmt.Sprintf("%sproperties.%s.", path, name), propSchema); err != nil {
+				return err
+			}
+		}
+	}
+
+	if rawItems, hasItems := schema["items"]; hasItems {
+		items, ok := rawItems.(map[string]interface{})
+		if !ok {
+			return fmt.Errorf("'%sitems' should be an object", path)
+		}
+		if err := validateValuesSchemaNode(path+"items.", items); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
@@ -0,0 +1,69 @@
+package module_manager
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/flant/antiopa/utils"
+)
+
+func TestValidateValuesSchema(t *testing.T) {
+	schema, err := utils.NewValuesFromBytes([]byte(`
+type: object
+properties:
+  replicas:
+    type: integer
+  hosts:
+    type: array
+    items:
+      type: object
+      properties:
+        port:
+          type: integer
+`))
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.NoError(t, validateValuesSchema(schema))
+
+	schema, err = utils.NewValuesFromBytes([]byte(`
+type: object
+properties:
+  hosts:
+    type: array
+    items:
+      properties:
+        port: 80
+`))
+	if !assert.NoError(t, err) {
+		return
+	}
+	err = validateValuesSchema(schema)
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), "properties.hosts.items.properties.port")
+	}
+}
+
+func TestModule_applyValuesSchemaDefaults(t *testing.T) {
+	schema, err := utils.NewValuesFromBytes([]byte(`
+type: object
+properties:
+  replicas:
+    type: integer
+    default: 2
+`))
+	if !assert.NoError(t, err) {
+		return
+	}
+	module := NewMainModuleManager(&MockHelmClient{}, nil).NewModule()
+	module.Name = "test-module"
+	module.ValuesSchema = schema
+
+	// module without values section gets defaults in an empty section
+	values := module.applyValuesSchemaDefaults(utils.Values{"global": map[string]interface{}{}})
+	assert.Equal(t, map[string]interface{}{"replicas": 2.0}, values["testModule"])
+
+	values = module.applyValuesSchemaDefaults(utils.Values{"testModule": map[string]interface{}{"replicas": 3}})
+	assert.Equal(t, map[string]interface{}{"replicas": 3}, values["testModule"])
+}
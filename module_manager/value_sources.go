@@ -0,0 +1,241 @@
+package module_manager
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"reflect"
+	"sync"
+	"time"
+
+	"github.com/romana/rlog"
+	"gopkg.in/yaml.v2"
+
+	"github.com/flant/antiopa/utils"
+)
+
+// ValueSourcesFileName is a list of external value sources in the working dir
+const ValueSourcesFileName = "value-sources.yaml"
+
+// Value source command is killed after this timeout if timeout is not set
+const DefaultValueSourceTimeout = 30 * time.Second
+
+// ValueSource is an executable plugin that prints values JSON to stdout, e.g.
+// {"global": {"clusterName": "main"}, "prometheus": {"retention": "7d"}}.
+// Global and modules sections of all sources are merged in order of sources into
+// a layer between static values and ConfigMap values.
+type ValueSource struct {
+	Name    string            `yaml:"name"`
+	Command string            `yaml:"command"`
+	Args    []string          `yaml:"args"`
+	Env     map[string]string `yaml:"env"`
+	// values are refreshed with this interval, e.g. "5m", values are loaded only on start if empty
+	Interval string `yaml:"interval"`
+	// command is killed after timeout, e.g. "30s"
+	Timeout string `yaml:"timeout"`
+
+	interval time.Duration
+	timeout  time.Duration
+}
+
+func (s *ValueSource) init() error {
+	if s.Name == "" {
+		return fmt.Errorf("name is required")
+	}
+	if s.Command == "" {
+		return fmt.Errorf("source '%s': command is required", s.Name)
+	}
+	var err error
+	if s.Interval != "" {
+		if s.interval, err = time.ParseDuration(s.Interval); err != nil {
+			return fmt.Errorf("source '%s': bad interval: %s", s.Name, err)
+		}
+	}
+	s.timeout = DefaultValueSourceTimeout
+	if s.Timeout != "" {
+		if s.timeout, err = time.ParseDuration(s.Timeout); err != nil {
+			return fmt.Errorf("source '%s': bad timeout: %s", s.Name, err)
+		}
+	}
+	if s.timeout <= 0 {
+		return fmt.Errorf("source '%s': timeout should be positive", s.Name)
+	}
+	return nil
+}
+
+// valueSources keeps the last values of each source
+type valueSources struct {
+	m       sync.Mutex
+	sources []*ValueSource
+	values  map[string]utils.Values
+}
+
+// loadValueSources reads value-sources.yaml from the working dir. Empty list is returned if file is not exists.
+func loadValueSources() ([]*ValueSource, error) {
+	sources := make([]*ValueSource, 0)
+
+	path := filepath.Join(WorkingDir, ValueSourcesFileName)
+	if _, err := os.Stat(path); os.IsNotExist(err) {
+		return sources, nil
+	}
+
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("cannot read '%s': %s", path, err)
+	}
+
+	if err := yaml.UnmarshalStrict(data, &sources); err != nil {
+		return nil, fmt.Errorf("bad %s: %s\n%s", ValueSourcesFileName, err, string(data))
+	}
+	names := make(map[string]bool)
+	for _, source := range sources {
+		if err := source.init(); err != nil {
+			return nil, fmt.Errorf("bad %s: %s", ValueSourcesFileName, err)
+		}
+		if names[source.Name] {
+			return nil, fmt.Errorf("bad %s: duplicate source '%s'", ValueSourcesFileName, source.Name)
+		}
+		names[source.Name] = true
+	}
+	return sources, nil
+}
+
+func (mm *MainModuleManager) initValueSources() error {
+	sources, err := loadValueSources()
+	if err != nil {
+		return err
+	}
+	mm.valueSources = &valueSources{
+		sources: sources,
+		values:  make(map[string]utils.Values),
+	}
+
+	// values are loaded before the first converge, failed sources are retried by interval
+	for _, source := range sources {
+		if err := mm.refreshValueSource(source); err != nil {
+			rlog.Errorf("VALUE_SOURCE '%s': %s", source.Name, err)
+		}
+	}
+
+	if len(sources) > 0 {
+		rlog.Infof("Initialized %d value sources", len(sources))
+	}
+
+	return nil
+}
+
+// runValueSources refreshes sources with interval
+func (mm *MainModuleManager) runValueSources() {
+	if mm.valueSources == nil {
+		return
+	}
+	for _, source := range mm.valueSources.sources {
+		if source.interval <= 0 {
+			continue
+		}
+		go func(source *ValueSource) {
+			for {
+				time.Sleep(source.interval)
+				if err := mm.refreshValueSource(source); err != nil {
+					rlog.Errorf("VALUE_SOURCE '%s': %s", source.Name, err)
+				}
+			}
+		}(source)
+	}
+}
+
+// refreshValueSource runs the source command and updates external values layer.
+// Global values change reloads all modules, modules sections change reruns modules.
+// Previous values of the source are kept on error.
+func (mm *MainModuleManager) refreshValueSource(source *ValueSource) error {
+	values, err := mm.execValueSource(source)
+	if err != nil {
+		return err
+	}
+
+	mm.valueSources.m.Lock()
+	mm.valueSources.values[source.Name] = values
+	merged := make([]utils.Values, 0, len(mm.valueSources.sources))
+	for _, s := range mm.valueSources.sources {
+		merged = append(merged, mm.valueSources.values[s.Name])
+	}
+	mm.valueSources.m.Unlock()
+
+	newValues := utils.MergeValues(merged...)
+	oldValues := mm.valuesStorage.ExternalValues()
+	if reflect.DeepEqual(oldValues, newValues) {
+		return nil
+	}
+	mm.valuesStorage.SetExternalValues(newValues)
+
+	if mm.kubeConfigManager == nil {
+		// initial values are loaded before the first converge
+		return nil
+	}
+
+	if !reflect.DeepEqual(oldValues["global"], newValues["global"]) {
+		rlog.Infof("VALUE_SOURCE '%s': global values are changed", source.Name)
+		mm.globalValuesChanged <- true
+		return nil
+	}
+	for _, moduleName := range mm.enabledModulesInOrder {
+		key := utils.ModuleNameToValuesKey(moduleName)
+		if !reflect.DeepEqual(oldValues[key], newValues[key]) {
+			rlog.Infof("VALUE_SOURCE '%s': module '%s' values are changed", source.Name, moduleName)
+			mm.moduleValuesChanged <- moduleName
+		}
+	}
+	return nil
+}
+
+// execValueSource runs the command and returns global and known modules sections from its output
+func (mm *MainModuleManager) execValueSource(source *ValueSource) (utils.Values, error) {
+	envs := []string{fmt.Sprintf("ANTIOPA_VALUE_SOURCE=%s", source.Name)}
+	for name, value := range source.Env {
+		envs = append(envs, fmt.Sprintf("%s=%s", name, value))
+	}
+	cmd := mm.makeCommand(WorkingDir, source.Command, source.Args, envs)
+
+	hook := &Hook{Name: fmt.Sprintf("value-source-%s", source.Name)}
+	deadline := newHookDeadline(hook, source.timeout)
+	cmd.Env = append(cmd.Env, deadline.Env()...)
+	if err := deadline.Start(cmd); err != nil {
+		return nil, err
+	}
+	output, err := execCommandOutput(cmd)
+	if deadline.Stop() {
+		return nil, fmt.Errorf("command is killed after timeout %s", source.timeout)
+	}
+	if err != nil {
+		return nil, fmt.Errorf("command failed: %s", err)
+	}
+
+	var values utils.Values
+	if err := json.Unmarshal(output, &values); err != nil {
+		return nil, fmt.Errorf("bad values JSON: %s", err)
+	}
+
+	res := make(utils.Values)
+	for key, section := range values {
+		if key != "global" && !mm.hasModuleValuesKey(key) {
+			rlog.Warnf("VALUE_SOURCE '%s': ignore values of unknown module '%s'", source.Name, key)
+			continue
+		}
+		if _, ok := section.(map[string]interface{}); !ok {
+			return nil, fmt.Errorf("section '%s' should be an object", key)
+		}
+		res[key] = section
+	}
+	return res, nil
+}
+
+func (mm *MainModuleManager) hasModuleValuesKey(key string) bool {
+	for moduleName := range mm.allModulesByName {
+		if utils.ModuleNameToValuesKey(moduleName) == key {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,56 @@
+package module_manager
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/flant/antiopa/utils"
+)
+
+func TestValueSources(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "value-sources")
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer os.RemoveAll(tmpDir)
+	TempDir = tmpDir
+	WorkingDir = tmpDir
+
+	ioutil.WriteFile(filepath.Join(tmpDir, "cmdb"), []byte(`#!/bin/sh
+echo '{"global": {"datacenter": "'$ANTIOPA_VALUE_SOURCE'"}, "prometheus": {"retention": "7d"}, "unknown": {"a": 1}}'
+`), 0755)
+	ioutil.WriteFile(filepath.Join(tmpDir, ValueSourcesFileName), []byte(`
+- name: cmdb
+  command: ./cmdb
+  interval: 5m
+`), 0644)
+
+	mm := NewMainModuleManager(&MockHelmClient{}, nil)
+	mm.allModulesByName["prometheus"] = &Module{Name: "prometheus"}
+
+	assert.NoError(t, mm.initValueSources())
+	assert.Equal(t, utils.Values{"global": map[string]interface{}{"datacenter": "cmdb"}}, mm.valuesStorage.ExternalValuesSection("global"))
+	assert.Equal(t, utils.Values{"prometheus": map[string]interface{}{"retention": "7d"}}, mm.valuesStorage.ExternalValuesSection("prometheus"))
+	assert.Equal(t, utils.Values{}, mm.valuesStorage.ExternalValuesSection("unknown"))
+}
+
+func TestLoadValueSources_Invalid(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "value-sources")
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer os.RemoveAll(tmpDir)
+	WorkingDir = tmpDir
+
+	ioutil.WriteFile(filepath.Join(tmpDir, ValueSourcesFileName), []byte(`
+- name: cmdb
+  command: ./cmdb
+  interval: often
+`), 0644)
+	_, err = loadValueSources()
+	assert.Error(t, err)
+}
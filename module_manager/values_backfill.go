@@ -0,0 +1,146 @@
+package module_manager
+
+import (
+	"encoding/json"
+	"fmt"
+	"reflect"
+
+	"github.com/romana/rlog"
+
+	"github.com/flant/antiopa/utils"
+)
+
+// ValuesBackfillMode enables backfill of config values from deployed releases on start.
+// Module without a section in the ConfigMap gets values of its release that differ from
+// static values, so converge does not wipe customizations of a cluster restored from backup
+// or of releases installed before antiopa. Values are only logged in "dry-run" mode.
+var ValuesBackfillMode string
+
+const (
+	ValuesBackfillDisabled = ""
+	ValuesBackfillDryRun   = "dry-run"
+	ValuesBackfillApply    = "apply"
+)
+
+// valuesBackfillIgnoredKeys are keys of the module section that are set by hooks, not by operator
+var valuesBackfillIgnoredKeys = []string{"internal"}
+
+func checkValuesBackfillMode(mode string) error {
+	switch mode {
+	case ValuesBackfillDisabled, ValuesBackfillDryRun, ValuesBackfillApply:
+		return nil
+	}
+	return fmt.Errorf("unknown mode '%s', expected '%s' or '%s'", mode, ValuesBackfillDryRun, ValuesBackfillApply)
+}
+
+// backfillModulesConfigValues backfills config values of enabled modules without a section
+// in the ConfigMap. Errors are logged, module is run with static values then.
+func (mm *MainModuleManager) backfillModulesConfigValues() {
+	if ValuesBackfillMode == ValuesBackfillDisabled {
+		return
+	}
+	for _, moduleName := range mm.enabledModulesByConfig {
+		if mm.valuesStorage.HasKubeModuleConfigValues(moduleName) {
+			continue
+		}
+		module := mm.allModulesByName[moduleName]
+		values, err := mm.moduleBackfillValues(module)
+		if err != nil {
+			rlog.Errorf("MODULE_MANAGER module '%s': cannot backfill config values from release: %s", moduleName, err)
+			continue
+		}
+		if values == nil {
+			continue
+		}
+
+		if ValuesBackfillMode == ValuesBackfillDryRun {
+			rlog.Infof("MODULE_MANAGER module '%s': config values would be backfilled from release:\n%s", moduleName, utils.ValuesToString(values))
+			continue
+		}
+		if err := mm.kubeConfigManager.SetKubeModuleValues(moduleName, values); err != nil {
+			rlog.Errorf("MODULE_MANAGER module '%s': cannot save backfilled config values: %s", moduleName, err)
+			continue
+		}
+		mm.valuesStorage.SetKubeModuleConfigValues(moduleName, values)
+		rlog.Infof("MODULE_MANAGER module '%s': config values are backfilled from release:\n%s", moduleName, utils.ValuesToString(values))
+	}
+}
+
+// moduleBackfillValues returns the module section of release values without values
+// that are equal to values of the module without config. Nil is returned if release
+// does not exist or has no customizations.
+func (mm *MainModuleManager) moduleBackfillValues(module *Module) (utils.Values, error) {
+	if chartExists, _ := module.checkHelmChart(); !chartExists {
+		return nil, nil
+	}
+	helmClient, err := module.HelmClient()
+	if err != nil {
+		return nil, err
+	}
+	releaseName := module.generateHelmReleaseName()
+	exists, err := helmClient.IsReleaseExists(releaseName)
+	if err != nil || !exists {
+		return nil, err
+	}
+	releaseValues, err := helmClient.GetReleaseValues(releaseName, false)
+	if err != nil {
+		return nil, err
+	}
+
+	valuesKey := module.moduleValuesKey()
+	releaseSection, err := normalizeValuesSection(releaseValues[valuesKey])
+	if err != nil {
+		return nil, fmt.Errorf("bad values of release '%s': %s", releaseName, err)
+	}
+	for _, key := range valuesBackfillIgnoredKeys {
+		delete(releaseSection, key)
+	}
+	values, _ := module.constructValuesFrom(mm.valuesStorage, nil)
+	currentSection, err := normalizeValuesSection(values[valuesKey])
+	if err != nil {
+		return nil, err
+	}
+
+	diff := valuesDiff(releaseSection, currentSection)
+	if len(diff) == 0 {
+		return nil, nil
+	}
+	return utils.Values{valuesKey: diff}, nil
+}
+
+// normalizeValuesSection converts the section through json, so numbers from yaml and go have the same types
+func normalizeValuesSection(section interface{}) (map[string]interface{}, error) {
+	res := make(map[string]interface{})
+	if section == nil {
+		return res, nil
+	}
+	data, err := json.Marshal(section)
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(data, &res); err != nil {
+		return nil, err
+	}
+	return res, nil
+}
+
+// valuesDiff returns fields of values that are absent or different in base. Maps are compared
+// by fields, other values are replaced as a whole.
+func valuesDiff(values map[string]interface{}, base map[string]interface{}) map[string]interface{} {
+	res := make(map[string]interface{})
+	for key, value := range values {
+		baseValue, hasKey := base[key]
+		valueMap, isMap := value.(map[string]interface{})
+		baseMap, baseIsMap := baseValue.(map[string]interface{})
+		if hasKey && isMap && baseIsMap {
+			if diff := valuesDiff(valueMap, baseMap); len(diff) > 0 {
+				res[key] = diff
+			}
+			continue
+		}
+		if !hasKey || !reflect.DeepEqual(value, baseValue) {
+			res[key] = value
+		}
+	}
+	return res
+}
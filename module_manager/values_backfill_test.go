@@ -0,0 +1,81 @@
+package module_manager
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/flant/antiopa/utils"
+)
+
+type backfillHelmClient struct {
+	MockHelmClient
+	releaseValues utils.Values
+}
+
+func (h *backfillHelmClient) IsReleaseExists(_ string) (bool, error) {
+	return h.releaseValues != nil, nil
+}
+
+func (h *backfillHelmClient) GetReleaseValues(_ string, _ bool) (utils.Values, error) {
+	return h.releaseValues, nil
+}
+
+func TestValuesDiff(t *testing.T) {
+	values := map[string]interface{}{
+		"replicas": 3.0,
+		"image":    "nginx",
+		"https":    map[string]interface{}{"mode": "CertManager", "issuer": "letsencrypt"},
+		"hosts":    []interface{}{"a", "b"},
+	}
+	base := map[string]interface{}{
+		"replicas": 2.0,
+		"image":    "nginx",
+		"https":    map[string]interface{}{"mode": "CertManager"},
+		"hosts":    []interface{}{"a"},
+	}
+	assert.Equal(t, map[string]interface{}{
+		"replicas": 3.0,
+		"https":    map[string]interface{}{"issuer": "letsencrypt"},
+		"hosts":    []interface{}{"a", "b"},
+	}, valuesDiff(values, base))
+	assert.Empty(t, valuesDiff(base, base))
+}
+
+func TestMainModuleManager_moduleBackfillValues(t *testing.T) {
+	dir, err := ioutil.TempDir("", "values-backfill")
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer os.RemoveAll(dir)
+	ioutil.WriteFile(filepath.Join(dir, "Chart.yaml"), []byte("name: dashboard\n"), 0644)
+
+	helmClient := &backfillHelmClient{}
+	mm := NewMainModuleManager(helmClient, nil)
+	module := &Module{Name: "dashboard", Path: dir, moduleManager: mm, StaticConfig: utils.NewModuleConfig("dashboard")}
+	module.StaticConfig.Values = utils.Values{"dashboard": map[string]interface{}{"replicas": 1, "image": "dashboard"}}
+	mm.allModulesByName = map[string]*Module{"dashboard": module}
+	mm.allModulesNamesInOrder = []string{"dashboard"}
+
+	// no release
+	values, err := mm.moduleBackfillValues(module)
+	assert.NoError(t, err)
+	assert.Nil(t, values)
+
+	helmClient.releaseValues = utils.Values{
+		"global":    map[string]interface{}{"project": "test"},
+		"dashboard": map[string]interface{}{"replicas": 3.0, "image": "dashboard", "internal": map[string]interface{}{"token": "xxx"}},
+	}
+	values, err = mm.moduleBackfillValues(module)
+	assert.NoError(t, err)
+	assert.Equal(t, utils.Values{"dashboard": map[string]interface{}{"replicas": 3.0}}, values)
+
+	// release without customizations
+	helmClient.releaseValues = utils.Values{"dashboard": map[string]interface{}{"replicas": 1}}
+	values, err = mm.moduleBackfillValues(module)
+	assert.NoError(t, err)
+	assert.Nil(t, values)
+}
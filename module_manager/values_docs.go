@@ -0,0 +1,185 @@
+package module_manager
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/flant/antiopa/utils"
+)
+
+// ValueDoc describes one configurable value of a module
+type ValueDoc struct {
+	Path        string      `json:"path"`
+	Type        string      `json:"type,omitempty"`
+	Default     interface{} `json:"default,omitempty"`
+	Description string      `json:"description,omitempty"`
+}
+
+// ModuleValuesDoc is a list of values of a module or of global values
+type ModuleValuesDoc struct {
+	Module    string     `json:"module"`
+	ValuesKey string     `json:"valuesKey"`
+	Values    []ValueDoc `json:"values"`
+}
+
+// GenerateValuesDocs loads modules from working dir and describes values from
+// openapi/values.yaml schemas and defaults from values.yaml files.
+func GenerateValuesDocs(workingDir string) ([]ModuleValuesDoc, error) {
+	WorkingDir = workingDir
+
+	mm := NewMainModuleManager(nil, nil)
+	if err := mm.initModulesIndex(); err != nil {
+		return nil, err
+	}
+
+	docs := make([]ModuleValuesDoc, 0)
+
+	globalValues, _ := mm.valuesStorage.GlobalStaticValues()["global"].(map[string]interface{})
+	if len(globalValues) > 0 {
+		docs = append(docs, ModuleValuesDoc{
+			Module:    "global",
+			ValuesKey: "global",
+			Values:    describeValues("global", nil, globalValues),
+		})
+	}
+
+	for _, moduleName := range mm.allModulesNamesInOrder {
+		module := mm.allModulesByName[moduleName]
+		valuesKey := module.moduleValuesKey()
+
+		var defaults map[string]interface{}
+		if module.StaticConfig != nil {
+			defaults, _ = module.StaticConfig.Values[valuesKey].(map[string]interface{})
+		}
+
+		docs = append(docs, ModuleValuesDoc{
+			Module:    moduleName,
+			ValuesKey: valuesKey,
+			Values:    describeValues(valuesKey, module.ValuesSchema, defaults),
+		})
+	}
+
+	return docs, nil
+}
+
+// describeValues merges properties from schema and defaults into a list sorted by path
+func describeValues(prefix string, schema map[string]interface{}, defaults map[string]interface{}) []ValueDoc {
+	docsByPath := make(map[string]*ValueDoc)
+
+	describeSchema(docsByPath, prefix, schema)
+	describeDefaults(docsByPath, prefix, defaults)
+
+	res := make([]ValueDoc, 0, len(docsByPath))
+	for _, doc := range docsByPath {
+		res = append(res, *doc)
+	}
+	sort.Slice(res, func(i, j int) bool {
+		return res[i].Path < res[j].Path
+	})
+	return res
+}
+
+func describeSchema(docsByPath map[string]*ValueDoc, path string, schema map[string]interface{}) {
+	properties, _ := schema["properties"].(map[string]interface{})
+	for name, rawPropSchema := range properties {
+		propSchema, ok := rawPropSchema.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		propPath := path + "." + name
+
+		doc := &ValueDoc{Path: propPath}
+		doc.Type, _ = propSchema["type"].(string)
+		doc.Description, _ = propSchema["description"].(string)
+		doc.Default = propSchema["default"]
+		docsByPath[propPath] = doc
+
+		describeSchema(docsByPath, propPath, propSchema)
+
+		if items, ok := propSchema["items"].(map[string]interface{}); ok {
+			describeSchema(docsByPath, propPath+"[]", items)
+		}
+	}
+}
+
+// describeDefaults adds defaults from values.yaml, default from schema has priority
+func describeDefaults(docsByPath map[string]*ValueDoc, path string, defaults map[string]interface{}) {
+	for name, value := range defaults {
+		valuePath := path + "." + name
+
+		doc, hasDoc := docsByPath[valuePath]
+		if !hasDoc {
+			doc = &ValueDoc{Path: valuePath}
+			docsByPath[valuePath] = doc
+		}
+		if doc.Type == "" {
+			doc.Type = valueType(value)
+		}
+
+		if nested, ok := value.(map[string]interface{}); ok {
+			describeDefaults(docsByPath, valuePath, nested)
+			continue
+		}
+
+		if doc.Default == nil {
+			doc.Default = utils.DeepCopyValue(value)
+		}
+	}
+}
+
+// valueType returns OpenAPI type of json compatible value
+func valueType(value interface{}) string {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		return "object"
+	case []interface{}:
+		return "array"
+	case string:
+		return "string"
+	case bool:
+		return "boolean"
+	case int, int64:
+		return "integer"
+	case float64:
+		if v == float64(int64(v)) {
+			return "integer"
+		}
+		return "number"
+	}
+	return ""
+}
+
+// ValuesDocsMarkdown formats docs as Markdown tables, one table per module
+func ValuesDocsMarkdown(docs []ModuleValuesDoc) string {
+	buf := &bytes.Buffer{}
+	buf.WriteString("# Modules values\n")
+
+	for _, moduleDoc := range docs {
+		fmt.Fprintf(buf, "\n## %s\n\n", moduleDoc.Module)
+		if len(moduleDoc.Values) == 0 {
+			buf.WriteString("No configurable values.\n")
+			continue
+		}
+
+		buf.WriteString("| Path | Type | Default | Description |\n")
+		buf.WriteString("|------|------|---------|-------------|\n")
+		for _, doc := range moduleDoc.Values {
+			defaultValue := ""
+			if doc.Default != nil {
+				data, _ := json.Marshal(doc.Default)
+				defaultValue = "`" + string(data) + "`"
+			}
+			fmt.Fprintf(buf, "| `%s` | %s | %s | %s |\n", doc.Path, doc.Type, defaultValue, markdownCell(doc.Description))
+		}
+	}
+
+	return buf.String()
+}
+
+func markdownCell(s string) string {
+	s = strings.Replace(s, "|", "\\|", -1)
+	return strings.Replace(strings.TrimSpace(s), "\n", " ", -1)
+}
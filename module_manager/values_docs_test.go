@@ -0,0 +1,51 @@
+package module_manager
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/flant/antiopa/utils"
+)
+
+func TestDescribeValues(t *testing.T) {
+	schema, err := utils.NewValuesFromBytes([]byte(`
+type: object
+properties:
+  replicas:
+    type: integer
+    default: 2
+    description: Number of pods
+  hosts:
+    type: array
+    items:
+      type: object
+      properties:
+        port:
+          type: integer
+`))
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	defaults, err := utils.NewValuesFromBytes([]byte(`
+replicas: 3
+image:
+  tag: v1.2
+`))
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	docs := describeValues("testModule", schema, defaults)
+	assert.Equal(t, []ValueDoc{
+		{Path: "testModule.hosts", Type: "array"},
+		{Path: "testModule.hosts[].port", Type: "integer"},
+		{Path: "testModule.image", Type: "object"},
+		{Path: "testModule.image.tag", Type: "string", Default: "v1.2"},
+		{Path: "testModule.replicas", Type: "integer", Default: 2.0, Description: "Number of pods"},
+	}, docs)
+
+	markdown := ValuesDocsMarkdown([]ModuleValuesDoc{{Module: "test-module", ValuesKey: "testModule", Values: docs}})
+	assert.Contains(t, markdown, "| `testModule.replicas` | integer | `2` | Number of pods |\n")
+}
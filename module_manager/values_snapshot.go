@@ -0,0 +1,104 @@
+package module_manager
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/romana/rlog"
+
+	"github.com/flant/antiopa/utils"
+	"github.com/flant/antiopa/version"
+)
+
+const ValuesSnapshotFormat = "antiopa-values/v1"
+
+// ValuesSnapshot is a state of values that is not stored in modules directory:
+// values from ConfigMap and dynamic values from hooks. It is used for backup and
+// migration of antiopa installation to another cluster.
+type ValuesSnapshot struct {
+	Format         string    `json:"format"`
+	AntiopaVersion string    `json:"antiopaVersion"`
+	CreatedAt      time.Time `json:"createdAt"`
+
+	KubeGlobalConfigValues      utils.Values                   `json:"kubeGlobalConfigValues"`
+	KubeModulesConfigValues     map[string]utils.Values        `json:"kubeModulesConfigValues"`
+	GlobalDynamicValuesPatches  []utils.ValuesPatch            `json:"globalDynamicValuesPatches"`
+	ModulesDynamicValuesPatches map[string][]utils.ValuesPatch `json:"modulesDynamicValuesPatches"`
+}
+
+// ExportValues returns a snapshot of values from ConfigMap and dynamic values
+func (mm *MainModuleManager) ExportValues() *ValuesSnapshot {
+	snapshot := &ValuesSnapshot{
+		Format:         ValuesSnapshotFormat,
+		AntiopaVersion: version.Version,
+		CreatedAt:      time.Now(),
+	}
+
+	snapshot.KubeGlobalConfigValues,
+		snapshot.KubeModulesConfigValues,
+		snapshot.GlobalDynamicValuesPatches,
+		snapshot.ModulesDynamicValuesPatches = mm.valuesStorage.Dump()
+
+	return snapshot
+}
+
+// ImportValues restores values from the snapshot. Values from ConfigMap are saved
+// into ConfigMap, dynamic values are replaced and all modules are rerun.
+// Values of unknown modules are skipped, values of modules absent in the snapshot are not changed.
+func (mm *MainModuleManager) ImportValues(snapshot *ValuesSnapshot) error {
+	if snapshot.Format != ValuesSnapshotFormat {
+		return fmt.Errorf("unsupported values snapshot format '%s', expected '%s'", snapshot.Format, ValuesSnapshotFormat)
+	}
+
+	rlog.Infof("MODULE_MANAGER import values snapshot from antiopa '%s' created at %s", snapshot.AntiopaVersion, snapshot.CreatedAt.String())
+
+	isKnownModule := func(moduleName string) bool {
+		if _, hasModule := mm.allModulesByName[moduleName]; hasModule {
+			return true
+		}
+		rlog.Warnf("MODULE_MANAGER import values: skip values of unknown module '%s'", moduleName)
+		return false
+	}
+
+	// dynamic values are applied first: modules are rerun after ConfigMap update
+	mm.valuesStorage.SetGlobalDynamicValuesPatches(snapshot.GlobalDynamicValuesPatches)
+	for moduleName, patches := range snapshot.ModulesDynamicValuesPatches {
+		if isKnownModule(moduleName) {
+			mm.valuesStorage.SetModuleDynamicValuesPatches(moduleName, patches)
+		}
+	}
+
+	if len(snapshot.KubeGlobalConfigValues) > 0 {
+		if err := mm.kubeConfigManager.SetKubeGlobalValues(snapshot.KubeGlobalConfigValues); err != nil {
+			return fmt.Errorf("cannot save global values into ConfigMap: %s", err)
+		}
+	}
+	for moduleName, values := range snapshot.KubeModulesConfigValues {
+		if !isKnownModule(moduleName) {
+			continue
+		}
+		if err := mm.kubeConfigManager.SetKubeModuleValues(moduleName, values); err != nil {
+			return fmt.Errorf("cannot save module '%s' values into ConfigMap: %s", moduleName, err)
+		}
+	}
+
+	// ConfigMap can be unchanged, so rerun is requested explicitly to apply dynamic values
+	mm.globalValuesChanged <- true
+
+	return nil
+}
+
+// CurrentValues returns effective values as hooks see them now: values of global hooks
+// if moduleName is empty or "global", values of module hooks otherwise
+func (mm *MainModuleManager) CurrentValues(moduleName string) (utils.Values, error) {
+	if moduleName == "" || moduleName == "global" {
+		return mm.globalHooksValues("values eval"), nil
+	}
+	module, err := mm.GetModule(moduleName)
+	if err != nil {
+		return nil, err
+	}
+	// stats of the last run are not changed
+	values, _ := module.constructValuesFrom(mm.valuesStorage, mm.enabledModulesInOrder)
+	return values, nil
+}
@@ -0,0 +1,37 @@
+package module_manager
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/flant/antiopa/utils"
+)
+
+func TestMainModuleManager_ExportImportValues(t *testing.T) {
+	src := NewMainModuleManager(nil, MockKubeConfigManager{})
+	src.valuesStorage.SetKubeGlobalConfigValues(utils.Values{"global": map[string]interface{}{"a": 1.0}})
+	src.valuesStorage.SetKubeModuleConfigValues("module", utils.Values{"module": map[string]interface{}{"b": 2.0}})
+	src.valuesStorage.AppendModuleDynamicValuesPatch("module", utils.ValuesPatch{Operations: []*utils.ValuesPatchOperation{
+		{Op: "add", Path: "/module/c", Value: 3.0},
+	}})
+	src.valuesStorage.AppendModuleDynamicValuesPatch("unknown", utils.ValuesPatch{Operations: []*utils.ValuesPatchOperation{
+		{Op: "add", Path: "/unknown/d", Value: 4.0},
+	}})
+
+	snapshot := src.ExportValues()
+	assert.Equal(t, ValuesSnapshotFormat, snapshot.Format)
+	assert.Equal(t, utils.Values{"module": map[string]interface{}{"b": 2.0}}, snapshot.KubeModulesConfigValues["module"])
+	assert.Len(t, snapshot.ModulesDynamicValuesPatches["module"], 1)
+
+	dst := NewMainModuleManager(nil, MockKubeConfigManager{})
+	dst.allModulesByName["module"] = &Module{Name: "module", moduleManager: dst}
+
+	assert.NoError(t, dst.ImportValues(snapshot))
+	assert.Equal(t, snapshot.ModulesDynamicValuesPatches["module"], dst.valuesStorage.ModuleDynamicValuesPatches("module"))
+	assert.Len(t, dst.valuesStorage.ModuleDynamicValuesPatches("unknown"), 0)
+	assert.True(t, <-dst.globalValuesChanged)
+
+	snapshot.Format = "unknown"
+	assert.Error(t, dst.ImportValues(snapshot))
+}
@@ -0,0 +1,74 @@
+package module_manager
+
+import (
+	"encoding/json"
+	"sync"
+	"time"
+
+	"github.com/flant/antiopa/utils"
+)
+
+// Layers of module values in order of merge
+const (
+	ValuesLayerGlobalStatic   = "globalStatic"
+	ValuesLayerGlobalExternal = "globalExternal"
+	ValuesLayerGlobalCluster  = "globalCluster"
+	ValuesLayerGlobalConfig   = "globalConfig"
+	ValuesLayerModuleStatic   = "moduleStatic"
+	ValuesLayerModuleExternal = "moduleExternal"
+	ValuesLayerModuleCluster  = "moduleCluster"
+	ValuesLayerGroupConfig    = "groupConfig"
+	ValuesLayerModuleConfig   = "moduleConfig"
+	ValuesLayerImported       = "imported"
+	ValuesLayerImageTags      = "imageTags"
+	ValuesLayerDynamic        = "dynamic"
+)
+
+// ValuesStats describes the last construction of module values: size of each layer
+// in bytes of JSON, duration of merge and duration of applying values schema.
+type ValuesStats struct {
+	LayersSizes    map[string]int
+	MergeDuration  time.Duration
+	SchemaDuration time.Duration
+}
+
+type valuesStatsRecorder struct {
+	m     sync.Mutex
+	stats *ValuesStats
+}
+
+func (r *valuesStatsRecorder) set(stats *ValuesStats) {
+	r.m.Lock()
+	r.stats = stats
+	r.m.Unlock()
+}
+
+func (r *valuesStatsRecorder) get() *ValuesStats {
+	r.m.Lock()
+	defer r.m.Unlock()
+	return r.stats
+}
+
+// ValuesStats returns stats of the last values construction of the module or nil
+func (m *Module) ValuesStats() *ValuesStats {
+	return m.valuesStats.get()
+}
+
+// valuesSize returns size of values in JSON
+func valuesSize(values interface{}) int {
+	data, err := json.Marshal(values)
+	if err != nil {
+		return 0
+	}
+	return len(data)
+}
+
+func valuesPatchesSize(patches ...[]utils.ValuesPatch) int {
+	size := 0
+	for _, list := range patches {
+		for _, patch := range list {
+			size += valuesSize(patch.Operations)
+		}
+	}
+	return size
+}
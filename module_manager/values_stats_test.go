@@ -0,0 +1,28 @@
+package module_manager
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/flant/antiopa/utils"
+)
+
+func TestModule_ValuesStats(t *testing.T) {
+	mm := NewMainModuleManager(&MockHelmClient{}, nil)
+
+	m := &Module{Name: "prometheus", moduleManager: mm, StaticConfig: utils.NewModuleConfig("prometheus")}
+	m.StaticConfig.Values = utils.Values{"prometheus": map[string]interface{}{"retention": "7d"}}
+	assert.Nil(t, m.ValuesStats())
+
+	m.values()
+
+	stats := m.ValuesStats()
+	if !assert.NotNil(t, stats) {
+		return
+	}
+	assert.Equal(t, len(`{"prometheus":{"retention":"7d"}}`), stats.LayersSizes[ValuesLayerModuleStatic])
+	assert.Equal(t, len(`{}`), stats.LayersSizes[ValuesLayerGlobalConfig])
+	assert.Equal(t, 0, stats.LayersSizes[ValuesLayerDynamic])
+	assert.Contains(t, stats.LayersSizes, ValuesLayerImported)
+}
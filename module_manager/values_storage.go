@@ -0,0 +1,332 @@
+package module_manager
+
+import (
+	"sync"
+
+	"github.com/flant/antiopa/utils"
+)
+
+// ValuesStorage keeps values of all modules: static values, values from ConfigMap
+// and patches from hooks. Storage is safe for concurrent use: getters return
+// copies and each change increments generation.
+type ValuesStorage struct {
+	m sync.RWMutex
+
+	generation uint64
+	// incremented on each change of dynamic values patches
+	dynamicGeneration uint64
+
+	// global static values from modules/values.yaml file
+	globalStaticValues utils.Values
+
+	// values для всех модулей, для конкретного кластера
+	kubeGlobalConfigValues utils.Values
+	// values для конкретного модуля, для конкретного кластера
+	kubeModulesConfigValues map[string]utils.Values
+
+	// Invariant: do not store patches that does not apply
+	// Give user error for patches early, after patch receive
+
+	// values для всех модулей, для конкретного инстанса antiopa-pod
+	globalDynamicValuesPatches []utils.ValuesPatch
+	// values для конкретного модуля, для конкретного инстанса antiopa-pod
+	modulesDynamicValuesPatches map[string][]utils.ValuesPatch
+
+	// values exported by modules after successful run
+	modulesExportedValues map[string]utils.Values
+
+	// values from external value sources: global and modules sections
+	externalValues utils.Values
+
+	// tags from imageTags key of the ConfigMap by image name
+	imageTags map[string]string
+
+	// values from group keys of the ConfigMap by group name
+	groupsValues map[string]utils.Values
+}
+
+func NewValuesStorage() *ValuesStorage {
+	return &ValuesStorage{
+		globalStaticValues:          make(utils.Values),
+		kubeGlobalConfigValues:      make(utils.Values),
+		kubeModulesConfigValues:     make(map[string]utils.Values),
+		globalDynamicValuesPatches:  make([]utils.ValuesPatch, 0),
+		modulesDynamicValuesPatches: make(map[string][]utils.ValuesPatch),
+		modulesExportedValues:       make(map[string]utils.Values),
+		externalValues:              make(utils.Values),
+		imageTags:                   make(map[string]string),
+		groupsValues:                make(map[string]utils.Values),
+	}
+}
+
+// Copy returns an independent copy of the storage, e.g. to calculate values with other config
+func (s *ValuesStorage) Copy() *ValuesStorage {
+	s.m.RLock()
+	defer s.m.RUnlock()
+
+	res := NewValuesStorage()
+	res.globalStaticValues = copyValues(s.globalStaticValues)
+	res.kubeGlobalConfigValues = copyValues(s.kubeGlobalConfigValues)
+	for moduleName, values := range s.kubeModulesConfigValues {
+		res.kubeModulesConfigValues[moduleName] = copyValues(values)
+	}
+	res.globalDynamicValuesPatches = copyPatches(s.globalDynamicValuesPatches)
+	for moduleName, patches := range s.modulesDynamicValuesPatches {
+		res.modulesDynamicValuesPatches[moduleName] = copyPatches(patches)
+	}
+	for moduleName, values := range s.modulesExportedValues {
+		res.modulesExportedValues[moduleName] = copyValues(values)
+	}
+	res.externalValues = copyValues(s.externalValues)
+	res.imageTags = copyImageTags(s.imageTags)
+	for groupName, values := range s.groupsValues {
+		res.groupsValues[groupName] = copyValues(values)
+	}
+	return res
+}
+
+func copyValues(values utils.Values) utils.Values {
+	if values == nil {
+		return nil
+	}
+	return utils.Values(utils.DeepCopyValue(map[string]interface{}(values)).(map[string]interface{}))
+}
+
+// Patches are not modified after they are stored, so only slice is copied
+func copyPatches(patches []utils.ValuesPatch) []utils.ValuesPatch {
+	if patches == nil {
+		return nil
+	}
+	res := make([]utils.ValuesPatch, len(patches))
+	copy(res, patches)
+	return res
+}
+
+// Generation is incremented on each change of the storage
+func (s *ValuesStorage) Generation() uint64 {
+	s.m.RLock()
+	defer s.m.RUnlock()
+	return s.generation
+}
+
+func (s *ValuesStorage) GlobalStaticValues() utils.Values {
+	s.m.RLock()
+	defer s.m.RUnlock()
+	return copyValues(s.globalStaticValues)
+}
+
+func (s *ValuesStorage) SetGlobalStaticValues(values utils.Values) {
+	s.m.Lock()
+	defer s.m.Unlock()
+	s.globalStaticValues = copyValues(values)
+	s.generation++
+}
+
+func (s *ValuesStorage) KubeGlobalConfigValues() utils.Values {
+	s.m.RLock()
+	defer s.m.RUnlock()
+	return copyValues(s.kubeGlobalConfigValues)
+}
+
+func (s *ValuesStorage) SetKubeGlobalConfigValues(values utils.Values) {
+	s.m.Lock()
+	defer s.m.Unlock()
+	s.kubeGlobalConfigValues = copyValues(values)
+	s.generation++
+}
+
+func (s *ValuesStorage) KubeModuleConfigValues(moduleName string) utils.Values {
+	s.m.RLock()
+	defer s.m.RUnlock()
+	return copyValues(s.kubeModulesConfigValues[moduleName])
+}
+
+func (s *ValuesStorage) HasKubeModuleConfigValues(moduleName string) bool {
+	s.m.RLock()
+	defer s.m.RUnlock()
+	_, hasValues := s.kubeModulesConfigValues[moduleName]
+	return hasValues
+}
+
+func (s *ValuesStorage) SetKubeModuleConfigValues(moduleName string, values utils.Values) {
+	s.m.Lock()
+	defer s.m.Unlock()
+	s.kubeModulesConfigValues[moduleName] = copyValues(values)
+	s.generation++
+}
+
+// SetKubeModulesConfigValues replaces config values for all modules
+func (s *ValuesStorage) SetKubeModulesConfigValues(modulesValues map[string]utils.Values) {
+	s.m.Lock()
+	defer s.m.Unlock()
+	s.kubeModulesConfigValues = make(map[string]utils.Values)
+	for moduleName, values := range modulesValues {
+		s.kubeModulesConfigValues[moduleName] = copyValues(values)
+	}
+	s.generation++
+}
+
+func (s *ValuesStorage) GlobalDynamicValuesPatches() []utils.ValuesPatch {
+	s.m.RLock()
+	defer s.m.RUnlock()
+	return copyPatches(s.globalDynamicValuesPatches)
+}
+
+func (s *ValuesStorage) SetGlobalDynamicValuesPatches(patches []utils.ValuesPatch) {
+	s.m.Lock()
+	defer s.m.Unlock()
+	s.globalDynamicValuesPatches = copyPatches(patches)
+	s.generation++
+	s.dynamicGeneration++
+}
+
+func (s *ValuesStorage) AppendGlobalDynamicValuesPatch(patch utils.ValuesPatch) {
+	s.m.Lock()
+	defer s.m.Unlock()
+	s.globalDynamicValuesPatches = utils.AppendValuesPatch(s.globalDynamicValuesPatches, patch)
+	s.generation++
+	s.dynamicGeneration++
+}
+
+func (s *ValuesStorage) ModuleDynamicValuesPatches(moduleName string) []utils.ValuesPatch {
+	s.m.RLock()
+	defer s.m.RUnlock()
+	return copyPatches(s.modulesDynamicValuesPatches[moduleName])
+}
+
+func (s *ValuesStorage) SetModuleDynamicValuesPatches(moduleName string, patches []utils.ValuesPatch) {
+	s.m.Lock()
+	defer s.m.Unlock()
+	s.modulesDynamicValuesPatches[moduleName] = copyPatches(patches)
+	s.generation++
+	s.dynamicGeneration++
+}
+
+func (s *ValuesStorage) AppendModuleDynamicValuesPatch(moduleName string, patch utils.ValuesPatch) {
+	s.m.Lock()
+	defer s.m.Unlock()
+	s.modulesDynamicValuesPatches[moduleName] = utils.AppendValuesPatch(s.modulesDynamicValuesPatches[moduleName], patch)
+	s.generation++
+	s.dynamicGeneration++
+}
+
+// DynamicValuesPatches returns copies of all dynamic patches and generation of dynamic values under one lock
+func (s *ValuesStorage) DynamicValuesPatches() (globalPatches []utils.ValuesPatch, modulesPatches map[string][]utils.ValuesPatch, generation uint64) {
+	s.m.RLock()
+	defer s.m.RUnlock()
+
+	globalPatches = copyPatches(s.globalDynamicValuesPatches)
+
+	modulesPatches = make(map[string][]utils.ValuesPatch)
+	for moduleName, patches := range s.modulesDynamicValuesPatches {
+		modulesPatches[moduleName] = copyPatches(patches)
+	}
+
+	return globalPatches, modulesPatches, s.dynamicGeneration
+}
+
+// ModuleExportedValues returns values exported by the module, nil if module has not exported values yet
+func (s *ValuesStorage) ModuleExportedValues(moduleName string) utils.Values {
+	s.m.RLock()
+	defer s.m.RUnlock()
+	return copyValues(s.modulesExportedValues[moduleName])
+}
+
+func (s *ValuesStorage) SetModuleExportedValues(moduleName string, values utils.Values) {
+	s.m.Lock()
+	defer s.m.Unlock()
+	s.modulesExportedValues[moduleName] = copyValues(values)
+	s.generation++
+}
+
+func (s *ValuesStorage) DeleteModuleExportedValues(moduleName string) {
+	s.m.Lock()
+	defer s.m.Unlock()
+	delete(s.modulesExportedValues, moduleName)
+	s.generation++
+}
+
+// Dump returns copies of values from ConfigMap and dynamic patches under one lock
+func (s *ValuesStorage) Dump() (kubeGlobalConfigValues utils.Values, kubeModulesConfigValues map[string]utils.Values, globalPatches []utils.ValuesPatch, modulesPatches map[string][]utils.ValuesPatch) {
+	s.m.RLock()
+	defer s.m.RUnlock()
+
+	kubeGlobalConfigValues = copyValues(s.kubeGlobalConfigValues)
+
+	kubeModulesConfigValues = make(map[string]utils.Values)
+	for moduleName, values := range s.kubeModulesConfigValues {
+		kubeModulesConfigValues[moduleName] = copyValues(values)
+	}
+
+	globalPatches = copyPatches(s.globalDynamicValuesPatches)
+
+	modulesPatches = make(map[string][]utils.ValuesPatch)
+	for moduleName, patches := range s.modulesDynamicValuesPatches {
+		modulesPatches[moduleName] = copyPatches(patches)
+	}
+
+	return
+}
+
+// ExternalValuesSection returns a section of values from value sources by the values key
+// ("global" or module values key). Empty values are returned if sources have no section.
+func (s *ValuesStorage) ExternalValuesSection(key string) utils.Values {
+	s.m.RLock()
+	defer s.m.RUnlock()
+	section, hasSection := s.externalValues[key]
+	if !hasSection {
+		return utils.Values{}
+	}
+	return copyValues(utils.Values{key: section})
+}
+
+func (s *ValuesStorage) ExternalValues() utils.Values {
+	s.m.RLock()
+	defer s.m.RUnlock()
+	return copyValues(s.externalValues)
+}
+
+func (s *ValuesStorage) SetExternalValues(values utils.Values) {
+	s.m.Lock()
+	defer s.m.Unlock()
+	s.externalValues = copyValues(values)
+	s.generation++
+}
+
+func (s *ValuesStorage) ImageTags() map[string]string {
+	s.m.RLock()
+	defer s.m.RUnlock()
+	return copyImageTags(s.imageTags)
+}
+
+func (s *ValuesStorage) SetImageTags(tags map[string]string) {
+	s.m.Lock()
+	defer s.m.Unlock()
+	s.imageTags = copyImageTags(tags)
+	s.generation++
+}
+
+// GroupValues returns values from the ConfigMap key of the group
+func (s *ValuesStorage) GroupValues(groupName string) utils.Values {
+	s.m.RLock()
+	defer s.m.RUnlock()
+	return copyValues(s.groupsValues[groupName])
+}
+
+func (s *ValuesStorage) SetGroupsValues(groupsValues map[string]utils.Values) {
+	s.m.Lock()
+	defer s.m.Unlock()
+	s.groupsValues = make(map[string]utils.Values, len(groupsValues))
+	for groupName, values := range groupsValues {
+		s.groupsValues[groupName] = copyValues(values)
+	}
+	s.generation++
+}
+
+func copyImageTags(tags map[string]string) map[string]string {
+	res := make(map[string]string, len(tags))
+	for image, tag := range tags {
+		res[image] = tag
+	}
+	return res
+}
@@ -0,0 +1,38 @@
+package module_manager
+
+import (
+	"reflect"
+	"testing"
+
+	"github.com/flant/antiopa/utils"
+)
+
+func TestValuesStorage_CopyOnRead(t *testing.T) {
+	s := NewValuesStorage()
+
+	values := utils.Values{"global": map[string]interface{}{"a": 1.0}}
+	s.SetKubeGlobalConfigValues(values)
+	generation := s.Generation()
+
+	// changes in the source and in the result do not affect storage
+	values["global"].(map[string]interface{})["a"] = 2.0
+	res := s.KubeGlobalConfigValues()
+	res["global"].(map[string]interface{})["b"] = 3.0
+
+	expected := utils.Values{"global": map[string]interface{}{"a": 1.0}}
+	if !reflect.DeepEqual(expected, s.KubeGlobalConfigValues()) {
+		t.Errorf("\n[EXPECTED]: %#v\n[GOT]: %#v", expected, s.KubeGlobalConfigValues())
+	}
+
+	if s.Generation() != generation {
+		t.Errorf("Generation should not be changed on read: %d != %d", generation, s.Generation())
+	}
+
+	s.SetKubeModuleConfigValues("module", utils.Values{"module": map[string]interface{}{}})
+	if s.Generation() <= generation {
+		t.Errorf("Generation should be incremented on write: %d <= %d", s.Generation(), generation)
+	}
+	if !s.HasKubeModuleConfigValues("module") || s.HasKubeModuleConfigValues("other-module") {
+		t.Errorf("Unexpected kube module config values: %#v", s.kubeModulesConfigValues)
+	}
+}
@@ -0,0 +1,41 @@
+package module_manager
+
+import (
+	"fmt"
+	"os"
+	"strings"
+
+	"github.com/romana/rlog"
+
+	"github.com/flant/antiopa/utils"
+)
+
+// Templates in values (see utils.ValuesTemplatePrefix) are evaluated when effective values
+// of a global hook or a module are constructed, after dynamic patches are applied. So ref
+// can copy values discovered by hooks, but only within global and module sections. Config
+// values are passed to hooks as is: hooks patch them and patches are saved into ConfigMap.
+
+// valuesTemplatesLookupEnv returns variables for env function of values templates. Only
+// variables that are passed to hooks are available, so templates cannot read secrets of antiopa.
+func valuesTemplatesLookupEnv(name string) (string, error) {
+	for _, env := range hooksEnviron() {
+		parts := strings.SplitN(env, "=", 2)
+		if parts[0] == name && len(parts) == 2 {
+			return parts[1], nil
+		}
+	}
+	if _, has := os.LookupEnv(name); has {
+		return "", fmt.Errorf("variable '%s' is not passed to hooks, add it to -hooks-env", name)
+	}
+	return "", nil
+}
+
+// evaluateValuesTemplates returns values with evaluated templates. Errors are logged and
+// templates with errors are left as is.
+func evaluateValuesTemplates(values utils.Values, owner string) utils.Values {
+	res, err := utils.EvaluateValuesTemplates(values, valuesTemplatesLookupEnv)
+	if err != nil {
+		rlog.Errorf("MODULE_MANAGER values templates of %s: %s", owner, err)
+	}
+	return res
+}
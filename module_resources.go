@@ -0,0 +1,44 @@
+package main
+
+import (
+	"k8s.io/api/core/v1"
+
+	"github.com/flant/antiopa/helm"
+)
+
+// SendResourcesTotalsMetrics sends requests and limits of pods in the module release, cpu is in cores
+func SendResourcesTotalsMetrics(moduleName string, totals *helm.ResourcesTotals) {
+	if totals == nil {
+		return
+	}
+
+	send := func(metric string, resources v1.ResourceList) {
+		for name, quantity := range resources {
+			value := float64(quantity.Value())
+			if name == v1.ResourceCPU {
+				value = float64(quantity.MilliValue()) / 1000
+			}
+			MetricsStorage.SendGaugeMetric(metric, value, map[string]string{"module": moduleName, "resource": string(name)})
+		}
+	}
+	send("antiopa_module_resource_requests", totals.Requests)
+	send("antiopa_module_resource_limits", totals.Limits)
+	send("antiopa_module_resource_per_node_requests", totals.PerNodeRequests)
+	send("antiopa_module_resource_per_node_limits", totals.PerNodeLimits)
+	MetricsStorage.SendGaugeMetric("antiopa_module_pods", float64(totals.Pods), map[string]string{"module": moduleName})
+	MetricsStorage.SendGaugeMetric("antiopa_module_per_node_pods", float64(totals.PerNodePods), map[string]string{"module": moduleName})
+}
+
+// SendDeprecatedApisMetrics sends the number of objects with deprecated API versions in the module release
+// and the number of objects that break on the next upgrade of the cluster
+func SendDeprecatedApisMetrics(moduleName string, usages []helm.DeprecatedApiUsage) {
+	removedInNextUpgrade := 0
+	for _, usage := range usages {
+		if usage.Removed || usage.RemovedInNextUpgrade {
+			removedInNextUpgrade++
+		}
+	}
+	labels := map[string]string{"module": moduleName}
+	MetricsStorage.SendGaugeMetric("antiopa_module_deprecated_apis", float64(len(usages)), labels)
+	MetricsStorage.SendGaugeMetric("antiopa_module_removed_apis_next_upgrade", float64(removedInNextUpgrade), labels)
+}
@@ -0,0 +1,257 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/romana/rlog"
+
+	"github.com/flant/antiopa/state_store"
+	"github.com/flant/antiopa/task"
+)
+
+// FailedTaskMaxDelay limits the exponential backoff of failed tasks: a delay before
+// the retry is FailedModuleDelay or FailedHookDelay doubled after each failure.
+var FailedTaskMaxDelay = 5 * time.Minute
+
+// FailedModuleRequeueAfter is a number of consecutive failures of ModuleRun after which
+// the task is removed from the queue and retried later, so a failing module does not block
+// runs of other modules. Failed run is retried at the head of the queue if 0.
+var FailedModuleRequeueAfter = 3
+
+// Period of checking retries of failed module runs
+var ModuleRunRetriesCheckPeriod = time.Second
+
+// ModuleRunRetriesStorageName is a ConfigMap (or other state storage) to keep retries and
+// module runs in progress between restarts, they are kept only in memory if empty.
+var ModuleRunRetriesStorageName = "antiopa-module-runs"
+
+const moduleRunRetriesStorageKey = "module-runs.json"
+
+// failedTaskDelay returns a delay before the retry of the task that failed failureCount times
+func failedTaskDelay(baseDelay time.Duration, failureCount int) time.Duration {
+	delay := baseDelay
+	for i := 1; i < failureCount && delay < FailedTaskMaxDelay; i++ {
+		delay *= 2
+	}
+	if delay > FailedTaskMaxDelay {
+		delay = FailedTaskMaxDelay
+	}
+	return delay
+}
+
+// ModuleRunRetry is a failed ModuleRun task waiting for the retry outside of the queue
+type ModuleRunRetry struct {
+	Module   string    `json:"module"`
+	Cause    string    `json:"cause"`
+	Urgent   bool      `json:"urgent"`
+	Failures int       `json:"failures"`
+	Error    string    `json:"error"`
+	RetryAt  time.Time `json:"retryAt"`
+}
+
+// ModuleRunRetries keeps failed ModuleRun tasks removed from the queue. One retry per module is kept.
+// Retries and module runs in progress are saved into the store, so runs interrupted by restart
+// and pending retries are queued again by the next antiopa process with their failure counts.
+type ModuleRunRetries struct {
+	m       sync.Mutex
+	retries map[string]*ModuleRunRetry
+	// module runs in progress, Error and RetryAt are not used
+	running map[string]*ModuleRunRetry
+	// nil store keeps retries in memory
+	store  state_store.Store
+	saveCh chan struct{}
+}
+
+// moduleRunRetriesState is saved into the store
+type moduleRunRetriesState struct {
+	Retries []ModuleRunRetry `json:"retries"`
+	Running []ModuleRunRetry `json:"running"`
+}
+
+func NewModuleRunRetries() *ModuleRunRetries {
+	return &ModuleRunRetries{
+		retries: make(map[string]*ModuleRunRetry),
+		running: make(map[string]*ModuleRunRetry),
+	}
+}
+
+// Start records the module run in progress until Finish
+func (r *ModuleRunRetries) Start(t task.Task) {
+	r.m.Lock()
+	defer r.m.Unlock()
+
+	r.running[t.GetName()] = &ModuleRunRetry{
+		Module:   t.GetName(),
+		Cause:    t.GetCause(),
+		Urgent:   t.GetUrgent(),
+		Failures: t.GetFailureCount(),
+	}
+	r.save()
+}
+
+// Finish is called when the module run is completed successfully or with an error
+func (r *ModuleRunRetries) Finish(moduleName string) {
+	r.m.Lock()
+	defer r.m.Unlock()
+
+	delete(r.running, moduleName)
+	r.save()
+}
+
+// Retry saves the failed task until the backoff delay is over
+func (r *ModuleRunRetries) Retry(t task.Task, err error, now time.Time) *ModuleRunRetry {
+	r.m.Lock()
+	defer r.m.Unlock()
+
+	retry := &ModuleRunRetry{
+		Module:   t.GetName(),
+		Cause:    t.GetCause(),
+		Urgent:   t.GetUrgent(),
+		Failures: t.GetFailureCount(),
+		Error:    err.Error(),
+		RetryAt:  now.Add(failedTaskDelay(FailedModuleDelay, t.GetFailureCount())),
+	}
+	r.retries[t.GetName()] = retry
+	r.save()
+	return retry
+}
+
+// Forget removes the retry of the module, e.g. after successful run triggered by another event
+func (r *ModuleRunRetries) Forget(moduleName string) {
+	r.m.Lock()
+	defer r.m.Unlock()
+
+	delete(r.retries, moduleName)
+	r.save()
+}
+
+// Dump returns retries sorted by module name
+func (r *ModuleRunRetries) Dump() []ModuleRunRetry {
+	r.m.Lock()
+	defer r.m.Unlock()
+
+	res := make([]ModuleRunRetry, 0, len(r.retries))
+	for _, retry := range r.retries {
+		res = append(res, *retry)
+	}
+	sort.Slice(res, func(i, j int) bool {
+		return res[i].Module < res[j].Module
+	})
+	return res
+}
+
+// Run periodically queues retries with passed delays
+func (r *ModuleRunRetries) Run() {
+	ticker := time.NewTicker(ModuleRunRetriesCheckPeriod)
+	for range ticker.C {
+		for _, t := range r.due(time.Now()) {
+			TasksQueue.Add(t)
+			rlog.Infof("QUEUE add ModuleRun %s: retry after %d failures", t.GetName(), t.GetFailureCount())
+		}
+	}
+}
+
+// due returns tasks for retries with passed delays, the failure count is kept for the backoff
+func (r *ModuleRunRetries) due(now time.Time) []task.Task {
+	r.m.Lock()
+	defer r.m.Unlock()
+
+	MetricsStorage.SendGaugeMetric("antiopa_module_run_retries", float64(len(r.retries)), map[string]string{})
+
+	res := make([]task.Task, 0)
+	for moduleName, retry := range r.retries {
+		if now.Before(retry.RetryAt) {
+			continue
+		}
+		delete(r.retries, moduleName)
+		newTask := task.NewTask(task.ModuleRun, moduleName).
+			WithCause(retry.Cause).
+			WithUrgent(retry.Urgent)
+		newTask.FailureCount = retry.Failures
+		res = append(res, newTask)
+	}
+	if len(res) > 0 {
+		r.save()
+	}
+	sort.Slice(res, func(i, j int) bool {
+		return res[i].GetName() < res[j].GetName()
+	})
+	return res
+}
+
+// Restore loads retries saved by the previous antiopa process. Module runs that were in progress
+// are retried at once with their failure counts, pending retries keep their time.
+func (r *ModuleRunRetries) Restore(store state_store.Store, now time.Time) error {
+	data, err := store.Load()
+	if err != nil {
+		return fmt.Errorf("cannot load module runs: %s", err)
+	}
+
+	r.m.Lock()
+	defer r.m.Unlock()
+	r.store = store
+	r.saveCh = make(chan struct{}, 1)
+	go r.runSaves()
+	if data == nil {
+		return nil
+	}
+
+	state := moduleRunRetriesState{}
+	if err := json.Unmarshal(data, &state); err != nil {
+		return fmt.Errorf("bad saved module runs: %s", err)
+	}
+	for i := range state.Retries {
+		retry := state.Retries[i]
+		r.retries[retry.Module] = &retry
+	}
+	for i := range state.Running {
+		retry := state.Running[i]
+		retry.Error = "run is interrupted by restart"
+		retry.RetryAt = now
+		r.retries[retry.Module] = &retry
+		rlog.Infof("QUEUE ModuleRun %s was interrupted by restart, retry it", retry.Module)
+	}
+	rlog.Infof("MAIN %d module runs retries are restored from %s", len(r.retries), store)
+	r.save()
+	return nil
+}
+
+// save signals runSaves to save the state, r.m should be locked
+func (r *ModuleRunRetries) save() {
+	if r.saveCh == nil {
+		return
+	}
+	select {
+	case r.saveCh <- struct{}{}:
+	default:
+		// save is already pending
+	}
+}
+
+func (r *ModuleRunRetries) runSaves() {
+	for range r.saveCh {
+		r.m.Lock()
+		state := moduleRunRetriesState{
+			Retries: make([]ModuleRunRetry, 0, len(r.retries)),
+			Running: make([]ModuleRunRetry, 0, len(r.running)),
+		}
+		for _, retry := range r.retries {
+			state.Retries = append(state.Retries, *retry)
+		}
+		for _, running := range r.running {
+			state.Running = append(state.Running, *running)
+		}
+		data, err := json.Marshal(state)
+		r.m.Unlock()
+		if err == nil {
+			err = r.store.Save(data)
+		}
+		if err != nil {
+			rlog.Errorf("MAIN cannot save module runs into %s: %s", r.store, err)
+		}
+	}
+}
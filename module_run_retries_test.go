@@ -0,0 +1,105 @@
+package main
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/flant/antiopa/state_store"
+	"github.com/flant/antiopa/task"
+)
+
+func TestFailedTaskDelay(t *testing.T) {
+	defer func(maxDelay time.Duration) { FailedTaskMaxDelay = maxDelay }(FailedTaskMaxDelay)
+	FailedTaskMaxDelay = time.Minute
+
+	assert.Equal(t, 5*time.Second, failedTaskDelay(5*time.Second, 1))
+	assert.Equal(t, 10*time.Second, failedTaskDelay(5*time.Second, 2))
+	assert.Equal(t, 40*time.Second, failedTaskDelay(5*time.Second, 4))
+	assert.Equal(t, time.Minute, failedTaskDelay(5*time.Second, 5))
+	assert.Equal(t, time.Minute, failedTaskDelay(5*time.Second, 100))
+}
+
+func TestModuleRunRetries(t *testing.T) {
+	defer func(delay time.Duration) { FailedModuleDelay = delay }(FailedModuleDelay)
+	defer func(maxDelay time.Duration) { FailedTaskMaxDelay = maxDelay }(FailedTaskMaxDelay)
+	FailedModuleDelay = 5 * time.Second
+	FailedTaskMaxDelay = time.Minute
+
+	retries := NewModuleRunRetries()
+	now := time.Now()
+
+	failed := task.NewTask(task.ModuleRun, "nginx").WithCause("startup").WithUrgent(true)
+	for i := 0; i < 3; i++ {
+		failed.IncrementFailureCount()
+	}
+	retry := retries.Retry(failed, fmt.Errorf("helm upgrade failed"), now)
+	assert.Equal(t, now.Add(20*time.Second), retry.RetryAt)
+
+	assert.Len(t, retries.due(now.Add(10*time.Second)), 0)
+
+	due := retries.due(now.Add(20 * time.Second))
+	if assert.Len(t, due, 1) {
+		assert.Equal(t, "nginx", due[0].GetName())
+		assert.Equal(t, 3, due[0].GetFailureCount())
+		assert.True(t, due[0].GetUrgent())
+	}
+	assert.Len(t, retries.Dump(), 0)
+}
+
+func TestModuleRunRetries_Restore(t *testing.T) {
+	dir, err := ioutil.TempDir("", "module-runs")
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer os.RemoveAll(dir)
+	store := &state_store.FileStore{Path: filepath.Join(dir, "module-runs.json")}
+	now := time.Now()
+
+	retries := NewModuleRunRetries()
+	assert.NoError(t, retries.Restore(store, now))
+
+	failed := task.NewTask(task.ModuleRun, "nginx").WithCause("startup")
+	for i := 0; i < 3; i++ {
+		failed.IncrementFailureCount()
+	}
+	retry := retries.Retry(failed, fmt.Errorf("helm upgrade failed"), now)
+
+	// antiopa is restarted during the run of prometheus
+	running := task.NewTask(task.ModuleRun, "prometheus").WithCause("module values changed")
+	running.IncrementFailureCount()
+	retries.Start(running)
+	retries.Start(task.NewTask(task.ModuleRun, "dashboard"))
+	retries.Finish("dashboard")
+
+	// wait for background save
+	var restored *ModuleRunRetries
+	for i := 0; i < 100; i++ {
+		restored = NewModuleRunRetries()
+		assert.NoError(t, restored.Restore(store, now))
+		if len(restored.Dump()) == 2 {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	dump := restored.Dump()
+	if !assert.Len(t, dump, 2) {
+		return
+	}
+	assert.Equal(t, "nginx", dump[0].Module)
+	assert.Equal(t, retry.RetryAt.Unix(), dump[0].RetryAt.Unix())
+	assert.Equal(t, 3, dump[0].Failures)
+
+	// interrupted run is retried at once with its failure count
+	due := restored.due(now)
+	if assert.Len(t, due, 1) {
+		assert.Equal(t, "prometheus", due[0].GetName())
+		assert.Equal(t, "module values changed", due[0].GetCause())
+		assert.Equal(t, 1, due[0].GetFailureCount())
+	}
+}
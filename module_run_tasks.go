@@ -0,0 +1,153 @@
+package main
+
+import (
+	"time"
+
+	"github.com/romana/rlog"
+
+	"github.com/flant/antiopa/approval"
+	"github.com/flant/antiopa/helm"
+	"github.com/flant/antiopa/module_manager"
+	"github.com/flant/antiopa/task"
+)
+
+// runModuleTask runs the module of ModuleRun task. The task is removed from the queue if the module
+// is converged, deferred or the failed module is retried out of the queue. Failed task is kept
+// in the queue and the delay before its retry is returned with the error of the run.
+func runModuleTask(queue *task.TasksQueue, t task.Task) (retryDelay time.Duration, err error) {
+	module, _ := ModuleManager.GetModule(t.GetName())
+	if module != nil && !t.GetUrgent() && !ConvergeOnce && !module.IsInMaintenanceWindow(time.Now()) {
+		nextWindow := module.MaintenanceWindows().NextOpen(time.Now())
+		rlog.Infof("TASK_RUN [%s] ModuleRun %s: deferred until maintenance window at %s", t.GetCorrelationId(), t.GetName(), nextWindow.Format(time.RFC3339))
+		moduleQueueLog(t.GetName()).Recordf("INFO", "task %s: run is deferred until maintenance window at %s", t.GetCorrelationId(), nextWindow.Format(time.RFC3339))
+		DeferredRuns.Defer(t, nextWindow)
+		queue.Remove(t.GetId())
+		return 0, nil
+	}
+	rlog.Infof("TASK_RUN [%s] ModuleRun %s", t.GetCorrelationId(), t.GetName())
+	moduleQueueLog(t.GetName()).Recordf("INFO", "task %s: run module, cause: %s", t.GetCorrelationId(), t.GetCause())
+	var valuesChanges []string
+	if module != nil {
+		valuesChanges = module.ValuesChangesSinceLastRun()
+	}
+	startedAt := time.Now()
+	ReleaseWatcher.Suspend(t.GetName())
+	// run is retried by the next antiopa process if it is interrupted by restart
+	ModuleRetries.Start(t)
+	err = ModuleManager.RunModule(t.GetName(), t.GetOnStartupHooks(), t.GetCorrelationId())
+	ModuleRetries.Finish(t.GetName())
+	if waitErr, ok := err.(*module_manager.ErrHelmUpgradeWaiting); ok {
+		// other tasks are run while helm upgrade waits
+		queue.Remove(t.GetId())
+		HelmUpgradeWaits.Wait(t, waitErr)
+		rlog.Infof("TASK_RUN [%s] ModuleRun %s: %s, check again at %s", t.GetCorrelationId(), t.GetName(), waitErr, waitErr.RetryAt.Format(time.RFC3339))
+		moduleQueueLog(t.GetName()).Recordf("INFO", "task %s: %s, check again at %s", t.GetCorrelationId(), waitErr, waitErr.RetryAt.Format(time.RFC3339))
+		return 0, nil
+	}
+	SendModuleRunMetrics(t.GetName(), startedAt, err)
+	var releaseUpgrade *helm.ReleaseUpgradeResult
+	if err == nil && module != nil {
+		releaseUpgrade = module.LastRunReleaseUpgrade()
+		SendReleaseUpgradeMetrics(t.GetName(), releaseUpgrade)
+		SendValuesStatsMetrics(t.GetName(), module.ValuesStats())
+		SendResourcesTotalsMetrics(t.GetName(), module.ResourcesTotals())
+		SendDeprecatedApisMetrics(t.GetName(), module.DeprecatedApis())
+	}
+	RecordModuleTask(t, startedAt, valuesChanges, releaseUpgrade, err)
+	RecordModuleHealth(t, err)
+	if err != nil {
+		if t.IsCancelled() {
+			rlog.Infof("TASK_RUN %s '%s' is cancelled", t.GetType(), t.GetName())
+			queue.Remove(t.GetId())
+			return 0, err
+		}
+		if ApiserverBreaker.Trip(err) {
+			return 0, err
+		}
+		MetricsStorage.SendCounterMetric("antiopa_module_run_errors", 1.0, map[string]string{"module": t.GetName()})
+		t.IncrementFailureCount()
+		CheckConvergeOnceFailure(t, err)
+		if FailedModuleRequeueAfter > 0 && t.GetFailureCount() >= FailedModuleRequeueAfter {
+			// other modules are run while the failed module waits for the retry
+			retry := ModuleRetries.Retry(t, err, time.Now())
+			queue.Remove(t.GetId())
+			rlog.Errorf("TASK_RUN [%s] %s '%s' failed. Failed count is %d, will retry at %s out of the queue. Error: %s", t.GetCorrelationId(), t.GetType(), t.GetName(), t.GetFailureCount(), retry.RetryAt.Format(time.RFC3339), err)
+			moduleQueueLog(t.GetName()).Recordf("ERROR", "task %s: run failed %d times, retry at %s: %s", t.GetCorrelationId(), t.GetFailureCount(), retry.RetryAt.Format(time.RFC3339), err)
+			return 0, err
+		}
+		delay := failedTaskDelay(FailedModuleDelay, t.GetFailureCount())
+		rlog.Errorf("TASK_RUN [%s] %s '%s' failed. Will retry after delay. Failed count is %d. Error: %s", t.GetCorrelationId(), t.GetType(), t.GetName(), t.GetFailureCount(), err)
+		moduleQueueLog(t.GetName()).Recordf("ERROR", "task %s: run failed %d times, retry after %s: %s", t.GetCorrelationId(), t.GetFailureCount(), delay, err)
+		return delay, err
+	}
+
+	queue.Remove(t.GetId())
+	moduleQueueLog(t.GetName()).Recordf("INFO", "task %s: module is converged in %s", t.GetCorrelationId(), time.Since(startedAt))
+	// module is converged, deferred run and retry are not needed anymore
+	DeferredRuns.Forget(t.GetName())
+	ModuleRetries.Forget(t.GetName())
+	HelmUpgradeWaits.Forget(t.GetName())
+	// module is enabled again, its release should not be deleted
+	approval.Forget(approval.DeleteReleaseKey(t.GetName()))
+	HeldHookRuns.QueueReady()
+	if module != nil {
+		AddonsReports.UpdateModuleNotes(module, releaseUpgrade)
+		ReportChartVersionChange(module, t.GetCorrelationId())
+	}
+	if err := ReleaseWatcher.WatchModule(t.GetName(), ModuleManager, KubeEventsManager); err != nil {
+		rlog.Errorf("TASK_RUN [%s] %s '%s': cannot watch release resources: %s", t.GetCorrelationId(), t.GetType(), t.GetName(), err)
+	}
+	return 0, nil
+}
+
+// runModuleTasksInParallel runs consecutive ModuleRun tasks from the head of the queue with
+// RunModulesGraph: independent modules are run concurrently, dependent modules after their
+// dependencies. Failed tasks and tasks of modules with failed dependencies are kept in the
+// queue in their order, the longest delay of failed tasks is pushed before their retry.
+func runModuleTasksInParallel(queue *task.TasksQueue) {
+	tasksByModule := make(map[string]task.Task)
+	modulesNames := make([]string, 0)
+	for _, t := range queue.HeadTasks(func(t task.Task) bool {
+		return t.GetType() == task.ModuleRun && !t.IsCancelled()
+	}) {
+		// the second run of the same module is run after the first one
+		if _, ok := tasksByModule[t.GetName()]; ok {
+			continue
+		}
+		tasksByModule[t.GetName()] = t
+		modulesNames = append(modulesNames, t.GetName())
+	}
+	if len(modulesNames) == 0 {
+		return
+	}
+	rlog.Infof("TASK_RUN run %d modules with parallelism %d: %v", len(modulesNames), module_manager.ModulesParallelism, modulesNames)
+
+	delays := make(chan time.Duration, len(modulesNames))
+	errs := ModuleManager.RunModulesGraph(modulesNames, func(moduleName string) error {
+		t := tasksByModule[moduleName]
+		// only the head of the queue is in progress for Cancel, mark other tasks of the run
+		queue.StartInFlight(t.GetId())
+		defer queue.StopInFlight(t.GetId())
+		delay, err := runModuleTask(queue, t)
+		delays <- delay
+		return err
+	})
+	close(delays)
+
+	for _, moduleName := range modulesNames {
+		if err := errs[moduleName]; module_manager.IsModuleDependencyFailed(err) {
+			moduleQueueLog(moduleName).Recordf("INFO", "task %s: %s", tasksByModule[moduleName].GetCorrelationId(), err)
+		}
+	}
+
+	var retryDelay time.Duration
+	for delay := range delays {
+		if delay > retryDelay {
+			retryDelay = delay
+		}
+	}
+	if retryDelay > 0 {
+		queue.Push(task.NewTaskDelay(retryDelay))
+		rlog.Infof("QUEUE push FailedModuleDelay %s", retryDelay)
+	}
+}
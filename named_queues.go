@@ -0,0 +1,112 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/romana/rlog"
+
+	"github.com/flant/antiopa/task"
+)
+
+// Name of the queue for modules runs, global hooks and hooks without queue in binding config
+const MainQueueName = "main"
+
+// NamedQueues are queues for hooks with `queue` in schedule or onKubernetesEvent binding.
+// Each queue has its own runner, so slow event hooks do not delay modules runs
+// in the main queue. Queue is created and started on first task.
+var NamedQueues = NewNamedTasksQueues()
+
+type NamedTasksQueues struct {
+	m      sync.Mutex
+	queues map[string]*task.TasksQueue
+}
+
+func NewNamedTasksQueues() *NamedTasksQueues {
+	return &NamedTasksQueues{
+		queues: make(map[string]*task.TasksQueue),
+	}
+}
+
+// Get returns the queue by name, new queue is created and its runner is started
+func (n *NamedTasksQueues) Get(name string) *task.TasksQueue {
+	n.m.Lock()
+	defer n.m.Unlock()
+
+	queue, ok := n.queues[name]
+	if !ok {
+		rlog.Infof("QUEUE create named queue '%s'", name)
+		queue = task.NewTasksQueue()
+		n.queues[name] = queue
+		go RunTasksQueue(name, queue)
+	}
+	return queue
+}
+
+// Names returns names of created queues in alphabetical order
+func (n *NamedTasksQueues) Names() []string {
+	n.m.Lock()
+	defer n.m.Unlock()
+
+	names := make([]string, 0, len(n.queues))
+	for name := range n.queues {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// AddHookTask adds a hook task into the queue from the binding config
+// or merges it into the queued task of the hook if the queue is saturated.
+func AddHookTask(t task.Task) {
+	queue := HookTaskQueue(t)
+	queueName := t.GetQueueName()
+	if queueName == "" {
+		queueName = MainQueueName
+	}
+	if coalesceHookTask(queueName, queue, t) {
+		return
+	}
+	queue.Add(t)
+}
+
+// HookTaskQueue returns the queue from the binding config of the hook task
+func HookTaskQueue(t task.Task) *task.TasksQueue {
+	queueName := t.GetQueueName()
+	if queueName == "" || queueName == MainQueueName {
+		return TasksQueue
+	}
+	return NamedQueues.Get(queueName)
+}
+
+// AllTasksQueues returns the main queue and named queues
+func AllTasksQueues() map[string]*task.TasksQueue {
+	res := map[string]*task.TasksQueue{MainQueueName: TasksQueue}
+	for _, name := range NamedQueues.Names() {
+		res[name] = NamedQueues.Get(name)
+	}
+	return res
+}
+
+// DumpAllTasksQueues returns dumps of the main queue and named queues
+func DumpAllTasksQueues() io.Reader {
+	readers := []io.Reader{bytes.NewBufferString(fmt.Sprintf("Queue '%s'\n", MainQueueName)), TasksQueue.DumpReader()}
+	for _, name := range NamedQueues.Names() {
+		readers = append(readers, bytes.NewBufferString(fmt.Sprintf("\nQueue '%s'\n", name)), NamedQueues.Get(name).DumpReader())
+	}
+	if ModuleRetries != nil {
+		if retries := ModuleRetries.Dump(); len(retries) > 0 {
+			var buf bytes.Buffer
+			buf.WriteString("\nFailed module runs waiting for retry\n")
+			for _, retry := range retries {
+				buf.WriteString(fmt.Sprintf("ModuleRun '%s' failed %d times, retry at %s: %s\n", retry.Module, retry.Failures, retry.RetryAt.Format(time.RFC3339), retry.Error))
+			}
+			readers = append(readers, &buf)
+		}
+	}
+	return io.MultiReader(readers...)
+}
@@ -0,0 +1,17 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNamedTasksQueues(t *testing.T) {
+	queues := NewNamedTasksQueues()
+
+	slow := queues.Get("slow-hooks")
+	assert.Equal(t, slow, queues.Get("slow-hooks"))
+	queues.Get("events")
+
+	assert.Equal(t, []string{"events", "slow-hooks"}, queues.Names())
+}
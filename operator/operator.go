@@ -0,0 +1,123 @@
+// Package operator assembles subsystems of antiopa without flags and without os.Exit,
+// so a custom distribution can have its own main, flag parsing and extra subsystems:
+//
+//	components, err := operator.Setup(operator.Options{WorkingDir: "/antiopa"})
+//	if err != nil {
+//		log.Fatal(err)
+//	}
+//	modules := components.ModuleManager.GetModuleNamesInOrder()
+//
+// Settings of subsystems that are not in Options are exported variables of their packages,
+// e.g. module_manager.HooksTimeout or helm.ClientType, they should be set before Setup.
+// The tasks queue and the main loop are still a part of the antiopa binary.
+package operator
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+
+	"k8s.io/client-go/rest"
+
+	"github.com/flant/antiopa/helm"
+	"github.com/flant/antiopa/kube"
+	"github.com/flant/antiopa/module_manager"
+	"github.com/flant/antiopa/utils"
+)
+
+// Options of Setup
+type Options struct {
+	// directory with 'modules' and 'global-hooks'
+	WorkingDir string
+	// directory for values files and other temporary files, a session in the system
+	// temporary directory is created if empty
+	TempDir string
+	// config of kube clients, in-cluster config or kubeconfig is used if nil
+	KubeConfig *rest.Config
+	// namespace of antiopa, namespace of the ServiceAccount or ANTIOPA_NAMESPACE is used if empty
+	Namespace string
+	// helm.TillerModeDeployment if empty
+	TillerMode string
+	// namespace of tiller, Namespace is used if empty
+	TillerNamespace string
+}
+
+// Components are initialized subsystems
+type Components struct {
+	Namespace     string
+	TempDir       string
+	HelmClient    helm.HelmClient
+	HelmClients   *helm.ClientsPool
+	ModuleManager module_manager.ModuleManager
+}
+
+func (o Options) validate() error {
+	if o.WorkingDir == "" {
+		return fmt.Errorf("working dir is required")
+	}
+	if _, err := os.Stat(filepath.Join(o.WorkingDir, "modules")); err != nil {
+		return fmt.Errorf("bad working dir: %s", err)
+	}
+	if _, err := helm.InitFunc(o.TillerMode); err != nil {
+		return err
+	}
+	return nil
+}
+
+// Setup connects to kubernetes, initializes helm clients and loads modules and global hooks
+// in the same order as antiopa does at start
+func Setup(options Options) (*Components, error) {
+	if err := options.validate(); err != nil {
+		return nil, err
+	}
+	initHelm, _ := helm.InitFunc(options.TillerMode)
+
+	config := options.KubeConfig
+	if config == nil {
+		var err error
+		if config, err = kube.LoadRestConfig(); err != nil {
+			return nil, err
+		}
+	}
+	namespace := options.Namespace
+	if namespace == "" {
+		var err error
+		if namespace, err = kube.DetectNamespace(); err != nil {
+			return nil, err
+		}
+	}
+	if err := kube.InitKubeWithConfig(config, namespace); err != nil {
+		return nil, err
+	}
+
+	tempDir := options.TempDir
+	if tempDir == "" {
+		var err error
+		if tempDir, err = utils.NewTempDirSession(filepath.Join(os.TempDir(), "antiopa")); err != nil {
+			return nil, fmt.Errorf("cannot create temporary dir: %s", err)
+		}
+	}
+
+	tillerNamespace := options.TillerNamespace
+	if tillerNamespace == "" {
+		tillerNamespace = namespace
+	}
+	helmClient, err := initHelm(tillerNamespace)
+	if err != nil {
+		return nil, fmt.Errorf("cannot initialize helm: %s", err)
+	}
+	helmClients := helm.NewClientsPool(helmClient, initHelm)
+
+	moduleManager, err := module_manager.Init(options.WorkingDir, tempDir, helmClients)
+	if err != nil {
+		return nil, fmt.Errorf("cannot initialize module manager: %s", err)
+	}
+
+	return &Components{
+		Namespace:     namespace,
+		TempDir:       tempDir,
+		HelmClient:    helmClient,
+		HelmClients:   helmClients,
+		ModuleManager: moduleManager,
+	}, nil
+}
@@ -0,0 +1,26 @@
+package operator
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestOptions_validate(t *testing.T) {
+	dir, err := ioutil.TempDir("", "antiopa-operator")
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer os.RemoveAll(dir)
+
+	assert.Error(t, Options{}.validate())
+	assert.Error(t, Options{WorkingDir: dir}.validate(), "modules dir is required")
+
+	assert.NoError(t, os.Mkdir(filepath.Join(dir, "modules"), 0755))
+	assert.NoError(t, Options{WorkingDir: dir}.validate())
+	assert.NoError(t, Options{WorkingDir: dir, TillerMode: "embedded"}.validate())
+	assert.Error(t, Options{WorkingDir: dir, TillerMode: "sidecar"}.validate())
+}
@@ -0,0 +1,97 @@
+package main
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/romana/rlog"
+
+	"github.com/flant/antiopa/task"
+)
+
+// PausedModuleRun is a ModuleRun task that is triggered while converge is disabled
+type PausedModuleRun struct {
+	Module   string    `json:"module"`
+	Cause    string    `json:"cause"`
+	PausedAt time.Time `json:"pausedAt"`
+
+	task task.Task
+}
+
+// PausedModuleRuns keeps ModuleRun tasks out of the queue while converge is disabled with
+// annotation on antiopa ConfigMap, so other tasks are not blocked. One run per module is kept.
+type PausedModuleRuns struct {
+	m    sync.Mutex
+	runs map[string]*PausedModuleRun
+}
+
+func NewPausedModuleRuns() *PausedModuleRuns {
+	return &PausedModuleRuns{
+		runs: make(map[string]*PausedModuleRun),
+	}
+}
+
+// Pause saves the ModuleRun task if converge is disabled. False is returned if converge
+// is enabled, the task should be run then.
+func (p *PausedModuleRuns) Pause(t task.Task) bool {
+	p.m.Lock()
+	defer p.m.Unlock()
+
+	// checked under the lock, so the task cannot be paused after Resume
+	if !IsConvergeDisabled() {
+		return false
+	}
+
+	if _, hasRun := p.runs[t.GetName()]; !hasRun {
+		p.runs[t.GetName()] = &PausedModuleRun{
+			Module:   t.GetName(),
+			Cause:    t.GetCause(),
+			PausedAt: time.Now(),
+			task:     t,
+		}
+	}
+	MetricsStorage.SendGaugeMetric("antiopa_paused_module_runs", float64(len(p.runs)), map[string]string{})
+	return true
+}
+
+// Resume adds paused runs into the queue in the order of modules. It should be called
+// after converge is enabled.
+func (p *PausedModuleRuns) Resume() {
+	p.m.Lock()
+	defer p.m.Unlock()
+
+	if len(p.runs) == 0 {
+		return
+	}
+
+	for _, moduleName := range ModuleManager.GetModuleNamesInOrder() {
+		run, hasRun := p.runs[moduleName]
+		if !hasRun {
+			continue
+		}
+		delete(p.runs, moduleName)
+		TasksQueue.Add(run.task)
+		rlog.Infof("QUEUE add ModuleRun %s: converge is enabled", moduleName)
+	}
+	for moduleName := range p.runs {
+		// module is gone, nothing to run
+		delete(p.runs, moduleName)
+	}
+	MetricsStorage.SendGaugeMetric("antiopa_paused_module_runs", 0.0, map[string]string{})
+}
+
+// Dump returns paused runs sorted by module name
+func (p *PausedModuleRuns) Dump() []PausedModuleRun {
+	p.m.Lock()
+	defer p.m.Unlock()
+
+	res := make([]PausedModuleRun, 0, len(p.runs))
+	for _, run := range p.runs {
+		res = append(res, *run)
+	}
+	sort.Slice(res, func(i, j int) bool {
+		return res[i].Module < res[j].Module
+	})
+	return res
+}
@@ -0,0 +1,46 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/flant/antiopa/module_manager"
+	"github.com/flant/antiopa/task"
+)
+
+func TestPausedModuleRuns(t *testing.T) {
+	defer SetConvergeDisabled(false)
+	defer func(mm module_manager.ModuleManager, queue *task.TasksQueue) {
+		ModuleManager = mm
+		TasksQueue = queue
+	}(ModuleManager, TasksQueue)
+	ModuleManager = &ModuleManagerMock{}
+	TasksQueue = task.NewTasksQueue()
+
+	paused := NewPausedModuleRuns()
+
+	// converge is enabled, the task is run
+	assert.False(t, paused.Pause(task.NewTask(task.ModuleRun, "test_module_1__101")))
+
+	SetConvergeDisabled(true)
+	assert.True(t, paused.Pause(task.NewTask(task.ModuleRun, "test_module_2__102").WithCause("config changed")))
+	assert.True(t, paused.Pause(task.NewTask(task.ModuleRun, "test_module_1__101").WithOnStartupHooks(true)))
+	// one run per module is kept
+	assert.True(t, paused.Pause(task.NewTask(task.ModuleRun, "test_module_2__102")))
+	runs := paused.Dump()
+	if assert.Len(t, runs, 2) {
+		assert.Equal(t, "test_module_1__101", runs[0].Module)
+		assert.Equal(t, "config changed", runs[1].Cause)
+	}
+	assert.Equal(t, 0, TasksQueue.Length())
+
+	SetConvergeDisabled(false)
+	paused.Resume()
+	assert.Len(t, paused.Dump(), 0)
+	if assert.Equal(t, 2, TasksQueue.Length()) {
+		head, _ := TasksQueue.Peek()
+		assert.Equal(t, "test_module_1__101", head.GetName())
+		assert.True(t, head.GetOnStartupHooks())
+	}
+}
@@ -0,0 +1,93 @@
+package main
+
+import (
+	"sync"
+
+	"github.com/romana/rlog"
+
+	"github.com/flant/antiopa/module_manager"
+	"github.com/flant/antiopa/task"
+)
+
+// QueueSaturationThreshold is a length of the tasks queue when onKubernetesEvent bindings
+// of the queue are switched into coalescing mode: events of the binding are merged into the
+// queued task of the hook instead of new tasks, only the last event of each object is kept.
+// So memory does not grow during event storms. 0 disables coalescing.
+var QueueSaturationThreshold = 1000
+
+var (
+	saturatedQueuesMutex sync.Mutex
+	saturatedQueues      = make(map[string]bool)
+)
+
+// IsQueueSaturated returns true if the queue is in coalescing mode. Changes of the mode are logged.
+func IsQueueSaturated(queueName string, queue *task.TasksQueue) bool {
+	saturated := QueueSaturationThreshold > 0 && queue.Length() >= QueueSaturationThreshold
+
+	saturatedQueuesMutex.Lock()
+	defer saturatedQueuesMutex.Unlock()
+	if saturatedQueues[queueName] != saturated {
+		if saturated {
+			rlog.Warnf("QUEUE '%s' has %d tasks, events of onKubernetesEvent bindings are coalesced", queueName, queue.Length())
+		} else {
+			rlog.Infof("QUEUE '%s' has %d tasks, events of onKubernetesEvent bindings are queued again", queueName, queue.Length())
+		}
+		saturatedQueues[queueName] = saturated
+	}
+	return saturated
+}
+
+// QueueSaturation returns the ratio of the queue length to QueueSaturationThreshold
+func QueueSaturation(queue *task.TasksQueue) float64 {
+	if QueueSaturationThreshold <= 0 {
+		return 0
+	}
+	return float64(queue.Length()) / float64(QueueSaturationThreshold)
+}
+
+// coalesceHookTask merges binding contexts of the onKubernetesEvent task into the queued task
+// of the same hook and binding if the queue is saturated. False is returned if the task
+// should be added into the queue.
+func coalesceHookTask(queueName string, queue *task.TasksQueue, t task.Task) bool {
+	if t.GetBinding() != module_manager.KubeEvents || len(t.GetBindingContext()) == 0 {
+		return false
+	}
+	if !IsQueueSaturated(queueName, queue) {
+		return false
+	}
+
+	bindingName := t.GetBindingContext()[0].Binding
+	coalesced := queue.UpdateLast(func(queued task.Task) bool {
+		return queued.GetType() == t.GetType() &&
+			queued.GetName() == t.GetName() &&
+			queued.GetBinding() == t.GetBinding() &&
+			!queued.IsCancelled() &&
+			len(queued.GetBindingContext()) > 0 &&
+			queued.GetBindingContext()[0].Binding == bindingName
+	}, func(queued task.Task) {
+		if baseTask, ok := queued.(*task.BaseTask); ok {
+			baseTask.WithBindingContext(coalesceBindingContexts(queued.GetBindingContext(), t.GetBindingContext()))
+		}
+	})
+	if coalesced {
+		MetricsStorage.SendCounterMetric("antiopa_kube_events_coalesced", float64(len(t.GetBindingContext())), map[string]string{"hook": t.GetName(), "queue": queueName})
+		rlog.Debugf("QUEUE '%s' coalesce %s@%s %s into the queued task", queueName, t.GetType(), t.GetBinding(), t.GetName())
+	}
+	return coalesced
+}
+
+// coalesceBindingContexts keeps the last event of each object as KubeEventsAggregator does.
+// Contexts of the new task are returned if objects were added and deleted in between.
+func coalesceBindingContexts(queued []module_manager.BindingContext, contexts []module_manager.BindingContext) []module_manager.BindingContext {
+	batch := &kubeEventsBatch{objects: make(map[string]int)}
+	for _, context := range queued {
+		batch.merge(context)
+	}
+	for _, context := range contexts {
+		batch.merge(context)
+	}
+	if len(batch.contexts) == 0 {
+		return contexts
+	}
+	return batch.contexts
+}
@@ -0,0 +1,61 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/flant/antiopa/metrics_storage"
+	"github.com/flant/antiopa/module_manager"
+	"github.com/flant/antiopa/task"
+)
+
+func TestCoalesceHookTask(t *testing.T) {
+	defer func(threshold int) { QueueSaturationThreshold = threshold }(QueueSaturationThreshold)
+	QueueSaturationThreshold = 3
+	MetricsStorage = metrics_storage.NewMetricStorage()
+
+	event := func(eventType string, name string) *task.BaseTask {
+		return task.NewTask(task.GlobalHookRun, "pods-hook").
+			WithBinding(module_manager.KubeEvents).
+			AppendBindingContext(module_manager.BindingContext{
+				Binding:           "pods",
+				ResourceEvent:     eventType,
+				ResourceNamespace: "default",
+				ResourceKind:      "Pod",
+				ResourceName:      name,
+			})
+	}
+
+	queue := task.NewTasksQueue()
+	queue.Add(event("ADDED", "web-1"))
+	queued := event("ADDED", "web-2")
+	queue.Add(queued)
+
+	// queue is not saturated
+	assert.False(t, coalesceHookTask("test", queue, event("MODIFIED", "web-1")))
+	queue.Add(task.NewTask(task.ModuleRun, "dashboard"))
+	assert.True(t, IsQueueSaturated("test", queue))
+	assert.Equal(t, 1.0, QueueSaturation(queue))
+
+	assert.True(t, coalesceHookTask("test", queue, event("MODIFIED", "web-2")))
+	assert.True(t, coalesceHookTask("test", queue, event("MODIFIED", "web-3")))
+	assert.Equal(t, 3, queue.Length())
+
+	contexts := queued.GetBindingContext()
+	if assert.Len(t, contexts, 2) {
+		// added and then updated object is still added for the hook
+		assert.Equal(t, "web-2", contexts[0].ResourceName)
+		assert.Equal(t, "ADDED", contexts[0].ResourceEvent)
+		assert.Equal(t, "web-3", contexts[1].ResourceName)
+	}
+
+	// other bindings and hooks are queued
+	assert.False(t, coalesceHookTask("test", queue, task.NewTask(task.GlobalHookRun, "pods-hook").WithBinding(module_manager.Schedule)))
+	other := event("MODIFIED", "web-1")
+	other.BindingContext[0].Binding = "other-pods"
+	assert.False(t, coalesceHookTask("test", queue, other))
+
+	QueueSaturationThreshold = 0
+	assert.False(t, IsQueueSaturated("test", queue))
+}
@@ -0,0 +1,202 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+
+	"github.com/romana/rlog"
+
+	"github.com/flant/antiopa/kube"
+	"github.com/flant/antiopa/module_manager"
+)
+
+const (
+	RbacSelfCheckOff     = "off"
+	RbacSelfCheckWarn    = "warn"
+	RbacSelfCheckEnforce = "enforce"
+)
+
+// RbacSelfCheck is a mode of the check of antiopa permissions with SelfSubjectAccessReview.
+// Permissions of antiopa and watches of global hooks are checked at start, watches of module
+// hooks are checked when module hooks are enabled. Missing permissions are reported in one
+// list. Antiopa exits at start in enforce mode and only logs the list in warn mode.
+var RbacSelfCheck = RbacSelfCheckWarn
+
+func checkRbacSelfCheckMode() error {
+	switch RbacSelfCheck {
+	case RbacSelfCheckOff, RbacSelfCheckWarn, RbacSelfCheckEnforce:
+		return nil
+	}
+	return fmt.Errorf("unknown mode '%s', expected '%s', '%s' or '%s'", RbacSelfCheck, RbacSelfCheckEnforce, RbacSelfCheckWarn, RbacSelfCheckOff)
+}
+
+func rbacSelfCheckEnabled() bool {
+	// fake cluster in dev mode has no authorizer
+	return RbacSelfCheck != RbacSelfCheckOff && !DevMode
+}
+
+// rbacRequiredPermissions returns permissions that antiopa needs itself. Permissions for
+// resources of helm releases are not checked: tiller uses its own ServiceAccount.
+func rbacRequiredPermissions(namespace string) []kube.Permission {
+	perm := func(verb string, group string, resource string, namespace string, users ...string) kube.Permission {
+		return kube.Permission{Verb: verb, Group: group, Resource: resource, Namespace: namespace, Users: users}
+	}
+
+	res := []kube.Permission{
+		perm("get", "", "configmaps", namespace, "config", "hooks state", "dynamic values", "addons report", "module runs"),
+		perm("list", "", "configmaps", namespace, "config"),
+		perm("watch", "", "configmaps", namespace, "config"),
+		perm("create", "", "configmaps", namespace, "hooks state", "dynamic values", "addons report", "module runs"),
+		perm("update", "", "configmaps", namespace, "config", "hooks state", "dynamic values", "addons report", "module runs"),
+		perm("get", "apps", "deployments", namespace, "image updates", "tiller"),
+		perm("update", "apps", "deployments", namespace, "image updates"),
+		perm("get", "", "pods", namespace, "image updates", "node exec"),
+		perm("create", "", "pods", namespace, "node exec"),
+		perm("delete", "", "pods", namespace, "node exec"),
+		perm("list", "", "pods", namespace, "hook jobs"),
+		perm("get", "", "secrets", namespace, "helm releases"),
+		perm("list", "", "secrets", namespace, "hook jobs"),
+		perm("create", "", "secrets", namespace, "hook jobs"),
+		perm("delete", "", "secrets", namespace, "hook jobs"),
+		perm("create", "", "events", namespace, "events"),
+		perm("get", "", "serviceaccounts", namespace, "hooks kubeconfig"),
+		perm("list", "", "serviceaccounts", namespace, "hooks kubeconfig"),
+		perm("create", "", "serviceaccounts", namespace, "hooks kubeconfig"),
+		perm("delete", "", "serviceaccounts", namespace, "hooks kubeconfig"),
+	}
+	for _, verb := range []string{"list", "create", "delete"} {
+		res = append(res, perm(verb, "batch", "jobs", namespace, "hook jobs"))
+	}
+	for _, verb := range []string{"get", "list", "create", "delete"} {
+		res = append(res, perm(verb, "rbac.authorization.k8s.io", "clusterrolebindings", "", "hooks kubeconfig"))
+		res = append(res, perm(verb, "rbac.authorization.k8s.io", "rolebindings", "", "hooks kubeconfig"))
+	}
+	// HookClusterRole is bound to ServiceAccounts of all hooks, antiopa may not have its rights
+	if kube.HookClusterRole != "" {
+		res = append(res, perm("bind", "rbac.authorization.k8s.io", "clusterroles", "", "hooks kubeconfig"))
+	}
+	if !ExternalTiller && !EmbeddedTiller {
+		res = append(res,
+			perm("list", "apps", "deployments", namespace, "tiller scheduling"),
+			perm("watch", "apps", "deployments", namespace, "tiller scheduling"),
+		)
+	}
+	if module_manager.NodePlatformsDiscovery {
+		res = append(res, perm("list", "", "nodes", "", "node platforms discovery"))
+	}
+	if ApiAuthEnabled {
+		res = append(res, perm("create", "authentication.k8s.io", "tokenreviews", "", "api auth"))
+	}
+	if SelfResources == SelfResourcesApply {
+		for _, verb := range []string{"get", "create", "update"} {
+			res = append(res, perm(verb, "networking.k8s.io", "networkpolicies", namespace, "self resources"))
+		}
+		for _, verb := range []string{"get", "create", "delete"} {
+			res = append(res, perm(verb, "policy", "poddisruptionbudgets", namespace, "self resources"))
+		}
+	}
+	if ConfigWebhookServiceName != "" {
+		res = append(res,
+			perm("get", "admissionregistration.k8s.io", "validatingwebhookconfigurations", "", "config webhook"),
+			perm("create", "admissionregistration.k8s.io", "validatingwebhookconfigurations", "", "config webhook"),
+			perm("update", "admissionregistration.k8s.io", "validatingwebhookconfigurations", "", "config webhook"),
+		)
+	}
+	return res
+}
+
+// kubeEventHooksPermissions returns list and watch permissions for resources of hooks bindings.
+// Kinds that cannot be resolved are returned as errors.
+func kubeEventHooksPermissions(descs []*KubeEventHook) ([]kube.Permission, []error) {
+	res := make([]kube.Permission, 0)
+	errs := make([]error, 0)
+	for _, desc := range descs {
+		group, resource, namespaced, err := kube.KindResource(desc.ApiVersion, desc.Kind)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("hook '%s': %s", desc.HookName, err))
+			continue
+		}
+		namespace := desc.Namespace
+		if !namespaced {
+			namespace = ""
+		}
+		user := fmt.Sprintf("hook '%s'", desc.HookName)
+		for _, verb := range []string{"list", "watch"} {
+			res = append(res, kube.Permission{Verb: verb, Group: group, Resource: resource, Namespace: namespace, Users: []string{user}})
+		}
+	}
+	return res, errs
+}
+
+func globalHooksKubeEventDescriptors(moduleManager module_manager.ModuleManager) []*KubeEventHook {
+	res := make([]*KubeEventHook, 0)
+	for _, hookName := range moduleManager.GetGlobalHooksInOrder(module_manager.KubeEvents) {
+		globalHook, err := moduleManager.GetGlobalHook(hookName)
+		if err != nil {
+			continue
+		}
+		res = append(res, MakeKubeEventHookDescriptors(globalHook.Hook, &globalHook.Config.HookConfig)...)
+	}
+	return res
+}
+
+// RunRbacSelfCheck checks permissions of antiopa and of global hooks. An error is returned
+// in enforce mode if some permissions are missing.
+func RunRbacSelfCheck(moduleManager module_manager.ModuleManager) error {
+	if !rbacSelfCheckEnabled() {
+		return nil
+	}
+
+	permissions := rbacRequiredPermissions(kube.KubernetesAntiopaNamespace)
+	hooksPermissions, errs := kubeEventHooksPermissions(globalHooksKubeEventDescriptors(moduleManager))
+	for _, err := range errs {
+		rlog.Warnf("MAIN RBAC self-check: %s", err)
+	}
+	permissions = append(permissions, hooksPermissions...)
+
+	denied, err := kube.CheckPermissions(permissions)
+	if err != nil {
+		rlog.Warnf("MAIN RBAC self-check is incomplete: %s", err)
+	}
+	if len(denied) == 0 {
+		rlog.Infof("MAIN RBAC self-check: %d permissions are granted", len(kube.MergePermissions(permissions)))
+		return nil
+	}
+
+	report := rbacMissingPermissionsReport(denied)
+	if RbacSelfCheck == RbacSelfCheckEnforce {
+		return fmt.Errorf("%d permissions are missing:\n%s", len(denied), report)
+	}
+	rlog.Warnf("MAIN RBAC self-check: %d permissions are missing:\n%s", len(denied), report)
+	return nil
+}
+
+// CheckModuleHooksPermissions logs missing permissions for watches of module hooks.
+// Informers are started anyway, so this check never fails.
+func CheckModuleHooksPermissions(moduleName string, descs []*KubeEventHook) {
+	if !rbacSelfCheckEnabled() || len(descs) == 0 {
+		return
+	}
+
+	permissions, errs := kubeEventHooksPermissions(descs)
+	for _, err := range errs {
+		rlog.Warnf("MAIN RBAC self-check of module '%s': %s", moduleName, err)
+	}
+	denied, err := kube.CheckPermissions(permissions)
+	if err != nil {
+		rlog.Warnf("MAIN RBAC self-check of module '%s' is incomplete: %s", moduleName, err)
+	}
+	if len(denied) > 0 {
+		rlog.Warnf("MAIN RBAC self-check: hooks of module '%s' miss %d permissions:\n%s", moduleName, len(denied), rbacMissingPermissionsReport(denied))
+	}
+}
+
+// rbacMissingPermissionsReport returns a line for each permission with parts of antiopa that need it
+func rbacMissingPermissionsReport(denied []kube.Permission) string {
+	buf := &bytes.Buffer{}
+	for _, perm := range denied {
+		fmt.Fprintf(buf, "  - %s (needed for %s)\n", perm, strings.Join(perm.Users, ", "))
+	}
+	return strings.TrimSuffix(buf.String(), "\n")
+}
@@ -0,0 +1,42 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/flant/antiopa/kube"
+)
+
+func TestKubeEventHooksPermissions(t *testing.T) {
+	descs := []*KubeEventHook{
+		{HookName: "global-hooks/pods", Kind: "Pod", Namespace: "kube-system"},
+		{HookName: "global-hooks/nodes", Kind: "node", Namespace: "kube-system"},
+		{HookName: "global-hooks/pods-again", Kind: "pod", Namespace: "kube-system"},
+	}
+
+	perms, errs := kubeEventHooksPermissions(descs)
+	assert.Len(t, errs, 0)
+
+	merged := kube.MergePermissions(perms)
+	if !assert.Len(t, merged, 4) {
+		return
+	}
+	assert.Equal(t, "list pods in namespace 'kube-system'", merged[0].String())
+	assert.Equal(t, []string{"hook 'global-hooks/pods'", "hook 'global-hooks/pods-again'"}, merged[0].Users)
+	// nodes are cluster-wide
+	assert.Equal(t, "watch nodes in all namespaces", merged[3].String())
+
+	report := rbacMissingPermissionsReport(merged[2:])
+	assert.Equal(t, "  - list nodes in all namespaces (needed for hook 'global-hooks/nodes')\n"+
+		"  - watch nodes in all namespaces (needed for hook 'global-hooks/nodes')", report)
+}
+
+func TestCheckRbacSelfCheckMode(t *testing.T) {
+	defer func(mode string) { RbacSelfCheck = mode }(RbacSelfCheck)
+
+	RbacSelfCheck = RbacSelfCheckEnforce
+	assert.NoError(t, checkRbacSelfCheckMode())
+	RbacSelfCheck = "strict"
+	assert.Error(t, checkRbacSelfCheckMode())
+}
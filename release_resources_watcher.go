@@ -0,0 +1,216 @@
+package main
+
+import (
+	"fmt"
+	"sort"
+	"sync"
+
+	"github.com/romana/rlog"
+
+	"github.com/flant/antiopa/helm"
+	"github.com/flant/antiopa/kube_events_manager"
+	"github.com/flant/antiopa/module_manager"
+	"github.com/flant/antiopa/task"
+)
+
+// jq filter to ignore changes made by controllers in status and by apiserver in metadata
+const ReleaseResourceJqFilter = `del(.status, .metadata.resourceVersion, .metadata.generation, .metadata.managedFields)`
+
+var ReleaseResourceEventTypes = []module_manager.OnKubernetesEventType{
+	module_manager.KubernetesEventOnUpdate,
+	module_manager.KubernetesEventOnDelete,
+}
+
+// ReleaseResourcesWatcher runs informers for resources of modules helm releases
+// and reacts on external changes of these resources according to module's watchRelease mode.
+type ReleaseResourcesWatcher interface {
+	WatchModule(moduleName string, moduleManager module_manager.ModuleManager, eventsManager kube_events_manager.KubeEventsManager) error
+	UnwatchModule(moduleName string, eventsManager kube_events_manager.KubeEventsManager) error
+	Suspend(moduleName string)
+	HandleEvent(kubeEvent kube_events_manager.KubeEvent, moduleManager module_manager.ModuleManager) (*struct{ Tasks []task.Task }, bool)
+}
+
+type watchedRelease struct {
+	ModuleName string
+	Mode       module_manager.ReleaseWatchMode
+	Resources  map[string]helm.ReleaseResource
+	ConfigIds  []string
+	Suspended  bool
+}
+
+type MainReleaseResourcesWatcher struct {
+	m                sync.Mutex
+	ReleasesByModule map[string]*watchedRelease
+	ModuleByConfigId map[string]string
+}
+
+func NewMainReleaseResourcesWatcher() *MainReleaseResourcesWatcher {
+	return &MainReleaseResourcesWatcher{
+		ReleasesByModule: make(map[string]*watchedRelease),
+		ModuleByConfigId: make(map[string]string),
+	}
+}
+
+func releaseResourceKey(kind, namespace, name string) string {
+	return fmt.Sprintf("%s/%s/%s", namespace, kind, name)
+}
+
+// WatchModule (re)starts informers for the current resources of module release.
+// Informers are restarted after each module run to get a new baseline of resources state.
+func (w *MainReleaseResourcesWatcher) WatchModule(moduleName string, moduleManager module_manager.ModuleManager, eventsManager kube_events_manager.KubeEventsManager) error {
+	module, err := moduleManager.GetModule(moduleName)
+	if err != nil {
+		return err
+	}
+
+	if module.Definition == nil || module.Definition.WatchRelease == module_manager.ReleaseWatchDisabled {
+		return w.UnwatchModule(moduleName, eventsManager)
+	}
+
+	helmClient, err := module.HelmClient()
+	if err != nil {
+		return err
+	}
+
+	releaseExists, err := helmClient.IsReleaseExists(moduleName)
+	if err != nil {
+		return err
+	}
+	if !releaseExists {
+		return w.UnwatchModule(moduleName, eventsManager)
+	}
+
+	manifest, err := helmClient.GetReleaseManifest(moduleName)
+	if err != nil {
+		return err
+	}
+
+	resources, err := helm.ParseReleaseManifest(manifest, helmClient.TillerNamespace())
+	if err != nil {
+		return fmt.Errorf("module '%s' release manifest: %s", moduleName, err)
+	}
+
+	if err := w.UnwatchModule(moduleName, eventsManager); err != nil {
+		return err
+	}
+
+	release := &watchedRelease{
+		ModuleName: moduleName,
+		Mode:       module.Definition.WatchRelease,
+		Resources:  make(map[string]helm.ReleaseResource),
+		ConfigIds:  make([]string, 0),
+	}
+
+	// One informer for each kind in namespace. Events for objects
+	// not in release are filtered out in HandleEvent.
+	informers := make(map[string]helm.ReleaseResource)
+	for _, resource := range resources {
+		release.Resources[releaseResourceKey(resource.Kind, resource.Namespace, resource.Name)] = resource
+		informers[fmt.Sprintf("%s/%s", resource.Namespace, resource.Kind)] = resource
+	}
+
+	informerKeys := make([]string, 0)
+	for key := range informers {
+		informerKeys = append(informerKeys, key)
+	}
+	sort.Strings(informerKeys)
+
+	w.m.Lock()
+	defer w.m.Unlock()
+
+	for _, key := range informerKeys {
+		resource := informers[key]
+		configId, err := eventsManager.Run(ReleaseResourceEventTypes, resource.ApiVersion, resource.Kind, resource.Namespace, nil, ReleaseResourceJqFilter, false)
+		if err != nil {
+			rlog.Warnf("RELEASE_WATCH module '%s': cannot watch %s in namespace '%s': %s", moduleName, resource.Kind, resource.Namespace, err)
+			continue
+		}
+		release.ConfigIds = append(release.ConfigIds, configId)
+		w.ModuleByConfigId[configId] = moduleName
+	}
+
+	w.ReleasesByModule[moduleName] = release
+
+	rlog.Debugf("RELEASE_WATCH module '%s': watch %d resources with %d informers in '%s' mode", moduleName, len(release.Resources), len(release.ConfigIds), release.Mode)
+
+	return nil
+}
+
+func (w *MainReleaseResourcesWatcher) UnwatchModule(moduleName string, eventsManager kube_events_manager.KubeEventsManager) error {
+	w.m.Lock()
+	defer w.m.Unlock()
+
+	release, hasRelease := w.ReleasesByModule[moduleName]
+	if !hasRelease {
+		return nil
+	}
+
+	for _, configId := range release.ConfigIds {
+		if err := eventsManager.Stop(configId); err != nil {
+			return err
+		}
+		delete(w.ModuleByConfigId, configId)
+	}
+
+	delete(w.ReleasesByModule, moduleName)
+
+	return nil
+}
+
+// Suspend ignores events for module release resources until next WatchModule.
+// Used while module is running to ignore changes made by antiopa itself.
+func (w *MainReleaseResourcesWatcher) Suspend(moduleName string) {
+	w.m.Lock()
+	defer w.m.Unlock()
+
+	if release, hasRelease := w.ReleasesByModule[moduleName]; hasRelease {
+		release.Suspended = true
+	}
+}
+
+// HandleEvent returns tasks for the kube event if event belongs to watched release informer.
+func (w *MainReleaseResourcesWatcher) HandleEvent(kubeEvent kube_events_manager.KubeEvent, moduleManager module_manager.ModuleManager) (*struct{ Tasks []task.Task }, bool) {
+	w.m.Lock()
+	defer w.m.Unlock()
+
+	moduleName, hasConfigId := w.ModuleByConfigId[kubeEvent.ConfigId]
+	if !hasConfigId {
+		return nil, false
+	}
+
+	res := &struct{ Tasks []task.Task }{Tasks: make([]task.Task, 0)}
+
+	release := w.ReleasesByModule[moduleName]
+	if release == nil || release.Suspended {
+		return res, true
+	}
+
+	resource, isReleaseResource := release.Resources[releaseResourceKey(kubeEvent.Kind, kubeEvent.Namespace, kubeEvent.Name)]
+	if !isReleaseResource && kubeEvent.Namespace == "" {
+		// cluster scoped object has no namespace in event
+		for _, r := range release.Resources {
+			if r.Kind == kubeEvent.Kind && r.Name == kubeEvent.Name {
+				resource, isReleaseResource = r, true
+				break
+			}
+		}
+	}
+	if !isReleaseResource {
+		return res, true
+	}
+
+	rlog.Warnf("RELEASE_WATCH module '%s': release resource %s is changed externally: %v", moduleName, resource.String(), kubeEvent.Events)
+	MetricsStorage.SendCounterMetric("antiopa_module_release_drift", 1.0, map[string]string{"module": moduleName})
+
+	if release.Mode == module_manager.ReleaseWatchRerun {
+		if err := moduleManager.ForceModuleHelmUpgrade(moduleName); err != nil {
+			rlog.Errorf("RELEASE_WATCH module '%s': cannot force helm upgrade: %s", moduleName, err)
+			return res, true
+		}
+		res.Tasks = append(res.Tasks, task.NewTask(task.ModuleRun, moduleName).WithCause("release resources changed"))
+		// Only one module run is needed for a batch of changes
+		release.Suspended = true
+	}
+
+	return res, true
+}
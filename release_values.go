@@ -0,0 +1,38 @@
+package main
+
+import (
+	"github.com/flant/antiopa/utils"
+)
+
+// ReleaseValues are values of the module release for debug API: values passed by antiopa
+// and computed values with defaults from values.yaml of the chart.
+type ReleaseValues struct {
+	Module   string       `json:"module"`
+	Release  string       `json:"release"`
+	User     utils.Values `json:"user"`
+	Computed utils.Values `json:"computed"`
+}
+
+// GetModuleReleaseValues returns user and computed values of the module release
+func GetModuleReleaseValues(moduleName string) (*ReleaseValues, error) {
+	module, err := ModuleManager.GetModule(moduleName)
+	if err != nil {
+		return nil, err
+	}
+	helmClient, err := module.HelmClient()
+	if err != nil {
+		return nil, err
+	}
+
+	res := &ReleaseValues{
+		Module:  moduleName,
+		Release: utils.ModuleReleaseName(moduleName),
+	}
+	if res.User, err = helmClient.GetReleaseValues(res.Release, false); err != nil {
+		return nil, err
+	}
+	if res.Computed, err = helmClient.GetReleaseValues(res.Release, true); err != nil {
+		return nil, err
+	}
+	return res, nil
+}
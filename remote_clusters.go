@@ -0,0 +1,33 @@
+package main
+
+import (
+	"github.com/flant/antiopa/helm"
+)
+
+// RemoteClusterStatus is a state of the remote cluster and enabled modules released in it
+type RemoteClusterStatus struct {
+	helm.ClusterStatus
+	Modules []string `json:"modules"`
+}
+
+// DumpRemoteClusters returns states of remote clusters
+func DumpRemoteClusters() []RemoteClusterStatus {
+	modulesByCluster := make(map[string][]string)
+	for _, moduleName := range ModuleManager.GetModuleNamesInOrder() {
+		module, err := ModuleManager.GetModule(moduleName)
+		if err != nil || module.Cluster() == "" {
+			continue
+		}
+		modulesByCluster[module.Cluster()] = append(modulesByCluster[module.Cluster()], moduleName)
+	}
+
+	res := make([]RemoteClusterStatus, 0)
+	for _, status := range HelmClients.ClustersStatus() {
+		modules := modulesByCluster[status.Name]
+		if modules == nil {
+			modules = make([]string, 0)
+		}
+		res = append(res, RemoteClusterStatus{ClusterStatus: status, Modules: modules})
+	}
+	return res
+}
@@ -0,0 +1,47 @@
+package main
+
+import (
+	"time"
+
+	"github.com/flant/antiopa/module_manager"
+	"github.com/flant/antiopa/task"
+)
+
+// runResult is a value of the result label of run metrics
+func runResult(err error) string {
+	if err != nil {
+		return "error"
+	}
+	return "success"
+}
+
+// SendModuleRunMetrics sends duration and result of the module run and errors of helm upgrade
+func SendModuleRunMetrics(moduleName string, startedAt time.Time, err error) {
+	labels := map[string]string{"module": moduleName, "result": runResult(err)}
+	MetricsStorage.SendHistogramMetric("antiopa_module_run_seconds", time.Since(startedAt).Seconds(), labels)
+	if module_manager.IsHelmUpgradeFailed(err) {
+		MetricsStorage.SendCounterMetric("antiopa_module_helm_upgrade_errors", 1.0, map[string]string{"module": moduleName})
+	}
+}
+
+// SendHookRunMetrics sends duration and result of the hook run by binding type,
+// failures are counted by the histogram with result="error"
+func SendHookRunMetrics(t task.Task, startedAt time.Time, err error) {
+	duration := time.Since(startedAt).Seconds()
+	if t.GetType() == task.GlobalHookRun {
+		_, hookLabel := hookErrorLabels(err, t.GetName())
+		MetricsStorage.SendHistogramMetric("antiopa_global_hook_run_seconds", duration, map[string]string{
+			"hook":    hookLabel,
+			"binding": string(t.GetBinding()),
+			"result":  runResult(err),
+		})
+		return
+	}
+	moduleLabel, hookLabel := hookErrorLabels(err, t.GetName())
+	MetricsStorage.SendHistogramMetric("antiopa_module_hook_run_seconds", duration, map[string]string{
+		"module":  moduleLabel,
+		"hook":    hookLabel,
+		"binding": string(t.GetBinding()),
+		"result":  runResult(err),
+	})
+}
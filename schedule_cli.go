@@ -0,0 +1,85 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+)
+
+// RunScheduleCommand handles `antiopa schedule list [--next N] [--json]`: schedule bindings
+// of hooks with crontabs, next runs and results of last runs from the running antiopa.
+func RunScheduleCommand(args []string) error {
+	const usage = "usage: antiopa schedule list [--next N] [--json]"
+	if len(args) == 0 || args[0] != "list" {
+		return fmt.Errorf(usage)
+	}
+
+	flags := flag.NewFlagSet("schedule list", flag.ContinueOnError)
+	next := flags.Int("next", 3, "number of next runs of each binding")
+	asJson := flags.Bool("json", false, "print schedules as JSON")
+	if err := flags.Parse(args[1:]); err != nil {
+		return err
+	}
+
+	client := &http.Client{Timeout: 60 * time.Second}
+	resp, err := client.Get(ApiAddress + "/schedules?next=" + strconv.Itoa(*next))
+	if err != nil {
+		return fmt.Errorf("cannot get schedules: %s", err)
+	}
+	defer resp.Body.Close()
+	body, _ := ioutil.ReadAll(resp.Body)
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("cannot get schedules: %s: %s", resp.Status, string(body))
+	}
+	if *asJson {
+		fmt.Print(string(body))
+		return nil
+	}
+
+	schedules := make([]EffectiveSchedule, 0)
+	if err := json.Unmarshal(body, &schedules); err != nil {
+		return fmt.Errorf("bad schedules: %s", err)
+	}
+	fmt.Print(formatSchedules(schedules))
+	return nil
+}
+
+// formatSchedules returns a table of schedules sorted by the next run
+func formatSchedules(schedules []EffectiveSchedule) string {
+	buf := &bytes.Buffer{}
+	w := tabwriter.NewWriter(buf, 0, 4, 2, ' ', 0)
+	fmt.Fprintln(w, "HOOK\tBINDING\tCRONTAB\tQUEUE\tNEXT RUNS\tLAST RUN\tRESULT")
+	for _, schedule := range schedules {
+		nextRuns := schedule.NextRuns
+		if len(nextRuns) == 0 {
+			nextRuns = []time.Time{schedule.NextRun}
+		}
+		formattedRuns := make([]string, 0, len(nextRuns))
+		for _, run := range nextRuns {
+			formattedRuns = append(formattedRuns, run.Format(time.RFC3339))
+		}
+
+		queue := schedule.Queue
+		if queue == "" {
+			queue = "main"
+		}
+		lastRun, result := "-", "-"
+		if schedule.LastRun != nil {
+			lastRun = schedule.LastRun.StartedAt.Format(time.RFC3339)
+			result = schedule.LastRun.Result
+			if schedule.LastRun.Error != "" {
+				result = fmt.Sprintf("%s: %s", result, schedule.LastRun.Error)
+			}
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\t%s\n", schedule.Hook, schedule.Binding, schedule.Crontab, queue, strings.Join(formattedRuns, ","), lastRun, result)
+	}
+	w.Flush()
+	return buf.String()
+}
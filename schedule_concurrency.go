@@ -0,0 +1,63 @@
+package main
+
+import (
+	"github.com/romana/rlog"
+
+	"github.com/flant/antiopa/module_manager"
+	"github.com/flant/antiopa/task"
+)
+
+// AddScheduledHookTask adds a task of the schedule binding. The previous run of the same
+// binding that is queued or running is kept with Allow policy, prevents the new run with
+// Forbid policy and is cancelled with Replace policy.
+func AddScheduledHookTask(t task.Task, policy module_manager.ScheduleConcurrencyPolicy) {
+	if policy == "" || policy == module_manager.ScheduleConcurrencyAllow {
+		AddHookTask(t)
+		return
+	}
+
+	previous, running := HookTaskQueue(t).Find(sameScheduleBinding(t))
+	if previous == nil {
+		AddHookTask(t)
+		return
+	}
+
+	state := "queued"
+	if running {
+		state = "running"
+	}
+
+	switch policy {
+	case module_manager.ScheduleConcurrencyForbid:
+		rlog.Infof("QUEUE skip %s@Schedule '%s': previous run #%s is %s, concurrencyPolicy is %s", t.GetType(), t.GetName(), previous.GetId(), state, policy)
+		MetricsStorage.SendCounterMetric("antiopa_schedule_skipped_runs", 1.0, map[string]string{"hook": t.GetName()})
+		return
+	case module_manager.ScheduleConcurrencyReplace:
+		rlog.Infof("QUEUE replace %s run #%s of %s@Schedule '%s', concurrencyPolicy is %s", state, previous.GetId(), t.GetType(), t.GetName(), policy)
+		if _, err := CancelTask(previous.GetId()); err != nil {
+			// previous run is done
+			rlog.Debugf("QUEUE cannot cancel previous run of '%s': %s", t.GetName(), err)
+		}
+	}
+	AddHookTask(t)
+}
+
+// sameScheduleBinding returns a predicate for not cancelled tasks of the same hook and schedule binding
+func sameScheduleBinding(t task.Task) func(task.Task) bool {
+	bindingName := scheduleBindingName(t)
+	return func(other task.Task) bool {
+		return other.GetType() == t.GetType() &&
+			other.GetName() == t.GetName() &&
+			other.GetBinding() == module_manager.Schedule &&
+			!other.IsCancelled() &&
+			scheduleBindingName(other) == bindingName
+	}
+}
+
+func scheduleBindingName(t task.Task) string {
+	context := t.GetBindingContext()
+	if len(context) == 0 {
+		return ""
+	}
+	return context[0].Binding
+}
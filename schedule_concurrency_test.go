@@ -0,0 +1,43 @@
+package main
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/flant/antiopa/module_manager"
+	"github.com/flant/antiopa/task"
+)
+
+func newScheduleTask(hookName string, bindingName string) task.Task {
+	return task.NewTask(task.GlobalHookRun, hookName).
+		WithBinding(module_manager.Schedule).
+		AppendBindingContext(module_manager.BindingContext{Binding: bindingName})
+}
+
+func TestAddScheduledHookTask(t *testing.T) {
+	defer func(q *task.TasksQueue) { TasksQueue = q }(TasksQueue)
+	TasksQueue = task.NewTasksQueue()
+	// running task at the head of the queue
+	TasksQueue.Add(task.NewTaskDelay(time.Second))
+
+	AddScheduledHookTask(newScheduleTask("backup", "hourly"), module_manager.ScheduleConcurrencyForbid)
+	AddScheduledHookTask(newScheduleTask("backup", "hourly"), module_manager.ScheduleConcurrencyForbid)
+	// other binding of the hook
+	AddScheduledHookTask(newScheduleTask("backup", "daily"), module_manager.ScheduleConcurrencyForbid)
+	assert.Equal(t, 3, TasksQueue.Length())
+
+	AddScheduledHookTask(newScheduleTask("backup", "hourly"), "")
+	assert.Equal(t, 4, TasksQueue.Length())
+
+	// queued runs are replaced by one
+	first, _ := TasksQueue.Find(sameScheduleBinding(newScheduleTask("backup", "hourly")))
+	AddScheduledHookTask(newScheduleTask("backup", "hourly"), module_manager.ScheduleConcurrencyReplace)
+	assert.Equal(t, 4, TasksQueue.Length())
+	assert.True(t, first.IsCancelled())
+
+	previous, running := TasksQueue.Find(sameScheduleBinding(newScheduleTask("backup", "hourly")))
+	assert.False(t, running)
+	assert.NotEqual(t, first.GetId(), previous.GetId())
+}
@@ -0,0 +1,72 @@
+package main
+
+import (
+	"sort"
+	"time"
+
+	"github.com/flant/antiopa/module_manager"
+	"github.com/flant/antiopa/schedule_manager"
+	"github.com/flant/antiopa/task"
+)
+
+// EffectiveSchedule is a schedule binding of the hook with time slicing offset
+type EffectiveSchedule struct {
+	Hook    string    `json:"hook"`
+	Binding string    `json:"binding"`
+	Crontab string    `json:"crontab"`
+	Offset  string    `json:"offset"`
+	NextRun time.Time `json:"nextRun"`
+	// next runs including NextRun if more than one run is requested
+	NextRuns          []time.Time                              `json:"nextRuns,omitempty"`
+	Queue             string                                   `json:"queue,omitempty"`
+	ConcurrencyPolicy module_manager.ScheduleConcurrencyPolicy `json:"concurrencyPolicy,omitempty"`
+	AllowFailure      bool                                     `json:"allowFailure,omitempty"`
+	LastRun           *ScheduleRunResult                       `json:"lastRun,omitempty"`
+}
+
+// QueueScheduledHookTask adds a task of the fired schedule after the offset of the hook
+// according to concurrency policy of the binding
+func QueueScheduledHookTask(t task.Task, offset time.Duration, policy module_manager.ScheduleConcurrencyPolicy) {
+	if offset <= 0 {
+		AddScheduledHookTask(t, policy)
+		return
+	}
+	time.AfterFunc(offset, func() {
+		AddScheduledHookTask(t, policy)
+	})
+}
+
+// DumpEffectiveSchedules returns schedules of hooks with offsets sorted by the next run.
+// NextRuns has next runs times if next is more than 1.
+func DumpEffectiveSchedules(storage ScheduledHooksStorage, next int) []EffectiveSchedule {
+	now := time.Now()
+	res := make([]EffectiveSchedule, 0)
+	for _, hook := range storage {
+		for _, schedule := range hook.Schedule {
+			bindingName := schedule.Name
+			if bindingName == "" {
+				bindingName = module_manager.ContextBindingType[module_manager.Schedule]
+			}
+			offset := schedule_manager.Offset(hook.Name, schedule.Crontab)
+			effective := EffectiveSchedule{
+				Hook:              hook.Name,
+				Binding:           bindingName,
+				Crontab:           schedule.Crontab,
+				Offset:            offset.String(),
+				NextRun:           schedule_manager.NextRun(schedule.Crontab, offset, now),
+				Queue:             schedule.Queue,
+				ConcurrencyPolicy: schedule.ConcurrencyPolicy,
+				AllowFailure:      schedule.AllowFailure,
+				LastRun:           ScheduleRuns.Last(hook.Name, bindingName),
+			}
+			if next > 1 {
+				effective.NextRuns = schedule_manager.NextRuns(schedule.Crontab, offset, now, next)
+			}
+			res = append(res, effective)
+		}
+	}
+	sort.SliceStable(res, func(i, j int) bool {
+		return res[i].NextRun.Before(res[j].NextRun)
+	})
+	return res
+}
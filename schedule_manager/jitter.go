@@ -0,0 +1,70 @@
+package schedule_manager
+
+import (
+	"hash/fnv"
+	"time"
+
+	"gopkg.in/robfig/cron.v2"
+)
+
+// Jitter is an interval to spread runs of hooks with the same crontab. Each hook gets a stable
+// offset within the interval, so hooks with '* * * * *' do not start at the same second.
+// Offset is not greater than a half of the crontab period. Zero disables time slicing.
+var Jitter time.Duration
+
+// Offset returns a delay of the hook run after the crontab is fired
+func Offset(hookName string, crontab string) time.Duration {
+	if Jitter <= 0 {
+		return 0
+	}
+
+	window := Jitter
+	if period := Period(crontab); period > 0 && window > period/2 {
+		window = period / 2
+	}
+	if window < time.Millisecond {
+		return 0
+	}
+
+	h := fnv.New64a()
+	h.Write([]byte(hookName + "@" + crontab))
+	return time.Duration(h.Sum64()%uint64(window/time.Millisecond)) * time.Millisecond
+}
+
+// Period returns an interval between the next two runs of crontab or 0 for bad crontab
+func Period(crontab string) time.Duration {
+	schedule, err := cron.Parse(crontab)
+	if err != nil {
+		return 0
+	}
+	next := schedule.Next(time.Now())
+	return schedule.Next(next).Sub(next)
+}
+
+// NextRun returns the time of the next hook run with offset after now
+func NextRun(crontab string, offset time.Duration, now time.Time) time.Time {
+	schedule, err := cron.Parse(crontab)
+	if err != nil {
+		return time.Time{}
+	}
+	// crontab may be fired already, but hook run is waiting for offset
+	return schedule.Next(now.Add(-offset)).Add(offset)
+}
+
+// NextRuns returns times of the next n hook runs with offset after now
+func NextRuns(crontab string, offset time.Duration, now time.Time, n int) []time.Time {
+	schedule, err := cron.Parse(crontab)
+	if err != nil {
+		return nil
+	}
+	res := make([]time.Time, 0, n)
+	fired := now.Add(-offset)
+	for i := 0; i < n; i++ {
+		fired = schedule.Next(fired)
+		if fired.IsZero() {
+			break
+		}
+		res = append(res, fired.Add(offset))
+	}
+	return res
+}
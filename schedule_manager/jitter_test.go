@@ -0,0 +1,49 @@
+package schedule_manager
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestOffset(t *testing.T) {
+	defer func() { Jitter = 0 }()
+
+	Jitter = 0
+	assert.Equal(t, time.Duration(0), Offset("hook", "* * * * *"))
+
+	Jitter = 20 * time.Second
+	offsets := map[time.Duration]bool{}
+	for _, hook := range []string{"a", "b", "c", "d", "e"} {
+		offset := Offset(hook, "* * * * *")
+		assert.True(t, offset >= 0 && offset < Jitter, "offset %s", offset)
+		assert.Equal(t, offset, Offset(hook, "* * * * *"), "offset should be stable")
+		offsets[offset] = true
+	}
+	assert.True(t, len(offsets) > 1, "hooks should have different offsets")
+
+	// offset is less than a half of period
+	Jitter = time.Hour
+	offset := Offset("hook", "*/10 * * * * *")
+	assert.True(t, offset < 5*time.Second, "offset %s", offset)
+}
+
+func TestNextRun(t *testing.T) {
+	now := time.Date(2019, 5, 1, 10, 0, 5, 0, time.UTC)
+
+	assert.Equal(t, time.Date(2019, 5, 1, 10, 0, 10, 0, time.UTC), NextRun("0 * * * * *", 10*time.Second, now))
+	assert.Equal(t, time.Date(2019, 5, 1, 10, 1, 3, 0, time.UTC), NextRun("0 * * * * *", 3*time.Second, now))
+	assert.Equal(t, time.Time{}, NextRun("bad", 0, now))
+}
+
+func TestNextRuns(t *testing.T) {
+	now := time.Date(2019, 5, 1, 10, 0, 5, 0, time.UTC)
+
+	assert.Equal(t, []time.Time{
+		time.Date(2019, 5, 1, 10, 0, 10, 0, time.UTC),
+		time.Date(2019, 5, 1, 10, 1, 10, 0, time.UTC),
+		time.Date(2019, 5, 1, 10, 2, 10, 0, time.UTC),
+	}, NextRuns("0 * * * * *", 10*time.Second, now, 3))
+	assert.Nil(t, NextRuns("bad", 0, now, 3))
+}
@@ -0,0 +1,70 @@
+package main
+
+import (
+	"sync"
+	"time"
+
+	"github.com/flant/antiopa/module_manager"
+	"github.com/flant/antiopa/task"
+)
+
+// Max number of next runs in /schedules?next=N
+const MaxScheduleNextRuns = 100
+
+// ScheduleRunResult is a result of the last run of the hook by the schedule binding
+type ScheduleRunResult struct {
+	StartedAt time.Time `json:"startedAt"`
+	Duration  string    `json:"duration"`
+	Result    string    `json:"result"`
+	Error     string    `json:"error,omitempty"`
+}
+
+// ScheduleRunResults keeps the last run of each schedule binding of hooks
+type ScheduleRunResults struct {
+	m       sync.Mutex
+	results map[string]ScheduleRunResult
+}
+
+func NewScheduleRunResults() *ScheduleRunResults {
+	return &ScheduleRunResults{results: make(map[string]ScheduleRunResult)}
+}
+
+func scheduleRunKey(hookName string, bindingName string) string {
+	return hookName + "@" + bindingName
+}
+
+// Record saves a result of the hook run task with schedule binding, other tasks are ignored
+func (r *ScheduleRunResults) Record(t task.Task, startedAt time.Time, err error) {
+	if t.GetBinding() != module_manager.Schedule {
+		return
+	}
+	result := ScheduleRunResult{
+		StartedAt: startedAt,
+		Duration:  time.Since(startedAt).String(),
+		Result:    runResult(err),
+	}
+	if err != nil {
+		result.Error = err.Error()
+	}
+
+	r.m.Lock()
+	defer r.m.Unlock()
+	// contexts of the same binding can be combined into one run
+	for _, bindingContext := range t.GetBindingContext() {
+		r.results[scheduleRunKey(t.GetName(), bindingContext.Binding)] = result
+	}
+}
+
+// Last returns the last run of the schedule binding of the hook, nil if binding is not run yet
+func (r *ScheduleRunResults) Last(hookName string, bindingName string) *ScheduleRunResult {
+	if r == nil {
+		return nil
+	}
+	r.m.Lock()
+	defer r.m.Unlock()
+	result, ok := r.results[scheduleRunKey(hookName, bindingName)]
+	if !ok {
+		return nil
+	}
+	return &result
+}
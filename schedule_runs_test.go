@@ -0,0 +1,57 @@
+package main
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/flant/antiopa/module_manager"
+	"github.com/flant/antiopa/task"
+)
+
+func TestScheduleRunResults(t *testing.T) {
+	defer func() { ScheduleRuns = nil }()
+	ScheduleRuns = NewScheduleRunResults()
+
+	storage := ScheduledHooksStorage{
+		{Name: "global-hooks/cleanup", Schedule: []module_manager.ScheduleConfig{
+			{Name: "cleanup", Crontab: "0 */5 * * * *", Queue: "slow", ConcurrencyPolicy: module_manager.ScheduleConcurrencyForbid},
+			{Crontab: "0 0 * * * *"},
+		}},
+	}
+
+	scheduleTask := task.NewTask(task.GlobalHookRun, "global-hooks/cleanup").
+		WithBinding(module_manager.Schedule).
+		AppendBindingContext(module_manager.BindingContext{Binding: "cleanup"})
+	ScheduleRuns.Record(scheduleTask, time.Now(), fmt.Errorf("timeout"))
+	// runs of other bindings are not recorded
+	ScheduleRuns.Record(task.NewTask(task.GlobalHookRun, "global-hooks/cleanup").WithBinding(module_manager.OnStartup), time.Now(), nil)
+
+	schedules := DumpEffectiveSchedules(storage, 3)
+	if !assert.Len(t, schedules, 2) {
+		return
+	}
+	for _, schedule := range schedules {
+		assert.Len(t, schedule.NextRuns, 3)
+		assert.Equal(t, schedule.NextRun, schedule.NextRuns[0])
+		if schedule.Binding == "cleanup" {
+			assert.Equal(t, "slow", schedule.Queue)
+			if assert.NotNil(t, schedule.LastRun) {
+				assert.Equal(t, "error", schedule.LastRun.Result)
+				assert.Equal(t, "timeout", schedule.LastRun.Error)
+			}
+		} else {
+			assert.Nil(t, schedule.LastRun)
+		}
+	}
+
+	table := formatSchedules(schedules)
+	assert.Contains(t, table, "global-hooks/cleanup")
+	assert.Contains(t, table, "error: timeout")
+	assert.Contains(t, table, "main")
+
+	// next runs are not listed by default
+	assert.Nil(t, DumpEffectiveSchedules(storage, 1)[0].NextRuns)
+}
@@ -0,0 +1,48 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/romana/rlog"
+
+	"github.com/flant/antiopa/task"
+)
+
+// QueueScopedConverge adds ModuleRun tasks for enabled modules with the tag and release
+// namespace in the order of modules. Runs are urgent like manual runs of modules.
+func QueueScopedConverge(tag string, namespace string) ([]string, error) {
+	scope := make([]string, 0)
+	if tag != "" {
+		scope = append(scope, fmt.Sprintf("tag=%s", tag))
+	}
+	if namespace != "" {
+		scope = append(scope, fmt.Sprintf("namespace=%s", namespace))
+	}
+	cause := fmt.Sprintf("scoped converge %s", strings.Join(scope, ","))
+
+	modulesNames := make([]string, 0)
+	for _, moduleName := range ModuleManager.GetModuleNamesInOrder() {
+		module, err := ModuleManager.GetModule(moduleName)
+		if err != nil {
+			return nil, err
+		}
+		matches, err := module.MatchesScope(tag, namespace)
+		if err != nil {
+			return nil, fmt.Errorf("module '%s': %s", moduleName, err)
+		}
+		if matches {
+			modulesNames = append(modulesNames, moduleName)
+		}
+	}
+
+	for _, moduleName := range modulesNames {
+		newTask := task.NewTask(task.ModuleRun, moduleName).
+			WithCause(cause).
+			WithUrgent(true)
+		TasksQueue.Add(newTask)
+		rlog.Infof("QUEUE add ModuleRun %s: %s", moduleName, cause)
+	}
+
+	return modulesNames, nil
+}
@@ -0,0 +1,37 @@
+// Package sdk has formats of files that antiopa passes to hooks and reads from hooks, so hooks
+// written in Go do not copy structs from antiopa. Package has no dependencies on antiopa internals.
+// JSON schemas of the same formats for hooks in other languages are in the schemas directory.
+package sdk
+
+// Events of onKubernetesEvent bindings in ResourceEvent
+const (
+	ResourceEventAdded    = "ADDED"
+	ResourceEventModified = "MODIFIED"
+	ResourceEventDeleted  = "DELETED"
+)
+
+// BindingContext is an item of the json array in BINDING_CONTEXT_PATH file
+type BindingContext struct {
+	// binding name: name of schedule or onKubernetesEvent binding, or a binding type, e.g. "beforeHelm"
+	Binding           string `json:"binding"`
+	ResourceEvent     string `json:"resourceEvent,omitempty"`
+	ResourceNamespace string `json:"resourceNamespace,omitempty"`
+	ResourceKind      string `json:"resourceKind,omitempty"`
+	ResourceName      string `json:"resourceName,omitempty"`
+	// object of onKubernetesEvent binding that triggered the hook run
+	Object map[string]interface{} `json:"object,omitempty"`
+	// results of hook nodeExec command on nodes
+	NodeExecResults []NodeExecResult `json:"nodeExecResults,omitempty"`
+	// name of http poller and its new response
+	HttpPoller   string      `json:"httpPoller,omitempty"`
+	HttpResponse interface{} `json:"httpResponse,omitempty"`
+}
+
+// NodeExecResult is a result of nodeExec command on a node
+type NodeExecResult struct {
+	Node     string `json:"node"`
+	ExitCode int    `json:"exitCode"`
+	Output   string `json:"output"`
+	// error if command is not completed: pod is not started, timeout, etc.
+	Error string `json:"error,omitempty"`
+}
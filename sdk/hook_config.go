@@ -0,0 +1,124 @@
+package sdk
+
+import (
+	"encoding/json"
+	"os"
+)
+
+// IsConfigRun returns true if antiopa runs the hook with --config argument to get its config
+func IsConfigRun() bool {
+	return len(os.Args) > 1 && os.Args[1] == "--config"
+}
+
+// PrintConfig prints GlobalHookConfig or ModuleHookConfig for antiopa
+func PrintConfig(config interface{}) error {
+	return json.NewEncoder(os.Stdout).Encode(config)
+}
+
+// GlobalHookConfig is printed by the global hook run with --config argument.
+// Orders are numbers, hooks of a binding are run in ascending order.
+type GlobalHookConfig struct {
+	HookConfig
+	BeforeAll  *float64 `json:"beforeAll,omitempty"`
+	AfterAll   *float64 `json:"afterAll,omitempty"`
+	OnShutdown *float64 `json:"onShutdown,omitempty"`
+}
+
+// ModuleHookConfig is printed by the module hook run with --config argument
+type ModuleHookConfig struct {
+	HookConfig
+	BeforeHelm      *float64 `json:"beforeHelm,omitempty"`
+	AfterHelm       *float64 `json:"afterHelm,omitempty"`
+	AfterDeleteHelm *float64 `json:"afterDeleteHelm,omitempty"`
+	// beforeHelm and afterHelm hooks with this flag can run concurrently with other parallel hooks
+	Parallel bool `json:"parallel,omitempty"`
+}
+
+// HookConfig has bindings of global and module hooks
+type HookConfig struct {
+	OnStartup         *float64                  `json:"onStartup,omitempty"`
+	Schedule          []ScheduleConfig          `json:"schedule,omitempty"`
+	OnKubernetesEvent []OnKubernetesEventConfig `json:"onKubernetesEvent,omitempty"`
+	NodeExec          *NodeExecConfig           `json:"nodeExec,omitempty"`
+	// hook is killed after timeout in seconds
+	Timeout int `json:"timeout,omitempty"`
+	// hook runs are held until these modules are converged successfully at least once
+	WaitForModules []string `json:"waitForModules,omitempty"`
+	// format of CONFIG_VALUES_PATH and VALUES_PATH files: "json" or "yaml"
+	ValuesFormat string `json:"valuesFormat,omitempty"`
+	// RBAC of the ServiceAccount of the hook for kubectl in KUBECONFIG
+	KubernetesScope *KubernetesScopeConfig `json:"kubernetesScope,omitempty"`
+	// hook is run in a Kubernetes Job with the ServiceAccount of the hook
+	Job *HookJobConfig `json:"job,omitempty"`
+}
+
+// HookJobConfig runs the hook in a Kubernetes Job instead of the antiopa container
+type HookJobConfig struct {
+	// image with the hook at the same path as in antiopa, image of antiopa is used if empty
+	Image string `json:"image,omitempty"`
+	// RuntimeClass of the pod for an alternate container runtime, e.g. gvisor or kata
+	RuntimeClassName string `json:"runtimeClassName,omitempty"`
+}
+
+// KubernetesScopeConfig is RBAC of the ServiceAccount of the hook
+type KubernetesScopeConfig struct {
+	// ClusterRole from -hook-cluster-role flag is bound if empty, ServiceAccount has no bindings if both are empty
+	ClusterRole string `json:"clusterRole,omitempty"`
+	// ClusterRole is bound in these namespaces, in all namespaces if empty
+	Namespaces []string `json:"namespaces,omitempty"`
+}
+
+// NodeExecConfig is a command to run in host namespaces of selected nodes before the hook
+type NodeExecConfig struct {
+	Command      []string          `json:"command"`
+	NodeSelector map[string]string `json:"nodeSelector,omitempty"`
+	Image        string            `json:"image,omitempty"`
+	// timeout in seconds
+	Timeout int `json:"timeout,omitempty"`
+}
+
+// ScheduleConfig is a crontab binding
+type ScheduleConfig struct {
+	Name         string `json:"name,omitempty"`
+	Crontab      string `json:"crontab"`
+	AllowFailure bool   `json:"allowFailure,omitempty"`
+	// named queue for hook runs, main queue is used if empty
+	Queue string `json:"queue,omitempty"`
+	// "Allow", "Forbid" or "Replace"
+	ConcurrencyPolicy string `json:"concurrencyPolicy,omitempty"`
+}
+
+// OnKubernetesEventConfig is a binding to events of kubernetes objects
+type OnKubernetesEventConfig struct {
+	Name string `json:"name,omitempty"`
+	// "add", "update" or "delete", all events if empty
+	EventTypes        []string           `json:"event,omitempty"`
+	ApiVersion        string             `json:"apiVersion,omitempty"`
+	Kind              string             `json:"kind"`
+	Selector          *LabelSelector     `json:"selector,omitempty"`
+	NamespaceSelector *NamespaceSelector `json:"namespaceSelector,omitempty"`
+	JqFilter          string             `json:"jqFilter,omitempty"`
+	AllowFailure      bool               `json:"allowFailure,omitempty"`
+	DisableDebug      bool               `json:"disableDebug,omitempty"`
+	Queue             string             `json:"queue,omitempty"`
+	// events are collected during this period, e.g. "10s", and the hook is run once
+	AggregationPeriod string `json:"aggregationPeriod,omitempty"`
+}
+
+// LabelSelector has the format of kubernetes label selector
+type LabelSelector struct {
+	MatchLabels      map[string]string          `json:"matchLabels,omitempty"`
+	MatchExpressions []LabelSelectorRequirement `json:"matchExpressions,omitempty"`
+}
+
+type LabelSelectorRequirement struct {
+	Key string `json:"key"`
+	// "In", "NotIn", "Exists" or "DoesNotExist"
+	Operator string   `json:"operator"`
+	Values   []string `json:"values,omitempty"`
+}
+
+type NamespaceSelector struct {
+	MatchNames []string `json:"matchNames,omitempty"`
+	Any        bool     `json:"any,omitempty"`
+}
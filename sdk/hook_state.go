@@ -0,0 +1,66 @@
+package sdk
+
+import (
+	"encoding/json"
+	"fmt"
+	"os"
+	"time"
+)
+
+// HookStateOperation is a line of HOOK_STATE_PATCH_PATH file
+type HookStateOperation struct {
+	// "set" or "delete"
+	Op    string      `json:"op"`
+	Key   string      `json:"key"`
+	Value interface{} `json:"value,omitempty"`
+	// key is expired after ttl, e.g. "24h"
+	Ttl string `json:"ttl,omitempty"`
+}
+
+// ReadHookState reads the state shared by hooks of the module
+func ReadHookState() (map[string]json.RawMessage, error) {
+	res := make(map[string]json.RawMessage)
+	if err := readJsonFile(os.Getenv(HookStatePathEnv), &res); err != nil {
+		return nil, err
+	}
+	return res, nil
+}
+
+// WriteHookStatePatch writes changes of the state, they are applied if hook is succeeded
+func WriteHookStatePatch(operations []HookStateOperation) error {
+	path := os.Getenv(HookStatePatchPathEnv)
+	if path == "" {
+		return fmt.Errorf("%s is not set", HookStatePatchPathEnv)
+	}
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	encoder := json.NewEncoder(f)
+	for _, operation := range operations {
+		if err := encoder.Encode(operation); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Deadline returns the deadline of the hook run, ok is false if hook has no timeout
+func Deadline() (deadline time.Time, ok bool) {
+	deadline, err := time.Parse(time.RFC3339, os.Getenv(HookDeadlineEnv))
+	if err != nil {
+		return time.Time{}, false
+	}
+	return deadline, true
+}
+
+// IsCancelled returns true if antiopa asks the hook to exit before the deadline
+func IsCancelled() bool {
+	path := os.Getenv(HookCancelPathEnv)
+	if path == "" {
+		return false
+	}
+	_, err := os.Stat(path)
+	return err == nil
+}
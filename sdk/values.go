@@ -0,0 +1,108 @@
+package sdk
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"os"
+)
+
+// Environment variables with paths of hook files
+const (
+	BindingContextPathEnv        = "BINDING_CONTEXT_PATH"
+	ConfigValuesJsonPathEnv      = "CONFIG_VALUES_JSON_PATH"
+	ValuesJsonPathEnv            = "VALUES_JSON_PATH"
+	ConfigValuesJsonPatchPathEnv = "CONFIG_VALUES_JSON_PATCH_PATH"
+	ValuesJsonPatchPathEnv       = "VALUES_JSON_PATCH_PATH"
+	ModuleEnabledResultPathEnv   = "MODULE_ENABLED_RESULT"
+	HookStatePathEnv             = "HOOK_STATE_PATH"
+	HookStatePatchPathEnv        = "HOOK_STATE_PATCH_PATH"
+	// deadline of the hook run in RFC3339, hook is killed after it
+	HookDeadlineEnv = "HOOK_DEADLINE"
+	// file is created shortly before the deadline, hook should exit when it exists
+	HookCancelPathEnv = "HOOK_CANCEL_PATH"
+)
+
+// Values are values of modules: "global" section and sections with camelCase names of modules
+type Values map[string]interface{}
+
+// ValuesPatchOperation is an operation of json patch for values
+type ValuesPatchOperation struct {
+	// "add", "remove", "replace", "move" or "copy"
+	Op    string      `json:"op"`
+	Path  string      `json:"path"`
+	Value interface{} `json:"value"`
+	// source path of move and copy operations
+	From string `json:"from,omitempty"`
+}
+
+// ReadBindingContext reads binding contexts of the hook run
+func ReadBindingContext() ([]BindingContext, error) {
+	res := make([]BindingContext, 0)
+	if err := readJsonFile(os.Getenv(BindingContextPathEnv), &res); err != nil {
+		return nil, err
+	}
+	return res, nil
+}
+
+// ReadValues reads effective values of the hook: static, config and dynamic values
+func ReadValues() (Values, error) {
+	res := make(Values)
+	if err := readJsonFile(os.Getenv(ValuesJsonPathEnv), &res); err != nil {
+		return nil, err
+	}
+	return res, nil
+}
+
+// ReadConfigValues reads values from the antiopa ConfigMap
+func ReadConfigValues() (Values, error) {
+	res := make(Values)
+	if err := readJsonFile(os.Getenv(ConfigValuesJsonPathEnv), &res); err != nil {
+		return nil, err
+	}
+	return res, nil
+}
+
+// WriteValuesPatch writes the patch of dynamic values
+func WriteValuesPatch(operations []ValuesPatchOperation) error {
+	return writeJsonFile(os.Getenv(ValuesJsonPatchPathEnv), operations)
+}
+
+// WriteConfigValuesPatch writes the patch of values in the antiopa ConfigMap
+func WriteConfigValuesPatch(operations []ValuesPatchOperation) error {
+	return writeJsonFile(os.Getenv(ConfigValuesJsonPatchPathEnv), operations)
+}
+
+// WriteModuleEnabledResult writes the result of the enabled script of the module
+func WriteModuleEnabledResult(enabled bool) error {
+	path := os.Getenv(ModuleEnabledResultPathEnv)
+	if path == "" {
+		return fmt.Errorf("%s is not set", ModuleEnabledResultPathEnv)
+	}
+	return ioutil.WriteFile(path, []byte(fmt.Sprintf("%t\n", enabled)), 0644)
+}
+
+func readJsonFile(path string, v interface{}) error {
+	if path == "" {
+		return fmt.Errorf("path is not set in environment")
+	}
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("cannot read '%s': %s", path, err)
+	}
+	if err := json.Unmarshal(data, v); err != nil {
+		return fmt.Errorf("cannot parse '%s': %s", path, err)
+	}
+	return nil
+}
+
+func writeJsonFile(path string, v interface{}) error {
+	if path == "" {
+		return fmt.Errorf("path is not set in environment")
+	}
+	data, err := json.Marshal(v)
+	if err != nil {
+		return err
+	}
+	return ioutil.WriteFile(path, data, 0644)
+}
@@ -0,0 +1,239 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"reflect"
+	"strconv"
+
+	"github.com/romana/rlog"
+	networking "k8s.io/api/networking/v1"
+	policy "k8s.io/api/policy/v1beta1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+	"k8s.io/apimachinery/pkg/util/intstr"
+
+	"github.com/flant/antiopa/helm"
+	"github.com/flant/antiopa/kube"
+)
+
+const (
+	SelfResourcesOff    = "off"
+	SelfResourcesDryRun = "dry-run"
+	SelfResourcesApply  = "apply"
+)
+
+// SelfResources is a mode of baseline resources for antiopa itself: NetworkPolicies of antiopa
+// and tiller and PodDisruptionBudget of antiopa. Resources are rendered at start from flags and
+// Deployments of antiopa and tiller, they are applied in apply mode and only logged in dry-run mode.
+// Resources are owned by the antiopa Deployment, so they are deleted with it.
+var SelfResources = SelfResourcesOff
+
+// Label of resources created by SelfResources
+const SelfResourceLabel = "antiopa.flant.com/self-resource"
+
+func checkSelfResourcesMode() error {
+	switch SelfResources {
+	case SelfResourcesOff, SelfResourcesDryRun, SelfResourcesApply:
+		return nil
+	}
+	return fmt.Errorf("unknown mode '%s', expected '%s', '%s' or '%s'", SelfResources, SelfResourcesApply, SelfResourcesDryRun, SelfResourcesOff)
+}
+
+// SelfResourcesConfig is the runtime configuration that resources are rendered from
+type SelfResourcesConfig struct {
+	Namespace string
+	// labels of antiopa Pods from the Deployment selector
+	Selector map[string]string
+	Replicas int32
+	// ports of API and of config validating webhook, 0 if webhook is disabled
+	ApiPort     int
+	WebhookPort int
+	// labels of tiller Pods, nil if tiller Deployment is not managed by antiopa
+	TillerSelector  map[string]string
+	TillerNamespace string
+	Owner           metav1.OwnerReference
+}
+
+// SelfResourcesSet is a set of rendered resources
+type SelfResourcesSet struct {
+	PodDisruptionBudget *policy.PodDisruptionBudget
+	NetworkPolicies     []*networking.NetworkPolicy
+}
+
+// RenderSelfResources renders resources for the config. Antiopa accepts connections only
+// on ports of API and webhook, tiller accepts connections only from antiopa. Egress is not
+// restricted: hooks and tiller access apiserver and external services.
+func RenderSelfResources(config SelfResourcesConfig) SelfResourcesSet {
+	meta := func(name string, namespace string) metav1.ObjectMeta {
+		res := metav1.ObjectMeta{
+			Name:      name,
+			Namespace: namespace,
+			Labels:    map[string]string{SelfResourceLabel: "true"},
+		}
+		// owner in another namespace is considered absent by garbage collector
+		if namespace == config.Namespace {
+			res.OwnerReferences = []metav1.OwnerReference{config.Owner}
+		}
+		return res
+	}
+	tcpPort := func(port int) networking.NetworkPolicyPort {
+		portValue := intstr.FromInt(port)
+		return networking.NetworkPolicyPort{Port: &portValue}
+	}
+
+	// PDB of one replica should not block drain of the node, antiopa recovers after restart
+	pdbSpec := policy.PodDisruptionBudgetSpec{Selector: &metav1.LabelSelector{MatchLabels: config.Selector}}
+	if config.Replicas > 1 {
+		minAvailable := intstr.FromInt(1)
+		pdbSpec.MinAvailable = &minAvailable
+	} else {
+		maxUnavailable := intstr.FromInt(1)
+		pdbSpec.MaxUnavailable = &maxUnavailable
+	}
+	res := SelfResourcesSet{
+		PodDisruptionBudget: &policy.PodDisruptionBudget{
+			ObjectMeta: meta(kube.AntiopaDeploymentName, config.Namespace),
+			Spec:       pdbSpec,
+		},
+	}
+
+	antiopaPorts := []networking.NetworkPolicyPort{tcpPort(config.ApiPort)}
+	if config.WebhookPort > 0 {
+		antiopaPorts = append(antiopaPorts, tcpPort(config.WebhookPort))
+	}
+	res.NetworkPolicies = append(res.NetworkPolicies, &networking.NetworkPolicy{
+		ObjectMeta: meta(kube.AntiopaDeploymentName, config.Namespace),
+		Spec: networking.NetworkPolicySpec{
+			PodSelector: metav1.LabelSelector{MatchLabels: config.Selector},
+			PolicyTypes: []networking.PolicyType{networking.PolicyTypeIngress},
+			Ingress:     []networking.NetworkPolicyIngressRule{{Ports: antiopaPorts}},
+		},
+	})
+
+	if config.TillerSelector != nil {
+		from := networking.NetworkPolicyPeer{PodSelector: &metav1.LabelSelector{MatchLabels: config.Selector}}
+		if config.TillerNamespace != config.Namespace {
+			from.NamespaceSelector = &metav1.LabelSelector{}
+		}
+		res.NetworkPolicies = append(res.NetworkPolicies, &networking.NetworkPolicy{
+			ObjectMeta: meta(helm.TillerDeploymentName, config.TillerNamespace),
+			Spec: networking.NetworkPolicySpec{
+				PodSelector: metav1.LabelSelector{MatchLabels: config.TillerSelector},
+				PolicyTypes: []networking.PolicyType{networking.PolicyTypeIngress},
+				Ingress: []networking.NetworkPolicyIngressRule{{
+					Ports: []networking.NetworkPolicyPort{tcpPort(helm.TillerPort)},
+					From:  []networking.NetworkPolicyPeer{from},
+				}},
+			},
+		})
+	}
+	return res
+}
+
+// portFromAddress returns a port of the listen address like ':9115'
+func portFromAddress(address string) (int, error) {
+	_, portString, err := net.SplitHostPort(address)
+	if err != nil {
+		return 0, err
+	}
+	return strconv.Atoi(portString)
+}
+
+// selfResourcesConfig gets the runtime configuration from flags and Deployments
+func selfResourcesConfig() (SelfResourcesConfig, error) {
+	config := SelfResourcesConfig{Namespace: kube.KubernetesAntiopaNamespace}
+
+	var err error
+	if config.ApiPort, err = portFromAddress(ListenAddress); err != nil {
+		return config, fmt.Errorf("bad listen address '%s': %s", ListenAddress, err)
+	}
+	if ConfigWebhookServiceName != "" {
+		if config.WebhookPort, err = portFromAddress(ConfigWebhookListenAddress); err != nil {
+			return config, fmt.Errorf("bad webhook listen address '%s': %s", ConfigWebhookListenAddress, err)
+		}
+	}
+
+	deployments := kube.KubernetesClient.AppsV1beta1().Deployments(kube.KubernetesAntiopaNamespace)
+	antiopaDeploy, err := deployments.Get(kube.AntiopaDeploymentName, metav1.GetOptions{})
+	if err != nil {
+		return config, fmt.Errorf("cannot get antiopa Deployment: %s", err)
+	}
+	if antiopaDeploy.Spec.Selector == nil || len(antiopaDeploy.Spec.Selector.MatchLabels) == 0 {
+		return config, fmt.Errorf("antiopa Deployment has no matchLabels in selector")
+	}
+	config.Selector = antiopaDeploy.Spec.Selector.MatchLabels
+	config.Replicas = 1
+	if antiopaDeploy.Spec.Replicas != nil {
+		config.Replicas = *antiopaDeploy.Spec.Replicas
+	}
+	isController := true
+	config.Owner = metav1.OwnerReference{
+		APIVersion: "apps/v1beta1",
+		Kind:       "Deployment",
+		Name:       antiopaDeploy.Name,
+		UID:        antiopaDeploy.UID,
+		Controller: &isController,
+	}
+
+	if !EmbeddedTiller && !ExternalTiller && HelmClient != nil {
+		config.TillerNamespace = HelmClient.TillerNamespace()
+		tillerDeploy, err := kube.KubernetesClient.AppsV1beta1().Deployments(config.TillerNamespace).Get(helm.TillerDeploymentName, metav1.GetOptions{})
+		if err != nil {
+			return config, fmt.Errorf("cannot get tiller Deployment: %s", err)
+		}
+		if tillerDeploy.Spec.Selector != nil && len(tillerDeploy.Spec.Selector.MatchLabels) > 0 {
+			config.TillerSelector = tillerDeploy.Spec.Selector.MatchLabels
+		}
+	}
+	return config, nil
+}
+
+// ApplySelfResources renders resources and creates or updates them in apply mode
+func ApplySelfResources() error {
+	config, err := selfResourcesConfig()
+	if err != nil {
+		return err
+	}
+	resources := RenderSelfResources(config)
+
+	if SelfResources == SelfResourcesDryRun {
+		data, _ := json.MarshalIndent(resources, "", "  ")
+		rlog.Infof("MAIN self resources would be applied:\n%s", data)
+		return nil
+	}
+
+	pdb := resources.PodDisruptionBudget
+	pdbClient := kube.Kubernetes.PolicyV1beta1().PodDisruptionBudgets(pdb.Namespace)
+	existingPdb, err := pdbClient.Get(pdb.Name, metav1.GetOptions{})
+	if errors.IsNotFound(err) {
+		_, err = pdbClient.Create(pdb)
+	} else if err == nil && !reflect.DeepEqual(existingPdb.Spec, pdb.Spec) {
+		// spec of PodDisruptionBudget is immutable in policy/v1beta1
+		err = pdbClient.Delete(existingPdb.Name, &metav1.DeleteOptions{})
+		if err == nil {
+			_, err = pdbClient.Create(pdb)
+		}
+	}
+	if err != nil {
+		return fmt.Errorf("cannot apply PodDisruptionBudget '%s': %s", pdb.Name, err)
+	}
+	rlog.Infof("MAIN self resources: PodDisruptionBudget '%s' is applied", pdb.Name)
+
+	for _, networkPolicy := range resources.NetworkPolicies {
+		client := kube.Kubernetes.NetworkingV1().NetworkPolicies(networkPolicy.Namespace)
+		existing, err := client.Get(networkPolicy.Name, metav1.GetOptions{})
+		if errors.IsNotFound(err) {
+			_, err = client.Create(networkPolicy)
+		} else if err == nil {
+			networkPolicy.ResourceVersion = existing.ResourceVersion
+			_, err = client.Update(networkPolicy)
+		}
+		if err != nil {
+			return fmt.Errorf("cannot apply NetworkPolicy '%s' in namespace '%s': %s", networkPolicy.Name, networkPolicy.Namespace, err)
+		}
+		rlog.Infof("MAIN self resources: NetworkPolicy '%s' in namespace '%s' is applied", networkPolicy.Name, networkPolicy.Namespace)
+	}
+	return nil
+}
@@ -0,0 +1,59 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+)
+
+func TestRenderSelfResources(t *testing.T) {
+	config := SelfResourcesConfig{
+		Namespace:       "antiopa",
+		Selector:        map[string]string{"app": "antiopa"},
+		Replicas:        1,
+		ApiPort:         9115,
+		TillerNamespace: "antiopa",
+		TillerSelector:  map[string]string{"app": "helm", "name": "tiller"},
+		Owner:           metav1.OwnerReference{Kind: "Deployment", Name: "antiopa", UID: "uid"},
+	}
+
+	res := RenderSelfResources(config)
+	pdb := res.PodDisruptionBudget
+	assert.Nil(t, pdb.Spec.MinAvailable)
+	assert.Equal(t, 1, pdb.Spec.MaxUnavailable.IntValue())
+	assert.Equal(t, "antiopa", pdb.OwnerReferences[0].Name)
+
+	if !assert.Len(t, res.NetworkPolicies, 2) {
+		return
+	}
+	antiopaPolicy := res.NetworkPolicies[0]
+	assert.Equal(t, map[string]string{"app": "antiopa"}, antiopaPolicy.Spec.PodSelector.MatchLabels)
+	if assert.Len(t, antiopaPolicy.Spec.Ingress[0].Ports, 1) {
+		assert.Equal(t, 9115, antiopaPolicy.Spec.Ingress[0].Ports[0].Port.IntValue())
+	}
+	tillerPolicy := res.NetworkPolicies[1]
+	assert.Equal(t, "tiller-deploy", tillerPolicy.Name)
+	assert.Equal(t, 44134, tillerPolicy.Spec.Ingress[0].Ports[0].Port.IntValue())
+	assert.Equal(t, map[string]string{"app": "antiopa"}, tillerPolicy.Spec.Ingress[0].From[0].PodSelector.MatchLabels)
+	assert.Nil(t, tillerPolicy.Spec.Ingress[0].From[0].NamespaceSelector)
+
+	// HA antiopa with webhook and embedded tiller
+	config.Replicas = 2
+	config.WebhookPort = 9443
+	config.TillerSelector = nil
+	res = RenderSelfResources(config)
+	assert.Equal(t, 1, res.PodDisruptionBudget.Spec.MinAvailable.IntValue())
+	if assert.Len(t, res.NetworkPolicies, 1) {
+		assert.Len(t, res.NetworkPolicies[0].Spec.Ingress[0].Ports, 2)
+	}
+}
+
+func TestPortFromAddress(t *testing.T) {
+	port, err := portFromAddress(":9115")
+	assert.NoError(t, err)
+	assert.Equal(t, 9115, port)
+
+	_, err = portFromAddress("9115")
+	assert.Error(t, err)
+}
@@ -0,0 +1,87 @@
+package main
+
+import (
+	"time"
+
+	"github.com/romana/rlog"
+
+	"github.com/flant/antiopa/executor"
+	"github.com/flant/antiopa/utils"
+)
+
+// How often cgroup stats of antiopa container are read
+const SelfMonitorInterval = 5 * time.Second
+
+// Commands are run one at a time when memory or CPU usage to limit ratio
+// is above the threshold. Throttling is disabled when usage falls below
+// the threshold minus hysteresis.
+var (
+	SelfThrottlingEnabled         = false
+	SelfThrottlingMemoryThreshold = 0.85
+	SelfThrottlingCpuThreshold    = 0.9
+)
+
+const SelfThrottlingHysteresis = 0.1
+
+// RunSelfMonitor sends metrics of antiopa container resources usage and
+// throttles hooks and helm commands when usage approaches limits.
+func RunSelfMonitor() {
+	prevStats, err := utils.ReadCgroupStats(utils.CgroupRoot)
+	if err != nil {
+		rlog.Infof("SELF_MONITOR cannot read cgroup stats, resources usage is not monitored: %s", err)
+		return
+	}
+	prevTime := time.Now()
+
+	for {
+		time.Sleep(SelfMonitorInterval)
+
+		stats, err := utils.ReadCgroupStats(utils.CgroupRoot)
+		if err != nil {
+			rlog.Errorf("SELF_MONITOR cannot read cgroup stats: %s", err)
+			continue
+		}
+		now := time.Now()
+
+		memoryRatio := stats.MemoryUsageRatio()
+		cpuRatio := stats.CpuUsageRatio(prevStats, now.Sub(prevTime))
+		prevStats, prevTime = stats, now
+
+		MetricsStorage.SendGaugeMetric("antiopa_memory_usage_bytes", float64(stats.MemoryUsage), map[string]string{})
+		MetricsStorage.SendGaugeMetric("antiopa_memory_limit_bytes", float64(stats.MemoryLimit), map[string]string{})
+		MetricsStorage.SendGaugeMetric("antiopa_cpu_usage_ratio", cpuRatio, map[string]string{})
+
+		if !SelfThrottlingEnabled {
+			continue
+		}
+
+		throttled := executor.IsThrottled()
+		newThrottled := selfThrottlingDecision(throttled, memoryRatio, cpuRatio)
+		if newThrottled != throttled {
+			if newThrottled {
+				rlog.Warnf("SELF_MONITOR memory usage %.0f%%, cpu usage %.0f%% of limits: run commands one at a time", memoryRatio*100, cpuRatio*100)
+			} else {
+				rlog.Infof("SELF_MONITOR memory usage %.0f%%, cpu usage %.0f%% of limits: throttling is disabled", memoryRatio*100, cpuRatio*100)
+			}
+			executor.SetThrottled(newThrottled)
+		}
+
+		throttledMetric := 0.0
+		if newThrottled {
+			throttledMetric = 1.0
+		}
+		MetricsStorage.SendGaugeMetric("antiopa_throttled", throttledMetric, map[string]string{})
+	}
+}
+
+// selfThrottlingDecision returns true if commands should be throttled
+func selfThrottlingDecision(throttled bool, memoryRatio float64, cpuRatio float64) bool {
+	if memoryRatio >= SelfThrottlingMemoryThreshold || cpuRatio >= SelfThrottlingCpuThreshold {
+		return true
+	}
+	if throttled {
+		return memoryRatio >= SelfThrottlingMemoryThreshold-SelfThrottlingHysteresis ||
+			cpuRatio >= SelfThrottlingCpuThreshold-SelfThrottlingHysteresis
+	}
+	return false
+}
@@ -0,0 +1,17 @@
+package main
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSelfThrottlingDecision(t *testing.T) {
+	assert.False(t, selfThrottlingDecision(false, 0.5, 0.5))
+	assert.True(t, selfThrottlingDecision(false, 0.9, 0.1))
+	assert.True(t, selfThrottlingDecision(false, 0.1, 0.95))
+
+	// throttling is kept until usage falls below threshold minus hysteresis
+	assert.True(t, selfThrottlingDecision(true, 0.8, 0.1))
+	assert.False(t, selfThrottlingDecision(true, 0.7, 0.1))
+}
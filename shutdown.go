@@ -0,0 +1,85 @@
+package main
+
+import (
+	"sync/atomic"
+	"time"
+
+	"github.com/romana/rlog"
+
+	"github.com/flant/antiopa/module_manager"
+	"github.com/flant/antiopa/task"
+)
+
+// ShutdownTimeout is a time to stop tasks queues and run onShutdown hooks after SIGTERM.
+// It should be less than terminationGracePeriodSeconds of antiopa Pod.
+var ShutdownTimeout = 25 * time.Second
+
+var (
+	shuttingDown int32
+	// number of running RunTasksQueue loops
+	runningTasksQueues int32
+)
+
+// IsShuttingDown returns true after SIGTERM: queues do not start new tasks
+func IsShuttingDown() bool {
+	return atomic.LoadInt32(&shuttingDown) == 1
+}
+
+// Shutdown stops tasks queues and runs onShutdown global hooks. Running tasks are waited
+// for a half of ShutdownTimeout at most, so hooks are run even if a long helm upgrade is in progress.
+func Shutdown() {
+	deadline := time.Now().Add(ShutdownTimeout)
+	atomic.StoreInt32(&shuttingDown, 1)
+
+	if !waitTasksQueuesStopped(ShutdownTimeout / 2) {
+		rlog.Warnf("MAIN shutdown: running tasks are not done in %s, run onShutdown hooks", ShutdownTimeout/2)
+	}
+
+	if ModuleManager == nil {
+		return
+	}
+	RunShutdownHooks(ModuleManager, deadline)
+}
+
+func waitTasksQueuesStopped(timeout time.Duration) bool {
+	deadline := time.Now().Add(timeout)
+	for atomic.LoadInt32(&runningTasksQueues) > 0 {
+		if time.Now().After(deadline) {
+			return false
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+	return true
+}
+
+// RunShutdownHooks runs onShutdown global hooks in order until the deadline. Failed hooks
+// are not retried: the next hook is run.
+func RunShutdownHooks(moduleManager module_manager.ModuleManager, deadline time.Time) {
+	hooks := moduleManager.GetGlobalHooksInOrder(module_manager.OnShutdown)
+	if len(hooks) == 0 {
+		return
+	}
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for _, hookName := range hooks {
+			if time.Now().After(deadline) {
+				return
+			}
+			t := task.NewTask(task.GlobalHookRun, hookName).WithBinding(module_manager.OnShutdown)
+			rlog.Infof("TASK_RUN [%s] GlobalHookRun@%s %s", t.GetCorrelationId(), t.GetBinding(), t.GetName())
+			bindingContext := []module_manager.BindingContext{{Binding: module_manager.ContextBindingType[module_manager.OnShutdown]}}
+			if err := moduleManager.RunGlobalHook(hookName, module_manager.OnShutdown, bindingContext, t.GetCorrelationId()); err != nil {
+				rlog.Errorf("TASK_RUN [%s] GlobalHookRun@%s %s failed: %s", t.GetCorrelationId(), t.GetBinding(), t.GetName(), err)
+			}
+		}
+	}()
+
+	select {
+	case <-done:
+		rlog.Infof("MAIN shutdown: onShutdown hooks are done")
+	case <-time.After(time.Until(deadline)):
+		rlog.Errorf("MAIN shutdown: onShutdown hooks are not done in %s", ShutdownTimeout)
+	}
+}
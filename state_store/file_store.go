@@ -0,0 +1,47 @@
+package state_store
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+)
+
+// FileStore keeps data in the local file. File is replaced atomically,
+// so it has either an old or a new state after crash.
+type FileStore struct {
+	Path string
+}
+
+func (s *FileStore) String() string {
+	return fmt.Sprintf("file '%s'", s.Path)
+}
+
+func (s *FileStore) Load() ([]byte, error) {
+	data, err := ioutil.ReadFile(s.Path)
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	return data, err
+}
+
+func (s *FileStore) Save(data []byte) error {
+	dir := filepath.Dir(s.Path)
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return err
+	}
+	f, err := ioutil.TempFile(dir, filepath.Base(s.Path)+".tmp")
+	if err != nil {
+		return err
+	}
+	if _, err := f.Write(data); err != nil {
+		f.Close()
+		os.Remove(f.Name())
+		return err
+	}
+	if err := f.Close(); err != nil {
+		os.Remove(f.Name())
+		return err
+	}
+	return os.Rename(f.Name(), s.Path)
+}
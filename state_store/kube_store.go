@@ -0,0 +1,103 @@
+package state_store
+
+import (
+	"fmt"
+
+	"k8s.io/api/core/v1"
+	"k8s.io/apimachinery/pkg/api/errors"
+	metav1 "k8s.io/apimachinery/pkg/apis/meta/v1"
+
+	"github.com/flant/antiopa/kube"
+)
+
+// ConfigMapStore keeps data in the key of the ConfigMap in antiopa namespace
+type ConfigMapStore struct {
+	Name string
+	Key  string
+}
+
+func (s *ConfigMapStore) String() string {
+	return fmt.Sprintf("ConfigMap '%s' key '%s'", s.Name, s.Key)
+}
+
+func (s *ConfigMapStore) Load() ([]byte, error) {
+	cm, err := kube.KubernetesClient.CoreV1().ConfigMaps(kube.KubernetesAntiopaNamespace).Get(s.Name, metav1.GetOptions{})
+	if errors.IsNotFound(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	data, hasKey := cm.Data[s.Key]
+	if !hasKey {
+		return nil, nil
+	}
+	return []byte(data), nil
+}
+
+func (s *ConfigMapStore) Save(data []byte) error {
+	configMaps := kube.KubernetesClient.CoreV1().ConfigMaps(kube.KubernetesAntiopaNamespace)
+
+	cm, err := configMaps.Get(s.Name, metav1.GetOptions{})
+	if errors.IsNotFound(err) {
+		cm = &v1.ConfigMap{}
+		cm.Name = s.Name
+		cm.Data = map[string]string{s.Key: string(data)}
+		_, err = configMaps.Create(cm)
+		return err
+	}
+	if err != nil {
+		return err
+	}
+
+	if cm.Data == nil {
+		cm.Data = make(map[string]string)
+	}
+	cm.Data[s.Key] = string(data)
+	_, err = configMaps.Update(cm)
+	return err
+}
+
+// SecretStore keeps data in the key of the Secret in antiopa namespace
+type SecretStore struct {
+	Name string
+	Key  string
+}
+
+func (s *SecretStore) String() string {
+	return fmt.Sprintf("Secret '%s' key '%s'", s.Name, s.Key)
+}
+
+func (s *SecretStore) Load() ([]byte, error) {
+	secret, err := kube.KubernetesClient.CoreV1().Secrets(kube.KubernetesAntiopaNamespace).Get(s.Name, metav1.GetOptions{})
+	if errors.IsNotFound(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	return secret.Data[s.Key], nil
+}
+
+func (s *SecretStore) Save(data []byte) error {
+	secrets := kube.KubernetesClient.CoreV1().Secrets(kube.KubernetesAntiopaNamespace)
+
+	secret, err := secrets.Get(s.Name, metav1.GetOptions{})
+	if errors.IsNotFound(err) {
+		secret = &v1.Secret{}
+		secret.Name = s.Name
+		secret.Data = map[string][]byte{s.Key: data}
+		_, err = secrets.Create(secret)
+		return err
+	}
+	if err != nil {
+		return err
+	}
+
+	if secret.Data == nil {
+		secret.Data = make(map[string][]byte)
+	}
+	secret.Data[s.Key] = data
+	_, err = secrets.Update(secret)
+	return err
+}
@@ -0,0 +1,55 @@
+package state_store
+
+import (
+	"fmt"
+	"path/filepath"
+)
+
+// Store keeps a blob of antiopa operational state: dynamic values, hooks state, converge history, etc.
+type Store interface {
+	// Load returns nil data if there is no saved state
+	Load() ([]byte, error)
+	Save(data []byte) error
+	// String describes the place of the state for logs
+	String() string
+}
+
+const (
+	KindConfigMap = "configmap"
+	KindSecret    = "secret"
+	KindFile      = "file"
+)
+
+// Kind is a storage for all states. Each state uses its default storage if empty:
+// Secret for dynamic values, ConfigMap for other states. File storage needs a persistent
+// volume in Dir to survive pod restarts, Secret is for states with sensitive data.
+var Kind string
+
+// Dir is a directory of file stores
+var Dir = "/var/lib/antiopa"
+
+func CheckKind(kind string) error {
+	switch kind {
+	case "", KindConfigMap, KindSecret, KindFile:
+		return nil
+	}
+	return fmt.Errorf("unknown state storage '%s', expected '%s', '%s' or '%s'", kind, KindConfigMap, KindSecret, KindFile)
+}
+
+// New returns a store for the state. The state is kept in the key of ConfigMap or Secret
+// with the name in antiopa namespace, or in the file Dir/name/key.
+func New(defaultKind string, name string, key string) (Store, error) {
+	kind := defaultKind
+	if Kind != "" {
+		kind = Kind
+	}
+	switch kind {
+	case KindConfigMap:
+		return &ConfigMapStore{Name: name, Key: key}, nil
+	case KindSecret:
+		return &SecretStore{Name: name, Key: key}, nil
+	case KindFile:
+		return &FileStore{Path: filepath.Join(Dir, name, key)}, nil
+	}
+	return nil, CheckKind(kind)
+}
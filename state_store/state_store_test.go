@@ -0,0 +1,50 @@
+package state_store
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFileStore(t *testing.T) {
+	dir, err := ioutil.TempDir("", "state-store")
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer os.RemoveAll(dir)
+
+	store := &FileStore{Path: filepath.Join(dir, "antiopa-hooks-state", "hooks-state.json")}
+	data, err := store.Load()
+	assert.NoError(t, err)
+	assert.Nil(t, data)
+
+	assert.NoError(t, store.Save([]byte(`{"a":1}`)))
+	assert.NoError(t, store.Save([]byte(`{"a":2}`)))
+	data, err = store.Load()
+	assert.NoError(t, err)
+	assert.Equal(t, `{"a":2}`, string(data))
+
+	// temp files are not left
+	files, _ := ioutil.ReadDir(filepath.Dir(store.Path))
+	assert.Len(t, files, 1)
+}
+
+func TestNew(t *testing.T) {
+	defer func() { Kind = "" }()
+
+	store, err := New(KindSecret, "antiopa-dynamic-values", "dynamic-values.json")
+	assert.NoError(t, err)
+	assert.Equal(t, &SecretStore{Name: "antiopa-dynamic-values", Key: "dynamic-values.json"}, store)
+
+	Kind = KindFile
+	store, err = New(KindSecret, "antiopa-dynamic-values", "dynamic-values.json")
+	assert.NoError(t, err)
+	assert.Equal(t, &FileStore{Path: filepath.Join(Dir, "antiopa-dynamic-values", "dynamic-values.json")}, store)
+
+	Kind = "etcd"
+	_, err = New(KindConfigMap, "antiopa-hooks-state", "hooks-state.json")
+	assert.Error(t, err)
+}
@@ -2,7 +2,10 @@ package task
 
 import (
 	"bytes"
+	"crypto/rand"
+	"encoding/hex"
 	"fmt"
+	"sync/atomic"
 	"time"
 
 	"github.com/flant/antiopa/module_manager"
@@ -16,6 +19,8 @@ const (
 	ModuleHookRun        TaskType = "TASK_MODULE_HOOK_RUN"
 	GlobalHookRun        TaskType = "TASK_GLOBAL_HOOK_RUN"
 	DiscoverModulesState TaskType = "TASK_DISCOVER_MODULES_STATE"
+	// modules of the stage from task name wait for modules of previous stages
+	StageBarrier TaskType = "TASK_STAGE_BARRIER"
 	// удаление релиза без сведений о модуле
 	ModulePurge TaskType = "TASK_MODULE_PURGE"
 	// retry module_manager-а
@@ -26,6 +31,8 @@ const (
 )
 
 type Task interface {
+	GetId() string
+	GetCorrelationId() string
 	GetName() string
 	GetType() TaskType
 	GetBinding() module_manager.BindingType
@@ -35,9 +42,38 @@ type Task interface {
 	GetDelay() time.Duration
 	GetAllowFailure() bool
 	GetOnStartupHooks() bool
+	GetCause() string
+	GetUrgent() bool
+	GetQueueName() string
+	IsCancelled() bool
+	Cancel()
+}
+
+// last id of created task
+var lastTaskId uint64
+
+func nextTaskId() string {
+	return fmt.Sprintf("%d", atomic.AddUint64(&lastTaskId, 1))
+}
+
+// correlationIdPrefix is unique for antiopa process, so correlation ids do not repeat after restart
+var correlationIdPrefix = newCorrelationIdPrefix()
+
+func newCorrelationIdPrefix() string {
+	b := make([]byte, 4)
+	if _, err := rand.Read(b); err != nil {
+		return fmt.Sprintf("%08x", uint32(time.Now().UnixNano()))
+	}
+	return hex.EncodeToString(b)
+}
+
+func correlationId(taskId string) string {
+	return fmt.Sprintf("%s-%s", correlationIdPrefix, taskId)
 }
 
 type BaseTask struct {
+	Id             string // unique id to cancel the task with API
+	CorrelationId  string // id in log lines, hook env, release values and events to trace the task in aggregated logs
 	FailureCount   int    // failed executions count
 	Name           string // name of module or hook
 	Type           TaskType
@@ -47,10 +83,21 @@ type BaseTask struct {
 	AllowFailure   bool // task considered ok if hook failed. false by default. can be true for some schedule hooks
 
 	OnStartupHooks bool // run module onStartup hooks on antiopa startup or on module enabled
+
+	Cause string // why task is created: startup, config change, etc.
+
+	Urgent bool // ModuleRun is not deferred until module maintenance window
+
+	QueueName string // named queue for hook run, main queue if empty
+
+	cancelled int32 // task is cancelled by operator, it should not be run or retried
 }
 
 func NewTask(taskType TaskType, name string) *BaseTask {
+	id := nextTaskId()
 	return &BaseTask{
+		Id:             id,
+		CorrelationId:  correlationId(id),
 		FailureCount:   0,
 		Name:           name,
 		Type:           taskType,
@@ -59,6 +106,14 @@ func NewTask(taskType TaskType, name string) *BaseTask {
 	}
 }
 
+func (t *BaseTask) GetId() string {
+	return t.Id
+}
+
+func (t *BaseTask) GetCorrelationId() string {
+	return t.CorrelationId
+}
+
 func (t *BaseTask) GetName() string {
 	return t.Name
 }
@@ -87,6 +142,26 @@ func (t *BaseTask) GetOnStartupHooks() bool {
 	return t.OnStartupHooks
 }
 
+func (t *BaseTask) GetCause() string {
+	return t.Cause
+}
+
+func (t *BaseTask) GetUrgent() bool {
+	return t.Urgent
+}
+
+func (t *BaseTask) GetQueueName() string {
+	return t.QueueName
+}
+
+func (t *BaseTask) IsCancelled() bool {
+	return atomic.LoadInt32(&t.cancelled) == 1
+}
+
+func (t *BaseTask) Cancel() {
+	atomic.StoreInt32(&t.cancelled, 1)
+}
+
 func (t *BaseTask) WithBinding(binding module_manager.BindingType) *BaseTask {
 	t.Binding = binding
 	return t
@@ -112,9 +187,24 @@ func (t *BaseTask) WithOnStartupHooks(onStartupHooks bool) *BaseTask {
 	return t
 }
 
+func (t *BaseTask) WithCause(cause string) *BaseTask {
+	t.Cause = cause
+	return t
+}
+
+func (t *BaseTask) WithUrgent(urgent bool) *BaseTask {
+	t.Urgent = urgent
+	return t
+}
+
+func (t *BaseTask) WithQueueName(queueName string) *BaseTask {
+	t.QueueName = queueName
+	return t
+}
+
 func (t *BaseTask) DumpAsText() string {
 	var buf bytes.Buffer
-	buf.WriteString(fmt.Sprintf("%s '%s'", t.Type, t.Name))
+	buf.WriteString(fmt.Sprintf("#%s %s '%s'", t.Id, t.Type, t.Name))
 	if t.FailureCount > 0 {
 		buf.WriteString(fmt.Sprintf(" failed %d times. ", t.FailureCount))
 	}
@@ -130,8 +220,11 @@ func (t *BaseTask) IncrementFailureCount() {
 }
 
 func NewTaskDelay(delay time.Duration) *BaseTask {
+	id := nextTaskId()
 	return &BaseTask{
-		Type:  Delay,
-		Delay: delay,
+		Id:            id,
+		CorrelationId: correlationId(id),
+		Type:          Delay,
+		Delay:         delay,
 	}
 }
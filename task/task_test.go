@@ -0,0 +1,18 @@
+package task
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCorrelationId(t *testing.T) {
+	first := NewTask(ModuleRun, "a")
+	second := NewTaskDelay(0)
+
+	assert.True(t, strings.HasSuffix(first.GetCorrelationId(), "-"+first.GetId()))
+	assert.NotEqual(t, first.GetCorrelationId(), second.GetCorrelationId())
+	// prefix is the same for tasks of one process
+	assert.Equal(t, strings.Split(first.GetCorrelationId(), "-")[0], strings.Split(second.GetCorrelationId(), "-")[0])
+}
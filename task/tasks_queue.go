@@ -4,6 +4,7 @@ import (
 	"bytes"
 	"fmt"
 	"io"
+	"sync"
 
 	"github.com/flant/antiopa/utils"
 )
@@ -55,6 +56,10 @@ type FailureCountIncrementable interface {
 
 type TasksQueue struct {
 	*utils.Queue
+
+	// ids of tasks that are run concurrently with the head of the queue
+	inFlightM sync.Mutex
+	inFlight  map[string]bool
 }
 
 func (tq *TasksQueue) Add(task Task) {
@@ -81,10 +86,32 @@ func (tq *TasksQueue) Peek() (task Task, err error) {
 
 func NewTasksQueue() *TasksQueue {
 	return &TasksQueue{
-		Queue: utils.NewQueue(),
+		Queue:    utils.NewQueue(),
+		inFlight: make(map[string]bool),
 	}
 }
 
+// StartInFlight marks the queued task as in progress, e.g. ModuleRun tasks run in parallel
+func (tq *TasksQueue) StartInFlight(id string) {
+	tq.inFlightM.Lock()
+	defer tq.inFlightM.Unlock()
+	tq.inFlight[id] = true
+}
+
+// StopInFlight unmarks the task after its run
+func (tq *TasksQueue) StopInFlight(id string) {
+	tq.inFlightM.Lock()
+	defer tq.inFlightM.Unlock()
+	delete(tq.inFlight, id)
+}
+
+// IsInFlight returns true if the task is marked as in progress with StartInFlight
+func (tq *TasksQueue) IsInFlight(id string) bool {
+	tq.inFlightM.Lock()
+	defer tq.inFlightM.Unlock()
+	return tq.inFlight[id]
+}
+
 func (tq *TasksQueue) IncrementFailureCount() {
 	tq.Queue.WithLock(func(topTask interface{}) string {
 		if v, ok := topTask.(FailureCountIncrementable); ok {
@@ -94,6 +121,80 @@ func (tq *TasksQueue) IncrementFailureCount() {
 	})
 }
 
+// Cancel marks the task with id as cancelled. Queued task is removed from the queue.
+// Task at the head of the queue and in-flight tasks are in progress, they are only marked
+// and running is true.
+func (tq *TasksQueue) Cancel(id string) (task Task, running bool) {
+	match := func(item interface{}) bool {
+		t, ok := item.(Task)
+		return ok && t.GetId() == id
+	}
+	if tq.IsInFlight(id) {
+		if item, _ := tq.Queue.Find(match); item != nil {
+			task = item.(Task)
+			task.Cancel()
+			return task, true
+		}
+	}
+
+	item, isHead := tq.Queue.RemoveFirst(match)
+	if item == nil {
+		return nil, false
+	}
+
+	task = item.(Task)
+	task.Cancel()
+	return task, isHead
+}
+
+// Find returns the first task that matches predicate. Task at the head of the queue is in progress.
+func (tq *TasksQueue) Find(predicate func(task Task) bool) (task Task, running bool) {
+	item, isHead := tq.Queue.Find(func(item interface{}) bool {
+		t, ok := item.(Task)
+		return ok && predicate(t)
+	})
+	if item == nil {
+		return nil, false
+	}
+	return item.(Task), isHead
+}
+
+// Remove deletes the task with id from the queue, the task at the head of the queue too
+func (tq *TasksQueue) Remove(id string) Task {
+	item := tq.Queue.Remove(func(item interface{}) bool {
+		t, ok := item.(Task)
+		return ok && t.GetId() == id
+	})
+	if item == nil {
+		return nil
+	}
+	return item.(Task)
+}
+
+// HeadTasks returns consecutive tasks from the head of the queue that match predicate
+func (tq *TasksQueue) HeadTasks(predicate func(task Task) bool) []Task {
+	items := tq.Queue.HeadWhile(func(item interface{}) bool {
+		t, ok := item.(Task)
+		return ok && predicate(t)
+	})
+	res := make([]Task, 0, len(items))
+	for _, item := range items {
+		res = append(res, item.(Task))
+	}
+	return res
+}
+
+// UpdateLast calls update for the last queued task that matches predicate. Task at the head
+// of the queue is in progress and is not updated. Returns true if a task is updated.
+func (tq *TasksQueue) UpdateLast(predicate func(task Task) bool, update func(task Task)) bool {
+	return tq.Queue.UpdateLast(func(item interface{}) bool {
+		t, ok := item.(Task)
+		return ok && predicate(t)
+	}, func(item interface{}) {
+		update(item.(Task))
+	})
+}
+
 // прочитать дамп структуры для сохранения во временный файл
 func (tq *TasksQueue) DumpReader() io.Reader {
 	var buf bytes.Buffer
@@ -1,23 +1,37 @@
 package task
 
 import (
-	"io"
-	"os"
+	"io/ioutil"
+	"time"
 
 	"github.com/romana/rlog"
+
+	"github.com/flant/antiopa/state_store"
 )
 
 type TasksQueueDumper struct {
 	DumpFilePath string
-	queue        *TasksQueue
-	eventCh      chan struct{}
+	store        state_store.Store
+	// minimal period between dumps, changes are batched during it
+	interval time.Duration
+	queue    *TasksQueue
+	eventCh  chan struct{}
 }
 
 func NewTasksQueueDumper(dumpFilePath string, queue *TasksQueue) *TasksQueueDumper {
+	result := NewTasksQueueStoreDumper(&state_store.FileStore{Path: dumpFilePath}, 0, queue)
+	result.DumpFilePath = dumpFilePath
+	return result
+}
+
+// NewTasksQueueStoreDumper dumps the queue into the store at most once per interval,
+// so ConfigMap or Secret store is not updated on each change of the queue
+func NewTasksQueueStoreDumper(store state_store.Store, interval time.Duration, queue *TasksQueue) *TasksQueueDumper {
 	result := &TasksQueueDumper{
-		DumpFilePath: dumpFilePath,
-		queue:        queue,
-		eventCh:      make(chan struct{}, 1),
+		store:    store,
+		interval: interval,
+		queue:    queue,
+		eventCh:  make(chan struct{}, 1),
 	}
 	go result.WatchQueue()
 	return result
@@ -25,7 +39,11 @@ func NewTasksQueueDumper(dumpFilePath string, queue *TasksQueue) *TasksQueueDump
 
 // При изменении очереди сдампить её в файл
 func (t *TasksQueueDumper) QueueChangeCallback() {
-	t.eventCh <- struct{}{}
+	select {
+	case t.eventCh <- struct{}{}:
+	default:
+		// dump is already pending
+	}
 }
 
 func (t *TasksQueueDumper) WatchQueue() {
@@ -33,21 +51,18 @@ func (t *TasksQueueDumper) WatchQueue() {
 		select {
 		case <-t.eventCh:
 			t.DumpQueue()
+			time.Sleep(t.interval)
 		}
 	}
 }
 
 func (t *TasksQueueDumper) DumpQueue() {
-	f, err := os.Create(t.DumpFilePath)
-	if err != nil {
-		rlog.Errorf("TasksQueueDumper: Cannot open '%s': %s\n", t.DumpFilePath, err)
-	}
-	_, err = io.Copy(f, t.queue.DumpReader())
+	data, err := ioutil.ReadAll(t.queue.DumpReader())
 	if err != nil {
-		rlog.Errorf("TasksQueueDumper: Cannot dump tasks to '%s': %s\n", t.DumpFilePath, err)
+		rlog.Errorf("TasksQueueDumper: Cannot dump tasks: %s\n", err)
+		return
 	}
-	err = f.Close()
-	if err != nil {
-		rlog.Errorf("TasksQueueDumper: Cannot close '%s': %s\n", t.DumpFilePath, err)
+	if err := t.store.Save(data); err != nil {
+		rlog.Errorf("TasksQueueDumper: Cannot save tasks into %s: %s\n", t.store, err)
 	}
 }
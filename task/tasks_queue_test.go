@@ -205,3 +205,64 @@ func FillQueue4(q *TasksQueue) {
 	q.Add(task3)
 	q.Add(task4)
 }
+
+func TestTasksQueue_Cancel(t *testing.T) {
+	q := NewTasksQueue()
+	head := NewTask(ModuleRun, "module-1")
+	queued := NewTask(ModuleRun, "module-2")
+	q.Add(head)
+	q.Add(queued)
+	q.Add(NewTask(ModuleRun, "module-3"))
+
+	// queued task is removed
+	cancelled, running := q.Cancel(queued.GetId())
+	assert.Equal(t, Task(queued), cancelled)
+	assert.True(t, queued.IsCancelled())
+	assert.False(t, running)
+	assert.Equal(t, 2, q.Length())
+
+	// head task is in progress, it is only marked
+	cancelled, running = q.Cancel(head.GetId())
+	assert.Equal(t, Task(head), cancelled)
+	assert.True(t, head.IsCancelled())
+	assert.True(t, running)
+	assert.Equal(t, 2, q.Length())
+
+	// in-flight task is in progress too
+	inFlight := NewTask(ModuleRun, "module-4")
+	q.Add(inFlight)
+	q.StartInFlight(inFlight.GetId())
+	cancelled, running = q.Cancel(inFlight.GetId())
+	assert.Equal(t, Task(inFlight), cancelled)
+	assert.True(t, inFlight.IsCancelled())
+	assert.True(t, running)
+	assert.Equal(t, 3, q.Length())
+
+	q.StopInFlight(inFlight.GetId())
+	assert.False(t, q.IsInFlight(inFlight.GetId()))
+
+	cancelled, _ = q.Cancel("unknown")
+	assert.Nil(t, cancelled)
+}
+
+func TestTasksQueue_UpdateLast(t *testing.T) {
+	q := NewTasksQueue()
+	head := NewTask(GlobalHookRun, "hook-1")
+	first := NewTask(GlobalHookRun, "hook-1")
+	last := NewTask(GlobalHookRun, "hook-1")
+	q.Add(head)
+	q.Add(first)
+	q.Add(NewTask(GlobalHookRun, "hook-2"))
+	q.Add(last)
+
+	isHook1 := func(task Task) bool { return task.GetName() == "hook-1" }
+	updated := make([]Task, 0)
+	assert.True(t, q.UpdateLast(isHook1, func(task Task) { updated = append(updated, task) }))
+	assert.Equal(t, []Task{last}, updated)
+
+	// head task is in progress
+	q.Remove(first.GetId())
+	q.Remove(last.GetId())
+	assert.False(t, q.UpdateLast(isHook1, func(task Task) { updated = append(updated, task) }))
+	assert.Len(t, updated, 1)
+}
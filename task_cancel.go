@@ -0,0 +1,58 @@
+package main
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/romana/rlog"
+
+	"github.com/flant/antiopa/executor"
+	"github.com/flant/antiopa/task"
+)
+
+// CancelTask cancels the task with id. Queued task is removed from the queue.
+// Processes of the running task are killed and the task is not retried.
+func CancelTask(id string) (task.Task, error) {
+	var t task.Task
+	var running bool
+	for _, queue := range AllTasksQueues() {
+		t, running = queue.Cancel(id)
+		if t != nil {
+			break
+		}
+	}
+	if t == nil {
+		return nil, fmt.Errorf("task #%s is not found in queues", id)
+	}
+
+	if running {
+		// hooks and helm of the task have its correlation id in env
+		killed := executor.KillTask(t.GetCorrelationId())
+		rlog.Infof("QUEUE cancel running task #%s %s '%s': %d processes are killed", t.GetId(), t.GetType(), t.GetName(), killed)
+	} else {
+		rlog.Infof("QUEUE cancel task #%s %s '%s'", t.GetId(), t.GetType(), t.GetName())
+		recordCancelledTask(t)
+	}
+
+	MetricsStorage.SendCounterMetric("antiopa_tasks_cancelled", 1.0, map[string]string{"type": string(t.GetType())})
+
+	return t, nil
+}
+
+// popIfCancelled removes failed task from the queue instead of retry if it is cancelled during run
+func popIfCancelled(queue *task.TasksQueue, t task.Task) bool {
+	if !t.IsCancelled() {
+		return false
+	}
+	rlog.Infof("TASK_RUN %s '%s' is cancelled", t.GetType(), t.GetName())
+	queue.Pop()
+	return true
+}
+
+// recordCancelledTask saves module task that is cancelled before start into converge history
+func recordCancelledTask(t task.Task) {
+	switch t.GetType() {
+	case task.ModuleRun, task.ModuleDelete, task.ModulePurge:
+		RecordModuleTask(t, time.Now(), nil, nil, nil)
+	}
+}
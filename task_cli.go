@@ -0,0 +1,57 @@
+package main
+
+import (
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+)
+
+// RunTaskCommand handles `antiopa task list` and `antiopa task cancel <id>`.
+// Commands use the API of running antiopa, so they are run with kubectl exec.
+func RunTaskCommand(args []string) error {
+	if len(args) == 0 {
+		return fmt.Errorf("usage: antiopa task list | antiopa task cancel <id>")
+	}
+
+	client := &http.Client{Timeout: 60 * time.Second}
+
+	switch args[0] {
+	case "list":
+		resp, err := client.Get(ApiAddress + "/queue")
+		if err != nil {
+			return fmt.Errorf("cannot get tasks queue: %s", err)
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			body, _ := ioutil.ReadAll(resp.Body)
+			return fmt.Errorf("cannot get tasks queue: %s: %s", resp.Status, string(body))
+		}
+		_, err = io.Copy(os.Stdout, resp.Body)
+		return err
+
+	case "cancel":
+		if len(args) < 2 {
+			return fmt.Errorf("usage: antiopa task cancel <id>")
+		}
+		id := strings.TrimPrefix(args[1], "#")
+
+		resp, err := client.Post(ApiAddress+"/task/cancel?id="+url.QueryEscape(id), "text/plain", nil)
+		if err != nil {
+			return fmt.Errorf("cannot cancel task: %s", err)
+		}
+		defer resp.Body.Close()
+		body, _ := ioutil.ReadAll(resp.Body)
+		if resp.StatusCode != http.StatusOK {
+			return fmt.Errorf("cannot cancel task: %s: %s", resp.Status, string(body))
+		}
+		fmt.Print(string(body))
+		return nil
+	}
+
+	return fmt.Errorf("unknown task command '%s'", args[0])
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"github.com/romana/rlog"
+
+	"github.com/flant/antiopa/executor"
+	"github.com/flant/antiopa/utils"
+)
+
+// Sessions of antiopa processes are subdirectories of TempDirBase
+const TempDirBase = "/tmp/antiopa"
+
+// How often usage of the temporary dir is checked
+const TempDirQuotaInterval = time.Minute
+
+// Files modified recently can be used by running hooks and are not evicted
+const TempDirEvictionMinAge = 10 * time.Minute
+
+// Disk usage quota of the session temporary dir in bytes, 0 disables the quota
+var TempDirQuota int64 = 1024 * 1024 * 1024
+
+// InitTempDir creates a session temporary dir and removes sessions of stopped processes
+func InitTempDir() (string, error) {
+	tempDir, err := utils.NewTempDirSession(TempDirBase)
+	if err != nil {
+		return "", err
+	}
+
+	removed, err := utils.CleanupTempDirSessions(TempDirBase, tempDir)
+	if err != nil {
+		rlog.Errorf("MAIN cannot remove old sessions from temporary dir: %s", err)
+	}
+	for _, path := range removed {
+		rlog.Infof("MAIN removed old temporary dir session '%s'", path)
+	}
+
+	return tempDir, nil
+}
+
+// Shims of the command wrapper for hooks are in this subdirectory of the session temporary dir
+const CommandWrapperShimsDirName = "command-wrapper"
+
+// InitCommandWrapperShims writes shims of helm and kubectl for hooks if -command-wrapper is set
+func InitCommandWrapperShims(tempDir string) error {
+	if executor.CommandWrapper == "" {
+		return nil
+	}
+	dir := filepath.Join(tempDir, CommandWrapperShimsDirName)
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return fmt.Errorf("cannot create dir for shims: %s", err)
+	}
+	return executor.WriteWrapperShims(dir)
+}
+
+// RunTempDirQuota evicts the oldest files and directories from the session temporary dir
+// when its usage is above TempDirQuota.
+func RunTempDirQuota(tempDir string) {
+	for {
+		time.Sleep(TempDirQuotaInterval)
+
+		// hooks should not bypass the command wrapper
+		usage, removed, err := utils.EvictTempDirEntries(tempDir, TempDirQuota, time.Now().Add(-TempDirEvictionMinAge), executor.WrapperShimsDir)
+		if err != nil {
+			rlog.Errorf("MAIN cannot check usage of temporary dir '%s': %s", tempDir, err)
+			continue
+		}
+		for _, entry := range removed {
+			rlog.Warnf("MAIN temporary dir quota %d bytes exceeded: removed '%s' (%d bytes)", TempDirQuota, entry.Path, entry.Size)
+		}
+		if TempDirQuota > 0 && usage > TempDirQuota {
+			rlog.Warnf("MAIN temporary dir usage %d bytes exceeds quota %d bytes, remaining files are recently modified", usage, TempDirQuota)
+		}
+
+		MetricsStorage.SendGaugeMetric("antiopa_tmp_dir_usage_bytes", float64(usage), map[string]string{})
+		if len(removed) > 0 {
+			MetricsStorage.SendCounterMetric("antiopa_tmp_dir_evictions", float64(len(removed)), map[string]string{})
+		}
+	}
+}
@@ -0,0 +1,40 @@
+package utils
+
+import (
+	"bytes"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestArchiveDirectory(t *testing.T) {
+	tmpDir, err := ioutil.TempDir("", "archive")
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer os.RemoveAll(tmpDir)
+
+	assert.NoError(t, os.MkdirAll(filepath.Join(tmpDir, "templates"), 0755))
+	assert.NoError(t, ioutil.WriteFile(filepath.Join(tmpDir, "Chart.yaml"), []byte("name: alpha\n"), 0644))
+	assert.NoError(t, ioutil.WriteFile(filepath.Join(tmpDir, "templates", "cm.yaml"), []byte("kind: ConfigMap\n"), 0644))
+
+	first := &bytes.Buffer{}
+	assert.NoError(t, ArchiveDirectory(tmpDir, first))
+
+	// modification time is not in the archive
+	future := time.Now().Add(time.Hour)
+	assert.NoError(t, os.Chtimes(filepath.Join(tmpDir, "Chart.yaml"), future, future))
+	second := &bytes.Buffer{}
+	assert.NoError(t, ArchiveDirectory(tmpDir, second))
+	assert.Equal(t, first.Bytes(), second.Bytes())
+
+	// content is in the archive
+	assert.NoError(t, ioutil.WriteFile(filepath.Join(tmpDir, "templates", "cm.yaml"), []byte("kind: Secret\n"), 0644))
+	third := &bytes.Buffer{}
+	assert.NoError(t, ArchiveDirectory(tmpDir, third))
+	assert.NotEqual(t, first.Bytes(), third.Bytes())
+}
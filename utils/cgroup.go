@@ -0,0 +1,164 @@
+package utils
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// Mount point of cgroup filesystem in the container
+const CgroupRoot = "/sys/fs/cgroup"
+
+// cgroup v1 reports huge number as the limit of unlimited memory
+const cgroupUnlimitedMemory = uint64(1) << 60
+
+// CgroupStats is a resources usage of the container
+type CgroupStats struct {
+	MemoryUsage uint64
+	// 0 if memory is not limited
+	MemoryLimit uint64
+	// cumulative CPU time of all processes in cgroup
+	CpuUsage time.Duration
+	// number of cores, 0 if CPU is not limited
+	CpuLimit float64
+}
+
+// MemoryUsageRatio returns usage to limit ratio, 0 is returned if memory is not limited
+func (s *CgroupStats) MemoryUsageRatio() float64 {
+	if s.MemoryLimit == 0 {
+		return 0
+	}
+	return float64(s.MemoryUsage) / float64(s.MemoryLimit)
+}
+
+// CpuUsageRatio returns average CPU usage to limit ratio since the previous stats.
+// 0 is returned if CPU is not limited.
+func (s *CgroupStats) CpuUsageRatio(prev *CgroupStats, elapsed time.Duration) float64 {
+	if s.CpuLimit == 0 || prev == nil || elapsed <= 0 {
+		return 0
+	}
+	return float64(s.CpuUsage-prev.CpuUsage) / float64(elapsed) / s.CpuLimit
+}
+
+// ReadCgroupStats reads memory and CPU usage and limits from cgroup v2 or cgroup v1 files
+func ReadCgroupStats(root string) (*CgroupStats, error) {
+	if _, err := os.Stat(filepath.Join(root, "cgroup.controllers")); err == nil {
+		return readCgroupV2Stats(root)
+	}
+	return readCgroupV1Stats(root)
+}
+
+func readCgroupV2Stats(root string) (*CgroupStats, error) {
+	stats := &CgroupStats{}
+	var err error
+
+	stats.MemoryUsage, err = readCgroupUint(filepath.Join(root, "memory.current"))
+	if err != nil {
+		return nil, err
+	}
+
+	memoryMax, err := readCgroupString(filepath.Join(root, "memory.max"))
+	if err != nil {
+		return nil, err
+	}
+	if memoryMax != "max" {
+		stats.MemoryLimit, err = strconv.ParseUint(memoryMax, 10, 64)
+		if err != nil {
+			return nil, fmt.Errorf("bad memory.max '%s': %s", memoryMax, err)
+		}
+	}
+
+	cpuStat, err := readCgroupString(filepath.Join(root, "cpu.stat"))
+	if err != nil {
+		return nil, err
+	}
+	for _, line := range strings.Split(cpuStat, "\n") {
+		fields := strings.Fields(line)
+		if len(fields) == 2 && fields[0] == "usage_usec" {
+			usage, err := strconv.ParseUint(fields[1], 10, 64)
+			if err != nil {
+				return nil, fmt.Errorf("bad cpu.stat usage_usec '%s': %s", fields[1], err)
+			}
+			stats.CpuUsage = time.Duration(usage) * time.Microsecond
+		}
+	}
+
+	// "max 100000" or "50000 100000"
+	cpuMax, err := readCgroupString(filepath.Join(root, "cpu.max"))
+	if err == nil {
+		fields := strings.Fields(cpuMax)
+		if len(fields) == 2 && fields[0] != "max" {
+			stats.CpuLimit = cpuQuotaCores(fields[0], fields[1])
+		}
+	}
+
+	return stats, nil
+}
+
+func readCgroupV1Stats(root string) (*CgroupStats, error) {
+	stats := &CgroupStats{}
+	var err error
+
+	stats.MemoryUsage, err = readCgroupUint(filepath.Join(root, "memory", "memory.usage_in_bytes"))
+	if err != nil {
+		return nil, err
+	}
+	stats.MemoryLimit, err = readCgroupUint(filepath.Join(root, "memory", "memory.limit_in_bytes"))
+	if err != nil {
+		return nil, err
+	}
+	if stats.MemoryLimit >= cgroupUnlimitedMemory {
+		stats.MemoryLimit = 0
+	}
+
+	cpuUsage, err := readCgroupUint(filepath.Join(root, "cpuacct", "cpuacct.usage"))
+	if err != nil {
+		return nil, err
+	}
+	stats.CpuUsage = time.Duration(cpuUsage)
+
+	// quota is -1 if CPU is not limited
+	quota, quotaErr := readCgroupString(filepath.Join(root, "cpu", "cpu.cfs_quota_us"))
+	period, periodErr := readCgroupString(filepath.Join(root, "cpu", "cpu.cfs_period_us"))
+	if quotaErr == nil && periodErr == nil && quota != "-1" {
+		stats.CpuLimit = cpuQuotaCores(quota, period)
+	}
+
+	return stats, nil
+}
+
+func cpuQuotaCores(quota string, period string) float64 {
+	quotaValue, err := strconv.ParseFloat(quota, 64)
+	if err != nil {
+		return 0
+	}
+	periodValue, err := strconv.ParseFloat(period, 64)
+	if err != nil || periodValue == 0 {
+		return 0
+	}
+	return quotaValue / periodValue
+}
+
+func readCgroupString(path string) (string, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(string(data)), nil
+}
+
+func readCgroupUint(path string) (uint64, error) {
+	value, err := readCgroupString(path)
+	if err != nil {
+		return 0, err
+	}
+	res, err := strconv.ParseUint(value, 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("bad value '%s' in %s: %s", value, path, err)
+	}
+	return res, nil
+}
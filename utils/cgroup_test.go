@@ -0,0 +1,75 @@
+package utils
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func writeCgroupFiles(t *testing.T, root string, files map[string]string) {
+	for name, content := range files {
+		path := filepath.Join(root, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := ioutil.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestReadCgroupStats_V2(t *testing.T) {
+	root, err := ioutil.TempDir("", "cgroup-v2")
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer os.RemoveAll(root)
+
+	writeCgroupFiles(t, root, map[string]string{
+		"cgroup.controllers": "cpu memory\n",
+		"memory.current":     "268435456\n",
+		"memory.max":         "536870912\n",
+		"cpu.stat":           "usage_usec 2000000\nuser_usec 1500000\n",
+		"cpu.max":            "50000 100000\n",
+	})
+
+	stats, err := ReadCgroupStats(root)
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.Equal(t, 0.5, stats.MemoryUsageRatio())
+	assert.Equal(t, 2*time.Second, stats.CpuUsage)
+	assert.Equal(t, 0.5, stats.CpuLimit)
+
+	prev := &CgroupStats{CpuUsage: time.Second}
+	assert.Equal(t, 1.0, stats.CpuUsageRatio(prev, 2*time.Second))
+}
+
+func TestReadCgroupStats_V1Unlimited(t *testing.T) {
+	root, err := ioutil.TempDir("", "cgroup-v1")
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer os.RemoveAll(root)
+
+	writeCgroupFiles(t, root, map[string]string{
+		"memory/memory.usage_in_bytes": "1048576\n",
+		"memory/memory.limit_in_bytes": "9223372036854771712\n",
+		"cpuacct/cpuacct.usage":        "1000000000\n",
+		"cpu/cpu.cfs_quota_us":         "-1\n",
+		"cpu/cpu.cfs_period_us":        "100000\n",
+	})
+
+	stats, err := ReadCgroupStats(root)
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.Equal(t, uint64(0), stats.MemoryLimit)
+	assert.Equal(t, 0.0, stats.MemoryUsageRatio())
+	assert.Equal(t, 0.0, stats.CpuLimit)
+	assert.Equal(t, time.Second, stats.CpuUsage)
+}
@@ -0,0 +1,81 @@
+package utils
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"strings"
+	"syscall"
+	"time"
+)
+
+// FileLock is an advisory exclusive lock of a file held by the process. The lock is
+// released by the kernel when the process exits, so a crashed process does not leave it.
+type FileLock struct {
+	Path string
+	file *os.File
+}
+
+// ErrFileLocked is returned if the lock is held by another process
+type ErrFileLocked struct {
+	Path string
+	// pid and hostname of the holder written into the lock file
+	Holder string
+}
+
+func (e *ErrFileLocked) Error() string {
+	if e.Holder == "" {
+		return fmt.Sprintf("'%s' is locked by another process", e.Path)
+	}
+	return fmt.Sprintf("'%s' is locked by another process: %s", e.Path, e.Holder)
+}
+
+// IsFileLocked returns true if err is ErrFileLocked
+func IsFileLocked(err error) bool {
+	_, ok := err.(*ErrFileLocked)
+	return ok
+}
+
+// TryLockFile locks the file without waiting. The file is created if needed and
+// pid and hostname of the process are written into it for ErrFileLocked of others.
+func TryLockFile(path string) (*FileLock, error) {
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0644)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := syscall.Flock(int(f.Fd()), syscall.LOCK_EX|syscall.LOCK_NB); err != nil {
+		f.Close()
+		if err == syscall.EWOULDBLOCK {
+			holder, _ := ioutil.ReadFile(path)
+			return nil, &ErrFileLocked{Path: path, Holder: strings.TrimSpace(string(holder))}
+		}
+		return nil, fmt.Errorf("cannot lock '%s': %s", path, err)
+	}
+
+	hostname, _ := os.Hostname()
+	if err := f.Truncate(0); err == nil {
+		f.WriteAt([]byte(fmt.Sprintf("pid %d on %s\n", os.Getpid(), hostname)), 0)
+	}
+
+	return &FileLock{Path: path, file: f}, nil
+}
+
+// LockFile waits for the lock while it is held by another process, ErrFileLocked
+// is returned after timeout
+func LockFile(path string, timeout time.Duration, interval time.Duration) (*FileLock, error) {
+	deadline := time.Now().Add(timeout)
+	for {
+		lock, err := TryLockFile(path)
+		if !IsFileLocked(err) || time.Now().Add(interval).After(deadline) {
+			return lock, err
+		}
+		time.Sleep(interval)
+	}
+}
+
+// Unlock releases the lock, the lock file is kept to not race with other processes
+func (l *FileLock) Unlock() error {
+	defer l.file.Close()
+	return syscall.Flock(int(l.file.Fd()), syscall.LOCK_UN)
+}
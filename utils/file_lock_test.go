@@ -0,0 +1,37 @@
+package utils
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFileLock(t *testing.T) {
+	dir, err := ioutil.TempDir("", "antiopa-lock")
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, ".lock")
+
+	lock, err := TryLockFile(path)
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	// flock locks belong to open files, so the second open of the same process is refused
+	_, err = LockFile(path, 30*time.Millisecond, 10*time.Millisecond)
+	assert.True(t, IsFileLocked(err))
+	assert.Contains(t, err.Error(), "pid ")
+
+	assert.NoError(t, lock.Unlock())
+
+	lock, err = TryLockFile(path)
+	if assert.NoError(t, err) {
+		lock.Unlock()
+	}
+}
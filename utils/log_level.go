@@ -0,0 +1,75 @@
+package utils
+
+import (
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+
+	"github.com/romana/rlog"
+)
+
+const (
+	LogLevelEnv   = "RLOG_LOG_LEVEL"
+	DebugLogLevel = "DEBUG"
+	InfoLogLevel  = "INFO"
+)
+
+var logLevelMutex sync.Mutex
+
+// уровень логирования, с которым запущена antiopa
+var initialLogLevel = os.Getenv(LogLevelEnv)
+
+// ToggleDebugLogLevel switches log level to DEBUG or back to the initial level.
+// Returns a new log level.
+func ToggleDebugLogLevel() string {
+	logLevelMutex.Lock()
+	defer logLevelMutex.Unlock()
+
+	newLevel := DebugLogLevel
+	if os.Getenv(LogLevelEnv) == DebugLogLevel {
+		newLevel = initialLogLevel
+		if newLevel == "" || newLevel == DebugLogLevel {
+			newLevel = InfoLogLevel
+		}
+	}
+
+	os.Setenv(LogLevelEnv, newLevel)
+	rlog.UpdateEnv()
+
+	return newLevel
+}
+
+// ReloadLogConfig restores the initial log level and re-reads rlog settings
+// from the environment and the config file defined by RLOG_CONF_FILE.
+func ReloadLogConfig() {
+	logLevelMutex.Lock()
+	defer logLevelMutex.Unlock()
+
+	if initialLogLevel == "" {
+		os.Unsetenv(LogLevelEnv)
+	} else {
+		os.Setenv(LogLevelEnv, initialLogLevel)
+	}
+	rlog.UpdateEnv()
+}
+
+// RunLogSignalsHandler changes logging settings on signals:
+// SIGUSR1 toggles DEBUG log level, SIGHUP reloads logging config.
+func RunLogSignalsHandler() {
+	signalsCh := make(chan os.Signal, 1)
+	signal.Notify(signalsCh, syscall.SIGUSR1, syscall.SIGHUP)
+	for {
+		select {
+		case sig := <-signalsCh:
+			switch sig {
+			case syscall.SIGUSR1:
+				newLevel := ToggleDebugLogLevel()
+				rlog.Infof("Log level is changed to %s with %s signal", newLevel, sig.String())
+			case syscall.SIGHUP:
+				ReloadLogConfig()
+				rlog.Infof("Log config is reloaded with %s signal", sig.String())
+			}
+		}
+	}
+}
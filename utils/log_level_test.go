@@ -0,0 +1,23 @@
+package utils
+
+import (
+	"os"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestToggleDebugLogLevel(t *testing.T) {
+	initialLogLevel = "WARN"
+	os.Setenv(LogLevelEnv, initialLogLevel)
+
+	assert.Equal(t, DebugLogLevel, ToggleDebugLogLevel())
+	assert.Equal(t, DebugLogLevel, os.Getenv(LogLevelEnv))
+
+	assert.Equal(t, "WARN", ToggleDebugLogLevel())
+	assert.Equal(t, "WARN", os.Getenv(LogLevelEnv))
+
+	ToggleDebugLogLevel()
+	ReloadLogConfig()
+	assert.Equal(t, "WARN", os.Getenv(LogLevelEnv))
+}
@@ -0,0 +1,110 @@
+package utils
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/romana/rlog"
+)
+
+// LogSamplingWindow suppresses identical errors and warnings with the same key, e.g. of a failing
+// hook or a stuck watch: the line is written once per window and the number of suppressed lines
+// is written after the window. Sampling is disabled if it is 0.
+var LogSamplingWindow time.Duration
+
+type sampledLine struct {
+	level      string
+	message    string
+	writtenAt  time.Time
+	suppressed int
+}
+
+type logSampler struct {
+	m     sync.Mutex
+	lines map[string]*sampledLine
+	// writes lines, rlog by default
+	write func(level string, message string)
+}
+
+var defaultLogSampler = newLogSampler(writeRlog)
+
+func newLogSampler(write func(level string, message string)) *logSampler {
+	return &logSampler{lines: make(map[string]*sampledLine), write: write}
+}
+
+func writeRlog(level string, message string) {
+	if level == "WARN" {
+		rlog.Warn(message)
+		return
+	}
+	rlog.Error(message)
+}
+
+// SampledErrorf writes the error unless the same error with the key is written during LogSamplingWindow
+func SampledErrorf(key string, format string, args ...interface{}) {
+	defaultLogSampler.log(time.Now(), LogSamplingWindow, "ERROR", key, fmt.Sprintf(format, args...))
+}
+
+// SampledWarnf writes the warning unless the same warning with the key is written during LogSamplingWindow
+func SampledWarnf(key string, format string, args ...interface{}) {
+	defaultLogSampler.log(time.Now(), LogSamplingWindow, "WARN", key, fmt.Sprintf(format, args...))
+}
+
+func (s *logSampler) log(now time.Time, window time.Duration, level string, key string, message string) {
+	if window <= 0 {
+		s.write(level, message)
+		return
+	}
+
+	id := fmt.Sprintf("%s\x00%s\x00%s", level, key, message)
+	s.m.Lock()
+	line, hasLine := s.lines[id]
+	if hasLine && now.Sub(line.writtenAt) < window {
+		line.suppressed++
+		s.m.Unlock()
+		return
+	}
+	s.lines[id] = &sampledLine{level: level, message: message, writtenAt: now}
+	s.m.Unlock()
+
+	if hasLine && line.suppressed > 0 {
+		s.write(level, repeatedSummary(line, now))
+	}
+	s.write(level, message)
+}
+
+// flush writes summaries of lines that are suppressed during the finished window
+// and forgets these lines, so the next identical line is written at once
+func (s *logSampler) flush(now time.Time, window time.Duration) {
+	s.m.Lock()
+	summaries := make([]*sampledLine, 0)
+	for id, line := range s.lines {
+		if now.Sub(line.writtenAt) < window {
+			continue
+		}
+		delete(s.lines, id)
+		if line.suppressed > 0 {
+			summaries = append(summaries, line)
+		}
+	}
+	s.m.Unlock()
+
+	for _, line := range summaries {
+		s.write(line.level, repeatedSummary(line, now))
+	}
+}
+
+func repeatedSummary(line *sampledLine, now time.Time) string {
+	return fmt.Sprintf("%s (repeated %d times in %s)", line.message, line.suppressed, now.Sub(line.writtenAt).Round(time.Second))
+}
+
+// RunLogSamplingSummaries periodically writes summaries of suppressed lines
+func RunLogSamplingSummaries() {
+	if LogSamplingWindow <= 0 {
+		return
+	}
+	for now := range time.Tick(LogSamplingWindow) {
+		defaultLogSampler.flush(now, LogSamplingWindow)
+	}
+}
@@ -0,0 +1,36 @@
+package utils
+
+import (
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLogSampler(t *testing.T) {
+	written := make([]string, 0)
+	sampler := newLogSampler(func(level string, message string) {
+		written = append(written, level+" "+message)
+	})
+
+	start := time.Now()
+	window := time.Minute
+	for i := 0; i < 5; i++ {
+		sampler.log(start.Add(time.Duration(i)*time.Second), window, "ERROR", "module dex", "hook failed")
+	}
+	// other module is not suppressed
+	sampler.log(start, window, "ERROR", "module ingress", "hook failed")
+	assert.Equal(t, []string{"ERROR hook failed", "ERROR hook failed"}, written)
+
+	sampler.flush(start.Add(time.Minute), window)
+	assert.Equal(t, "ERROR hook failed (repeated 4 times in 1m0s)", written[2])
+	assert.Len(t, written, 3)
+
+	// line is written at once after the summary
+	sampler.log(start.Add(61*time.Second), window, "ERROR", "module dex", "hook failed")
+	assert.Len(t, written, 4)
+
+	// sampling is disabled
+	sampler.log(start.Add(62*time.Second), 0, "ERROR", "module dex", "hook failed")
+	assert.Len(t, written, 5)
+}
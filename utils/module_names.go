@@ -0,0 +1,100 @@
+package utils
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+// Module directory is a module name with a numeric prefix for order: 100-cert-manager
+var ModuleDirectoryRegexp = regexp.MustCompile(`^([0-9][0-9][0-9])-(.*)$`)
+
+// Module name is kebab-case: lowercase letters and digits separated with dashes
+var ModuleNameRegexp = regexp.MustCompile(`^[a-z][a-z0-9]*(-[a-z0-9]+)*$`)
+
+// ModuleNames are names of one module in different places:
+// 100-cert-manager directory, cert-manager module and release, certManager values key
+type ModuleNames struct {
+	Directory   string `json:"directory"`
+	Name        string `json:"name"`
+	ValuesKey   string `json:"valuesKey"`
+	ReleaseName string `json:"releaseName"`
+}
+
+// NewModuleNames returns names of the module in the directory
+func NewModuleNames(directory string) (*ModuleNames, error) {
+	matchRes := ModuleDirectoryRegexp.FindStringSubmatch(directory)
+	if matchRes == nil {
+		return nil, fmt.Errorf("bad module directory name '%s', must match regex '%s'", directory, ModuleDirectoryRegexp)
+	}
+
+	moduleName := matchRes[2]
+	if err := ValidateModuleName(moduleName); err != nil {
+		return nil, err
+	}
+
+	return &ModuleNames{
+		Directory:   directory,
+		Name:        moduleName,
+		ValuesKey:   ModuleNameToValuesKey(moduleName),
+		ReleaseName: ModuleReleaseName(moduleName),
+	}, nil
+}
+
+// ValidateModuleName checks that module name is kebab-case and
+// can be restored from the values key.
+func ValidateModuleName(moduleName string) error {
+	if !ModuleNameRegexp.MatchString(moduleName) {
+		return fmt.Errorf("bad module name '%s', must match regex '%s'", moduleName, ModuleNameRegexp)
+	}
+
+	valuesKey := ModuleNameToValuesKey(moduleName)
+	if valuesKey == "global" {
+		return fmt.Errorf("bad module name '%s': values key '%s' is reserved", moduleName, valuesKey)
+	}
+	if restored := ModuleNameFromValuesKey(valuesKey); restored != moduleName {
+		return fmt.Errorf("bad module name '%s': values key '%s' is converted back to '%s'", moduleName, valuesKey, restored)
+	}
+
+	return nil
+}
+
+// ModuleReleaseName returns the name of helm release of the module
+func ModuleReleaseName(moduleName string) string {
+	return moduleName
+}
+
+// DetectModuleNamesCollisions returns an error if different directories
+// have the same module name, values key or release name.
+func DetectModuleNamesCollisions(modules []*ModuleNames) error {
+	directoriesByName := make(map[string][]string)
+	for _, names := range modules {
+		for _, name := range []string{"module " + names.Name, "values key " + names.ValuesKey, "release " + names.ReleaseName} {
+			directoriesByName[name] = appendUnique(directoriesByName[name], names.Directory)
+		}
+	}
+
+	collisions := make([]string, 0)
+	for name, directories := range directoriesByName {
+		if len(directories) > 1 {
+			sort.Strings(directories)
+			collisions = append(collisions, fmt.Sprintf("%s in %s", name, strings.Join(directories, ", ")))
+		}
+	}
+	if len(collisions) == 0 {
+		return nil
+	}
+
+	sort.Strings(collisions)
+	return fmt.Errorf("modules names collisions: %s", strings.Join(collisions, "; "))
+}
+
+func appendUnique(list []string, item string) []string {
+	for _, listItem := range list {
+		if listItem == item {
+			return list
+		}
+	}
+	return append(list, item)
+}
@@ -0,0 +1,36 @@
+package utils
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNewModuleNames(t *testing.T) {
+	names, err := NewModuleNames("100-cert-manager")
+	if assert.NoError(t, err) {
+		assert.Equal(t, &ModuleNames{
+			Directory:   "100-cert-manager",
+			Name:        "cert-manager",
+			ValuesKey:   "certManager",
+			ReleaseName: "cert-manager",
+		}, names)
+	}
+
+	for _, directory := range []string{"cert-manager", "100-Cert-Manager", "100-cert_manager", "100-cert-manager2", "100-global"} {
+		_, err := NewModuleNames(directory)
+		assert.Error(t, err, directory)
+	}
+}
+
+func TestDetectModuleNamesCollisions(t *testing.T) {
+	a, _ := NewModuleNames("100-cert-manager")
+	b, _ := NewModuleNames("200-prometheus")
+	assert.NoError(t, DetectModuleNamesCollisions([]*ModuleNames{a, b}))
+
+	c, _ := NewModuleNames("300-cert-manager")
+	err := DetectModuleNamesCollisions([]*ModuleNames{a, b, c})
+	if assert.Error(t, err) {
+		assert.Contains(t, err.Error(), "values key certManager in 100-cert-manager, 300-cert-manager")
+	}
+}
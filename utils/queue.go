@@ -67,6 +67,88 @@ func (q *Queue) Push(task interface{}) {
 	q.queueChanged()
 }
 
+// Remove first element that matches predicate except the head element. Head element
+// is returned with isHead=true and is not removed: it is in progress.
+func (q *Queue) RemoveFirst(predicate func(item interface{}) bool) (item interface{}, isHead bool) {
+	q.m.Lock()
+	for i := 0; i < len(q.items); i++ {
+		if !predicate(q.items[i]) {
+			continue
+		}
+		item = q.items[i]
+		if i == 0 {
+			q.m.Unlock()
+			return item, true
+		}
+		q.items = append(q.items[:i:i], q.items[i+1:]...)
+		q.m.Unlock()
+		q.queueChanged()
+		return item, false
+	}
+	q.m.Unlock()
+	return nil, false
+}
+
+// Remove deletes the first element that matches predicate, the head element too
+func (q *Queue) Remove(predicate func(item interface{}) bool) (item interface{}) {
+	q.m.Lock()
+	for i := 0; i < len(q.items); i++ {
+		if !predicate(q.items[i]) {
+			continue
+		}
+		item = q.items[i]
+		q.items = append(q.items[:i:i], q.items[i+1:]...)
+		q.m.Unlock()
+		q.queueChanged()
+		return item
+	}
+	q.m.Unlock()
+	return nil
+}
+
+// HeadWhile returns consecutive elements from the head of the queue that match predicate
+func (q *Queue) HeadWhile(predicate func(item interface{}) bool) []interface{} {
+	q.m.Lock()
+	defer q.m.Unlock()
+	res := make([]interface{}, 0)
+	for _, item := range q.items {
+		if !predicate(item) {
+			break
+		}
+		res = append(res, item)
+	}
+	return res
+}
+
+// Find returns the first element that matches predicate, isHead is true for the head element
+func (q *Queue) Find(predicate func(item interface{}) bool) (item interface{}, isHead bool) {
+	q.m.Lock()
+	defer q.m.Unlock()
+	for i, item := range q.items {
+		if predicate(item) {
+			return item, i == 0
+		}
+	}
+	return nil, false
+}
+
+// UpdateLast calls update for the last element that matches predicate except the head element,
+// which is in progress. Element is updated under the lock of the queue. Returns true if updated.
+func (q *Queue) UpdateLast(predicate func(item interface{}) bool, update func(item interface{})) bool {
+	q.m.Lock()
+	for i := len(q.items) - 1; i > 0; i-- {
+		if !predicate(q.items[i]) {
+			continue
+		}
+		update(q.items[i])
+		q.m.Unlock()
+		q.queueChanged()
+		return true
+	}
+	q.m.Unlock()
+	return false
+}
+
 func (q *Queue) IsEmpty() bool {
 	q.m.Lock()
 	defer q.m.Unlock()
@@ -0,0 +1,158 @@
+package utils
+
+import (
+	"fmt"
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"syscall"
+	"time"
+)
+
+const TempDirSessionPrefix = "session-"
+
+// NewTempDirSession creates a session directory for the process in baseDir: session-<pid>-<random>
+func NewTempDirSession(baseDir string) (string, error) {
+	if err := os.MkdirAll(baseDir, os.FileMode(0777)); err != nil {
+		return "", err
+	}
+	return ioutil.TempDir(baseDir, fmt.Sprintf("%s%d-", TempDirSessionPrefix, os.Getpid()))
+}
+
+// CleanupTempDirSessions removes session directories of processes that are not running.
+// Sessions with pid of the current process are left from previous container runs.
+func CleanupTempDirSessions(baseDir string, currentSession string) ([]string, error) {
+	files, err := ioutil.ReadDir(baseDir)
+	if err != nil {
+		return nil, err
+	}
+
+	removed := make([]string, 0)
+	for _, file := range files {
+		path := filepath.Join(baseDir, file.Name())
+		if !file.IsDir() || !strings.HasPrefix(file.Name(), TempDirSessionPrefix) || path == currentSession {
+			continue
+		}
+
+		pid := tempDirSessionPid(file.Name())
+		if pid > 0 && pid != os.Getpid() && isProcessRunning(pid) {
+			continue
+		}
+
+		if err := os.RemoveAll(path); err != nil {
+			return removed, err
+		}
+		removed = append(removed, path)
+	}
+
+	return removed, nil
+}
+
+// tempDirSessionPid returns pid from the session directory name or 0
+func tempDirSessionPid(name string) int {
+	parts := strings.SplitN(strings.TrimPrefix(name, TempDirSessionPrefix), "-", 2)
+	pid, err := strconv.Atoi(parts[0])
+	if err != nil {
+		return 0
+	}
+	return pid
+}
+
+func isProcessRunning(pid int) bool {
+	err := syscall.Kill(pid, 0)
+	return err == nil || err == syscall.EPERM
+}
+
+// TempDirEntry is a file or a directory in the session directory
+type TempDirEntry struct {
+	Path string
+	Size int64
+	// the latest modification time of files in the entry
+	ModTime time.Time
+}
+
+// TempDirEntries returns top level entries of dir with their sizes
+func TempDirEntries(dir string) ([]TempDirEntry, error) {
+	files, err := ioutil.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	entries := make([]TempDirEntry, 0, len(files))
+	for _, file := range files {
+		entry := TempDirEntry{Path: filepath.Join(dir, file.Name())}
+		err := filepath.Walk(entry.Path, func(_ string, info os.FileInfo, err error) error {
+			if err != nil {
+				// file can be removed by hook during walk
+				return nil
+			}
+			entry.Size += info.Size()
+			if info.ModTime().After(entry.ModTime) {
+				entry.ModTime = info.ModTime()
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+		entries = append(entries, entry)
+	}
+
+	return entries, nil
+}
+
+// EvictTempDirEntries removes the oldest top level entries of dir until its usage
+// is below quota. Entries modified after minModTime can be in use and are not removed.
+// Paths in keep are never removed but are counted in usage.
+// Returns usage after eviction and removed entries.
+func EvictTempDirEntries(dir string, quota int64, minModTime time.Time, keep ...string) (int64, []TempDirEntry, error) {
+	entries, err := TempDirEntries(dir)
+	if err != nil {
+		return 0, nil, err
+	}
+
+	var usage int64
+	for _, entry := range entries {
+		usage += entry.Size
+	}
+
+	removed := make([]TempDirEntry, 0)
+	if quota <= 0 || usage <= quota {
+		return usage, removed, nil
+	}
+
+	sort.SliceStable(entries, func(i, j int) bool {
+		return entries[i].ModTime.Before(entries[j].ModTime)
+	})
+
+	for _, entry := range entries {
+		if usage <= quota {
+			break
+		}
+		if entry.ModTime.After(minModTime) {
+			break
+		}
+		if isKeptEntry(entry.Path, keep) {
+			continue
+		}
+		if err := os.RemoveAll(entry.Path); err != nil {
+			return usage, removed, err
+		}
+		usage -= entry.Size
+		removed = append(removed, entry)
+	}
+
+	return usage, removed, nil
+}
+
+func isKeptEntry(path string, keep []string) bool {
+	for _, keepPath := range keep {
+		if keepPath != "" && filepath.Clean(keepPath) == path {
+			return true
+		}
+	}
+	return false
+}
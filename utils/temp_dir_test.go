@@ -0,0 +1,90 @@
+package utils
+
+import (
+	"io/ioutil"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCleanupTempDirSessions(t *testing.T) {
+	baseDir, err := ioutil.TempDir("", "antiopa-sessions")
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer os.RemoveAll(baseDir)
+
+	current, err := NewTempDirSession(baseDir)
+	if !assert.NoError(t, err) {
+		return
+	}
+	// session of the same pid from the previous container run
+	previous, err := NewTempDirSession(baseDir)
+	if !assert.NoError(t, err) {
+		return
+	}
+	// pid of init is always running
+	running := filepath.Join(baseDir, TempDirSessionPrefix+"1-abc")
+	assert.NoError(t, os.Mkdir(running, 0755))
+	other := filepath.Join(baseDir, "other")
+	assert.NoError(t, os.Mkdir(other, 0755))
+
+	removed, err := CleanupTempDirSessions(baseDir, current)
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.Equal(t, []string{previous}, removed)
+	assert.DirExists(t, current)
+	assert.DirExists(t, other)
+	if os.Getpid() != 1 {
+		assert.DirExists(t, running)
+	}
+}
+
+func TestEvictTempDirEntries(t *testing.T) {
+	dir, err := ioutil.TempDir("", "antiopa-quota")
+	if !assert.NoError(t, err) {
+		return
+	}
+	defer os.RemoveAll(dir)
+
+	now := time.Now()
+	for i, name := range []string{"old.json", "middle.json", "new.json"} {
+		path := filepath.Join(dir, name)
+		assert.NoError(t, ioutil.WriteFile(path, make([]byte, 100), 0644))
+		modTime := now.Add(time.Duration(i-3) * time.Hour)
+		assert.NoError(t, os.Chtimes(path, modTime, modTime))
+	}
+
+	// new.json is modified after minModTime
+	usage, removed, err := EvictTempDirEntries(dir, 50, now.Add(-90*time.Minute))
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.Len(t, removed, 2)
+	assert.Equal(t, int64(100), usage)
+	assert.Equal(t, filepath.Join(dir, "old.json"), removed[0].Path)
+
+	usage, removed, err = EvictTempDirEntries(dir, 0, now)
+	assert.NoError(t, err)
+	assert.Equal(t, int64(100), usage)
+	assert.Len(t, removed, 0)
+
+	// kept entry is skipped even if it is the oldest one
+	shims := filepath.Join(dir, "command-wrapper")
+	assert.NoError(t, os.Mkdir(shims, 0755))
+	assert.NoError(t, ioutil.WriteFile(filepath.Join(shims, "kubectl"), make([]byte, 100), 0755))
+	oldTime := now.Add(-5 * time.Hour)
+	assert.NoError(t, os.Chtimes(filepath.Join(shims, "kubectl"), oldTime, oldTime))
+	assert.NoError(t, os.Chtimes(shims, oldTime, oldTime))
+
+	_, removed, err = EvictTempDirEntries(dir, 50, now, shims)
+	assert.NoError(t, err)
+	if assert.Len(t, removed, 1) {
+		assert.Equal(t, filepath.Join(dir, "new.json"), removed[0].Path)
+	}
+	assert.DirExists(t, shims)
+}
@@ -5,10 +5,11 @@ import (
 	"fmt"
 	"io/ioutil"
 	"reflect"
+	"sort"
+	"strconv"
 	"strings"
 
 	"github.com/evanphx/json-patch"
-	ghodssyaml "github.com/ghodss/yaml"
 	"github.com/go-yaml/yaml"
 	"github.com/peterbourgon/mergemap"
 	"github.com/segmentio/go-camelcase"
@@ -43,6 +44,8 @@ type ValuesPatchOperation struct {
 	Op    string      `json:"op"`
 	Path  string      `json:"path"`
 	Value interface{} `json:"value"`
+	// source path of move and copy operations
+	From string `json:"from,omitempty"`
 }
 
 func (op *ValuesPatchOperation) ToString() string {
@@ -109,22 +112,7 @@ func NewValues(data map[interface{}]interface{}) (Values, error) {
 }
 
 func FormatValues(someValues map[interface{}]interface{}) (Values, error) {
-	yamlDoc, err := yaml.Marshal(someValues)
-	if err != nil {
-		return nil, err
-	}
-
-	jsonDoc, err := ghodssyaml.YAMLToJSON(yamlDoc)
-	if err != nil {
-		return nil, err
-	}
-
-	values := make(Values)
-	if err := json.Unmarshal(jsonDoc, &values); err != nil {
-		return nil, err
-	}
-
-	return values, nil
+	return NormalizeValues(someValues)
 }
 
 func MustValuesPatch(res *ValuesPatch, err error) *ValuesPatch {
@@ -208,7 +196,12 @@ func ApplyValuesPatch(values Values, valuesPatch ValuesPatch) (Values, bool, err
 		return nil, false, err
 	}
 
-	valuesChanged := !reflect.DeepEqual(values, resValues)
+	// values from yaml files have ints and values after json patch have float64
+	normalizedValues, err := NormalizeValue(values)
+	if err != nil {
+		return nil, false, err
+	}
+	valuesChanged := !reflect.DeepEqual(normalizedValues, map[string]interface{}(resValues))
 
 	return resValues, valuesChanged, nil
 }
@@ -260,3 +253,93 @@ func DumpValuesYaml(values Values) ([]byte, error) {
 func DumpValuesJson(values Values) ([]byte, error) {
 	return json.Marshal(values)
 }
+
+// ValuesChangesSummary returns sorted list of changed keys on the second level of values:
+// "+global.a" for added key, "-module.b" for removed key and "~module.c" for changed value.
+func ValuesChangesSummary(oldValues Values, newValues Values) []string {
+	res := make([]string, 0)
+
+	for _, section := range unionKeys(oldValues, newValues) {
+		oldSection, oldIsMap := oldValues[section].(map[string]interface{})
+		newSection, newIsMap := newValues[section].(map[string]interface{})
+		if !oldIsMap || !newIsMap {
+			if change := valueChange(oldValues, newValues, section); change != "" {
+				res = append(res, change+section)
+			}
+			continue
+		}
+
+		for _, key := range unionKeys(oldSection, newSection) {
+			if change := valueChange(oldSection, newSection, key); change != "" {
+				res = append(res, change+section+"."+key)
+			}
+		}
+	}
+
+	sort.Strings(res)
+	return res
+}
+
+func unionKeys(a map[string]interface{}, b map[string]interface{}) []string {
+	keys := make(map[string]bool)
+	for key := range a {
+		keys[key] = true
+	}
+	for key := range b {
+		keys[key] = true
+	}
+
+	res := make([]string, 0, len(keys))
+	for key := range keys {
+		res = append(res, key)
+	}
+	return res
+}
+
+// valueChange returns "+", "-" or "~" if value is added, removed or changed
+func valueChange(oldValues map[string]interface{}, newValues map[string]interface{}, key string) string {
+	oldValue, hasOld := oldValues[key]
+	newValue, hasNew := newValues[key]
+	switch {
+	case !hasOld && hasNew:
+		return "+"
+	case hasOld && !hasNew:
+		return "-"
+	case !reflect.DeepEqual(oldValue, newValue):
+		return "~"
+	}
+	return ""
+}
+
+// ValueAtPath returns the value at the dot separated path, numbers are indexes of lists.
+// Empty path returns the value itself.
+func ValueAtPath(value interface{}, path string) (interface{}, bool) {
+	if path == "" {
+		return value, true
+	}
+	for _, key := range strings.Split(path, ".") {
+		switch v := value.(type) {
+		case Values:
+			item, hasKey := v[key]
+			if !hasKey {
+				return nil, false
+			}
+			value = item
+		case map[string]interface{}:
+			item, hasKey := v[key]
+			if !hasKey {
+				return nil, false
+			}
+			value = item
+		case []interface{}:
+			i, err := strconv.Atoi(key)
+			if err != nil || i < 0 || i >= len(v) {
+				return nil, false
+			}
+			value = v[i]
+		default:
+			return nil, false
+		}
+	}
+	return value, true
+}
@@ -0,0 +1,185 @@
+package utils
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"strconv"
+)
+
+// maxExactFloat is the largest integer that is exactly represented by float64
+const maxExactFloat = 1 << 53
+
+// NormalizeValue converts data parsed by yaml.v2 into the form that encoding/json produces
+// when the same document is decoded from JSON:
+//
+//   - map[interface{}]interface{} becomes map[string]interface{}, non-string keys are
+//     formatted like ghodss/yaml does it ('yes: 1' is a key "true" in YAML 1.1);
+//   - all numbers become float64. Integers that cannot be exactly represented by float64
+//     are kept as int64 or uint64 to not corrupt them;
+//   - NaN and infinities are errors: they cannot be serialized to JSON.
+//
+// Values are compared with reflect.DeepEqual, passed to hooks as JSON and to helm as YAML,
+// so values from YAML files and from hooks should have the same types.
+func NormalizeValue(value interface{}) (interface{}, error) {
+	return normalizeValue(value, "")
+}
+
+// NormalizeValues returns values in the normalized form, see NormalizeValue
+func NormalizeValues(values map[interface{}]interface{}) (Values, error) {
+	res, err := normalizeValue(values, "")
+	if err != nil {
+		return nil, err
+	}
+	return Values(res.(map[string]interface{})), nil
+}
+
+func normalizeValue(value interface{}, path string) (interface{}, error) {
+	switch v := value.(type) {
+	case nil, string, bool:
+		return v, nil
+
+	case map[interface{}]interface{}:
+		res := make(map[string]interface{}, len(v))
+		for key, item := range v {
+			strKey, err := normalizeKey(key, path)
+			if err != nil {
+				return nil, err
+			}
+			if _, has := res[strKey]; has {
+				return nil, fmt.Errorf("%s: duplicate key '%s' after conversion to string", valuePath(path, strKey), strKey)
+			}
+			if res[strKey], err = normalizeValue(item, valuePath(path, strKey)); err != nil {
+				return nil, err
+			}
+		}
+		return res, nil
+
+	case map[string]interface{}:
+		return normalizeStringMap(v, path)
+
+	case Values:
+		return normalizeStringMap(v, path)
+
+	case []interface{}:
+		res := make([]interface{}, len(v))
+		for i, item := range v {
+			var err error
+			if res[i], err = normalizeValue(item, fmt.Sprintf("%s[%d]", path, i)); err != nil {
+				return nil, err
+			}
+		}
+		return res, nil
+
+	case float64:
+		return normalizeFloat(v, path)
+	case float32:
+		return normalizeFloat(float64(v), path)
+	case int:
+		return normalizeInt(int64(v)), nil
+	case int8:
+		return normalizeInt(int64(v)), nil
+	case int16:
+		return normalizeInt(int64(v)), nil
+	case int32:
+		return normalizeInt(int64(v)), nil
+	case int64:
+		return normalizeInt(v), nil
+	case uint:
+		return normalizeUint(uint64(v)), nil
+	case uint8:
+		return normalizeUint(uint64(v)), nil
+	case uint16:
+		return normalizeUint(uint64(v)), nil
+	case uint32:
+		return normalizeUint(uint64(v)), nil
+	case uint64:
+		return normalizeUint(v), nil
+	case json.Number:
+		if i, err := v.Int64(); err == nil {
+			return normalizeInt(i), nil
+		}
+		f, err := v.Float64()
+		if err != nil {
+			return nil, fmt.Errorf("%s: bad number '%s'", valuePathOrRoot(path), v)
+		}
+		return normalizeFloat(f, path)
+	}
+
+	// structs and other types are converted as encoding/json does it
+	data, err := json.Marshal(value)
+	if err != nil {
+		return nil, fmt.Errorf("%s: value of type %T is not JSON compatible: %s", valuePathOrRoot(path), value, err)
+	}
+	var res interface{}
+	if err := json.Unmarshal(data, &res); err != nil {
+		return nil, fmt.Errorf("%s: %s", valuePathOrRoot(path), err)
+	}
+	return res, nil
+}
+
+func normalizeStringMap(values map[string]interface{}, path string) (interface{}, error) {
+	res := make(map[string]interface{}, len(values))
+	for key, item := range values {
+		var err error
+		if res[key], err = normalizeValue(item, valuePath(path, key)); err != nil {
+			return nil, err
+		}
+	}
+	return res, nil
+}
+
+func normalizeKey(key interface{}, path string) (string, error) {
+	switch k := key.(type) {
+	case string:
+		return k, nil
+	case bool:
+		return strconv.FormatBool(k), nil
+	case int:
+		return strconv.Itoa(k), nil
+	case int64:
+		return strconv.FormatInt(k, 10), nil
+	case uint64:
+		return strconv.FormatUint(k, 10), nil
+	case float64:
+		return strconv.FormatFloat(k, 'g', -1, 64), nil
+	case nil:
+		return "null", nil
+	}
+	return "", fmt.Errorf("%s: unsupported key %#v of type %T", valuePathOrRoot(path), key, key)
+}
+
+func normalizeFloat(v float64, path string) (interface{}, error) {
+	if math.IsNaN(v) || math.IsInf(v, 0) {
+		return nil, fmt.Errorf("%s: %v cannot be represented in JSON", valuePathOrRoot(path), v)
+	}
+	return v, nil
+}
+
+func normalizeInt(v int64) interface{} {
+	if v > maxExactFloat || v < -maxExactFloat {
+		return v
+	}
+	return float64(v)
+}
+
+func normalizeUint(v uint64) interface{} {
+	if v > maxExactFloat {
+		return v
+	}
+	return float64(v)
+}
+
+func valuePath(path string, key string) string {
+	if path == "" {
+		return key
+	}
+	return path + "." + key
+}
+
+func valuePathOrRoot(path string) string {
+	if path == "" {
+		return "<root>"
+	}
+	return path
+}
@@ -0,0 +1,75 @@
+package utils
+
+import (
+	"encoding/json"
+	"math"
+	"reflect"
+	"testing"
+
+	"github.com/go-yaml/yaml"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestNormalizeValues(t *testing.T) {
+	yamlDoc := `
+replicas: 2
+ratio: 0.5
+on: true
+ports:
+  80: http
+  443: https
+big: 9007199254740993
+nodes:
+- name: a
+  weight: 10
+`
+	var raw map[interface{}]interface{}
+	if !assert.NoError(t, yaml.Unmarshal([]byte(yamlDoc), &raw)) {
+		return
+	}
+
+	values, err := NormalizeValues(raw)
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.Equal(t, Values{
+		"replicas": 2.0,
+		"ratio":    0.5,
+		"true":     true,
+		"ports":    map[string]interface{}{"80": "http", "443": "https"},
+		"big":      int64(9007199254740993),
+		"nodes": []interface{}{
+			map[string]interface{}{"name": "a", "weight": 10.0},
+		},
+	}, values)
+
+	// the same document from JSON is equal to the normalized YAML
+	jsonDoc := `{"replicas": 2, "ratio": 0.5, "true": true, "ports": {"80": "http", "443": "https"}, "nodes": [{"name": "a", "weight": 10}]}`
+	var fromJson map[string]interface{}
+	if !assert.NoError(t, json.Unmarshal([]byte(jsonDoc), &fromJson)) {
+		return
+	}
+	delete(values, "big")
+	assert.True(t, reflect.DeepEqual(map[string]interface{}(values), fromJson))
+}
+
+func TestNormalizeValue_Errors(t *testing.T) {
+	_, err := NormalizeValue(map[interface{}]interface{}{"a": map[interface{}]interface{}{"b": math.Inf(1)}})
+	assert.EqualError(t, err, "a.b: +Inf cannot be represented in JSON")
+
+	_, err = NormalizeValue(map[interface{}]interface{}{"a": []interface{}{map[interface{}]interface{}{1: "x", "1": "y"}}})
+	assert.EqualError(t, err, "a[0].1: duplicate key '1' after conversion to string")
+}
+
+func TestApplyValuesPatch_NormalizedValues(t *testing.T) {
+	values, err := NewValuesFromBytes([]byte("global:\n  replicas: 2\n"))
+	if !assert.NoError(t, err) {
+		return
+	}
+	values["global"].(map[string]interface{})["replicas"] = 2
+
+	patch := ValuesPatch{Operations: []*ValuesPatchOperation{{Op: "add", Path: "/global/replicas", Value: 2}}}
+	_, changed, err := ApplyValuesPatch(values, patch)
+	assert.NoError(t, err)
+	assert.False(t, changed)
+}
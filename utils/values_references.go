@@ -0,0 +1,44 @@
+package utils
+
+import "strings"
+
+// ResolveValuesReferences returns a copy of values where strings with the prefix
+// are replaced with results of resolve. Values are not modified.
+func ResolveValuesReferences(values Values, prefix string, resolve func(ref string) (interface{}, error)) (Values, error) {
+	res, err := resolveReferences(map[string]interface{}(values), prefix, resolve)
+	if err != nil {
+		return nil, err
+	}
+	return Values(res.(map[string]interface{})), nil
+}
+
+func resolveReferences(value interface{}, prefix string, resolve func(ref string) (interface{}, error)) (interface{}, error) {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		res := make(map[string]interface{}, len(v))
+		for key, item := range v {
+			resolved, err := resolveReferences(item, prefix, resolve)
+			if err != nil {
+				return nil, err
+			}
+			res[key] = resolved
+		}
+		return res, nil
+	case []interface{}:
+		res := make([]interface{}, len(v))
+		for i, item := range v {
+			resolved, err := resolveReferences(item, prefix, resolve)
+			if err != nil {
+				return nil, err
+			}
+			res[i] = resolved
+		}
+		return res, nil
+	case string:
+		if strings.HasPrefix(v, prefix) {
+			return resolve(strings.TrimPrefix(v, prefix))
+		}
+	}
+
+	return DeepCopyValue(value), nil
+}
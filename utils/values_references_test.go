@@ -0,0 +1,42 @@
+package utils
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestResolveValuesReferences(t *testing.T) {
+	values := Values{
+		"db": map[string]interface{}{
+			"password": "vault:secret/db#password",
+			"users":    []interface{}{"admin", "vault:secret/db#user"},
+			"port":     5432.0,
+		},
+	}
+
+	resolve := func(ref string) (interface{}, error) {
+		return fmt.Sprintf("<%s>", ref), nil
+	}
+
+	res, err := ResolveValuesReferences(values, "vault:", resolve)
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.Equal(t, Values{
+		"db": map[string]interface{}{
+			"password": "<secret/db#password>",
+			"users":    []interface{}{"admin", "<secret/db#user>"},
+			"port":     5432.0,
+		},
+	}, res)
+
+	// values are not modified
+	assert.Equal(t, "vault:secret/db#password", values["db"].(map[string]interface{})["password"])
+
+	_, err = ResolveValuesReferences(values, "vault:", func(ref string) (interface{}, error) {
+		return nil, fmt.Errorf("no secret")
+	})
+	assert.Error(t, err)
+}
@@ -0,0 +1,90 @@
+package utils
+
+// ApplyValuesDefaults returns a copy of values with defaults from OpenAPI schema.
+// Default is set only for absent fields. Absent objects are created if
+// their properties have defaults. Values and schema are not modified.
+func ApplyValuesDefaults(values Values, schema Values) Values {
+	res := DeepCopyValue(map[string]interface{}(values)).(map[string]interface{})
+	applySchemaDefaults(res, schema)
+	return Values(res)
+}
+
+func applySchemaDefaults(value interface{}, schema map[string]interface{}) interface{} {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		properties, _ := schema["properties"].(map[string]interface{})
+		for name, rawPropSchema := range properties {
+			propSchema, ok := rawPropSchema.(map[string]interface{})
+			if !ok {
+				continue
+			}
+
+			propValue, hasValue := v[name]
+			if !hasValue {
+				if defaultValue, hasDefault := propSchema["default"]; hasDefault {
+					propValue = DeepCopyValue(defaultValue)
+				} else if schemaHasDefaults(propSchema) {
+					propValue = map[string]interface{}{}
+				} else {
+					continue
+				}
+			}
+
+			v[name] = applySchemaDefaults(propValue, propSchema)
+		}
+		return v
+	case []interface{}:
+		if items, ok := schema["items"].(map[string]interface{}); ok {
+			for i := range v {
+				v[i] = applySchemaDefaults(v[i], items)
+			}
+		}
+		return v
+	}
+
+	return value
+}
+
+// schemaHasDefaults returns true if object schema has defaults in properties
+func schemaHasDefaults(schema map[string]interface{}) bool {
+	properties, _ := schema["properties"].(map[string]interface{})
+	for _, rawPropSchema := range properties {
+		propSchema, ok := rawPropSchema.(map[string]interface{})
+		if !ok {
+			continue
+		}
+		if _, hasDefault := propSchema["default"]; hasDefault {
+			return true
+		}
+		if schemaHasDefaults(propSchema) {
+			return true
+		}
+	}
+	return false
+}
+
+// DeepCopyValue returns a copy of json compatible value
+func DeepCopyValue(value interface{}) interface{} {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		res := make(map[string]interface{}, len(v))
+		for key, item := range v {
+			res[key] = DeepCopyValue(item)
+		}
+		return res
+	case Values:
+		res := make(Values, len(v))
+		for key, item := range v {
+			res[key] = DeepCopyValue(item)
+		}
+		return res
+	case []interface{}:
+		res := make([]interface{}, len(v))
+		for i, item := range v {
+			res[i] = DeepCopyValue(item)
+		}
+		return res
+	}
+
+	return value
+}
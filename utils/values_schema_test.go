@@ -0,0 +1,73 @@
+package utils
+
+import (
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestApplyValuesDefaults(t *testing.T) {
+	schema, err := NewValuesFromBytes([]byte(`
+type: object
+properties:
+  replicas:
+    type: integer
+    default: 2
+  image:
+    type: object
+    properties:
+      tag:
+        type: string
+        default: latest
+      pullPolicy:
+        type: string
+  ingress:
+    type: object
+    properties:
+      enabled:
+        type: boolean
+  hosts:
+    type: array
+    items:
+      type: object
+      properties:
+        port:
+          type: integer
+          default: 80
+`))
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	values, err := NewValuesFromBytes([]byte(`
+replicas: 3
+hosts:
+- name: a
+- name: b
+  port: 8080
+`))
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	expected, err := NewValuesFromBytes([]byte(`
+replicas: 3
+image:
+  tag: latest
+hosts:
+- name: a
+  port: 80
+- name: b
+  port: 8080
+`))
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	res := ApplyValuesDefaults(values, schema)
+	assert.Equal(t, expected, res)
+
+	// values are not modified
+	assert.NotContains(t, values, "image")
+	assert.Equal(t, map[string]interface{}{"name": "a"}, values["hosts"].([]interface{})[0])
+}
@@ -0,0 +1,245 @@
+package utils
+
+import (
+	"bytes"
+	"encoding/base64"
+	"fmt"
+	"strconv"
+	"strings"
+	"text/template"
+	"text/template/parse"
+)
+
+// ValuesTemplatePrefix marks string values that are evaluated as Go templates when values
+// are merged, e.g. 'tpl:{{ env "CLUSTER_NAME" }}' or 'tpl:{{ ref "global.discovery.clusterDomain" }}'.
+//
+// Available functions:
+//
+//   - env NAME: environment variable, lookupEnv decides which variables are available;
+//   - ref PATH: value by dot separated path (list items are addressed by index). Referenced
+//     templates are evaluated first, reference cycles are errors. A template with a single
+//     ref action keeps the type of the referenced value, so maps and numbers can be copied;
+//   - b64enc, b64dec: base64 encoding;
+//   - default DEFAULT VALUE: DEFAULT if VALUE is empty.
+const ValuesTemplatePrefix = "tpl:"
+
+// EvaluateValuesTemplates returns a copy of values with templates replaced with results.
+// Templates that cannot be evaluated are left as is and their errors are returned together.
+// Values are returned as is if there are no templates.
+func EvaluateValuesTemplates(values Values, lookupEnv func(name string) (string, error)) (Values, error) {
+	if !hasValuesTemplates(map[string]interface{}(values)) {
+		return values, nil
+	}
+
+	e := &valuesTemplatesEvaluator{
+		values:    map[string]interface{}(values),
+		lookupEnv: lookupEnv,
+		results:   make(map[string]interface{}),
+	}
+	res := e.evaluateAll(map[string]interface{}(values), "")
+	if len(e.errs) > 0 {
+		return Values(res.(map[string]interface{})), fmt.Errorf("%s", strings.Join(e.errs, "; "))
+	}
+	return Values(res.(map[string]interface{})), nil
+}
+
+func hasValuesTemplates(value interface{}) bool {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		for _, item := range v {
+			if hasValuesTemplates(item) {
+				return true
+			}
+		}
+	case []interface{}:
+		for _, item := range v {
+			if hasValuesTemplates(item) {
+				return true
+			}
+		}
+	case string:
+		return strings.HasPrefix(v, ValuesTemplatePrefix)
+	}
+	return false
+}
+
+type valuesTemplatesEvaluator struct {
+	values    map[string]interface{}
+	lookupEnv func(name string) (string, error)
+	// results of templates by path
+	results map[string]interface{}
+	// paths of templates that are being evaluated, to detect cycles
+	stack []string
+	errs  []string
+}
+
+// evaluateAll evaluates all templates in the value, errors are collected
+func (e *valuesTemplatesEvaluator) evaluateAll(value interface{}, path string) interface{} {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		res := make(map[string]interface{}, len(v))
+		for key, item := range v {
+			res[key] = e.evaluateAll(item, valuePath(path, key))
+		}
+		return res
+	case []interface{}:
+		res := make([]interface{}, len(v))
+		for i, item := range v {
+			res[i] = e.evaluateAll(item, valuePath(path, strconv.Itoa(i)))
+		}
+		return res
+	case string:
+		if strings.HasPrefix(v, ValuesTemplatePrefix) {
+			res, err := e.evaluate(path, v)
+			if err != nil {
+				e.errs = append(e.errs, err.Error())
+				return v
+			}
+			return res
+		}
+	}
+	return DeepCopyValue(value)
+}
+
+// resolve evaluates all templates in the referenced value, the first error is returned
+func (e *valuesTemplatesEvaluator) resolve(value interface{}, path string) (interface{}, error) {
+	switch v := value.(type) {
+	case map[string]interface{}:
+		res := make(map[string]interface{}, len(v))
+		for key, item := range v {
+			resolved, err := e.resolve(item, valuePath(path, key))
+			if err != nil {
+				return nil, err
+			}
+			res[key] = resolved
+		}
+		return res, nil
+	case []interface{}:
+		res := make([]interface{}, len(v))
+		for i, item := range v {
+			resolved, err := e.resolve(item, valuePath(path, strconv.Itoa(i)))
+			if err != nil {
+				return nil, err
+			}
+			res[i] = resolved
+		}
+		return res, nil
+	case string:
+		if strings.HasPrefix(v, ValuesTemplatePrefix) {
+			return e.evaluate(path, v)
+		}
+	}
+	return DeepCopyValue(value), nil
+}
+
+func (e *valuesTemplatesEvaluator) evaluate(path string, value string) (interface{}, error) {
+	if res, has := e.results[path]; has {
+		return DeepCopyValue(res), nil
+	}
+	for i, p := range e.stack {
+		if p == path {
+			return nil, fmt.Errorf("%s: reference cycle %s", path, strings.Join(append(append([]string{}, e.stack[i:]...), path), " -> "))
+		}
+	}
+	e.stack = append(e.stack, path)
+	defer func() { e.stack = e.stack[:len(e.stack)-1] }()
+
+	tpl, err := template.New(path).Funcs(template.FuncMap{
+		"env":     e.env,
+		"ref":     e.ref,
+		"b64enc":  b64enc,
+		"b64dec":  b64dec,
+		"default": defaultValue,
+	}).Parse(strings.TrimPrefix(value, ValuesTemplatePrefix))
+	if err != nil {
+		return nil, fmt.Errorf("%s: bad template: %s", path, err)
+	}
+
+	var res interface{}
+	if refPath, ok := singleRefAction(tpl); ok {
+		if res, err = e.ref(refPath); err != nil {
+			return nil, fmt.Errorf("%s: %s", path, err)
+		}
+	} else {
+		buf := &bytes.Buffer{}
+		if err := tpl.Execute(buf, nil); err != nil {
+			return nil, fmt.Errorf("%s: %s", path, err)
+		}
+		res = buf.String()
+	}
+
+	e.results[path] = res
+	return DeepCopyValue(res), nil
+}
+
+func (e *valuesTemplatesEvaluator) env(name string) (string, error) {
+	if e.lookupEnv == nil {
+		return "", fmt.Errorf("environment variables are not available")
+	}
+	return e.lookupEnv(name)
+}
+
+func (e *valuesTemplatesEvaluator) ref(path string) (interface{}, error) {
+	var value interface{} = e.values
+	for _, key := range strings.Split(path, ".") {
+		switch v := value.(type) {
+		case map[string]interface{}:
+			item, has := v[key]
+			if !has {
+				return nil, fmt.Errorf("ref '%s': no key '%s'", path, key)
+			}
+			value = item
+		case []interface{}:
+			i, err := strconv.Atoi(key)
+			if err != nil || i < 0 || i >= len(v) {
+				return nil, fmt.Errorf("ref '%s': bad index '%s'", path, key)
+			}
+			value = v[i]
+		default:
+			return nil, fmt.Errorf("ref '%s': '%s' is not a map or a list", path, key)
+		}
+	}
+	return e.resolve(value, path)
+}
+
+// singleRefAction returns the path if the template is exactly '{{ ref "path" }}'
+func singleRefAction(tpl *template.Template) (string, bool) {
+	if tpl.Tree == nil || len(tpl.Tree.Root.Nodes) != 1 {
+		return "", false
+	}
+	action, ok := tpl.Tree.Root.Nodes[0].(*parse.ActionNode)
+	if !ok || len(action.Pipe.Decl) != 0 || len(action.Pipe.Cmds) != 1 {
+		return "", false
+	}
+	args := action.Pipe.Cmds[0].Args
+	if len(args) != 2 {
+		return "", false
+	}
+	if ident, ok := args[0].(*parse.IdentifierNode); !ok || ident.Ident != "ref" {
+		return "", false
+	}
+	path, ok := args[1].(*parse.StringNode)
+	if !ok {
+		return "", false
+	}
+	return path.Text, true
+}
+
+func b64enc(s string) string {
+	return base64.StdEncoding.EncodeToString([]byte(s))
+}
+
+func b64dec(s string) (string, error) {
+	data, err := base64.StdEncoding.DecodeString(s)
+	if err != nil {
+		return "", err
+	}
+	return string(data), nil
+}
+
+func defaultValue(def interface{}, value interface{}) interface{} {
+	if value == nil || value == "" {
+		return def
+	}
+	return value
+}
@@ -0,0 +1,79 @@
+package utils
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestEvaluateValuesTemplates(t *testing.T) {
+	values := Values{
+		"global": map[string]interface{}{
+			"clusterName": `tpl:{{ env "CLUSTER_NAME" }}`,
+			"discovery": map[string]interface{}{
+				"clusterDomain": "cluster.local",
+				"replicas":      3.0,
+			},
+		},
+		"dex": map[string]interface{}{
+			"issuer":   `tpl:https://dex.{{ ref "global.clusterName" }}.{{ ref "global.discovery.clusterDomain" }}`,
+			"replicas": `tpl:{{ ref "global.discovery.replicas" }}`,
+			"token":    `tpl:{{ ref "global.clusterName" | b64enc }}`,
+			"region":   `tpl:{{ env "REGION" | default "eu" }}`,
+			"domains":  []interface{}{"static", `tpl:{{ ref "dex.issuer" }}`},
+		},
+	}
+
+	env := map[string]string{"CLUSTER_NAME": "prod"}
+	lookupEnv := func(name string) (string, error) {
+		return env[name], nil
+	}
+
+	res, err := EvaluateValuesTemplates(values, lookupEnv)
+	if !assert.NoError(t, err) {
+		return
+	}
+	assert.Equal(t, map[string]interface{}{
+		"issuer":   "https://dex.prod.cluster.local",
+		"replicas": 3.0,
+		"token":    "cHJvZA==",
+		"region":   "eu",
+		"domains":  []interface{}{"static", "https://dex.prod.cluster.local"},
+	}, res["dex"])
+	assert.Equal(t, "prod", res["global"].(map[string]interface{})["clusterName"])
+
+	// values are not modified
+	assert.Equal(t, `tpl:{{ ref "global.discovery.replicas" }}`, values["dex"].(map[string]interface{})["replicas"])
+
+	// values without templates are returned as is
+	plain := Values{"a": "b"}
+	res, err = EvaluateValuesTemplates(plain, nil)
+	assert.NoError(t, err)
+	assert.Equal(t, plain, res)
+}
+
+func TestEvaluateValuesTemplates_Errors(t *testing.T) {
+	values := Values{
+		"a": `tpl:{{ ref "b" }}`,
+		"b": `tpl:x-{{ ref "a" }}`,
+		"c": `tpl:{{ ref "missing.key" }}`,
+		"d": `tpl:{{ env "SECRET" }}`,
+		"e": "ok",
+	}
+	lookupEnv := func(name string) (string, error) {
+		return "", fmt.Errorf("variable '%s' is not allowed", name)
+	}
+
+	res, err := EvaluateValuesTemplates(values, lookupEnv)
+	if !assert.Error(t, err) {
+		return
+	}
+	assert.Contains(t, err.Error(), "reference cycle a -> b -> a")
+	assert.Contains(t, err.Error(), "ref 'missing.key': no key 'missing'")
+	assert.Contains(t, err.Error(), "variable 'SECRET' is not allowed")
+
+	// templates with errors are left as is
+	assert.Equal(t, `tpl:{{ ref "missing.key" }}`, res["c"])
+	assert.Equal(t, "ok", res["e"])
+}
@@ -5,6 +5,8 @@ import (
 	"reflect"
 	"strings"
 	"testing"
+
+	"github.com/stretchr/testify/assert"
 )
 
 func TestModuleConfig(t *testing.T) {
@@ -200,16 +202,16 @@ func TestCompactValuesPatchOperations(t *testing.T) {
 			"path",
 			[]*ValuesPatchOperation{
 				{
-					"add",
-					"/a",
-					"",
+					Op:    "add",
+					Path:  "/a",
+					Value: "",
 				},
 			},
 			[]*ValuesPatchOperation{
 				{
-					"add",
-					"/a",
-					"",
+					Op:    "add",
+					Path:  "/a",
+					Value: "",
 				},
 			},
 			nil,
@@ -218,16 +220,16 @@ func TestCompactValuesPatchOperations(t *testing.T) {
 			"subpath",
 			[]*ValuesPatchOperation{
 				{
-					"add",
-					"/a/b",
-					"",
+					Op:    "add",
+					Path:  "/a/b",
+					Value: "",
 				},
 			},
 			[]*ValuesPatchOperation{
 				{
-					"add",
-					"/a",
-					"",
+					Op:    "add",
+					Path:  "/a",
+					Value: "",
 				},
 			},
 			nil,
@@ -236,23 +238,23 @@ func TestCompactValuesPatchOperations(t *testing.T) {
 			"different op",
 			[]*ValuesPatchOperation{
 				{
-					"add",
-					"/a",
-					"",
+					Op:    "add",
+					Path:  "/a",
+					Value: "",
 				},
 			},
 			[]*ValuesPatchOperation{
 				{
-					"delete",
-					"/a",
-					"",
+					Op:    "delete",
+					Path:  "/a",
+					Value: "",
 				},
 			},
 			[]*ValuesPatchOperation{
 				{
-					"add",
-					"/a",
-					"",
+					Op:    "add",
+					Path:  "/a",
+					Value: "",
 				},
 			},
 		},
@@ -260,23 +262,23 @@ func TestCompactValuesPatchOperations(t *testing.T) {
 			"different path",
 			[]*ValuesPatchOperation{
 				{
-					"add",
-					"/a",
-					"",
+					Op:    "add",
+					Path:  "/a",
+					Value: "",
 				},
 			},
 			[]*ValuesPatchOperation{
 				{
-					"add",
-					"/b",
-					"",
+					Op:    "add",
+					Path:  "/b",
+					Value: "",
 				},
 			},
 			[]*ValuesPatchOperation{
 				{
-					"add",
-					"/a",
-					"",
+					Op:    "add",
+					Path:  "/a",
+					Value: "",
 				},
 			},
 		},
@@ -284,48 +286,48 @@ func TestCompactValuesPatchOperations(t *testing.T) {
 			"sample",
 			[]*ValuesPatchOperation{
 				{
-					"add",
-					"/a",
-					"",
+					Op:    "add",
+					Path:  "/a",
+					Value: "",
 				},
 				{
-					"add",
-					"/a/b",
-					"",
+					Op:    "add",
+					Path:  "/a/b",
+					Value: "",
 				},
 				{
-					"add",
-					"/b",
-					"",
+					Op:    "add",
+					Path:  "/b",
+					Value: "",
 				},
 				{
-					"delete",
-					"/c",
-					"",
+					Op:    "delete",
+					Path:  "/c",
+					Value: "",
 				},
 			},
 			[]*ValuesPatchOperation{
 				{
-					"add",
-					"/a",
-					"",
+					Op:    "add",
+					Path:  "/a",
+					Value: "",
 				},
 				{
-					"delete",
-					"/c",
-					"",
+					Op:    "delete",
+					Path:  "/c",
+					Value: "",
 				},
 				{
-					"add",
-					"/d",
-					"",
+					Op:    "add",
+					Path:  "/d",
+					Value: "",
 				},
 			},
 			[]*ValuesPatchOperation{
 				{
-					"add",
-					"/b",
-					"",
+					Op:    "add",
+					Path:  "/b",
+					Value: "",
 				},
 			},
 		},
@@ -357,9 +359,9 @@ func TestApplyPatch(t *testing.T) {
 			ValuesPatch{
 				[]*ValuesPatchOperation{
 					{
-						"add",
-						"/test_key_3",
-						"baz",
+						Op:    "add",
+						Path:  "/test_key_3",
+						Value: "baz",
 					},
 				},
 			},
@@ -379,9 +381,9 @@ func TestApplyPatch(t *testing.T) {
 			ValuesPatch{
 				[]*ValuesPatchOperation{
 					{
-						"remove",
-						"/test_key_3",
-						"baz",
+						Op:    "remove",
+						Path:  "/test_key_3",
+						Value: "baz",
 					},
 				},
 			},
@@ -416,3 +418,67 @@ func TestApplyPatch(t *testing.T) {
 		})
 	}
 }
+
+func TestApplyValuesPatch_Move(t *testing.T) {
+	patch, err := ValuesPatchFromBytes([]byte(`[{"op":"move","from":"/module/old","path":"/module/new"}]`))
+	if err != nil {
+		t.Fatalf("ValuesPatchFromBytes error: %s", err)
+	}
+
+	newValues, changed, err := ApplyValuesPatch(Values{"module": map[string]interface{}{"old": "foo"}}, *patch)
+	if err != nil {
+		t.Fatalf("ApplyValuesPatch error: %s", err)
+	}
+
+	expected := Values{"module": map[string]interface{}{"new": "foo"}}
+	if !reflect.DeepEqual(expected, newValues) {
+		t.Errorf("\n[EXPECTED]: %#v\n[GOT]: %#v", expected, newValues)
+	}
+	if !changed {
+		t.Errorf("values should be changed")
+	}
+}
+
+func TestValuesChangesSummary(t *testing.T) {
+	oldValues := Values{
+		"global": map[string]interface{}{"a": 1.0, "b": "x"},
+		"module": map[string]interface{}{"c": []interface{}{"1"}, "d": true},
+		"flag":   true,
+	}
+	newValues := Values{
+		"global": map[string]interface{}{"a": 1.0, "b": "y"},
+		"module": map[string]interface{}{"c": []interface{}{"1"}, "e": 2.0},
+		"flag":   false,
+	}
+
+	expected := []string{"+module.e", "-module.d", "~flag", "~global.b"}
+	res := ValuesChangesSummary(oldValues, newValues)
+	if !reflect.DeepEqual(expected, res) {
+		t.Errorf("\n[EXPECTED]: %#v\n[GOT]: %#v", expected, res)
+	}
+}
+
+func TestValueAtPath(t *testing.T) {
+	values, err := NewValuesFromBytes([]byte(`
+global:
+  modules:
+  - name: nginx
+    replicas: 2
+`))
+	if !assert.NoError(t, err) {
+		return
+	}
+
+	value, found := ValueAtPath(values, "global.modules.0.replicas")
+	assert.True(t, found)
+	assert.Equal(t, 2.0, value)
+
+	value, found = ValueAtPath(values, "")
+	assert.True(t, found)
+	assert.Equal(t, values, value)
+
+	for _, path := range []string{"global.modules.1", "global.modules.name", "global.modules.0.replicas.x", "nginx"} {
+		_, found = ValueAtPath(values, path)
+		assert.False(t, found, path)
+	}
+}
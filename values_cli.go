@@ -0,0 +1,124 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"os"
+	"time"
+
+	"github.com/flant/antiopa/module_manager"
+)
+
+// Address of HTTP API of running antiopa for CLI commands
+var ApiAddress string
+
+// RunValuesCommand handles `antiopa values export [file]` and `antiopa values import <file>`.
+// Commands use the API of running antiopa, so they are run with kubectl exec.
+// `antiopa values eval` evaluates paths, jq filters and merges against current values.
+// `antiopa values docs` works offline with modules in the current dir.
+func RunValuesCommand(args []string) error {
+	if len(args) == 0 {
+		return fmt.Errorf("usage: antiopa values export [file] | antiopa values import <file> | antiopa values eval [-module name] [expression...] | antiopa values docs [-format markdown|json] [file]")
+	}
+
+	if args[0] == "docs" {
+		return runValuesDocsCommand(args[1:])
+	}
+	if args[0] == "eval" {
+		return runValuesEvalCommand(args[1:])
+	}
+
+	client := &http.Client{Timeout: 60 * time.Second}
+
+	switch args[0] {
+	case "export":
+		resp, err := client.Get(ApiAddress + "/values/export")
+		if err != nil {
+			return fmt.Errorf("cannot export values: %s", err)
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			body, _ := ioutil.ReadAll(resp.Body)
+			return fmt.Errorf("cannot export values: %s: %s", resp.Status, string(body))
+		}
+
+		out := io.Writer(os.Stdout)
+		if len(args) > 1 {
+			f, err := os.Create(args[1])
+			if err != nil {
+				return err
+			}
+			defer f.Close()
+			out = f
+		}
+		_, err = io.Copy(out, resp.Body)
+		return err
+
+	case "import":
+		if len(args) < 2 {
+			return fmt.Errorf("usage: antiopa values import <file>")
+		}
+		data, err := ioutil.ReadFile(args[1])
+		if err != nil {
+			return err
+		}
+
+		resp, err := client.Post(ApiAddress+"/values/import", "application/json", bytes.NewReader(data))
+		if err != nil {
+			return fmt.Errorf("cannot import values: %s", err)
+		}
+		defer resp.Body.Close()
+		body, _ := ioutil.ReadAll(resp.Body)
+		if resp.StatusCode != http.StatusOK {
+			return fmt.Errorf("cannot import values: %s: %s", resp.Status, string(body))
+		}
+		fmt.Println(string(body))
+		return nil
+	}
+
+	return fmt.Errorf("unknown values command '%s'", args[0])
+}
+
+// runValuesDocsCommand prints documentation of all modules values from schemas and values.yaml files
+func runValuesDocsCommand(args []string) error {
+	flags := flag.NewFlagSet("values docs", flag.ContinueOnError)
+	format := flags.String("format", "markdown", "output format: markdown or json")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	workingDir, err := os.Getwd()
+	if err != nil {
+		return fmt.Errorf("cannot determine working dir: %s", err)
+	}
+
+	docs, err := module_manager.GenerateValuesDocs(workingDir)
+	if err != nil {
+		return err
+	}
+
+	var data []byte
+	switch *format {
+	case "markdown":
+		data = []byte(module_manager.ValuesDocsMarkdown(docs))
+	case "json":
+		data, err = json.MarshalIndent(docs, "", "  ")
+		if err != nil {
+			return err
+		}
+		data = append(data, '\n')
+	default:
+		return fmt.Errorf("unknown format '%s', markdown or json is expected", *format)
+	}
+
+	if flags.NArg() > 0 {
+		return ioutil.WriteFile(flags.Arg(0), data, 0644)
+	}
+	_, err = os.Stdout.Write(data)
+	return err
+}
@@ -0,0 +1,299 @@
+package main
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"os"
+	"os/exec"
+	"strings"
+	"time"
+
+	"github.com/flant/antiopa/utils"
+)
+
+const valuesEvalHelp = `commands:
+  get [path]             value at the dot separated path, numbers are indexes of lists: global.modules.0
+  .filter | jq <filter>  jq filter on values, e.g. .global.discovery | keys
+  merge <yaml|json>      merge values into the local copy as values of the ConfigMap are merged
+  patch <json patch>     apply json patch to the local copy as a patch from a hook
+  diff                   keys changed by merge and patch
+  reset                  drop local changes
+  reload                 fetch current values from antiopa, local changes are dropped
+  module [name|global]   show or switch values of module hooks or global hooks
+  help                   this help
+  exit                   quit
+`
+
+// ValuesEvalSession evaluates expressions against values fetched from running antiopa.
+// merge and patch change only the local copy, antiopa state is never changed.
+type ValuesEvalSession struct {
+	Module string
+
+	fetch func(moduleName string) (utils.Values, error)
+	jq    func(filter string, data []byte) (string, error)
+
+	current utils.Values
+	values  utils.Values
+}
+
+func NewValuesEvalSession(moduleName string, fetch func(moduleName string) (utils.Values, error)) *ValuesEvalSession {
+	return &ValuesEvalSession{
+		Module: moduleName,
+		fetch:  fetch,
+		jq:     runJq,
+	}
+}
+
+// Reload fetches values of the module or global values
+func (s *ValuesEvalSession) Reload() error {
+	values, err := s.fetch(s.Module)
+	if err != nil {
+		return err
+	}
+	s.current = values
+	s.values, err = copyValues(values)
+	return err
+}
+
+// Eval runs the command and returns its output
+func (s *ValuesEvalSession) Eval(line string) (string, error) {
+	line = strings.TrimSpace(line)
+	if line == "" {
+		return "", nil
+	}
+	if strings.HasPrefix(line, ".") {
+		return s.evalJq(line)
+	}
+
+	command, arg := line, ""
+	if i := strings.IndexAny(line, " \t"); i > 0 {
+		command, arg = line[:i], strings.TrimSpace(line[i+1:])
+	}
+
+	switch command {
+	case "help":
+		return valuesEvalHelp, nil
+
+	case "get":
+		value, found := utils.ValueAtPath(s.values, strings.TrimPrefix(arg, "."))
+		if !found {
+			return "", fmt.Errorf("'%s' is not found", arg)
+		}
+		return utils.YamlToString(value), nil
+
+	case "jq":
+		if arg == "" {
+			return "", fmt.Errorf("usage: jq <filter>")
+		}
+		return s.evalJq(arg)
+
+	case "merge":
+		if arg == "" {
+			return "", fmt.Errorf("usage: merge <yaml|json>")
+		}
+		values, err := utils.NewValuesFromBytes([]byte(arg))
+		if err != nil {
+			return "", err
+		}
+		// merge changes nested maps of the first values
+		merged, err := copyValues(s.values)
+		if err != nil {
+			return "", err
+		}
+		return s.apply(utils.MergeValues(merged, values))
+
+	case "patch":
+		if arg == "" {
+			return "", fmt.Errorf("usage: patch <json patch>")
+		}
+		patch, err := utils.ValuesPatchFromBytes([]byte(arg))
+		if err != nil {
+			return "", err
+		}
+		patched, _, err := utils.ApplyValuesPatch(s.values, *patch)
+		if err != nil {
+			return "", fmt.Errorf("patch is not applied: %s", err)
+		}
+		return s.apply(patched)
+
+	case "diff":
+		return valuesChangesOutput(utils.ValuesChangesSummary(s.current, s.values)), nil
+
+	case "reset":
+		values, err := copyValues(s.current)
+		if err != nil {
+			return "", err
+		}
+		s.values = values
+		return "local changes are dropped\n", nil
+
+	case "reload":
+		if err := s.Reload(); err != nil {
+			return "", err
+		}
+		return fmt.Sprintf("values of %s are fetched\n", s.scope()), nil
+
+	case "module":
+		if arg == "" {
+			return s.scope() + "\n", nil
+		}
+		previous := s.Module
+		s.Module = arg
+		if arg == "global" {
+			s.Module = ""
+		}
+		if err := s.Reload(); err != nil {
+			s.Module = previous
+			return "", err
+		}
+		return fmt.Sprintf("values of %s are fetched\n", s.scope()), nil
+	}
+
+	return "", fmt.Errorf("unknown command '%s', type 'help' for commands", command)
+}
+
+// apply replaces the local copy and returns changed keys
+func (s *ValuesEvalSession) apply(values utils.Values) (string, error) {
+	changes := utils.ValuesChangesSummary(s.values, values)
+	s.values = values
+	return valuesChangesOutput(changes), nil
+}
+
+func (s *ValuesEvalSession) evalJq(filter string) (string, error) {
+	data, err := json.Marshal(s.values)
+	if err != nil {
+		return "", err
+	}
+	res, err := s.jq(filter, data)
+	if err != nil {
+		return "", err
+	}
+	return res + "\n", nil
+}
+
+func (s *ValuesEvalSession) scope() string {
+	if s.Module == "" {
+		return "global hooks"
+	}
+	return fmt.Sprintf("module '%s'", s.Module)
+}
+
+func (s *ValuesEvalSession) prompt() string {
+	if s.Module == "" {
+		return "global> "
+	}
+	return s.Module + "> "
+}
+
+// Run reads commands line by line until exit or EOF, errors of commands are printed
+func (s *ValuesEvalSession) Run(in io.Reader, out io.Writer) error {
+	scanner := bufio.NewScanner(in)
+	// merge and patch can have large documents in one line
+	scanner.Buffer(make([]byte, 64*1024), 4*1024*1024)
+
+	fmt.Fprintf(out, "values of %s, type 'help' for commands\n", s.scope())
+	for {
+		fmt.Fprint(out, s.prompt())
+		if !scanner.Scan() {
+			fmt.Fprintln(out)
+			return scanner.Err()
+		}
+		line := strings.TrimSpace(scanner.Text())
+		if line == "exit" || line == "quit" {
+			return nil
+		}
+		res, err := s.Eval(line)
+		if err != nil {
+			fmt.Fprintf(out, "error: %s\n", err)
+			continue
+		}
+		fmt.Fprint(out, res)
+	}
+}
+
+func valuesChangesOutput(changes []string) string {
+	if len(changes) == 0 {
+		return "no changes\n"
+	}
+	return strings.Join(changes, "\n") + "\n"
+}
+
+// copyValues returns a deep copy of values
+func copyValues(values utils.Values) (utils.Values, error) {
+	data, err := json.Marshal(values)
+	if err != nil {
+		return nil, err
+	}
+	res := make(utils.Values)
+	if err := json.Unmarshal(data, &res); err != nil {
+		return nil, err
+	}
+	return res, nil
+}
+
+// runJq runs jq from PATH, the CLI is run in antiopa container with jq or on a developer machine
+func runJq(filter string, data []byte) (string, error) {
+	cmd := exec.Command("jq", filter)
+	cmd.Stdin = bytes.NewReader(data)
+	var stdout, stderr bytes.Buffer
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return "", fmt.Errorf("jq: %s: %s", err, strings.TrimSpace(stderr.String()))
+	}
+	return strings.TrimSpace(stdout.String()), nil
+}
+
+// fetchCurrentValues returns values as hooks see them from API of running antiopa
+func fetchCurrentValues(moduleName string) (utils.Values, error) {
+	client := &http.Client{Timeout: 60 * time.Second}
+	resp, err := client.Get(ApiAddress + "/values/current?module=" + url.QueryEscape(moduleName))
+	if err != nil {
+		return nil, fmt.Errorf("cannot get values: %s", err)
+	}
+	defer resp.Body.Close()
+	body, _ := ioutil.ReadAll(resp.Body)
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("cannot get values: %s: %s", resp.Status, strings.TrimSpace(string(body)))
+	}
+	values := make(utils.Values)
+	if err := json.Unmarshal(body, &values); err != nil {
+		return nil, fmt.Errorf("bad values: %s", err)
+	}
+	return values, nil
+}
+
+// runValuesEvalCommand evaluates expressions from arguments or starts an interactive session
+// with values of running antiopa, e.g. `antiopa values eval -module nginx 'get nginx.replicas'`
+func runValuesEvalCommand(args []string) error {
+	flags := flag.NewFlagSet("values eval", flag.ContinueOnError)
+	moduleName := flags.String("module", "", "evaluate values of module hooks, values of global hooks are used if empty")
+	if err := flags.Parse(args); err != nil {
+		return err
+	}
+
+	session := NewValuesEvalSession(*moduleName, fetchCurrentValues)
+	if err := session.Reload(); err != nil {
+		return err
+	}
+
+	if flags.NArg() > 0 {
+		for _, expression := range flags.Args() {
+			out, err := session.Eval(expression)
+			if err != nil {
+				return err
+			}
+			fmt.Print(out)
+		}
+		return nil
+	}
+
+	return session.Run(os.Stdin, os.Stdout)
+}
@@ -0,0 +1,84 @@
+package main
+
+import (
+	"bytes"
+	"fmt"
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+
+	"github.com/flant/antiopa/utils"
+)
+
+func TestValuesEvalSession(t *testing.T) {
+	fetched := make([]string, 0)
+	session := NewValuesEvalSession("nginx", func(moduleName string) (utils.Values, error) {
+		fetched = append(fetched, moduleName)
+		if moduleName == "unknown" {
+			return nil, fmt.Errorf("module 'unknown' not found")
+		}
+		return utils.NewValuesFromBytes([]byte(`
+global:
+  clusterDomain: cluster.local
+nginx:
+  replicas: 2
+  ports:
+  - 80
+  - 443
+`))
+	})
+	session.jq = func(filter string, data []byte) (string, error) {
+		return filter + " " + string(data), nil
+	}
+	if !assert.NoError(t, session.Reload()) {
+		return
+	}
+
+	out, err := session.Eval("get nginx.ports.1")
+	assert.NoError(t, err)
+	assert.Equal(t, "443\n", out)
+
+	_, err = session.Eval("get nginx.image")
+	assert.Error(t, err)
+
+	out, err = session.Eval(".nginx.replicas")
+	assert.NoError(t, err)
+	assert.True(t, strings.HasPrefix(out, ".nginx.replicas {"))
+
+	out, err = session.Eval(`merge {"nginx": {"replicas": 3, "image": "nginx:1.15"}}`)
+	assert.NoError(t, err)
+	assert.Equal(t, "+nginx.image\n~nginx.replicas\n", out)
+
+	out, err = session.Eval(`patch [{"op": "remove", "path": "/nginx/ports"}]`)
+	assert.NoError(t, err)
+	assert.Equal(t, "-nginx.ports\n", out)
+
+	out, err = session.Eval("diff")
+	assert.NoError(t, err)
+	assert.Equal(t, "+nginx.image\n-nginx.ports\n~nginx.replicas\n", out)
+
+	// the fetched values are not changed by merge
+	_, err = session.Eval("reset")
+	assert.NoError(t, err)
+	out, err = session.Eval("get nginx.replicas")
+	assert.NoError(t, err)
+	assert.Equal(t, "2\n", out)
+
+	_, err = session.Eval("module unknown")
+	assert.Error(t, err)
+	assert.Equal(t, "nginx", session.Module)
+
+	_, err = session.Eval("module global")
+	assert.NoError(t, err)
+	assert.Equal(t, "", session.Module)
+	assert.Equal(t, []string{"nginx", "unknown", ""}, fetched)
+
+	_, err = session.Eval("set nginx.replicas 1")
+	assert.Error(t, err)
+
+	var output bytes.Buffer
+	err = session.Run(strings.NewReader("get global.clusterDomain\nbad\nexit\nget global\n"), &output)
+	assert.NoError(t, err)
+	assert.Equal(t, "values of global hooks, type 'help' for commands\nglobal> cluster.local\nglobal> error: unknown command 'bad', type 'help' for commands\nglobal> ", output.String())
+}
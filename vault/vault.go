@@ -0,0 +1,242 @@
+package vault
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/romana/rlog"
+
+	"github.com/flant/antiopa/kube"
+	"github.com/flant/antiopa/utils"
+)
+
+// Prefix of references to Vault secrets in values: "vault:secret/data/app#password"
+const ReferencePrefix = "vault:"
+
+// Secrets without lease (kv) are cached for this period
+const DefaultSecretTTL = 5 * time.Minute
+
+// Client is initialized if Vault address is set. Values are not resolved if it is nil.
+var Client *VaultClient
+
+type cachedSecret struct {
+	data      map[string]interface{}
+	expiresAt time.Time
+}
+
+// VaultClient reads secrets with token from kubernetes auth method
+type VaultClient struct {
+	Address  string
+	Role     string
+	AuthPath string
+
+	http *http.Client
+
+	m             sync.Mutex
+	token         string
+	tokenTTL      time.Duration
+	secretsByPath map[string]*cachedSecret
+}
+
+// Init logs in with the antiopa service account token and starts token renewal
+func Init(address string, role string, authPath string) error {
+	rlog.Infof("VAULT: init client for '%s' with role '%s'", address, role)
+
+	client := &VaultClient{
+		Address:       strings.TrimRight(address, "/"),
+		Role:          role,
+		AuthPath:      authPath,
+		http:          &http.Client{Timeout: 30 * time.Second},
+		secretsByPath: make(map[string]*cachedSecret),
+	}
+
+	if err := client.login(); err != nil {
+		return err
+	}
+	go client.renewToken()
+
+	Client = client
+	return nil
+}
+
+type vaultResponse struct {
+	LeaseDuration int                    `json:"lease_duration"`
+	Data          map[string]interface{} `json:"data"`
+	Auth          *struct {
+		ClientToken   string `json:"client_token"`
+		LeaseDuration int    `json:"lease_duration"`
+		Renewable     bool   `json:"renewable"`
+	} `json:"auth"`
+	Errors []string `json:"errors"`
+}
+
+func (c *VaultClient) request(method string, path string, body interface{}, token string) (*vaultResponse, error) {
+	var reqBody []byte
+	if body != nil {
+		var err error
+		if reqBody, err = json.Marshal(body); err != nil {
+			return nil, err
+		}
+	}
+
+	req, err := http.NewRequest(method, fmt.Sprintf("%s/v1/%s", c.Address, path), bytes.NewReader(reqBody))
+	if err != nil {
+		return nil, err
+	}
+	if token != "" {
+		req.Header.Set("X-Vault-Token", token)
+	}
+
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	data, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	res := &vaultResponse{}
+	if len(data) > 0 {
+		if err := json.Unmarshal(data, res); err != nil {
+			return nil, fmt.Errorf("bad response from '%s': %s", path, err)
+		}
+	}
+	if resp.StatusCode >= 400 {
+		return nil, fmt.Errorf("'%s' returns %d: %s", path, resp.StatusCode, strings.Join(res.Errors, ", "))
+	}
+
+	return res, nil
+}
+
+func (c *VaultClient) login() error {
+	jwt, err := ioutil.ReadFile(kube.KubeTokenFilePath)
+	if err != nil {
+		return fmt.Errorf("cannot read service account token: %s", err)
+	}
+
+	res, err := c.request("POST", fmt.Sprintf("auth/%s/login", c.AuthPath), map[string]string{
+		"role": c.Role,
+		"jwt":  string(jwt),
+	}, "")
+	if err != nil {
+		return fmt.Errorf("vault login failed: %s", err)
+	}
+	if res.Auth == nil || res.Auth.ClientToken == "" {
+		return fmt.Errorf("vault login failed: no token in response")
+	}
+
+	c.m.Lock()
+	c.token = res.Auth.ClientToken
+	c.tokenTTL = time.Duration(res.Auth.LeaseDuration) * time.Second
+	c.m.Unlock()
+
+	rlog.Infof("VAULT: logged in, token ttl is %s", c.tokenTTL)
+	return nil
+}
+
+// renewToken renews token after 2/3 of its ttl and logs in again if renewal fails
+func (c *VaultClient) renewToken() {
+	for {
+		c.m.Lock()
+		ttl := c.tokenTTL
+		token := c.token
+		c.m.Unlock()
+
+		if ttl <= 0 {
+			// token without ttl does not expire
+			return
+		}
+		time.Sleep(ttl * 2 / 3)
+
+		res, err := c.request("POST", "auth/token/renew-self", nil, token)
+		if err == nil && res.Auth != nil {
+			c.m.Lock()
+			c.tokenTTL = time.Duration(res.Auth.LeaseDuration) * time.Second
+			c.m.Unlock()
+			rlog.Debugf("VAULT: token is renewed, ttl is %s", c.tokenTTL)
+			continue
+		}
+
+		rlog.Errorf("VAULT: cannot renew token, login again: %v", err)
+		for {
+			if err := c.login(); err != nil {
+				rlog.Errorf("VAULT: %s", err)
+				time.Sleep(10 * time.Second)
+				continue
+			}
+			break
+		}
+	}
+}
+
+// readSecret returns data of the secret from cache or from Vault. Data of kv v2 is unwrapped.
+func (c *VaultClient) readSecret(path string) (map[string]interface{}, error) {
+	c.m.Lock()
+	cached, hasCached := c.secretsByPath[path]
+	token := c.token
+	c.m.Unlock()
+
+	if hasCached && time.Now().Before(cached.expiresAt) {
+		return cached.data, nil
+	}
+
+	res, err := c.request("GET", path, nil, token)
+	if err != nil {
+		return nil, fmt.Errorf("cannot read vault secret: %s", err)
+	}
+
+	data := res.Data
+	// kv v2 returns secret in data.data with metadata
+	if nested, ok := data["data"].(map[string]interface{}); ok {
+		if _, hasMetadata := data["metadata"]; hasMetadata {
+			data = nested
+		}
+	}
+
+	ttl := time.Duration(res.LeaseDuration) * time.Second
+	if ttl <= 0 {
+		ttl = DefaultSecretTTL
+	}
+
+	c.m.Lock()
+	c.secretsByPath[path] = &cachedSecret{data: data, expiresAt: time.Now().Add(ttl)}
+	c.m.Unlock()
+
+	return data, nil
+}
+
+// Resolve returns a value for reference "path#key"
+func (c *VaultClient) Resolve(ref string) (interface{}, error) {
+	parts := strings.SplitN(ref, "#", 2)
+	if len(parts) != 2 || parts[0] == "" || parts[1] == "" {
+		return nil, fmt.Errorf("bad vault reference '%s%s', expected '%spath#key'", ReferencePrefix, ref, ReferencePrefix)
+	}
+
+	data, err := c.readSecret(parts[0])
+	if err != nil {
+		return nil, fmt.Errorf("'%s%s': %s", ReferencePrefix, ref, err)
+	}
+	value, hasKey := data[parts[1]]
+	if !hasKey {
+		return nil, fmt.Errorf("'%s%s': no key '%s' in secret", ReferencePrefix, ref, parts[1])
+	}
+	return value, nil
+}
+
+// ResolveValues returns a copy of values with Vault references replaced with secrets.
+// Values are returned as is if Vault is not configured.
+func ResolveValues(values utils.Values) (utils.Values, error) {
+	if Client == nil {
+		return values, nil
+	}
+	return utils.ResolveValuesReferences(values, ReferencePrefix, Client.Resolve)
+}
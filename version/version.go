@@ -0,0 +1,47 @@
+package version
+
+import (
+	"fmt"
+	"runtime"
+)
+
+// Build information. Values are set at link time:
+// go build -ldflags "-X github.com/flant/antiopa/version.Version=v1.0.0 ..."
+var (
+	Version   = "dev"
+	Commit    = "unknown"
+	BuildDate = "unknown"
+)
+
+// Env variable with antiopa version for hooks
+const VersionEnv = "ANTIOPA_VERSION"
+
+type Info struct {
+	Version   string `json:"version"`
+	Commit    string `json:"commit"`
+	BuildDate string `json:"buildDate"`
+	GoVersion string `json:"goVersion"`
+}
+
+func Get() Info {
+	return Info{
+		Version:   Version,
+		Commit:    Commit,
+		BuildDate: BuildDate,
+		GoVersion: runtime.Version(),
+	}
+}
+
+func (i Info) String() string {
+	return fmt.Sprintf("antiopa %s (commit %s, built %s, %s)", i.Version, i.Commit, i.BuildDate, i.GoVersion)
+}
+
+// Labels returns labels for antiopa_build_info metric
+func (i Info) Labels() map[string]string {
+	return map[string]string{
+		"version":    i.Version,
+		"commit":     i.Commit,
+		"build_date": i.BuildDate,
+		"go_version": i.GoVersion,
+	}
+}